@@ -0,0 +1,237 @@
+// Command luadoc generates the Lua API reference of the executor package from
+// its Go source. It finds every L.SetField(table, "name", L.NewFunction(e.method))
+// and L.SetGlobal("name", ...) registration and takes descriptions, "Usage:" and
+// "Returns:" lines from the doc comment of the registered method.
+//
+// Run via go generate in internal/executor.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// entry is one documented Lua function
+type entry struct {
+	Table   string
+	Name    string
+	Doc     string
+	Usage   []string
+	Returns string
+}
+
+func main() {
+	dir := flag.String("dir", ".", "Package directory to scan")
+	out := flag.String("out", "apidoc_gen.go", "Output file")
+	pkg := flag.String("package", "executor", "Package name of the output file")
+	flag.Parse()
+
+	entries, err := scan(*dir, filepath.Base(*out))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := render(*pkg, entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *out), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("luadoc: %d function(s) written to %s\n", len(entries), *out)
+}
+
+func scan(dir, skip string) ([]entry, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return info.Name() != skip && !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ast.File
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			files = append(files, f)
+		}
+	}
+
+	// Doc comments of every function and method by name
+	docs := make(map[string]string)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Doc != nil {
+				docs[fn.Name.Name] = fn.Doc.Text()
+			}
+		}
+	}
+
+	var entries []entry
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			entries = append(entries, scanFunc(fn, docs)...)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Table != entries[j].Table {
+			return entries[i].Table < entries[j].Table
+		}
+		return false // keep registration order within a table
+	})
+	return entries, nil
+}
+
+// scanFunc finds Lua registrations in one Go function
+func scanFunc(fn *ast.FuncDecl, docs map[string]string) []entry {
+	type field struct {
+		name   string
+		method string
+	}
+	tables := make(map[string][]field) // Go variable -> registered fields
+	funcs := make(map[string]bool)     // Go variables holding L.NewFunction(func literal)
+	var result []entry
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.AssignStmt:
+			for i, rhs := range node.Rhs {
+				call, ok := rhs.(*ast.CallExpr)
+				if !ok || i >= len(node.Lhs) || callName(call) != "NewFunction" || len(call.Args) != 1 {
+					continue
+				}
+				if _, isLit := call.Args[0].(*ast.FuncLit); isLit {
+					if ident, ok := node.Lhs[i].(*ast.Ident); ok {
+						funcs[ident.Name] = true
+					}
+				}
+			}
+
+		case *ast.CallExpr:
+			switch callName(node) {
+			case "SetField":
+				if len(node.Args) != 3 {
+					return true
+				}
+				table, ok1 := node.Args[0].(*ast.Ident)
+				name, ok2 := stringLit(node.Args[1])
+				method := registeredMethod(node.Args[2])
+				if ok1 && ok2 && method != "" {
+					tables[table.Name] = append(tables[table.Name], field{name, method})
+				}
+
+			case "SetGlobal":
+				if len(node.Args) != 2 {
+					return true
+				}
+				name, ok := stringLit(node.Args[0])
+				ident, isIdent := node.Args[1].(*ast.Ident)
+				if !ok || !isIdent {
+					return true
+				}
+				if fields, isTable := tables[ident.Name]; isTable {
+					for _, f := range fields {
+						result = append(result, parseDoc(name, f.name, f.method, docs[f.method]))
+					}
+				} else if funcs[ident.Name] && fn.Doc != nil {
+					result = append(result, parseDoc("", name, fn.Name.Name, fn.Doc.Text()))
+				}
+			}
+		}
+		return true
+	})
+
+	return result
+}
+
+// callName returns the selector name of x.Name(...) calls
+func callName(call *ast.CallExpr) string {
+	if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name
+	}
+	return ""
+}
+
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// registeredMethod extracts "method" from L.NewFunction(e.method) or
+// L.NewFunction(e.method(args)) (factories like makeEventEmit)
+func registeredMethod(expr ast.Expr) string {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || callName(call) != "NewFunction" || len(call.Args) != 1 {
+		return ""
+	}
+	arg := call.Args[0]
+	if inner, ok := arg.(*ast.CallExpr); ok {
+		arg = inner.Fun
+	}
+	if sel, ok := arg.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name
+	}
+	return ""
+}
+
+// parseDoc splits a doc comment into description, usage and returns
+func parseDoc(table, name, method, doc string) entry {
+	e := entry{Table: table, Name: name}
+
+	var desc []string
+	for i, line := range strings.Split(strings.TrimSpace(doc), "\n") {
+		line = strings.TrimSpace(line)
+		if i == 0 {
+			line = strings.TrimSpace(strings.TrimPrefix(line, method))
+			// Global registrations read "registerX registers X: does ..."
+			line = strings.TrimPrefix(line, "registers "+name+": ")
+		}
+		switch {
+		case strings.HasPrefix(line, "Usage:"):
+			for _, usage := range strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "Usage:")), " or ") {
+				e.Usage = append(e.Usage, strings.TrimSpace(usage))
+			}
+		case strings.HasPrefix(line, "Returns:"):
+			e.Returns = strings.TrimSpace(strings.TrimPrefix(line, "Returns:"))
+		case line != "":
+			desc = append(desc, line)
+		}
+	}
+	e.Doc = strings.Join(desc, "\n")
+	if e.Doc != "" {
+		e.Doc = strings.ToUpper(e.Doc[:1]) + e.Doc[1:]
+	}
+	return e
+}
+
+func render(pkg string, entries []entry) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by luadoc; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	buf.WriteString("var luaAPIReference = []APIFunction{\n")
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "\t{Table: %q, Name: %q, Doc: %q, Usage: %#v, Returns: %q},\n",
+			e.Table, e.Name, e.Doc, e.Usage, e.Returns)
+	}
+	buf.WriteString("}\n")
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"homescript-server/internal/config"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/discovery"
+	"homescript-server/internal/events"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/scaffold"
+	"homescript-server/internal/types"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceDiscoveredEventType is the system event routed to
+// events/system/device_discovered/ for every device background discovery adds
+const DeviceDiscoveredEventType = "device_discovered"
+
+// backgroundDiscovery adds devices that appear while the server runs: to
+// the device manager, devices.yaml and the handler scaffolds
+type backgroundDiscovery struct {
+	disc      *discovery.MQTTDiscovery
+	devices   *devices.Manager
+	client    *mqtt.Client
+	router    *events.Router
+	haConfigs string
+	followsHA bool // runtime Home Assistant discovery adds ha/ devices itself
+	mu        sync.Mutex
+}
+
+// startBackgroundDiscovery keeps MQTT discovery running until the returned
+// function is called. Discovery gets its own connection: it subscribes to
+// filters the server's client uses with other handlers.
+func startBackgroundDiscovery(cfg mqtt.Config, discCfg config.DiscoveryConfig, client *mqtt.Client, deviceManager *devices.Manager, router *events.Router, followsHA bool) (func(), error) {
+	cfg.ClientID += "-discovery"
+	cfg.CleanSession = true
+	cfg.StatusTopic = ""
+	cfg.SharedGroup = ""
+	cfg.ConsolidateMin = 0
+	discClient, err := mqtt.NewClient(cfg, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backgroundDiscovery{
+		disc:      discovery.New(discClient.GetInternalClient()),
+		devices:   deviceManager,
+		client:    client,
+		router:    router,
+		haConfigs: configPath + "/devices/ha_configs.json",
+		followsHA: followsHA,
+	}
+	b.disc.SetTopics(cfg.Topics)
+	b.disc.SetFilter(discCfg.Include, discCfg.Exclude)
+	b.disc.SetOnChange(b.onChange)
+	if err := b.disc.Start(); err != nil {
+		discClient.Disconnect()
+		return nil, err
+	}
+	logger.Info("Background discovery started")
+	return discClient.Disconnect, nil
+}
+
+// onChange merges the devices discovery doesn't know yet. Devices removed
+// from an integration stay in devices.yaml, and devices the user deleted
+// from it aren't added back (see config.AddDiscovered).
+func (b *backgroundDiscovery) onChange(found []*types.Device) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var fresh []*types.Device
+	for _, dev := range found {
+		if b.followsHA && strings.HasPrefix(dev.ID, "ha/") {
+			continue
+		}
+		if _, known := b.devices.GetDevice(dev.ID); known {
+			continue
+		}
+		fresh = append(fresh, dev)
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	devicesYAML := configPath + "/devices/devices.yaml"
+	merge, err := config.AddDiscovered(fresh, devicesYAML)
+	if err == nil && len(merge.Added) > 0 {
+		err = merge.Write(devicesYAML)
+	}
+	if err != nil {
+		logger.Warn("Failed to add discovered devices to devices.yaml: %v", err)
+		return
+	}
+	var added []*types.Device
+	for _, dev := range fresh {
+		if slices.Contains(merge.Added, dev.ID) {
+			added = append(added, dev)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	haConfigs := b.disc.GetHAConfigs()
+	newHA := make(map[string]*types.HomeAssistantDiscovery)
+	for _, dev := range added {
+		if cfg := haConfigs[dev.ID]; cfg != nil {
+			b.devices.GetHAManager().RegisterDevice(dev.ID, cfg)
+			newHA[dev.ID] = cfg
+		}
+		b.devices.AddDevice(dev)
+		b.client.SubscribeDevice(dev)
+	}
+	if len(newHA) > 0 {
+		if err := config.MergeHAConfigs(newHA, b.haConfigs); err != nil {
+			logger.Warn("Failed to save HA configs of discovered devices: %v", err)
+		}
+	}
+	if err := scaffold.GenerateDeviceScaffolds(added, configPath); err != nil {
+		logger.Warn("Failed to scaffold discovered devices: %v", err)
+	}
+
+	for _, dev := range added {
+		logger.Info("Discovered new device %s (%s, %s)", dev.ID, dev.Type, dev.Name)
+		b.router.RouteEvent(&types.Event{
+			Source: "system",
+			Type:   DeviceDiscoveredEventType,
+			Area:   dev.Area,
+			Data: map[string]interface{}{
+				"device": dev.ID,
+				"name":   dev.Name,
+				"type":   dev.Type,
+				"vendor": dev.Vendor,
+				"model":  dev.Model,
+				"area":   dev.Area,
+			},
+			Timestamp:     time.Now(),
+			CorrelationID: types.NewCorrelationID(),
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/api"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/metrics"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/types"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serverURL   = "http://localhost:8080"
+	serverToken = ""
+)
+
+func devicesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "devices",
+		Short: "List, inspect and control devices on a running server",
+	}
+
+	cmd.PersistentFlags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API")
+	cmd.PersistentFlags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API")
+
+	cmd.AddCommand(devicesListCmd())
+	cmd.AddCommand(devicesShowCmd())
+	cmd.AddCommand(devicesSetCmd())
+	cmd.AddCommand(devicesStatsCmd())
+	return cmd
+}
+
+func devicesListCmd() *cobra.Command {
+	var area, deviceType string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List devices with their current state",
+		Long: `List the devices of the running server with their cached state. Requires
+'api.enabled' in server.yaml and the HTTP server, with a token of the read
+scope.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDevicesList(area, deviceType); err != nil {
+				logger.Critical("Devices error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&area, "area", "", "Only devices in this area")
+	cmd.Flags().StringVar(&deviceType, "type", "", "Only devices of this type")
+	return cmd
+}
+
+func devicesShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a device's attributes, topics and state",
+		Long: `Show one device of the running server (by ID or alias) with its cached
+state, and the MQTT topics devices.yaml maps it to, to check the mapping.
+Requires 'api.enabled' in server.yaml and a token of the read scope.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDevicesShow(args[0]); err != nil {
+				logger.Critical("Devices error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func devicesSetCmd() *cobra.Command {
+	var direct, dryRun bool
+	cmd := &cobra.Command{
+		Use:   "set <id> <attribute>=<value>...",
+		Short: "Set device attributes",
+		Long: `Set attributes of a device like device.set in a script, e.g.
+
+  homescript-server devices set porch_light state=ON brightness=200
+
+Values are read as JSON if they parse (200, true, "ON") and as strings
+otherwise. The running server sends the command, with the device's schema,
+protection and confirmation applied; this needs 'api.enabled' and a token of
+the control scope.
+
+--mqtt publishes straight to the broker instead, using devices.yaml, for when
+the server isn't running. --dry-run only prints the topics and payloads
+devices.yaml maps the command to.`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			attrs, err := parseAttributes(args[1:])
+			if err == nil {
+				err = runDevicesSet(args[0], attrs, direct, dryRun)
+			}
+			if err != nil {
+				logger.Critical("Devices error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&direct, "mqtt", false, "Publish directly to the MQTT broker instead of through the server")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the MQTT messages without sending them")
+	return cmd
+}
+
+func devicesStatsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stats [device]",
+		Short: "Show per-device message rates, command success and latency",
+		Long: `Show metrics collected by the running server to spot flaky actuators.
+Requires 'metrics.enabled' in server.yaml and the HTTP server.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDevicesStats(args); err != nil {
+				logger.Critical("Stats error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// fetchJSON GETs path from the running server and decodes the JSON response
+func fetchJSON(path string, result interface{}) error {
+	return requestJSON(http.MethodGet, path, nil, result)
+}
+
+// postJSON POSTs body as JSON to path on the running server and decodes the
+// JSON response
+func postJSON(path string, body, result interface{}) error {
+	if body == nil {
+		return requestJSON(http.MethodPost, path, nil, result)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return requestJSON(http.MethodPost, path, bytes.NewReader(data), result)
+}
+
+func requestJSON(method, path string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimSuffix(serverURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if serverToken != "" {
+		req.Header.Set("Authorization", "Bearer "+serverToken)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("server not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (HTTP %d)", apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("unexpected response: HTTP %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func runDevicesStats(args []string) error {
+	var stats []metrics.DeviceStats
+	if len(args) == 1 {
+		var single metrics.DeviceStats
+		if err := fetchJSON("/metrics/devices/"+args[0], &single); err != nil {
+			return err
+		}
+		stats = append(stats, single)
+	} else if err := fetchJSON("/metrics/devices", &stats); err != nil {
+		return err
+	}
+
+	if len(stats) == 0 {
+		fmt.Println("No device metrics recorded yet")
+		return nil
+	}
+
+	fmt.Printf("%-28s %8s %8s %-10s %6s %6s %8s %10s %10s %8s\n",
+		"DEVICE", "MSGS", "MSG/MIN", "LAST SEEN", "CMDS", "FAIL", "SUCCESS", "AVG LAT", "AVG ECHO", "NO ECHO")
+	for _, s := range stats {
+		fmt.Printf("%-28s %8d %8.2f %-10s %6d %6d %7.1f%% %8.1fms %8.0fms %8d\n",
+			s.Device, s.Messages, s.MessagesPerMin, lastSeenAgo(s.LastSeen),
+			s.Commands, s.CommandsFailed, s.SuccessRatio*100, s.AvgLatencyMs, s.AvgEchoMs, s.EchoTimeouts)
+		if s.LastCommandErr != "" {
+			fmt.Printf("    last error: %s\n", s.LastCommandErr)
+		}
+	}
+	return nil
+}
+
+// lastSeenAgo formats a timestamp as a short age ("42s", "3m", "never")
+func lastSeenAgo(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+func runDevicesList(area, deviceType string) error {
+	var list []api.RESTDevice
+	if err := fetchJSON("/api/devices", &list); err != nil {
+		return err
+	}
+
+	fmt.Printf("%-28s %-24s %-12s %-12s %s\n", "ID", "NAME", "TYPE", "AREA", "STATE")
+	shown := 0
+	for _, dev := range list {
+		if (area != "" && dev.Area != area) || (deviceType != "" && dev.Type != deviceType) {
+			continue
+		}
+		shown++
+		fmt.Printf("%-28s %-24s %-12s %-12s %s\n", dev.ID, dev.Name, dev.Type, dev.Area, stateSummary(dev.State, 60))
+	}
+	fmt.Printf("\n%d device(s)\n", shown)
+	return nil
+}
+
+func runDevicesShow(id string) error {
+	var dev api.RESTDevice
+	if err := fetchJSON("/api/devices/"+url.PathEscape(id), &dev); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s)\n", dev.ID, dev.Name)
+	fmt.Printf("  %-15s %s\n", "type:", dev.Type)
+	if dev.Area != "" {
+		fmt.Printf("  %-15s %s\n", "area:", dev.Area)
+	}
+	if len(dev.Attributes) > 0 {
+		fmt.Printf("  %-15s %s\n", "attributes:", strings.Join(dev.Attributes, ", "))
+	}
+	if len(dev.Actions) > 0 {
+		fmt.Printf("  %-15s %s\n", "actions:", strings.Join(dev.Actions, ", "))
+	}
+
+	// Topics come from the local devices.yaml, which may differ from the
+	// server's if it wasn't reloaded
+	if configured, err := configuredDevice(dev.ID); err == nil {
+		printTopics(configured)
+	} else {
+		logger.Debug("No topics from devices.yaml: %v", err)
+	}
+
+	fmt.Println("state:")
+	if len(dev.State) == 0 {
+		fmt.Println("  (none reported yet)")
+	}
+	for _, attr := range sortedStateKeys(dev.State) {
+		fmt.Printf("  %-20s %s\n", attr, formatValue(dev.State[attr]))
+	}
+	return nil
+}
+
+func runDevicesSet(id string, attrs map[string]interface{}, direct, dryRun bool) error {
+	if dryRun {
+		dev, err := configuredDevice(id)
+		if err != nil {
+			return err
+		}
+		commands, err := devices.Commands(dev, attrs)
+		if err != nil {
+			return err
+		}
+		for _, command := range commands {
+			fmt.Printf("%s %s\n", command.Topic, command.Payload)
+		}
+		return nil
+	}
+
+	if direct {
+		return setDirect(id, attrs)
+	}
+	var result map[string]interface{}
+	if err := postJSON("/api/devices/"+url.PathEscape(id)+"/set", attrs, &result); err != nil {
+		return err
+	}
+	fmt.Printf("%s: set %s\n", id, stateSummary(attrs, 0))
+	return nil
+}
+
+// setDirect publishes a command to the broker with a device manager built
+// from devices.yaml, without the running server
+func setDirect(id string, attrs map[string]interface{}) error {
+	deviceConfig, err := loadDeviceConfig()
+	if err != nil {
+		return err
+	}
+	cfg, err := mqttConfig("homescript-cli")
+	if err != nil {
+		return err
+	}
+	mqttClient, err := mqtt.NewClient(cfg, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer mqttClient.Disconnect()
+
+	deviceManager := devices.New(mqttClient.GetInternalClient(), deviceConfig.Devices)
+	deviceManager.SetTopics(cfg.Topics)
+	if err := deviceManager.Set(id, attrs); err != nil {
+		return err
+	}
+	fmt.Printf("%s: published %s\n", id, stateSummary(attrs, 0))
+	return nil
+}
+
+// configuredDevice finds a device in devices.yaml by ID or alias
+func configuredDevice(id string) (*types.Device, error) {
+	deviceConfig, err := loadDeviceConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, dev := range deviceConfig.Devices {
+		if dev.ID == id {
+			return dev, nil
+		}
+		for _, alias := range dev.Aliases {
+			if alias == id {
+				return dev, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("device %s is not in devices.yaml", id)
+}
+
+func printTopics(dev *types.Device) {
+	if dev.MQTT.StateTopic != "" {
+		fmt.Printf("  %-15s %s\n", "state topic:", dev.MQTT.StateTopic)
+	}
+	if dev.MQTT.CommandTopic != "" {
+		fmt.Printf("  %-15s %s\n", "command topic:", dev.MQTT.CommandTopic)
+	}
+	if dev.MQTT.AvailabilityTopic != "" {
+		fmt.Printf("  %-15s %s\n", "availability:", dev.MQTT.AvailabilityTopic)
+	}
+	for _, state := range dev.StateTopics {
+		fmt.Printf("  %-15s %s\n", "state topic:", state.Topic)
+	}
+	attrs := make([]string, 0, len(dev.Commands))
+	for attr := range dev.Commands {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	for _, attr := range attrs {
+		fmt.Printf("  %-15s %s (%s)\n", "command topic:", dev.Commands[attr].Topic, attr)
+	}
+}
+
+// parseAttributes reads attribute=value arguments; values are JSON if they
+// parse, strings otherwise
+func parseAttributes(args []string) (map[string]interface{}, error) {
+	attrs := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		attr, raw, ok := strings.Cut(arg, "=")
+		if !ok || attr == "" {
+			return nil, fmt.Errorf("invalid %q, want <attribute>=<value>", arg)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+		attrs[attr] = value
+	}
+	return attrs, nil
+}
+
+// stateSummary renders attributes as "a=1 b=ON", cut to width (0 for no limit)
+func stateSummary(state map[string]interface{}, width int) string {
+	parts := make([]string, 0, len(state))
+	for _, attr := range sortedStateKeys(state) {
+		parts = append(parts, attr+"="+formatValue(state[attr]))
+	}
+	summary := strings.Join(parts, " ")
+	if width > 0 && len(summary) > width {
+		summary = summary[:width-3] + "..."
+	}
+	return summary
+}
+
+func sortedStateKeys(state map[string]interface{}) []string {
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue prints strings bare and everything else as JSON
+func formatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/logger"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func docsCmd() *cobra.Command {
+	var outFile string
+
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Print the Lua API reference as Markdown",
+		Long: `Print the Lua API reference generated from the server source.
+The running server also serves it at /docs/lua when the HTTP server is enabled.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			reference := executor.APIReferenceMarkdown()
+			if outFile == "" {
+				fmt.Print(reference)
+				return
+			}
+			if err := os.WriteFile(outFile, []byte(reference), 0644); err != nil {
+				logger.Critical("Failed to write %s: %v", outFile, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Wrote Lua API reference to %s\n", outFile)
+		},
+	}
+
+	cmd.Flags().StringVar(&outFile, "out", "", "Write to this file instead of stdout")
+	return cmd
+}
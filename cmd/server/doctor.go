@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/logger"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func doctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Check handler scripts for APIs that changed under their API version",
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDoctor(); err != nil {
+				logger.Critical("Doctor error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func runDoctor() error {
+	eventsPath := filepath.Join(configPath, "events")
+
+	var found []executor.Deprecation
+	scripts := 0
+	err := filepath.WalkDir(eventsPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".lua") {
+			return nil
+		}
+		scripts++
+		deprecations, err := executor.CheckDeprecations(path)
+		if err != nil {
+			return err
+		}
+		found = append(found, deprecations...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		fmt.Printf("Checked %d script(s): no changed APIs in use\n", scripts)
+		return nil
+	}
+
+	for _, d := range found {
+		fmt.Printf("%s:%d [%s]\n    %s\n    migration: %s\n", d.Script, d.Line, d.Rule, d.Message, d.Hint)
+	}
+	fmt.Printf("\nChecked %d script(s): %d deprecation(s)\n", scripts, len(found))
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/events"
+	"homescript-server/internal/journal"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/scaffold"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func eventsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "events",
+		Short: "Inspect how events are routed",
+	}
+	cmd.AddCommand(eventsUnroutedCmd())
+	cmd.AddCommand(eventsScaffoldCmd())
+	return cmd
+}
+
+func eventsUnroutedCmd() *cobra.Command {
+	var (
+		fromJournal bool
+		since       time.Duration
+		hintsOnly   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "unrouted",
+		Short: "List events that matched no handler script",
+		Long: `List the events that matched no handler script, most frequent first, with
+the directory their handler would go in. A hint points at likely typos: a
+similarly named directory (wrong device ID, "on_Change") or files that are
+not .lua ("on_change.Lua").
+
+By default the counts come from the running server since it started
+(requires 'metrics.enabled' in server.yaml and the HTTP server). With
+--from-journal the journaled events are checked against the handlers on
+disk instead; stop the server first, the journal is locked while it runs.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			var list []events.UnroutedEvent
+			var err error
+			if fromJournal {
+				list, err = journalUnrouted(since)
+			} else {
+				err = fetchJSON("/metrics/unrouted", &list)
+			}
+			if err != nil {
+				logger.Critical("Unrouted events error: %v", err)
+				os.Exit(1)
+			}
+			printUnrouted(list, hintsOnly)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API")
+	cmd.Flags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API")
+	cmd.Flags().BoolVar(&fromJournal, "from-journal", false, "Check journaled events against the handlers on disk")
+	cmd.Flags().StringVar(&journalPath, "journal", journalPath, "Event journal file (with --from-journal) (default "+defaultJournalPath+")")
+	cmd.Flags().DurationVar(&since, "since", 24*time.Hour, "Journaled events within this duration (with --from-journal, 0 for all)")
+	cmd.Flags().BoolVar(&hintsOnly, "hints", false, "Only list events with a hint (likely typos)")
+	return cmd
+}
+
+func eventsScaffoldCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "scaffold <directory>...",
+		Short: "Create a stub handler for an event directory",
+		Long: `Create a stub handler script for each directory, as listed by
+'events unrouted' (e.g. device/porch_light/state): on_change.lua for a device
+attribute, handler.lua otherwise. Existing scripts are left alone. The
+running server picks new scripts up on the next matching event.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			failed := false
+			for _, dir := range args {
+				path, err := scaffold.WriteHandler(configPath, dir)
+				if err != nil {
+					logger.Error("Scaffold %s: %v", dir, err)
+					failed = true
+					continue
+				}
+				fmt.Println(path)
+			}
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// journalUnrouted routes the journaled events against the handler tree
+func journalUnrouted(since time.Duration) ([]events.UnroutedEvent, error) {
+	eventJournal, err := journal.Open(journalFile(), 0)
+	if err != nil {
+		return nil, err
+	}
+	defer eventJournal.Close()
+
+	var filter journal.Filter
+	if since > 0 {
+		filter.Since = time.Now().Add(-since)
+	}
+	recorded, err := eventJournal.Events(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Routing only, nothing runs
+	router := events.New(configPath, nil)
+	defer router.Close()
+	for _, event := range recorded {
+		router.CheckUnrouted(event)
+	}
+	return router.Unrouted(true), nil
+}
+
+func printUnrouted(list []events.UnroutedEvent, hintsOnly bool) {
+	shown := 0
+	for _, e := range list {
+		if hintsOnly && e.Hint == "" {
+			continue
+		}
+		if shown == 0 {
+			fmt.Printf("%8s  %-10s  %s\n", "COUNT", "LAST SEEN", "HANDLER DIRECTORY")
+		}
+		shown++
+		fmt.Printf("%8d  %-10s  events/%s\n", e.Count, lastSeenAgo(e.LastSeen), e.Directory)
+		if e.Hint != "" {
+			fmt.Printf("%8s  %-10s  hint: %s\n", "", "", e.Hint)
+		}
+	}
+	if shown == 0 {
+		fmt.Println("No unrouted events")
+		return
+	}
+	fmt.Printf("\nCreate a handler with: homescript-server events scaffold <directory>\n")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/journal"
+	"homescript-server/internal/logger"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func journalCmd() *cobra.Command {
+	var (
+		since  time.Duration
+		until  string
+		filter journal.Filter
+	)
+
+	cmd := &cobra.Command{
+		Use:   "journal",
+		Short: "Show journaled events (e.g. what happened last night)",
+		Long: `List events from the event journal written by 'run --record-events'. Use --correlation
+to trace everything caused by a single MQTT message or time tick. Stop the
+server first: the journal is locked while it runs.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+			if until != "" {
+				t, err := time.ParseInLocation("2006-01-02 15:04", until, time.Local)
+				if err != nil {
+					logger.Critical("Invalid --until %q (expected \"2006-01-02 15:04\")", until)
+					os.Exit(1)
+				}
+				filter.Until = t
+			}
+			if err := runJournal(filter); err != nil {
+				logger.Critical("Journal error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&since, "since", 12*time.Hour, "Show events journaled within this duration (0 for all)")
+	cmd.Flags().StringVar(&until, "until", "", "Show events up to this local time (\"2006-01-02 15:04\")")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "Show only the N most recent matching events (0 for all)")
+	addJournalFilterFlags(cmd, &filter)
+	return cmd
+}
+
+// defaultJournalPath is the event journal file unless --journal says otherwise
+const defaultJournalPath = "./data/journal.db"
+
+// journalFile returns the event journal file
+func journalFile() string {
+	if journalPath == "" {
+		return defaultJournalPath
+	}
+	return journalPath
+}
+
+// addJournalFilterFlags registers the event filter flags shared by 'journal' and 'replay'
+func addJournalFilterFlags(cmd *cobra.Command, filter *journal.Filter) {
+	cmd.Flags().StringVar(&journalPath, "journal", journalPath, "Event journal file (default "+defaultJournalPath+")")
+	cmd.Flags().StringVar(&filter.Source, "source", "", "Only events from this source (device, mqtt, time, custom)")
+	cmd.Flags().StringVar(&filter.Type, "type", "", "Only events of this type")
+	cmd.Flags().StringVar(&filter.Device, "device", "", "Only events for this device ID")
+	cmd.Flags().StringVar(&filter.Attribute, "attribute", "", "Only events for this attribute")
+	cmd.Flags().StringVar(&filter.CorrelationID, "correlation", "", "Only events with this correlation ID")
+}
+
+func runJournal(filter journal.Filter) error {
+	eventJournal, err := journal.Open(journalFile(), 0)
+	if err != nil {
+		return err
+	}
+	defer eventJournal.Close()
+
+	entries, err := eventJournal.Events(filter)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No journaled events match the filter")
+		return nil
+	}
+
+	for _, event := range entries {
+		fmt.Printf("%s [%s] %s/%s", event.Timestamp.Format("2006-01-02 15:04:05.000"), event.CorrelationID, event.Source, event.Type)
+		if event.Device != "" {
+			fmt.Printf(" device=%s", event.Device)
+		}
+		if event.Attribute != "" {
+			fmt.Printf(" attribute=%s", event.Attribute)
+		}
+		if data, err := json.Marshal(event.Data); err == nil && len(event.Data) > 0 {
+			fmt.Printf(" %s", data)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
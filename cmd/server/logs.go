@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/events"
+	"homescript-server/internal/logger"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func logsCmd() *cobra.Command {
+	var (
+		follow     bool
+		showEvents bool
+		interval   time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show the running server's recent log lines or events",
+		Long: `Print the log lines the running server kept (api.log_lines, default 500),
+or with --events the last 200 routed events with the handler scripts they
+ran. With --follow new ones are printed as they come, like tail -f. Lines are
+kept from the server's log level on; start it with --log-level info to see
+more than errors. Requires 'api.enabled' in server.yaml and the HTTP server,
+with a token of the read scope.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			tail := tailLogs
+			if showEvents {
+				tail = tailEvents
+			}
+			var after uint64
+			for {
+				last, err := tail(after)
+				if err != nil {
+					logger.Critical("Logs error: %v", err)
+					os.Exit(1)
+				}
+				if !follow {
+					return
+				}
+				if last < after {
+					fmt.Println("-- server restarted --")
+					after = 0
+					continue
+				}
+				after = last
+				time.Sleep(interval)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API")
+	cmd.Flags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing new lines as they are logged")
+	cmd.Flags().BoolVar(&showEvents, "events", false, "Show routed events instead of log lines")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "How often to poll the server with --follow")
+	return cmd
+}
+
+// tailLogs prints the server's log lines after seq and returns the last seq
+func tailLogs(after uint64) (uint64, error) {
+	var result struct {
+		Lines []logger.Line `json:"lines"`
+		Last  uint64        `json:"last"`
+	}
+	if err := fetchJSON(fmt.Sprintf("/api/logs?after=%d", after), &result); err != nil {
+		return 0, err
+	}
+	for _, line := range result.Lines {
+		fmt.Printf("%s [%s] ", line.Time.Local().Format("2006/01/02 15:04:05"), line.Level)
+		if line.Module != "" {
+			fmt.Printf("[%s] ", line.Module)
+		}
+		fmt.Println(line.Message)
+	}
+	return result.Last, nil
+}
+
+// tailEvents prints the server's routed events after seq and returns the last seq
+func tailEvents(after uint64) (uint64, error) {
+	var result struct {
+		Events []events.RecentEvent `json:"events"`
+		Last   uint64               `json:"last"`
+	}
+	if err := fetchJSON(fmt.Sprintf("/api/events/recent?after=%d", after), &result); err != nil {
+		return 0, err
+	}
+	for _, event := range result.Events {
+		fmt.Printf("%s [%s] %s/%s", event.Timestamp.Local().Format("2006-01-02 15:04:05.000"), event.CorrelationID, event.Source, event.Type)
+		if event.Device != "" {
+			fmt.Printf(" device=%s", event.Device)
+		}
+		if event.Attribute != "" {
+			fmt.Printf(" attribute=%s", event.Attribute)
+		}
+		if data, err := json.Marshal(event.Data); err == nil && len(event.Data) > 0 {
+			fmt.Printf(" %s", data)
+		}
+		if len(event.Scripts) > 0 {
+			fmt.Printf(" → %s", strings.Join(event.Scripts, ", "))
+		}
+		fmt.Println()
+	}
+	return result.Last, nil
+}
@@ -1,20 +1,36 @@
 package main
 
 import (
+	"fmt"
+	"homescript-server/internal/api"
+	"homescript-server/internal/bridge"
 	"homescript-server/internal/config"
 	"homescript-server/internal/devices"
 	"homescript-server/internal/discovery"
+	"homescript-server/internal/effects"
 	"homescript-server/internal/events"
 	"homescript-server/internal/executor"
 	"homescript-server/internal/geolocation"
+	"homescript-server/internal/hass"
+	"homescript-server/internal/history"
+	"homescript-server/internal/journal"
 	"homescript-server/internal/logger"
+	"homescript-server/internal/metrics"
+	"homescript-server/internal/mirror"
 	"homescript-server/internal/mqtt"
+	"homescript-server/internal/reload"
 	"homescript-server/internal/scaffold"
+	"homescript-server/internal/scenes"
 	"homescript-server/internal/scheduler"
 	"homescript-server/internal/storage"
+	"homescript-server/internal/stream"
+	"homescript-server/internal/types"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,16 +38,48 @@ import (
 )
 
 var (
-	configPath = "./config"
-	dbPath     = "./data/state.db"
-	mqttBroker = "tcp://localhost:1883"
-	mqttUser   = ""
-	mqttPass   = ""
-	logLevel   = "error"
-	latitude   = 0.0
-	longitude  = 0.0
+	configPath   = "./config"
+	dbPath       = "./data/state.db"
+	mqttBroker   = "tcp://localhost:1883"
+	mqttUser     = ""
+	mqttPass     = ""
+	mqttCA       = ""
+	mqttCert     = ""
+	mqttKey      = ""
+	mqttTLSSkip  = false
+	mqttClientID = ""
+	topicPrefix  = ""
+	logLevel     = "error"
+	logFormat    = "default"
+	logSample    time.Duration
+	latitude     = 0.0
+	longitude    = 0.0
+
+	scriptBudget  = 1 * time.Second
+	historySize   = 50
+	bridgePrefix  = ""
+	virtualPrefix = "homescript/virtual"
+	httpAddr      = ""
+	statusTopic   = "homescript/status"
+	staleAfter    = time.Duration(0)
+	seedTimeout   = 3 * time.Second
+	coalesce      = false
+	echoCommands  = false
+	simulateTime  = ""
+	simulateSpeed = 60.0
+
+	recordEvents     = false
+	journalPath      = ""
+	journalRetention = 7 * 24 * time.Hour
+
+	fastLaneWorkers    = 2
+	jobWorkers         = executor.DefaultJobWorkers
+	priorityAttributes = events.DefaultPriorityAttributes
 )
 
+// defaultLogLines is how many log lines GET /api/logs keeps without api.log_lines
+const defaultLogLines = 500
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "homescript-server",
@@ -44,6 +92,13 @@ func main() {
 				log.Printf("Invalid log level '%s', using ERROR", logLevel)
 			}
 			logger.Init(level, true) // true = use colors
+
+			format, err := logger.ParseFormat(logFormat)
+			if err != nil {
+				log.Printf("Invalid log format '%s', using default", logFormat)
+			}
+			logger.SetFormat(format)
+			logger.SetSampling(logSample)
 		},
 	}
 
@@ -52,12 +107,38 @@ func main() {
 	rootCmd.PersistentFlags().StringVar(&mqttBroker, "mqtt-broker", mqttBroker, "MQTT broker URL (tcp://host:port)")
 	rootCmd.PersistentFlags().StringVar(&mqttUser, "mqtt-user", mqttUser, "MQTT username")
 	rootCmd.PersistentFlags().StringVar(&mqttPass, "mqtt-pass", mqttPass, "MQTT password")
+	rootCmd.PersistentFlags().StringVar(&mqttCA, "mqtt-ca", mqttCA, "CA certificate (PEM) for ssl:// brokers (system roots if empty)")
+	rootCmd.PersistentFlags().StringVar(&mqttCert, "mqtt-cert", mqttCert, "Client certificate (PEM) for ssl:// brokers")
+	rootCmd.PersistentFlags().StringVar(&mqttKey, "mqtt-key", mqttKey, "Client key (PEM) for ssl:// brokers")
+	rootCmd.PersistentFlags().BoolVar(&mqttTLSSkip, "mqtt-insecure", mqttTLSSkip, "Don't verify the broker certificate (testing only)")
+	rootCmd.PersistentFlags().StringVar(&mqttClientID, "mqtt-client-id", mqttClientID, "Stable MQTT client ID for 'run' with a persistent session (mqtt.client_id in server.yaml)")
+	rootCmd.PersistentFlags().StringVar(&topicPrefix, "topic-prefix", topicPrefix, "Prefix for every MQTT topic, e.g. site1 for site1/zigbee2mqtt/... (none if empty)")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", logLevel, "Log level (debug, info, warn, error, critical)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormat, "Log format (default, compact)")
+	rootCmd.PersistentFlags().DurationVar(&logSample, "log-sample-window", logSample, "Suppress identical debug messages within this window, e.g. 1m (0 disables)")
 	rootCmd.PersistentFlags().Float64Var(&latitude, "latitude", latitude, "Latitude for sunrise/sunset (auto-detected if not set)")
 	rootCmd.PersistentFlags().Float64Var(&longitude, "longitude", longitude, "Longitude for sunrise/sunset (auto-detected if not set)")
 
 	rootCmd.AddCommand(runCmd())
 	rootCmd.AddCommand(discoverCmd())
+	rootCmd.AddCommand(replayCmd())
+	rootCmd.AddCommand(journalCmd())
+	rootCmd.AddCommand(eventsCmd())
+	rootCmd.AddCommand(reloadCmd())
+	rootCmd.AddCommand(doctorCmd())
+	rootCmd.AddCommand(devicesCmd())
+	rootCmd.AddCommand(scheduleCmd())
+	rootCmd.AddCommand(triggerCmd())
+	rootCmd.AddCommand(schemaCmd())
+	rootCmd.AddCommand(docsCmd())
+	rootCmd.AddCommand(scenarioCmd())
+	rootCmd.AddCommand(restoreCmd())
+	rootCmd.AddCommand(stateCmd())
+	rootCmd.AddCommand(summaryCmd())
+	rootCmd.AddCommand(noderedCmd())
+	rootCmd.AddCommand(logsCmd())
+	rootCmd.AddCommand(validateCmd())
+	rootCmd.AddCommand(testCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Critical("Fatal error: %v", err)
@@ -66,7 +147,7 @@ func main() {
 }
 
 func runCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run the smart home server",
 		Run: func(cmd *cobra.Command, args []string) {
@@ -76,16 +157,39 @@ func runCmd() *cobra.Command {
 			}
 		},
 	}
+
+	cmd.Flags().IntVar(&fastLaneWorkers, "fast-lane-workers", fastLaneWorkers, "Extra workers reserved for high-priority events")
+	cmd.Flags().IntVar(&jobWorkers, "job-workers", jobWorkers, "Background jobs (job.submit) running at once")
+	cmd.Flags().StringSliceVar(&priorityAttributes, "priority-attributes", priorityAttributes, "Device attributes whose events use the high-priority fast lane")
+	cmd.Flags().StringVar(&statusTopic, "status-topic", statusTopic, "Retained online/offline server availability topic, offline also as Last Will (disabled if empty)")
+	cmd.Flags().StringVar(&httpAddr, "http-addr", httpAddr, "HTTP listen address, e.g. :8080 (overrides http.listen in server.yaml)")
+	cmd.Flags().BoolVar(&recordEvents, "record-events", recordEvents, "Journal every routed event for 'replay', 'journal' and the daily summary")
+	cmd.Flags().StringVar(&journalPath, "journal", journalPath, "Event journal file with --record-events (default "+defaultJournalPath+")")
+	cmd.Flags().DurationVar(&journalRetention, "journal-retention", journalRetention, "How long journal entries are kept (0 to keep forever)")
+	cmd.Flags().StringVar(&virtualPrefix, "virtual-prefix", virtualPrefix, "MQTT prefix for virtual devices with 'mirror: true' (disabled if empty)")
+	cmd.Flags().StringVar(&bridgePrefix, "bridge-prefix", bridgePrefix, "Mirror device states and commands under <prefix>/<area>/<device>/<attr>, accepting commands on .../set (disabled if empty)")
+	cmd.Flags().StringVar(&simulateTime, "simulate-time", simulateTime, "Run time events on a virtual clock starting at this time, e.g. 2024-06-21T20:55 (for testing)")
+	cmd.Flags().Float64Var(&simulateSpeed, "simulate-speed", simulateSpeed, "Virtual seconds per real second with --simulate-time")
+	cmd.Flags().BoolVar(&coalesce, "coalesce-commands", coalesce, "Drop a queued device command when the next one queued overwrites all its attributes")
+	cmd.Flags().BoolVar(&echoCommands, "echo-commands", echoCommands, "Log every device command at INFO with the attributes it changes and the sending script, whatever --log-level")
+	cmd.Flags().DurationVar(&seedTimeout, "seed-timeout", seedTimeout, "How long startup waits for Zigbee2MQTT devices to answer /get state requests; retained and requested states don't route events (0 to disable)")
+	cmd.Flags().DurationVar(&staleAfter, "stale-after", staleAfter, "Route a stale event for devices silent this long (0 = only devices with stale_after)")
+	cmd.Flags().IntVar(&historySize, "history-size", historySize, "Number of recent values kept in memory per device attribute for event.history")
+	cmd.Flags().DurationVar(&scriptBudget, "script-budget", scriptBudget, "Default script execution budget, overridable per script with '-- @budget 200ms' (0 to disable)")
+	return cmd
 }
 
 func discoverCmd() *cobra.Command {
 	var timeout int
+	var lan bool
+	var dryRun bool
+	var include, exclude []string
 
 	cmd := &cobra.Command{
 		Use:   "discover",
 		Short: "Discover devices and generate configuration",
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := runDiscovery(time.Duration(timeout) * time.Second); err != nil {
+			if err := runDiscovery(time.Duration(timeout)*time.Second, lan, dryRun, include, exclude); err != nil {
 				logger.Critical("Discovery error: %v", err)
 				os.Exit(1)
 			}
@@ -93,18 +197,167 @@ func discoverCmd() *cobra.Command {
 	}
 
 	cmd.Flags().IntVar(&timeout, "timeout", 15, "Discovery timeout in seconds")
+	cmd.Flags().BoolVar(&lan, "lan", false, "Also scan the LAN with mDNS and SSDP for ESPHome, Shelly, WLED and cameras not on MQTT yet")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would change in devices.yaml without writing any file")
+	cmd.Flags().StringArrayVar(&include, "include", nil, "Only keep devices matching field=pattern[,field=pattern] (vendor, model, name, id, topic; repeatable)")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", nil, "Skip devices matching field=pattern[,field=pattern], e.g. name=0x* (repeatable)")
 	return cmd
 }
 
-func runDiscovery(timeout time.Duration) error {
+// logAreaSummary reports how discovered devices are spread across areas
+func logAreaSummary(devs []*types.Device) {
+	counts := make(map[string]int)
+	unassigned := 0
+	for _, dev := range devs {
+		if dev.Area == "" {
+			unassigned++
+			continue
+		}
+		counts[dev.Area]++
+	}
+
+	areas := make([]string, 0, len(counts))
+	for area, n := range counts {
+		areas = append(areas, fmt.Sprintf("%s (%d)", area, n))
+	}
+	sort.Strings(areas)
+
+	if len(areas) > 0 {
+		logger.Info("Areas: %s", strings.Join(areas, ", "))
+	}
+	if unassigned > 0 {
+		logger.Info("%d device(s) without area; set 'area' in devices.yaml", unassigned)
+	}
+}
+
+// loadDeviceConfig loads devices.yaml and resolves device templates from
+// config/devices/templates
+func loadDeviceConfig() (*types.DevicesConfig, error) {
+	deviceConfig, err := config.LoadDevicesYAML(configPath + "/devices/devices.yaml")
+	if err != nil {
+		return nil, err
+	}
+	templates, err := devices.LoadTemplates(configPath + "/devices/templates")
+	if err != nil {
+		return nil, err
+	}
+	if err := devices.ApplyTemplates(deviceConfig.Devices, templates); err != nil {
+		return nil, err
+	}
+	return deviceConfig, nil
+}
+
+// mqttConfig builds the broker connection settings from the flags and the
+// mqtt section of server.yaml (flags win)
+func mqttConfig(clientID string) (mqtt.Config, error) {
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return mqtt.Config{}, err
+	}
+	tlsConfig := serverConfig.MQTT.TLS
+	if mqttCA != "" {
+		tlsConfig.CACert = mqttCA
+	}
+	if mqttCert != "" {
+		tlsConfig.ClientCert = mqttCert
+	}
+	if mqttKey != "" {
+		tlsConfig.ClientKey = mqttKey
+	}
+	if mqttTLSSkip {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return mqtt.Config{
+		Broker:             mqttBroker,
+		ClientID:           clientID,
+		CleanSession:       true,
+		Username:           mqttUser,
+		Password:           mqttPass,
+		TopicPrefix:        topicPrefix,
+		CACert:             tlsConfig.CACert,
+		ClientCert:         tlsConfig.ClientCert,
+		ClientKey:          tlsConfig.ClientKey,
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+		Topics:             serverConfig.Topics,
+	}, nil
+}
+
+// openStorage opens the state database: the SQL database configured under
+// storage in server.yaml, or the bbolt file at --db
+func openStorage() (*storage.Storage, error) {
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return nil, err
+	}
+	if serverConfig.Storage.Driver != "" {
+		return storage.NewSQL(serverConfig.Storage.Driver, serverConfig.Storage.DSN)
+	}
+	return storage.New(dbPath)
+}
+
+// backupSettings returns where backups go and how many are kept, with the
+// defaults for what server.yaml leaves out
+func backupSettings(cfg config.BackupConfig) (string, int) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(dbPath), "backups")
+	}
+	keep := cfg.Keep
+	if keep == 0 {
+		keep = 7
+	}
+	return dir, keep
+}
+
+// onDeviceRenamed updates devices.yaml after a runtime rename and moves the
+// device's event directory; the old ID stays usable as an alias
+func onDeviceRenamed(oldID string, dev *types.Device) {
+	if err := config.RenameDevice(configPath+"/devices/devices.yaml", oldID, dev); err != nil {
+		logger.Warn("Failed to update devices.yaml after renaming %s: %v", oldID, err)
+	}
+
+	oldDir := filepath.Join(configPath, "events", "device", oldID)
+	newDir := filepath.Join(configPath, "events", "device", dev.ID)
+	if _, err := os.Stat(oldDir); err != nil {
+		return
+	}
+	if _, err := os.Stat(newDir); err == nil {
+		logger.Warn("Not moving %s: %s already exists (handlers in both run)", oldDir, newDir)
+		return
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		logger.Warn("Failed to move %s to %s: %v", oldDir, newDir, err)
+		return
+	}
+	logger.Info("Moved handlers of %s to %s", oldID, newDir)
+}
+
+func runDiscovery(timeout time.Duration, lan, dryRun bool, include, exclude []string) error {
+	includeRules, excludeRules, err := discoveryRules(include, exclude)
+	if err != nil {
+		return err
+	}
+
 	logger.Info("Starting device discovery...")
 
+	// The LAN scan runs alongside MQTT discovery
+	var lanDevices []*types.LANDevice
+	var lanErr error
+	lanDone := make(chan struct{})
+	if lan {
+		go func() {
+			defer close(lanDone)
+			lanDevices, lanErr = discovery.ScanLAN(timeout)
+		}()
+	} else {
+		close(lanDone)
+	}
+
 	// Connect to MQTT for discovery
-	cfg := mqtt.Config{
-		Broker:   mqttBroker,
-		ClientID: "homescript-discovery",
-		Username: mqttUser,
-		Password: mqttPass,
+	cfg, err := mqttConfig("homescript-discovery")
+	if err != nil {
+		return err
 	}
 
 	mqttClient, err := mqtt.NewClient(cfg, nil, nil)
@@ -119,12 +372,24 @@ func runDiscovery(timeout time.Duration) error {
 
 	// Create temporary device manager for HA config registration
 	tempDeviceManager := devices.New(mqttClient.GetInternalClient(), nil)
+	tempDeviceManager.SetTopics(cfg.Topics)
 
 	// Run discovery
 	disc := discovery.New(mqttClient.GetInternalClient())
+	disc.SetTopics(cfg.Topics)
 	disc.SetHAManager(tempDeviceManager.GetHAManager())
+	disc.SetFilter(includeRules, excludeRules)
 	discoveredDevices := disc.Discover(timeout)
 
+	<-lanDone
+	if lanErr != nil {
+		logger.Warn("%v", lanErr)
+	} else if lan && !dryRun {
+		if err := saveLANReport(discovery.UnknownLAN(lanDevices, discoveredDevices)); err != nil {
+			return err
+		}
+	}
+
 	if len(discoveredDevices) == 0 {
 		logger.Warn("No devices discovered")
 		return nil
@@ -132,12 +397,28 @@ func runDiscovery(timeout time.Duration) error {
 
 	logger.Info("Discovered %d device(s)", len(discoveredDevices))
 
-	// Generate devices.yaml
 	devicesYAMLPath := configPath + "/devices/devices.yaml"
-	if err := config.GenerateDevicesYAML(discoveredDevices, devicesYAMLPath); err != nil {
+	sources := config.Sources{Topics: cfg.Topics, Bases: disc.AnsweredBases()}
+	if dryRun {
+		merge, err := config.MergeDiscovered(discoveredDevices, sources, devicesYAMLPath)
+		if err != nil {
+			return err
+		}
+		if merge.Empty() {
+			fmt.Printf("%s is up to date\n", devicesYAMLPath)
+		} else {
+			fmt.Print(merge.Diff())
+		}
+		return nil
+	}
+
+	// Generate devices.yaml
+	merge, err := config.GenerateDevicesYAML(discoveredDevices, sources, devicesYAMLPath)
+	if err != nil {
 		return err
 	}
-	logger.Info("Generated: %s", devicesYAMLPath)
+	logger.Info("Generated: %s (%d added, %d removed, %d updated)", devicesYAMLPath, len(merge.Added), len(merge.Removed), len(merge.Changed))
+	logAreaSummary(discoveredDevices)
 
 	// Save HA discovery configs
 	haConfigsPath := configPath + "/devices/ha_configs.json"
@@ -159,21 +440,95 @@ func runDiscovery(timeout time.Duration) error {
 	return nil
 }
 
+// discoveryRules combines the include and exclude rules of server.yaml with
+// those given as flags
+func discoveryRules(include, exclude []string) ([]types.DiscoveryRule, []types.DiscoveryRule, error) {
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return nil, nil, err
+	}
+	includeRules := serverConfig.Discovery.Include
+	for _, s := range include {
+		rule, err := discovery.ParseRule(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		includeRules = append(includeRules, rule)
+	}
+	excludeRules := serverConfig.Discovery.Exclude
+	for _, s := range exclude {
+		rule, err := discovery.ParseRule(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		excludeRules = append(excludeRules, rule)
+	}
+	return includeRules, excludeRules, nil
+}
+
+// saveLANReport lists the LAN devices not on MQTT and writes the report
+func saveLANReport(found []*types.LANDevice) error {
+	if len(found) == 0 {
+		logger.Info("LAN scan found no devices missing from MQTT")
+		return nil
+	}
+	logger.Info("Found %d LAN device(s) not on MQTT:", len(found))
+	for _, dev := range found {
+		logger.Info("  %-8s %s (%s, %s)", dev.Kind, dev.Name, dev.Address, dev.Source)
+	}
+
+	reportPath := configPath + "/devices/lan_devices.yaml"
+	if err := config.SaveLANReport(found, reportPath); err != nil {
+		return err
+	}
+	logger.Info("Saved LAN report with hints and suggested entries to: %s", reportPath)
+	return nil
+}
+
 func runServer() error {
 	logger.Info("Starting Smart Home Server...")
 
 	// Load device configuration
-	devicesYAMLPath := configPath + "/devices/devices.yaml"
-	deviceConfig, err := config.LoadDevicesYAML(devicesYAMLPath)
+	deviceConfig, err := loadDeviceConfig()
 	if err != nil {
 		logger.Error("Failed to load devices config: %v", err)
 		logger.Info("Run 'homescript-server discover' first to generate configuration")
 		return err
 	}
-	logger.Info("Loaded %d device(s)", len(deviceConfig.Devices))
+	logger.Info("Loaded %d device(s), %d virtual", len(deviceConfig.Devices), len(deviceConfig.Virtual))
+
+	// Hand-added templated devices never go through discovery, scaffold them here
+	var templated []*types.Device
+	for _, dev := range deviceConfig.Devices {
+		if dev.Template != "" {
+			templated = append(templated, dev)
+		}
+	}
+	if len(templated) > 0 {
+		if err := scaffold.GenerateDeviceScaffolds(templated, configPath); err != nil {
+			logger.Warn("Failed to scaffold templated devices: %v", err)
+		}
+	}
+
+	// Load optional server settings
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return err
+	}
+	if httpAddr != "" {
+		serverConfig.HTTP.Listen = httpAddr
+	}
+	if serverConfig.API.Enabled && serverConfig.HTTP.Listen != "" {
+		// Keep recent log lines for GET /api/logs from here on
+		lines := serverConfig.API.LogLines
+		if lines <= 0 {
+			lines = defaultLogLines
+		}
+		logger.EnableTail(lines)
+	}
 
 	// Initialize storage
-	store, err := storage.New(dbPath)
+	store, err := openStorage()
 	if err != nil {
 		return err
 	}
@@ -182,17 +537,30 @@ func runServer() error {
 			logger.Error("Error closing storage: %v", err)
 		}
 	}()
+	store.SetBatchWindow(serverConfig.Storage.BatchWindow)
 	logger.Debug("Storage initialized")
 
-	// Connect to MQTT first (without router/deviceManager)
-	cfg := mqtt.Config{
-		Broker:   mqttBroker,
-		ClientID: "homescript-server-" + time.Now().Format("20060102150405"),
-		Username: mqttUser,
-		Password: mqttPass,
+	// Connect to MQTT once; the router and device manager are attached below,
+	// before anything is subscribed
+	// A stable client ID resumes the broker session, so QoS 1 messages sent
+	// while the server was down are delivered once it is back
+	clientID := mqttClientID
+	if clientID == "" {
+		clientID = serverConfig.MQTT.ClientID
 	}
+	persistent := clientID != ""
+	if !persistent {
+		clientID = "homescript-server-" + time.Now().Format("20060102150405")
+	}
+	cfg, err := mqttConfig(clientID)
+	if err != nil {
+		return err
+	}
+	cfg.CleanSession = !persistent
+	cfg.StatusTopic = statusTopic
+	cfg.SharedGroup = serverConfig.MQTT.SharedGroup
+	cfg.ConsolidateMin = serverConfig.MQTT.Consolidate
 
-	// Create MQTT client without router (will be set later)
 	mqttClient, err := mqtt.NewClient(cfg, nil, nil)
 	if err != nil {
 		return err
@@ -202,6 +570,15 @@ func runServer() error {
 
 	// Initialize device manager with MQTT client
 	deviceManager := devices.New(mqttClient.GetInternalClient(), deviceConfig.Devices)
+	deviceManager.SetTopics(cfg.Topics)
+	deviceManager.SetVirtualDevices(deviceConfig.Virtual)
+	deviceManager.SetGroups(deviceConfig.Groups)
+	deviceManager.SetCoalesce(coalesce)
+	deviceManager.SetCommandEcho(echoCommands)
+
+	// Track per-device message rates and command outcomes
+	deviceMetrics := metrics.New()
+	deviceManager.SetMetrics(deviceMetrics)
 
 	// Load and register HA discovery configs
 	haConfigsPath := configPath + "/devices/ha_configs.json"
@@ -218,32 +595,178 @@ func runServer() error {
 
 	// Initialize executor with device manager and storage
 	exec := executor.New(store, deviceManager, configPath)
+	exec.SetScriptBudget(scriptBudget)
+	exec.SetJobWorkers(jobWorkers)
+	backupDir, backupKeep := backupSettings(serverConfig.Storage.Backup)
+	exec.SetBackupDir(backupDir, backupKeep)
+	if interval := serverConfig.Storage.Backup.Interval; interval > 0 && serverConfig.Storage.Driver == "" {
+		stopBackups := store.StartBackups(backupDir, interval, backupKeep, serverConfig.Storage.Backup.Compact)
+		defer stopBackups()
+		logger.Info("Backing up state to %s every %s", backupDir, interval)
+	}
 	pool := executor.NewPool(exec, 10, 100)
+	pool.SetReservedWorkers(fastLaneWorkers)
 	pool.Start()
 	defer pool.Stop()
-	logger.Debug("Worker pool started with 10 workers")
+	logger.Debug("Worker pool started with 10 workers (+%d fast-lane)", fastLaneWorkers)
 
 	// Initialize event router with worker pool
 	router := events.New(configPath, pool)
+	defer router.Close()
+	router.SetPriorityAttributes(priorityAttributes)
+	router.SetDeviceStates(deviceManager)
+	router.SetAliases(deviceManager)
+	router.SetSubscriber(exec)
+	if err := router.SetScriptFlags(store); err != nil {
+		logger.Warn("Failed to load disabled handler scripts: %v", err)
+	}
+	exec.SetRouter(router)
+	deviceManager.SetRouter(router)
+
+	// Scenes from config/scenes/*.yaml, via scene.activate or a "scene" custom event
+	sceneEngine := scenes.New(configPath+"/scenes", deviceManager)
+	router.SetScenes(sceneEngine)
+	exec.SetScenes(sceneEngine)
+
+	// Keep recent device values in memory for event.history
+	eventHistory := history.New(historySize)
+	router.SetHistory(eventHistory)
+	exec.SetHistory(eventHistory)
+	deviceMetrics.SetHistory(eventHistory)
+
+	// Journal every routed event to its own file for replay and queries
+	if recordEvents {
+		eventJournal, err := journal.Open(journalFile(), journalRetention)
+		if err != nil {
+			return err
+		}
+		defer eventJournal.Close()
+		eventJournal.Start()
+		router.SetRecorder(eventJournal)
+		logger.Info("Event journal: %s (retention %v)", journalFile(), journalRetention)
+
+		// Have a local language model summarize the day
+		if serverConfig.Summary.Model != "" {
+			at := serverConfig.Summary.At
+			if at == "" {
+				at = "21:00"
+			}
+			summarizer := newSummarizer(serverConfig.Summary, eventJournal, router)
+			if err := summarizer.Start(at); err != nil {
+				return err
+			}
+			defer summarizer.Stop()
+		}
+	} else if serverConfig.Summary.Model != "" {
+		logger.Warn("Daily summary needs the event journal (--record-events), disabled")
+	}
+	// Attach device metadata to device events before they reach scripts
+	if serverConfig.Enrich.Enabled {
+		enricher, err := events.NewEnricher(deviceManager, serverConfig.Enrich.Fields)
+		if err != nil {
+			return err
+		}
+		enricher.SetPreviousDefaults(serverConfig.Enrich.PreviousDefaults)
+		router.Use(enricher)
+	}
 	logger.Debug("Event router initialized")
 
-	// Recreate MQTT client with router and device manager
-	mqttClient.Disconnect()
-	mqttClient, err = mqtt.NewClient(cfg, router, deviceManager)
-	if err != nil {
-		return err
+	// Replicate state and history off the device in the background
+	if targets := mirrorTargets(serverConfig.Mirror); len(targets) > 0 {
+		interval := serverConfig.Mirror.Interval
+		if interval <= 0 {
+			interval = 5 * time.Minute
+		}
+		stateMirror := mirror.New(store, eventHistory, interval, targets...)
+		stateMirror.Start()
+		defer stateMirror.Stop()
 	}
-	defer mqttClient.Disconnect()
 
-	// Update device manager's MQTT client to use the new connected one
-	deviceManager.SetClient(mqttClient.GetInternalClient())
+	// Route MQTT messages now that the pipeline is complete
+	mqttClient.SetRouter(router)
+	mqttClient.SetDeviceManager(deviceManager)
+	exec.SetPublisher(mqttClient)
 
-	logger.Debug("MQTT reconnected with event routing")
+	// Follow Zigbee2MQTT renames: persist the new ID and move its handlers
+	mqttClient.SetRenameHandler(onDeviceRenamed)
+	mqttClient.SetFPSThresholds(mqtt.FPSThresholds{
+		CameraFPS:    serverConfig.Frigate.MinCameraFPS,
+		DetectionFPS: serverConfig.Frigate.MinDetectionFPS,
+	})
 
-	// Subscribe to device topics
+	// Subscribe to device topics; retained states only fill the device
+	// manager until seeding is done
+	if seedTimeout > 0 {
+		mqttClient.BeginSeeding()
+	}
 	if err := mqttClient.SubscribeToDevices(); err != nil {
 		return err
 	}
+	for _, sub := range serverConfig.Subscriptions {
+		if err := mqttClient.Subscribe(mqtt.Subscription(sub)); err != nil {
+			logger.Warn("%v", err)
+		}
+	}
+	if serverConfig.HomeAssistant.Discovery {
+		if err := mqttClient.SubscribeHADiscovery(); err != nil {
+			logger.Warn("%v", err)
+		}
+	}
+	mqttClient.DeliverQueued()
+	mqttClient.Seed(seedTimeout)
+
+	// Add devices paired or announced while the server runs
+	if serverConfig.Discovery.Background {
+		stopDiscovery, err := startBackgroundDiscovery(cfg, serverConfig.Discovery, mqttClient, deviceManager, router, serverConfig.HomeAssistant.Discovery)
+		if err != nil {
+			logger.Warn("Failed to start background discovery: %v", err)
+		} else {
+			defer stopDiscovery()
+		}
+	}
+
+	// Import the entities that only exist inside Home Assistant
+	if wsConfig := serverConfig.HomeAssistant.WebSocket; wsConfig.URL != "" {
+		haBridge, err := hass.New(hass.Config(wsConfig), deviceManager, router)
+		if err != nil {
+			return err
+		}
+		deviceManager.SetForwarder(haBridge)
+		exec.SetHomeAssistant(haBridge)
+		haBridge.Start()
+		defer haBridge.Stop()
+	}
+
+	// Watch for devices that stopped reporting
+	stopStaleWatch := deviceManager.StartStaleWatch(staleAfter)
+	defer stopStaleWatch()
+
+	// Mirror virtual devices flagged with 'mirror: true' for dashboards
+	if virtualPrefix != "" {
+		if err := deviceManager.StartVirtualMirror(virtualPrefix); err != nil {
+			return err
+		}
+	}
+
+	// Mirror devices to a clean namespace if bridge mode is enabled
+	if bridgePrefix != "" {
+		deviceBridge := bridge.New(mqttClient.GetInternalClient(), bridgePrefix, deviceManager)
+		if err := deviceBridge.Start(); err != nil {
+			return err
+		}
+	}
+
+	// Fan out state changes to filtered stream subscribers
+	streamHub := stream.NewHub()
+	deviceManager.AddStateListener(streamHub.PublishState)
+
+	if serverConfig.Stream.MQTTPrefix != "" {
+		mqttStream := stream.NewMQTTStream(mqttClient.GetInternalClient(), streamHub, serverConfig.Stream.MQTTPrefix)
+		if err := mqttStream.Start(); err != nil {
+			return err
+		}
+		defer mqttStream.Stop()
+	}
 
 	// Auto-detect location if coordinates not specified
 	schedulerLatitude := latitude
@@ -260,25 +783,113 @@ func runServer() error {
 		}
 	}
 
+	// A simulated clock lets time handlers be tried without waiting for them
+	var clock *scheduler.Clock
+	if simulateTime != "" {
+		start, err := scheduler.ParseSimulatedTime(simulateTime, time.Local)
+		if err != nil {
+			return err
+		}
+		clock = scheduler.NewSimulatedClock(start, simulateSpeed)
+		logger.Warn("Simulating time from %s at %gx speed; time events and timers follow the virtual clock", start.Format("2006-01-02 15:04"), simulateSpeed)
+	}
+
 	// Initialize and start scheduler for time-based events
 	sched := scheduler.New(router, scheduler.Config{
 		Latitude:  schedulerLatitude,
 		Longitude: schedulerLongitude,
+		Clock:     clock,
 	})
 
 	// Connect executor and scheduler bidirectionally
 	exec.SetScheduler(sched)
 	sched.SetExecutor(exec)
 
+	// Effects run on the scheduler; put back devices a restart caught mid-effect
+	effectEngine := effects.New(deviceManager, store, sched)
+	effectEngine.Recover()
+	exec.SetEffects(effectEngine)
+	defer effectEngine.Stop()
+
+	// Apply devices.yaml changes on SIGHUP or POST /reload, all or nothing
+	reloader := reload.New(configPath, loadDeviceConfig, deviceConfig, deviceManager, mqttClient, router)
+
+	// Start embedded HTTP server if configured (after the scheduler, which /schedule reads)
+	if serverConfig.HTTP.Listen != "" {
+		httpServer := api.New(serverConfig.HTTP.Listen)
+		if serverConfig.HTTP.TLSCert != "" || serverConfig.HTTP.TLSKey != "" {
+			if err := httpServer.SetTLS(serverConfig.HTTP.TLSCert, serverConfig.HTTP.TLSKey); err != nil {
+				return err
+			}
+		}
+		api.RegisterDocs(httpServer)
+		// The API credentials guard the API and the CLI endpoints alike
+		auth, err := api.NewAPIAuth(serverConfig.API)
+		if err != nil {
+			return err
+		}
+		if auth.Enabled() && !httpServer.TLS() {
+			logger.Warn("API credentials are sent in clear text without http.tls_cert and http.tls_key")
+		}
+		if serverConfig.Guest.Enabled {
+			api.RegisterGuest(httpServer, deviceManager, serverConfig.Guest.Token, serverConfig.Guest.Devices)
+		}
+		if len(serverConfig.Kiosk.Actions) > 0 {
+			api.RegisterKiosk(httpServer, serverConfig.Kiosk, exec, deviceManager, router)
+		}
+		if serverConfig.Stream.Enabled {
+			api.RegisterStream(httpServer, streamHub, auth)
+		}
+		if serverConfig.Metrics.Enabled {
+			api.RegisterMetrics(httpServer, deviceMetrics, router, exec, auth)
+		}
+		if serverConfig.Wizard.Enabled {
+			api.RegisterWizard(httpServer, serverConfig.Wizard, deviceManager, router, configPath)
+			api.RegisterEditor(httpServer, serverConfig.Wizard, exec, configPath)
+		}
+		if serverConfig.Mirror.AcceptToken != "" {
+			api.RegisterMirror(httpServer, mirror.NewReceiver(store, eventHistory), serverConfig.Mirror.AcceptToken)
+		}
+		if serverConfig.Schedule.Enabled {
+			api.RegisterSchedule(httpServer, sched, auth)
+		}
+		if serverConfig.Trigger.Enabled {
+			api.RegisterTrigger(httpServer, router, sched, deviceManager, auth)
+		}
+		if serverConfig.Reload.Enabled {
+			api.RegisterReload(httpServer, reloader, auth)
+		}
+		if serverConfig.API.Enabled {
+			api.RegisterREST(httpServer, deviceManager, exec, store, sched, auth)
+			api.RegisterScripts(httpServer, router, exec, auth)
+			api.RegisterEvents(httpServer, router, auth)
+			api.RegisterOpenAPI(httpServer, deviceManager, serverConfig, auth)
+			api.RegisterTail(httpServer, router, auth)
+		}
+		httpServer.Start()
+		defer httpServer.Stop()
+	}
+
+	// Long-lived scripts from config/services/, fed by the router
+	if n := exec.StartServices(); n > 0 {
+		logger.Info("Started %d service(s)", n)
+	}
+	defer exec.StopServices()
+
 	sched.Start()
 	defer sched.Stop()
 
 	logger.Info("Server is running. Press Ctrl+C to stop.")
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal; SIGHUP reloads the configuration
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloader.Reload(false)
+	}
 
 	logger.Info("Shutting down...")
 	return nil
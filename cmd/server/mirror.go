@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/mirror"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// mirrorTargets returns the configured mirror destinations
+func mirrorTargets(cfg config.MirrorConfig) []mirror.Target {
+	var targets []mirror.Target
+	if cfg.URL != "" {
+		targets = append(targets, mirror.NewHTTPTarget(cfg.URL, cfg.Token))
+	}
+	if cfg.S3.Bucket != "" {
+		key := cfg.S3.Key
+		if key == "" {
+			key = "homescript/mirror.json"
+		}
+		targets = append(targets, &mirror.S3Target{
+			Endpoint:  cfg.S3.Endpoint,
+			Bucket:    cfg.S3.Bucket,
+			Key:       key,
+			Region:    cfg.S3.Region,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+		})
+	}
+	return targets
+}
+
+func restoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore <snapshot.json>",
+		Short: "Restore state from a mirror snapshot",
+		Long: `Replace the state database (--db) with a snapshot pushed by state
+mirroring, e.g. the object downloaded from the S3 bucket. Stop the server
+first: the database is locked while it runs. Device history is kept in
+memory and is not restored.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runRestore(args[0]); err != nil {
+				logger.Critical("Restore error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+func runRestore(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	snapshot, err := mirror.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := mirror.Apply(snapshot, store, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d key(s) from %s (taken %s on %s)\n", len(snapshot.State), path, snapshot.Time.Format("2006-01-02 15:04:05"), snapshot.Host)
+	return nil
+}
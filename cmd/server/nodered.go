@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/events"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/nodered"
+	"homescript-server/internal/scenes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func noderedCmd() *cobra.Command {
+	var (
+		sceneNames []string
+		handlers   []string
+		output     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "nodered",
+		Short: "Export scenes and handler conditions as a Node-RED flow",
+		Long: `Render scenes and the conditions.yaml guards of handler directories as
+Node-RED flow JSON (Menu > Import in Node-RED). Scenes become inject nodes
+publishing the commands the server would send; handler directories become
+their trigger, the conditions and cooldown in a function node, and a link out
+node for the converted scripts. Lua scripts aren't converted.
+
+Without --scene and --handler everything is exported: every scene and every
+device and time handler directory with a conditions.yaml. The broker and
+--topic-prefix are taken from the usual flags.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runNodeRED(sceneNames, handlers, output); err != nil {
+				logger.Critical("Node-RED export error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&sceneNames, "scene", nil, "Scene to export (repeatable)")
+	cmd.Flags().StringSliceVar(&handlers, "handler", nil, "Handler directory to export, relative to events/ (e.g. device/hallway_motion/occupancy)")
+	cmd.Flags().StringVarP(&output, "out", "o", "", "Write the flow to this file instead of stdout")
+	return cmd
+}
+
+func runNodeRED(sceneNames, handlers []string, output string) error {
+	deviceConfig, err := loadDeviceConfig()
+	if err != nil {
+		return err
+	}
+	mqttCfg, err := mqttConfig("")
+	if err != nil {
+		return err
+	}
+	exporter, err := nodered.New(deviceConfig.Devices, mqttCfg.Broker, mqttCfg.TopicPrefix)
+	if err != nil {
+		return err
+	}
+	for _, virtual := range deviceConfig.Virtual {
+		exporter.Skip(virtual.ID, "virtual device")
+	}
+	haConfigs, err := config.LoadHAConfigs(configPath + "/devices/ha_configs.json")
+	if err != nil {
+		logger.Warn("Failed to load HA configs: %v", err)
+	}
+	for id := range haConfigs {
+		exporter.Skip(id, "Home Assistant discovery device")
+	}
+
+	sceneEngine := scenes.New(configPath+"/scenes", nil)
+	eventsDir := filepath.Join(configPath, "events")
+	exportAll := len(sceneNames) == 0 && len(handlers) == 0
+	if exportAll {
+		sceneNames = sceneEngine.List()
+		if handlers, err = conditionDirs(eventsDir); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sceneNames {
+		scene, err := sceneEngine.Get(name)
+		if err != nil {
+			return err
+		}
+		exporter.AddScene(scene)
+	}
+	exported := 0
+	for _, dir := range handlers {
+		dir = strings.Trim(filepath.ToSlash(dir), "/")
+		conditions, scripts, err := readHandlerDir(filepath.Join(eventsDir, dir))
+		if err != nil {
+			return err
+		}
+		if err := exporter.AddHandler(dir, conditions, scripts); err != nil {
+			// Exporting everything skips what can't be exported
+			if !exportAll {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "warning: skipped %v\n", err)
+			continue
+		}
+		exported++
+	}
+
+	data, err := json.MarshalIndent(exporter.Flow(), "", "  ")
+	if err != nil {
+		return err
+	}
+	// Warnings go to stderr, stdout may be the flow
+	for _, warning := range exporter.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if output == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d scene(s) and %d handler(s) to %s\n", len(sceneNames), exported, output)
+	return nil
+}
+
+// conditionDirs lists the device and time handler directories with a
+// conditions.yaml, relative to the events directory
+func conditionDirs(eventsDir string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(eventsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || d.Name() != "conditions.yaml" {
+			return nil
+		}
+		rel, err := filepath.Rel(eventsDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, "device/") || strings.HasPrefix(rel, "time/") {
+			dirs = append(dirs, rel)
+		}
+		return nil
+	})
+	sort.Strings(dirs)
+	return dirs, err
+}
+
+// readHandlerDir reads the conditions (nil if there are none) and the names
+// of the Lua scripts of a handler directory
+func readHandlerDir(dir string) (*events.Conditions, []string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var scripts []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".lua") {
+			scripts = append(scripts, entry.Name())
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "conditions.yaml"))
+	if os.IsNotExist(err) {
+		return nil, scripts, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	conditions, err := events.ParseConditions(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", filepath.Join(dir, "conditions.yaml"), err)
+	}
+	return conditions, scripts, nil
+}
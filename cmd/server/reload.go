@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/reload"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func reloadCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "reload",
+		Short: "Apply devices.yaml changes to the running server",
+		Long: `Validate devices.yaml, every handler script, library, conditions.yaml
+and scene on the running server, and only if all of them pass, swap in the
+new devices and groups in one step. Prints what changed; with --dry-run
+nothing is applied. Requires 'reload.enabled' in server.yaml and the HTTP
+server (sending SIGHUP to the server reloads too).
+
+Scripts are picked up as they are saved either way; a reload reports the
+broken ones. Virtual devices and services need a restart.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "/reload"
+			if dryRun {
+				path += "?dry_run=1"
+			}
+			var result reload.Result
+			if err := postJSON(path, nil, &result); err != nil {
+				logger.Critical("Reload error: %v", err)
+				os.Exit(1)
+			}
+			printReload(&result, dryRun)
+			if len(result.Errors) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API")
+	cmd.Flags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Validate and show what would change without applying it")
+	return cmd
+}
+
+func printReload(result *reload.Result, dryRun bool) {
+	if len(result.Errors) > 0 {
+		fmt.Printf("Reload rejected, nothing changed (%d error(s)):\n", len(result.Errors))
+		for _, err := range result.Errors {
+			fmt.Printf("  %s\n", err)
+		}
+		fmt.Println()
+	}
+
+	changed := false
+	for _, section := range []struct {
+		name    string
+		changes reload.Changes
+	}{
+		{"Devices", result.Devices},
+		{"Groups", result.Groups},
+		{"Files", result.Files},
+	} {
+		c := section.changes
+		if c.Count() == 0 {
+			continue
+		}
+		changed = true
+		fmt.Printf("%s:\n", section.name)
+		for _, list := range []struct {
+			mark  string
+			names []string
+		}{{"+", c.Added}, {"-", c.Removed}, {"~", c.Changed}} {
+			for _, name := range list.names {
+				fmt.Printf("  %s %s\n", list.mark, name)
+			}
+		}
+	}
+	if !changed {
+		fmt.Println("No changes")
+	}
+	if len(result.Restart) > 0 {
+		fmt.Printf("\nRestart to apply: %s\n", strings.Join(result.Restart, ", "))
+	}
+
+	switch {
+	case result.Applied:
+		fmt.Println("\nReloaded")
+	case dryRun && len(result.Errors) == 0:
+		fmt.Println("\nValid, nothing applied (--dry-run)")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/events"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/journal"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/scenes"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func replayCmd() *cobra.Command {
+	var (
+		since  time.Duration
+		filter journal.Filter
+		dryRun bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Re-run journaled events through the router",
+		Long: `Re-inject events from the event journal through the event router to
+reproduce why a script did or didn't fire. Stop the server first: the
+databases are locked while it runs. Timers created by replayed scripts are
+not executed.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+			if err := runReplay(filter, dryRun); err != nil {
+				logger.Critical("Replay error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&since, "since", time.Hour, "Replay events journaled within this duration (0 for all)")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "Replay only the N most recent matching events (0 for all)")
+	addJournalFilterFlags(cmd, &filter)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List events and matching scripts without executing them")
+	return cmd
+}
+
+func runReplay(filter journal.Filter, dryRun bool) error {
+	eventJournal, err := journal.Open(journalFile(), 0)
+	if err != nil {
+		return err
+	}
+	defer eventJournal.Close()
+
+	recorded, err := eventJournal.Events(filter)
+	if err != nil {
+		return err
+	}
+	if len(recorded) == 0 {
+		fmt.Println("No journaled events match the filter")
+		return nil
+	}
+
+	var exec *executor.Executor
+	if !dryRun {
+		store, err := openStorage()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		deviceConfig, err := loadDeviceConfig()
+		if err != nil {
+			return err
+		}
+
+		cfg, err := mqttConfig("homescript-replay")
+		if err != nil {
+			return err
+		}
+		mqttClient, err := mqtt.NewClient(cfg, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer mqttClient.Disconnect()
+
+		deviceManager := devices.New(mqttClient.GetInternalClient(), deviceConfig.Devices)
+		deviceManager.SetTopics(cfg.Topics)
+		deviceManager.SetVirtualDevices(deviceConfig.Virtual)
+		deviceManager.SetGroups(deviceConfig.Groups)
+		exec = executor.New(store, deviceManager, configPath)
+		exec.SetScenes(scenes.New(configPath+"/scenes", deviceManager))
+	}
+
+	// Routing only; scripts are executed synchronously below so output stays ordered
+	router := events.New(configPath, nil)
+
+	for i, event := range recorded {
+		scripts := router.FindScripts(event)
+		fmt.Printf("[%d/%d] %s %s/%s", i+1, len(recorded), event.Timestamp.Format("2006-01-02 15:04:05"), event.Source, event.Type)
+		if event.Device != "" {
+			fmt.Printf(" device=%s", event.Device)
+		}
+		if event.Attribute != "" {
+			fmt.Printf(" attribute=%s", event.Attribute)
+		}
+		if event.Topic != "" {
+			fmt.Printf(" topic=%s", event.Topic)
+		}
+		fmt.Println()
+
+		if len(scripts) == 0 {
+			fmt.Println("    no matching scripts")
+			continue
+		}
+
+		for _, script := range scripts {
+			if dryRun {
+				fmt.Printf("    would run %s\n", script)
+				continue
+			}
+
+			if err := exec.Execute(script, event); err != nil {
+				fmt.Printf("    FAILED %s: %v\n", script, err)
+			} else {
+				fmt.Printf("    ran %s\n", script)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/harness"
+	"homescript-server/internal/logger"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func scenarioCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scenario [file or directory...]",
+		Short: "Run automation scenarios against the configuration",
+		Long: `Run YAML scenarios (default: <config>/scenarios) against the configuration
+with an in-memory MQTT broker, temporary storage and a manual clock. Nothing
+is sent to a real broker and the state database is not touched. Exits with
+status 1 if any scenario fails, for use in CI.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				args = []string{filepath.Join(configPath, "scenarios")}
+			}
+			failed, err := runScenarios(args)
+			if err != nil {
+				logger.Critical("Scenario error: %v", err)
+				os.Exit(1)
+			}
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	return cmd
+}
+
+func runScenarios(paths []string) (failed int, err error) {
+	scenarios, err := harness.LoadScenarios(paths)
+	if err != nil {
+		return 0, err
+	}
+	if len(scenarios) == 0 {
+		return 0, fmt.Errorf("no scenarios found in %v", paths)
+	}
+
+	for _, sc := range scenarios {
+		failures, err := sc.Run(configPath)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+		if len(failures) == 0 {
+			fmt.Printf("PASS %s\n", sc.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s\n", sc.Name)
+		for _, f := range failures {
+			fmt.Printf("    %s\n", f)
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", len(scenarios)-failed, failed)
+	return failed, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/scheduler"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func scheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Show what the running server will do for the rest of today",
+		Long: `List today's sunrise and sunset, the time events with a handler that
+will still fire today and the pending timers, to sanity-check schedules.
+Requires 'schedule.enabled' in server.yaml and the HTTP server.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runSchedule(); err != nil {
+				logger.Critical("Schedule error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API")
+	cmd.Flags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API")
+	return cmd
+}
+
+func runSchedule() error {
+	var agenda scheduler.Agenda
+	if err := fetchJSON("/schedule", &agenda); err != nil {
+		return err
+	}
+
+	fmt.Printf("Now:     %s\n", agenda.Now.Format("2006-01-02 15:04 MST"))
+	if agenda.Sunrise != nil && agenda.Sunset != nil {
+		fmt.Printf("Sunrise: %s\n", agenda.Sunrise.Format("15:04"))
+		fmt.Printf("Sunset:  %s\n", agenda.Sunset.Format("15:04"))
+	} else {
+		fmt.Println("Sunrise: unknown (no coordinates)")
+	}
+
+	fmt.Println()
+	printAgenda("Time events", agenda.Events)
+	fmt.Println()
+	printAgenda("Timers", agenda.Timers)
+	return nil
+}
+
+func printAgenda(title string, entries []scheduler.AgendaEntry) {
+	fmt.Printf("%s (%d):\n", title, len(entries))
+	if len(entries) == 0 {
+		fmt.Println("  none for the rest of today")
+		return
+	}
+	for _, e := range entries {
+		line := fmt.Sprintf("  %s  %s", e.At.Format("15:04:05"), e.Name)
+		if e.Every != "" && e.Until != nil {
+			line += fmt.Sprintf("  (every %s until %s)", e.Every, e.Until.Format("15:04"))
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/schema"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func schemaCmd() *cobra.Command {
+	var outDir string
+
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Export devices as JSON schema and the HTTP API as OpenAPI",
+		Long: `Write machine-readable schemas generated from devices.yaml and server.yaml:
+
+  <out>/openapi.json              OpenAPI 3 document of the enabled HTTP endpoints
+  <out>/devices/<id>.schema.json  JSON schema of each device's state
+
+Use them to generate typed clients or validate payloads in external tools.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runSchema(outDir); err != nil {
+				logger.Critical("Schema error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&outDir, "out", "./schema", "Output directory")
+	return cmd
+}
+
+func runSchema(outDir string) error {
+	deviceConfig, err := loadDeviceConfig()
+	if err != nil {
+		return err
+	}
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return err
+	}
+
+	devs := append([]*types.Device(nil), deviceConfig.Devices...)
+	for _, v := range deviceConfig.Virtual {
+		devs = append(devs, virtualDeviceForSchema(v))
+	}
+
+	devicesDir := filepath.Join(outDir, "devices")
+	if err := os.MkdirAll(devicesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	for _, dev := range devs {
+		if err := writeJSONFile(filepath.Join(devicesDir, dev.ID+".schema.json"), schema.Device(dev)); err != nil {
+			return err
+		}
+	}
+
+	openAPIPath := filepath.Join(outDir, "openapi.json")
+	if err := writeJSONFile(openAPIPath, schema.OpenAPI(devs, serverConfig)); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s and %d device schema(s) to %s\n", openAPIPath, len(devs), devicesDir)
+	return nil
+}
+
+// virtualDeviceForSchema describes a virtual device like a regular one
+func virtualDeviceForSchema(v *types.VirtualDevice) *types.Device {
+	dev := &types.Device{ID: v.ID, Name: v.Name, Type: v.Type, Vendor: devices.VirtualVendor, Area: v.Area}
+	switch v.Type {
+	case "switch":
+		dev.Attributes = []string{"state"}
+		dev.Schema = map[string]*types.AttributeSchema{"state": {Values: []string{"ON", "OFF", "TOGGLE"}}}
+	case "number":
+		dev.Attributes = []string{"value"}
+		dev.Schema = map[string]*types.AttributeSchema{"value": {Min: v.Min, Max: v.Max}}
+	case "text":
+		dev.Attributes = []string{"value"}
+	}
+	return dev
+}
+
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
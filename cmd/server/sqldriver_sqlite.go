@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// Registers the pure-Go SQLite driver as "sqlite" for storage.driver.
+// Build with: go build -tags sqlite ./cmd/server
+import _ "modernc.org/sqlite"
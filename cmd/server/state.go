@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/logger"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// stateFile is what 'state export' writes and 'state import' reads
+type stateFile struct {
+	Exported time.Time              `json:"exported"`
+	State    map[string]interface{} `json:"state"`
+	// Expires holds the expiry of keys set with a TTL
+	Expires map[string]time.Time `json:"expires,omitempty"`
+}
+
+func stateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Export, import, back up or compact the state database",
+		Long: `Back up, migrate or inspect the state database (--db) as JSON. Stop the
+server first: the database is locked while it runs. While it runs, back up
+with storage.backup in server.yaml or system.backup() instead.`,
+	}
+	cmd.AddCommand(stateExportCmd())
+	cmd.AddCommand(stateImportCmd())
+	cmd.AddCommand(stateBackupCmd())
+	cmd.AddCommand(stateCompactCmd())
+	return cmd
+}
+
+func stateExportCmd() *cobra.Command {
+	var prefix string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the state as JSON to stdout",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runStateExport(prefix, os.Stdout); err != nil {
+				logger.Critical("Export error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Only export keys starting with this prefix")
+	return cmd
+}
+
+func stateImportCmd() *cobra.Command {
+	var replace bool
+	cmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Load state exported by 'state export' (stdin without a file)",
+		Long: `Load state exported by 'state export'. Keys are added to the database,
+overwriting keys with the same name; with --replace everything else is
+deleted. Keys whose TTL ran out since the export are skipped.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			in := io.Reader(os.Stdin)
+			name := "stdin"
+			if len(args) == 1 {
+				f, err := os.Open(args[0])
+				if err != nil {
+					logger.Critical("Import error: %v", err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				in, name = f, args[0]
+			}
+			if err := runStateImport(in, name, replace); err != nil {
+				logger.Critical("Import error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&replace, "replace", false, "Delete keys that are not in the file")
+	return cmd
+}
+
+func stateBackupCmd() *cobra.Command {
+	var dir string
+	var keep int
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Copy the state database to a timestamped file",
+		Long: `Copy the state database to state-<timestamp>.db in the backup directory
+(storage.backup.dir in server.yaml, backups/ next to --db by default) and
+delete the oldest copies beyond --keep. Restore a copy by stopping the
+server and putting it in place of --db.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runStateBackup(cmd, dir, keep); err != nil {
+				logger.Critical("Backup error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "Backup directory (default from server.yaml)")
+	cmd.Flags().IntVar(&keep, "keep", 0, "Copies to keep, -1 for all (default from server.yaml, 7)")
+	return cmd
+}
+
+func stateCompactCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "compact",
+		Short: "Shrink the state database file",
+		Long: `Rewrite the state database without the free pages left behind by deleted
+and expired keys. Set storage.backup.compact in server.yaml to compact after
+every scheduled backup while the server runs.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			store, err := openStorage()
+			if err != nil {
+				logger.Critical("Compact error: %v", err)
+				os.Exit(1)
+			}
+			defer store.Close()
+			before, after, err := store.Compact()
+			if err != nil {
+				logger.Critical("Compact error: %v", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Compacted %s: %d -> %d bytes\n", dbPath, before, after)
+		},
+	}
+}
+
+func runStateBackup(cmd *cobra.Command, dir string, keep int) error {
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return err
+	}
+	defaultDir, defaultKeep := backupSettings(serverConfig.Storage.Backup)
+	if dir == "" {
+		dir = defaultDir
+	}
+	if !cmd.Flags().Changed("keep") {
+		keep = defaultKeep
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	path, err := store.BackupTo(dir, keep)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		fmt.Printf("Backed up %s to %s (%d bytes)\n", dbPath, path, info.Size())
+	} else {
+		fmt.Printf("Backed up %s to %s\n", dbPath, path)
+	}
+	return nil
+}
+
+func runStateExport(prefix string, out io.Writer) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	all, err := store.All()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	file := stateFile{Exported: now, State: make(map[string]interface{}), Expires: make(map[string]time.Time)}
+	for key, value := range all {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		file.State[key] = value
+		if ttl, ok := store.TTL(key); ok {
+			file.Expires[key] = now.Add(ttl)
+		}
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+func runStateImport(in io.Reader, name string, replace bool) error {
+	var file stateFile
+	if err := json.NewDecoder(in).Decode(&file); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if file.State == nil {
+		return fmt.Errorf("%s: no \"state\" object (not written by 'state export'?)", name)
+	}
+
+	// Keys with a TTL are written separately, with the time they have left
+	values := make(map[string]interface{}, len(file.State))
+	ttls := make(map[string]time.Duration)
+	skipped := 0
+	for key, value := range file.State {
+		expires, ok := file.Expires[key]
+		switch {
+		case !ok:
+			values[key] = value
+		case time.Until(expires) > 0:
+			ttls[key] = time.Until(expires)
+		default:
+			skipped++
+		}
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if replace {
+		err = store.Replace(values, nil)
+	} else {
+		err = store.SetMany(values)
+	}
+	if err != nil {
+		return err
+	}
+	for key, ttl := range ttls {
+		if err := store.SetTTL(key, file.State[key], ttl); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+
+	fmt.Printf("Imported %d key(s) from %s", len(values)+len(ttls), name)
+	if skipped > 0 {
+		fmt.Printf(", skipped %d expired", skipped)
+	}
+	fmt.Println()
+	return nil
+}
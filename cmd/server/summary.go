@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/journal"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/summary"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newSummarizer creates the daily summary writer configured in server.yaml;
+// router may be nil to only use the webhook
+func newSummarizer(cfg config.SummaryConfig, source summary.Source, router summary.EventRouter) *summary.Summarizer {
+	var notifiers []summary.Notifier
+	if router != nil {
+		notifiers = append(notifiers, &summary.EventNotifier{Router: router})
+	}
+	if cfg.Webhook != "" {
+		notifiers = append(notifiers, summary.NewWebhookNotifier(cfg.Webhook, cfg.WebhookToken))
+	}
+	summarizer := summary.New(source, summary.NewOllama(cfg.URL, cfg.Model), notifiers...)
+	summarizer.SetPrompt(cfg.Prompt)
+	return summarizer
+}
+
+func summaryCmd() *cobra.Command {
+	var (
+		since      time.Duration
+		promptOnly bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "summary",
+		Short: "Write a summary of journaled events with a local language model",
+		Long: `Have the model configured under 'summary' in config/server.yaml write a
+plain-language summary of the journaled events, print it and post it to the
+configured webhook. --prompt-only prints what would be sent to the model
+instead. Stop the server first: the journal is locked while it runs.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runSummary(since, promptOnly); err != nil {
+				logger.Critical("Summary error: %v", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&since, "since", 24*time.Hour, "Summarize events journaled within this duration")
+	cmd.Flags().BoolVar(&promptOnly, "prompt-only", false, "Print the prompt instead of calling the model")
+	cmd.Flags().StringVar(&journalPath, "journal", journalPath, "Event journal file (default "+defaultJournalPath+")")
+	return cmd
+}
+
+func runSummary(since time.Duration, promptOnly bool) error {
+	serverConfig, err := config.LoadServerConfig(configPath + "/server.yaml")
+	if err != nil {
+		return err
+	}
+	if serverConfig.Summary.Model == "" && !promptOnly {
+		return fmt.Errorf("no model configured (summary.model in server.yaml)")
+	}
+
+	eventJournal, err := journal.Open(journalFile(), 0)
+	if err != nil {
+		return err
+	}
+	defer eventJournal.Close()
+
+	summarizer := newSummarizer(serverConfig.Summary, eventJournal, nil)
+	until := time.Now()
+	if promptOnly {
+		prompt, _, err := summarizer.Prompt(until.Add(-since), until)
+		if err != nil {
+			return err
+		}
+		fmt.Print(prompt)
+		return nil
+	}
+
+	result, err := summarizer.Run(context.Background(), until.Add(-since), until)
+	if result != nil {
+		fmt.Println(result.Text)
+	}
+	return err
+}
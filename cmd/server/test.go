@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/harness"
+	"homescript-server/internal/events"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func testCmd() *cobra.Command {
+	var (
+		data    string
+		states  []string
+		advance time.Duration
+		remote  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "test <script>",
+		Short: "Run a handler script once with a synthetic event",
+		Long: `Run one handler script with the event its directory would receive, e.g.
+
+  homescript-server test config/events/device/kitchen_sensor/temperature/on_change.lua --data '{"temperature": 25}'
+
+The script path may also be relative to the events directory. --data is
+merged into the event data; for device events it also updates the device's
+cached state first, as a real report would.
+
+By default the script runs against the configuration with an in-memory MQTT
+broker, temporary storage and mocked devices (as 'scenario' does): nothing
+reaches real devices. Its log lines are printed at info level or above, then
+every script run (including handlers of the events it caused) and every
+MQTT message it published. --state seeds other devices, --advance moves the
+clock on to fire timers.
+
+With --remote the script runs on the running server against the real
+devices instead, through POST /api/scripts/run (requires 'api.enabled' and a
+token of the control scope), and the log lines logged meanwhile are printed.
+
+Exits with status 1 if a script failed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			script, err := eventsRelative(args[0])
+			if err != nil {
+				logger.Critical("Test error: %v", err)
+				os.Exit(1)
+			}
+			var eventData map[string]interface{}
+			if data != "" {
+				if err := json.Unmarshal([]byte(data), &eventData); err != nil {
+					logger.Critical("Test error: --data must be a JSON object: %v", err)
+					os.Exit(1)
+				}
+			}
+
+			var failed bool
+			if remote {
+				failed, err = runTestRemote(script, eventData)
+			} else {
+				explicitLevel := cmd.Flag("log-level").Changed
+				failed, err = runTest(script, eventData, states, advance, explicitLevel)
+			}
+			if err != nil {
+				logger.Critical("Test error: %v", err)
+				os.Exit(1)
+			}
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&data, "data", "", "Event data as a JSON object")
+	cmd.Flags().StringArrayVar(&states, "state", nil, "Seed a device's cached state, as <id>=<JSON object> (repeatable)")
+	cmd.Flags().DurationVar(&advance, "advance", 0, "Move the clock on by this duration after the run, firing timers")
+	cmd.Flags().BoolVar(&remote, "remote", false, "Run on the running server with its real devices")
+	cmd.Flags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API (with --remote)")
+	cmd.Flags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API (with --remote)")
+	return cmd
+}
+
+// eventsRelative turns a script path (a file, or relative to the events
+// directory) into a path relative to the events directory
+func eventsRelative(script string) (string, error) {
+	if _, err := os.Stat(script); err == nil {
+		root, err := filepath.Abs(filepath.Join(configPath, "events"))
+		if err != nil {
+			return "", err
+		}
+		abs, err := filepath.Abs(script)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is not below %s", script, root)
+		}
+		return filepath.ToSlash(rel), nil
+	}
+	if _, err := os.Stat(filepath.Join(configPath, "events", filepath.FromSlash(script))); err != nil {
+		return "", fmt.Errorf("no such script: %s", script)
+	}
+	return filepath.ToSlash(filepath.Clean(script)), nil
+}
+
+// runTest runs script in a harness and prints what it did
+func runTest(script string, data map[string]interface{}, states []string, advance time.Duration, explicitLevel bool) (bool, error) {
+	event, err := events.SampleEvent(script, nil)
+	if err != nil {
+		return false, err
+	}
+	event.CorrelationID = types.NewCorrelationID()
+
+	h, err := harness.New(harness.Options{
+		ConfigPath: configPath,
+		Start:      time.Now(),
+		Latitude:   latitude,
+		Longitude:  longitude,
+		Echo:       true,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer h.Close()
+
+	for _, seed := range states {
+		id, state, ok := strings.Cut(seed, "=")
+		var attrs map[string]interface{}
+		if !ok || json.Unmarshal([]byte(state), &attrs) != nil {
+			return false, fmt.Errorf("--state %s: want <id>=<JSON object>", seed)
+		}
+		h.SetDeviceState(id, attrs)
+	}
+	if event.Source == "device" && event.Device != "" {
+		if len(data) > 0 {
+			h.SetDeviceState(event.Device, data)
+		}
+		// Device events carry the whole state, like a real report
+		if state, err := h.DeviceState(event.Device); err == nil {
+			for k, v := range state {
+				event.Data[k] = v
+			}
+		}
+	}
+	for k, v := range data {
+		event.Data[k] = v
+	}
+
+	// The harness logs its setup at info; the script's lines are what matter
+	if !explicitLevel && logger.GetLevel() > logger.INFO {
+		logger.SetLevel(logger.INFO)
+	}
+	fmt.Printf("Running %s with %s\n\n", script, describeEvent(event))
+	h.RunScript(script, event)
+	if advance > 0 {
+		h.Advance(advance)
+	}
+
+	failed := false
+	fmt.Println("\nScripts:")
+	for _, run := range h.Runs() {
+		name := run.Script
+		if name == "" {
+			name = "(harness)"
+		}
+		if run.Err != nil {
+			failed = true
+			fmt.Printf("  FAIL  %s\n        %v\n", name, run.Err)
+			continue
+		}
+		fmt.Printf("  ok    %s\n", name)
+	}
+	if published := h.Published(); len(published) > 0 {
+		fmt.Println("Published:")
+		for _, msg := range published {
+			fmt.Printf("  %s %s\n", msg.Topic, msg.Payload)
+		}
+	}
+	return failed, nil
+}
+
+// runTestRemote runs script on the running server and prints the log lines
+// logged meanwhile
+func runTestRemote(script string, data map[string]interface{}) (bool, error) {
+	var before struct {
+		Last uint64 `json:"last"`
+	}
+	if err := fetchJSON(fmt.Sprintf("/api/logs?after=%d", uint64(math.MaxUint64)), &before); err != nil {
+		return false, err
+	}
+
+	var result struct {
+		Event      string `json:"event"`
+		DurationMS int64  `json:"duration_ms"`
+	}
+	var body interface{}
+	if len(data) > 0 {
+		body = data
+	}
+	runErr := postJSON("/api/scripts/run/"+script, body, &result)
+	if _, err := tailLogs(before.Last); err != nil {
+		return false, err
+	}
+	if runErr != nil {
+		fmt.Printf("\nFAIL  %s\n      %v\n", script, runErr)
+		return true, nil
+	}
+	fmt.Printf("\nok    %s (%s event, %dms)\n", script, result.Event, result.DurationMS)
+	return false, nil
+}
+
+// describeEvent renders an event on one line
+func describeEvent(event *types.Event) string {
+	text := event.Source + "/" + event.Type
+	if event.Device != "" {
+		text += " device=" + event.Device
+	}
+	if event.Attribute != "" {
+		text += " attribute=" + event.Attribute
+	}
+	if encoded, err := json.Marshal(event.Data); err == nil && len(event.Data) > 0 {
+		text += " " + string(encoded)
+	}
+	return text
+}
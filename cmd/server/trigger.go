@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/api"
+	"homescript-server/internal/logger"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func triggerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trigger",
+		Short: "Fire a simulated event on a running server",
+		Long: `Route a synthesized event through the running server's router, so
+handlers can be tried without waiting for the real trigger (e.g. sunset
+lighting at noon). The event data has simulated = true.
+Requires 'trigger.enabled' in server.yaml and the HTTP server.`,
+	}
+
+	cmd.PersistentFlags().StringVar(&serverURL, "server", serverURL, "Base URL of the running server's HTTP API")
+	cmd.PersistentFlags().StringVar(&serverToken, "token", serverToken, "Token for the server's HTTP API")
+
+	cmd.AddCommand(triggerTimeCmd())
+	cmd.AddCommand(triggerWebhookCmd())
+	cmd.AddCommand(triggerDeviceCmd())
+	return cmd
+}
+
+func triggerTimeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "time <event>",
+		Short: "Fire a time event now (sunset, 07_00, sunrise/-00_30, ...)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runTrigger("/trigger/time/"+args[0], nil)
+		},
+	}
+}
+
+func triggerWebhookCmd() *cobra.Command {
+	var data string
+	cmd := &cobra.Command{
+		Use:   "webhook <type>",
+		Short: "Fire a webhook event as if POST /api/events/<type> was called",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := make(map[string]interface{})
+			if data != "" {
+				if err := json.Unmarshal([]byte(data), &body); err != nil {
+					logger.Critical("Invalid --data (expected a JSON object): %v", err)
+					os.Exit(1)
+				}
+			}
+			runTrigger("/trigger/webhook/"+args[0], body)
+		},
+	}
+	cmd.Flags().StringVar(&data, "data", "", "Event data as a JSON object")
+	return cmd
+}
+
+func triggerDeviceCmd() *cobra.Command {
+	var value, data string
+	cmd := &cobra.Command{
+		Use:   "device <id> <attribute>",
+		Short: "Fire a state_change event as if the device reported a new value",
+		Long: `Fire the state_change event a device message would route: the device's
+cached state merged with the new value (and --data). The cached state
+itself is not changed.
+
+--value is parsed as JSON when possible (23.5, true), else taken as text (ON).`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			body := api.DeviceTrigger{Device: args[0], Attribute: args[1], Value: parseValue(value)}
+			if data != "" {
+				if err := json.Unmarshal([]byte(data), &body.Data); err != nil {
+					logger.Critical("Invalid --data (expected a JSON object): %v", err)
+					os.Exit(1)
+				}
+			}
+			runTrigger("/trigger/device", body)
+		},
+	}
+	cmd.Flags().StringVar(&value, "value", "", "New value of the attribute")
+	cmd.Flags().StringVar(&data, "data", "", "Other attributes in the same message, as a JSON object")
+	_ = cmd.MarkFlagRequired("value")
+	return cmd
+}
+
+// parseValue reads a command line value as JSON, falling back to text
+func parseValue(s string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return s
+	}
+	return value
+}
+
+// runTrigger posts a simulated event and prints what was routed
+func runTrigger(path string, body interface{}) {
+	var result struct {
+		Event string                 `json:"event"`
+		Data  map[string]interface{} `json:"data"`
+	}
+	if err := postJSON(path, body, &result); err != nil {
+		logger.Critical("Trigger error: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Routed %s\n", result.Event)
+	if result.Data != nil {
+		data, _ := json.MarshalIndent(result.Data, "", "  ")
+		fmt.Printf("Event data: %s\n", data)
+	}
+}
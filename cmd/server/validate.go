@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"homescript-server/internal/reload"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func validateCmd() *cobra.Command {
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check devices.yaml, server.yaml and all scripts before a restart",
+		Long: `Check the configuration directory without starting the server: parse
+devices.yaml (with templates) and server.yaml, check the syntax of every MQTT
+topic, compile every Lua script in events/, lib/ and services/, parse every
+conditions.yaml, params.yaml and scene, and check that each directory below
+events/device/ and events/area/ names a known device, attribute or area.
+
+Errors make the command exit with status 1. Handler directories nothing
+routes to are warnings, since devices added at runtime (Home Assistant
+entities) aren't in devices.yaml; --strict fails on them too.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			result := reload.Validate(configPath, loadDeviceConfig)
+			for _, err := range result.Errors {
+				fmt.Printf("ERROR    %s\n", err)
+			}
+			for _, warning := range result.Warnings {
+				fmt.Printf("WARNING  %s\n", warning)
+			}
+			if len(result.Errors)+len(result.Warnings) > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Checked %d device(s) and %d file(s): %d error(s), %d warning(s)\n",
+				result.Devices, result.Files, len(result.Errors), len(result.Warnings))
+			if len(result.Errors) > 0 || (strict && len(result.Warnings) > 0) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&strict, "strict", false, "Exit with status 1 on warnings too")
+	return cmd
+}
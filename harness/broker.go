@@ -0,0 +1,240 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Message is an MQTT message that passed through the in-memory broker
+type Message struct {
+	Topic    string
+	Payload  []byte
+	Retained bool
+}
+
+type subscription struct {
+	filter  string
+	handler mqtt.MessageHandler
+}
+
+// Broker is an in-memory MQTT broker with one client, the server under test.
+// Deliveries are queued instead of run right away, so a test decides when
+// handlers run and they always run in publish order.
+type Broker struct {
+	mu        sync.Mutex
+	subs      []subscription
+	retained  map[string][]byte
+	published []Message
+	deliver   func(func())
+	onPublish func(Message)
+}
+
+func newBroker(deliver func(func())) *Broker {
+	return &Broker{retained: make(map[string][]byte), deliver: deliver}
+}
+
+// Client returns the broker connection handed to the server
+func (b *Broker) Client() mqtt.Client {
+	return &brokerClient{broker: b}
+}
+
+// Inject delivers a message as if a device or another client published it
+func (b *Broker) Inject(topic string, payload []byte, retained bool) {
+	b.mu.Lock()
+	if retained {
+		b.retained[topic] = payload
+	}
+	subs := b.matching(topic)
+	b.mu.Unlock()
+
+	b.dispatch(subs, topic, payload, retained)
+}
+
+// Published returns everything the server published, in order
+func (b *Broker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.published...)
+}
+
+// matching returns subscriptions whose filter matches topic (b.mu must be held)
+func (b *Broker) matching(topic string) []subscription {
+	var subs []subscription
+	for _, sub := range b.subs {
+		if matchTopic(sub.filter, topic) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+func (b *Broker) dispatch(subs []subscription, topic string, payload []byte, retained bool) {
+	msg := &message{topic: topic, payload: payload, retained: retained}
+	client := b.Client()
+	for _, sub := range subs {
+		handler := sub.handler
+		b.deliver(func() { handler(client, msg) })
+	}
+}
+
+// matchTopic reports whether an MQTT topic filter (with + and #) matches topic
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(filter, "$share/") {
+		if parts := strings.SplitN(filter, "/", 3); len(parts) == 3 {
+			filter = parts[2]
+		}
+	}
+
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// brokerClient implements mqtt.Client on top of the in-memory broker
+type brokerClient struct {
+	broker *Broker
+}
+
+func (c *brokerClient) IsConnected() bool      { return true }
+func (c *brokerClient) IsConnectionOpen() bool { return true }
+func (c *brokerClient) Connect() mqtt.Token    { return &doneToken{} }
+func (c *brokerClient) Disconnect(uint)        {}
+
+func (c *brokerClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	var data []byte
+	switch v := payload.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case bytes.Buffer:
+		data = v.Bytes()
+	case *bytes.Buffer:
+		data = v.Bytes()
+	default:
+		return &doneToken{err: fmt.Errorf("unknown payload type %T", payload)}
+	}
+
+	b := c.broker
+	msg := Message{Topic: topic, Payload: data, Retained: retained}
+	b.mu.Lock()
+	b.published = append(b.published, msg)
+	if retained {
+		b.retained[topic] = data
+	}
+	subs := b.matching(topic)
+	onPublish := b.onPublish
+	b.mu.Unlock()
+
+	b.dispatch(subs, topic, data, retained)
+	if onPublish != nil {
+		onPublish(msg)
+	}
+	return &doneToken{}
+}
+
+func (c *brokerClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *brokerClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	b := c.broker
+	b.mu.Lock()
+	type retainedMessage struct {
+		topic   string
+		payload []byte
+	}
+	var replay []retainedMessage
+	for filter := range filters {
+		b.subs = append(b.subs, subscription{filter: filter, handler: callback})
+		for topic, payload := range b.retained {
+			if matchTopic(filter, topic) {
+				replay = append(replay, retainedMessage{topic, payload})
+			}
+		}
+	}
+	b.mu.Unlock()
+	sort.Slice(replay, func(i, j int) bool { return replay[i].topic < replay[j].topic })
+
+	// Like a real broker, new subscriptions receive matching retained messages
+	for _, r := range replay {
+		b.dispatch([]subscription{{handler: callback}}, r.topic, r.payload, true)
+	}
+	return &doneToken{}
+}
+
+func (c *brokerClient) Unsubscribe(topics ...string) mqtt.Token {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	remove := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		remove[topic] = true
+	}
+	kept := b.subs[:0]
+	for _, sub := range b.subs {
+		if !remove[sub.filter] {
+			kept = append(kept, sub)
+		}
+	}
+	b.subs = kept
+	return &doneToken{}
+}
+
+func (c *brokerClient) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.broker.subs = append(c.broker.subs, subscription{filter: topic, handler: callback})
+}
+
+func (c *brokerClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.ClientOptionsReader{}
+}
+
+// doneToken is an already completed token
+type doneToken struct {
+	err error
+}
+
+func (t *doneToken) Wait() bool                     { return true }
+func (t *doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t *doneToken) Error() error                   { return t.err }
+
+func (t *doneToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// message implements mqtt.Message
+type message struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return 0 }
+func (m *message) Retained() bool    { return m.retained }
+func (m *message) Topic() string     { return m.topic }
+func (m *message) MessageID() uint16 { return 0 }
+func (m *message) Payload() []byte   { return m.payload }
+func (m *message) Ack()              {}
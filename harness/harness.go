@@ -0,0 +1,392 @@
+// Package harness runs a configuration directory (devices, handlers, scenes)
+// against an in-memory MQTT broker, temporary storage and a manual clock, so
+// automations can be tested in CI before they reach the house. Everything
+// runs on the caller's goroutine in a fixed order: the same inputs and seed
+// give the same results.
+//
+//	h, err := harness.New(harness.Options{ConfigPath: "./config"})
+//	if err != nil { ... }
+//	defer h.Close()
+//	h.Publish("zigbee2mqtt/hall_motion", `{"occupancy": true}`)
+//	h.Advance(5 * time.Minute)
+//	msgs := h.PublishedTo("zigbee2mqtt/hall_light/set")
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/effects"
+	"homescript-server/internal/events"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/history"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/scenes"
+	"homescript-server/internal/scheduler"
+	"homescript-server/internal/storage"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxSteps bounds the work queue drained after one input; handlers that keep
+// triggering each other are reported instead of hanging the test
+const maxSteps = 10000
+
+// Options configure a harness
+type Options struct {
+	// ConfigPath is the configuration directory under test (devices/, events/, scenes/, lib/)
+	ConfigPath string
+	// Start is the initial time of the clock (default 2024-01-01 12:00 in Location)
+	Start time.Time
+	// Location for time events (default time.Local)
+	Location *time.Location
+	// Latitude and Longitude place sunrise and sunset
+	Latitude  float64
+	Longitude float64
+	// Seed seeds math.random in scripts
+	Seed int64
+	// Echo makes devices report commanded values back on their state topic,
+	// as Zigbee2MQTT does
+	Echo bool
+}
+
+// Run is one script execution
+type Run struct {
+	// Script is relative to the events directory, e.g. "device/porch/state/on_change.lua"
+	Script string
+	Event  *types.Event
+	Err    error
+}
+
+// Harness is a complete server pipeline without network, disk state or wall clock
+type Harness struct {
+	opts    Options
+	broker  *Broker
+	clock   *scheduler.Clock
+	store   *storage.Storage
+	tempDir string
+	devices *devices.Manager
+	exec    *executor.Executor
+	router  *events.Router
+	sched   *scheduler.Scheduler
+
+	mu    sync.Mutex
+	queue []func()
+	runs  []Run
+}
+
+// New loads the configuration and wires the pipeline
+func New(opts Options) (*Harness, error) {
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.Start.IsZero() {
+		opts.Start = time.Date(2024, 1, 1, 12, 0, 0, 0, opts.Location)
+	}
+
+	deviceConfig, err := config.LoadDevicesYAML(filepath.Join(opts.ConfigPath, "devices", "devices.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	templates, err := devices.LoadTemplates(filepath.Join(opts.ConfigPath, "devices", "templates"))
+	if err != nil {
+		return nil, err
+	}
+	if err := devices.ApplyTemplates(deviceConfig.Devices, templates); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "homescript-harness-")
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.New(filepath.Join(tempDir, "state.db"))
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, err
+	}
+
+	h := &Harness{
+		opts:    opts,
+		clock:   scheduler.NewManualClock(opts.Start),
+		store:   store,
+		tempDir: tempDir,
+	}
+	h.broker = newBroker(h.enqueue)
+	if opts.Echo {
+		h.broker.onPublish = h.echo
+	}
+
+	h.devices = devices.New(h.broker.Client(), deviceConfig.Devices)
+	h.devices.SetVirtualDevices(deviceConfig.Virtual)
+	h.devices.SetGroups(deviceConfig.Groups)
+
+	haConfigs, err := config.LoadHAConfigs(filepath.Join(opts.ConfigPath, "devices", "ha_configs.json"))
+	if err == nil {
+		for deviceID, haConfig := range haConfigs {
+			h.devices.GetHAManager().RegisterDevice(deviceID, haConfig)
+		}
+	}
+
+	h.exec = executor.New(store, h.devices, opts.ConfigPath)
+	h.exec.SetScriptBudget(0)
+	h.exec.SetRandomSeed(opts.Seed)
+	h.exec.SetJobDispatcher(h.enqueue)
+	h.exec.SetServiceDispatcher(h.enqueue)
+
+	h.router = events.New(opts.ConfigPath, inlinePool{h})
+	h.router.SetDeviceStates(h.devices)
+	h.router.SetAliases(h.devices)
+	h.router.SetSubscriber(h.exec)
+	h.exec.SetRouter(h.router)
+	h.devices.SetRouter(h.router)
+
+	sceneEngine := scenes.New(filepath.Join(opts.ConfigPath, "scenes"), h.devices)
+	h.router.SetScenes(sceneEngine)
+	h.exec.SetScenes(sceneEngine)
+
+	eventHistory := history.New(50)
+	h.router.SetHistory(eventHistory)
+	h.exec.SetHistory(eventHistory)
+
+	h.sched = scheduler.New(h.router, scheduler.Config{
+		Location:  opts.Location,
+		Latitude:  opts.Latitude,
+		Longitude: opts.Longitude,
+		Clock:     h.clock,
+	})
+	h.exec.SetScheduler(h.sched)
+	h.sched.SetExecutor(h.exec)
+	h.exec.SetEffects(effects.New(h.devices, store, h.sched))
+	h.exec.StartServices()
+
+	client := mqtt.NewClientFromConnection(h.broker.Client(), h.router, h.devices)
+	h.exec.SetPublisher(client)
+	if err := client.SubscribeToDevices(); err != nil {
+		h.Close()
+		return nil, err
+	}
+	serverConfig, err := config.LoadServerConfig(filepath.Join(opts.ConfigPath, "server.yaml"))
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	for _, sub := range serverConfig.Subscriptions {
+		if err := client.Subscribe(mqtt.Subscription(sub)); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+	if serverConfig.HomeAssistant.Discovery {
+		if err := client.SubscribeHADiscovery(); err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+	h.settle()
+
+	return h, nil
+}
+
+// Close releases the temporary storage
+func (h *Harness) Close() error {
+	h.exec.StopServices()
+	h.router.Close()
+	err := h.store.Close()
+	os.RemoveAll(h.tempDir)
+	return err
+}
+
+// inlinePool runs routed scripts through the harness queue instead of workers
+type inlinePool struct {
+	h *Harness
+}
+
+func (p inlinePool) Submit(task executor.Task) {
+	p.h.enqueue(func() {
+		err := p.h.exec.Execute(task.ScriptPath, task.Event)
+		script, relErr := filepath.Rel(filepath.Join(p.h.opts.ConfigPath, "events"), task.ScriptPath)
+		if relErr != nil {
+			script = task.ScriptPath
+		}
+		p.h.mu.Lock()
+		p.h.runs = append(p.h.runs, Run{Script: filepath.ToSlash(script), Event: task.Event, Err: err})
+		p.h.mu.Unlock()
+	})
+}
+
+func (h *Harness) enqueue(work func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.queue = append(h.queue, work)
+}
+
+// settle runs queued deliveries and scripts, including everything they
+// trigger, until nothing is left
+func (h *Harness) settle() {
+	for steps := 0; ; steps++ {
+		h.mu.Lock()
+		if len(h.queue) == 0 {
+			h.mu.Unlock()
+			return
+		}
+		if steps >= maxSteps {
+			h.queue = nil
+			h.runs = append(h.runs, Run{Err: fmt.Errorf("still busy after %d steps, handlers probably trigger each other in a loop", maxSteps)})
+			h.mu.Unlock()
+			return
+		}
+		work := h.queue[0]
+		h.queue = h.queue[1:]
+		h.mu.Unlock()
+
+		work()
+	}
+}
+
+// echo reports a command back on the device's state topic
+func (h *Harness) echo(msg Message) {
+	for _, dev := range h.devices.ListDevices() {
+		if dev.MQTT.CommandTopic != msg.Topic || dev.MQTT.StateTopic == "" {
+			continue
+		}
+		var attrs map[string]interface{}
+		if json.Unmarshal(msg.Payload, &attrs) != nil {
+			return
+		}
+		h.broker.Inject(dev.MQTT.StateTopic, msg.Payload, false)
+		return
+	}
+}
+
+// Publish delivers an MQTT message as if a device sent it and runs every
+// handler it triggers. payload is sent as is if it is a string or []byte,
+// otherwise as JSON.
+func (h *Harness) Publish(topic string, payload interface{}) error {
+	var data []byte
+	switch v := payload.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	h.broker.Inject(topic, data, false)
+	h.settle()
+	return nil
+}
+
+// Emit routes a custom event and runs its handlers
+func (h *Harness) Emit(eventType string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	h.router.RouteEvent(&types.Event{
+		Source:    "custom",
+		Type:      eventType,
+		Data:      data,
+		Timestamp: h.clock.Now(),
+	})
+	h.settle()
+}
+
+// RunScript runs one handler script (relative to the events directory) with
+// event, as if the router had picked it, and every handler it triggers.
+// Conditions and other scripts of the directory are skipped.
+func (h *Harness) RunScript(script string, event *types.Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = h.clock.Now()
+	}
+	inlinePool{h}.Submit(executor.Task{
+		ScriptPath: filepath.Join(h.opts.ConfigPath, "events", filepath.FromSlash(script)),
+		Event:      event,
+	})
+	h.settle()
+}
+
+// Advance moves the clock forward second by second, firing time events and
+// timers on the way
+func (h *Harness) Advance(d time.Duration) {
+	end := h.clock.Now().Add(d)
+	for t := h.clock.Now().Add(time.Second); !t.After(end); t = t.Add(time.Second) {
+		h.clock.Set(t)
+		h.sched.Tick()
+		h.settle()
+	}
+	h.clock.Set(end)
+}
+
+// Use adds event middleware (see package pipeline), run in the order added
+// before events are dispatched to scripts
+func (h *Harness) Use(middleware ...events.Middleware) {
+	h.router.Use(middleware...)
+}
+
+// Now returns the harness clock
+func (h *Harness) Now() time.Time {
+	return h.clock.Now()
+}
+
+// SetDeviceState seeds the cached state of a device without routing events
+func (h *Harness) SetDeviceState(id string, state map[string]interface{}) {
+	h.devices.UpdateState(id, state)
+	h.settle()
+}
+
+// DeviceState returns the cached state of a device
+func (h *Harness) DeviceState(id string) (map[string]interface{}, error) {
+	return h.devices.Get(id)
+}
+
+// SetState stores a persistent state value (state.get in scripts)
+func (h *Harness) SetState(key string, value interface{}) error {
+	return h.store.Set(key, value)
+}
+
+// State returns a persistent state value; ok is false if the key is unset
+func (h *Harness) State(key string) (value interface{}, ok bool) {
+	value, err := h.store.Get(key)
+	return value, err == nil
+}
+
+// Published returns every message the server published, in order
+func (h *Harness) Published() []Message {
+	return h.broker.Published()
+}
+
+// PublishedTo returns the messages the server published on topic
+func (h *Harness) PublishedTo(topic string) []Message {
+	var msgs []Message
+	for _, msg := range h.broker.Published() {
+		if msg.Topic == topic {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Runs returns every script execution so far, in order
+func (h *Harness) Runs() []Run {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Run(nil), h.runs...)
+}
+
+// Errors returns the errors of failed script executions
+func (h *Harness) Errors() []error {
+	var errs []error
+	for _, run := range h.Runs() {
+		if run.Err != nil {
+			errs = append(errs, run.Err)
+		}
+	}
+	return errs
+}
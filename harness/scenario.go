@@ -0,0 +1,332 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/scheduler"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is a sequence of inputs and expectations, kept as YAML next to
+// the configuration and run in CI with "homescript-server scenario"
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Start is the initial clock time, e.g. "2024-06-21T20:55"
+	Start     string  `yaml:"start,omitempty"`
+	Latitude  float64 `yaml:"latitude,omitempty"`
+	Longitude float64 `yaml:"longitude,omitempty"`
+	Seed      int64   `yaml:"seed,omitempty"`
+	// Echo makes devices confirm commands on their state topic
+	Echo  bool    `yaml:"echo,omitempty"`
+	Steps []*Step `yaml:"steps"`
+}
+
+// Step is one scenario step; exactly one field is set
+type Step struct {
+	// Devices seeds cached device states without routing events
+	Devices map[string]map[string]interface{} `yaml:"devices,omitempty"`
+	// State seeds persistent state keys
+	State map[string]interface{} `yaml:"state,omitempty"`
+	// MQTT publishes a message as if a device sent it
+	MQTT *MQTTStep `yaml:"mqtt,omitempty"`
+	// Emit routes a custom event
+	Emit *EmitStep `yaml:"emit,omitempty"`
+	// Advance moves the clock, e.g. "90s" or "2h"
+	Advance time.Duration `yaml:"advance,omitempty"`
+	// Expect checks what happened since the previous expect step
+	Expect *Expect `yaml:"expect,omitempty"`
+}
+
+// MQTTStep is an incoming MQTT message
+type MQTTStep struct {
+	Topic string `yaml:"topic"`
+	// Payload is a string sent as is, or a map sent as JSON
+	Payload interface{} `yaml:"payload"`
+}
+
+// EmitStep is a custom event
+type EmitStep struct {
+	Type string                 `yaml:"type"`
+	Data map[string]interface{} `yaml:"data,omitempty"`
+}
+
+// Expect lists conditions checked at a point of a scenario
+type Expect struct {
+	// Published messages, in order; a map payload matches JSON containing its keys
+	Published []*MQTTStep `yaml:"published,omitempty"`
+	// NotPublished topics must not have been published to
+	NotPublished []string `yaml:"not_published,omitempty"`
+	// Devices maps device IDs to expected cached attribute values
+	Devices map[string]map[string]interface{} `yaml:"devices,omitempty"`
+	// State maps persistent keys to expected values (null = unset)
+	State map[string]interface{} `yaml:"state,omitempty"`
+	// Ran lists scripts (relative to events/) that must have run
+	Ran []string `yaml:"ran,omitempty"`
+	// NotRan lists scripts that must not have run
+	NotRan []string `yaml:"not_ran,omitempty"`
+}
+
+// LoadScenarios reads scenarios from YAML files and directories of them
+func LoadScenarios(paths []string) ([]*Scenario, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+	sort.Strings(files)
+
+	var scenarios []*Scenario
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		sc := &Scenario{}
+		if err := yaml.Unmarshal(data, sc); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if sc.Name == "" {
+			sc.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		scenarios = append(scenarios, sc)
+	}
+	return scenarios, nil
+}
+
+// Run plays the scenario against configPath and returns the failed
+// expectations and script errors (none if it passed)
+func (sc *Scenario) Run(configPath string) ([]string, error) {
+	opts := Options{
+		ConfigPath: configPath,
+		Latitude:   sc.Latitude,
+		Longitude:  sc.Longitude,
+		Seed:       sc.Seed,
+		Echo:       sc.Echo,
+	}
+	if sc.Start != "" {
+		start, err := scheduler.ParseSimulatedTime(sc.Start, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		opts.Start = start
+	}
+
+	h, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Close()
+
+	var failures []string
+	published, runs := 0, 0
+	for i, step := range sc.Steps {
+		prefix := fmt.Sprintf("step %d", i+1)
+		switch {
+		case step.Devices != nil:
+			for _, id := range sortedKeys(step.Devices) {
+				h.SetDeviceState(id, step.Devices[id])
+			}
+		case step.State != nil:
+			for _, key := range sortedKeys(step.State) {
+				if err := h.SetState(key, step.State[key]); err != nil {
+					return nil, fmt.Errorf("%s: %w", prefix, err)
+				}
+			}
+		case step.MQTT != nil:
+			payload := step.MQTT.Payload
+			if payload == nil {
+				payload = ""
+			}
+			if err := h.Publish(step.MQTT.Topic, payload); err != nil {
+				return nil, fmt.Errorf("%s: %w", prefix, err)
+			}
+		case step.Emit != nil:
+			h.Emit(step.Emit.Type, step.Emit.Data)
+		case step.Advance > 0:
+			h.Advance(step.Advance)
+		case step.Expect != nil:
+			msgs := h.Published()[published:]
+			ran := h.Runs()[runs:]
+			for _, f := range step.Expect.check(h, msgs, ran) {
+				failures = append(failures, prefix+": "+f)
+			}
+			published, runs = len(h.Published()), len(h.Runs())
+		default:
+			return nil, fmt.Errorf("%s: empty step", prefix)
+		}
+	}
+
+	for _, run := range h.Runs() {
+		if run.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", run.Script, run.Err))
+		}
+	}
+	return failures, nil
+}
+
+// check returns the unmet conditions given messages and runs since the last check
+func (e *Expect) check(h *Harness, msgs []Message, runs []Run) []string {
+	var failures []string
+
+	next := 0
+	for _, want := range e.Published {
+		found := false
+		for ; next < len(msgs); next++ {
+			if msgs[next].Topic == want.Topic && payloadMatches(want.Payload, msgs[next].Payload) {
+				found = true
+				next++
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("expected publish to %s %s", want.Topic, describe(want.Payload)))
+		}
+	}
+
+	for _, topic := range e.NotPublished {
+		for _, msg := range msgs {
+			if msg.Topic == topic {
+				failures = append(failures, fmt.Sprintf("unexpected publish to %s: %s", topic, msg.Payload))
+				break
+			}
+		}
+	}
+
+	for _, id := range sortedKeys(e.Devices) {
+		state, err := h.DeviceState(id)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		for attr, want := range e.Devices[id] {
+			if got := state[attr]; !valueMatches(want, got) {
+				failures = append(failures, fmt.Sprintf("device %s: %s is %v, expected %v", id, attr, got, want))
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(e.State) {
+		want := e.State[key]
+		got, ok := h.State(key)
+		if want == nil {
+			if ok {
+				failures = append(failures, fmt.Sprintf("state %s is %v, expected unset", key, got))
+			}
+			continue
+		}
+		if !ok || !valueMatches(want, got) {
+			failures = append(failures, fmt.Sprintf("state %s is %v, expected %v", key, got, want))
+		}
+	}
+
+	ran := make(map[string]bool, len(runs))
+	for _, run := range runs {
+		ran[run.Script] = true
+	}
+	for _, script := range e.Ran {
+		if !ran[script] {
+			failures = append(failures, "expected "+script+" to run")
+		}
+	}
+	for _, script := range e.NotRan {
+		if ran[script] {
+			failures = append(failures, "expected "+script+" not to run")
+		}
+	}
+
+	return failures
+}
+
+// payloadMatches compares an expected payload with a published one; a map
+// matches a JSON object containing at least its keys, nil matches anything
+func payloadMatches(want interface{}, payload []byte) bool {
+	if want == nil {
+		return true
+	}
+	if _, isMap := want.(map[string]interface{}); isMap {
+		var got interface{}
+		if json.Unmarshal(payload, &got) != nil {
+			return false
+		}
+		return valueMatches(want, got)
+	}
+	return valueMatches(want, string(payload))
+}
+
+// valueMatches compares a YAML value with a runtime one: maps by their
+// expected keys, numbers numerically, anything else by its text
+func valueMatches(want, got interface{}) bool {
+	if wantMap, ok := want.(map[string]interface{}); ok {
+		gotMap, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range wantMap {
+			if !valueMatches(v, gotMap[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	if w, ok := toFloat(want); ok {
+		if g, ok := toFloat(got); ok {
+			return w == g
+		}
+	}
+	return fmt.Sprint(want) == fmt.Sprint(got)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func describe(payload interface{}) string {
+	if payload == nil {
+		return ""
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Sprint(payload)
+	}
+	return string(data)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
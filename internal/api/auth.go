@@ -0,0 +1,133 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"homescript-server/internal/config"
+	"net/http"
+)
+
+// Scopes of API credentials
+const (
+	// ScopeRead allows the GET endpoints of the API
+	ScopeRead = "read"
+	// ScopeControl allows everything: setting devices and state, running
+	// scripts and posting events
+	ScopeControl = "control"
+	// ScopeEvents allows POST /api/events only
+	ScopeEvents = "events"
+)
+
+// credential is an API token or a basic auth user with its scope
+type credential struct {
+	name     string
+	token    string
+	username string
+	password string
+	scope    string
+}
+
+// APIAuth checks API requests for a bearer token (or ?token=) or basic auth
+// credentials with the scope of the endpoint
+type APIAuth struct {
+	credentials []credential
+	basic       bool // users are configured; 401s ask browsers for a login
+}
+
+// NewAPIAuth collects the credentials of the api section: token (control),
+// events_token (events) and the scoped tokens and users
+func NewAPIAuth(cfg config.APIConfig) (*APIAuth, error) {
+	a := &APIAuth{}
+	if cfg.Token != "" {
+		a.credentials = append(a.credentials, credential{name: "token", token: cfg.Token, scope: ScopeControl})
+	}
+	if cfg.EventsToken != "" {
+		a.credentials = append(a.credentials, credential{name: "events_token", token: cfg.EventsToken, scope: ScopeEvents})
+	}
+	for i, t := range cfg.Tokens {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("tokens[%d]", i)
+		}
+		if t.Token == "" {
+			return nil, fmt.Errorf("api token %s: token is empty", name)
+		}
+		scope, err := credentialScope(t.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("api token %s: %w", name, err)
+		}
+		a.credentials = append(a.credentials, credential{name: name, token: t.Token, scope: scope})
+	}
+	for _, u := range cfg.Users {
+		if u.Username == "" || u.Password == "" {
+			return nil, fmt.Errorf("api user %q: username and password are required", u.Username)
+		}
+		scope, err := credentialScope(u.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("api user %s: %w", u.Username, err)
+		}
+		a.credentials = append(a.credentials, credential{name: u.Username, username: u.Username, password: u.Password, scope: scope})
+		a.basic = true
+	}
+	return a, nil
+}
+
+// credentialScope validates a configured scope; read if empty
+func credentialScope(scope string) (string, error) {
+	switch scope {
+	case "":
+		return ScopeRead, nil
+	case ScopeRead, ScopeControl, ScopeEvents:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown scope %q (read, control or events)", scope)
+}
+
+// Enabled reports whether any credentials are configured; the API is
+// disabled without them
+func (a *APIAuth) Enabled() bool {
+	return a != nil && len(a.credentials) > 0
+}
+
+// identify returns the credential a request carries, or nil
+func (a *APIAuth) identify(r *http.Request) *credential {
+	token := requestToken(r)
+	username, password, basic := r.BasicAuth()
+	for i := range a.credentials {
+		c := &a.credentials[i]
+		if c.token != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1 {
+			return c
+		}
+		if c.username != "" && basic && subtle.ConstantTimeCompare([]byte(username), []byte(c.username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(c.password)) == 1 {
+			return c
+		}
+	}
+	return nil
+}
+
+// allows reports whether a credential of scope have may use an endpoint of
+// scope want; control includes the others
+func allows(have, want string) bool {
+	return have == want || have == ScopeControl
+}
+
+// require wraps an endpoint that needs a credential with scope
+func (a *APIAuth) require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := a.identify(r)
+		if c == nil {
+			if a.basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="homescript"`)
+			}
+			writeError(w, http.StatusUnauthorized, "invalid API credentials")
+			return
+		}
+		if !allows(c.scope, scope) {
+			log.Warn("API: %s (%s scope) refused %s %s", c.name, c.scope, r.Method, r.URL.Path)
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s scope required", scope))
+			return
+		}
+		next(w, r)
+	}
+}
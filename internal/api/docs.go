@@ -0,0 +1,75 @@
+package api
+
+import (
+	"homescript-server/internal/executor"
+	"html/template"
+	"net/http"
+)
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lua API Reference</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; line-height: 1.4; }
+pre { background: #f4f4f4; padding: .5em; }
+h3 code { font-size: 1.1em; }
+.doc { white-space: pre-line; }
+</style>
+</head>
+<body>
+<h1>Lua API Reference</h1>
+<p>Generated from the server source (API version {{.Version}}). <a href="/docs/lua.md">Markdown</a></p>
+{{range .Functions}}
+{{if .Heading}}<h2>{{.Heading}}</h2>{{end}}
+<h3 id="{{.QualifiedName}}"><code>{{.QualifiedName}}</code></h3>
+{{if .Doc}}<p class="doc">{{.Doc}}</p>{{end}}
+{{if .Usage}}<pre>{{range .Usage}}{{.}}
+{{end}}</pre>{{end}}
+{{if .Returns}}<p>Returns: {{.Returns}}</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// docsFunction adds the section heading to the first function of each table
+type docsFunction struct {
+	executor.APIFunction
+	Heading string
+}
+
+// RegisterDocs serves the generated Lua API reference at /docs/lua (HTML)
+// and /docs/lua.md (Markdown). The reference contains no home data, so it
+// is not protected by a token.
+func RegisterDocs(s *Server) {
+	s.HandleFunc("GET /docs/lua", func(w http.ResponseWriter, r *http.Request) {
+		var functions []docsFunction
+		table := "-"
+		for _, f := range executor.APIReference() {
+			entry := docsFunction{APIFunction: f}
+			if f.Table != table {
+				table = f.Table
+				entry.Heading = table
+				if table == "" {
+					entry.Heading = "Globals"
+				}
+			}
+			functions = append(functions, entry)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := docsTemplate.Execute(w, map[string]interface{}{
+			"Version":   executor.CurrentAPIVersion,
+			"Functions": functions,
+		})
+		if err != nil {
+			log.Debug("Failed to render API reference: %v", err)
+		}
+	})
+	s.HandleFunc("GET /docs/lua.md", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(executor.APIReferenceMarkdown()))
+	})
+	log.Info("Lua API reference at /docs/lua")
+}
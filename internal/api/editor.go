@@ -0,0 +1,376 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/events"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/types"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScriptRunner executes a handler script with an event (implemented by executor.Executor)
+type ScriptRunner interface {
+	Execute(scriptPath string, event *types.Event) error
+}
+
+// Editor serves a browser editor for handler scripts below config/events
+type Editor struct {
+	token    string
+	runner   ScriptRunner
+	basePath string
+}
+
+// editorRequest is the JSON body of check, run and save requests
+type editorRequest struct {
+	Path   string                 `json:"path"`
+	Source string                 `json:"source"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// RegisterEditor registers the handler editor (/editor) using the wizard token
+func RegisterEditor(s *Server, cfg config.WizardConfig, runner ScriptRunner, basePath string) {
+	if cfg.Token == "" {
+		return // RegisterWizard already warned
+	}
+
+	ed := &Editor{token: cfg.Token, runner: runner, basePath: basePath}
+	s.HandleFunc("GET /editor", ed.handleList)
+	s.HandleFunc("POST /editor/login", ed.handleLogin)
+	s.HandleFunc("GET /editor/edit", ed.authorize(ed.handleEdit))
+	s.HandleFunc("POST /editor/check", ed.authorize(ed.handleCheck))
+	s.HandleFunc("POST /editor/run", ed.authorize(ed.handleRun))
+	s.HandleFunc("POST /editor/save", ed.authorize(ed.handleSave))
+	log.Info("Script editor enabled at /editor")
+}
+
+// wizardCookie keeps the wizard token in the browser after logging in to the
+// editor, so its links don't need the token in the URL
+const wizardCookie = "homescript_wizard"
+
+// sessionToken reads the token of an editor request from the Authorization
+// header or the login cookie, never the URL, which ends up in browser
+// history, Referer headers and access logs
+func sessionToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && auth[:7] == "Bearer " {
+		return auth[7:]
+	}
+	if cookie, err := r.Cookie(wizardCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (ed *Editor) valid(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(ed.token)) == 1
+}
+
+func (ed *Editor) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ed.valid(sessionToken(r)) {
+			writeError(w, http.StatusUnauthorized, "invalid editor token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+var editorLoginPage = template.Must(template.New("editor-login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Handlers</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+.error { background: #fdd; padding: 0.6em; border-radius: 8px; }
+</style>
+</head>
+<body>
+<h1>Handlers</h1>
+{{if .Failed}}<p class="error">Invalid token.</p>{{end}}
+<form method="post" action="/editor/login">
+<label>Wizard token <input type="password" name="token" required autofocus></label>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// handleLogin checks the token of the login form and keeps it in a cookie
+// for the editor and wizard pages
+func (ed *Editor) handleLogin(w http.ResponseWriter, r *http.Request) {
+	token := formToken(r)
+	if !ed.valid(token) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		if err := editorLoginPage.Execute(w, map[string]interface{}{"Failed": true}); err != nil {
+			log.Debug("Failed to render editor login: %v", err)
+		}
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     wizardCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/editor", http.StatusSeeOther)
+}
+
+// eventsDir is where editable handlers live
+func (ed *Editor) eventsDir() string {
+	return filepath.Join(ed.basePath, "events")
+}
+
+// resolve turns a path relative to config/events into a file path, refusing
+// anything that is not a .lua file inside it
+func (ed *Editor) resolve(rel string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if rel == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid script path: %s", rel)
+	}
+	if !strings.HasSuffix(clean, ".lua") {
+		return "", fmt.Errorf("not a Lua script: %s", rel)
+	}
+	return filepath.Join(ed.eventsDir(), clean), nil
+}
+
+func (ed *Editor) decode(w http.ResponseWriter, r *http.Request) (*editorRequest, string, bool) {
+	var req editorRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return nil, "", false
+	}
+	path, err := ed.resolve(req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, "", false
+	}
+	return &req, path, true
+}
+
+var editorListPage = template.Must(template.New("editor-list").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Handlers</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+li { margin: 0.2em 0; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Handlers</h1>
+<p><a href="/wizard">New automation</a></p>
+<ul>
+{{range .Scripts}}<li><a href="/editor/edit?path={{.}}">{{.}}</a></li>
+{{else}}<li>No handlers yet.</li>
+{{end}}
+</ul>
+</body>
+</html>
+`))
+
+// handleList lists the handlers, or asks for the token without a valid one
+func (ed *Editor) handleList(w http.ResponseWriter, r *http.Request) {
+	if !ed.valid(sessionToken(r)) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := editorLoginPage.Execute(w, map[string]interface{}{"Failed": false}); err != nil {
+			log.Debug("Failed to render editor login: %v", err)
+		}
+		return
+	}
+
+	var scripts []string
+	root := ed.eventsDir()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".lua") {
+			rel, relErr := filepath.Rel(root, path)
+			if relErr == nil {
+				scripts = append(scripts, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := map[string]interface{}{"Scripts": scripts}
+	if err := editorListPage.Execute(w, data); err != nil {
+		log.Debug("Failed to render editor list: %v", err)
+	}
+}
+
+var editorPage = template.Must(template.New("editor").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Path}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 1em auto; padding: 0 1em; }
+textarea { width: 100%; font-family: monospace; font-size: 0.95em; }
+#source { height: 28em; }
+#data { height: 5em; }
+#result { white-space: pre-wrap; padding: 0.6em; border-radius: 8px; background: #f4f4f4; }
+.error { background: #fdd !important; }
+.ok { background: #dfd !important; }
+</style>
+</head>
+<body>
+<p><a href="/editor">All handlers</a></p>
+<h1><code>{{.Path}}</code></h1>
+<textarea id="source" spellcheck="false">{{.Source}}</textarea>
+<p>
+<button onclick="send('check')">Check syntax</button>
+<button onclick="send('save')">Save</button>
+</p>
+<h3>Test with sample event</h3>
+<p>Event data (JSON), merged into the sample event for this handler. The script runs for real: device commands are sent.</p>
+<textarea id="data" spellcheck="false">{}</textarea>
+<p><button onclick="send('run')">Run</button></p>
+<div id="result"></div>
+<script>
+async function send(op) {
+  const result = document.getElementById("result");
+  let data = {};
+  try { data = JSON.parse(document.getElementById("data").value || "{}"); }
+  catch (e) { result.className = "error"; result.textContent = "Invalid event data: " + e; return; }
+  const resp = await fetch("/editor/" + op, {
+    method: "POST",
+    headers: {"Content-Type": "application/json", "Authorization": "Bearer " + {{.Token}}},
+    body: JSON.stringify({path: {{.Path}}, source: document.getElementById("source").value, data: data}),
+  });
+  const body = await resp.json();
+  result.className = resp.ok ? "ok" : "error";
+  result.textContent = resp.ok ? body.message : body.error;
+}
+</script>
+</body>
+</html>
+`))
+
+func (ed *Editor) handleEdit(w http.ResponseWriter, r *http.Request) {
+	rel := r.URL.Query().Get("path")
+	path, err := ed.resolve(rel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	source, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := map[string]interface{}{"Token": ed.token, "Path": rel, "Source": string(source)}
+	if err := editorPage.Execute(w, data); err != nil {
+		log.Debug("Failed to render editor: %v", err)
+	}
+}
+
+func (ed *Editor) handleCheck(w http.ResponseWriter, r *http.Request) {
+	req, _, ok := ed.decode(w, r)
+	if !ok {
+		return
+	}
+	if err := executor.CheckSyntax([]byte(req.Source), filepath.Base(req.Path)); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Syntax OK"})
+}
+
+// handleRun executes the edited (unsaved) source with a sample event for its
+// path. The source is written next to the handler so SCRIPT_DIR, params and
+// DoSiblings behave the same; the .lua.test suffix keeps the router away.
+func (ed *Editor) handleRun(w http.ResponseWriter, r *http.Request) {
+	req, path, ok := ed.decode(w, r)
+	if !ok {
+		return
+	}
+	if err := executor.CheckSyntax([]byte(req.Source), filepath.Base(req.Path)); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	event, err := events.SampleEvent(req.Path, req.Data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	testPath := path + ".test"
+	if err := os.WriteFile(testPath, []byte(req.Source), 0644); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(testPath)
+
+	log.Info("Editor: test run of %s", req.Path)
+	started := time.Now()
+	if err := ed.runner.Execute(testPath, event); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message": fmt.Sprintf("Ran in %v with %s/%s event (see the server log for output)",
+			time.Since(started).Round(time.Millisecond), event.Source, event.Type),
+	})
+}
+
+// handleSave writes the source after a syntax check, keeping the previous
+// version as <script>.lua.<timestamp>.bak
+func (ed *Editor) handleSave(w http.ResponseWriter, r *http.Request) {
+	req, path, ok := ed.decode(w, r)
+	if !ok {
+		return
+	}
+	if err := executor.CheckSyntax([]byte(req.Source), filepath.Base(req.Path)); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "not saved: "+err.Error())
+		return
+	}
+
+	message := "Saved"
+	if previous, err := os.ReadFile(path); err == nil {
+		backup := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+		if err := os.WriteFile(backup, previous, 0644); err != nil {
+			writeError(w, http.StatusInternalServerError, "backup failed, not saved: "+err.Error())
+			return
+		}
+		message = "Saved, previous version in " + filepath.Base(backup)
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := os.WriteFile(path, []byte(req.Source), 0644); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Info("Editor: saved %s", req.Path)
+	writeJSON(w, http.StatusOK, map[string]string{"message": message})
+}
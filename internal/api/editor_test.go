@@ -0,0 +1,90 @@
+package api
+
+import (
+	"homescript-server/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func editorServer(t *testing.T) *Server {
+	t.Helper()
+	base := t.TempDir()
+	script := filepath.Join(base, "events", "time", "07_00", "wake.lua")
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(script, []byte("log.info(\"up\")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := New("")
+	RegisterEditor(s, config.WizardConfig{Enabled: true, Token: "secret"}, nil, base)
+	return s
+}
+
+func serve(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestEditorRejectsQueryToken(t *testing.T) {
+	s := editorServer(t)
+
+	w := serve(s, httptest.NewRequest("GET", "/editor/edit?path=time/07_00/wake.lua&token=secret", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("edit with ?token=: status %d, want 401", w.Code)
+	}
+	body := strings.NewReader(`{"path": "time/07_00/wake.lua", "source": "x = 1"}`)
+	w = serve(s, httptest.NewRequest("POST", "/editor/check?token=secret", body))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("check with ?token=: status %d, want 401", w.Code)
+	}
+	w = serve(s, httptest.NewRequest("GET", "/editor?token=secret", nil))
+	if strings.Contains(w.Body.String(), "wake.lua") {
+		t.Error("list with ?token= shows the handlers, want the login form")
+	}
+}
+
+func TestEditorLogin(t *testing.T) {
+	s := editorServer(t)
+
+	login := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/editor/login", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(s, r)
+	}
+	if w := login("wrong"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("login with a wrong token: status %d, %d cookie(s)", w.Code, len(w.Result().Cookies()))
+	}
+	w := login("secret")
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusSeeOther || len(cookies) != 1 {
+		t.Fatalf("login: status %d, %d cookie(s), want a redirect with the cookie", w.Code, len(cookies))
+	}
+
+	r := httptest.NewRequest("GET", "/editor", nil)
+	r.AddCookie(cookies[0])
+	w = serve(s, r)
+	page := w.Body.String()
+	if !strings.Contains(page, "/editor/edit?path=time%2f07_00%2fwake.lua") || strings.Contains(page, "secret") {
+		t.Errorf("list after login: want the handler linked without the token:\n%s", page)
+	}
+
+	r = httptest.NewRequest("GET", "/editor/edit?path=time/07_00/wake.lua", nil)
+	r.AddCookie(cookies[0])
+	if w = serve(s, r); w.Code != http.StatusOK {
+		t.Errorf("edit with the login cookie: status %d", w.Code)
+	}
+
+	body := strings.NewReader(`{"path": "time/07_00/wake.lua", "source": "x = 1"}`)
+	r = httptest.NewRequest("POST", "/editor/check", body)
+	r.Header.Set("Authorization", "Bearer secret")
+	if w = serve(s, r); w.Code != http.StatusOK {
+		t.Errorf("check with a bearer token: status %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"homescript-server/internal/types"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// eventTypePattern limits webhook event types to directory names below
+// events/webhook/, e.g. "doorbell" or "phone/arrived"
+var eventTypePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+(/[A-Za-z0-9_-]+)*$`)
+
+// RegisterEvents registers POST /api/events/<type>, which routes a webhook
+// event (events/webhook/<type>/) with a JSON object body as data. It needs
+// the events scope, so doorbells and phones can be given a token that does
+// nothing else.
+func RegisterEvents(s *Server, router EventRouter, auth *APIAuth) {
+	if !auth.Enabled() {
+		return // RegisterREST already warned
+	}
+
+	s.HandleFunc("POST /api/events/{type...}", auth.require(ScopeEvents, func(w http.ResponseWriter, r *http.Request) {
+		routeWebhook(w, r, router, false)
+	}))
+	log.Info("Webhook events enabled at /api/events")
+}
+
+// routeWebhook routes the webhook event named by the {type} path value, with
+// the request's JSON object body as data (and simulated = true for 'trigger').
+// POST /api/events, POST /webhook and POST /trigger/webhook all go through it.
+func routeWebhook(w http.ResponseWriter, r *http.Request, router EventRouter, simulated bool) {
+	eventType := r.PathValue("type")
+	if !eventTypePattern.MatchString(eventType) {
+		writeError(w, http.StatusBadRequest, "invalid event type: "+eventType)
+		return
+	}
+	data, err := readEventData(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if simulated {
+		data["simulated"] = true
+	}
+
+	event := &types.Event{
+		Source:        "webhook",
+		Type:          eventType,
+		Data:          data,
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	}
+	log.Info("[%s] Webhook event: %s", event.CorrelationID, eventType)
+	router.RouteEvent(event)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ok":             true,
+		"event":          "webhook/" + eventType,
+		"correlation_id": event.CorrelationID,
+	})
+}
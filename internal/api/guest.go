@@ -0,0 +1,137 @@
+package api
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GuestDevice is the read-only view of a device shown to guests
+type GuestDevice struct {
+	ID    string                 `json:"id"`
+	Name  string                 `json:"name"`
+	Type  string                 `json:"type"`
+	State map[string]interface{} `json:"state"`
+}
+
+// Guest serves a read-only view of whitelisted devices for wall tablets
+type Guest struct {
+	devices DeviceReader
+	token   string
+	allowed []string
+}
+
+// RegisterGuest registers the guest dashboard (/guest) and its JSON feed (/guest/devices)
+func RegisterGuest(s *Server, devices DeviceReader, token string, allowed []string) {
+	g := &Guest{devices: devices, token: token, allowed: allowed}
+	s.HandleFunc("GET /guest", g.authorize(g.handlePage))
+	s.HandleFunc("GET /guest/devices", g.authorize(g.handleDevices))
+	log.Info("Guest view enabled for %d device(s)", len(allowed))
+}
+
+// authorize checks the guest token if one is configured
+func (g *Guest) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.token != "" && subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(g.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid guest token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// snapshot returns current state of all whitelisted devices
+func (g *Guest) snapshot() []GuestDevice {
+	result := make([]GuestDevice, 0, len(g.allowed))
+	for _, id := range g.allowed {
+		dev, ok := g.devices.GetDevice(id)
+		if !ok {
+			continue
+		}
+		state, err := g.devices.Get(id)
+		if err != nil {
+			continue
+		}
+		// Binary payloads (snapshots) are never exposed
+		for k, v := range state {
+			if _, isBinary := v.([]byte); isBinary {
+				delete(state, k)
+			}
+		}
+		result = append(result, GuestDevice{ID: dev.ID, Name: dev.Name, Type: dev.Type, State: state})
+	}
+	return result
+}
+
+func (g *Guest) handleDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, g.snapshot())
+}
+
+var guestPage = template.Must(template.New("guest").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="10">
+<title>House status</title>
+<style>
+body { font-family: sans-serif; background: #111; color: #eee; margin: 1em; }
+.device { background: #222; border-radius: 8px; padding: 0.8em; margin-bottom: 0.8em; }
+.name { font-size: 1.3em; margin-bottom: 0.3em; }
+.attr { color: #aaa; }
+.updated { color: #666; font-size: 0.8em; }
+</style>
+</head>
+<body>
+{{range .Devices}}{{$id := .ID}}
+<div class="device">
+  <div class="name">{{.Name}}</div>
+  {{range $k := .Keys}}<div class="attr">{{$k}}: {{index $.States $id $k}}</div>{{end}}
+</div>
+{{else}}
+<p>No devices configured for the guest view.</p>
+{{end}}
+<div class="updated">Updated {{.Updated}}</div>
+</body>
+</html>
+`))
+
+func (g *Guest) handlePage(w http.ResponseWriter, r *http.Request) {
+	type pageDevice struct {
+		ID   string
+		Name string
+		Keys []string
+	}
+
+	devices := g.snapshot()
+	page := struct {
+		Devices []pageDevice
+		States  map[string]map[string]interface{}
+		Updated string
+	}{
+		States:  make(map[string]map[string]interface{}),
+		Updated: time.Now().Format("15:04:05"),
+	}
+
+	for _, dev := range devices {
+		keys := make([]string, 0, len(dev.State))
+		for k := range dev.State {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		name := dev.Name
+		if name == "" {
+			name = dev.ID
+		}
+		page.Devices = append(page.Devices, pageDevice{ID: dev.ID, Name: name, Keys: keys})
+		page.States[dev.ID] = dev.State
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := guestPage.Execute(w, page); err != nil {
+		log.Debug("Failed to render guest page: %v", err)
+	}
+}
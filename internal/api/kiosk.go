@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/types"
+	"net/http"
+	"time"
+)
+
+// ActionCaller runs device action scripts (implemented by executor.Executor)
+type ActionCaller interface {
+	CallAction(id, action string, params map[string]interface{}) error
+}
+
+// DeviceSetter sets device attributes (implemented by devices.Manager)
+type DeviceSetter interface {
+	Set(id string, attrs map[string]interface{}) error
+}
+
+// EventRouter routes events (implemented by events.Router)
+type EventRouter interface {
+	RouteEvent(event *types.Event)
+}
+
+// Kiosk exposes configured actions as simple GET endpoints
+type Kiosk struct {
+	cfg     config.KioskConfig
+	actions ActionCaller
+	devices DeviceSetter
+	router  EventRouter
+}
+
+// RegisterKiosk registers GET /kiosk/<name> for every configured kiosk action
+func RegisterKiosk(s *Server, cfg config.KioskConfig, actions ActionCaller, devices DeviceSetter, router EventRouter) {
+	if cfg.Token == "" {
+		log.Warn("Kiosk actions configured without a token, kiosk endpoints disabled")
+		return
+	}
+
+	k := &Kiosk{cfg: cfg, actions: actions, devices: devices, router: router}
+	s.HandleFunc("GET /kiosk/{name...}", k.handle)
+	log.Info("Kiosk enabled with %d action(s)", len(cfg.Actions))
+}
+
+func (k *Kiosk) handle(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(k.cfg.Token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid kiosk token")
+		return
+	}
+
+	name := r.PathValue("name")
+	action, ok := k.cfg.Actions[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown kiosk action: "+name)
+		return
+	}
+
+	log.Info("Kiosk action: %s", name)
+	if err := k.run(action); err != nil {
+		log.Error("Kiosk action %s failed: %v", name, err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "action": name})
+}
+
+func (k *Kiosk) run(action config.KioskAction) error {
+	switch {
+	case action.Action != "":
+		return k.actions.CallAction(action.Device, action.Action, action.Params)
+	case action.Set != nil:
+		return k.devices.Set(action.Device, action.Set)
+	case action.Scene != "":
+		k.router.RouteEvent(&types.Event{
+			Source:    "custom",
+			Type:      "scene",
+			Data:      map[string]interface{}{"scene": action.Scene},
+			Timestamp: time.Now(),
+		})
+		return nil
+	case action.Event != "":
+		k.router.RouteEvent(&types.Event{
+			Source:    "custom",
+			Type:      action.Event,
+			Data:      action.Data,
+			Timestamp: time.Now(),
+		})
+		return nil
+	default:
+		return fmt.Errorf("kiosk action has no action, set, scene or event")
+	}
+}
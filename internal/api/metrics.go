@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"homescript-server/internal/events"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/metrics"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// UnroutedReporter counts events that matched no script (implemented by
+// events.Router)
+type UnroutedReporter interface {
+	Unrouted(hints bool) []events.UnroutedEvent
+}
+
+// BudgetReporter times script runs against their execution budgets
+// (implemented by executor.Executor)
+type BudgetReporter interface {
+	BudgetStats() map[string]executor.BudgetStat
+}
+
+// RegisterMetrics registers Prometheus metrics at /metrics, per-device JSON
+// stats at /metrics/devices (used by 'devices stats') and unrouted events at
+// /metrics/unrouted (used by 'events unrouted'), for API credentials of the
+// read scope. /metrics also carries the script runs and budget overruns.
+func RegisterMetrics(s *Server, registry *metrics.Registry, unrouted UnroutedReporter, budgets BudgetReporter, auth *APIAuth) {
+	if !auth.Enabled() {
+		log.Warn("Metrics enabled without API tokens or users, /metrics disabled")
+		return
+	}
+	read := func(next http.HandlerFunc) http.HandlerFunc { return auth.require(ScopeRead, next) }
+
+	s.HandleFunc("GET /metrics", read(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := registry.WritePrometheus(w); err != nil {
+			log.Debug("Failed to write metrics: %v", err)
+			return
+		}
+		if err := writeUnroutedPrometheus(w, unrouted.Unrouted(false)); err != nil {
+			log.Debug("Failed to write metrics: %v", err)
+			return
+		}
+		if err := writeBudgetPrometheus(w, budgets.BudgetStats()); err != nil {
+			log.Debug("Failed to write metrics: %v", err)
+		}
+	}))
+	s.HandleFunc("GET /metrics/devices", read(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, registry.Devices())
+	}))
+	s.HandleFunc("GET /metrics/devices/{id}", read(func(w http.ResponseWriter, r *http.Request) {
+		stats, ok := registry.Device(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "no metrics for device: "+r.PathValue("id"))
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	}))
+	s.HandleFunc("GET /metrics/unrouted", read(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, unrouted.Unrouted(true))
+	}))
+	log.Info("Metrics enabled at /metrics")
+}
+
+// writeUnroutedPrometheus writes the unrouted event counts per handler directory
+func writeUnroutedPrometheus(w io.Writer, list []events.UnroutedEvent) error {
+	const name = "homescript_unrouted_events_total"
+	if _, err := fmt.Fprintf(w, "# HELP %s Events that matched no script, by handler directory\n# TYPE %s counter\n", name, name); err != nil {
+		return err
+	}
+	for _, e := range list {
+		if _, err := fmt.Fprintf(w, "%s{directory=\"%s\",source=\"%s\"} %d\n", name, metrics.EscapeLabel(e.Directory), metrics.EscapeLabel(e.Source), e.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBudgetPrometheus writes the runs, budget overruns and durations per script
+func writeBudgetPrometheus(w io.Writer, stats map[string]executor.BudgetStat) error {
+	scripts := make([]string, 0, len(stats))
+	for script := range stats {
+		scripts = append(scripts, script)
+	}
+	slices.Sort(scripts)
+
+	series := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(s executor.BudgetStat) float64
+	}{
+		{"homescript_script_runs_total", "counter", "Script runs",
+			func(s executor.BudgetStat) float64 { return float64(s.Runs) }},
+		{"homescript_script_budget_overruns_total", "counter", "Script runs that took longer than the execution budget",
+			func(s executor.BudgetStat) float64 { return float64(s.Overruns) }},
+		{"homescript_script_budget_seconds", "gauge", "Execution budget of the script (0 if disabled)",
+			func(s executor.BudgetStat) float64 { return s.Budget.Seconds() }},
+		{"homescript_script_duration_last_seconds", "gauge", "Duration of the latest run",
+			func(s executor.BudgetStat) float64 { return s.LastDuration.Seconds() }},
+		{"homescript_script_duration_max_seconds", "gauge", "Longest run since start",
+			func(s executor.BudgetStat) float64 { return s.MaxDuration.Seconds() }},
+	}
+	for _, m := range series {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, script := range scripts {
+			if _, err := fmt.Fprintf(w, "%s{script=\"%s\"} %g\n", m.name, metrics.EscapeLabel(script), m.value(stats[script])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
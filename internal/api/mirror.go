@@ -0,0 +1,35 @@
+package api
+
+import (
+	"crypto/subtle"
+	"homescript-server/internal/mirror"
+	"io"
+	"net/http"
+)
+
+// maxSnapshotSize bounds a pushed snapshot
+const maxSnapshotSize = 256 << 20
+
+// RegisterMirror accepts state snapshots from a primary at POST /mirror,
+// making this instance its standby
+func RegisterMirror(s *Server, receiver *mirror.Receiver, token string) {
+	s.HandleFunc("POST /mirror", func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid mirror token")
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		snapshot, err := receiver.Receive(data)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": len(snapshot.State), "time": snapshot.Time})
+	})
+	log.Info("Accepting mirrored state at /mirror")
+}
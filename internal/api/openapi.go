@@ -0,0 +1,20 @@
+package api
+
+import (
+	"homescript-server/internal/config"
+	"homescript-server/internal/schema"
+	"net/http"
+)
+
+// RegisterOpenAPI serves GET /api/openapi.json, the OpenAPI document of the
+// enabled endpoints (the one `schema --out` writes) for client generators.
+// It is built per request, so devices added by a reload show up.
+func RegisterOpenAPI(s *Server, devices DeviceLister, server *config.ServerConfig, auth *APIAuth) {
+	if !auth.Enabled() {
+		return // RegisterREST already warned
+	}
+	s.HandleFunc("GET /api/openapi.json", auth.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, schema.OpenAPI(devices.ListDevices(), server))
+	}))
+	log.Info("OpenAPI document at /api/openapi.json")
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"homescript-server/internal/reload"
+	"net/http"
+)
+
+// Reloader applies a changed configuration (implemented by reload.Reloader)
+type Reloader interface {
+	Reload(dryRun bool) *reload.Result
+}
+
+// RegisterReload validates the configuration and swaps it in at
+// POST /reload (?dry_run=1 only reports what would change; used by 'reload'),
+// for API credentials of the control scope
+func RegisterReload(s *Server, reloader Reloader, auth *APIAuth) {
+	if !auth.Enabled() {
+		log.Warn("Reload enabled without API tokens or users, /reload disabled")
+		return
+	}
+	s.HandleFunc("POST /reload", auth.require(ScopeControl, func(w http.ResponseWriter, r *http.Request) {
+		dryRun := r.URL.Query().Get("dry_run")
+		// A rejected reload is a result too: applied is false and errors says why
+		writeJSON(w, http.StatusOK, reloader.Reload(dryRun == "1" || dryRun == "true"))
+	}))
+	log.Info("Reload enabled at /reload")
+}
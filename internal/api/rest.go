@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"homescript-server/internal/scheduler"
+	"homescript-server/internal/types"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// RESTDevices lists, reads and sets devices (implemented by devices.Manager)
+type RESTDevices interface {
+	DeviceReader
+	DeviceSetter
+	ListDevices() []*types.Device
+}
+
+// StateStore holds the state.set values (implemented by storage.Storage)
+type StateStore interface {
+	GetMany(keys []string) (map[string]interface{}, error)
+	Set(key string, value interface{}) error
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+}
+
+// TimerList lists and cancels pending timers (implemented by scheduler.Scheduler)
+type TimerList interface {
+	Timers() []scheduler.AgendaEntry
+	RemoveTimer(id string) bool
+}
+
+// RESTDevice is a device with its cached state as served by the REST API
+type RESTDevice struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Area       string                 `json:"area,omitempty"`
+	Attributes []string               `json:"attributes,omitempty"`
+	Actions    []string               `json:"actions,omitempty"`
+	State      map[string]interface{} `json:"state"`
+}
+
+// RegisterREST registers the JSON API for UIs and external integrations;
+// GET needs the read scope, the rest control:
+//
+//	GET    /api/devices                        devices with their state
+//	GET    /api/devices/<id>                   one device
+//	POST   /api/devices/<id>/set               device.set, JSON object body
+//	POST   /api/devices/<id>/actions/<action>  device.call, JSON object body as params
+//	GET    /api/state?prefix=                  state values by key
+//	GET    /api/state/<key>                    one value
+//	PUT    /api/state/<key>                    state.set, JSON body as value
+//	DELETE /api/state/<key>                    state.delete
+//	GET    /api/timers                         pending timers
+//	DELETE /api/timers/<id>                    timer.cancel
+func RegisterREST(s *Server, devices RESTDevices, actions ActionCaller, store StateStore, timers TimerList, auth *APIAuth) {
+	if !auth.Enabled() {
+		log.Warn("REST API enabled without tokens or users, /api disabled")
+		return
+	}
+	read := func(next http.HandlerFunc) http.HandlerFunc { return auth.require(ScopeRead, next) }
+	control := func(next http.HandlerFunc) http.HandlerFunc { return auth.require(ScopeControl, next) }
+
+	s.HandleFunc("GET /api/devices", read(func(w http.ResponseWriter, r *http.Request) {
+		list := devices.ListDevices()
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		result := make([]RESTDevice, 0, len(list))
+		for _, dev := range list {
+			result = append(result, restDevice(devices, dev))
+		}
+		writeJSON(w, http.StatusOK, result)
+	}))
+	s.HandleFunc("GET /api/devices/{id}", read(func(w http.ResponseWriter, r *http.Request) {
+		dev, ok := devices.GetDevice(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown device: "+r.PathValue("id"))
+			return
+		}
+		writeJSON(w, http.StatusOK, restDevice(devices, dev))
+	}))
+	s.HandleFunc("POST /api/devices/{id}/set", control(func(w http.ResponseWriter, r *http.Request) {
+		dev, ok := devices.GetDevice(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown device: "+r.PathValue("id"))
+			return
+		}
+		attrs, err := readEventData(r)
+		if err != nil || len(attrs) == 0 {
+			writeError(w, http.StatusBadRequest, "body must be a JSON object of attributes")
+			return
+		}
+
+		log.Info("API: setting %s %v", dev.ID, attrs)
+		if err := devices.Set(dev.ID, attrs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "device": dev.ID})
+	}))
+	s.HandleFunc("POST /api/devices/{id}/actions/{action}", control(func(w http.ResponseWriter, r *http.Request) {
+		dev, ok := devices.GetDevice(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown device: "+r.PathValue("id"))
+			return
+		}
+		params, err := readEventData(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		action := r.PathValue("action")
+		log.Info("API: calling %s.%s", dev.ID, action)
+		if err := actions.CallAction(dev.ID, action, params); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "device": dev.ID, "action": action})
+	}))
+
+	s.HandleFunc("GET /api/state", read(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := store.List(r.URL.Query().Get("prefix"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		values, err := store.GetMany(keys)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, values)
+	}))
+	s.HandleFunc("GET /api/state/{key...}", read(func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		values, err := store.GetMany([]string{key})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		value, ok := values[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "key not found: "+key)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value})
+	}))
+	s.HandleFunc("PUT /api/state/{key...}", control(func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		var value interface{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&value); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if err := store.Set(key, value); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "key": key})
+	}))
+	s.HandleFunc("DELETE /api/state/{key...}", control(func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if err := store.Delete(key); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "key": key})
+	}))
+
+	s.HandleFunc("GET /api/timers", read(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, timers.Timers())
+	}))
+	s.HandleFunc("DELETE /api/timers/{id...}", control(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !timers.RemoveTimer(id) {
+			writeError(w, http.StatusNotFound, "unknown timer: "+id)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "timer": id})
+	}))
+	log.Info("REST API enabled at /api")
+}
+
+// restDevice combines a device with its cached state (empty if it hasn't
+// reported yet); binary payloads (snapshots) are left out
+func restDevice(devices DeviceReader, dev *types.Device) RESTDevice {
+	state, err := devices.Get(dev.ID)
+	if err != nil {
+		state = make(map[string]interface{})
+	}
+	for k, v := range state {
+		if _, isBinary := v.([]byte); isBinary {
+			delete(state, k)
+		}
+	}
+	return RESTDevice{
+		ID:         dev.ID,
+		Name:       dev.Name,
+		Type:       dev.Type,
+		Area:       dev.Area,
+		Attributes: dev.Attributes,
+		Actions:    dev.Actions,
+		State:      state,
+	}
+}
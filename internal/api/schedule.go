@@ -0,0 +1,20 @@
+package api
+
+import (
+	"homescript-server/internal/scheduler"
+	"net/http"
+)
+
+// RegisterSchedule serves today's sun times, the time events that will
+// still fire and the pending timers at /schedule (used by 'schedule'), for
+// API credentials of the read scope
+func RegisterSchedule(s *Server, sched *scheduler.Scheduler, auth *APIAuth) {
+	if !auth.Enabled() {
+		log.Warn("Schedule enabled without API tokens or users, /schedule disabled")
+		return
+	}
+	s.HandleFunc("GET /schedule", auth.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, sched.Today())
+	}))
+	log.Info("Schedule enabled at /schedule")
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"homescript-server/internal/events"
+	"homescript-server/internal/types"
+	"net/http"
+	"time"
+)
+
+// ScriptManager lists handler scripts and switches them on and off
+// (implemented by events.Router)
+type ScriptManager interface {
+	Scripts() ([]events.HandlerScript, error)
+	ScriptPath(rel string) (string, error)
+	SetScriptDisabled(rel string, disabled bool) error
+}
+
+// RegisterScripts registers handler script management next to the REST API;
+// listing needs the read scope, the rest control. Paths are relative to
+// config/events:
+//
+//	GET  /api/scripts                  handler scripts and whether they are disabled
+//	POST /api/scripts/disable/<path>   stop routing events to the script (persisted)
+//	POST /api/scripts/enable/<path>    route events to it again
+//	POST /api/scripts/run/<path>       run it now with a sample event, JSON object body as event data
+func RegisterScripts(s *Server, scripts ScriptManager, runner ScriptRunner, auth *APIAuth) {
+	if !auth.Enabled() {
+		return // RegisterREST already warned
+	}
+	setDisabled := func(disabled bool) http.HandlerFunc {
+		return auth.require(ScopeControl, func(w http.ResponseWriter, r *http.Request) {
+			path := r.PathValue("path")
+			if err := scripts.SetScriptDisabled(path, disabled); err != nil {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "path": path, "disabled": disabled})
+		})
+	}
+
+	s.HandleFunc("GET /api/scripts", auth.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		list, err := scripts.Scripts()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if list == nil {
+			list = []events.HandlerScript{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	}))
+	s.HandleFunc("POST /api/scripts/disable/{path...}", setDisabled(true))
+	s.HandleFunc("POST /api/scripts/enable/{path...}", setDisabled(false))
+	s.HandleFunc("POST /api/scripts/run/{path...}", auth.require(ScopeControl, func(w http.ResponseWriter, r *http.Request) {
+		rel := r.PathValue("path")
+		path, err := scripts.ScriptPath(rel)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		data, err := readEventData(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		event, err := events.SampleEvent(rel, data)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		event.Data["simulated"] = true
+		event.CorrelationID = types.NewCorrelationID()
+
+		// A manual run skips conditions.yaml and runs disabled scripts too
+		log.Info("[%s] API: running %s with %s/%s event", event.CorrelationID, rel, event.Source, event.Type)
+		started := time.Now()
+		if err := runner.Execute(path, event); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"ok":          true,
+			"path":        rel,
+			"event":       event.Source + "/" + event.Type,
+			"duration_ms": time.Since(started).Milliseconds(),
+		})
+	}))
+	log.Info("Script management enabled at /api/scripts")
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"io"
+	"net/http"
+	"time"
+)
+
+var log = logger.Module("http")
+
+// DeviceReader provides read access to devices and their cached state
+type DeviceReader interface {
+	GetDevice(id string) (*types.Device, bool)
+	Get(id string) (map[string]interface{}, error)
+}
+
+// Server is the embedded HTTP server
+type Server struct {
+	addr       string
+	mux        *http.ServeMux
+	httpServer *http.Server
+	tls        bool
+}
+
+// New creates a new HTTP server listening on addr
+func New(addr string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		addr: addr,
+		mux:  mux,
+		httpServer: &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// SetTLS serves HTTPS with a PEM certificate and key instead of plain HTTP
+func (s *Server) SetTLS(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	s.httpServer.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	s.tls = true
+	return nil
+}
+
+// TLS reports whether the server serves HTTPS
+func (s *Server) TLS() bool {
+	return s.tls
+}
+
+// Handle registers a handler for a pattern (net/http ServeMux syntax)
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a handler function for a pattern
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start begins serving in the background
+func (s *Server) Start() {
+	go func() {
+		var err error
+		if s.tls {
+			log.Info("HTTPS server listening on %s", s.addr)
+			// The certificate is already in TLSConfig
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			log.Info("HTTP server listening on %s", s.addr)
+			err = s.httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("HTTP server error: %v", err)
+		}
+	}()
+}
+
+// Stop gracefully shuts the server down
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		log.Warn("HTTP server shutdown error: %v", err)
+	}
+	log.Debug("HTTP server stopped")
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Debug("Failed to write JSON response: %v", err)
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// requestToken extracts a token from "Authorization: Bearer" or ?token=
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && auth[:7] == "Bearer " {
+		return auth[7:]
+	}
+	return r.URL.Query().Get("token")
+}
+
+// readEventData decodes an optional JSON object request body as event data
+func readEventData(r *http.Request) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &data); err != nil || data == nil {
+			return nil, fmt.Errorf("body must be a JSON object")
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"homescript-server/internal/stream"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// RegisterStream registers the filtered state change WebSocket at /stream.
+//
+// Initial filter comes from query parameters:
+//
+//	/stream?devices=porch,kitchen_sensor&attributes=temperature&min_delta=0.5
+//
+// Clients may send a JSON filter message at any time to replace it. The
+// stream needs API credentials of the read scope.
+func RegisterStream(s *Server, hub *stream.Hub, auth *APIAuth) {
+	if !auth.Enabled() {
+		log.Warn("WebSocket event stream enabled without API tokens or users, /stream disabled")
+		return
+	}
+	s.HandleFunc("GET /stream", auth.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		handleStream(hub, w, r)
+	}))
+	log.Info("WebSocket event stream enabled at /stream")
+}
+
+func handleStream(hub *stream.Hub, w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := hub.Subscribe(filterFromQuery(r))
+	defer hub.Unsubscribe(sub)
+
+	log.Debug("Stream client connected: %s", r.RemoteAddr)
+
+	// Read filter updates until the client disconnects
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var filter stream.Filter
+			if err := conn.ReadJSON(&filter); err != nil {
+				return
+			}
+			sub.SetFilter(filter)
+		}
+	}()
+
+	for {
+		select {
+		case change, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(change); err != nil {
+				return
+			}
+		case <-done:
+			log.Debug("Stream client disconnected: %s", r.RemoteAddr)
+			return
+		}
+	}
+}
+
+func filterFromQuery(r *http.Request) stream.Filter {
+	query := r.URL.Query()
+	filter := stream.Filter{
+		Devices:    splitList(query.Get("devices")),
+		Attributes: splitList(query.Get("attributes")),
+	}
+	if delta, err := strconv.ParseFloat(query.Get("min_delta"), 64); err == nil {
+		filter.MinDelta = delta
+	}
+	return filter
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"homescript-server/internal/events"
+	"homescript-server/internal/logger"
+	"net/http"
+	"strconv"
+)
+
+// RecentEvents returns the last dispatched events (implemented by events.Router)
+type RecentEvents interface {
+	RecentEvents(after uint64) ([]events.RecentEvent, uint64)
+}
+
+// RegisterTail registers the log and event tail of the REST API (read scope),
+// for `homescript-server logs` and dashboards without shell access:
+//
+//	GET /api/logs?after=<seq>           recent log lines
+//	GET /api/events/recent?after=<seq>  recent events with the scripts they ran
+//
+// Both answer the entries newer than after and last, the sequence number to
+// pass as after next time. last going down means the server restarted.
+func RegisterTail(s *Server, recent RecentEvents, auth *APIAuth) {
+	if !auth.Enabled() {
+		return // RegisterREST already warned
+	}
+
+	s.HandleFunc("GET /api/logs", auth.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		after, ok := afterParam(w, r)
+		if !ok {
+			return
+		}
+		lines, last := logger.Tail(after)
+		if lines == nil {
+			lines = []logger.Line{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"lines": lines, "last": last})
+	}))
+	s.HandleFunc("GET /api/events/recent", auth.require(ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		after, ok := afterParam(w, r)
+		if !ok {
+			return
+		}
+		list, last := recent.RecentEvents(after)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"events": list, "last": last})
+	}))
+	log.Info("Log and event tail enabled at /api/logs and /api/events/recent")
+}
+
+// afterParam parses the optional ?after= sequence number
+func afterParam(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	value := r.URL.Query().Get("after")
+	if value == "" {
+		return 0, true
+	}
+	after, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid after: "+value)
+		return 0, false
+	}
+	return after, true
+}
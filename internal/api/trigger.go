@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"io"
+	"net/http"
+	"time"
+)
+
+// TimeTrigger fires time events on demand (implemented by scheduler.Scheduler)
+type TimeTrigger interface {
+	Trigger(eventType string) error
+}
+
+// RegisterTrigger registers simulated events routed through the real router
+// (used by 'trigger'):
+//
+//	POST /trigger/time/<event>     time event, e.g. sunset or sunset/-00_30
+//	POST /trigger/webhook/<type>   webhook event, JSON object body as data
+//	POST /trigger/device           state_change, body {"device", "attribute", "value", "data"}
+//
+// They need API credentials of the control scope.
+func RegisterTrigger(s *Server, router EventRouter, times TimeTrigger, devices DeviceReader, auth *APIAuth) {
+	if !auth.Enabled() {
+		log.Warn("Simulated events enabled without API tokens or users, /trigger disabled")
+		return
+	}
+	control := func(next http.HandlerFunc) http.HandlerFunc { return auth.require(ScopeControl, next) }
+
+	s.HandleFunc("POST /trigger/time/{event...}", control(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("event")
+		if err := times.Trigger(name); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "event": "time/" + name})
+	}))
+	s.HandleFunc("POST /trigger/webhook/{type...}", control(func(w http.ResponseWriter, r *http.Request) {
+		routeWebhook(w, r, router, true)
+	}))
+	s.HandleFunc("POST /trigger/device", control(func(w http.ResponseWriter, r *http.Request) {
+		var req DeviceTrigger
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if req.Device == "" || req.Attribute == "" {
+			writeError(w, http.StatusBadRequest, "device and attribute are required")
+			return
+		}
+		event, err := deviceEvent(devices, req)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		log.Info("[%s] Simulating %s.%s = %v", event.CorrelationID, event.Device, event.Attribute, req.Value)
+		router.RouteEvent(event)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "event": "device/" + event.Device + "/" + event.Attribute, "data": event.Data})
+	}))
+	log.Info("Simulated events enabled at /trigger")
+}
+
+// DeviceTrigger is a simulated device state change: Attribute becomes Value,
+// with the other attributes in Data changed in the same message
+type DeviceTrigger struct {
+	Device    string                 `json:"device"`
+	Attribute string                 `json:"attribute"`
+	Value     interface{}            `json:"value"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// deviceEvent builds the state_change event a device message would route:
+// the cached state merged with the new values. The cache itself is left
+// alone, so device.get still returns the real state.
+func deviceEvent(devices DeviceReader, req DeviceTrigger) (*types.Event, error) {
+	dev, ok := devices.GetDevice(req.Device)
+	if !ok {
+		return nil, fmt.Errorf("unknown device: %s", req.Device)
+	}
+
+	data := make(map[string]interface{})
+	if state, err := devices.Get(dev.ID); err == nil {
+		for k, v := range state {
+			data[k] = v
+		}
+	}
+	for k, v := range req.Data {
+		data[k] = v
+	}
+	data[req.Attribute] = req.Value
+	data["simulated"] = true
+
+	return &types.Event{
+		Source:        "device",
+		Type:          "state_change",
+		Device:        dev.ID,
+		Attribute:     req.Attribute,
+		Area:          dev.Area,
+		Topic:         dev.MQTT.StateTopic,
+		Data:          data,
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	}, nil
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto/subtle"
+	"homescript-server/internal/config"
+	"homescript-server/internal/scaffold"
+	"homescript-server/internal/types"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DeviceLister lists configured devices (implemented by devices.Manager)
+type DeviceLister interface {
+	ListDevices() []*types.Device
+}
+
+// Wizard serves the "new automation" form that writes handler scripts
+type Wizard struct {
+	token    string
+	devices  DeviceLister
+	basePath string
+}
+
+// RegisterWizard registers the automation wizard (/wizard) and webhook
+// triggers (POST /webhook/<type>), which route the same webhook event as
+// POST /api/events/<type> for the wizard token
+func RegisterWizard(s *Server, cfg config.WizardConfig, devices DeviceLister, router EventRouter, basePath string) {
+	if cfg.Token == "" {
+		log.Warn("Wizard enabled without a token, wizard and webhooks disabled")
+		return
+	}
+
+	wz := &Wizard{token: cfg.Token, devices: devices, basePath: basePath}
+	s.HandleFunc("GET /wizard", wz.authorize(pageToken, wz.handleForm))
+	s.HandleFunc("POST /wizard", wz.authorize(formToken, wz.handleCreate))
+	s.HandleFunc("POST /webhook/{type...}", wz.authorize(requestToken, func(w http.ResponseWriter, r *http.Request) {
+		routeWebhook(w, r, router, false)
+	}))
+	log.Info("Automation wizard enabled at /wizard")
+}
+
+func (wz *Wizard) authorize(tokenOf func(*http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(tokenOf(r)), []byte(wz.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid wizard token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// formToken reads the token of a form submission from the Authorization
+// header or the "token" form field, never the URL, which ends up in logs
+func formToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && auth[:7] == "Bearer " {
+		return auth[7:]
+	}
+	return r.PostFormValue("token")
+}
+
+// pageToken reads the token of the wizard page from the Authorization
+// header, the editor login cookie or ?token=
+func pageToken(r *http.Request) string {
+	if token := sessionToken(r); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+var wizardPage = template.Must(template.New("wizard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>New automation</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; }
+fieldset { margin-bottom: 1em; border-radius: 8px; }
+label { display: block; margin: 0.4em 0; }
+.error { background: #fdd; padding: 0.6em; border-radius: 8px; }
+.ok { background: #dfd; padding: 0.6em; border-radius: 8px; }
+pre { background: #f4f4f4; padding: 0.6em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>New automation</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Created}}<p class="ok">Created <code>{{.Created}}</code>. It is active immediately.</p><pre>{{.Script}}</pre>{{end}}
+<form method="post" action="/wizard">
+<input type="hidden" name="token" value="{{.Token}}">
+<fieldset>
+<legend>1. Name</legend>
+<label>Name <input name="name" required pattern="[A-Za-z0-9_-]+" placeholder="porch_light_at_night"></label>
+</fieldset>
+
+<fieldset>
+<legend>2. When</legend>
+<label><input type="radio" name="trigger" value="device" checked> A device changes</label>
+<label>Device <input name="device" list="devices"></label>
+<label>Attribute <input name="attribute" placeholder="state"></label>
+<label><input type="radio" name="trigger" value="time"> At a time</label>
+<label>Time <input name="time" placeholder="07:30, sunrise or sunset"></label>
+<label><input type="radio" name="trigger" value="webhook"> A webhook is called</label>
+<label>Webhook name <input name="webhook" pattern="[A-Za-z0-9_-]*" placeholder="doorbell"></label>
+</fieldset>
+
+<fieldset>
+<legend>3. Only if (optional)</legend>
+<label>After <input type="time" name="after"></label>
+<label>Before <input type="time" name="before"></label>
+<label>On
+{{range .Weekdays}}<input type="checkbox" name="weekday" value="{{.Value}}"> {{.Name}} {{end}}
+</label>
+<label>Device <input name="cond_device" list="devices"></label>
+<label>Attribute <input name="cond_attribute"> equals <input name="cond_value"></label>
+</fieldset>
+
+<fieldset>
+<legend>4. Then</legend>
+<label><input type="radio" name="body" value="set" checked> Set a device</label>
+<label>Device <input name="target" list="devices"> attribute <input name="target_attr" placeholder="state"> to <input name="target_value_set" placeholder="ON"></label>
+<label><input type="radio" name="body" value="scene"> Activate a scene <input name="target_value_scene"></label>
+<label><input type="radio" name="body" value="log"> Write a log message <input name="target_value_log"></label>
+<label><input type="radio" name="body" value="blank"> Empty script to edit later</label>
+</fieldset>
+
+<datalist id="devices">{{range .Devices}}<option value="{{.ID}}">{{.Name}}</option>{{end}}</datalist>
+<button type="submit">Create automation</button>
+</form>
+</body>
+</html>
+`))
+
+type wizardWeekday struct {
+	Value int
+	Name  string
+}
+
+type wizardPageData struct {
+	Token    string
+	Devices  []*types.Device
+	Weekdays []wizardWeekday
+	Error    string
+	Created  string
+	Script   string
+}
+
+func (wz *Wizard) render(w http.ResponseWriter, status int, data wizardPageData) {
+	data.Token = wz.token
+	data.Devices = wz.devices.ListDevices()
+	sort.Slice(data.Devices, func(i, j int) bool { return data.Devices[i].ID < data.Devices[j].ID })
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		data.Weekdays = append(data.Weekdays, wizardWeekday{Value: int(d), Name: d.String()[:3]})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := wizardPage.Execute(w, data); err != nil {
+		log.Debug("Failed to render wizard page: %v", err)
+	}
+}
+
+func (wz *Wizard) handleForm(w http.ResponseWriter, r *http.Request) {
+	wz.render(w, http.StatusOK, wizardPageData{})
+}
+
+func (wz *Wizard) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		wz.render(w, http.StatusBadRequest, wizardPageData{Error: err.Error()})
+		return
+	}
+
+	a := &scaffold.Automation{
+		Name:          r.PostForm.Get("name"),
+		Trigger:       r.PostForm.Get("trigger"),
+		Device:        r.PostForm.Get("device"),
+		Attribute:     r.PostForm.Get("attribute"),
+		Time:          r.PostForm.Get("time"),
+		Webhook:       r.PostForm.Get("webhook"),
+		After:         r.PostForm.Get("after"),
+		Before:        r.PostForm.Get("before"),
+		CondDevice:    r.PostForm.Get("cond_device"),
+		CondAttribute: r.PostForm.Get("cond_attribute"),
+		CondValue:     r.PostForm.Get("cond_value"),
+		Body:          r.PostForm.Get("body"),
+		Target:        r.PostForm.Get("target"),
+		TargetAttr:    r.PostForm.Get("target_attr"),
+		TargetValue:   r.PostForm.Get("target_value_" + r.PostForm.Get("body")),
+	}
+	for _, d := range r.PostForm["weekday"] {
+		if day, err := strconv.Atoi(d); err == nil && day >= 0 && day <= 6 {
+			a.Weekdays = append(a.Weekdays, day)
+		}
+	}
+
+	// Catch typos in device IDs before they become a handler that never runs
+	for _, id := range []string{a.Device, a.CondDevice, a.Target} {
+		if id != "" && !wz.hasDevice(id) {
+			wz.render(w, http.StatusBadRequest, wizardPageData{Error: "unknown device: " + id})
+			return
+		}
+	}
+
+	path, err := scaffold.WriteAutomation(wz.basePath, a)
+	if err != nil {
+		wz.render(w, http.StatusBadRequest, wizardPageData{Error: err.Error()})
+		return
+	}
+
+	rel, err := filepath.Rel(wz.basePath, path)
+	if err != nil {
+		rel = path
+	}
+	wz.render(w, http.StatusOK, wizardPageData{Created: rel, Script: scaffold.AutomationScript(a)})
+}
+
+func (wz *Wizard) hasDevice(id string) bool {
+	for _, dev := range wz.devices.ListDevices() {
+		if dev.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var log = logger.Module("bridge")
+
+// Bridge mirrors device states and commands under a clean namespace and
+// accepts commands from it:
+//
+//	<prefix>/<area>/<device_id>/<attribute>          normalized state (retained)
+//	<prefix>/<area>/<device_id>/<attribute>/command  command sent to the device
+//	<prefix>/<area>/<device_id>/<attribute>/set      command, forwarded to device.set
+//
+// Devices without an area are under noArea; slashes in device IDs
+// (frigate/porch) become underscores, so every device is one topic level.
+type Bridge struct {
+	client        mqtt.Client
+	prefix        string
+	deviceManager *devices.Manager
+}
+
+// noArea is the area level of devices without an area
+const noArea = "unassigned"
+
+// New creates a new bridge publishing under prefix (e.g. "home")
+func New(client mqtt.Client, prefix string, dm *devices.Manager) *Bridge {
+	return &Bridge{
+		client:        client,
+		prefix:        strings.TrimSuffix(prefix, "/"),
+		deviceManager: dm,
+	}
+}
+
+// Start subscribes to bridge command topics and starts mirroring device
+// states and commands
+func (b *Bridge) Start() error {
+	topic := b.prefix + "/+/+/+/set"
+	token := b.client.Subscribe(topic, 0, b.handleCommand)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+
+	b.deviceManager.AddStateListener(b.PublishState)
+	b.deviceManager.AddCommandListener(b.PublishCommand)
+
+	log.Info("Bridge started: mirroring devices under %s/<area>/<device>/", b.prefix)
+	return nil
+}
+
+// StateTopic returns the bridge topic for a device attribute
+func (b *Bridge) StateTopic(deviceID, attr string) string {
+	area := noArea
+	if dev, ok := b.deviceManager.GetDevice(deviceID); ok && dev.Area != "" {
+		area = dev.Area
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", b.prefix, area, topicID(deviceID), attr)
+}
+
+// PublishState mirrors a device state update, one retained message per
+// attribute. It runs on the MQTT callback path, so it doesn't wait for the
+// broker to acknowledge.
+func (b *Bridge) PublishState(deviceID string, state map[string]interface{}) {
+	b.publish(deviceID, state, "", true)
+}
+
+// PublishCommand mirrors a command sent to a device, one message per attribute
+func (b *Bridge) PublishCommand(deviceID string, attrs map[string]interface{}) {
+	b.publish(deviceID, attrs, "/command", false)
+}
+
+func (b *Bridge) publish(deviceID string, values map[string]interface{}, suffix string, retain bool) {
+	for attr, value := range values {
+		// Binary payloads (snapshots) are not mirrored
+		if _, isBinary := value.([]byte); isBinary {
+			continue
+		}
+
+		payload, err := encodeValue(value)
+		if err != nil {
+			log.Debug("Skipping %s.%s: %v", deviceID, attr, err)
+			continue
+		}
+
+		b.client.Publish(b.StateTopic(deviceID, attr)+suffix, 0, retain, payload)
+	}
+}
+
+// handleCommand forwards <prefix>/<area>/<device_id>/<attribute>/set to the
+// device manager
+func (b *Bridge) handleCommand(_ mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	parts := strings.Split(strings.TrimPrefix(topic, b.prefix+"/"), "/")
+	if len(parts) != 4 {
+		log.Debug("Ignoring bridge command with invalid topic: %s", topic)
+		return
+	}
+	area, id, attr := parts[0], parts[1], parts[2]
+
+	dev := b.device(area, id)
+	if dev == nil {
+		log.Warn("Bridge command %s: no device %s in area %s", topic, id, area)
+		return
+	}
+
+	value := decodeValue(msg.Payload())
+	log.Debug("Bridge command: %s.%s = %v", dev.ID, attr, value)
+
+	if err := b.deviceManager.Set(dev.ID, map[string]interface{}{attr: value}); err != nil {
+		log.Error("Bridge command %s failed: %v", topic, err)
+	}
+}
+
+// device finds the device a bridge topic names
+func (b *Bridge) device(area, id string) *types.Device {
+	for _, dev := range b.deviceManager.ListDevices() {
+		devArea := dev.Area
+		if devArea == "" {
+			devArea = noArea
+		}
+		if topicID(dev.ID) == id && devArea == area {
+			return dev
+		}
+	}
+	return nil
+}
+
+// topicID is a device ID as one topic level
+func topicID(id string) string {
+	return strings.ReplaceAll(id, "/", "_")
+}
+
+// encodeValue renders strings as-is and everything else as JSON
+func encodeValue(value interface{}) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(value)
+}
+
+// decodeValue parses JSON scalars/objects, falling back to the raw string
+func decodeValue(payload []byte) interface{} {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err == nil {
+		return value
+	}
+	return string(payload)
+}
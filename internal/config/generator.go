@@ -2,22 +2,55 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"homescript-server/internal/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// GenerateDevicesYAML creates or updates the devices.yaml file
-func GenerateDevicesYAML(devices []*types.Device, path string) error {
-	config := types.DevicesConfig{
-		Devices:   devices,
-		Generated: time.Now(),
+// GenerateDevicesYAML creates or updates the devices.yaml file, keeping
+// user edits (see MergeDiscovered)
+func GenerateDevicesYAML(devices []*types.Device, sources Sources, path string) (*DevicesMerge, error) {
+	merge, err := MergeDiscovered(devices, sources, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := merge.Write(path); err != nil {
+		return nil, err
+	}
+	return merge, nil
+}
+
+// RenameDevice replaces the entry of device oldID in devices.yaml with dev
+// (used when a device is renamed at runtime)
+func RenameDevice(path, oldID string, dev *types.Device) error {
+	config, err := LoadDevicesYAML(path)
+	if err != nil {
+		return err
 	}
 
+	found := false
+	for i, existing := range config.Devices {
+		if existing.ID == oldID {
+			config.Devices[i] = dev
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("device %s not found in %s", oldID, path)
+	}
+
+	return writeDevicesYAML(config, path)
+}
+
+// writeDevicesYAML writes devices.yaml with the generated-file header
+func writeDevicesYAML(config *types.DevicesConfig, path string) error {
 	data, err := yaml.Marshal(config)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
@@ -78,6 +111,240 @@ func SaveHAConfigs(configs map[string]*types.HomeAssistantDiscovery, path string
 	return nil
 }
 
+// editDevicesYAML applies the merge to devices.yaml line by line: entries of
+// removed devices are cut, entries of changed devices are re-encoded at their
+// place and new devices are appended to the list. Every other line, with its
+// comments and indentation, is kept as it is. A missing or empty file is
+// written from scratch.
+func (m *DevicesMerge) editDevicesYAML(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return writeDevicesYAML(m.Config, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return writeDevicesYAML(m.Config, path)
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("failed to parse config: top level is not a mapping")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+
+	devices := make(map[string]*types.Device, len(m.Config.Devices))
+	for _, dev := range m.Config.Devices {
+		devices[dev.ID] = dev
+	}
+	var added []*types.Device
+	for _, id := range m.Added {
+		added = append(added, devices[id])
+	}
+
+	var key, list *yaml.Node
+	end := len(lines)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "devices" {
+			key, list = root.Content[i], root.Content[i+1]
+			if i+2 < len(root.Content) {
+				end = root.Content[i+2].Line - 1
+			}
+		}
+	}
+
+	var out []string
+	switch {
+	case key == nil:
+		if len(added) == 0 {
+			return nil
+		}
+		entries, err := renderDevices(added, -1)
+		if err != nil {
+			return err
+		}
+		out = append(append(lines, "devices:\n"), entries...)
+
+	case list.Kind == yaml.SequenceNode && list.Style&yaml.FlowStyle == 0 && len(list.Content) > 0:
+		for end > key.Line && isBlankOrComment(lines[end-1]) {
+			end--
+		}
+		out, err = m.editDeviceList(lines, key.Line, end, list.Content, devices, added)
+		if err != nil {
+			return err
+		}
+
+	case list.Line == key.Line && (list.Tag == "!!null" || (list.Kind == yaml.SequenceNode && len(list.Content) == 0)):
+		// "devices:", "devices: ~" or "devices: []"
+		if len(added) == 0 {
+			return nil
+		}
+		entries, err := renderDevices(added, -1)
+		if err != nil {
+			return err
+		}
+		out = append(out, lines[:key.Line-1]...)
+		out = append(out, "devices:\n")
+		out = append(out, entries...)
+		out = append(out, lines[key.Line:]...)
+
+	default:
+		return fmt.Errorf("failed to parse config: devices is not a list")
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(out, "")), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// editDeviceList rewrites the block list of devices between the "devices:"
+// line (1-based) and end (0-based, exclusive). Each entry spans from the
+// comments right above its dash to the next entry.
+func (m *DevicesMerge) editDeviceList(lines []string, keyLine, end int, entries []*yaml.Node, devices map[string]*types.Device, added []*types.Device) ([]string, error) {
+	removed := make(map[string]bool, len(m.Removed))
+	for _, id := range m.Removed {
+		removed[id] = true
+	}
+
+	starts := make([]int, len(entries))
+	dashes := make([]int, len(entries))
+	for i, entry := range entries {
+		dash := entry.Line - 1
+		for dash > keyLine && !strings.HasPrefix(strings.TrimSpace(lines[dash]), "-") {
+			dash--
+		}
+		start := dash
+		for start-1 > keyLine-1 && strings.HasPrefix(strings.TrimSpace(lines[start-1]), "#") {
+			start--
+		}
+		starts[i], dashes[i] = start, dash
+	}
+
+	out := append([]string(nil), lines[:starts[0]]...)
+	for i, entry := range entries {
+		stop := end
+		if i+1 < len(entries) {
+			stop = starts[i+1]
+		}
+		var dev struct {
+			ID string `yaml:"id"`
+		}
+		_ = entry.Decode(&dev)
+		if removed[dev.ID] {
+			continue
+		}
+		if _, changed := m.Changed[dev.ID]; !changed || devices[dev.ID] == nil {
+			out = append(out, lines[starts[i]:stop]...)
+			continue
+		}
+
+		body := stop
+		for body > dashes[i]+1 && isBlankOrComment(lines[body-1]) {
+			body--
+		}
+		entryLines, err := renderDevices([]*types.Device{devices[dev.ID]}, indentOf(lines[dashes[i]]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, lines[starts[i]:dashes[i]]...)
+		out = append(out, entryLines...)
+		out = append(out, lines[body:stop]...)
+	}
+
+	if len(added) > 0 {
+		entryLines, err := renderDevices(added, indentOf(lines[dashes[len(dashes)-1]]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entryLines...)
+	}
+	return append(out, lines[end:]...), nil
+}
+
+// renderDevices encodes devices as devices.yaml list entries, the dash at
+// column indent (-1 keeps the generated indentation)
+func renderDevices(devices []*types.Device, indent int) ([]string, error) {
+	data, err := yaml.Marshal(map[string]interface{}{"devices": devices})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal devices: %w", err)
+	}
+	lines := strings.SplitAfter(strings.TrimPrefix(string(data), "devices:\n"), "\n")
+	lines = lines[:len(lines)-1]
+	if indent < 0 {
+		return lines, nil
+	}
+
+	shift := indent - indentOf(lines[0])
+	for i, line := range lines {
+		switch {
+		case strings.TrimSpace(line) == "":
+		case shift > 0:
+			lines[i] = strings.Repeat(" ", shift) + line
+		case shift < 0:
+			lines[i] = line[min(-shift, indentOf(line)):]
+		}
+	}
+	return lines, nil
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isBlankOrComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// SaveLANReport writes the devices found by the LAN scan, with a devices.yaml
+// entry to copy for those that have a built-in template
+func SaveLANReport(devs []*types.LANDevice, path string) error {
+	data, err := yaml.Marshal(map[string]interface{}{"devices": devs})
+	if err != nil {
+		return fmt.Errorf("failed to marshal LAN report: %w", err)
+	}
+
+	header := fmt.Sprintf(`# Devices found on the LAN that don't publish on MQTT yet
+# Generated at: %s
+# Follow each hint, then copy the suggested entries into devices.yaml
+# Run 'homescript-server discover --lan' to regenerate
+
+`, time.Now().Format(time.RFC3339))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(path, append([]byte(header), data...), 0644); err != nil {
+		return fmt.Errorf("failed to write LAN report: %w", err)
+	}
+	return nil
+}
+
+// MergeHAConfigs adds configs to the HA discovery configs saved at path,
+// replacing those of the same device
+func MergeHAConfigs(configs map[string]*types.HomeAssistantDiscovery, path string) error {
+	saved, err := LoadHAConfigs(path)
+	if err != nil {
+		return err
+	}
+	for id, cfg := range configs {
+		saved[id] = cfg
+	}
+	return SaveHAConfigs(saved, path)
+}
+
 // LoadHAConfigs loads Home Assistant discovery configs from JSON file
 func LoadHAConfigs(path string) (map[string]*types.HomeAssistantDiscovery, error) {
 	data, err := os.ReadFile(path)
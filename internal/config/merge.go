@@ -0,0 +1,455 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// discoveredBaseFile keeps, next to devices.yaml, what the last 'discover'
+// found; it is the common ancestor for merging user edits with new results
+const discoveredBaseFile = ".discovered.yaml"
+
+// handMaintained are the fields kept from devices.yaml when there's no base
+// to tell user edits from discovered values (first run after an upgrade)
+var handMaintained = []string{"area", "aliases", "optimistic", "confirm", "protect", "mqtt.qos", "mqtt.retain"}
+
+// FieldChange is one device field that regeneration changes
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// Sources tells which discovery sources answered
+type Sources struct {
+	Topics types.Topics
+	// Bases are the Zigbee2MQTT and Frigate base topics whose instance
+	// answered (see discovery.AnsweredBases)
+	Bases []string
+}
+
+// DevicesMerge is the outcome of merging discovered devices into devices.yaml
+type DevicesMerge struct {
+	Config      *types.DevicesConfig
+	Added       []string
+	Removed     []string
+	Changed     map[string][]FieldChange
+	sources     Sources
+	removedFrom map[string]string
+	// base is saved as .discovered.yaml by Write
+	base []*types.Device
+}
+
+// MergeDiscovered merges a discovery result into devices.yaml as a three-way
+// merge against the previous discovery result: fields the user edited are
+// kept, the others follow discovery. Lists (attributes, actions, aliases)
+// keep entries the user added or removed. New devices are appended; a device
+// is removed only once it was discovered before and its source answered
+// this time without it: the Zigbee2MQTT or Frigate instance it is under, or
+// for the other integrations any device with the same ID prefix. Hand-added
+// devices, groups and virtual devices are never touched, and a device the
+// user deleted from devices.yaml isn't added back. Nothing is written; see Write.
+func MergeDiscovered(devices []*types.Device, sources Sources, path string) (*DevicesMerge, error) {
+	return mergeDevices(devices, sources, path, false)
+}
+
+// AddDiscovered merges only the devices that are new: those neither in
+// devices.yaml nor found by an earlier discovery, which the user deleted.
+// Existing entries are left as they are and nothing is removed. The added
+// devices join the base, so deleting them later sticks too.
+func AddDiscovered(devices []*types.Device, path string) (*DevicesMerge, error) {
+	return mergeDevices(devices, Sources{}, path, true)
+}
+
+func mergeDevices(devices []*types.Device, sources Sources, path string, addOnly bool) (*DevicesMerge, error) {
+	sources.Topics = sources.Topics.WithDefaults()
+	result := &DevicesMerge{
+		Config:      &types.DevicesConfig{Generated: time.Now()},
+		Changed:     make(map[string][]FieldChange),
+		sources:     sources,
+		removedFrom: make(map[string]string),
+	}
+	if !addOnly {
+		result.base = devices
+	}
+
+	existing, err := LoadDevicesYAML(path)
+	exists := err == nil
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		existing = &types.DevicesConfig{}
+	}
+	result.Config.Groups = existing.Groups
+	result.Config.Virtual = existing.Virtual
+
+	// Without devices.yaml the base is stale: everything starts over
+	base := make(map[string]map[string]interface{})
+	if prev, err := LoadDevicesYAML(filepath.Join(filepath.Dir(path), discoveredBaseFile)); err == nil && exists {
+		for _, dev := range prev.Devices {
+			if m, err := deviceMap(dev); err == nil {
+				base[dev.ID] = m
+			}
+		}
+		if addOnly {
+			result.base = prev.Devices
+		}
+	}
+
+	if addOnly {
+		result.Config.Devices = existing.Devices
+		known := make(map[string]bool, len(existing.Devices))
+		for _, dev := range existing.Devices {
+			known[dev.ID] = true
+		}
+		for _, dev := range devices {
+			if _, deleted := base[dev.ID]; known[dev.ID] || deleted {
+				continue
+			}
+			result.Config.Devices = append(result.Config.Devices, dev)
+			result.Added = append(result.Added, dev.ID)
+			result.base = append(result.base, dev)
+			known[dev.ID] = true
+		}
+		return result, nil
+	}
+
+	found := make(map[string]*types.Device, len(devices))
+	answered := make(map[string]bool)
+	for _, base := range sources.Bases {
+		answered[base] = true
+	}
+	for _, dev := range devices {
+		found[dev.ID] = dev
+		if _, ok := sources.Topics.DeviceBase(dev); !ok {
+			if source := result.sourceOf(dev); source != "" {
+				answered[source] = true
+			}
+		}
+	}
+
+	kept := make(map[string]bool)
+	for _, cur := range existing.Devices {
+		disc := found[cur.ID]
+		if disc == nil {
+			source := result.sourceOf(cur)
+			if _, wasFound := base[cur.ID]; wasFound && answered[source] {
+				result.Removed = append(result.Removed, cur.ID)
+				result.removedFrom[cur.ID] = source
+				continue
+			}
+			result.Config.Devices = append(result.Config.Devices, cur)
+			kept[cur.ID] = true
+			continue
+		}
+
+		curMap, err := deviceMap(cur)
+		if err != nil {
+			return nil, err
+		}
+		discMap, err := deviceMap(disc)
+		if err != nil {
+			return nil, err
+		}
+		baseMap, ok := base[cur.ID]
+		if !ok {
+			baseMap = assumedBase(curMap)
+		}
+
+		merged := merge3(baseMap, curMap, discMap).(map[string]interface{})
+		dev, err := deviceFromMap(merged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", cur.ID, err)
+		}
+		result.Config.Devices = append(result.Config.Devices, dev)
+		kept[cur.ID] = true
+
+		if changes := diffMaps("", curMap, merged); len(changes) > 0 {
+			result.Changed[cur.ID] = changes
+		}
+	}
+
+	for _, dev := range devices {
+		if _, deleted := base[dev.ID]; deleted && !kept[dev.ID] {
+			continue
+		}
+		if !kept[dev.ID] {
+			result.Config.Devices = append(result.Config.Devices, dev)
+			result.Added = append(result.Added, dev.ID)
+			kept[dev.ID] = true
+		}
+	}
+
+	return result, nil
+}
+
+// Write saves the merged devices.yaml and the discovery result as the base
+// for the next merge. devices.yaml is edited in place (see editDevicesYAML).
+func (m *DevicesMerge) Write(path string) error {
+	if err := m.editDevicesYAML(path); err != nil {
+		return err
+	}
+	base := &types.DevicesConfig{Devices: m.base, Generated: m.Config.Generated}
+	return writeDevicesYAML(base, filepath.Join(filepath.Dir(path), discoveredBaseFile))
+}
+
+// Diff describes what Write would change in devices.yaml, one line per
+// added or removed device and per changed field
+func (m *DevicesMerge) Diff() string {
+	var b strings.Builder
+	for _, id := range m.Added {
+		fmt.Fprintf(&b, "+ %s\n", id)
+	}
+	for _, id := range m.Removed {
+		fmt.Fprintf(&b, "- %s (gone from %s)\n", id, m.removedFrom[id])
+	}
+
+	ids := make([]string, 0, len(m.Changed))
+	for id := range m.Changed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(&b, "~ %s\n", id)
+		for _, c := range m.Changed[id] {
+			fmt.Fprintf(&b, "    %s: %s -> %s\n", c.Field, diffValue(c.Old), diffValue(c.New))
+		}
+	}
+	return b.String()
+}
+
+// Empty reports whether the merge leaves the devices unchanged
+func (m *DevicesMerge) Empty() bool {
+	return len(m.Added) == 0 && len(m.Removed) == 0 && len(m.Changed) == 0
+}
+
+// sourceOf names the discovery source of a device: the base topic of the
+// Zigbee2MQTT or Frigate instance it is under, otherwise its integration.
+// Zigbee2MQTT and Frigate devices under a base that is no longer configured
+// have no source ("") and are kept.
+func (m *DevicesMerge) sourceOf(dev *types.Device) string {
+	if base, ok := m.sources.Topics.DeviceBase(dev); ok {
+		return base
+	}
+	if integration := integrationOf(dev.ID); integration != "zigbee2mqtt" && integration != "frigate" {
+		return integration
+	}
+	return ""
+}
+
+// integrationOf names the integration a discovered device comes from, by
+// its ID prefix (Zigbee2MQTT devices have none)
+func integrationOf(id string) string {
+	if i := strings.Index(id, "/"); i > 0 {
+		return id[:i]
+	}
+	return "zigbee2mqtt"
+}
+
+// deviceMap converts a device to its devices.yaml form as plain maps
+func deviceMap(dev *types.Device) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(dev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", dev.ID, err)
+	}
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", dev.ID, err)
+	}
+	return m, nil
+}
+
+func deviceFromMap(m map[string]interface{}) (*types.Device, error) {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var dev types.Device
+	if err := yaml.Unmarshal(data, &dev); err != nil {
+		return nil, err
+	}
+	return &dev, nil
+}
+
+// assumedBase stands in for a missing base: every field is taken as
+// unedited except the hand-maintained ones
+func assumedBase(cur map[string]interface{}) map[string]interface{} {
+	base := copyMap(cur)
+	for _, field := range handMaintained {
+		parts := strings.Split(field, ".")
+		m := base
+		for _, p := range parts[:len(parts)-1] {
+			sub, ok := m[p].(map[string]interface{})
+			if !ok {
+				m = nil
+				break
+			}
+			m = sub
+		}
+		if m != nil {
+			delete(m, parts[len(parts)-1])
+		}
+	}
+	return base
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			v = copyMap(sub)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// merge3 merges the user's value cur and the discovered value disc of a
+// field whose previously discovered value was base
+func merge3(base, cur, disc interface{}) interface{} {
+	curMap, curOK := cur.(map[string]interface{})
+	discMap, discOK := disc.(map[string]interface{})
+	if curOK && discOK {
+		baseMap, _ := base.(map[string]interface{})
+		out := make(map[string]interface{})
+		for k := range unionKeys(curMap, discMap) {
+			if v := merge3(baseMap[k], curMap[k], discMap[k]); v != nil {
+				out[k] = v
+			}
+		}
+		return out
+	}
+
+	if curList, ok := scalarList(cur); ok {
+		if discList, ok := scalarList(disc); ok {
+			baseList, _ := scalarList(base)
+			return mergeLists(baseList, curList, discList)
+		}
+	}
+
+	if reflect.DeepEqual(cur, base) {
+		return disc
+	}
+	return cur
+}
+
+// mergeLists takes the discovered list, minus what the user removed from
+// the base, plus what the user added
+func mergeLists(base, cur, disc []interface{}) interface{} {
+	out := []interface{}{}
+	for _, v := range disc {
+		if contains(base, v) && !contains(cur, v) {
+			continue
+		}
+		out = append(out, v)
+	}
+	for _, v := range cur {
+		if !contains(base, v) && !contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func scalarList(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return nil, true
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	for _, item := range list {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		}
+	}
+	return list, true
+}
+
+func contains(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func unionKeys(a, b map[string]interface{}) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+// diffMaps lists the fields that differ between old and new, nested fields
+// as "mqtt.state_topic"
+func diffMaps(prefix string, old, new map[string]interface{}) []FieldChange {
+	keys := make([]string, 0)
+	for k := range unionKeys(old, new) {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var changes []FieldChange
+	for _, k := range keys {
+		field := prefix + k
+		o, n := old[k], new[k]
+		om, oOK := o.(map[string]interface{})
+		nm, nOK := n.(map[string]interface{})
+		if oOK && nOK {
+			changes = append(changes, diffMaps(field+".", om, nm)...)
+			continue
+		}
+		if isEmpty(o) && isEmpty(n) {
+			continue
+		}
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, FieldChange{Field: field, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	if list, ok := v.([]interface{}); ok {
+		return len(list) == 0
+	}
+	return false
+}
+
+func diffValue(v interface{}) string {
+	if v == nil {
+		return "(unset)"
+	}
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	// Lists and maps in flow style keep the diff to one line
+	node.Style = yaml.FlowStyle
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(data))
+}
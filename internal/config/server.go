@@ -0,0 +1,342 @@
+package config
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ServerConfig holds optional server settings from config/server.yaml
+type ServerConfig struct {
+	HTTP    HTTPConfig    `yaml:"http"`
+	Guest   GuestConfig   `yaml:"guest"`
+	Kiosk   KioskConfig   `yaml:"kiosk"`
+	Stream  StreamConfig  `yaml:"stream"`
+	Metrics MetricsConfig `yaml:"metrics"`
+	// Storage selects where state is kept (the bbolt file at --db if empty)
+	Storage StorageConfig `yaml:"storage"`
+	// Schedule serves today's sun times, time events and timers at /schedule
+	Schedule ScheduleConfig `yaml:"schedule"`
+	// Reload lets the CLI apply devices.yaml changes to the running server
+	Reload ReloadConfig `yaml:"reload"`
+	// Trigger lets the CLI fire time events and webhooks on the running server
+	Trigger TriggerConfig `yaml:"trigger"`
+	// API serves devices, state and timers as JSON under /api
+	API    APIConfig    `yaml:"api"`
+	Wizard WizardConfig `yaml:"wizard"`
+	MQTT   MQTTConfig   `yaml:"mqtt"`
+	Mirror MirrorConfig `yaml:"mirror"`
+	Enrich EnrichConfig `yaml:"enrich"`
+	// Topics overrides the integrations' base topics (zigbee2mqtt, frigate, homeassistant)
+	Topics  types.Topics  `yaml:"topics"`
+	Frigate FrigateConfig `yaml:"frigate"`
+	// Subscriptions are raw MQTT topics routed to events/mqtt/
+	Subscriptions []SubscriptionConfig `yaml:"subscriptions"`
+	HomeAssistant HomeAssistantConfig  `yaml:"homeassistant"`
+	Summary       SummaryConfig        `yaml:"summary"`
+	Discovery     DiscoveryConfig      `yaml:"discovery"`
+}
+
+// DiscoveryConfig controls device discovery while the server runs
+type DiscoveryConfig struct {
+	// Background keeps discovery running and adds new devices to
+	// devices.yaml, routing a system/device_discovered event for each
+	Background bool `yaml:"background"`
+	// Include keeps only devices matching one of the rules (all if empty)
+	Include []types.DiscoveryRule `yaml:"include"`
+	// Exclude drops devices matching any of the rules
+	Exclude []types.DiscoveryRule `yaml:"exclude"`
+}
+
+// SummaryConfig has a local language model (Ollama) write a daily summary of
+// the event journal, routed to events/custom/daily_summary/ and optionally
+// posted to a webhook (disabled if Model is empty; requires the journal)
+type SummaryConfig struct {
+	// Model served by Ollama, e.g. llama3.2
+	Model string `yaml:"model"`
+	// URL of the Ollama API (default http://localhost:11434)
+	URL string `yaml:"url"`
+	// At is the local time of the summary, covering the 24 hours before (default 21:00)
+	At string `yaml:"at"`
+	// Prompt replaces the default instructions given to the model
+	Prompt string `yaml:"prompt"`
+	// Webhook receives the summary as JSON (optional)
+	Webhook      string `yaml:"webhook"`
+	WebhookToken string `yaml:"webhook_token"`
+}
+
+// HomeAssistantConfig controls Home Assistant MQTT discovery at runtime
+type HomeAssistantConfig struct {
+	// Discovery turns every entity announced under the discovery prefix
+	// (Tasmota, ESPHome, ...) into a device while the server runs
+	Discovery bool `yaml:"discovery"`
+	// WebSocket imports the entities of a Home Assistant instance that are
+	// not on MQTT and forwards their commands as service calls
+	WebSocket HAWebSocketConfig `yaml:"websocket"`
+}
+
+// HAWebSocketConfig connects to the Home Assistant WebSocket API (disabled
+// if URL is empty)
+type HAWebSocketConfig struct {
+	// URL of Home Assistant, e.g. http://homeassistant.local:8123
+	URL string `yaml:"url"`
+	// Token is a long-lived access token
+	Token string `yaml:"token"`
+	// Domains to import, e.g. [light, climate] (most controllable and sensor domains if empty)
+	Domains []string `yaml:"domains"`
+	// Exclude skips entities matching any of these globs, e.g. "sensor.*_rssi"
+	Exclude []string `yaml:"exclude"`
+}
+
+// SubscriptionConfig subscribes to a topic or filter not belonging to a
+// device (doorbell firmware, OwnTracks); messages run the scripts in
+// events/mqtt/<topic>/, or events/mqtt/<handler>/ if handler is set
+type SubscriptionConfig struct {
+	Topic   string `yaml:"topic"`
+	Handler string `yaml:"handler"`
+	QoS     byte   `yaml:"qos"`
+}
+
+// FrigateConfig sets when Frigate cameras count as degraded, from the frame
+// rates in frigate/stats (0 disables a check)
+type FrigateConfig struct {
+	MinCameraFPS    float64 `yaml:"min_camera_fps"`
+	MinDetectionFPS float64 `yaml:"min_detection_fps"`
+}
+
+// EnrichConfig attaches device metadata to device events as event.data.meta
+type EnrichConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Fields to attach: name, area, type, vendor, model, previous (all if empty)
+	Fields []string `yaml:"fields"`
+	// PreviousDefaults is the previous value per attribute for a device's
+	// first report of it, e.g. {occupancy: false, contact: true}
+	PreviousDefaults map[string]interface{} `yaml:"previous_defaults"`
+}
+
+// MirrorConfig replicates state and history to a standby instance and/or an
+// S3-compatible bucket, and lets a standby accept them
+type MirrorConfig struct {
+	// Interval between pushes; unchanged snapshots are skipped (default 5m)
+	Interval time.Duration `yaml:"interval"`
+	// URL of a standby's mirror endpoint, e.g. http://standby:8080/mirror
+	URL string `yaml:"url"`
+	// Token sent to the standby (its accept_token)
+	Token string         `yaml:"token"`
+	S3    MirrorS3Config `yaml:"s3"`
+	// AcceptToken makes this instance a standby accepting POST /mirror
+	// (requires http.listen; disabled if empty)
+	AcceptToken string `yaml:"accept_token"`
+}
+
+// MirrorS3Config uploads snapshots to an S3-compatible bucket (disabled if Bucket is empty)
+type MirrorS3Config struct {
+	// Endpoint, e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Endpoint string `yaml:"endpoint"`
+	Bucket   string `yaml:"bucket"`
+	// Key of the object (default homescript/mirror.json)
+	Key string `yaml:"key"`
+	// Region used for signing (default us-east-1)
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// MQTTConfig holds broker connection settings not covered by flags
+type MQTTConfig struct {
+	TLS MQTTTLSConfig `yaml:"tls"`
+	// ClientID is a stable client ID for 'run' with a persistent session, so
+	// QoS 1 messages queued while the server is down survive restarts; the
+	// --mqtt-client-id flag takes precedence (a fresh, clean session with a
+	// timestamped ID if both are empty)
+	ClientID string `yaml:"client_id"`
+	// SharedGroup subscribes the topics of 'subscriptions' as
+	// $share/<group>/... so instances running the same configuration split
+	// those messages (run only, disabled if empty)
+	SharedGroup string `yaml:"shared_group"`
+	// Consolidate subscribes device state topics through one wildcard per
+	// topic pattern (zigbee2mqtt/+) once at least this many devices share it
+	// (disabled if 0)
+	Consolidate int `yaml:"consolidate"`
+}
+
+// MQTTTLSConfig configures TLS for ssl:// brokers; the --mqtt-ca, --mqtt-cert,
+// --mqtt-key and --mqtt-insecure flags take precedence
+type MQTTTLSConfig struct {
+	// CACert is a PEM file with the CA of the broker certificate (system roots if empty)
+	CACert string `yaml:"ca_cert"`
+	// ClientCert and ClientKey are PEM files for brokers requiring client certificates
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// InsecureSkipVerify accepts any broker certificate (for testing only)
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// HTTPConfig configures the embedded HTTP server
+type HTTPConfig struct {
+	// Listen address, e.g. ":8080" (HTTP server disabled if empty)
+	Listen string `yaml:"listen"`
+	// TLSCert and TLSKey are PEM files; both set serves HTTPS instead
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+// GuestConfig configures the read-only guest view
+type GuestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Token required as ?token= or Bearer header (unauthenticated if empty)
+	Token string `yaml:"token"`
+	// Devices visible to guests (whitelist)
+	Devices []string `yaml:"devices"`
+}
+
+// StreamConfig configures filtered state change streams for low-power clients
+type StreamConfig struct {
+	// Enabled serves the WebSocket stream at /stream (requires http.listen
+	// and API credentials, read scope)
+	Enabled bool `yaml:"enabled"`
+	// MQTTPrefix enables the MQTT stream under <prefix>/subscribe (disabled if empty)
+	MQTTPrefix string `yaml:"mqtt_prefix"`
+}
+
+// MetricsConfig configures per-device metrics on the HTTP server
+type MetricsConfig struct {
+	// Enabled serves /metrics and /metrics/devices (requires http.listen and
+	// API credentials, read scope)
+	Enabled bool `yaml:"enabled"`
+}
+
+// StorageConfig keeps state in a SQL database instead of the bbolt file,
+// e.g. for SQL queries or a database on network storage
+type StorageConfig struct {
+	// Driver is a database/sql driver compiled into the binary ("sqlite",
+	// "postgres", ...); bbolt at --db if empty
+	Driver string `yaml:"driver"`
+	// DSN is the driver's data source name
+	DSN string `yaml:"dsn"`
+	// Backup copies the bbolt file while the server runs
+	Backup BackupConfig `yaml:"backup"`
+	// BatchWindow commits the writes within this window together, for
+	// SD cards and slow disks (every write is committed at once if 0)
+	BatchWindow time.Duration `yaml:"batch_window"`
+}
+
+// BackupConfig configures copies of the bbolt state file, taken every
+// Interval and by system.backup()
+type BackupConfig struct {
+	// Dir receives state-<timestamp>.db copies (default: backups/ next to --db)
+	Dir string `yaml:"dir"`
+	// Interval between scheduled backups (none if 0)
+	Interval time.Duration `yaml:"interval"`
+	// Keep is how many copies are kept, oldest deleted first (default 7, -1 for all)
+	Keep int `yaml:"keep"`
+	// Compact shrinks the database file after each scheduled backup
+	Compact bool `yaml:"compact"`
+}
+
+// ScheduleConfig configures the "what will happen today" view on the HTTP
+// server (used by 'schedule')
+type ScheduleConfig struct {
+	// Enabled serves /schedule (requires http.listen and API credentials,
+	// read scope)
+	Enabled bool `yaml:"enabled"`
+}
+
+// TriggerConfig configures simulated events on the HTTP server (used by
+// 'trigger')
+type TriggerConfig struct {
+	// Enabled serves POST /trigger/... (requires http.listen and API
+	// credentials, control scope)
+	Enabled bool `yaml:"enabled"`
+}
+
+// APIConfig configures the REST API for UIs and external integrations
+type APIConfig struct {
+	// Enabled serves /api/... (requires http.listen; disabled without
+	// any token or user). The credentials also guard /stream, /metrics,
+	// /schedule, /trigger and /reload, with or without Enabled.
+	Enabled bool `yaml:"enabled"`
+	// Token allows everything, as ?token= or Bearer header
+	Token string `yaml:"token"`
+	// EventsToken is accepted by POST /api/events/<type> only, for
+	// doorbells, phones and third-party services
+	EventsToken string `yaml:"events_token"`
+	// Tokens are further bearer tokens with a scope
+	Tokens []APIToken `yaml:"tokens"`
+	// Users log in with basic auth
+	Users []APIUser `yaml:"users"`
+	// LogLines is how many recent log lines GET /api/logs keeps (default 500)
+	LogLines int `yaml:"log_lines"`
+}
+
+// APIToken is a bearer token of the API. Scope is read (GET endpoints, the
+// default), control (everything) or events (POST /api/events only).
+type APIToken struct {
+	Name  string `yaml:"name"` // shown in the log when refused
+	Token string `yaml:"token"`
+	Scope string `yaml:"scope"`
+}
+
+// APIUser is a basic auth login of the API, with a scope like APIToken
+type APIUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Scope    string `yaml:"scope"`
+}
+
+// ReloadConfig configures reloading the configuration over the HTTP server
+// (used by 'reload'; SIGHUP reloads regardless)
+type ReloadConfig struct {
+	// Enabled serves POST /reload (requires http.listen and API credentials,
+	// control scope)
+	Enabled bool `yaml:"enabled"`
+}
+
+// WizardConfig configures the "new automation" wizard at /wizard, which
+// writes handler scripts, and the webhook triggers it creates (POST /webhook/<name>)
+type WizardConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Token required for the wizard and webhooks (wizard disabled if empty)
+	Token string `yaml:"token"`
+}
+
+// KioskConfig configures simple GET action endpoints for dumb clients
+// (NFC tags, Stream Deck buttons): GET /kiosk/<name>?token=...
+type KioskConfig struct {
+	// Token required for every kiosk request (kiosk disabled if empty)
+	Token   string                 `yaml:"token"`
+	Actions map[string]KioskAction `yaml:"actions"`
+}
+
+// KioskAction is what a kiosk endpoint does; exactly one of Action, Set, Scene or Event is used
+type KioskAction struct {
+	Device string                 `yaml:"device,omitempty"`
+	Action string                 `yaml:"action,omitempty"` // device action script (device.call)
+	Params map[string]interface{} `yaml:"params,omitempty"` // parameters for Action
+	Set    map[string]interface{} `yaml:"set,omitempty"`    // attributes for device.set
+	Scene  string                 `yaml:"scene,omitempty"`  // scene to activate
+	Event  string                 `yaml:"event,omitempty"`  // custom event to emit
+	Data   map[string]interface{} `yaml:"data,omitempty"`   // data for Event
+}
+
+// LoadServerConfig loads server settings; a missing file yields defaults
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	var config ServerConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &config, nil
+		}
+		return nil, fmt.Errorf("failed to read server config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse server config: %w", err)
+	}
+
+	return &config, nil
+}
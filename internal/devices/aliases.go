@@ -0,0 +1,126 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"strings"
+)
+
+// addAliases registers a device's aliases (m.mu must be held)
+func (m *Manager) addAliases(dev *types.Device) {
+	for _, alias := range dev.Aliases {
+		if _, isDevice := m.devices[alias]; isDevice {
+			log.Warn("Alias %s of device %s is the ID of another device, ignored", alias, dev.ID)
+			continue
+		}
+		if other, taken := m.aliases[alias]; taken && other != dev.ID {
+			log.Warn("Alias %s of device %s is already used by %s, ignored", alias, dev.ID, other)
+			continue
+		}
+		m.aliases[alias] = dev.ID
+	}
+}
+
+// resolve maps an alias to its device ID; other IDs are returned as is (m.mu must be held)
+func (m *Manager) resolve(id string) string {
+	if _, ok := m.devices[id]; ok {
+		return id
+	}
+	if target, ok := m.aliases[id]; ok {
+		return target
+	}
+	return id
+}
+
+// Aliases returns the aliases of a device (used by the router to also run
+// handlers in events/device/<alias>/)
+func (m *Manager) Aliases(id string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dev, ok := m.devices[m.resolve(id)]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), dev.Aliases...)
+}
+
+// RenameZigbee2MQTT follows a Zigbee2MQTT friendly_name change: the device
+// with state topic <base>/<from> moves to <base>/<to> and gets the ID newID,
+// keeping its old ID as an alias. The renamed device replaces the old one
+// (handlers subscribed for the old device must be replaced too).
+func (m *Manager) RenameZigbee2MQTT(base, from, to, newID string) (renamed *types.Device, oldID string, err error) {
+	oldTopic := base + "/" + from
+	newTopic := base + "/" + to
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var old *types.Device
+	for _, dev := range m.devices {
+		if dev.MQTT.StateTopic == oldTopic {
+			old = dev
+			break
+		}
+	}
+	if old == nil {
+		return nil, "", fmt.Errorf("no device with state topic %s", oldTopic)
+	}
+	if existing, ok := m.devices[newID]; ok && existing != old {
+		return nil, "", fmt.Errorf("device %s already exists", newID)
+	}
+
+	dev := *old
+	dev.ID = newID
+	if dev.Name == "" || dev.Name == from {
+		dev.Name = to
+	}
+	dev.MQTT.StateTopic = newTopic
+	dev.MQTT.CommandTopic = replaceTopicPrefix(old.MQTT.CommandTopic, oldTopic, newTopic)
+	dev.MQTT.AvailabilityTopic = replaceTopicPrefix(old.MQTT.AvailabilityTopic, oldTopic, newTopic)
+
+	dev.Aliases = nil
+	for _, alias := range old.Aliases {
+		if alias != newID {
+			dev.Aliases = append(dev.Aliases, alias)
+		}
+	}
+	if old.ID != newID {
+		dev.Aliases = append(dev.Aliases, old.ID)
+	}
+
+	// Re-key everything kept per device
+	delete(m.devices, old.ID)
+	m.devices[newID] = &dev
+	if state, ok := m.states[old.ID]; ok {
+		delete(m.states, old.ID)
+		m.states[newID] = state
+	}
+	if t, ok := m.lastSeen[old.ID]; ok {
+		delete(m.lastSeen, old.ID)
+		m.lastSeen[newID] = t
+	}
+	if m.stale[old.ID] {
+		delete(m.stale, old.ID)
+		m.stale[newID] = true
+	}
+	if _, ok := m.configured[old.ID]; ok {
+		delete(m.configured, old.ID)
+		m.configured[newID] = &dev
+	}
+	delete(m.aliases, newID)
+	for _, alias := range dev.Aliases {
+		m.aliases[alias] = newID
+	}
+
+	log.Info("Device %s renamed in Zigbee2MQTT (%s -> %s), now %s", old.ID, from, to, newID)
+	return &dev, old.ID, nil
+}
+
+// replaceTopicPrefix swaps the device part of a topic ("zigbee2mqtt/Old/set" -> "zigbee2mqtt/New/set")
+func replaceTopicPrefix(topic, oldPrefix, newPrefix string) string {
+	if topic == oldPrefix || strings.HasPrefix(topic, oldPrefix+"/") {
+		return newPrefix + strings.TrimPrefix(topic, oldPrefix)
+	}
+	return topic
+}
@@ -0,0 +1,102 @@
+package devices
+
+import (
+	"encoding/json"
+	"homescript-server/internal/types"
+	"strings"
+)
+
+// AvailabilityAttribute is the state attribute holding "online" or "offline"
+const AvailabilityAttribute = "availability"
+
+// Zigbee2MQTTBridgeState is the Zigbee2MQTT bridge LWT under the base topic
+// (zigbee2mqtt/bridge/state); when it reports offline every device of that
+// Zigbee2MQTT instance is unreachable
+const Zigbee2MQTTBridgeState = "bridge/state"
+
+// AvailabilityTopic returns the topic reporting a device's availability:
+// the configured availability_topic, or <state_topic>/availability for
+// Zigbee2MQTT devices. Empty if the device has none.
+func (m *Manager) AvailabilityTopic(dev *types.Device) string {
+	if dev.MQTT.AvailabilityTopic != "" {
+		return dev.MQTT.AvailabilityTopic
+	}
+	if _, ok := m.Zigbee2MQTTBase(dev); ok {
+		return dev.MQTT.StateTopic + "/availability"
+	}
+	return ""
+}
+
+// Zigbee2MQTTBase returns the base topic of the Zigbee2MQTT instance
+// managing a device, if it is a Zigbee2MQTT device
+func (m *Manager) Zigbee2MQTTBase(dev *types.Device) (string, bool) {
+	return m.Topics().Zigbee2MQTTBase(dev.MQTT.StateTopic)
+}
+
+// ParseAvailability understands Zigbee2MQTT ({"state":"online"} or "online")
+// and LWT-style payloads ("Online"/"Offline", "true"/"false", "1"/"0")
+func ParseAvailability(payload []byte) (online bool, ok bool) {
+	value := strings.TrimSpace(string(payload))
+
+	var obj struct {
+		State string `json:"state"`
+	}
+	if json.Unmarshal(payload, &obj) == nil && obj.State != "" {
+		value = obj.State
+	}
+
+	switch strings.ToLower(strings.Trim(value, `"`)) {
+	case "online", "true", "1", "connected":
+		return true, true
+	case "offline", "false", "0", "disconnected":
+		return false, true
+	}
+	return false, false
+}
+
+// SetAvailability records whether a device is reachable and reports whether it changed.
+// The value is kept as the "availability" attribute ("online"/"offline").
+func (m *Manager) SetAvailability(id string, online bool) bool {
+	value := "offline"
+	if online {
+		value = "online"
+	}
+
+	m.mu.Lock()
+	id = m.resolve(id)
+	if _, ok := m.devices[id]; !ok {
+		m.mu.Unlock()
+		return false
+	}
+	if m.states[id] == nil {
+		m.states[id] = make(map[string]interface{})
+	}
+	if m.states[id][AvailabilityAttribute] == value {
+		m.mu.Unlock()
+		return false
+	}
+	m.states[id][AvailabilityAttribute] = value
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	state := map[string]interface{}{AvailabilityAttribute: value}
+	for _, listener := range listeners {
+		listener(id, state)
+	}
+
+	log.Info("Device %s is %s", id, value)
+	return true
+}
+
+// IsOnline reports whether a device is reachable; known is false if the
+// device never reported availability
+func (m *Manager) IsOnline(id string) (online bool, known bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.states[m.resolve(id)][AvailabilityAttribute]
+	if !ok {
+		return false, false
+	}
+	return value == "online", true
+}
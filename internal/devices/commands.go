@@ -0,0 +1,91 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"sort"
+	"strings"
+)
+
+// Command is an MQTT message published by device.set
+type Command struct {
+	// Attribute set by the message, empty if it carries all of them
+	Attribute string
+	Topic     string
+	Payload   []byte
+}
+
+// Commands returns the messages that set attrs on an MQTT device: one per
+// attribute for templated devices and Frigate cameras, otherwise a JSON
+// object on the command topic
+func Commands(dev *types.Device, attrs map[string]interface{}) ([]Command, error) {
+	// Templated devices publish per attribute as described by their template
+	if len(dev.Commands) > 0 {
+		return templateCommands(dev, attrs)
+	}
+
+	// Special handling for Frigate cameras - each attribute needs separate topic
+	if dev.Type == "camera" && dev.Vendor == "Frigate NVR" {
+		// Frigate requires publishing to frigate/{camera}/{attribute}/set
+		// CommandTopic is like "frigate/CameraName"
+		commands := make([]Command, 0, len(attrs))
+		for _, attr := range sortedAttributes(attrs) {
+			// Frigate expects "ON"/"OFF" or numeric strings
+			commands = append(commands, Command{
+				Attribute: attr,
+				Topic:     fmt.Sprintf("%s/%s/set", dev.MQTT.CommandTopic, attr),
+				Payload:   []byte(payloadString(attrs[attr])),
+			})
+		}
+		return commands, nil
+	}
+
+	// Default behavior for non-Frigate devices - publish JSON to single command topic
+	payload, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	return []Command{{Topic: dev.MQTT.CommandTopic, Payload: payload}}, nil
+}
+
+// templateCommands renders each attribute with the device's template commands
+func templateCommands(dev *types.Device, attrs map[string]interface{}) ([]Command, error) {
+	commands := make([]Command, 0, len(attrs))
+	for _, attr := range sortedAttributes(attrs) {
+		value := attrs[attr]
+		cmd := dev.Commands[attr]
+		if cmd == nil {
+			cmd = dev.Commands["*"]
+		}
+		if cmd == nil {
+			return nil, fmt.Errorf("device %s has no command for attribute %s", dev.ID, attr)
+		}
+
+		var payload []byte
+		if cmd.Payload == "" {
+			data, err := json.Marshal(map[string]interface{}{attr: value})
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal payload: %w", err)
+			}
+			payload = data
+		} else {
+			text := payloadString(value)
+			if mapped, ok := cmd.Values[text]; ok {
+				text = mapped
+			}
+			payload = []byte(strings.ReplaceAll(strings.ReplaceAll(cmd.Payload, "{attribute}", attr), "{value}", text))
+		}
+		commands = append(commands, Command{Attribute: attr, Topic: cmd.Topic, Payload: payload})
+	}
+	return commands, nil
+}
+
+func sortedAttributes(attrs map[string]interface{}) []string {
+	names := make([]string, 0, len(attrs))
+	for attr := range attrs {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	return names
+}
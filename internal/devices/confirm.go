@@ -0,0 +1,230 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"strings"
+	"time"
+)
+
+// CommandFailedAttribute is the event attribute of unconfirmed commands,
+// routed to events/device/<id>/command_failed/
+const CommandFailedAttribute = "command_failed"
+
+// SetOptions control how device.set follows up on a command
+type SetOptions struct {
+	// Optimistic writes the commanded values to the cached state right away
+	Optimistic bool
+	// ConfirmTimeout > 0 waits this long for the device to report the values
+	ConfirmTimeout time.Duration
+	// Retries is how often an unconfirmed command is resent
+	Retries int
+	// QoS and Retain of the published command
+	QoS    byte
+	Retain bool
+	// Origin is where the command came from for the command echo, e.g. the
+	// sending script relative to the config directory
+	Origin string
+	// CorrelationID is the ID of the event chain that sent the command, for
+	// the events the command causes
+	CorrelationID string
+}
+
+// DefaultSetOptions returns the options configured for a device in devices.yaml
+func DefaultSetOptions(dev *types.Device) SetOptions {
+	opts := SetOptions{Optimistic: dev.Optimistic, QoS: dev.MQTT.QoS, Retain: dev.MQTT.Retain}
+	if dev.Confirm != nil {
+		opts.ConfirmTimeout = dev.Confirm.Timeout
+		opts.Retries = dev.Confirm.Retries
+	}
+	return opts
+}
+
+// pendingConfirm is a command waiting for its device to report the new values
+type pendingConfirm struct {
+	dev      *types.Device
+	attrs    map[string]interface{}
+	opts     SetOptions
+	seq      uint64 // the command's place in the device's queue
+	attempt  int
+	timer    *time.Timer
+	previous map[string]interface{} // cached values before an optimistic update
+}
+
+// SetWithOptions is Set with explicit optimistic/confirmation options instead
+// of the device defaults
+func (m *Manager) SetWithOptions(id string, attrs map[string]interface{}, opts SetOptions) error {
+	m.mu.RLock()
+	dev, ok := m.devices[m.resolve(id)]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("device not found: %s", id)
+	}
+	return m.set(dev, attrs, opts)
+}
+
+// applyOptimistic writes attrs to the cached state, notifying the state
+// listeners as for a report, and returns the values they replaced
+func (m *Manager) applyOptimistic(id string, attrs map[string]interface{}) map[string]interface{} {
+	m.mu.Lock()
+	previous := make(map[string]interface{}, len(attrs))
+	for k := range attrs {
+		previous[k] = m.states[id][k]
+	}
+	listeners := m.mergeState(id, attrs)
+	m.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(id, attrs)
+	}
+	return previous
+}
+
+// expectConfirm starts waiting for the device to confirm attrs; a newer
+// command for the same device replaces the pending one
+func (m *Manager) expectConfirm(dev *types.Device, cmd *command, previous map[string]interface{}) {
+	opts := cmd.opts
+	p := &pendingConfirm{dev: dev, attrs: cmd.attrs, opts: opts, seq: cmd.seq, previous: previous}
+
+	m.mu.Lock()
+	if old := m.pending[dev.ID]; old != nil {
+		old.timer.Stop()
+	}
+	m.pending[dev.ID] = p
+	p.timer = time.AfterFunc(opts.ConfirmTimeout, func() { m.confirmTimeout(p) })
+	m.mu.Unlock()
+}
+
+// checkConfirm completes the pending command of a device if state confirms it
+func (m *Manager) checkConfirm(id string, state map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.pending[id]
+	if p == nil || !confirmsAll(p.attrs, state) {
+		return
+	}
+	p.timer.Stop()
+	delete(m.pending, id)
+	log.Debug("Device %s confirmed %v", id, p.attrs)
+}
+
+// confirmTimeout resends an unconfirmed command or gives up on it
+func (m *Manager) confirmTimeout(p *pendingConfirm) {
+	m.mu.Lock()
+	if m.pending[p.dev.ID] != p {
+		m.mu.Unlock()
+		return // confirmed or replaced meanwhile
+	}
+
+	if p.attempt < p.opts.Retries {
+		p.attempt++
+		m.mu.Unlock()
+
+		log.Warn("[%s] Device %s did not confirm %v, resending (%d/%d)", p.opts.CorrelationID, p.dev.ID, p.attrs, p.attempt, p.opts.Retries)
+		if err := m.enqueue(p.dev, &command{attrs: p.attrs, opts: p.opts, resend: p}); err != nil {
+			log.Error("[%s] Failed to resend command to %s: %v", p.opts.CorrelationID, p.dev.ID, err)
+		}
+		return
+	}
+
+	delete(m.pending, p.dev.ID)
+	// Undo the optimistic update unless the device reported something else
+	// meanwhile; attributes it added are removed (nil for the listeners)
+	restored := make(map[string]interface{})
+	if state := m.states[p.dev.ID]; p.previous != nil && state != nil {
+		for k, v := range p.attrs {
+			if fmt.Sprint(state[k]) == fmt.Sprint(v) {
+				restored[k] = p.previous[k]
+			}
+		}
+	}
+	var listeners []StateListener
+	if len(restored) > 0 {
+		listeners = m.mergeState(p.dev.ID, restored)
+		for k, v := range restored {
+			if v == nil {
+				delete(m.states[p.dev.ID], k)
+			}
+		}
+	}
+	router := m.router
+	m.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(p.dev.ID, restored)
+	}
+
+	attempts := p.attempt + 1
+	log.Warn("[%s] Device %s did not confirm %v after %d attempt(s)", p.opts.CorrelationID, p.dev.ID, p.attrs, attempts)
+	if router != nil {
+		router.RouteEvent(&types.Event{
+			Source:    "device",
+			Type:      CommandFailedAttribute,
+			Device:    p.dev.ID,
+			Attribute: CommandFailedAttribute,
+			Area:      p.dev.Area,
+			Data: map[string]interface{}{
+				"attributes": p.attrs,
+				"attempts":   attempts,
+			},
+			Timestamp:     time.Now(),
+			CorrelationID: p.opts.CorrelationID,
+		})
+	}
+}
+
+// resend publishes an unconfirmed command again once its turn in the
+// device's queue came, through the protect limits like any command. It is
+// dropped if a newer command was queued for the device meanwhile.
+func (m *Manager) resend(dev *types.Device, p *pendingConfirm) error {
+	m.queueMu.Lock()
+	newer := m.queued[dev.ID] != p.seq
+	m.queueMu.Unlock()
+
+	m.mu.Lock()
+	if m.pending[dev.ID] != p {
+		m.mu.Unlock()
+		return nil // confirmed meanwhile
+	}
+	if newer {
+		delete(m.pending, dev.ID)
+		m.mu.Unlock()
+		log.Debug("[%s] Dropping resend to %s, a newer command was queued", p.opts.CorrelationID, dev.ID)
+		return nil
+	}
+	m.mu.Unlock()
+
+	switching, err := m.checkProtect(dev, p.attrs, p.opts.CorrelationID)
+	if err == nil {
+		start := time.Now()
+		if err = m.publish(dev, p.attrs, p.opts); err == nil && switching {
+			m.recordSwitch(dev.ID, start)
+		}
+	}
+
+	// Wait for the confirmation again, giving up after the last attempt
+	m.mu.Lock()
+	if m.pending[dev.ID] == p {
+		p.timer = time.AfterFunc(p.opts.ConfirmTimeout, func() { m.confirmTimeout(p) })
+	}
+	m.mu.Unlock()
+	return err
+}
+
+// confirmsAll reports whether state contains at least one of attrs and every
+// reported one has the commanded value
+func confirmsAll(attrs, state map[string]interface{}) bool {
+	reported := false
+	for attr, want := range attrs {
+		got, ok := state[attr]
+		if !ok {
+			continue
+		}
+		if !strings.EqualFold(fmt.Sprint(got), fmt.Sprint(want)) {
+			return false
+		}
+		reported = true
+	}
+	return reported
+}
@@ -0,0 +1,96 @@
+package devices
+
+import (
+	"homescript-server/internal/types"
+	"testing"
+	"time"
+)
+
+func confirmedDevice(protect *types.ProtectConfig) *types.Device {
+	return &types.Device{
+		ID:      "heater",
+		MQTT:    types.MQTTConfig{CommandTopic: "heater/set"},
+		Confirm: &types.ConfirmConfig{Timeout: 20 * time.Millisecond, Retries: 1},
+		Protect: protect,
+	}
+}
+
+// waitForEvent waits for router to have routed n events
+func waitForEvent(t *testing.T, router *eventRecorder, n int) []*types.Event {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if events := router.routed(); len(events) >= n {
+			return events
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("routed %d events, want %d", len(router.routed()), n)
+	return nil
+}
+
+func TestConfirmResendAndGiveUp(t *testing.T) {
+	client := &publishClient{}
+	dev := confirmedDevice(nil)
+	m := New(client, []*types.Device{dev})
+	router := &eventRecorder{}
+	m.SetRouter(router)
+
+	opts := DefaultSetOptions(dev)
+	opts.CorrelationID = "abc123"
+	if err := m.SetWithOptions("heater", map[string]interface{}{"state": "ON"}, opts); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	e := waitForEvent(t, router, 1)[0]
+	if e.Type != CommandFailedAttribute || e.CorrelationID != "abc123" {
+		t.Errorf("routed %s event with correlation ID %q, want %s with abc123", e.Type, e.CorrelationID, CommandFailedAttribute)
+	}
+	if n := client.publishes(); n != 2 {
+		t.Errorf("published %d times, want the command and one resend", n)
+	}
+}
+
+func TestConfirmResendDroppedForNewerCommand(t *testing.T) {
+	client := &publishClient{}
+	dev := confirmedDevice(nil)
+	m := New(client, []*types.Device{dev})
+	router := &eventRecorder{}
+	m.SetRouter(router)
+
+	if err := m.Set("heater", map[string]interface{}{"state": "ON"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	// A newer command without confirmation leaves the first one pending
+	if err := m.SetWithOptions("heater", map[string]interface{}{"state": "OFF"}, SetOptions{}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if n := client.publishes(); n != 2 {
+		t.Errorf("published %d times, want 2 without a resend of the older command", n)
+	}
+	if events := router.routed(); len(events) != 0 {
+		t.Errorf("routed %s, want no event for the superseded command", events[0].Type)
+	}
+}
+
+func TestConfirmResendProtected(t *testing.T) {
+	client := &publishClient{}
+	dev := confirmedDevice(&types.ProtectConfig{MinInterval: time.Hour})
+	m := New(client, []*types.Device{dev})
+	router := &eventRecorder{}
+	m.SetRouter(router)
+
+	opts := DefaultSetOptions(dev)
+	opts.CorrelationID = "abc123"
+	if err := m.SetWithOptions("heater", map[string]interface{}{"state": "ON"}, opts); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	events := waitForEvent(t, router, 2)
+	if events[0].Type != CommandBlockedAttribute || events[1].Type != CommandFailedAttribute {
+		t.Errorf("routed %s and %s, want %s and %s", events[0].Type, events[1].Type, CommandBlockedAttribute, CommandFailedAttribute)
+	}
+	if n := client.publishes(); n != 1 {
+		t.Errorf("published %d times, want the resend blocked within the minimum interval", n)
+	}
+}
@@ -0,0 +1,121 @@
+package devices
+
+import (
+	"homescript-server/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dialect normalizes a vendor-specific state payload in place
+type Dialect func(dev *types.Device, state map[string]interface{})
+
+var (
+	dialects = map[string][]Dialect{
+		"lowercase_onoff": {normalizeOnOff},
+		"comma_decimal":   {normalizeCommaDecimal},
+		"tuya":            {normalizeTuyaDatapoints, normalizeOnOff, normalizeCommaDecimal},
+	}
+
+	dialectsMu sync.RWMutex
+)
+
+// RegisterDialect adds or replaces a named dialect made of one or more normalization steps
+func RegisterDialect(name string, steps ...Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	dialects[name] = steps
+}
+
+// DialectNames returns all registered dialect names
+func DialectNames() []string {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NormalizePayload applies the device's dialect (`dialect:` in devices.yaml)
+// to an incoming state payload. Dialects are opt-in: devices without one
+// are left alone, whatever their vendor.
+func NormalizePayload(dev *types.Device, state map[string]interface{}) {
+	dialectsMu.RLock()
+	name := dev.Dialect
+	steps, ok := dialects[name]
+	dialectsMu.RUnlock()
+
+	if name == "" || name == "none" {
+		return
+	}
+	if !ok {
+		log.Warn("Unknown payload dialect %q for device %s", name, dev.ID)
+		return
+	}
+
+	for _, step := range steps {
+		step(dev, state)
+	}
+}
+
+// normalizeOnOff upper-cases on/off string values ("on" -> "ON")
+func normalizeOnOff(_ *types.Device, state map[string]interface{}) {
+	for k, v := range state {
+		if s, ok := v.(string); ok {
+			switch strings.ToLower(strings.TrimSpace(s)) {
+			case "on":
+				state[k] = "ON"
+			case "off":
+				state[k] = "OFF"
+			}
+		}
+	}
+}
+
+// normalizeCommaDecimal converts numeric strings with a comma decimal separator ("23,5") to numbers
+func normalizeCommaDecimal(_ *types.Device, state map[string]interface{}) {
+	for k, v := range state {
+		s, ok := v.(string)
+		if !ok || strings.Count(s, ",") != 1 || strings.Contains(s, ".") {
+			continue
+		}
+		if f, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(s), ",", ".", 1), 64); err == nil {
+			state[k] = f
+		}
+	}
+}
+
+// normalizeTuyaDatapoints flattens Tuya datapoint payloads ({"dps": {"1": true}})
+// into named attributes. Names come from the device `datapoints:` mapping;
+// unmapped datapoints become "dp_<n>" (datapoint 1 defaults to "state").
+func normalizeTuyaDatapoints(dev *types.Device, state map[string]interface{}) {
+	dps, ok := state["dps"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	delete(state, "dps")
+
+	for dp, value := range dps {
+		name := dev.Datapoints[dp]
+		if name == "" {
+			name = "dp_" + dp
+			if dp == "1" {
+				name = "state"
+			}
+		}
+
+		if b, isBool := value.(bool); isBool && name == "state" {
+			if b {
+				value = "ON"
+			} else {
+				value = "OFF"
+			}
+		}
+		state[name] = value
+	}
+}
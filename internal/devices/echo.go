@@ -0,0 +1,74 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"reflect"
+	"strings"
+)
+
+// echoLog writes the command echo; its INFO lines show with --echo-commands
+// whatever the log level
+var echoLog = logger.Module("command")
+
+// Column widths of the echo lines
+const (
+	echoDeviceWidth = 24
+	echoChangeWidth = 40
+)
+
+// SetCommandEcho logs every command sent to a device at INFO, one aligned
+// line with the device, the attributes it changes and the script it came from
+func (m *Manager) SetCommandEcho(enabled bool) {
+	if enabled && logger.GetLevel() > logger.INFO {
+		logger.SetModuleLevel(echoLog.Name(), logger.INFO)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.echo = enabled
+}
+
+// echoing returns the cached values of attrs before a command changes them,
+// or nil if the echo is off
+func (m *Manager) echoing(id string, attrs map[string]interface{}) map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.echo {
+		return nil
+	}
+	previous := make(map[string]interface{}, len(attrs))
+	for attr := range attrs {
+		if value, ok := m.states[id][attr]; ok {
+			previous[attr] = value
+		}
+	}
+	return previous
+}
+
+// echoCommand logs a sent command, e.g.
+//
+//	Hallway light            state OFF → ON, brightness 120 → 200     events/device/hallway_motion/occupancy/light.lua
+func echoCommand(dev *types.Device, attrs, previous map[string]interface{}, origin string) {
+	name := dev.Name
+	if name == "" {
+		name = dev.ID
+	}
+	changes := make([]string, 0, len(attrs))
+	for _, attr := range sortedAttributes(attrs) {
+		value := attrs[attr]
+		old, known := previous[attr]
+		switch {
+		case !known:
+			changes = append(changes, fmt.Sprintf("%s ? → %v", attr, value))
+		case reflect.DeepEqual(old, value):
+			changes = append(changes, fmt.Sprintf("%s %v (unchanged)", attr, value))
+		default:
+			changes = append(changes, fmt.Sprintf("%s %v → %v", attr, old, value))
+		}
+	}
+	if origin == "" {
+		origin = "-"
+	}
+	echoLog.Info("%-*s %-*s %s", echoDeviceWidth, name, echoChangeWidth, strings.Join(changes, ", "), origin)
+}
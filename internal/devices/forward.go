@@ -0,0 +1,20 @@
+package devices
+
+import "homescript-server/internal/types"
+
+// Forwarder sends the commands of devices that live in another system (Home
+// Assistant entities imported over its WebSocket API) instead of over MQTT
+type Forwarder interface {
+	// Owns reports whether the forwarder handles commands for a device
+	Owns(id string) bool
+	// Forward sends validated attributes to the device
+	Forward(dev *types.Device, attrs map[string]interface{}) error
+}
+
+// SetForwarder routes commands of the devices owned by f through it; they
+// still queue, respect protect limits and are confirmed like MQTT devices
+func (m *Manager) SetForwarder(f Forwarder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forwarder = f
+}
@@ -0,0 +1,96 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homescript-server/internal/types"
+	"time"
+)
+
+// SetGroups replaces the configured device groups
+func (m *Manager) SetGroups(groups map[string]*types.Group) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.groups = make(map[string]*types.Group, len(groups))
+	for name, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, id := range group.Devices {
+			if _, ok := m.devices[id]; !ok {
+				log.Warn("Group %s references unknown device: %s", name, id)
+			}
+		}
+		m.groups[name] = group
+	}
+}
+
+// GetGroup retrieves a group configuration
+func (m *Manager) GetGroup(name string) (*types.Group, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	group, ok := m.groups[name]
+	return group, ok
+}
+
+// SetGroup sets attributes on every member of a group. Groups backed by a
+// native Zigbee2MQTT group get a single command so members switch together.
+func (m *Manager) SetGroup(name string, attrs map[string]interface{}) error {
+	group, ok := m.GetGroup(name)
+	if !ok {
+		return fmt.Errorf("group not found: %s", name)
+	}
+
+	if group.Zigbee2MQTT != "" {
+		return m.setNativeGroup(m.groupBase(group), group.Zigbee2MQTT, attrs)
+	}
+
+	var errs []error
+	for _, id := range group.Devices {
+		if err := m.Set(id, attrs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	log.Debug("Set group %s (%d device(s)): %v", name, len(group.Devices), attrs)
+	return errors.Join(errs...)
+}
+
+// groupBase returns the base topic of the Zigbee2MQTT instance a native
+// group belongs to: the one managing its members, or the first configured
+func (m *Manager) groupBase(group *types.Group) string {
+	for _, id := range group.Devices {
+		if dev, ok := m.GetDevice(id); ok {
+			if base, ok := m.Zigbee2MQTTBase(dev); ok {
+				return base
+			}
+		}
+	}
+	return m.Topics().Zigbee2MQTT[0]
+}
+
+// setNativeGroup publishes to a Zigbee2MQTT group command topic
+func (m *Manager) setNativeGroup(base, friendlyName string, attrs map[string]interface{}) error {
+	payload, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	if !m.client.IsConnected() {
+		return fmt.Errorf("MQTT client not connected")
+	}
+
+	topic := fmt.Sprintf("%s/%s/set", base, friendlyName)
+	log.Debug("Publishing to %s: %s", topic, string(payload))
+
+	token := m.client.Publish(topic, 0, false, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("publish timeout after 5 seconds")
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("failed to publish: %w", token.Error())
+	}
+	return nil
+}
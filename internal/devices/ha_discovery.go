@@ -0,0 +1,224 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"strings"
+)
+
+// haAbbreviations expands the short keys Tasmota, ESPHome and others use in
+// discovery configs to save memory (a subset of Home Assistant's list:
+// the keys types.HomeAssistantDiscovery knows about)
+var haAbbreviations = map[string]string{
+	"act_t":             "action_topic",
+	"act_tpl":           "action_template",
+	"avty":              "availability",
+	"avty_t":            "availability_topic",
+	"bri_cmd_t":         "brightness_command_topic",
+	"bri_stat_t":        "brightness_state_topic",
+	"bri_val_tpl":       "brightness_value_template",
+	"clrm_stat_t":       "color_mode_state_topic",
+	"clrm_val_tpl":      "color_mode_value_template",
+	"cmd_t":             "command_topic",
+	"curr_hum_t":        "current_humidity_topic",
+	"curr_hum_tpl":      "current_humidity_template",
+	"curr_temp_t":       "current_temperature_topic",
+	"curr_temp_tpl":     "current_temperature_template",
+	"dev":               "device",
+	"dev_cla":           "device_class",
+	"ent_cat":           "entity_category",
+	"fan_mode_cmd_t":    "fan_mode_command_topic",
+	"fan_mode_stat_t":   "fan_mode_state_topic",
+	"fan_mode_stat_tpl": "fan_mode_state_template",
+	"hum_cmd_t":         "target_humidity_command_topic",
+	"hum_stat_t":        "target_humidity_state_topic",
+	"hum_state_tpl":     "target_humidity_state_template",
+	"ic":                "icon",
+	"json_attr_t":       "json_attributes_topic",
+	"json_attr_tpl":     "json_attributes_template",
+	"mode_cmd_t":        "mode_command_topic",
+	"mode_stat_t":       "mode_state_topic",
+	"mode_stat_tpl":     "mode_state_template",
+	"osc_cmd_t":         "oscillation_command_topic",
+	"osc_stat_t":        "oscillation_state_topic",
+	"osc_val_tpl":       "oscillation_value_template",
+	"pct_cmd_t":         "percentage_command_topic",
+	"pct_stat_t":        "percentage_state_topic",
+	"pct_val_tpl":       "percentage_value_template",
+	"pl_off":            "payload_off",
+	"pl_on":             "payload_on",
+	"pos_t":             "position_topic",
+	"pos_tpl":           "position_template",
+	"pr_mode_cmd_t":     "preset_mode_command_topic",
+	"pr_mode_stat_t":    "preset_mode_state_topic",
+	"pr_mode_val_tpl":   "preset_mode_value_template",
+	"rgb_cmd_t":         "rgb_command_topic",
+	"rgb_stat_t":        "rgb_state_topic",
+	"rgb_val_tpl":       "rgb_value_template",
+	"set_pos_t":         "set_position_topic",
+	"stat_off":          "state_off",
+	"stat_on":           "state_on",
+	"stat_t":            "state_topic",
+	"t":                 "topic",
+	"temp_cmd_t":        "temperature_command_topic",
+	"temp_stat_t":       "temperature_state_topic",
+	"temp_stat_tpl":     "temperature_state_template",
+	"tilt_cmd_t":        "tilt_command_topic",
+	"tilt_status_t":     "tilt_status_topic",
+	"tilt_status_tpl":   "tilt_status_template",
+	"uniq_id":           "unique_id",
+	"unit_of_meas":      "unit_of_measurement",
+	"val_tpl":           "value_template",
+}
+
+// haDeviceAbbreviations expands the short keys of the "device" object
+var haDeviceAbbreviations = map[string]string{
+	"ids": "identifiers",
+	"mdl": "model",
+	"mf":  "manufacturer",
+	"sa":  "suggested_area",
+	"sw":  "sw_version",
+}
+
+// ParseHADiscoveryTopic splits a discovery topic under prefix into its
+// component, node ID and object ID. Both <prefix>/<component>/<object_id>/config
+// (node ID = object ID) and <prefix>/<component>/<node_id>/<object_id>/config
+// are accepted.
+func ParseHADiscoveryTopic(prefix, topic string) (component, nodeID, objectID string, ok bool) {
+	if !strings.HasPrefix(topic, prefix+"/") {
+		return "", "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(topic, prefix+"/"), "/")
+	switch {
+	case len(parts) == 3 && parts[2] == "config":
+		return parts[0], parts[1], parts[1], true
+	case len(parts) == 4 && parts[3] == "config":
+		return parts[0], parts[1], parts[2], true
+	}
+	return "", "", "", false
+}
+
+// ParseHADiscovery parses a discovery config, expanding abbreviated keys and
+// the "~" base topic, and taking the availability topic from an
+// availability list if needed
+func ParseHADiscovery(payload []byte) (*types.HomeAssistantDiscovery, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("invalid discovery config: %w", err)
+	}
+
+	base, _ := raw["~"].(string)
+	raw = expandHAKeys(raw, haAbbreviations, base)
+	if dev, ok := raw["device"].(map[string]interface{}); ok {
+		dev = expandHAKeys(dev, haDeviceAbbreviations, "")
+		// A single identifier may be given as a string
+		if id, ok := dev["identifiers"].(string); ok {
+			dev["identifiers"] = []string{id}
+		}
+		raw["device"] = dev
+	}
+	if list, ok := raw["availability"].([]interface{}); ok {
+		for i, item := range list {
+			if entry, ok := item.(map[string]interface{}); ok {
+				list[i] = expandHAKeys(entry, haAbbreviations, base)
+			}
+		}
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var config types.HomeAssistantDiscovery
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid discovery config: %w", err)
+	}
+	if config.AvailabilityTopic == "" && len(config.Availability) > 0 {
+		config.AvailabilityTopic = config.Availability[0].Topic
+	}
+	return &config, nil
+}
+
+// expandHAKeys renames abbreviated keys, substitutes base for a leading or
+// trailing "~" in topics and turns scalar payload_*/state_* values into
+// strings (Zigbee2MQTT sends "payload_on": true)
+func expandHAKeys(raw map[string]interface{}, abbreviations map[string]string, base string) map[string]interface{} {
+	result := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		if full, ok := abbreviations[key]; ok {
+			key = full
+		}
+		if s, ok := value.(string); ok && base != "" && (key == "topic" || strings.HasSuffix(key, "_topic")) {
+			if strings.HasPrefix(s, "~") {
+				s = base + s[1:]
+			} else if strings.HasSuffix(s, "~") {
+				s = s[:len(s)-1] + base
+			}
+			value = s
+		}
+		if strings.HasPrefix(key, "payload_") || key == "state_on" || key == "state_off" {
+			switch v := value.(type) {
+			case bool, float64:
+				data, _ := json.Marshal(v)
+				value = string(data)
+			}
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// HAStateTopics returns how to read the state of an entity: one entry per
+// state topic of its component, parsed with the matching value template.
+// The main state topic reports the "state" attribute (JSON schema lights
+// report their keys as is); json_attributes_topic adds its object's keys.
+func HAStateTopics(component string, config *types.HomeAssistantDiscovery) []*types.TemplateState {
+	var topics []*types.TemplateState
+	add := func(topic, attribute, template string) {
+		if topic != "" {
+			topics = append(topics, &types.TemplateState{Topic: topic, Attribute: attribute, ValueTemplate: template})
+		}
+	}
+
+	if component == "light" && config.Schema == "json" && config.ValueTemplate == "" {
+		add(config.StateTopic, "", "")
+	} else {
+		add(config.StateTopic, "state", config.ValueTemplate)
+	}
+
+	switch component {
+	case "climate":
+		add(config.CurrentTemperatureTopic, "current_temperature", config.CurrentTemperatureTemplate)
+		add(config.TemperatureStateTopic, "temperature", config.TemperatureStateTemplate)
+		add(config.ModeStateTopic, "hvac_mode", config.ModeStateTemplate)
+		add(config.FanModeStateTopic, "fan_mode", config.FanModeStateTemplate)
+		add(config.ActionTopic, "hvac_action", config.ActionTemplate)
+		add(config.PresetModeStateTopic, "preset_mode", config.PresetModeValueTemplate)
+		add(config.CurrentHumidityTopic, "humidity", config.CurrentHumidityTemplate)
+		add(config.TargetHumidityStateTopic, "target_humidity", config.TargetHumidityStateTemplate)
+	case "cover":
+		add(config.PositionTopic, "position", config.PositionTemplate)
+		add(config.TiltStatusTopic, "tilt", config.TiltStatusTemplate)
+	case "light":
+		add(config.BrightnessStateTopic, "brightness", config.BrightnessValueTemplate)
+		add(config.ColorModeStateTopic, "color_mode", config.ColorModeValueTemplate)
+		add(config.RGBStateTopic, "rgb_color", config.RGBValueTemplate)
+	case "fan":
+		add(config.PercentageStateTopic, "percentage", config.PercentageValueTemplate)
+		add(config.PresetModeStateTopic, "preset_mode", config.PresetModeValueTemplate)
+		add(config.OscillationStateTopic, "oscillating", config.OscillationValueTemplate)
+	case "humidifier":
+		add(config.CurrentHumidityTopic, "humidity", config.CurrentHumidityTemplate)
+		add(config.TargetHumidityStateTopic, "target_humidity", config.TargetHumidityStateTemplate)
+		add(config.ModeStateTopic, "mode", config.ModeStateTemplate)
+	}
+
+	if config.JSONAttributesTopic != "" {
+		topics = append(topics, &types.TemplateState{
+			Topic:         config.JSONAttributesTopic,
+			ValueTemplate: config.JSONAttributesTemplate,
+		})
+	}
+	return topics
+}
@@ -3,7 +3,6 @@ package devices
 import (
 	"encoding/json"
 	"fmt"
-	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
 	"strings"
 	"sync"
@@ -39,7 +38,14 @@ func (h *HADeviceManager) RegisterDevice(deviceID string, config *types.HomeAssi
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	h.configs[deviceID] = config
-	logger.Debug("Registered HA device config for %s", deviceID)
+	log.Debug("Registered HA device config for %s", deviceID)
+}
+
+// UnregisterDevice forgets the HA config of a device
+func (h *HADeviceManager) UnregisterDevice(deviceID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.configs, deviceID)
 }
 
 // IsHADevice checks if a device is an HA device
@@ -68,11 +74,11 @@ func (h *HADeviceManager) Set(deviceID string, attrs map[string]interface{}) err
 		topic, payload := h.getCommandTopicAndPayload(config, attr, value)
 
 		if topic == "" {
-			logger.Warn("No command topic for %s.%s", deviceID, attr)
+			log.Warn("No command topic for %s.%s", deviceID, attr)
 			continue
 		}
 
-		logger.Debug("Publishing to HA device %s: %s = %s", deviceID, topic, string(payload))
+		log.Debug("Publishing to HA device %s: %s = %s", deviceID, topic, string(payload))
 
 		token := h.client.Publish(topic, 0, false, payload)
 		if !token.WaitTimeout(5 * time.Second) {
@@ -83,7 +89,7 @@ func (h *HADeviceManager) Set(deviceID string, attrs map[string]interface{}) err
 		}
 	}
 
-	logger.Debug("Successfully set HA device %s: %v", deviceID, attrs)
+	log.Debug("Successfully set HA device %s: %v", deviceID, attrs)
 	return nil
 }
 
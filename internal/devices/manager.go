@@ -1,9 +1,9 @@
 package devices
 
 import (
-	"encoding/json"
 	"fmt"
 	"homescript-server/internal/logger"
+	"homescript-server/internal/metrics"
 	"homescript-server/internal/types"
 	"sync"
 	"time"
@@ -11,32 +11,97 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+var log = logger.Module("devices")
+
+// StateListener is notified after a device state update has been applied
+type StateListener func(id string, state map[string]interface{})
+
+// CommandListener is notified after a command has been sent to a device
+type CommandListener func(id string, attrs map[string]interface{})
+
 // Manager manages smart home devices
 type Manager struct {
 	client    mqtt.Client
 	devices   map[string]*types.Device
 	states    map[string]map[string]interface{}
 	haManager *HADeviceManager
-	mu        sync.RWMutex
+	groups    map[string]*types.Group
+	listeners []StateListener
+	commands  []CommandListener
+	metrics   *metrics.Registry
+	router    EventRouter
+	virtual   map[string]*types.VirtualDevice
+	lastSeen  map[string]time.Time
+	aliases   map[string]string // alias -> device ID
+	stale     map[string]bool
+	pending   map[string]*pendingConfirm // commands awaiting confirmation
+	// configured are the devices as loaded from devices.yaml, which a reload replaces
+	configured map[string]*types.Device
+	// forwarder sends commands of devices that are not on MQTT
+	forwarder Forwarder
+	// switchTimes are the recent switches of protected devices, oldest first
+	switchTimes map[string][]time.Time
+	// queues serialize the commands of each device while one is being sent
+	queueMu  sync.Mutex
+	queues   map[string]*commandQueue
+	coalesce bool
+	// queued counts the commands queued per device, so a resend can tell
+	// whether a newer command came after it
+	queued map[string]uint64
+	// echo logs every sent command (SetCommandEcho)
+	echo bool
+	// virtualPrefix is where mirrored virtual devices are published (empty until started)
+	virtualPrefix string
+	topics        types.Topics
+	mu            sync.RWMutex
 }
 
 // New creates a new device manager
 func New(client mqtt.Client, devices []*types.Device) *Manager {
 	m := &Manager{
-		client:    client,
-		devices:   make(map[string]*types.Device),
-		states:    make(map[string]map[string]interface{}),
-		haManager: NewHADeviceManager(client),
+		client:      client,
+		devices:     make(map[string]*types.Device),
+		states:      make(map[string]map[string]interface{}),
+		haManager:   NewHADeviceManager(client),
+		groups:      make(map[string]*types.Group),
+		virtual:     make(map[string]*types.VirtualDevice),
+		lastSeen:    make(map[string]time.Time),
+		aliases:     make(map[string]string),
+		configured:  make(map[string]*types.Device),
+		stale:       make(map[string]bool),
+		pending:     make(map[string]*pendingConfirm),
+		topics:      types.DefaultTopics(),
+		switchTimes: make(map[string][]time.Time),
+		queues:      make(map[string]*commandQueue),
+		queued:      make(map[string]uint64),
 	}
 
 	for _, dev := range devices {
 		m.devices[dev.ID] = dev
 		m.states[dev.ID] = make(map[string]interface{})
+		m.configured[dev.ID] = dev
+	}
+	for _, dev := range devices {
+		m.addAliases(dev)
 	}
 
 	return m
 }
 
+// SetTopics sets the integration base topics (defaults to types.DefaultTopics)
+func (m *Manager) SetTopics(topics types.Topics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topics = topics.WithDefaults()
+}
+
+// Topics returns the integration base topics
+func (m *Manager) Topics() types.Topics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.topics
+}
+
 // SetClient updates the MQTT client reference
 func (m *Manager) SetClient(client mqtt.Client) {
 	m.mu.Lock()
@@ -50,12 +115,19 @@ func (m *Manager) GetHAManager() *HADeviceManager {
 	return m.haManager
 }
 
+// SetMetrics enables per-device message and command metrics
+func (m *Manager) SetMetrics(registry *metrics.Registry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = registry
+}
+
 // Get retrieves current state of a device
 func (m *Manager) Get(id string) (map[string]interface{}, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	state, ok := m.states[id]
+	state, ok := m.states[m.resolve(id)]
 	if !ok {
 		return nil, fmt.Errorf("device not found: %s", id)
 	}
@@ -68,19 +140,104 @@ func (m *Manager) Get(id string) (map[string]interface{}, error) {
 	return result, nil
 }
 
-// Set updates device state by publishing to MQTT
+// Set updates device state by publishing to MQTT, with the optimistic and
+// confirm settings of the device
 func (m *Manager) Set(id string, attrs map[string]interface{}) error {
 	m.mu.RLock()
-	dev, ok := m.devices[id]
+	dev, ok := m.devices[m.resolve(id)]
 	m.mu.RUnlock()
-
 	if !ok {
 		return fmt.Errorf("device not found: %s", id)
 	}
+	return m.set(dev, attrs, DefaultSetOptions(dev))
+}
+
+func (m *Manager) set(dev *types.Device, attrs map[string]interface{}, opts SetOptions) error {
+	m.mu.RLock()
+	virtual := m.virtual[dev.ID]
+	m.mu.RUnlock()
+
+	// Virtual devices never leave the server
+	if virtual != nil {
+		return m.setVirtual(virtual, attrs)
+	}
+
+	// Reject or clamp values outside the attribute schema before publishing
+	attrs, err := validateAttrs(dev, attrs)
+	if err != nil {
+		return err
+	}
+	return m.enqueue(dev, &command{attrs: attrs, opts: opts})
+}
+
+// send publishes one validated command when its turn in the device's queue
+// has come
+func (m *Manager) send(dev *types.Device, cmd *command) error {
+	if cmd.resend != nil {
+		return m.resend(dev, cmd.resend)
+	}
+	id, attrs, opts := dev.ID, cmd.attrs, cmd.opts
+	m.mu.RLock()
+	registry := m.metrics
+	m.mu.RUnlock()
+
+	// Protected devices (compressors, relays) refuse switching too often
+	switching, err := m.checkProtect(dev, attrs, opts.CorrelationID)
+	if err != nil {
+		return err
+	}
+
+	previous := m.echoing(id, attrs)
+	start := time.Now()
+	err = m.publish(dev, attrs, opts)
+	if registry != nil {
+		registry.RecordCommand(id, time.Since(start), err)
+		if err == nil {
+			registry.ExpectEcho(id, attrs)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if switching {
+		m.recordSwitch(dev.ID, start)
+	}
+	if previous != nil {
+		echoCommand(dev, attrs, previous, opts.Origin)
+	}
+	m.mu.RLock()
+	commands := m.commands
+	m.mu.RUnlock()
+	for _, listener := range commands {
+		listener(id, attrs)
+	}
+
+	var replaced map[string]interface{}
+	if opts.Optimistic {
+		replaced = m.applyOptimistic(id, attrs)
+	}
+	if opts.ConfirmTimeout > 0 {
+		m.expectConfirm(dev, cmd, replaced)
+	}
+	return nil
+}
+
+// publish sends attrs to the device's command topic(s) with the QoS and
+// retain flag of opts
+func (m *Manager) publish(dev *types.Device, attrs map[string]interface{}, opts SetOptions) error {
+	id := dev.ID
+
+	// Devices of other systems (Home Assistant entities) don't need MQTT
+	m.mu.RLock()
+	forwarder := m.forwarder
+	m.mu.RUnlock()
+	if forwarder != nil && forwarder.Owns(id) {
+		return forwarder.Forward(dev, attrs)
+	}
 
 	// Check MQTT connection status
 	if !m.client.IsConnected() {
-		logger.Warn("MQTT client not connected when trying to set device %s", id)
+		log.Warn("MQTT client not connected when trying to set device %s", id)
 		return fmt.Errorf("MQTT client not connected")
 	}
 
@@ -89,93 +246,120 @@ func (m *Manager) Set(id string, attrs map[string]interface{}) error {
 		return m.haManager.Set(id, attrs)
 	}
 
-	// Special handling for Frigate cameras - each attribute needs separate topic
-	if dev.Type == "camera" && dev.Vendor == "Frigate NVR" {
-		// Frigate requires publishing to frigate/{camera}/{attribute}/set
-		// CommandTopic is like "frigate/CameraName"
-		for attr, value := range attrs {
-			topic := fmt.Sprintf("%s/%s/set", dev.MQTT.CommandTopic, attr)
-
-			// Convert value to string (Frigate expects "ON"/"OFF" or numeric strings)
-			var payload []byte
-			switch v := value.(type) {
-			case string:
-				payload = []byte(v)
-			case bool:
-				if v {
-					payload = []byte("ON")
-				} else {
-					payload = []byte("OFF")
-				}
-			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-				payload = []byte(fmt.Sprintf("%d", v))
-			case float32, float64:
-				payload = []byte(fmt.Sprintf("%v", v))
-			default:
-				payload = []byte(fmt.Sprintf("%v", v))
-			}
+	commands, err := Commands(dev, attrs)
+	if err != nil {
+		return err
+	}
+	for _, cmd := range commands {
+		log.Debug("Publishing to %s: %s", cmd.Topic, string(cmd.Payload))
 
-			logger.Debug("Publishing to Frigate topic %s: %s", topic, string(payload))
+		token := m.client.Publish(cmd.Topic, opts.QoS, opts.Retain, cmd.Payload)
 
-			token := m.client.Publish(topic, 0, false, payload)
-			if !token.WaitTimeout(5 * time.Second) {
-				return fmt.Errorf("publish timeout for %s after 5 seconds", attr)
+		// Wait with timeout
+		if !token.WaitTimeout(5 * time.Second) {
+			if cmd.Attribute != "" {
+				return fmt.Errorf("publish timeout for %s after 5 seconds", cmd.Attribute)
 			}
-			if token.Error() != nil {
-				return fmt.Errorf("failed to publish %s: %w", attr, token.Error())
+			return fmt.Errorf("publish timeout after 5 seconds")
+		}
+		if token.Error() != nil {
+			if cmd.Attribute != "" {
+				return fmt.Errorf("failed to publish %s: %w", cmd.Attribute, token.Error())
 			}
+			return fmt.Errorf("failed to publish: %w", token.Error())
 		}
-
-		logger.Debug("Successfully set Frigate camera %s: %v", id, attrs)
-		return nil
-	}
-
-	// Default behavior for non-Frigate devices - publish JSON to single command topic
-	payload, err := json.Marshal(attrs)
-	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	logger.Debug("Publishing to %s: %s", dev.MQTT.CommandTopic, string(payload))
-
-	token := m.client.Publish(dev.MQTT.CommandTopic, 0, false, payload)
+	log.Debug("Successfully set device %s: %v", id, attrs)
+	return nil
+}
 
-	// Wait with timeout
-	if !token.WaitTimeout(5 * time.Second) {
-		return fmt.Errorf("publish timeout after 5 seconds")
+// payloadString converts a value to a plain-text payload (bools become "ON"/"OFF")
+func payloadString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "ON"
+		}
+		return "OFF"
+	default:
+		return fmt.Sprintf("%v", v)
 	}
+}
 
-	if token.Error() != nil {
-		return fmt.Errorf("failed to publish: %w", token.Error())
-	}
+// AddStateListener registers a callback invoked after every state update
+func (m *Manager) AddStateListener(listener StateListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
 
-	logger.Debug("Successfully set device %s: %v", id, attrs)
-	return nil
+// AddCommandListener registers a callback invoked after every command sent
+// to a device
+func (m *Manager) AddCommandListener(listener CommandListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = append(m.commands, listener)
 }
 
 // UpdateState updates the cached state of a device
 func (m *Manager) UpdateState(id string, state map[string]interface{}) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	if _, ok := m.devices[id]; !ok {
+		m.mu.Unlock()
 		return
 	}
 
+	listeners := m.mergeState(id, state)
+
+	now := time.Now()
+	previous := m.lastSeen[id]
+	m.lastSeen[id] = now
+	recovered := m.stale[id]
+	delete(m.stale, id)
+
+	dev := m.devices[id]
+	registry := m.metrics
+	router := m.router
+	m.mu.Unlock()
+
+	if registry != nil {
+		registry.RecordMessage(id, state)
+	}
+	m.checkConfirm(id, state)
+
+	if recovered {
+		log.Info("Device %s reports again", id)
+		if router != nil {
+			router.RouteEvent(staleEvent(dev, false, previous, now))
+		}
+	}
+
+	for _, listener := range listeners {
+		listener(id, state)
+	}
+}
+
+// mergeState writes state into the cached state of a device and returns the
+// state listeners to notify once m.mu is released (m.mu must be held)
+func (m *Manager) mergeState(id string, state map[string]interface{}) []StateListener {
 	if m.states[id] == nil {
 		m.states[id] = make(map[string]interface{})
 	}
-
 	for k, v := range state {
 		m.states[id][k] = v
 	}
+	return m.listeners
 }
 
 // GetDevice retrieves device configuration
 func (m *Manager) GetDevice(id string) (*types.Device, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	dev, ok := m.devices[id]
+	dev, ok := m.devices[m.resolve(id)]
 	return dev, ok
 }
 
@@ -190,3 +374,39 @@ func (m *Manager) ListDevices() []*types.Device {
 	}
 	return devices
 }
+
+// AddDevice adds a device found at runtime, replacing the device with the
+// same ID; cached state is kept
+func (m *Manager) AddDevice(dev *types.Device) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.devices[dev.ID] = dev
+	if _, ok := m.states[dev.ID]; !ok {
+		m.states[dev.ID] = make(map[string]interface{})
+	}
+	m.addAliases(dev)
+}
+
+// RemoveDevice forgets a device and everything kept for it, reporting
+// whether it existed
+func (m *Manager) RemoveDevice(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.devices[id]; !ok {
+		return false
+	}
+	delete(m.devices, id)
+	delete(m.configured, id)
+	delete(m.states, id)
+	delete(m.lastSeen, id)
+	delete(m.stale, id)
+	for alias, target := range m.aliases {
+		if target == id {
+			delete(m.aliases, alias)
+		}
+	}
+	m.haManager.UnregisterDevice(id)
+	return true
+}
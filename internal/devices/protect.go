@@ -0,0 +1,103 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"strings"
+	"time"
+)
+
+// CommandBlockedAttribute is the event attribute of commands refused by a
+// device's protect settings, routed to events/device/<id>/command_blocked/
+const CommandBlockedAttribute = "command_blocked"
+
+// checkProtect refuses a command that would switch a protected device too
+// soon after the last switch or too often within the hour. switching
+// reports whether the command switches the device; the caller records the
+// switch with recordSwitch once the command went out. Commands that leave
+// the switching attributes as they are pass freely.
+func (m *Manager) checkProtect(dev *types.Device, attrs map[string]interface{}, correlationID string) (switching bool, err error) {
+	protect := dev.Protect
+	if protect == nil || (protect.MinInterval <= 0 && protect.MaxPerHour <= 0) {
+		return false, nil
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	if !m.switches(dev, attrs) {
+		m.mu.Unlock()
+		return false, nil
+	}
+
+	// Keep the switches of the last hour
+	history := m.switchTimes[dev.ID]
+	for len(history) > 0 && now.Sub(history[0]) >= time.Hour {
+		history = history[1:]
+	}
+	m.switchTimes[dev.ID] = history
+
+	var reason string
+	switch {
+	case protect.MinInterval > 0 && len(history) > 0 && now.Sub(history[len(history)-1]) < protect.MinInterval:
+		since := now.Sub(history[len(history)-1]).Round(time.Second)
+		reason = fmt.Sprintf("switched %s ago, minimum interval is %s", since, protect.MinInterval)
+	case protect.MaxPerHour > 0 && len(history) >= protect.MaxPerHour:
+		reason = fmt.Sprintf("switched %d times within the last hour (max %d)", len(history), protect.MaxPerHour)
+	}
+	router := m.router
+	m.mu.Unlock()
+
+	if reason == "" {
+		return true, nil
+	}
+
+	log.Warn("Blocked command to %s: %s", dev.ID, reason)
+	if router != nil {
+		router.RouteEvent(&types.Event{
+			Source:    "device",
+			Type:      CommandBlockedAttribute,
+			Device:    dev.ID,
+			Attribute: CommandBlockedAttribute,
+			Area:      dev.Area,
+			Data: map[string]interface{}{
+				"attributes": attrs,
+				"reason":     reason,
+			},
+			Timestamp:     now,
+			CorrelationID: correlationID,
+		})
+	}
+	return false, fmt.Errorf("device %s: %s", dev.ID, reason)
+}
+
+// recordSwitch counts a switch of device id at when for its protect limits
+func (m *Manager) recordSwitch(id string, when time.Time) {
+	m.mu.Lock()
+	m.switchTimes[id] = append(m.switchTimes[id], when)
+	m.mu.Unlock()
+}
+
+// switches reports whether attrs change a switching attribute of dev from
+// its cached value (TOGGLE always does). Callers hold m.mu.
+func (m *Manager) switches(dev *types.Device, attrs map[string]interface{}) bool {
+	names := dev.Protect.Attributes
+	if len(names) == 0 {
+		names = []string{"state"}
+	}
+	state := m.states[dev.ID]
+	for _, name := range names {
+		value, ok := attrs[name]
+		if !ok {
+			continue
+		}
+		text := payloadString(value)
+		if strings.EqualFold(text, "TOGGLE") {
+			return true
+		}
+		current, known := state[name]
+		if !known || !strings.EqualFold(payloadString(current), text) {
+			return true
+		}
+	}
+	return false
+}
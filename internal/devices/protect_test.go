@@ -0,0 +1,106 @@
+package devices
+
+import (
+	"errors"
+	"homescript-server/internal/types"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// publishClient is an MQTT client counting publishes, which fail while
+// failing is set
+type publishClient struct {
+	mqtt.Client
+	mu        sync.Mutex
+	failing   bool
+	published int
+}
+
+func (c *publishClient) IsConnected() bool { return true }
+
+func (c *publishClient) Publish(string, byte, bool, interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failing {
+		return &publishToken{err: errors.New("broker gone")}
+	}
+	c.published++
+	return &publishToken{}
+}
+
+func (c *publishClient) setFailing(failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing = failing
+}
+
+func (c *publishClient) publishes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.published
+}
+
+type publishToken struct {
+	mqtt.Token
+	err error
+}
+
+func (t *publishToken) WaitTimeout(time.Duration) bool { return true }
+func (t *publishToken) Error() error                   { return t.err }
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []*types.Event
+}
+
+func (r *eventRecorder) RouteEvent(event *types.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) routed() []*types.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*types.Event(nil), r.events...)
+}
+
+func TestProtectCountsPublishedSwitches(t *testing.T) {
+	client := &publishClient{}
+	dev := &types.Device{
+		ID:      "compressor",
+		MQTT:    types.MQTTConfig{CommandTopic: "compressor/set"},
+		Protect: &types.ProtectConfig{MinInterval: time.Hour},
+	}
+	m := New(client, []*types.Device{dev})
+	router := &eventRecorder{}
+	m.SetRouter(router)
+
+	// A command that never went out doesn't start the interval
+	client.setFailing(true)
+	if err := m.Set("compressor", map[string]interface{}{"state": "ON"}); err == nil {
+		t.Fatal("Set with a failing publish succeeded")
+	}
+	client.setFailing(false)
+	if err := m.Set("compressor", map[string]interface{}{"state": "ON"}); err != nil {
+		t.Fatalf("Set after a failed publish: %v", err)
+	}
+
+	opts := DefaultSetOptions(dev)
+	opts.CorrelationID = "abc123"
+	err := m.SetWithOptions("compressor", map[string]interface{}{"state": "OFF"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "minimum interval") {
+		t.Fatalf("Set within the minimum interval: err = %v, want blocked", err)
+	}
+	events := router.routed()
+	if len(events) != 1 {
+		t.Fatalf("routed %d events, want 1 command_blocked", len(events))
+	}
+	if e := events[0]; e.Type != CommandBlockedAttribute || e.CorrelationID != "abc123" {
+		t.Errorf("routed %s event with correlation ID %q, want %s with abc123", e.Type, e.CorrelationID, CommandBlockedAttribute)
+	}
+}
@@ -0,0 +1,111 @@
+package devices
+
+import (
+	"homescript-server/internal/types"
+	"strings"
+)
+
+// command is one device.set waiting for its turn
+type command struct {
+	attrs  map[string]interface{}
+	opts   SetOptions
+	seq    uint64          // position among the device's queued commands
+	resend *pendingConfirm // set when resending an unconfirmed command
+	turn   chan struct{}   // closed when the command may be sent
+	done   chan struct{}   // closed when it was sent; err holds the result
+	err    error
+}
+
+// commandQueue holds the commands of one device waiting behind the one
+// being sent
+type commandQueue struct {
+	waiting []*command
+}
+
+// SetCoalesce lets a queued command replace the one queued right before it
+// when it overwrites all of its attributes ({brightness: 10} followed by
+// {brightness: 20} sends only the second). Callers of both get the result.
+func (m *Manager) SetCoalesce(enabled bool) {
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+	m.coalesce = enabled
+}
+
+// enqueue sends the commands of a device one at a time, in the order they
+// arrived, so concurrent scripts can't interleave their publishes, protect
+// checks and optimistic updates. Resends keep their place in the count of
+// queued commands, so they don't drop each other.
+func (m *Manager) enqueue(dev *types.Device, cmd *command) error {
+	m.queueMu.Lock()
+	if cmd.resend == nil {
+		m.queued[dev.ID]++
+	}
+	cmd.seq = m.queued[dev.ID]
+	q, busy := m.queues[dev.ID]
+	if busy && m.coalesce && cmd.resend == nil && len(q.waiting) > 0 {
+		last := q.waiting[len(q.waiting)-1]
+		if supersedes(cmd.attrs, cmd.opts, last) {
+			log.Debug("Coalescing command to %s: %v replaces %v", dev.ID, cmd.attrs, last.attrs)
+			last.attrs = cmd.attrs
+			last.opts.Origin = cmd.opts.Origin
+			last.opts.CorrelationID = cmd.opts.CorrelationID
+			last.seq = cmd.seq
+			m.queueMu.Unlock()
+			<-last.done
+			return last.err
+		}
+	}
+
+	cmd.turn = make(chan struct{})
+	cmd.done = make(chan struct{})
+	if busy {
+		q.waiting = append(q.waiting, cmd)
+		m.queueMu.Unlock()
+		<-cmd.turn
+	} else {
+		m.queues[dev.ID] = &commandQueue{}
+		m.queueMu.Unlock()
+	}
+
+	// Taken off the queue before its turn, so attrs no longer change
+	cmd.err = m.send(dev, cmd)
+
+	m.queueMu.Lock()
+	q = m.queues[dev.ID]
+	if len(q.waiting) > 0 {
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		close(next.turn)
+	} else {
+		delete(m.queues, dev.ID)
+	}
+	m.queueMu.Unlock()
+
+	close(cmd.done)
+	return cmd.err
+}
+
+// supersedes reports whether attrs overwrite every attribute of the queued
+// command with the same options (wherever they came from). Toggles depend on
+// what came before and are never coalesced.
+func supersedes(attrs map[string]interface{}, opts SetOptions, queued *command) bool {
+	if queued.resend != nil {
+		return false
+	}
+	opts.Origin = queued.opts.Origin
+	opts.CorrelationID = queued.opts.CorrelationID
+	if opts != queued.opts {
+		return false
+	}
+	for _, value := range attrs {
+		if s, ok := value.(string); ok && strings.EqualFold(s, "TOGGLE") {
+			return false
+		}
+	}
+	for attr := range queued.attrs {
+		if _, ok := attrs[attr]; !ok {
+			return false
+		}
+	}
+	return true
+}
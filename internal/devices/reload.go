@@ -0,0 +1,98 @@
+package devices
+
+import (
+	"homescript-server/internal/types"
+	"reflect"
+	"sort"
+)
+
+// DeviceChange is a device from devices.yaml that a reload adds (Old is
+// nil), removes (New is nil) or changes. Old is the device as used until
+// then, including what discovery merged in.
+type DeviceChange struct {
+	Old *types.Device
+	New *types.Device
+}
+
+// DiffConfigured compares the devices from devices.yaml with next, by ID
+func (m *Manager) DiffConfigured(next []*types.Device) []DeviceChange {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.diffConfigured(next)
+}
+
+// ReplaceConfigured swaps the devices from devices.yaml and the groups for
+// the reloaded ones in one step and returns what changed. Devices that are
+// not from devices.yaml (virtual devices, Home Assistant entities) are kept,
+// and so is the cached state of devices that still exist.
+func (m *Manager) ReplaceConfigured(next []*types.Device, groups map[string]*types.Group) []DeviceChange {
+	m.mu.Lock()
+	changes := m.diffConfigured(next)
+	for _, change := range changes {
+		if change.New != nil {
+			continue
+		}
+		id := change.Old.ID
+		delete(m.devices, id)
+		delete(m.configured, id)
+		delete(m.states, id)
+		delete(m.lastSeen, id)
+		delete(m.stale, id)
+		m.haManager.UnregisterDevice(id)
+	}
+	for _, dev := range next {
+		if old, ok := m.configured[dev.ID]; ok && reflect.DeepEqual(old, dev) {
+			continue // keeps what discovery merged in at runtime
+		}
+		m.devices[dev.ID] = dev
+		m.configured[dev.ID] = dev
+		if _, ok := m.states[dev.ID]; !ok {
+			m.states[dev.ID] = make(map[string]interface{})
+		}
+	}
+
+	m.aliases = make(map[string]string)
+	for _, dev := range m.devices {
+		m.addAliases(dev)
+	}
+	m.mu.Unlock()
+
+	m.SetGroups(groups)
+	return changes
+}
+
+// diffConfigured compares the devices from devices.yaml with next (m.mu
+// must be held). A device of next that was added at runtime (by discovery,
+// which also writes devices.yaml) counts as changed only if it differs.
+func (m *Manager) diffConfigured(next []*types.Device) []DeviceChange {
+	var changes []DeviceChange
+	seen := make(map[string]bool, len(next))
+	for _, dev := range next {
+		seen[dev.ID] = true
+		current, ok := m.devices[dev.ID]
+		loaded, configured := m.configured[dev.ID]
+		switch {
+		case !ok:
+			changes = append(changes, DeviceChange{New: dev})
+		case configured && !reflect.DeepEqual(loaded, dev), !configured && !reflect.DeepEqual(current, dev):
+			changes = append(changes, DeviceChange{Old: current, New: dev})
+		}
+	}
+	for id := range m.configured {
+		if !seen[id] {
+			changes = append(changes, DeviceChange{Old: m.devices[id]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changeID(changes[i]) < changeID(changes[j])
+	})
+	return changes
+}
+
+func changeID(change DeviceChange) string {
+	if change.New != nil {
+		return change.New.ID
+	}
+	return change.Old.ID
+}
@@ -0,0 +1,107 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"strconv"
+	"strings"
+)
+
+// validateAttrs checks attrs against the device schema from devices.yaml.
+// Numbers outside min/max are clamped (with a warning), enum values are
+// matched case-insensitively and rewritten to their canonical spelling.
+// Attributes without a schema pass through unchanged.
+func validateAttrs(dev *types.Device, attrs map[string]interface{}) (map[string]interface{}, error) {
+	if len(dev.Schema) == 0 {
+		return attrs, nil
+	}
+
+	result := make(map[string]interface{}, len(attrs))
+	for attr, value := range attrs {
+		schema := dev.Schema[attr]
+		if schema == nil {
+			result[attr] = value
+			continue
+		}
+
+		checked, err := checkValue(schema, dev.ID, attr, value)
+		if err != nil {
+			return nil, err
+		}
+		result[attr] = checked
+	}
+	return result, nil
+}
+
+// checkValue validates one value against an attribute schema
+func checkValue(schema *types.AttributeSchema, deviceID, attr string, value interface{}) (interface{}, error) {
+	if len(schema.Values) > 0 {
+		if s, ok := value.(string); ok {
+			for _, allowed := range schema.Values {
+				if strings.EqualFold(s, allowed) {
+					return allowed, nil
+				}
+			}
+		}
+		// Binary attributes with only on/off/toggle values still accept booleans
+		if b, ok := value.(bool); ok && schema.Min == nil && schema.Max == nil {
+			want := "OFF"
+			if b {
+				want = "ON"
+			}
+			for _, allowed := range schema.Values {
+				if strings.EqualFold(want, allowed) {
+					return allowed, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("invalid value %v for %s.%s: expected one of %s",
+			value, deviceID, attr, strings.Join(schema.Values, ", "))
+	}
+
+	if schema.Min == nil && schema.Max == nil {
+		return value, nil
+	}
+
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s.%s: expected a number", v, deviceID, attr)
+		}
+		f = parsed
+	default:
+		return nil, fmt.Errorf("invalid value %v for %s.%s: expected a number", value, deviceID, attr)
+	}
+
+	clamped := f
+	if schema.Min != nil && clamped < *schema.Min {
+		clamped = *schema.Min
+	}
+	if schema.Max != nil && clamped > *schema.Max {
+		clamped = *schema.Max
+	}
+	if clamped != f {
+		log.Warn("Value %v for %s.%s out of range %s, clamped to %v", f, deviceID, attr, rangeString(schema), clamped)
+	}
+	return clamped, nil
+}
+
+// rangeString formats a schema's numeric range, e.g. "[0, 254]"
+func rangeString(schema *types.AttributeSchema) string {
+	lo, hi := "-inf", "+inf"
+	if schema.Min != nil {
+		lo = strconv.FormatFloat(*schema.Min, 'f', -1, 64)
+	}
+	if schema.Max != nil {
+		hi = strconv.FormatFloat(*schema.Max, 'f', -1, 64)
+	}
+	return "[" + lo + ", " + hi + "]"
+}
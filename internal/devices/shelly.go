@@ -0,0 +1,206 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"sort"
+	"strconv"
+)
+
+// ShellyTemplate is the built-in template of Shelly Gen2+ devices (Plus, Pro,
+// Gen3), which speak JSON-RPC over MQTT. Like the tasmota template its topics
+// are generated from vars:
+//
+//	prefix    the MQTT topic prefix, by default the device ID (required)
+//	switches  number of switch components (switch:0, switch:1, ...)
+//	covers    number of cover components
+//	inputs    number of input components
+//	metering  "true" if switches and covers measure power
+//
+// Commands go to <prefix>/rpc; state comes from the status notifications on
+// <prefix>/events/rpc, <prefix>/status/<component> if generic status
+// notifications are enabled, and the answer to Shelly.GetStatus sent when
+// the server subscribes.
+const ShellyTemplate = "shelly_gen2"
+
+// shellyRPCSource is appended to the prefix to form the "src" of requests:
+// Shelly answers on <src>/rpc, a topic only this device's answers use
+const shellyRPCSource = "/homescript"
+
+// shellyOnOff translates switch outputs
+var shellyOnOff = map[string]string{"true": "ON", "false": "OFF"}
+
+// shellyCoverStates translates cover states to the Zigbee2MQTT convention
+var shellyCoverStates = map[string]string{
+	"open":    "OPEN",
+	"closed":  "CLOSE",
+	"opening": "OPENING",
+	"closing": "CLOSING",
+	"stopped": "STOP",
+}
+
+// shellyComponent is one switch, cover or input and the attributes it reports
+type shellyComponent struct {
+	key    string            // "switch:0"
+	fields map[string]string // attribute -> path in the component's status
+	values map[string]map[string]string
+}
+
+// shellyTemplate generates the template of one Shelly device from its vars
+func shellyTemplate(vars map[string]string) (*types.DeviceTemplate, error) {
+	prefix := vars["prefix"]
+	if prefix == "" {
+		return nil, fmt.Errorf("template %s needs vars.prefix", ShellyTemplate)
+	}
+	count := func(name string) (int, error) {
+		if vars[name] == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(vars[name])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid vars.%s: %s", name, vars[name])
+		}
+		return n, nil
+	}
+	switches, err := count("switches")
+	if err != nil {
+		return nil, err
+	}
+	covers, err := count("covers")
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := count("inputs")
+	if err != nil {
+		return nil, err
+	}
+	metering := vars["metering"] == "true"
+
+	rpcTopic := prefix + "/rpc"
+	source := prefix + shellyRPCSource
+	request := func(method, params string) string {
+		payload := fmt.Sprintf(`{"id":1,"src":%q,"method":%q`, source, method)
+		if params != "" {
+			payload += `,"params":` + params
+		}
+		return payload + "}"
+	}
+
+	tmpl := &types.DeviceTemplate{
+		Type:              "switch",
+		Vendor:            "Shelly",
+		Schema:            make(map[string]*types.AttributeSchema),
+		Commands:          make(map[string]*types.TemplateCommand),
+		AvailabilityTopic: prefix + "/online",
+		Refresh:           []*types.TemplateCommand{{Topic: rpcTopic, Payload: request("Shelly.GetStatus", "")}},
+	}
+	var components []shellyComponent
+
+	// name numbers attributes when a device has several components of a kind
+	// (state_1 for switch:0); covers next to switches are cover_state
+	name := func(attr string, i, n int) string {
+		if n > 1 {
+			return fmt.Sprintf("%s_%d", attr, i+1)
+		}
+		return attr
+	}
+	meter := func(c *shellyComponent, i, n int) {
+		if !metering {
+			return
+		}
+		for attr, path := range map[string]string{"power": "apower", "voltage": "voltage", "current": "current", "energy": "aenergy.total"} {
+			c.fields[name(attr, i, n)] = path
+		}
+	}
+
+	for i := 0; i < switches; i++ {
+		attr := name("state", i, switches)
+		c := shellyComponent{
+			key:    fmt.Sprintf("switch:%d", i),
+			fields: map[string]string{attr: "output"},
+			values: map[string]map[string]string{attr: shellyOnOff},
+		}
+		meter(&c, i, switches)
+		components = append(components, c)
+
+		tmpl.Schema[attr] = &types.AttributeSchema{Values: []string{"ON", "OFF"}}
+		tmpl.Commands[attr] = &types.TemplateCommand{
+			Topic:   rpcTopic,
+			Payload: request("Switch.Set", fmt.Sprintf(`{"id":%d,"on":{value}}`, i)),
+			Values:  map[string]string{"ON": "true", "OFF": "false"},
+		}
+	}
+
+	for i := 0; i < covers; i++ {
+		stateAttr := name("state", i, covers)
+		if switches > 0 {
+			stateAttr = name("cover_state", i, covers)
+		}
+		positionAttr := name("position", i, covers)
+		c := shellyComponent{
+			key:    fmt.Sprintf("cover:%d", i),
+			fields: map[string]string{stateAttr: "state", positionAttr: "current_pos"},
+			values: map[string]map[string]string{stateAttr: shellyCoverStates},
+		}
+		meter(&c, i, covers)
+		components = append(components, c)
+
+		min, max := 0.0, 100.0
+		tmpl.Schema[stateAttr] = &types.AttributeSchema{Values: []string{"OPEN", "CLOSE", "STOP"}}
+		tmpl.Schema[positionAttr] = &types.AttributeSchema{Min: &min, Max: &max}
+		tmpl.Commands[stateAttr] = &types.TemplateCommand{
+			Topic:   rpcTopic,
+			Payload: request("Cover.{value}", fmt.Sprintf(`{"id":%d}`, i)),
+			Values:  map[string]string{"OPEN": "Open", "CLOSE": "Close", "STOP": "Stop"},
+		}
+		tmpl.Commands[positionAttr] = &types.TemplateCommand{
+			Topic:   rpcTopic,
+			Payload: request("Cover.GoToPosition", fmt.Sprintf(`{"id":%d,"pos":{value}}`, i)),
+		}
+		tmpl.Type = "cover"
+	}
+
+	for i := 0; i < inputs; i++ {
+		components = append(components, shellyComponent{
+			key:    fmt.Sprintf("input:%d", i),
+			fields: map[string]string{name("input", i, inputs): "state"},
+		})
+	}
+
+	if len(components) == 0 {
+		return nil, fmt.Errorf("template %s: device has neither switches, covers nor inputs", ShellyTemplate)
+	}
+	if switches == 0 && covers == 0 {
+		tmpl.Type = "sensor"
+	}
+
+	// Every component reports on three topics, each wrapping its status differently
+	wrappers := []struct{ topic, path string }{
+		{prefix + "/events/rpc", "params."}, // NotifyStatus (changes only)
+		{source + "/rpc", "result."},        // answer to Shelly.GetStatus
+		{prefix + "/status/", ""},           // generic status notifications
+	}
+	for _, c := range components {
+		attrs := make([]string, 0, len(c.fields))
+		for attr := range c.fields {
+			attrs = append(attrs, attr)
+		}
+		sort.Strings(attrs)
+		tmpl.Attributes = append(tmpl.Attributes, attrs...)
+		for _, w := range wrappers {
+			topic := w.topic
+			if w.path == "" {
+				topic += c.key
+			}
+			fields := make(map[string]string, len(c.fields))
+			for attr, path := range c.fields {
+				if w.path != "" {
+					path = w.path + c.key + "." + path
+				}
+				fields[attr] = path
+			}
+			tmpl.State = append(tmpl.State, &types.TemplateState{Topic: topic, Fields: fields, Values: c.values})
+		}
+	}
+	return tmpl, nil
+}
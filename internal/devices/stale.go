@@ -0,0 +1,118 @@
+package devices
+
+import (
+	"homescript-server/internal/types"
+	"time"
+)
+
+// StaleAttribute is the event attribute of stale/recovered notifications,
+// routed to events/device/<id>/stale/
+const StaleAttribute = "stale"
+
+// LastSeen returns when the last state message of a device arrived; ok is
+// false if none arrived since the server started
+func (m *Manager) LastSeen(id string) (time.Time, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.lastSeen[m.resolve(id)]
+	return t, ok
+}
+
+// staleWindow returns how long a device may stay silent before it is stale (0 = never)
+func staleWindow(dev *types.Device, global time.Duration) time.Duration {
+	if dev.StaleAfter > 0 {
+		return dev.StaleAfter
+	}
+	return global
+}
+
+// StartStaleWatch periodically checks for devices that sent no state message
+// within window (or their own stale_after) and routes a "stale" event for
+// each; a "stale" event with stale = false follows when the device reports
+// again. Devices never heard from count from the time the watch started.
+// Call the returned function to stop watching.
+func (m *Manager) StartStaleWatch(window time.Duration) func() {
+	started := time.Now()
+
+	interval := time.Minute
+	if window > 0 && window/10 < interval {
+		interval = window / 10
+	}
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				m.checkStale(window, started, now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// checkStale marks devices silent for longer than their window as stale
+func (m *Manager) checkStale(window time.Duration, started, now time.Time) {
+	type staleDevice struct {
+		dev      *types.Device
+		lastSeen time.Time
+	}
+	var newlyStale []staleDevice
+
+	m.mu.Lock()
+	for id, dev := range m.devices {
+		if m.virtual[id] != nil || m.stale[id] {
+			continue
+		}
+		limit := staleWindow(dev, window)
+		if limit <= 0 {
+			continue
+		}
+		last, seen := m.lastSeen[id]
+		if !seen {
+			last = started
+		}
+		if now.Sub(last) > limit {
+			m.stale[id] = true
+			newlyStale = append(newlyStale, staleDevice{dev: dev, lastSeen: m.lastSeen[id]})
+		}
+	}
+	router := m.router
+	m.mu.Unlock()
+
+	for _, s := range newlyStale {
+		if s.lastSeen.IsZero() {
+			log.Warn("Device %s has not reported since startup", s.dev.ID)
+		} else {
+			log.Warn("Device %s is stale, last seen %s ago", s.dev.ID, now.Sub(s.lastSeen).Round(time.Second))
+		}
+		if router != nil {
+			router.RouteEvent(staleEvent(s.dev, true, s.lastSeen, now))
+		}
+	}
+}
+
+// staleEvent builds the event routed when a device becomes stale or recovers
+func staleEvent(dev *types.Device, stale bool, lastSeen, now time.Time) *types.Event {
+	data := map[string]interface{}{StaleAttribute: stale}
+	if !lastSeen.IsZero() {
+		data["last_seen"] = lastSeen.Unix()
+		data["silent_for"] = now.Sub(lastSeen).Seconds()
+	}
+	return &types.Event{
+		Source:    "device",
+		Type:      "stale",
+		Device:    dev.ID,
+		Attribute: StaleAttribute,
+		Area:      dev.Area,
+		Data:      data,
+		Timestamp: now,
+	}
+}
@@ -0,0 +1,224 @@
+package devices
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TasmotaTemplate is the built-in template of Tasmota devices. Unlike the
+// other templates its topics are generated from vars:
+//
+//	topic       the Tasmota Topic (required)
+//	full_topic  the FullTopic pattern, default "%prefix%/%topic%/"
+//	prefixes    cmnd,stat,tele prefixes if changed from the defaults
+//	hostname    substituted for %hostname%, mac for %id%
+//	relays      number of POWER outputs, default 1 (0 for pure sensors)
+//	light       dimmer, ct, rgb, rgbw or rgbcw
+//	sensors     attribute=path pairs into tele/<topic>/SENSOR
+//	            ("temperature=AM2301.Temperature,power=ENERGY.Power")
+const TasmotaTemplate = "tasmota"
+
+// tasmotaLightTypes are the light vars values, indexed by Tasmota's lt_st
+var tasmotaLightTypes = []string{"", "dimmer", "ct", "rgb", "rgbw", "rgbcw"}
+
+// TasmotaLightType returns the light vars value of a Tasmota light subtype
+func TasmotaLightType(subtype int) string {
+	if subtype < 0 || subtype >= len(tasmotaLightTypes) {
+		return ""
+	}
+	return tasmotaLightTypes[subtype]
+}
+
+// tasmotaTemplate generates the template of one Tasmota device from its vars
+func tasmotaTemplate(vars map[string]string) (*types.DeviceTemplate, error) {
+	topic := vars["topic"]
+	if topic == "" {
+		return nil, fmt.Errorf("template %s needs vars.topic", TasmotaTemplate)
+	}
+
+	prefixes := []string{"cmnd", "stat", "tele"}
+	if p := vars["prefixes"]; p != "" {
+		parts := strings.Split(p, ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("vars.prefixes must list the cmnd, stat and tele prefixes")
+		}
+		for i := range parts {
+			prefixes[i] = strings.TrimSpace(parts[i])
+		}
+	}
+	fullTopic := vars["full_topic"]
+	if fullTopic == "" {
+		fullTopic = "%prefix%/%topic%/"
+	}
+	mac := strings.ToUpper(strings.ReplaceAll(vars["mac"], ":", ""))
+	if len(mac) > 6 {
+		mac = mac[len(mac)-6:]
+	}
+	topicOf := func(prefix int, command string) string {
+		t := strings.NewReplacer(
+			"%prefix%", prefixes[prefix],
+			"%topic%", topic,
+			"%hostname%", vars["hostname"],
+			"%id%", mac,
+		).Replace(fullTopic)
+		if !strings.HasSuffix(t, "/") {
+			t += "/"
+		}
+		return t + command
+	}
+	cmnd := func(command string) string { return topicOf(0, command) }
+
+	relays := 1
+	if r := vars["relays"]; r != "" {
+		n, err := strconv.Atoi(r)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid vars.relays: %s", r)
+		}
+		relays = n
+	}
+
+	tmpl := &types.DeviceTemplate{
+		Type:              "switch",
+		Vendor:            "Tasmota",
+		Schema:            make(map[string]*types.AttributeSchema),
+		Commands:          make(map[string]*types.TemplateCommand),
+		AvailabilityTopic: topicOf(2, "LWT"),
+	}
+	// stat/RESULT answers commands, tele/STATE repeats the state periodically
+	fields := make(map[string]string)
+
+	onOff := &types.AttributeSchema{Values: []string{"ON", "OFF", "TOGGLE"}}
+	for i := 1; i <= relays; i++ {
+		attr, power := "state", "POWER"
+		if relays > 1 {
+			attr, power = fmt.Sprintf("state_%d", i), fmt.Sprintf("POWER%d", i)
+		}
+		tmpl.Attributes = append(tmpl.Attributes, attr)
+		tmpl.Schema[attr] = onOff
+		tmpl.Commands[attr] = &types.TemplateCommand{Topic: cmnd(power), Payload: "{value}"}
+		fields[attr] = power
+	}
+
+	light := vars["light"]
+	if light != "" {
+		known := false
+		for _, t := range tasmotaLightTypes[1:] {
+			known = known || t == light
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid vars.light: %s (expected dimmer, ct, rgb, rgbw or rgbcw)", light)
+		}
+		tmpl.Type = "light"
+
+		percent := func(max float64) *types.AttributeSchema {
+			min := 0.0
+			return &types.AttributeSchema{Min: &min, Max: &max}
+		}
+		lightAttr := func(attr, command string, schema *types.AttributeSchema) {
+			tmpl.Attributes = append(tmpl.Attributes, attr)
+			if schema != nil {
+				tmpl.Schema[attr] = schema
+			}
+			tmpl.Commands[attr] = &types.TemplateCommand{Topic: cmnd(command), Payload: "{value}"}
+			fields[attr] = command
+		}
+		lightAttr("brightness", "Dimmer", percent(100))
+		if light == "ct" || light == "rgbcw" {
+			min, max := 153.0, 500.0
+			lightAttr("color_temp", "CT", &types.AttributeSchema{Min: &min, Max: &max})
+		}
+		if strings.HasPrefix(light, "rgb") {
+			lightAttr("color", "Color", nil)
+		}
+		if light == "rgbw" {
+			lightAttr("white", "White", percent(100))
+		}
+	}
+
+	if len(fields) > 0 {
+		tmpl.State = append(tmpl.State,
+			&types.TemplateState{Topic: topicOf(1, "RESULT"), Fields: fields},
+			&types.TemplateState{Topic: topicOf(2, "STATE"), Fields: fields},
+		)
+	}
+
+	if sensors := vars["sensors"]; sensors != "" {
+		sensorFields := make(map[string]string)
+		for _, pair := range strings.Split(sensors, ",") {
+			attr, path, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || attr == "" || path == "" {
+				return nil, fmt.Errorf("invalid vars.sensors entry %q (expected attribute=path)", pair)
+			}
+			sensorFields[attr] = path
+			tmpl.Attributes = append(tmpl.Attributes, attr)
+		}
+		tmpl.State = append(tmpl.State, &types.TemplateState{Topic: topicOf(2, "SENSOR"), Fields: sensorFields})
+		if relays == 0 && light == "" {
+			tmpl.Type = "sensor"
+		}
+	}
+
+	if len(tmpl.State) == 0 {
+		return nil, fmt.Errorf("template %s: device has neither relays, light nor sensors", TasmotaTemplate)
+	}
+	return tmpl, nil
+}
+
+// TasmotaSensorVars turns a sample SENSOR payload into the sensors var:
+// every numeric reading becomes an attribute named after it in snake case
+// ("ENERGY.ApparentPower" -> apparent_power), prefixed with the sensor name
+// when two sensors report the same reading (am2301_temperature)
+func TasmotaSensorVars(sample map[string]interface{}) string {
+	type reading struct{ sensor, name string }
+	var readings []reading
+	count := make(map[string]int)
+	for sensor, value := range sample {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			continue // Time, TempUnit, ...
+		}
+		for name, v := range obj {
+			if _, isNumber := v.(float64); !isNumber {
+				continue
+			}
+			readings = append(readings, reading{sensor, name})
+			count[snakeCase(name)]++
+		}
+	}
+
+	pairs := make([]string, 0, len(readings))
+	for _, r := range readings {
+		attr := snakeCase(r.name)
+		if count[attr] > 1 {
+			attr = snakeCase(r.sensor) + "_" + attr
+		}
+		pairs = append(pairs, attr+"="+r.sensor+"."+r.name)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// snakeCase converts Tasmota's CamelCase names ("ApparentPower", "AM2301",
+// "DS18B20-1") into attribute names
+func snakeCase(name string) string {
+	var sb strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			// Start a new word at a lower-to-upper change ("ApparentPower")
+			if i > 0 && runes[i-1] >= 'a' && runes[i-1] <= 'z' {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r + ('a' - 'A'))
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,304 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placeholder matches {name} in template topics and payloads
+var placeholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// builtinTemplates cover common firmware; files in config/devices/templates override them
+var builtinTemplates = map[string]*types.DeviceTemplate{
+	// WLED light with MQTT enabled: vars topic (WLED's device topic, e.g. wled/5f1a2b)
+	"wled": {
+		Type:       "light",
+		Vendor:     "WLED",
+		Attributes: []string{"brightness", "color"},
+		Schema:     map[string]*types.AttributeSchema{"state": {Values: []string{"ON", "OFF", "T"}}},
+		State: []*types.TemplateState{
+			{Topic: "{topic}/g", Attribute: "brightness"}, // 0-255, 0 when off
+			{Topic: "{topic}/c", Attribute: "color"},      // #RRGGBB
+		},
+		Commands: map[string]*types.TemplateCommand{
+			"state":      {Topic: "{topic}", Payload: "{value}"},
+			"brightness": {Topic: "{topic}", Payload: "{value}"},
+			"color":      {Topic: "{topic}/col", Payload: "{value}"},
+		},
+		AvailabilityTopic: "{topic}/status",
+	},
+	// Firmware publishing a JSON object and accepting JSON commands: vars topic
+	"json": {
+		Type:  "sensor",
+		State: []*types.TemplateState{{Topic: "{topic}"}},
+		Commands: map[string]*types.TemplateCommand{
+			"*": {Topic: "{topic}/set"},
+		},
+	},
+}
+
+// generatedTemplates build the template of each device from its vars, for
+// firmware whose topics depend on the hardware (number of relays, sensors)
+var generatedTemplates = map[string]func(vars map[string]string) (*types.DeviceTemplate, error){
+	TasmotaTemplate: tasmotaTemplate,
+	ShellyTemplate:  shellyTemplate,
+	// The former single-relay Tasmota template, what tasmota builds by default
+	"tasmota_switch": tasmotaTemplate,
+}
+
+// LoadTemplates returns the built-in device templates merged with
+// <dir>/<name>.yaml files. A missing directory is not an error.
+func LoadTemplates(dir string) (map[string]*types.DeviceTemplate, error) {
+	templates := make(map[string]*types.DeviceTemplate, len(builtinTemplates))
+	for name, tmpl := range builtinTemplates {
+		templates[name] = tmpl
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return templates, nil
+		}
+		return nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".yaml")
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+		}
+		var tmpl types.DeviceTemplate
+		if err := yaml.Unmarshal(data, &tmpl); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		if len(tmpl.State) == 0 && len(tmpl.Commands) == 0 {
+			return nil, fmt.Errorf("template %s defines neither state topics nor commands", name)
+		}
+		templates[name] = &tmpl
+	}
+
+	return templates, nil
+}
+
+// ApplyTemplates resolves the template of every device that names one. Values
+// set on the device itself (type, attributes, schema, ...) take precedence.
+func ApplyTemplates(devs []*types.Device, templates map[string]*types.DeviceTemplate) error {
+	for _, dev := range devs {
+		if dev.Template == "" {
+			continue
+		}
+		tmpl, ok := templates[dev.Template]
+		if generate := generatedTemplates[dev.Template]; !ok && generate != nil {
+			generated, err := generate(dev.Vars)
+			if err != nil {
+				return fmt.Errorf("device %s: %w", dev.ID, err)
+			}
+			tmpl, ok = generated, true
+		}
+		if !ok {
+			return fmt.Errorf("device %s: unknown template %q", dev.ID, dev.Template)
+		}
+		if err := applyTemplate(dev, tmpl); err != nil {
+			return fmt.Errorf("device %s: %w", dev.ID, err)
+		}
+	}
+	return nil
+}
+
+func applyTemplate(dev *types.Device, tmpl *types.DeviceTemplate) error {
+	vars := map[string]string{"id": dev.ID}
+	for k, v := range dev.Vars {
+		vars[k] = v
+	}
+	expand := func(s string) (string, error) {
+		var missing string
+		result := placeholder.ReplaceAllStringFunc(s, func(m string) string {
+			name := m[1 : len(m)-1]
+			if name == "value" || name == "attribute" {
+				return m // filled in per command
+			}
+			v, ok := vars[name]
+			if !ok {
+				missing = name
+			}
+			return v
+		})
+		if missing != "" {
+			return "", fmt.Errorf("template %s needs vars.%s", dev.Template, missing)
+		}
+		return result, nil
+	}
+
+	if dev.Type == "" {
+		dev.Type = tmpl.Type
+	}
+	if dev.Vendor == "" {
+		dev.Vendor = tmpl.Vendor
+	}
+	if dev.Model == "" {
+		dev.Model = tmpl.Model
+	}
+	if dev.Dialect == "" {
+		dev.Dialect = tmpl.Dialect
+	}
+	if len(dev.Attributes) == 0 {
+		dev.Attributes = tmpl.Attributes
+	}
+	if len(dev.Actions) == 0 {
+		dev.Actions = tmpl.Actions
+	}
+	if dev.Schema == nil {
+		dev.Schema = tmpl.Schema
+	}
+
+	dev.StateTopics = nil
+	for _, st := range tmpl.State {
+		topic, err := expand(st.Topic)
+		if err != nil {
+			return err
+		}
+		dev.StateTopics = append(dev.StateTopics, &types.TemplateState{Topic: topic, Attribute: st.Attribute, Fields: st.Fields, ValueTemplate: st.ValueTemplate, Values: st.Values})
+	}
+
+	dev.Commands = make(map[string]*types.TemplateCommand, len(tmpl.Commands))
+	for attr, cmd := range tmpl.Commands {
+		topic, err := expand(cmd.Topic)
+		if err != nil {
+			return err
+		}
+		payload, err := expand(cmd.Payload)
+		if err != nil {
+			return err
+		}
+		dev.Commands[attr] = &types.TemplateCommand{Topic: topic, Payload: payload, Values: cmd.Values}
+	}
+
+	dev.Refresh = nil
+	for _, cmd := range tmpl.Refresh {
+		topic, err := expand(cmd.Topic)
+		if err != nil {
+			return err
+		}
+		payload, err := expand(cmd.Payload)
+		if err != nil {
+			return err
+		}
+		dev.Refresh = append(dev.Refresh, &types.TemplateCommand{Topic: topic, Payload: payload})
+	}
+
+	if dev.MQTT.AvailabilityTopic == "" && tmpl.AvailabilityTopic != "" {
+		topic, err := expand(tmpl.AvailabilityTopic)
+		if err != nil {
+			return err
+		}
+		dev.MQTT.AvailabilityTopic = topic
+	}
+
+	// Fill the plain topics so tools that only know them (schema, bridge) still work
+	if dev.MQTT.StateTopic == "" && len(dev.StateTopics) > 0 {
+		dev.MQTT.StateTopic = dev.StateTopics[0].Topic
+	}
+	if dev.MQTT.CommandTopic == "" {
+		if cmd := dev.Commands["*"]; cmd != nil {
+			dev.MQTT.CommandTopic = cmd.Topic
+		} else if len(dev.Commands) > 0 {
+			attrs := make([]string, 0, len(dev.Commands))
+			for attr := range dev.Commands {
+				attrs = append(attrs, attr)
+			}
+			sort.Strings(attrs)
+			dev.MQTT.CommandTopic = dev.Commands[attrs[0]].Topic
+		}
+	}
+	return nil
+}
+
+// ParseTemplateState converts a payload received on one of a templated
+// device's state topics into attributes. ok is false if nothing was extracted.
+func ParseTemplateState(st *types.TemplateState, payload []byte) (state map[string]interface{}, ok bool) {
+	state, ok = parseTemplateState(st, payload)
+	for attr, values := range st.Values {
+		if value, found := state[attr]; found {
+			if mapped, known := values[fmt.Sprint(value)]; known {
+				state[attr] = mapped
+			}
+		}
+	}
+	return state, ok
+}
+
+func parseTemplateState(st *types.TemplateState, payload []byte) (state map[string]interface{}, ok bool) {
+	if st.ValueTemplate != "" {
+		value, err := EvalValueTemplate(st.ValueTemplate, payload)
+		if err != nil {
+			log.Debug("Value template on %s: %v", st.Topic, err)
+			return nil, false
+		}
+		if st.Attribute != "" {
+			return map[string]interface{}{st.Attribute: value}, true
+		}
+		if s, isString := value.(string); isString {
+			json.Unmarshal([]byte(s), &value)
+		}
+		obj, isObject := value.(map[string]interface{})
+		return obj, isObject && len(obj) > 0
+	}
+	if st.Attribute != "" {
+		return map[string]interface{}{st.Attribute: plainValue(payload)}, true
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return nil, false
+	}
+	if len(st.Fields) == 0 {
+		return data, len(data) > 0
+	}
+
+	state = make(map[string]interface{})
+	for attr, path := range st.Fields {
+		if value, found := lookupPath(data, path); found {
+			state[attr] = value
+		}
+	}
+	return state, len(state) > 0
+}
+
+// plainValue parses a non-JSON payload: numbers become numbers, anything else a trimmed string
+func plainValue(payload []byte) interface{} {
+	s := strings.TrimSpace(string(payload))
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// lookupPath walks a dotted path ("ENERGY.Power") through nested JSON objects
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	var current interface{} = data
+	for _, part := range parts {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,1052 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EvalValueTemplate renders a Home Assistant value template against an MQTT
+// payload. It covers what device firmware puts in discovery configs rather
+// than full Jinja: {{ value }}, {{ value_json.a.b }}, value_json['a'][0],
+// number, string and list literals, + - * /, comparisons (== != < <= > >= in),
+// and, or, not, the tests "is [not] defined/none/number/string", inline
+// "x if cond else y", {% if %}/{% elif %}/{% else %}/{% endif %} with the
+// {%- -%} whitespace control, and the filters float, int, round(n), abs,
+// bool, string, lower, upper, trim, tojson and default(x).
+//
+// A template that is a single expression yields the expression's value
+// (a JSON number, bool, string or object); anything else yields the rendered
+// text. Numeric text becomes a number either way.
+func EvalValueTemplate(tmpl string, payload []byte) (interface{}, error) {
+	nodes, err := parseTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &templateEnv{value: strings.TrimSpace(string(payload))}
+	var valueJSON interface{}
+	if json.Unmarshal(payload, &valueJSON) == nil {
+		env.valueJSON = valueJSON
+		env.hasJSON = true
+	}
+
+	var parts []interface{}
+	if parts, err = renderNodes(nodes, env, parts); err != nil {
+		return nil, err
+	}
+
+	var exprs []templateResult
+	literal := false
+	for _, part := range parts {
+		switch p := part.(type) {
+		case templateResult:
+			exprs = append(exprs, p)
+		case string:
+			literal = literal || strings.TrimSpace(p) != ""
+		}
+	}
+	if len(exprs) == 1 && !literal {
+		if s, ok := exprs[0].value.(string); ok {
+			return plainValue([]byte(s)), nil
+		}
+		return exprs[0].value, nil
+	}
+
+	var sb strings.Builder
+	for _, part := range parts {
+		switch p := part.(type) {
+		case string:
+			sb.WriteString(p)
+		case templateResult:
+			sb.WriteString(renderValue(p.value))
+		}
+	}
+	return plainValue([]byte(sb.String())), nil
+}
+
+// templateResult marks an evaluated expression among literal text
+type templateResult struct {
+	value interface{}
+}
+
+// templateNode is literal text, an {{ expression }} or an {% if %} block
+type templateNode struct {
+	text     string
+	expr     string
+	isExpr   bool
+	branches []ifBranch // {% if %}, {% elif %}..., {% else %} (cond "")
+}
+
+type ifBranch struct {
+	cond  string
+	nodes []templateNode
+}
+
+// templateTag is a piece of a template: text, {{ }} or {% %}
+type templateTag struct {
+	kind string // "text", "expr" or the statement keyword
+	src  string // text, expression, or the statement's condition
+}
+
+// splitTemplate cuts a template into text, expressions and statements,
+// applying the {{- -}} and {%- -%} whitespace control
+func splitTemplate(tmpl string) ([]templateTag, error) {
+	var tags []templateTag
+	trimNext := false
+	rest := tmpl
+	for rest != "" {
+		start := len(rest)
+		for _, open := range []string{"{{", "{%"} {
+			if i := strings.Index(rest, open); i >= 0 && i < start {
+				start = i
+			}
+		}
+		text := rest[:start]
+		if trimNext {
+			text = strings.TrimLeft(text, " \t\r\n")
+		}
+		if start < len(rest) && strings.HasPrefix(rest[start+2:], "-") {
+			text = strings.TrimRight(text, " \t\r\n")
+		}
+		if text != "" {
+			tags = append(tags, templateTag{kind: "text", src: text})
+		}
+		if start == len(rest) {
+			break
+		}
+
+		closing := "}}"
+		if rest[start+1] == '%' {
+			closing = "%}"
+		}
+		end := strings.Index(rest[start+2:], closing)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated %s in %s", rest[start:start+2], tmpl)
+		}
+		inner := rest[start+2 : start+2+end]
+		rest = rest[start+2+end+2:]
+		inner = strings.TrimPrefix(inner, "-")
+		trimNext = strings.HasSuffix(inner, "-")
+		inner = strings.TrimSpace(strings.TrimSuffix(inner, "-"))
+
+		if closing == "}}" {
+			tags = append(tags, templateTag{kind: "expr", src: inner})
+			continue
+		}
+		keyword, cond, _ := strings.Cut(inner, " ")
+		switch keyword {
+		case "if", "elif", "else", "endif":
+			tags = append(tags, templateTag{kind: keyword, src: strings.TrimSpace(cond)})
+		default:
+			return nil, fmt.Errorf("unsupported template statement {%% %s %%}", inner)
+		}
+	}
+	return tags, nil
+}
+
+// parseTemplate turns a template into nodes, nesting the {% if %} blocks
+func parseTemplate(tmpl string) ([]templateNode, error) {
+	tags, err := splitTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	nodes, pos, err := parseNodes(tags, 0)
+	if err != nil {
+		return nil, err
+	}
+	if pos < len(tags) {
+		return nil, fmt.Errorf("unexpected {%% %s %%} in %s", tags[pos].kind, tmpl)
+	}
+	return nodes, nil
+}
+
+// parseNodes parses tags until the end or an elif, else or endif
+func parseNodes(tags []templateTag, pos int) ([]templateNode, int, error) {
+	var nodes []templateNode
+	for pos < len(tags) {
+		tag := tags[pos]
+		switch tag.kind {
+		case "text":
+			nodes = append(nodes, templateNode{text: tag.src})
+		case "expr":
+			nodes = append(nodes, templateNode{expr: tag.src, isExpr: true})
+		case "if":
+			node, next, err := parseIf(tags, pos)
+			if err != nil {
+				return nil, 0, err
+			}
+			nodes = append(nodes, node)
+			pos = next
+			continue
+		default:
+			return nodes, pos, nil
+		}
+		pos++
+	}
+	return nodes, pos, nil
+}
+
+// parseIf parses an {% if %} block starting at tags[pos]
+func parseIf(tags []templateTag, pos int) (templateNode, int, error) {
+	var node templateNode
+	cond := tags[pos].src
+	if cond == "" {
+		return node, 0, fmt.Errorf("{%% if %%} without a condition")
+	}
+	pos++
+	for {
+		body, next, err := parseNodes(tags, pos)
+		if err != nil {
+			return node, 0, err
+		}
+		node.branches = append(node.branches, ifBranch{cond: cond, nodes: body})
+		if next >= len(tags) {
+			return node, 0, fmt.Errorf("{%% if %%} without {%% endif %%}")
+		}
+		switch tag := tags[next]; tag.kind {
+		case "endif":
+			return node, next + 1, nil
+		case "elif":
+			if cond == "" || tag.src == "" {
+				return node, 0, fmt.Errorf("unexpected {%% elif %%}")
+			}
+			cond = tag.src
+		case "else":
+			if cond == "" {
+				return node, 0, fmt.Errorf("unexpected {%% else %%}")
+			}
+			cond = ""
+		}
+		pos = next + 1
+	}
+}
+
+// renderNodes appends the text and expression values of nodes to parts
+func renderNodes(nodes []templateNode, env *templateEnv, parts []interface{}) ([]interface{}, error) {
+	for _, node := range nodes {
+		switch {
+		case node.isExpr:
+			value, err := env.eval(node.expr)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, templateResult{value})
+		case node.branches != nil:
+			for _, branch := range node.branches {
+				if branch.cond != "" {
+					value, err := env.evalCondition(branch.cond)
+					if err != nil {
+						return nil, err
+					}
+					if !truthy(value) {
+						continue
+					}
+				}
+				var err error
+				if parts, err = renderNodes(branch.nodes, env, parts); err != nil {
+					return nil, err
+				}
+				break
+			}
+		default:
+			parts = append(parts, node.text)
+		}
+	}
+	return parts, nil
+}
+
+// templateEnv holds the variables of one evaluation
+type templateEnv struct {
+	value     string
+	valueJSON interface{}
+	hasJSON   bool
+}
+
+// eval parses and evaluates one {{ }} expression
+func (env *templateEnv) eval(expr string) (interface{}, error) {
+	value, err := env.evalCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	if u, ok := value.(undefined); ok {
+		return nil, fmt.Errorf("%s is undefined", string(u))
+	}
+	return value, nil
+}
+
+// evalCondition parses and evaluates an expression, which may be undefined
+// (false in a condition)
+func (env *templateEnv) evalCondition(expr string) (interface{}, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{env: env, tokens: tokens}
+	eval, err := p.expression()
+	if err != nil {
+		return nil, fmt.Errorf("%v in {{%s}}", err, expr)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in {{%s}}", p.tokens[p.pos].text, expr)
+	}
+	return eval()
+}
+
+// undefined is the value of a missing variable or key; it only survives a
+// default filter, a test or a condition
+type undefined string
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || isLetter(c):
+			j := i + 1
+			for j < len(expr) && (expr[j] == '_' || isLetter(expr[j]) || isDigit(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, expr[i:j]})
+			i = j
+		case isDigit(c):
+			j := i + 1
+			for j < len(expr) && (isDigit(expr[j]) || expr[j] == '.' && j+1 < len(expr) && isDigit(expr[j+1])) {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, expr[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			j := strings.IndexByte(expr[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string in {{%s}}", expr)
+			}
+			tokens = append(tokens, token{tokString, expr[i+1 : i+1+j]})
+			i += j + 2
+		case i+1 < len(expr) && expr[i+1] == '=' && strings.IndexByte("=!<>", c) >= 0:
+			tokens = append(tokens, token{tokPunct, expr[i : i+2]})
+			i += 2
+		case strings.IndexByte(".[]()|,+-*/<>", c) >= 0:
+			tokens = append(tokens, token{tokPunct, string(c)})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in {{%s}}", c, expr)
+		}
+	}
+	return tokens, nil
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// evalFunc evaluates a parsed (sub)expression
+type evalFunc func() (interface{}, error)
+
+// exprParser compiles an expression into an evalFunc, so that only the
+// chosen side of "x if cond else y" is evaluated:
+//
+//	expression := or ("if" or "else" expression)?
+//	or := and ("or" and)*, and := not ("and" not)*, not := "not" not | comparison
+//	comparison := sum ((== != < <= > >= in) sum)*
+//	sum := term (+|- term)*, term := filtered (*|/ filtered)*
+//	filtered := unary (| filter)* ("is" ["not"] test)?
+type exprParser struct {
+	env    *templateEnv
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokPunct && p.tokens[p.pos].text == text
+}
+
+// peekWord reports whether the next token is the keyword word
+func (p *exprParser) peekWord(word string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokIdent && p.tokens[p.pos].text == word
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.peek(text) {
+		return fmt.Errorf("expected %q", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) expression() (evalFunc, error) {
+	then, err := p.or()
+	if err != nil || !p.peekWord("if") {
+		return then, err
+	}
+	p.pos++
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	// Without else, a false condition renders nothing
+	otherwise := evalFunc(func() (interface{}, error) { return "", nil })
+	if p.peekWord("else") {
+		p.pos++
+		if otherwise, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	return func() (interface{}, error) {
+		c, err := cond()
+		if err != nil {
+			return nil, err
+		}
+		if truthy(c) {
+			return then()
+		}
+		return otherwise()
+	}, nil
+}
+
+func (p *exprParser) or() (evalFunc, error) {
+	return p.logical("or", p.and)
+}
+
+func (p *exprParser) and() (evalFunc, error) {
+	return p.logical("and", p.not)
+}
+
+// logical parses operands joined by and/or, which short-circuit and yield
+// the deciding operand like Jinja
+func (p *exprParser) logical(op string, operand func() (evalFunc, error)) (evalFunc, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord(op) {
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func() (interface{}, error) {
+			v, err := l()
+			if err != nil || truthy(v) == (op == "or") {
+				return v, err
+			}
+			return right()
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) not() (evalFunc, error) {
+	if !p.peekWord("not") {
+		return p.comparison()
+	}
+	p.pos++
+	operand, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return func() (interface{}, error) {
+		v, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(v), nil
+	}, nil
+}
+
+func (p *exprParser) comparison() (evalFunc, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.peek("==") || p.peek("!=") || p.peek("<") || p.peek("<=") || p.peek(">") || p.peek(">="):
+			op = p.tokens[p.pos].text
+		case p.peekWord("in"):
+			op = "in"
+		case p.peekWord("not") && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == tokIdent && p.tokens[p.pos+1].text == "in":
+			op = "not in"
+			p.pos++
+		default:
+			return left, nil
+		}
+		p.pos++
+		right, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func() (interface{}, error) {
+			lv, err := l()
+			if err != nil {
+				return nil, err
+			}
+			rv, err := right()
+			if err != nil {
+				return nil, err
+			}
+			return compare(op, lv, rv)
+		}
+	}
+}
+
+func (p *exprParser) sum() (evalFunc, error) {
+	return p.binary(p.term, "+", "-")
+}
+
+func (p *exprParser) term() (evalFunc, error) {
+	return p.binary(p.filtered, "*", "/")
+}
+
+// binary parses operands joined by arithmetic operators of one precedence
+func (p *exprParser) binary(operand func() (evalFunc, error), ops ...string) (evalFunc, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(ops[0]) || p.peek(ops[1]) {
+		op := p.tokens[p.pos].text
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func() (interface{}, error) {
+			lv, err := l()
+			if err != nil {
+				return nil, err
+			}
+			rv, err := right()
+			if err != nil {
+				return nil, err
+			}
+			return arithmetic(op, lv, rv)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) filtered() (evalFunc, error) {
+	value, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("|") {
+		p.pos++
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokIdent {
+			return nil, fmt.Errorf("expected filter name")
+		}
+		name := p.tokens[p.pos].text
+		p.pos++
+		var args []evalFunc
+		if p.peek("(") {
+			p.pos++
+			for !p.peek(")") {
+				arg, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if !p.peek(",") {
+					break
+				}
+				p.pos++
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		v := value
+		value = func() (interface{}, error) {
+			input, err := v()
+			if err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(args))
+			for i, arg := range args {
+				if values[i], err = arg(); err != nil {
+					return nil, err
+				}
+			}
+			return applyFilter(name, input, values)
+		}
+	}
+	if p.peekWord("is") {
+		p.pos++
+		negate := p.peekWord("not")
+		if negate {
+			p.pos++
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokIdent {
+			return nil, fmt.Errorf("expected test name after 'is'")
+		}
+		name := p.tokens[p.pos].text
+		p.pos++
+		v := value
+		value = func() (interface{}, error) {
+			input, err := v()
+			if err != nil {
+				return nil, err
+			}
+			result, err := applyTest(name, input)
+			return result != negate, err
+		}
+	}
+	return value, nil
+}
+
+func (p *exprParser) unary() (evalFunc, error) {
+	if p.peek("-") {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func() (interface{}, error) {
+			v, err := operand()
+			if err != nil {
+				return nil, err
+			}
+			return arithmetic("-", 0.0, v)
+		}, nil
+	}
+	return p.postfix()
+}
+
+func (p *exprParser) postfix() (evalFunc, error) {
+	value, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var key evalFunc
+		switch {
+		case p.peek("."):
+			p.pos++
+			if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokIdent && p.tokens[p.pos].kind != tokNumber {
+				return nil, fmt.Errorf("expected attribute name after '.'")
+			}
+			name := p.tokens[p.pos].text
+			key = func() (interface{}, error) { return name, nil }
+			p.pos++
+		case p.peek("["):
+			p.pos++
+			if key, err = p.expression(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return value, nil
+		}
+		v := value
+		value = func() (interface{}, error) {
+			container, err := v()
+			if err != nil {
+				return nil, err
+			}
+			k, err := key()
+			if err != nil {
+				return nil, err
+			}
+			return index(container, k), nil
+		}
+	}
+}
+
+func (p *exprParser) primary() (evalFunc, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	constant := func(v interface{}) (evalFunc, error) {
+		return func() (interface{}, error) { return v, nil }, nil
+	}
+	switch tok.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok.text)
+		}
+		return constant(f)
+	case tokString:
+		return constant(tok.text)
+	case tokIdent:
+		switch tok.text {
+		case "value":
+			return constant(p.env.value)
+		case "value_json":
+			if !p.env.hasJSON {
+				return constant(undefined("value_json"))
+			}
+			return constant(p.env.valueJSON)
+		case "true", "True":
+			return constant(true)
+		case "false", "False":
+			return constant(false)
+		case "none", "None":
+			return constant(nil)
+		}
+		return constant(undefined(tok.text))
+	}
+	switch tok.text {
+	case "(":
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return value, p.expect(")")
+	case "[":
+		var items []evalFunc
+		for !p.peek("]") {
+			item, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if !p.peek(",") {
+				break
+			}
+			p.pos++
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return func() (interface{}, error) {
+			list := make([]interface{}, len(items))
+			for i, item := range items {
+				var err error
+				if list[i], err = item(); err != nil {
+					return nil, err
+				}
+			}
+			return list, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// truthy tells whether a value counts as true in a condition
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, undefined:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// compare applies a comparison operator; numbers compare as numbers,
+// strings as strings, and == is false between different types
+func compare(op string, left, right interface{}) (interface{}, error) {
+	switch op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in", "not in":
+		found, err := contained(left, right)
+		return found == (op == "in"), err
+	}
+	for _, v := range []interface{}{left, right} {
+		if u, ok := v.(undefined); ok {
+			return nil, fmt.Errorf("%s is undefined", string(u))
+		}
+	}
+	var cmp int
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	ls, lsok := left.(string)
+	rs, rsok := right.(string)
+	switch {
+	case lok && rok:
+		cmp = compareOrdered(l, r)
+	case lsok && rsok:
+		cmp = strings.Compare(ls, rs)
+	default:
+		return nil, fmt.Errorf("cannot compare %v %s %v", renderValue(left), op, renderValue(right))
+	}
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func compareOrdered(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+func equal(left, right interface{}) bool {
+	if _, ok := left.(undefined); ok {
+		return false
+	}
+	if _, ok := right.(undefined); ok {
+		return false
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+// contained implements "needle in haystack" for strings, lists and objects
+func contained(needle, haystack interface{}) (bool, error) {
+	switch h := haystack.(type) {
+	case string:
+		s, ok := needle.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot look for %v in a string", renderValue(needle))
+		}
+		return strings.Contains(h, s), nil
+	case []interface{}:
+		for _, item := range h {
+			if equal(item, needle) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		_, ok := h[renderValue(needle)]
+		return ok, nil
+	case undefined:
+		return false, fmt.Errorf("%s is undefined", string(h))
+	}
+	return false, fmt.Errorf("cannot look for %v in %v", renderValue(needle), renderValue(haystack))
+}
+
+// applyTest implements "x is <test>"
+func applyTest(name string, value interface{}) (bool, error) {
+	_, isUndefined := value.(undefined)
+	switch name {
+	case "defined":
+		return !isUndefined, nil
+	case "undefined":
+		return isUndefined, nil
+	case "none":
+		return value == nil, nil
+	case "number":
+		_, ok := value.(float64)
+		return ok, nil
+	case "string":
+		_, ok := value.(string)
+		return ok, nil
+	}
+	return false, fmt.Errorf("unsupported test %q", name)
+}
+
+// index looks up a key of an object or an element of a list
+func index(value, key interface{}) interface{} {
+	name := renderValue(key)
+	if u, ok := value.(undefined); ok {
+		return undefined(string(u) + "." + name)
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if item, ok := v[name]; ok {
+			return item
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(name); err == nil {
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return v[i]
+			}
+		}
+	}
+	return undefined(name)
+}
+
+func applyFilter(name string, value interface{}, args []interface{}) (interface{}, error) {
+	if name == "default" || name == "d" {
+		if _, ok := value.(undefined); ok && len(args) > 0 {
+			return args[0], nil
+		}
+		return value, nil
+	}
+	if u, ok := value.(undefined); ok {
+		return nil, fmt.Errorf("%s is undefined", string(u))
+	}
+
+	switch name {
+	case "float":
+		f, ok := toNumber(value)
+		if !ok {
+			if len(args) > 0 {
+				return args[0], nil
+			}
+			return nil, fmt.Errorf("cannot convert %v to float", value)
+		}
+		return f, nil
+	case "int":
+		f, ok := toNumber(value)
+		if !ok {
+			if len(args) > 0 {
+				return args[0], nil
+			}
+			return nil, fmt.Errorf("cannot convert %v to int", value)
+		}
+		return math.Trunc(f), nil
+	case "round":
+		f, ok := toNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("cannot round %v", value)
+		}
+		precision := 0.0
+		if len(args) > 0 {
+			precision, _ = toNumber(args[0])
+		}
+		scale := math.Pow(10, precision)
+		return math.Round(f*scale) / scale, nil
+	case "abs":
+		f, ok := toNumber(value)
+		if !ok {
+			return nil, fmt.Errorf("cannot take abs of %v", value)
+		}
+		return math.Abs(f), nil
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			return v != 0, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "on", "yes", "1":
+				return true, nil
+			}
+			return false, nil
+		}
+		return value != nil, nil
+	case "string":
+		return renderValue(value), nil
+	case "lower":
+		return strings.ToLower(renderValue(value)), nil
+	case "upper":
+		return strings.ToUpper(renderValue(value)), nil
+	case "trim":
+		return strings.TrimSpace(renderValue(value)), nil
+	case "tojson", "to_json":
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return nil, fmt.Errorf("unsupported filter %q", name)
+}
+
+func arithmetic(op string, left, right interface{}) (interface{}, error) {
+	for _, v := range []interface{}{left, right} {
+		if u, ok := v.(undefined); ok {
+			return nil, fmt.Errorf("%s is undefined", string(u))
+		}
+	}
+	if op == "+" {
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		if lok && rok {
+			return ls + rs, nil
+		}
+	}
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %v and %v", op, left, right)
+	}
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	}
+	if r == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return l / r, nil
+}
+
+// toNumber converts numbers, numeric strings and bools
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// renderValue prints a value the way Jinja does
+func renderValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "None"
+	case string:
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case undefined:
+		return ""
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
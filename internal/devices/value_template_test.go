@@ -0,0 +1,110 @@
+package devices
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEvalValueTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		payload string
+		want    interface{}
+	}{
+		{"value", "{{ value }}", "ON", "ON"},
+		{"numeric value", "{{ value }}", "21.5", 21.5},
+		{"json path", "{{ value_json.ENERGY.Power }}", `{"ENERGY": {"Power": 42}}`, 42.0},
+		{"json brackets", "{{ value_json['a'][0] }}", `{"a": [7, 8]}`, 7.0},
+		{"negative index", "{{ value_json.a[-1] }}", `{"a": [7, 8]}`, 8.0},
+		{"numeric key", "{{ value_json.a.1 }}", `{"a": [7, 8]}`, 8.0},
+		{"decimal literal", "{{ value | float * 0.1 }}", "215", 21.5},
+		{"index then attribute", "{{ value_json.list.0.name }}", `{"list": [{"name": "x"}]}`, "x"},
+		{"arithmetic precedence", "{{ (value | float - 32) * 5 / 9 }}", "212", 100.0},
+		{"unary minus", "{{ -value_json.t }}", `{"t": 3}`, -3.0},
+		{"string concat", "{{ value_json.a + '-' + value_json.b }}", `{"a": "x", "b": "y"}`, "x-y"},
+		{"float filter", "{{ value | float }}", "3.25", 3.25},
+		{"float default", "{{ value | float(0) }}", "n/a", 0.0},
+		{"int filter", "{{ value | int }}", "3.9", 3.0},
+		{"round", "{{ value_json.t | round(1) }}", `{"t": 21.46}`, 21.5},
+		{"abs", "{{ value | float | abs }}", "-4", 4.0},
+		{"bool", "{{ value | bool }}", "on", true},
+		{"lower", "{{ value_json.state | lower }}", `{"state": "ON"}`, "on"},
+		{"upper trim", "{{ value | trim | upper }}", " off ", "OFF"},
+		{"default", "{{ value_json.missing | default('none') }}", `{}`, "none"},
+		{"tojson", "{{ value_json.a | tojson }}", `{"a": {"b": 1}}`, `{"b":1}`},
+		{"text around expression", "{{ value_json.t }} °C", `{"t": 20}`, "20 °C"},
+		{"two expressions", "{{ value_json.a }}{{ value_json.b }}", `{"a": 1, "b": 2}`, 12.0},
+
+		{"equal", "{{ value_json.state == 'ON' }}", `{"state": "ON"}`, true},
+		{"not equal", "{{ value_json.state != 'ON' }}", `{"state": "ON"}`, false},
+		{"number vs string", "{{ value == 1 }}", "1", false},
+		{"less than", "{{ value | float < 10 }}", "9.5", true},
+		{"greater or equal", "{{ value_json.b >= 50 }}", `{"b": 50}`, true},
+		{"and or", "{{ value_json.a > 1 and value_json.b < 1 or value_json.c }}", `{"a": 2, "b": 0, "c": false}`, true},
+		{"not", "{{ not value_json.on }}", `{"on": false}`, true},
+		{"in list", "{{ value in ['on', 'open'] }}", "open", true},
+		{"not in string", "{{ 'err' not in value }}", "fine", true},
+		{"in object", "{{ 'temp' in value_json }}", `{"temp": 1}`, true},
+		{"is defined", "{{ value_json.t is defined }}", `{"t": 1}`, true},
+		{"is not defined", "{{ value_json.t is not defined }}", `{}`, true},
+		{"is none", "{{ value_json.t is none }}", `{"t": null}`, true},
+		{"is number", "{{ value_json.t is number }}", `{"t": 1}`, true},
+
+		{"inline if", "{{ 'ON' if value_json.state == 1 else 'OFF' }}", `{"state": 1}`, "ON"},
+		{"inline else", "{{ 'ON' if value_json.state == 1 else 'OFF' }}", `{"state": 0}`, "OFF"},
+		{"inline if skips undefined branch", "{{ value_json.t | float if value_json.t is defined else 0 }}", `{}`, 0.0},
+		{"inline if without else", "{{ 'low' if value_json.b < 20 }}", `{"b": 50}`, ""},
+		{"nested inline if", "{{ 'a' if value == 'x' else 'b' if value == 'y' else 'c' }}", "y", "b"},
+
+		{"if statement", "{% if value_json.state == 1 %}ON{% else %}OFF{% endif %}", `{"state": 1}`, "ON"},
+		{"else statement", "{% if value_json.state == 1 %}ON{% else %}OFF{% endif %}", `{"state": 0}`, "OFF"},
+		{"elif statement", "{% if value | int < 10 %}low{% elif value | int < 20 %}mid{% else %}high{% endif %}", "15", "mid"},
+		{"if without match", "{% if value == 'x' %}x{% endif %}", "y", ""},
+		{"if undefined", "{% if value_json.missing %}yes{% else %}no{% endif %}", `{}`, "no"},
+		{"if with expression", "{% if value_json.t is defined %}{{ value_json.t | round(1) }}{% endif %}", `{"t": 1.26}`, 1.3},
+		{"nested if", "{% if value_json.a %}{% if value_json.b %}ab{% else %}a{% endif %}{% endif %}", `{"a": 1, "b": 0}`, "a"},
+		{"whitespace control", "{%- if value == 'on' -%}\n  ON\n{%- else -%}\n  OFF\n{%- endif -%}", "on", "ON"},
+		{"expression whitespace control", "  {{- value -}}  ", "7", 7.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvalValueTemplate(tt.tmpl, []byte(tt.payload))
+			if err != nil {
+				t.Fatalf("EvalValueTemplate(%q, %q): %v", tt.tmpl, tt.payload, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EvalValueTemplate(%q, %q) = %#v, want %#v", tt.tmpl, tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalValueTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		payload string
+	}{
+		{"undefined variable", "{{ value_json.missing }}", `{}`},
+		{"value_json of text", "{{ value_json.a }}", "text"},
+		{"unterminated expression", "{{ value", "1"},
+		{"unterminated statement", "{% if value %}x", "1"},
+		{"missing endif", "{% if value %}x{% else %}y", "1"},
+		{"stray endif", "x{% endif %}", "1"},
+		{"else after else", "{% if value %}a{% else %}b{% else %}c{% endif %}", "1"},
+		{"unsupported statement", "{% for x in value_json %}{{ x }}{% endfor %}", "[1]"},
+		{"unsupported filter", "{{ value | sha1 }}", "1"},
+		{"unsupported test", "{{ value is even }}", "1"},
+		{"cannot compare", "{{ value_json.a < 'x' }}", `{"a": 1}`},
+		{"division by zero", "{{ value | float / 0 }}", "1"},
+		{"trailing tokens", "{{ value value }}", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := EvalValueTemplate(tt.tmpl, []byte(tt.payload)); err == nil {
+				t.Errorf("EvalValueTemplate(%q, %q) = %#v, want an error", tt.tmpl, tt.payload, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// VirtualVendor is the vendor of devices created from the virtual: section
+const VirtualVendor = "virtual"
+
+// EventRouter routes events generated by virtual devices (implemented by events.Router)
+type EventRouter interface {
+	RouteEvent(event *types.Event)
+}
+
+// virtualAttribute returns the single attribute a virtual device type exposes
+func virtualAttribute(devType string) (string, bool) {
+	switch devType {
+	case "switch":
+		return "state", true
+	case "number", "text":
+		return "value", true
+	}
+	return "", false
+}
+
+// SetRouter sets the router that receives state_change events from virtual devices
+func (m *Manager) SetRouter(router EventRouter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.router = router
+}
+
+// SetVirtualDevices registers helpers that exist only in the server
+func (m *Manager) SetVirtualDevices(virtual []*types.VirtualDevice) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, cfg := range virtual {
+		attr, ok := virtualAttribute(cfg.Type)
+		if !ok {
+			log.Warn("Virtual device %s has unknown type %q (expected switch, number or text)", cfg.ID, cfg.Type)
+			continue
+		}
+		if _, exists := m.devices[cfg.ID]; exists {
+			log.Warn("Virtual device %s conflicts with an existing device, skipped", cfg.ID)
+			continue
+		}
+
+		initial, err := normalizeVirtual(cfg, cfg.Initial)
+		if err != nil {
+			log.Warn("Virtual device %s has invalid initial value: %v", cfg.ID, err)
+			initial, _ = normalizeVirtual(cfg, nil)
+		}
+
+		name := cfg.Name
+		if name == "" {
+			name = cfg.ID
+		}
+		m.devices[cfg.ID] = &types.Device{
+			ID:         cfg.ID,
+			Name:       name,
+			Type:       cfg.Type,
+			Vendor:     VirtualVendor,
+			Area:       cfg.Area,
+			Attributes: []string{attr},
+		}
+		m.states[cfg.ID] = map[string]interface{}{attr: initial}
+		m.virtual[cfg.ID] = cfg
+	}
+
+	if len(m.virtual) > 0 {
+		log.Info("Registered %d virtual device(s)", len(m.virtual))
+	}
+}
+
+// IsVirtual reports whether a device is a server-side helper
+func (m *Manager) IsVirtual(id string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.virtual[id]
+	return ok
+}
+
+// normalizeVirtual converts a value to the canonical form for the helper type:
+// "ON"/"OFF" for switches, a float within min/max (snapped to step) for numbers,
+// and a string for text. nil yields the type's zero value.
+func normalizeVirtual(cfg *types.VirtualDevice, value interface{}) (interface{}, error) {
+	switch cfg.Type {
+	case "switch":
+		switch v := value.(type) {
+		case nil:
+			return "OFF", nil
+		case bool:
+			if v {
+				return "ON", nil
+			}
+			return "OFF", nil
+		case string:
+			switch strings.ToUpper(v) {
+			case "ON", "TRUE":
+				return "ON", nil
+			case "OFF", "FALSE":
+				return "OFF", nil
+			}
+		}
+		return nil, fmt.Errorf("switch value must be ON, OFF or a boolean, got %v", value)
+
+	case "number":
+		var f float64
+		switch v := value.(type) {
+		case nil:
+			f = 0
+			if cfg.Min != nil {
+				f = *cfg.Min
+			}
+		case float64:
+			f = v
+		case int:
+			f = float64(v)
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("number value expected, got %q", v)
+			}
+			f = parsed
+		default:
+			return nil, fmt.Errorf("number value expected, got %v", value)
+		}
+		if cfg.Step > 0 {
+			base := 0.0
+			if cfg.Min != nil {
+				base = *cfg.Min
+			}
+			f = base + math.Round((f-base)/cfg.Step)*cfg.Step
+		}
+		if cfg.Min != nil && f < *cfg.Min {
+			return nil, fmt.Errorf("value %v below minimum %v", f, *cfg.Min)
+		}
+		if cfg.Max != nil && f > *cfg.Max {
+			return nil, fmt.Errorf("value %v above maximum %v", f, *cfg.Max)
+		}
+		return f, nil
+
+	case "text":
+		if value == nil {
+			return "", nil
+		}
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	}
+	return nil, fmt.Errorf("unknown virtual device type: %s", cfg.Type)
+}
+
+// setVirtual applies a value to a helper and routes a state_change event if it changed
+func (m *Manager) setVirtual(cfg *types.VirtualDevice, attrs map[string]interface{}) error {
+	attr, _ := virtualAttribute(cfg.Type)
+
+	value, ok := attrs[attr]
+	if !ok || len(attrs) != 1 {
+		return fmt.Errorf("virtual %s %s only accepts attribute %q", cfg.Type, cfg.ID, attr)
+	}
+
+	m.mu.RLock()
+	old := m.states[cfg.ID][attr]
+	m.mu.RUnlock()
+
+	// Switches also accept TOGGLE like Zigbee2MQTT devices
+	if s, isString := value.(string); isString && cfg.Type == "switch" && strings.EqualFold(s, "TOGGLE") {
+		if old == "ON" {
+			value = "OFF"
+		} else {
+			value = "ON"
+		}
+	}
+
+	normalized, err := normalizeVirtual(cfg, value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", cfg.ID, err)
+	}
+
+	state := map[string]interface{}{attr: normalized}
+	m.UpdateState(cfg.ID, state)
+	log.Debug("Set virtual device %s: %v", cfg.ID, normalized)
+
+	if cfg.Mirror {
+		m.publishVirtual(cfg.ID, state)
+	}
+
+	if old == normalized {
+		return nil
+	}
+
+	m.mu.RLock()
+	router := m.router
+	m.mu.RUnlock()
+	if router != nil {
+		router.RouteEvent(&types.Event{
+			Source:    "device",
+			Type:      "state_change",
+			Device:    cfg.ID,
+			Attribute: attr,
+			Area:      cfg.Area,
+			Data: map[string]interface{}{
+				attr:        normalized,
+				"old_value": old,
+			},
+			Timestamp: time.Now(),
+		})
+	}
+	return nil
+}
+
+// StartVirtualMirror publishes mirrored helpers (retained) under <prefix>/<id>
+// and accepts commands on <prefix>/<id>/set
+func (m *Manager) StartVirtualMirror(prefix string) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	m.mu.Lock()
+	m.virtualPrefix = prefix
+	var mirrored []string
+	for id, cfg := range m.virtual {
+		if cfg.Mirror {
+			mirrored = append(mirrored, id)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(mirrored) == 0 {
+		return nil
+	}
+
+	topic := prefix + "/+/set"
+	token := m.client.Subscribe(topic, 0, m.handleVirtualCommand)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+
+	for _, id := range mirrored {
+		if state, err := m.Get(id); err == nil {
+			m.publishVirtual(id, state)
+		}
+	}
+
+	log.Info("Mirroring %d virtual device(s) under %s/", len(mirrored), prefix)
+	return nil
+}
+
+// publishVirtual publishes a helper's state as retained JSON
+func (m *Manager) publishVirtual(id string, state map[string]interface{}) {
+	m.mu.RLock()
+	prefix := m.virtualPrefix
+	client := m.client
+	m.mu.RUnlock()
+
+	if prefix == "" {
+		return
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		log.Debug("Failed to marshal virtual device %s: %v", id, err)
+		return
+	}
+
+	token := client.Publish(prefix+"/"+id, 0, true, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		log.Warn("Publish timeout mirroring virtual device %s", id)
+		return
+	}
+	if token.Error() != nil {
+		log.Warn("Failed to mirror virtual device %s: %v", id, token.Error())
+	}
+}
+
+// handleVirtualCommand applies <prefix>/<id>/set payloads: a JSON object
+// ({"state": "ON"}) or a bare value for the helper's only attribute
+func (m *Manager) handleVirtualCommand(_ mqtt.Client, msg mqtt.Message) {
+	m.mu.RLock()
+	prefix := m.virtualPrefix
+	m.mu.RUnlock()
+
+	id := strings.TrimSuffix(strings.TrimPrefix(msg.Topic(), prefix+"/"), "/set")
+
+	m.mu.RLock()
+	cfg, ok := m.virtual[id]
+	m.mu.RUnlock()
+	if !ok || !cfg.Mirror {
+		log.Debug("Ignoring command for unknown mirrored device: %s", msg.Topic())
+		return
+	}
+
+	attrs := make(map[string]interface{})
+	if err := json.Unmarshal(msg.Payload(), &attrs); err != nil {
+		var value interface{}
+		if err := json.Unmarshal(msg.Payload(), &value); err != nil {
+			value = string(msg.Payload())
+		}
+		attr, _ := virtualAttribute(cfg.Type)
+		attrs = map[string]interface{}{attr: value}
+	}
+
+	if err := m.setVirtual(cfg, attrs); err != nil {
+		log.Error("Virtual device command %s failed: %v", msg.Topic(), err)
+	}
+}
@@ -0,0 +1,103 @@
+package discovery
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"regexp"
+	"strings"
+)
+
+// SetFilter keeps only devices matching one of the include rules (all if
+// there are none) and none of the exclude rules
+func (d *MQTTDiscovery) SetFilter(include, exclude []types.DiscoveryRule) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.include = include
+	d.exclude = exclude
+}
+
+// allowed reports whether dev passes the filter (caller holds d.mu)
+func (d *MQTTDiscovery) allowed(dev *types.Device) bool {
+	if len(d.include) > 0 {
+		included := false
+		for _, rule := range d.include {
+			if MatchRule(rule, dev) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, rule := range d.exclude {
+		if MatchRule(rule, dev) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchRule reports whether dev matches every field set in rule. A rule
+// without fields matches nothing.
+func MatchRule(rule types.DiscoveryRule, dev *types.Device) bool {
+	if rule == (types.DiscoveryRule{}) {
+		return false
+	}
+	if rule.Vendor != "" && !matchGlob(rule.Vendor, dev.Vendor) {
+		return false
+	}
+	if rule.Model != "" && !matchGlob(rule.Model, dev.Model) {
+		return false
+	}
+	if rule.Name != "" && !matchGlob(rule.Name, dev.Name) {
+		return false
+	}
+	if rule.ID != "" && !matchGlob(rule.ID, dev.ID) {
+		return false
+	}
+	if rule.Topic != "" && !strings.HasPrefix(dev.MQTT.StateTopic, rule.Topic) {
+		return false
+	}
+	return true
+}
+
+// matchGlob matches s against a case-insensitive pattern where * is any
+// text (including /) and ? a single character
+func matchGlob(pattern, s string) bool {
+	expr := regexp.QuoteMeta(strings.ToLower(pattern))
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(strings.ToLower(s))
+}
+
+// ParseRule parses a rule given on the command line as comma-separated
+// field=pattern pairs, e.g. "vendor=IKEA,name=0x*"
+func ParseRule(s string) (types.DiscoveryRule, error) {
+	var rule types.DiscoveryRule
+	for _, part := range strings.Split(s, ",") {
+		field, pattern, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || pattern == "" {
+			return rule, fmt.Errorf("invalid discovery rule %q: expected field=pattern", s)
+		}
+		switch strings.ToLower(field) {
+		case "vendor":
+			rule.Vendor = pattern
+		case "model":
+			rule.Model = pattern
+		case "name":
+			rule.Name = pattern
+		case "id":
+			rule.ID = pattern
+		case "topic":
+			rule.Topic = pattern
+		default:
+			return rule, fmt.Errorf("invalid discovery rule %q: unknown field %q (vendor, model, name, id, topic)", s, field)
+		}
+	}
+	return rule, nil
+}
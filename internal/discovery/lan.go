@@ -0,0 +1,445 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/types"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	mdnsAddress = "224.0.0.251:5353"
+	ssdpAddress = "239.255.255.250:1900"
+	// mdnsEnumeration lists the service types announced on the network
+	mdnsEnumeration = "_services._dns-sd._udp.local."
+)
+
+// lanServices are the mDNS service types queried directly and the kind of
+// device behind them ("": decided by the instance name)
+var lanServices = map[string]string{
+	"_esphomelib._tcp.local.": "esphome",
+	"_shelly._tcp.local.":     "shelly",
+	"_wled._tcp.local.":       "wled",
+	"_rtsp._tcp.local.":       "camera",
+	"_axis-video._tcp.local.": "camera",
+	"_http._tcp.local.":       "", // Shelly Gen1, older WLED
+}
+
+// lanHints tell how to get each kind of device onto MQTT
+var lanHints = map[string]string{
+	"esphome": "Add the mqtt: component to its ESPHome config; it then announces its entities through Home Assistant discovery",
+	"shelly":  "Enable MQTT in the device's web UI (Settings > MQTT, with RPC and generic status notifications)",
+	"wled":    "Enable MQTT under Config > Sync Interfaces; the device topic defaults to wled/<last 6 MAC digits>",
+	"camera":  "Add its stream to Frigate; Frigate's MQTT events then appear as frigate/ devices",
+}
+
+// ScanLAN looks for devices on the local network with mDNS and SSDP for the
+// given time. Devices already publishing on MQTT show up here as well; see
+// UnknownLAN.
+func ScanLAN(timeout time.Duration) ([]*types.LANDevice, error) {
+	var (
+		wg                 sync.WaitGroup
+		mdnsDevs, ssdpDevs []*types.LANDevice
+		mdnsErr, ssdpErr   error
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		mdnsDevs, mdnsErr = scanMDNS(timeout)
+	}()
+	go func() {
+		defer wg.Done()
+		ssdpDevs, ssdpErr = scanSSDP(timeout)
+	}()
+	wg.Wait()
+
+	if mdnsErr != nil && ssdpErr != nil {
+		return nil, fmt.Errorf("LAN scan failed: mDNS: %v, SSDP: %v", mdnsErr, ssdpErr)
+	}
+	if mdnsErr != nil {
+		log.Warn("mDNS scan failed: %v", mdnsErr)
+	}
+	if ssdpErr != nil {
+		log.Warn("SSDP scan failed: %v", ssdpErr)
+	}
+
+	// mDNS names devices better; SSDP only adds what mDNS didn't find
+	found := mdnsDevs
+	seen := make(map[string]bool)
+	for _, dev := range mdnsDevs {
+		seen[dev.Kind+"/"+dev.Address] = true
+	}
+	for _, dev := range ssdpDevs {
+		if !seen[dev.Kind+"/"+dev.Address] {
+			seen[dev.Kind+"/"+dev.Address] = true
+			found = append(found, dev)
+		}
+	}
+
+	for _, dev := range found {
+		dev.Hint = lanHints[dev.Kind]
+		dev.Suggested = lanSuggestion(dev)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Kind != found[j].Kind {
+			return found[i].Kind < found[j].Kind
+		}
+		return found[i].Name < found[j].Name
+	})
+	return found, nil
+}
+
+// UnknownLAN drops LAN devices that match an MQTT device by ID or name
+func UnknownLAN(found []*types.LANDevice, known []*types.Device) []*types.LANDevice {
+	names := make(map[string]bool)
+	for _, dev := range known {
+		names[dev.ID] = true
+		if _, suffix, ok := strings.Cut(dev.ID, "/"); ok {
+			names[suffix] = true
+		}
+		names[SanitizeID(dev.Name)] = true
+	}
+
+	var unknown []*types.LANDevice
+	for _, dev := range found {
+		if names[SanitizeID(dev.Name)] || (dev.Suggested != nil && names[dev.Suggested.ID]) {
+			continue
+		}
+		unknown = append(unknown, dev)
+	}
+	return unknown
+}
+
+// lanSuggestion returns the devices.yaml entry of a device with a built-in
+// template, nil for the others
+func lanSuggestion(dev *types.LANDevice) *types.LANSuggestion {
+	switch dev.Kind {
+	case "shelly":
+		// Gen1 devices speak a different protocol
+		if dev.Info["gen"] == "" || dev.Info["gen"] == "1" {
+			return nil
+		}
+		// The default MQTT prefix is the device ID, which is also its mDNS name
+		prefix := strings.ToLower(dev.Name)
+		return &types.LANSuggestion{
+			ID:       "shelly/" + SanitizeID(prefix),
+			Name:     dev.Name,
+			Template: devices.ShellyTemplate,
+			Vars:     map[string]string{"prefix": prefix, "switches": "1"},
+		}
+	case "wled":
+		mac := strings.ToLower(strings.ReplaceAll(dev.Info["mac"], ":", ""))
+		if len(mac) < 6 {
+			return nil
+		}
+		topic := "wled/" + mac[len(mac)-6:]
+		return &types.LANSuggestion{
+			ID:       SanitizeID(dev.Name),
+			Name:     dev.Name,
+			Template: "wled",
+			Vars:     map[string]string{"topic": topic},
+		}
+	}
+	return nil
+}
+
+// mdnsInstance is one announced service instance
+type mdnsInstance struct {
+	service string
+	kind    string
+	host    string
+	port    int
+	txt     map[string]string
+	address string
+}
+
+// scanMDNS sends one-shot mDNS queries (answered by unicast to our port) for
+// the known service types and the service enumeration, and queries newly
+// enumerated types once
+func scanMDNS(timeout time.Duration) ([]*types.LANDevice, error) {
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	queried := make(map[string]bool)
+	query := func(names ...string) error {
+		var questions []dnsmessage.Question
+		for _, name := range names {
+			if queried[name] {
+				continue
+			}
+			queried[name] = true
+			n, err := dnsmessage.NewName(name)
+			if err != nil {
+				continue
+			}
+			questions = append(questions, dnsmessage.Question{Name: n, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+		}
+		if len(questions) == 0 {
+			return nil
+		}
+		msg := dnsmessage.Message{Questions: questions}
+		packet, err := msg.Pack()
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteToUDP(packet, group)
+		return err
+	}
+
+	names := []string{mdnsEnumeration}
+	for service := range lanServices {
+		names = append(names, service)
+	}
+	if err := query(names...); err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]*mdnsInstance)
+	hosts := make(map[string]string)
+	instance := func(name string) *mdnsInstance {
+		inst := instances[name]
+		if inst == nil {
+			inst = &mdnsInstance{txt: make(map[string]string)}
+			instances[name] = inst
+		}
+		return inst
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // deadline
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		var newTypes []string
+		records := append(msg.Answers, msg.Additionals...)
+		for _, rr := range records {
+			name := rr.Header.Name.String()
+			switch body := rr.Body.(type) {
+			case *dnsmessage.PTRResource:
+				target := body.PTR.String()
+				if name == mdnsEnumeration {
+					newTypes = append(newTypes, target)
+					continue
+				}
+				inst := instance(target)
+				inst.service = name
+				inst.kind = lanServices[name]
+				inst.address = from.IP.String()
+			case *dnsmessage.SRVResource:
+				inst := instance(name)
+				inst.host = body.Target.String()
+				inst.port = int(body.Port)
+				if inst.address == "" {
+					inst.address = from.IP.String()
+				}
+			case *dnsmessage.TXTResource:
+				inst := instance(name)
+				for _, entry := range body.TXT {
+					if k, v, ok := strings.Cut(entry, "="); ok {
+						inst.txt[strings.ToLower(k)] = v
+					}
+				}
+			case *dnsmessage.AResource:
+				hosts[name] = net.IP(body.A[:]).String()
+			}
+		}
+		if err := query(newTypes...); err != nil {
+			log.Debug("mDNS query failed: %v", err)
+		}
+	}
+
+	var found []*types.LANDevice
+	for name, inst := range instances {
+		if inst.service == "" {
+			continue // TXT or SRV of a service we didn't ask for
+		}
+		label := strings.TrimSuffix(name, "."+inst.service)
+		label = strings.ReplaceAll(label, `\ `, " ")
+		kind := inst.kind
+		if kind == "" {
+			kind = kindByName(label)
+		}
+		if kind == "" {
+			log.Debug("Skipping mDNS service %s", name)
+			continue
+		}
+
+		address := inst.address
+		if ip, ok := hosts[inst.host]; ok {
+			address = ip
+		}
+		dev := &types.LANDevice{
+			Kind:    kind,
+			Name:    label,
+			Address: address,
+			Port:    inst.port,
+			Source:  "mdns",
+			Info:    inst.txt,
+		}
+		if friendly := inst.txt["friendly_name"]; friendly != "" {
+			dev.Name = friendly
+		}
+		dev.Model = firstOf(inst.txt, "app", "board", "model")
+		if len(dev.Info) == 0 {
+			dev.Info = nil
+		}
+		found = append(found, dev)
+	}
+	return found, nil
+}
+
+// upnpDescription is the part of a UPnP device description we use
+type upnpDescription struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+	} `xml:"device"`
+}
+
+// scanSSDP sends an M-SEARCH for all devices and reads the description of
+// every answering device to recognize cameras
+func scanSSDP(timeout time.Duration) ([]*types.LANDevice, error) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: ssdp:all\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(search), group); err != nil {
+		return nil, err
+	}
+
+	// Devices answer once per service; one description per address is enough
+	locations := make(map[string]string)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break // deadline
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); location != "" {
+			if _, ok := locations[from.IP.String()]; !ok {
+				locations[from.IP.String()] = location
+			}
+		}
+	}
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	var found []*types.LANDevice
+	for address, location := range locations {
+		desc, err := fetchDescription(client, location)
+		if err != nil {
+			log.Debug("Failed to read UPnP description of %s: %v", address, err)
+			continue
+		}
+		d := desc.Device
+		kind := kindByName(d.FriendlyName)
+		deviceType := strings.ToLower(d.DeviceType)
+		if strings.Contains(deviceType, "camera") || strings.Contains(deviceType, "networkvideo") {
+			kind = "camera"
+		}
+		if kind == "" {
+			log.Debug("Skipping UPnP device %s (%s)", d.FriendlyName, d.DeviceType)
+			continue
+		}
+
+		name := d.FriendlyName
+		if name == "" {
+			name = address
+		}
+		info := map[string]string{"device_type": d.DeviceType, "location": location}
+		if d.Manufacturer != "" {
+			info["manufacturer"] = d.Manufacturer
+		}
+		found = append(found, &types.LANDevice{
+			Kind:    kind,
+			Name:    name,
+			Address: address,
+			Source:  "ssdp",
+			Model:   d.ModelName,
+			Info:    info,
+		})
+	}
+	return found, nil
+}
+
+// fetchDescription downloads and parses a UPnP device description
+func fetchDescription(client *http.Client, location string) (*upnpDescription, error) {
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var desc upnpDescription
+	if err := xml.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+// kindByName recognizes devices announced under generic services by their
+// default names ("shelly1-E8DB84D4B7A3", "WLED-Kitchen")
+func kindByName(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, "shelly"):
+		return "shelly"
+	case strings.HasPrefix(lower, "wled"):
+		return "wled"
+	}
+	return ""
+}
+
+// firstOf returns the first non-empty value of keys in m
+func firstOf(m map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if m[k] != "" {
+			return m[k]
+		}
+	}
+	return ""
+}
@@ -6,6 +6,7 @@ import (
 	"homescript-server/internal/devices"
 	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +14,8 @@ import (
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+var log = logger.Module("discovery")
+
 // MQTTDiscovery handles device discovery from MQTT
 type MQTTDiscovery struct {
 	client               mqtt.Client
@@ -23,6 +26,14 @@ type MQTTDiscovery struct {
 	zigbeeReceived       bool
 	frigateReceived      bool
 	homeAssistantDevices map[string]*homeAssistantEntity // Track HA entities by topic
+	topics               types.Topics
+	zigbeeDevices        map[string][]string     // Zigbee2MQTT base topic -> device IDs
+	answered             map[string]bool         // Zigbee2MQTT and Frigate base topics that answered
+	tasmota              map[string]*tasmotaNode // Tasmota devices by MAC
+	shelly               map[string]*shellyNode  // Shelly devices by topic prefix
+	shellyPrefixes       []string                // in query order (request IDs)
+	include              []types.DiscoveryRule
+	exclude              []types.DiscoveryRule
 }
 
 // homeAssistantEntity tracks a Home Assistant discovered entity
@@ -40,9 +51,20 @@ func New(client mqtt.Client) *MQTTDiscovery {
 		client:               client,
 		devices:              make(map[string]*types.Device),
 		homeAssistantDevices: make(map[string]*homeAssistantEntity),
+		topics:               types.DefaultTopics(),
+		zigbeeDevices:        make(map[string][]string),
+		answered:             make(map[string]bool),
+		tasmota:              make(map[string]*tasmotaNode),
+		shelly:               make(map[string]*shellyNode),
 	}
 }
 
+// SetTopics sets the integration base topics to discover under (defaults
+// to types.DefaultTopics)
+func (d *MQTTDiscovery) SetTopics(topics types.Topics) {
+	d.topics = topics.WithDefaults()
+}
+
 // SetHAManager sets the HA device manager
 func (d *MQTTDiscovery) SetHAManager(haManager *devices.HADeviceManager) {
 	d.haManager = haManager
@@ -55,85 +77,104 @@ func (d *MQTTDiscovery) SetOnChange(fn func([]*types.Device)) {
 
 // Start begins listening for device discovery messages
 func (d *MQTTDiscovery) Start() error {
-	logger.Debug("Starting MQTT discovery subscriptions...")
-
-	// Subscribe to Zigbee2MQTT devices
-	logger.Debug("Subscribing to zigbee2mqtt/bridge/devices...")
-	token := d.client.Subscribe("zigbee2mqtt/bridge/devices", 0, d.handleDevices)
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to subscribe to zigbee2mqtt: %w", token.Error())
-	}
+	log.Debug("Starting MQTT discovery subscriptions...")
+
+	// Subscribe to the devices of every Zigbee2MQTT instance
+	for _, base := range d.topics.Zigbee2MQTT {
+		base := base
+		topic := base + "/bridge/devices"
+		log.Debug("Subscribing to %s...", topic)
+		token := d.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+			d.handleDevices(base, msg)
+		})
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+		}
 
-	// Request current device list
-	token = d.client.Publish("zigbee2mqtt/bridge/config/devices/get", 0, false, "")
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to request zigbee2mqtt devices: %w", token.Error())
+		// Request current device list
+		token = d.client.Publish(base+"/bridge/config/devices/get", 0, false, "")
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to request %s devices: %w", base, token.Error())
+		}
 	}
 
 	// Subscribe to Frigate camera activity for instant camera discovery
-	logger.Debug("Subscribing to frigate/camera_activity...")
-	token = d.client.Subscribe("frigate/camera_activity", 0, d.handleFrigateCameraActivity)
-	if token.Wait() && token.Error() != nil {
-		logger.Debug("Failed to subscribe to frigate/camera_activity (Frigate not available?): %v", token.Error())
-	} else {
-		logger.Debug("Successfully subscribed to frigate/camera_activity for camera discovery")
+	for _, base := range d.topics.Frigate {
+		base := base
+		topic := base + "/camera_activity"
+		log.Debug("Subscribing to %s...", topic)
+		token := d.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+			d.handleFrigateCameraActivity(base, msg)
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Debug("Failed to subscribe to %s (Frigate not available?): %v", topic, token.Error())
+			continue
+		}
+		log.Debug("Successfully subscribed to %s for camera discovery", topic)
 
 		// Trigger Frigate to send camera_activity immediately
 		// According to docs: frigate/onConnect triggers immediate frigate/camera_activity response
-		logger.Debug("Publishing to frigate/onConnect to trigger immediate camera_activity...")
-		token = d.client.Publish("frigate/onConnect", 0, false, "ON")
+		log.Debug("Publishing to %s/onConnect to trigger immediate camera_activity...", base)
+		token = d.client.Publish(base+"/onConnect", 0, false, "ON")
 		if token.Wait() && token.Error() != nil {
-			logger.Debug("Failed to trigger frigate/onConnect: %v", token.Error())
+			log.Debug("Failed to trigger %s/onConnect: %v", base, token.Error())
 		} else {
-			logger.Debug("Successfully triggered frigate/onConnect with 'ON'")
+			log.Debug("Successfully triggered %s/onConnect with 'ON'", base)
 		}
 	}
 
+	// Subscribe to Tasmota's own discovery (retained, answers right away)
+	d.subscribeTasmota()
+
+	// Query Shelly Gen2+ devices announced by their retained online flag
+	d.subscribeShelly()
+
 	// Subscribe to Home Assistant MQTT Discovery
 	// Support both formats:
 	//   - homeassistant/<component>/<object_id>/config (4 parts)
 	//   - homeassistant/<component>/<node_id>/<object_id>/config (5 parts)
+	prefix := d.topics.HomeAssistant
 
 	// Subscribe to 5-part format
-	logger.Debug("Subscribing to homeassistant/+/+/+/config (5-part format)...")
-	token = d.client.Subscribe("homeassistant/+/+/+/config", 0, d.handleHomeAssistantDiscovery)
+	log.Debug("Subscribing to %s/+/+/+/config (5-part format)...", prefix)
+	token := d.client.Subscribe(prefix+"/+/+/+/config", 0, d.handleHomeAssistantDiscovery)
 	if token.Wait() && token.Error() != nil {
-		logger.Debug("Failed to subscribe to HA discovery 5-part format: %v", token.Error())
+		log.Debug("Failed to subscribe to HA discovery 5-part format: %v", token.Error())
 	} else {
-		logger.Debug("Successfully subscribed to HA discovery (5-part)")
+		log.Debug("Successfully subscribed to HA discovery (5-part)")
 	}
 
 	// Subscribe to 4-part format (simplified)
-	logger.Debug("Subscribing to homeassistant/+/+/config (4-part format)...")
-	token = d.client.Subscribe("homeassistant/+/+/config", 0, d.handleHomeAssistantDiscovery)
+	log.Debug("Subscribing to %s/+/+/config (4-part format)...", prefix)
+	token = d.client.Subscribe(prefix+"/+/+/config", 0, d.handleHomeAssistantDiscovery)
 	if token.Wait() && token.Error() != nil {
-		logger.Debug("Failed to subscribe to HA discovery 4-part format: %v", token.Error())
+		log.Debug("Failed to subscribe to HA discovery 4-part format: %v", token.Error())
 	} else {
-		logger.Debug("Successfully subscribed to HA discovery (4-part)")
+		log.Debug("Successfully subscribed to HA discovery (4-part)")
 
 		// Publish birth message to announce our presence
 		// This tells HA-aware devices that we're online and ready
-		logger.Debug("Publishing birth message to homeassistant/status...")
-		token = d.client.Publish("homeassistant/status", 1, true, "online")
+		log.Debug("Publishing birth message to %s/status...", prefix)
+		token = d.client.Publish(prefix+"/status", 1, true, "online")
 		if token.Wait() && token.Error() != nil {
-			logger.Debug("Failed to publish birth message: %v", token.Error())
+			log.Debug("Failed to publish birth message: %v", token.Error())
 		} else {
-			logger.Debug("Successfully published birth message")
+			log.Debug("Successfully published birth message")
 		}
 	}
 
-	logger.Info("MQTT discovery started")
+	log.Info("MQTT discovery started")
 	return nil
 }
 
 // Discover performs a one-time discovery with timeout
 func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 	if err := d.Start(); err != nil {
-		logger.Debug("Failed to start discovery: %v", err)
+		log.Debug("Failed to start discovery: %v", err)
 		return nil
 	}
 
-	logger.Debug("Discovering devices (timeout: %v)...", timeout)
+	log.Debug("Discovering devices (timeout: %v)...", timeout)
 
 	// Wait for Zigbee2MQTT devices (usually responds in 1-2 seconds)
 	zigbeeTimeout := 5 * time.Second
@@ -148,7 +189,7 @@ func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 		d.mu.RUnlock()
 
 		if received {
-			logger.Debug("Zigbee2MQTT devices received")
+			log.Debug("Zigbee2MQTT devices received")
 			break
 		}
 		time.Sleep(100 * time.Millisecond)
@@ -164,7 +205,7 @@ func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 	}
 
 	if frigateTimeout > 0 {
-		logger.Debug("Waiting up to %v for Frigate cameras...", frigateTimeout)
+		log.Debug("Waiting up to %v for Frigate cameras...", frigateTimeout)
 		deadline = time.Now().Add(frigateTimeout)
 		for time.Now().Before(deadline) {
 			d.mu.RLock()
@@ -172,7 +213,7 @@ func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 			d.mu.RUnlock()
 
 			if received {
-				logger.Debug("Frigate cameras received")
+				log.Debug("Frigate cameras received")
 				break
 			}
 			time.Sleep(100 * time.Millisecond)
@@ -185,7 +226,7 @@ func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 	d.mu.RUnlock()
 
 	if !frigateReceived {
-		logger.Debug("No Frigate cameras detected (Frigate may not be available)")
+		log.Debug("No Frigate cameras detected (Frigate may not be available)")
 	}
 
 	// Wait for Home Assistant discovery messages (retained messages should arrive quickly)
@@ -198,7 +239,7 @@ func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 	}
 
 	if haTimeout > 0 {
-		logger.Debug("Waiting up to %v for Home Assistant devices...", haTimeout)
+		log.Debug("Waiting up to %v for Home Assistant devices...", haTimeout)
 		initialHACount := 0
 		d.mu.RLock()
 		// Count HA devices (those with "ha/" prefix)
@@ -238,37 +279,59 @@ func (d *MQTTDiscovery) Discover(timeout time.Duration) []*types.Device {
 		d.mu.RUnlock()
 
 		if haCount > 0 {
-			logger.Debug("Found %d Home Assistant device(s)", haCount)
+			log.Debug("Found %d Home Assistant device(s)", haCount)
 
 			// Wait additional time for state_topic messages to populate attributes
-			logger.Debug("Waiting 2s for HA state messages to discover attributes...")
+			log.Debug("Waiting 2s for HA state messages to discover attributes...")
 			time.Sleep(2 * time.Second)
 		} else {
-			logger.Debug("No Home Assistant devices detected")
+			log.Debug("No Home Assistant devices detected")
 		}
 	}
 
+	devices := d.GetDevices()
 	d.mu.RLock()
 	deviceCount = len(d.devices)
 	d.mu.RUnlock()
 
-	logger.Info("Discovery complete: found %d device(s)", deviceCount)
+	if skipped := deviceCount - len(devices); skipped > 0 {
+		log.Info("Discovery complete: found %d device(s), %d skipped by filters", deviceCount, skipped)
+	} else {
+		log.Info("Discovery complete: found %d device(s)", deviceCount)
+	}
 
-	return d.GetDevices()
+	return devices
 }
 
-// GetDevices returns discovered devices
+// GetDevices returns discovered devices that pass the filter
 func (d *MQTTDiscovery) GetDevices() []*types.Device {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
 	devices := make([]*types.Device, 0, len(d.devices))
 	for _, dev := range d.devices {
-		devices = append(devices, dev)
+		if d.allowed(dev) {
+			devices = append(devices, dev)
+		}
 	}
 	return devices
 }
 
+// AnsweredBases returns the Zigbee2MQTT and Frigate base topics whose
+// instance answered, so that devices of an instance that timed out aren't
+// taken as gone
+func (d *MQTTDiscovery) AnsweredBases() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	bases := make([]string, 0, len(d.answered))
+	for base := range d.answered {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	return bases
+}
+
 // GetHAConfigs returns all HA discovery configs (deviceID -> config)
 func (d *MQTTDiscovery) GetHAConfigs() map[string]*types.HomeAssistantDiscovery {
 	d.mu.RLock()
@@ -276,20 +339,27 @@ func (d *MQTTDiscovery) GetHAConfigs() map[string]*types.HomeAssistantDiscovery
 
 	configs := make(map[string]*types.HomeAssistantDiscovery)
 	for _, entity := range d.homeAssistantDevices {
+		if dev := d.devices[entity.deviceID]; dev != nil && !d.allowed(dev) {
+			continue
+		}
 		configs[entity.deviceID] = entity.config
 	}
 	return configs
 }
 
-func (d *MQTTDiscovery) handleDevices(_ mqtt.Client, msg mqtt.Message) {
+// handleDevices replaces the devices of the Zigbee2MQTT instance at base
+func (d *MQTTDiscovery) handleDevices(base string, msg mqtt.Message) {
 	var z2mDevices []types.Zigbee2MQTTDevice
 	if err := json.Unmarshal(msg.Payload(), &z2mDevices); err != nil {
-		logger.Debug("Failed to parse devices: %v", err)
+		log.Debug("Failed to parse devices: %v", err)
 		return
 	}
 
 	d.mu.Lock()
-	d.devices = make(map[string]*types.Device)
+	for _, id := range d.zigbeeDevices[base] {
+		delete(d.devices, id)
+	}
+	ids := make([]string, 0, len(z2mDevices))
 
 	for _, z2mDev := range z2mDevices {
 		// Skip coordinator and devices without definition
@@ -297,24 +367,31 @@ func (d *MQTTDiscovery) handleDevices(_ mqtt.Client, msg mqtt.Message) {
 			continue
 		}
 
-		dev := d.convertToDevice(z2mDev)
+		dev := d.convertToDevice(base, z2mDev)
+		if existing, ok := d.devices[dev.ID]; ok {
+			log.Warn("Zigbee2MQTT device %s on %s has the same ID as %s, keeping the first", dev.ID, base, existing.MQTT.StateTopic)
+			continue
+		}
 		d.devices[dev.ID] = dev
+		ids = append(ids, dev.ID)
 	}
+	d.zigbeeDevices[base] = ids
 
 	d.zigbeeReceived = true
+	d.answered[base] = true
 	d.mu.Unlock()
 
-	logger.Debug("Discovered %d Zigbee2MQTT device(s)", len(d.devices))
+	log.Debug("Discovered %d Zigbee2MQTT device(s) on %s", len(ids), base)
 
 	if d.onChange != nil {
 		d.onChange(d.GetDevices())
 	}
 }
 
-func (d *MQTTDiscovery) convertToDevice(z2m types.Zigbee2MQTTDevice) *types.Device {
+func (d *MQTTDiscovery) convertToDevice(base string, z2m types.Zigbee2MQTTDevice) *types.Device {
 	// Zigbee devices: no prefix (default source) or "zigbee/" if you prefer explicit
 	// For simplicity, we'll keep them without prefix as they're the default
-	deviceID := sanitizeID(z2m.FriendlyName)
+	deviceID := SanitizeID(z2m.FriendlyName)
 
 	dev := &types.Device{
 		ID:         deviceID,
@@ -324,8 +401,9 @@ func (d *MQTTDiscovery) convertToDevice(z2m types.Zigbee2MQTTDevice) *types.Devi
 		Attributes: make([]string, 0),
 		Actions:    make([]string, 0),
 		MQTT: types.MQTTConfig{
-			StateTopic:   fmt.Sprintf("zigbee2mqtt/%s", z2m.FriendlyName),
-			CommandTopic: fmt.Sprintf("zigbee2mqtt/%s/set", z2m.FriendlyName),
+			StateTopic:        fmt.Sprintf("%s/%s", base, z2m.FriendlyName),
+			CommandTopic:      fmt.Sprintf("%s/%s/set", base, z2m.FriendlyName),
+			AvailabilityTopic: fmt.Sprintf("%s/%s/availability", base, z2m.FriendlyName),
 		},
 	}
 
@@ -339,12 +417,14 @@ func (d *MQTTDiscovery) convertToDevice(z2m types.Zigbee2MQTTDevice) *types.Devi
 		// Handle direct properties (like battery, linkquality)
 		if expose.Property != "" && expose.Property != "state" {
 			attrMap[expose.Property] = true
+			addSchema(dev, expose.Property, expose.ValueMin, expose.ValueMax, expose.Values)
 		}
 
 		// Handle features
 		for _, feature := range expose.Features {
 			if feature.Property != "" {
 				attrMap[feature.Property] = true
+				addSchema(dev, feature.Property, feature.ValueMin, feature.ValueMax, featureValues(feature))
 
 				// Generate actions for state property
 				if feature.Property == "state" {
@@ -367,7 +447,34 @@ func (d *MQTTDiscovery) convertToDevice(z2m types.Zigbee2MQTTDevice) *types.Devi
 	return dev
 }
 
-func sanitizeID(name string) string {
+// addSchema records value constraints exposed by Zigbee2MQTT for an attribute
+func addSchema(dev *types.Device, attr string, min, max *float64, values []string) {
+	if min == nil && max == nil && len(values) == 0 {
+		return
+	}
+	if dev.Schema == nil {
+		dev.Schema = make(map[string]*types.AttributeSchema)
+	}
+	dev.Schema[attr] = &types.AttributeSchema{Min: min, Max: max, Values: values}
+}
+
+// featureValues returns enum values, or the on/off/toggle values of a binary feature
+func featureValues(feature types.Zigbee2MQTTFeature) []string {
+	if len(feature.Values) > 0 {
+		return feature.Values
+	}
+
+	var values []string
+	for _, v := range []interface{}{feature.ValueOn, feature.ValueOff, feature.ValueToggle} {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// SanitizeID turns a friendly name into a device ID ("Living Room Lamp" -> "living_room_lamp")
+func SanitizeID(name string) string {
 	// Replace spaces and special characters with underscores
 	id := strings.ToLower(name)
 	id = strings.ReplaceAll(id, " ", "_")
@@ -384,10 +491,11 @@ func sanitizeID(name string) string {
 	return result
 }
 
-// createFrigateCameraDevice creates a Device object for a Frigate camera
-func createFrigateCameraDevice(cameraName string) *types.Device {
+// createFrigateCameraDevice creates a Device object for a camera of the
+// Frigate instance at base
+func createFrigateCameraDevice(base, cameraName string) *types.Device {
 	// Frigate devices: frigate/<camera_name>
-	deviceID := "frigate/" + sanitizeID(cameraName)
+	deviceID := "frigate/" + SanitizeID(cameraName)
 
 	return &types.Device{
 		ID:     deviceID,
@@ -414,6 +522,12 @@ func createFrigateCameraDevice(cameraName string) *types.Device {
 			"dog",
 			"cat",
 			"all", // All detected objects count
+			// Stream health (from frigate/stats)
+			"camera_fps",
+			"detection_fps",
+			"process_fps",
+			"skipped_fps",
+			"degraded", // Below the server.yaml frigate thresholds
 		},
 		Actions: []string{
 			// Actions map to frigate/{camera_name}/{action}/set topics
@@ -435,23 +549,23 @@ func createFrigateCameraDevice(cameraName string) *types.Device {
 		MQTT: types.MQTTConfig{
 			// State topic pattern for configuration states
 			// frigate/{camera_name}/detect/state, frigate/{camera_name}/enabled/state, etc.
-			StateTopic: fmt.Sprintf("frigate/%s/#", cameraName),
+			StateTopic: fmt.Sprintf("%s/%s/#", base, cameraName),
 			// Command topic base - each action constructs its own topic
-			CommandTopic: fmt.Sprintf("frigate/%s", cameraName),
+			CommandTopic: fmt.Sprintf("%s/%s", base, cameraName),
 		},
 	}
 }
 
-func (d *MQTTDiscovery) handleFrigateStats(_ mqtt.Client, msg mqtt.Message) {
-	logger.Debug("Received Frigate stats message")
+func (d *MQTTDiscovery) handleFrigateStats(base string, msg mqtt.Message) {
+	log.Debug("Received Frigate stats message")
 
 	var stats types.FrigateStats
 	if err := json.Unmarshal(msg.Payload(), &stats); err != nil {
-		logger.Debug("Failed to parse Frigate stats: %v", err)
+		log.Debug("Failed to parse Frigate stats: %v", err)
 		return
 	}
 
-	logger.Debug("Parsed Frigate stats, found %d camera(s)", len(stats.Cameras))
+	log.Debug("Parsed Frigate stats, found %d camera(s)", len(stats.Cameras))
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -459,37 +573,38 @@ func (d *MQTTDiscovery) handleFrigateStats(_ mqtt.Client, msg mqtt.Message) {
 	// Convert each camera to a Device
 	for cameraName := range stats.Cameras {
 		// Frigate devices: frigate/<camera_name>
-		deviceID := "frigate/" + sanitizeID(cameraName)
+		deviceID := "frigate/" + SanitizeID(cameraName)
 
 		// Skip if already added
 		if _, exists := d.devices[deviceID]; exists {
-			logger.Debug("Camera %s already in devices", cameraName)
+			log.Debug("Camera %s already in devices", cameraName)
 			continue
 		}
 
 		// Create device for camera
-		dev := createFrigateCameraDevice(cameraName)
+		dev := createFrigateCameraDevice(base, cameraName)
 		d.devices[deviceID] = dev
-		logger.Debug("Discovered Frigate camera: %s", cameraName)
+		log.Debug("Discovered Frigate camera: %s", cameraName)
 	}
 
 	d.frigateReceived = true
+	d.answered[base] = true
 
 	if d.onChange != nil {
 		d.onChange(d.GetDevices())
 	}
 }
 
-func (d *MQTTDiscovery) handleFrigateCameraActivity(_ mqtt.Client, msg mqtt.Message) {
-	logger.Debug("Received Frigate camera_activity message")
+func (d *MQTTDiscovery) handleFrigateCameraActivity(base string, msg mqtt.Message) {
+	log.Debug("Received Frigate camera_activity message")
 
 	var cameraActivity types.FrigateCameraActivity
 	if err := json.Unmarshal(msg.Payload(), &cameraActivity); err != nil {
-		logger.Debug("Failed to parse Frigate camera_activity: %v", err)
+		log.Debug("Failed to parse Frigate camera_activity: %v", err)
 		return
 	}
 
-	logger.Debug("Parsed Frigate camera_activity, found %d camera(s)", len(cameraActivity))
+	log.Debug("Parsed Frigate camera_activity, found %d camera(s)", len(cameraActivity))
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -497,21 +612,22 @@ func (d *MQTTDiscovery) handleFrigateCameraActivity(_ mqtt.Client, msg mqtt.Mess
 	// Convert each camera to a Device
 	for cameraName := range cameraActivity {
 		// Frigate devices: frigate/<camera_name>
-		deviceID := "frigate/" + sanitizeID(cameraName)
+		deviceID := "frigate/" + SanitizeID(cameraName)
 
 		// Skip if already added
 		if _, exists := d.devices[deviceID]; exists {
-			logger.Debug("Camera %s already in devices", cameraName)
+			log.Debug("Camera %s already in devices", cameraName)
 			continue
 		}
 
 		// Create device for camera
-		dev := createFrigateCameraDevice(cameraName)
+		dev := createFrigateCameraDevice(base, cameraName)
 		d.devices[deviceID] = dev
-		logger.Debug("Discovered Frigate camera: %s", cameraName)
+		log.Debug("Discovered Frigate camera: %s", cameraName)
 	}
 
 	d.frigateReceived = true
+	d.answered[base] = true
 
 	if d.onChange != nil {
 		d.onChange(d.GetDevices())
@@ -526,76 +642,42 @@ func (d *MQTTDiscovery) handleHomeAssistantDiscovery(_ mqtt.Client, msg mqtt.Mes
 	topic := msg.Topic()
 	payload := msg.Payload()
 
-	logger.Debug("Received Home Assistant discovery message: %s", topic)
+	log.Debug("Received Home Assistant discovery message: %s", topic)
 
-	// Parse topic - support both 4-part and 5-part formats
-	parts := strings.Split(topic, "/")
-
-	var component, nodeID, objectID string
-
-	if len(parts) == 4 && parts[0] == "homeassistant" && parts[3] == "config" {
-		// Simplified format: homeassistant/<component>/<object_id>/config
-		component = parts[1]
-		objectID = parts[2]
-		nodeID = parts[2] // Use object_id as node_id in simplified format
-	} else if len(parts) == 5 && parts[0] == "homeassistant" && parts[4] == "config" {
-		// Full format: homeassistant/<component>/<node_id>/<object_id>/config
-		component = parts[1]
-		nodeID = parts[2]
-		objectID = parts[3]
-	} else {
-		logger.Debug("Invalid HA discovery topic format: %s (expected 4 or 5 parts)", topic)
+	component, nodeID, objectID, ok := devices.ParseHADiscoveryTopic(d.topics.HomeAssistant, topic)
+	if !ok {
+		log.Debug("Invalid HA discovery topic format: %s (expected under %s/, 4 or 5 parts)", topic, d.topics.HomeAssistant)
 		return
 	}
 
 	// Empty payload means device was removed
 	if len(payload) == 0 {
-		logger.Debug("HA device removed: %s/%s/%s", component, nodeID, objectID)
+		log.Debug("HA device removed: %s/%s/%s", component, nodeID, objectID)
 		d.removeHomeAssistantDevice(topic)
 		return
 	}
 
 	// Parse discovery config
-	var config types.HomeAssistantDiscovery
-	if err := json.Unmarshal(payload, &config); err != nil {
-		logger.Debug("Failed to parse HA discovery config: %v", err)
+	config, err := devices.ParseHADiscovery(payload)
+	if err != nil {
+		log.Debug("Failed to parse HA discovery config: %v", err)
 		return
 	}
 
-	logger.Debug("Discovered HA entity: %s (name=%s, unique_id=%s)", topic, config.Name, config.UniqueID)
+	log.Debug("Discovered HA entity: %s (name=%s, unique_id=%s)", topic, config.Name, config.UniqueID)
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	// Create internal device ID - prefer unique_id, fallback to object_id/node_id
-	var deviceID string
-	if config.UniqueID != "" {
-		// Use unique_id as device ID (most reliable)
-		deviceID = sanitizeID(config.UniqueID)
-	} else if config.Device != nil && len(config.Device.Identifiers) > 0 {
-		// Use device identifier
-		deviceID = sanitizeID(config.Device.Identifiers[0])
-	} else if objectID != nodeID {
-		// 5-part format - use object_id
-		deviceID = sanitizeID(objectID)
-	} else {
-		// 4-part format - use node_id
-		deviceID = sanitizeID(nodeID)
-	}
-
-	// Home Assistant devices: ha/<device_id>
-	deviceID = "ha/" + deviceID
-
-	// Get appropriate state_topic for this component type
-	stateTopic := getHAStateTopic(component, &config)
-	// Don't set single command_topic for HA devices - they use multiple topic per attribute
-	commandTopic := ""
+	newDev := NewHomeAssistantDevice(component, nodeID, objectID, config)
+	deviceID := newDev.ID
+	stateTopic := newDev.MQTT.StateTopic
 
 	// Store entity info (don't track attributes for HA devices - they come dynamically from state_topic)
 	entity := &homeAssistantEntity{
 		component:  component,
 		objectID:   objectID,
-		config:     &config,
+		config:     config,
 		deviceID:   deviceID,
 		attributes: []string{}, // Empty - HA devices send attributes dynamically
 	}
@@ -604,62 +686,85 @@ func (d *MQTTDiscovery) handleHomeAssistantDiscovery(_ mqtt.Client, msg mqtt.Mes
 	// Create or update device
 	dev, exists := d.devices[deviceID]
 	if !exists {
-		// Create new device
-		dev = &types.Device{
-			ID:   deviceID,
-			Name: config.Name,
-			Type: mapHAComponentToType(component),
-			MQTT: types.MQTTConfig{
-				StateTopic:   stateTopic,
-				CommandTopic: commandTopic,
-			},
-			Attributes: []string{},
-			Actions:    []string{},
-		}
-
-		if config.Device != nil {
-			dev.Model = config.Device.Model
-			dev.Vendor = config.Device.Manufacturer
-			if dev.Name == "" {
-				dev.Name = config.Device.Name
-			}
-		}
-
+		dev = newDev
 		d.devices[deviceID] = dev
-		logger.Debug("Created HA device: %s (type=%s)", deviceID, dev.Type)
+		log.Debug("Created HA device: %s (type=%s)", deviceID, dev.Type)
 
 		// Register HA config with HADeviceManager for multi-topic command handling
 		if d.haManager != nil {
-			d.haManager.RegisterDevice(deviceID, &config)
+			d.haManager.RegisterDevice(deviceID, config)
 		}
 	} else {
 		// Update MQTT topics if not set (first entity with topics wins)
 		if dev.MQTT.StateTopic == "" && stateTopic != "" {
 			dev.MQTT.StateTopic = stateTopic
 		}
-		if dev.MQTT.CommandTopic == "" && commandTopic != "" {
-			dev.MQTT.CommandTopic = commandTopic
-		}
 	}
 
 	// Don't add attributes for HA devices - they send attributes dynamically in state_topic JSON
 	// Scaffold will generate typical attributes for examples
 
 	// Add actions based on THIS entity's component type and config
-	actions := getHAActions(component, &config)
+	actions := getHAActions(component, config)
 	for _, action := range actions {
 		if !contains(dev.Actions, action) {
 			dev.Actions = append(dev.Actions, action)
 		}
 	}
 
-	logger.Debug("HA device %s now has %d actions", deviceID, len(dev.Actions))
+	log.Debug("HA device %s now has %d actions", deviceID, len(dev.Actions))
 
 	if d.onChange != nil {
 		d.onChange(d.GetDevices())
 	}
 }
 
+// NewHomeAssistantDevice creates the device of a Home Assistant entity. Its
+// ID is ha/<unique_id>, falling back to the first device identifier and then
+// the object ID; its state topics follow devices.HAStateTopics.
+func NewHomeAssistantDevice(component, nodeID, objectID string, config *types.HomeAssistantDiscovery) *types.Device {
+	var deviceID string
+	if config.UniqueID != "" {
+		// Use unique_id as device ID (most reliable)
+		deviceID = SanitizeID(config.UniqueID)
+	} else if config.Device != nil && len(config.Device.Identifiers) > 0 {
+		// Use device identifier
+		deviceID = SanitizeID(config.Device.Identifiers[0])
+	} else if objectID != nodeID {
+		// 5-part format - use object_id
+		deviceID = SanitizeID(objectID)
+	} else {
+		// 4-part format - use node_id
+		deviceID = SanitizeID(nodeID)
+	}
+
+	// Don't set single command_topic for HA devices - they use multiple topic per attribute
+	dev := &types.Device{
+		ID:   "ha/" + deviceID,
+		Name: config.Name,
+		Type: mapHAComponentToType(component),
+		MQTT: types.MQTTConfig{
+			StateTopic:        getHAStateTopic(component, config),
+			AvailabilityTopic: config.AvailabilityTopic,
+		},
+		Attributes:  []string{},
+		Actions:     getHAActions(component, config),
+		StateTopics: devices.HAStateTopics(component, config),
+	}
+
+	if config.Device != nil {
+		dev.Model = config.Device.Model
+		dev.Vendor = config.Device.Manufacturer
+		if config.Device.SuggestedArea != "" {
+			dev.Area = SanitizeID(config.Device.SuggestedArea)
+		}
+		if dev.Name == "" {
+			dev.Name = config.Device.Name
+		}
+	}
+	return dev
+}
+
 func (d *MQTTDiscovery) removeHomeAssistantDevice(topic string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -682,7 +787,7 @@ func (d *MQTTDiscovery) removeHomeAssistantDevice(topic string) {
 
 	if !hasOtherEntities {
 		delete(d.devices, deviceID)
-		logger.Debug("Removed HA device: %s", deviceID)
+		log.Debug("Removed HA device: %s", deviceID)
 
 		if d.onChange != nil {
 			d.onChange(d.GetDevices())
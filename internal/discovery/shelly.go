@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/types"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// shellyDiscoverySource is the "src" of discovery requests; Shelly answers
+// on <src>/rpc
+const shellyDiscoverySource = "homescript-discovery"
+
+// shellyNode is a Shelly Gen2+ device being discovered, by topic prefix
+type shellyNode struct {
+	info     *shellyDeviceInfo
+	status   map[string]json.RawMessage
+	deviceID string
+}
+
+// shellyDeviceInfo is the result of Shelly.GetDeviceInfo
+type shellyDeviceInfo struct {
+	Name  *string `json:"name"`
+	ID    string  `json:"id"`
+	Model string  `json:"model"`
+	App   string  `json:"app"`
+}
+
+// shellyResponse is a JSON-RPC answer
+type shellyResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+}
+
+// subscribeShelly finds Shelly Gen2+ devices by their retained
+// <prefix>/online message and asks each for its info and status. Only the
+// default prefixes (the device ID, "shellyplus1pm-a8032ab12345") are
+// recognized; devices with a custom prefix are added by hand.
+func (d *MQTTDiscovery) subscribeShelly() {
+	token := d.client.Subscribe(shellyDiscoverySource+"/rpc", 0, d.handleShellyResponse)
+	if token.Wait() && token.Error() != nil {
+		log.Debug("Failed to subscribe to Shelly responses: %v", token.Error())
+		return
+	}
+
+	log.Debug("Subscribing to +/online for Shelly devices...")
+	token = d.client.Subscribe("+/online", 0, func(_ mqtt.Client, msg mqtt.Message) {
+		prefix := strings.TrimSuffix(msg.Topic(), "/online")
+		if !strings.HasPrefix(strings.ToLower(prefix), "shelly") || string(msg.Payload()) != "true" {
+			return
+		}
+
+		d.mu.Lock()
+		if _, known := d.shelly[prefix]; known {
+			d.mu.Unlock()
+			return
+		}
+		d.shelly[prefix] = &shellyNode{}
+		d.shellyPrefixes = append(d.shellyPrefixes, prefix)
+		n := len(d.shellyPrefixes)
+		d.mu.Unlock()
+
+		// Request IDs encode the device (2n: info, 2n+1: status)
+		for i, method := range []string{"Shelly.GetDeviceInfo", "Shelly.GetStatus"} {
+			request := fmt.Sprintf(`{"id":%d,"src":%q,"method":%q}`, 2*n+i, shellyDiscoverySource, method)
+			token := d.client.Publish(prefix+"/rpc", 0, false, request)
+			if token.Wait() && token.Error() != nil {
+				log.Debug("Failed to query Shelly device %s: %v", prefix, token.Error())
+			}
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Debug("Failed to subscribe to +/online: %v", token.Error())
+	}
+}
+
+// handleShellyResponse collects the answers to discovery requests and adds
+// the device once both arrived
+func (d *MQTTDiscovery) handleShellyResponse(_ mqtt.Client, msg mqtt.Message) {
+	var resp shellyResponse
+	if err := json.Unmarshal(msg.Payload(), &resp); err != nil || len(resp.Result) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	index := resp.ID/2 - 1
+	if index < 0 || index >= len(d.shellyPrefixes) {
+		d.mu.Unlock()
+		return
+	}
+	prefix := d.shellyPrefixes[index]
+	node := d.shelly[prefix]
+
+	var err error
+	if resp.ID%2 == 0 {
+		var info shellyDeviceInfo
+		err = json.Unmarshal(resp.Result, &info)
+		node.info = &info
+	} else {
+		err = json.Unmarshal(resp.Result, &node.status)
+	}
+	if err != nil {
+		d.mu.Unlock()
+		log.Debug("Failed to parse Shelly response from %s: %v", prefix, err)
+		return
+	}
+	if node.info == nil || node.status == nil || node.deviceID != "" {
+		d.mu.Unlock()
+		return
+	}
+
+	dev := shellyDevice(prefix, node.info, node.status)
+	if existing, ok := d.devices[dev.ID]; ok {
+		log.Warn("Shelly device %s has the same ID as %s, keeping the first", prefix, existing.Name)
+	} else {
+		d.devices[dev.ID] = dev
+		node.deviceID = dev.ID
+		log.Debug("Discovered Shelly device: %s (%s)", dev.ID, dev.Model)
+	}
+	d.mu.Unlock()
+
+	if d.onChange != nil {
+		d.onChange(d.GetDevices())
+	}
+}
+
+// shellyDevice creates a device using the shelly_gen2 template from the
+// components listed in its status
+func shellyDevice(prefix string, info *shellyDeviceInfo, status map[string]json.RawMessage) *types.Device {
+	counts := make(map[string]int)
+	metering := false
+	for key, raw := range status {
+		kind, _, ok := strings.Cut(key, ":")
+		if !ok {
+			continue // sys, wifi, mqtt, ...
+		}
+		counts[kind]++
+		if kind == "switch" || kind == "cover" {
+			var component struct {
+				APower *float64 `json:"apower"`
+			}
+			if json.Unmarshal(raw, &component) == nil && component.APower != nil {
+				metering = true
+			}
+		}
+	}
+
+	vars := map[string]string{"prefix": prefix}
+	for kind, name := range map[string]string{"switch": "switches", "cover": "covers", "input": "inputs"} {
+		if counts[kind] > 0 {
+			vars[name] = strconv.Itoa(counts[kind])
+		}
+	}
+	if metering {
+		vars["metering"] = "true"
+	}
+
+	name := prefix
+	if info.Name != nil && *info.Name != "" {
+		name = *info.Name
+	}
+	model := info.App
+	if model == "" {
+		model = info.Model
+	}
+
+	dev := &types.Device{
+		ID:       "shelly/" + SanitizeID(prefix),
+		Name:     name,
+		Model:    model,
+		Vendor:   "Shelly",
+		Template: devices.ShellyTemplate,
+		Vars:     vars,
+	}
+	// Resolve now so devices.yaml lists the type and attributes; the topics
+	// are generated again from the vars at startup
+	if err := devices.ApplyTemplates([]*types.Device{dev}, nil); err != nil {
+		log.Debug("Shelly device %s: %v", dev.ID, err)
+		dev.Type = "sensor"
+	}
+	return dev
+}
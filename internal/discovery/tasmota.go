@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"encoding/json"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/types"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// tasmotaDiscoveryPrefix is where Tasmota 9.2+ announces itself (fixed in the
+// firmware). With SetOption19 1 Tasmota uses Home Assistant discovery
+// instead, which is handled like any other HA device.
+const tasmotaDiscoveryPrefix = "tasmota/discovery"
+
+// tasmotaNode is what one Tasmota device (by MAC) announced so far
+type tasmotaNode struct {
+	config   *types.TasmotaDiscovery
+	sensors  map[string]interface{}
+	deviceID string
+}
+
+// subscribeTasmota follows the retained config and sensors messages
+func (d *MQTTDiscovery) subscribeTasmota() {
+	for _, kind := range []string{"config", "sensors"} {
+		topic := tasmotaDiscoveryPrefix + "/+/" + kind
+		log.Debug("Subscribing to %s...", topic)
+		token := d.client.Subscribe(topic, 0, d.handleTasmotaDiscovery)
+		if token.Wait() && token.Error() != nil {
+			log.Debug("Failed to subscribe to %s: %v", topic, token.Error())
+		}
+	}
+}
+
+// handleTasmotaDiscovery processes tasmota/discovery/<mac>/config and
+// tasmota/discovery/<mac>/sensors; an empty payload removes the device
+func (d *MQTTDiscovery) handleTasmotaDiscovery(_ mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), tasmotaDiscoveryPrefix+"/"), "/")
+	if len(parts) != 2 {
+		return
+	}
+	mac, kind := parts[0], parts[1]
+
+	d.mu.Lock()
+	node := d.tasmota[mac]
+	if node == nil {
+		node = &tasmotaNode{}
+		d.tasmota[mac] = node
+	}
+
+	switch kind {
+	case "config":
+		if len(msg.Payload()) == 0 {
+			node.config = nil
+			break
+		}
+		var cfg types.TasmotaDiscovery
+		if err := json.Unmarshal(msg.Payload(), &cfg); err != nil {
+			d.mu.Unlock()
+			log.Debug("Failed to parse Tasmota discovery %s: %v", msg.Topic(), err)
+			return
+		}
+		node.config = &cfg
+	case "sensors":
+		var sensors types.TasmotaSensors
+		if len(msg.Payload()) > 0 {
+			if err := json.Unmarshal(msg.Payload(), &sensors); err != nil {
+				d.mu.Unlock()
+				log.Debug("Failed to parse Tasmota sensors %s: %v", msg.Topic(), err)
+				return
+			}
+		}
+		node.sensors = sensors.Sensors
+	default:
+		d.mu.Unlock()
+		return
+	}
+
+	if node.deviceID != "" {
+		delete(d.devices, node.deviceID)
+		node.deviceID = ""
+	}
+	if node.config != nil {
+		dev := tasmotaDevice(node.config, node.sensors)
+		if existing, ok := d.devices[dev.ID]; ok {
+			log.Warn("Tasmota device %s (%s) has the same ID as %s, keeping the first", dev.ID, mac, existing.Name)
+		} else {
+			d.devices[dev.ID] = dev
+			node.deviceID = dev.ID
+			log.Debug("Discovered Tasmota device: %s (%s)", dev.ID, node.config.Model)
+		}
+	}
+	d.mu.Unlock()
+
+	if d.onChange != nil {
+		d.onChange(d.GetDevices())
+	}
+}
+
+// tasmotaDevice creates a device using the tasmota template, whose vars
+// carry the topic layout, relays, light and sensors announced by the device
+func tasmotaDevice(cfg *types.TasmotaDiscovery, sensors map[string]interface{}) *types.Device {
+	name := cfg.DeviceName
+	if len(cfg.FriendlyNames) > 0 && cfg.FriendlyNames[0] != nil && *cfg.FriendlyNames[0] != "" {
+		name = *cfg.FriendlyNames[0]
+	}
+	if name == "" {
+		name = cfg.Topic
+	}
+
+	relays := 0
+	for _, r := range cfg.Relays {
+		if r != 0 {
+			relays++
+		}
+	}
+
+	vars := map[string]string{
+		"topic":  cfg.Topic,
+		"relays": strconv.Itoa(relays),
+	}
+	if cfg.FullTopic != "" && cfg.FullTopic != "%prefix%/%topic%/" {
+		vars["full_topic"] = cfg.FullTopic
+		if strings.Contains(cfg.FullTopic, "%hostname%") {
+			vars["hostname"] = cfg.Hostname
+		}
+		if strings.Contains(cfg.FullTopic, "%id%") {
+			vars["mac"] = cfg.MAC
+		}
+	}
+	if len(cfg.Prefixes) == 3 && strings.Join(cfg.Prefixes, ",") != "cmnd,stat,tele" {
+		vars["prefixes"] = strings.Join(cfg.Prefixes, ",")
+	}
+	if light := devices.TasmotaLightType(cfg.LightSubtype); light != "" {
+		vars["light"] = light
+	}
+	if s := devices.TasmotaSensorVars(sensors); s != "" {
+		vars["sensors"] = s
+	}
+
+	dev := &types.Device{
+		ID:       "tasmota/" + SanitizeID(cfg.Topic),
+		Name:     name,
+		Model:    cfg.Model,
+		Vendor:   "Tasmota",
+		Template: devices.TasmotaTemplate,
+		Vars:     vars,
+	}
+	// Resolve now so devices.yaml lists the type and attributes; the topics
+	// are generated again from the vars at startup
+	if err := devices.ApplyTemplates([]*types.Device{dev}, nil); err != nil {
+		log.Debug("Tasmota device %s: %v", dev.ID, err)
+		dev.Type = "sensor"
+	}
+	return dev
+}
@@ -0,0 +1,239 @@
+// Package effects runs light effects such as flashing from Go, on the
+// scheduler's timers, so alert patterns don't hold a Lua worker in sleeps or
+// chains of timers. The state to restore is kept in storage while an effect
+// runs and put back at startup if the server stopped in the middle of one.
+package effects
+
+import (
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/scenes"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logger.Module("effects")
+
+// MinInterval is the shortest flash interval: the scheduler checks timers
+// once a second, and Zigbee meshes don't keep up with faster toggling anyway
+const MinInterval = time.Second
+
+// MaxTimes bounds the number of flashes of one effect
+const MaxTimes = 100
+
+// keyPrefix holds the state to restore of every running effect, by device
+const keyPrefix = "_effects/"
+
+// Store persists the state to restore (a *storage.Storage)
+type Store interface {
+	Get(key string) (interface{}, error)
+	Set(key string, value interface{}) error
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+}
+
+// Scheduler runs the effect steps (a *scheduler.Scheduler)
+type Scheduler interface {
+	Now() time.Time
+	AddFuncTimer(id string, triggerTime time.Time, fn func())
+	RemoveTimer(id string) bool
+}
+
+// flash is a running flash effect on one device
+type flash struct {
+	device   string
+	on       bool // whether the device was on before the effect
+	steps    int  // state changes: two per flash
+	step     int
+	start    time.Time
+	interval time.Duration
+	// restore is sent when the effect ends (nil: the last step already put
+	// the on/off state back)
+	restore map[string]interface{}
+}
+
+// Engine runs effects on devices
+type Engine struct {
+	devices scenes.DeviceController
+	store   Store
+	sched   Scheduler
+	mu      sync.Mutex
+	running map[string]*flash
+}
+
+// New creates an effect engine
+func New(devices scenes.DeviceController, store Store, sched Scheduler) *Engine {
+	return &Engine{
+		devices: devices,
+		store:   store,
+		sched:   sched,
+		running: make(map[string]*flash),
+	}
+}
+
+// Flash toggles a device times times, changing state every interval, and
+// with restore puts back everything captured before (brightness, color, ...)
+// when done. Flashing a device that is already flashing restarts the effect
+// but keeps the state from before the first one.
+func (e *Engine) Flash(device string, times int, interval time.Duration, restore bool) error {
+	if times < 1 || times > MaxTimes {
+		return fmt.Errorf("times must be between 1 and %d", MaxTimes)
+	}
+	if interval < MinInterval {
+		return fmt.Errorf("interval must be at least %s", MinInterval)
+	}
+
+	e.mu.Lock()
+	f, err := e.startLocked(device, times, interval, restore)
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Debug("Flashing %s %d time(s) every %s", device, times, interval)
+	e.advance(f)
+	return nil
+}
+
+// startLocked saves the state to restore and registers a new flash
+func (e *Engine) startLocked(device string, times int, interval time.Duration, restore bool) (*flash, error) {
+	var snapshot map[string]interface{}
+	if previous := e.running[device]; previous != nil {
+		e.sched.RemoveTimer(timerID(device))
+		snapshot = previous.restore
+		if snapshot == nil {
+			snapshot = map[string]interface{}{"state": onOff(previous.on)}
+		}
+	} else {
+		var err error
+		if snapshot, err = scenes.Snapshot(e.devices, device); err != nil {
+			return nil, err
+		}
+	}
+	state, ok := snapshot["state"].(string)
+	if !ok {
+		return nil, fmt.Errorf("device %s has not reported an on/off state yet", device)
+	}
+
+	f := &flash{
+		device:   device,
+		on:       strings.EqualFold(state, "ON"),
+		steps:    2 * times,
+		start:    e.sched.Now(),
+		interval: interval,
+	}
+	// Kept until the effect ends, so a restart still finds it
+	saved := map[string]interface{}{"state": state}
+	if restore {
+		f.restore = scenes.RestoreAttributes(snapshot)
+		saved = f.restore
+	}
+	if err := e.store.Set(keyPrefix+device, saved); err != nil {
+		return nil, fmt.Errorf("failed to save state of %s: %w", device, err)
+	}
+	e.running[device] = f
+	return f, nil
+}
+
+// advance applies the next state change of f and schedules the one after, or
+// ends the effect one interval after the last change. Devices are set outside
+// the lock: an optimistic update may run scripts that start effects.
+func (e *Engine) advance(f *flash) {
+	e.mu.Lock()
+	if e.running[f.device] != f {
+		e.mu.Unlock()
+		return
+	}
+	if f.step >= f.steps {
+		delete(e.running, f.device)
+		e.mu.Unlock()
+		e.end(f)
+		return
+	}
+
+	// Even steps invert the original state, odd steps bring it back
+	on := f.on == (f.step%2 == 1)
+	f.step++
+	// Steps are planned from the start, so timer jitter doesn't add up
+	e.sched.AddFuncTimer(timerID(f.device), f.start.Add(time.Duration(f.step)*f.interval), func() {
+		e.advance(f)
+	})
+	e.mu.Unlock()
+
+	if err := e.devices.Set(f.device, map[string]interface{}{"state": onOff(on)}); err != nil {
+		log.Warn("Flash %s: %v", f.device, err)
+	}
+}
+
+// end restores the device of a finished or stopped effect and forgets its
+// saved state, unless a new effect on the device saved its own meanwhile
+func (e *Engine) end(f *flash) {
+	if f.restore != nil {
+		if err := e.devices.Set(f.device, f.restore); err != nil {
+			log.Warn("Failed to restore %s after flashing: %v", f.device, err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running[f.device] != nil {
+		return
+	}
+	if err := e.store.Delete(keyPrefix + f.device); err != nil {
+		log.Warn("Failed to forget saved state of %s: %v", f.device, err)
+	}
+	log.Debug("Flash of %s done", f.device)
+}
+
+// Recover puts back devices whose effect was interrupted by a restart
+func (e *Engine) Recover() {
+	keys, err := e.store.List(keyPrefix)
+	if err != nil {
+		log.Warn("Failed to list interrupted effects: %v", err)
+		return
+	}
+	for _, key := range keys {
+		device := strings.TrimPrefix(key, keyPrefix)
+		value, err := e.store.Get(key)
+		attrs, ok := value.(map[string]interface{})
+		if err != nil || !ok || len(attrs) == 0 {
+			log.Warn("Dropping unreadable saved state of %s", device)
+		} else if err := e.devices.Set(device, attrs); err != nil {
+			log.Warn("Failed to restore %s after an interrupted effect: %v", device, err)
+		} else {
+			log.Info("Restored %s after an interrupted effect", device)
+		}
+		e.store.Delete(key)
+	}
+}
+
+// Stop ends running effects right away, restoring their devices
+func (e *Engine) Stop() {
+	e.mu.Lock()
+	stopped := make([]*flash, 0, len(e.running))
+	for device, f := range e.running {
+		e.sched.RemoveTimer(timerID(device))
+		if f.restore == nil {
+			f.restore = map[string]interface{}{"state": onOff(f.on)}
+		}
+		stopped = append(stopped, f)
+		delete(e.running, device)
+	}
+	e.mu.Unlock()
+
+	for _, f := range stopped {
+		e.end(f)
+	}
+}
+
+func timerID(device string) string {
+	return "effect/" + device
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
@@ -0,0 +1,250 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// conditionsFile is the optional file next to handlers that guards their execution
+const conditionsFile = "conditions.yaml"
+
+// DeviceStateReader provides current device state for condition checks
+type DeviceStateReader interface {
+	Get(id string) (map[string]interface{}, error)
+}
+
+// Conditions are declarative guards evaluated before handlers in a directory run
+//
+//	time_window: {after: "18:00", before: "06:00"}
+//	weekdays: [1, 2, 3, 4, 5]     # 0 = Sunday
+//	devices:
+//	  - {device: porch, attribute: state, equals: "OFF"}
+//	  - {device: kitchen_sensor, attribute: temperature, above: 25}
+//	cooldown: 5m
+type Conditions struct {
+	TimeWindow *TimeWindow       `yaml:"time_window,omitempty"`
+	Weekdays   []int             `yaml:"weekdays,omitempty"`
+	Devices    []DeviceCondition `yaml:"devices,omitempty"`
+	Cooldown   time.Duration     `yaml:"cooldown,omitempty"`
+}
+
+// TimeWindow limits execution to a daily HH:MM range (may cross midnight)
+type TimeWindow struct {
+	After  string `yaml:"after,omitempty"`
+	Before string `yaml:"before,omitempty"`
+}
+
+// DeviceCondition requires a device attribute to have a given value or range
+type DeviceCondition struct {
+	Device    string      `yaml:"device"`
+	Attribute string      `yaml:"attribute"`
+	Equals    interface{} `yaml:"equals,omitempty"`
+	NotEquals interface{} `yaml:"not_equals,omitempty"`
+	Above     *float64    `yaml:"above,omitempty"`
+	Below     *float64    `yaml:"below,omitempty"`
+}
+
+type cachedConditions struct {
+	modTime    time.Time
+	conditions *Conditions // nil when the file doesn't exist or is invalid
+	err        error       // why the file is invalid
+}
+
+// conditionEvaluator loads conditions.yaml files and tracks cooldowns per directory
+type conditionEvaluator struct {
+	devices   DeviceStateReader
+	cache     map[string]cachedConditions
+	lastFired map[string]time.Time
+	mu        sync.Mutex
+}
+
+func newConditionEvaluator() *conditionEvaluator {
+	return &conditionEvaluator{
+		cache:     make(map[string]cachedConditions),
+		lastFired: make(map[string]time.Time),
+	}
+}
+
+// ParseConditions parses the contents of a conditions.yaml file
+func ParseConditions(data []byte) (*Conditions, error) {
+	var conditions Conditions
+	if err := yaml.Unmarshal(data, &conditions); err != nil {
+		return nil, err
+	}
+	return &conditions, nil
+}
+
+// load returns the conditions for a handler directory, or nil if none are
+// declared. An invalid file is logged once per change and returned as an error.
+func (c *conditionEvaluator) load(dir string) (*Conditions, error) {
+	path := filepath.Join(dir, conditionsFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	cached, ok := c.cache[dir]
+	c.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.conditions, cached.err
+	}
+
+	var conditions *Conditions
+	var invalid error
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if conditions, invalid = ParseConditions(data); invalid != nil {
+			log.Error("Invalid %s: %v (handlers in %s will not run)", path, invalid, dir)
+		}
+	}
+
+	c.mu.Lock()
+	c.cache[dir] = cachedConditions{modTime: info.ModTime(), conditions: conditions, err: invalid}
+	c.mu.Unlock()
+
+	return conditions, invalid
+}
+
+// allow evaluates the conditions of a handler directory and records the firing
+// time for cooldowns when they pass
+func (c *conditionEvaluator) allow(dir string, now time.Time) (bool, string) {
+	conditions, err := c.load(dir)
+	if err != nil {
+		// Fail closed: an invalid guard must not let handlers run unguarded
+		return false, fmt.Sprintf("invalid %s: %v", conditionsFile, err)
+	}
+	if conditions == nil {
+		return true, ""
+	}
+
+	if ok, reason := c.check(conditions, now); !ok {
+		return false, reason
+	}
+
+	if conditions.Cooldown > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if last, ok := c.lastFired[dir]; ok && now.Sub(last) < conditions.Cooldown {
+			return false, fmt.Sprintf("cooldown %v active (fired %v ago)", conditions.Cooldown, now.Sub(last).Round(time.Second))
+		}
+		c.lastFired[dir] = now
+	}
+
+	return true, ""
+}
+
+func (c *conditionEvaluator) check(conditions *Conditions, now time.Time) (bool, string) {
+	if tw := conditions.TimeWindow; tw != nil {
+		if !inTimeWindow(tw, now) {
+			return false, fmt.Sprintf("outside time window %s-%s", tw.After, tw.Before)
+		}
+	}
+
+	if len(conditions.Weekdays) > 0 {
+		weekday := int(now.Weekday())
+		matched := false
+		for _, d := range conditions.Weekdays {
+			if d == weekday {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, fmt.Sprintf("weekday %d not allowed", weekday)
+		}
+	}
+
+	for _, dc := range conditions.Devices {
+		if c.devices == nil {
+			return false, "device states unavailable"
+		}
+		state, err := c.devices.Get(dc.Device)
+		if err != nil {
+			return false, fmt.Sprintf("device %s unknown", dc.Device)
+		}
+		if ok, reason := dc.matches(state[dc.Attribute]); !ok {
+			return false, fmt.Sprintf("%s.%s %s", dc.Device, dc.Attribute, reason)
+		}
+	}
+
+	return true, ""
+}
+
+func (dc DeviceCondition) matches(value interface{}) (bool, string) {
+	if dc.Equals != nil && fmt.Sprint(value) != fmt.Sprint(dc.Equals) {
+		return false, fmt.Sprintf("is %v, want %v", value, dc.Equals)
+	}
+	if dc.NotEquals != nil && fmt.Sprint(value) == fmt.Sprint(dc.NotEquals) {
+		return false, fmt.Sprintf("is %v", value)
+	}
+	if dc.Above != nil || dc.Below != nil {
+		num, ok := toFloat(value)
+		if !ok {
+			return false, fmt.Sprintf("is %v, not a number", value)
+		}
+		if dc.Above != nil && num <= *dc.Above {
+			return false, fmt.Sprintf("is %v, want above %v", num, *dc.Above)
+		}
+		if dc.Below != nil && num >= *dc.Below {
+			return false, fmt.Sprintf("is %v, want below %v", num, *dc.Below)
+		}
+	}
+	return true, ""
+}
+
+// inTimeWindow checks now against an HH:MM window, supporting windows that cross midnight
+func inTimeWindow(tw *TimeWindow, now time.Time) bool {
+	current := now.Hour()*60 + now.Minute()
+
+	after, hasAfter := parseClock(tw.After)
+	before, hasBefore := parseClock(tw.Before)
+
+	switch {
+	case hasAfter && hasBefore && after <= before:
+		return current >= after && current < before
+	case hasAfter && hasBefore:
+		return current >= after || current < before
+	case hasAfter:
+		return current >= after
+	case hasBefore:
+		return current < before
+	default:
+		return true
+	}
+}
+
+func parseClock(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	var hour, minute int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		var f float64
+		if _, err := fmt.Sscanf(v, "%g", &f); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,114 @@
+package events
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"sync"
+)
+
+// MetaKey is the event data key enriched device events carry their metadata in
+const MetaKey = "meta"
+
+// EnrichFields are the metadata fields an Enricher can attach
+var EnrichFields = []string{"name", "area", "type", "vendor", "model", "previous"}
+
+// DeviceLookup provides device definitions (implemented by devices.Manager)
+type DeviceLookup interface {
+	GetDevice(id string) (*types.Device, bool)
+}
+
+// Enricher is a middleware stage attaching device metadata to device events
+// as event.data.meta ({name, area, type, vendor, model, previous}), so scripts
+// and sinks don't each look it up. previous is the attribute's value in the
+// previous event for the device, absent for the first one unless the event
+// is the device's first report and a default is set for the attribute.
+type Enricher struct {
+	devices  DeviceLookup
+	fields   map[string]bool
+	defaults map[string]interface{} // attribute -> previous for first reports
+	mu       sync.Mutex
+	last     map[string]interface{} // "device\x00attribute" -> value
+}
+
+// NewEnricher creates an enricher attaching fields (all of EnrichFields if empty)
+func NewEnricher(devices DeviceLookup, fields []string) (*Enricher, error) {
+	if len(fields) == 0 {
+		fields = EnrichFields
+	}
+	known := make(map[string]bool, len(EnrichFields))
+	for _, field := range EnrichFields {
+		known[field] = true
+	}
+
+	e := &Enricher{
+		devices: devices,
+		fields:  make(map[string]bool, len(fields)),
+		last:    make(map[string]interface{}),
+	}
+	for _, field := range fields {
+		if !known[field] {
+			return nil, fmt.Errorf("unknown enrich field %q (expected one of %v)", field, EnrichFields)
+		}
+		e.fields[field] = true
+	}
+	return e, nil
+}
+
+// SetPreviousDefaults sets the previous value per attribute attached to
+// first reports (see types.Event.FirstReport), so edge-triggered handlers
+// comparing against it see e.g. occupancy going from false to true
+func (e *Enricher) SetPreviousDefaults(defaults map[string]interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaults = defaults
+}
+
+// Handle attaches the metadata and continues
+func (e *Enricher) Handle(event *types.Event, next Handler) {
+	if event.Source == "device" && event.Device != "" {
+		e.enrich(event)
+	}
+	next(event)
+}
+
+func (e *Enricher) enrich(event *types.Event) {
+	meta := make(map[string]interface{})
+
+	if dev, ok := e.devices.GetDevice(event.Device); ok {
+		e.add(meta, "name", dev.Name)
+		e.add(meta, "area", dev.Area)
+		e.add(meta, "type", dev.Type)
+		e.add(meta, "vendor", dev.Vendor)
+		e.add(meta, "model", dev.Model)
+	}
+
+	if e.fields["previous"] && event.Attribute != "" {
+		if value, ok := event.Data[event.Attribute]; ok {
+			// Binary payloads (snapshots) are not worth keeping
+			if _, isBinary := value.([]byte); !isBinary {
+				key := event.Device + "\x00" + event.Attribute
+				e.mu.Lock()
+				previous, seen := e.last[key]
+				if !seen && event.FirstReport {
+					previous, seen = e.defaults[event.Attribute]
+				}
+				e.last[key] = value
+				e.mu.Unlock()
+				if seen {
+					meta["previous"] = previous
+				}
+			}
+		}
+	}
+
+	if event.Data == nil {
+		event.Data = make(map[string]interface{})
+	}
+	event.Data[MetaKey] = meta
+}
+
+func (e *Enricher) add(meta map[string]interface{}, field, value string) {
+	if e.fields[field] && value != "" {
+		meta[field] = value
+	}
+}
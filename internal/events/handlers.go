@@ -0,0 +1,90 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// handlerSources are the directories directly below events/ that events are
+// routed to
+var handlerSources = []string{"area", "custom", "device", "mqtt", "state", "system", "time", "webhook"}
+
+// CheckHandlerDirs reports handler directories no event will ever reach:
+// unknown sources below events/, events/device/<id> for unknown devices,
+// attribute directories a device doesn't have and events/area/<area> for
+// areas without devices. devices maps device IDs and aliases to their
+// attributes (nil if any attribute may be reported).
+func CheckHandlerDirs(basePath string, devices map[string][]string, areas []string) []string {
+	root := filepath.Join(basePath, "events")
+	var problems []string
+
+	for _, source := range subdirs(root) {
+		if !containsString(handlerSources, source) {
+			problems = append(problems, unknownDir("events/"+source, "unknown event source "+source, source, handlerSources))
+		}
+	}
+
+	deviceIDs := make([]string, 0, len(devices))
+	for id := range devices {
+		deviceIDs = append(deviceIDs, id)
+	}
+	sort.Strings(deviceIDs)
+	for _, id := range subdirs(filepath.Join(root, "device")) {
+		dir := "events/device/" + id
+		attributes, ok := devices[id]
+		if !ok {
+			problems = append(problems, unknownDir(dir, "unknown device "+id, id, deviceIDs))
+			continue
+		}
+		if attributes == nil {
+			continue
+		}
+		for _, attr := range subdirs(filepath.Join(root, "device", id)) {
+			if attr != "actions" && !containsString(attributes, attr) {
+				problems = append(problems, unknownDir(dir+"/"+attr, id+" has no attribute "+attr, attr, attributes))
+			}
+		}
+	}
+
+	for _, area := range subdirs(filepath.Join(root, "area")) {
+		if !containsString(areas, area) {
+			problems = append(problems, unknownDir("events/area/"+area, "no device in area "+area, area, areas))
+		}
+	}
+	return problems
+}
+
+// unknownDir describes a handler directory for something that doesn't
+// exist, suggesting the known name it is probably a typo of
+func unknownDir(dir, problem, name string, known []string) string {
+	if match := similarName(name, known); match != "" {
+		return fmt.Sprintf("%s: %s, did you mean %s?", dir, problem, match)
+	}
+	return fmt.Sprintf("%s: %s", dir, problem)
+}
+
+// subdirs lists the directories in dir, sorted (none if it doesn't exist)
+func subdirs(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
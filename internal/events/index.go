@@ -0,0 +1,97 @@
+package events
+
+import (
+	"homescript-server/internal/fswatch"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// scriptIndex caches the handler scripts of each event directory, so devices
+// reporting every few seconds don't cost a directory read per event. The
+// whole cache is dropped whenever a watched directory gains, loses or renames
+// an entry. Without a watcher (not Linux) every lookup reads the directory.
+type scriptIndex struct {
+	watcher *fswatch.Watcher
+	mu      sync.Mutex
+	dirs    map[string][]string // directory -> scripts (nil if none or missing)
+}
+
+func newScriptIndex() *scriptIndex {
+	watcher, err := fswatch.New()
+	if err != nil {
+		log.Debug("Not watching event directories, reading them for every event: %v", err)
+	}
+	return &scriptIndex{watcher: watcher, dirs: make(map[string][]string)}
+}
+
+// lookup returns the .lua files in dir
+func (x *scriptIndex) lookup(dir string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.watcher == nil {
+		return readLuaFiles(dir)
+	}
+	if x.watcher.Changed() {
+		x.dirs = make(map[string][]string)
+	}
+	if scripts, ok := x.dirs[dir]; ok {
+		return append([]string(nil), scripts...)
+	}
+
+	// Watch before reading so a script added in between is not missed
+	watched, err := x.watcher.AddNearest(dir)
+	if err != nil {
+		log.Debug("Cannot watch %s: %v", dir, err)
+		return readLuaFiles(dir)
+	}
+	scripts := readLuaFiles(dir)
+	if !watched && scripts != nil {
+		// Created after the watch failed and unwatched; cache it next time
+		return scripts
+	}
+	x.dirs[dir] = append([]string(nil), scripts...)
+	return scripts
+}
+
+// reset forgets the cached directories, as if every watched one changed
+func (x *scriptIndex) reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.dirs = make(map[string][]string)
+}
+
+// close stops watching; later lookups read the directories
+func (x *scriptIndex) close() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.watcher != nil {
+		x.watcher.Close()
+		x.watcher = nil
+	}
+}
+
+// readLuaFiles lists the .lua files in dir
+func readLuaFiles(dir string) []string {
+	var scripts []string
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return scripts
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if strings.HasSuffix(entry.Name(), ".lua") {
+			fullPath := filepath.Join(dir, entry.Name())
+			scripts = append(scripts, fullPath)
+		}
+	}
+
+	return scripts
+}
@@ -0,0 +1,47 @@
+package events
+
+import "homescript-server/internal/types"
+
+// Handler continues routing an event
+type Handler func(event *types.Event)
+
+// Middleware is an ordered stage of the event pipeline, run for every event
+// before it is recorded and dispatched to scripts. A stage may inspect or
+// modify the event and call next to continue, or return without calling next
+// to drop it (dedupe, throttling, ACLs). It may also call next with another
+// event, or several times.
+type Middleware interface {
+	Handle(event *types.Event, next Handler)
+}
+
+// MiddlewareFunc adapts a function to Middleware
+type MiddlewareFunc func(event *types.Event, next Handler)
+
+// Handle calls f
+func (f MiddlewareFunc) Handle(event *types.Event, next Handler) {
+	f(event, next)
+}
+
+// Use appends stages to the pipeline; they run in the order added
+func (r *Router) Use(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Copy so events already in the pipeline keep the chain they started with
+	chain := make([]Middleware, 0, len(r.middleware)+len(middleware))
+	chain = append(chain, r.middleware...)
+	r.middleware = append(chain, middleware...)
+}
+
+// runMiddleware passes event through chain, then dispatches it
+func (r *Router) runMiddleware(chain []Middleware, event *types.Event) {
+	if len(chain) == 0 {
+		r.dispatch(event)
+		return
+	}
+	chain[0].Handle(event, func(next *types.Event) {
+		if next == nil {
+			return
+		}
+		r.runMiddleware(chain[1:], next)
+	})
+}
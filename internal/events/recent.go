@@ -0,0 +1,71 @@
+package events
+
+import (
+	"homescript-server/internal/types"
+	"sync"
+)
+
+// recentSize is how many dispatched events the router keeps for the API
+const recentSize = 200
+
+// RecentEvent is a dispatched event with the handler scripts it ran
+type RecentEvent struct {
+	Seq uint64 `json:"seq"`
+	types.Event
+	// Scripts are relative to the events directory, after conditions.yaml
+	// and disabled scripts were applied
+	Scripts []string `json:"scripts"`
+}
+
+// recentEvents keeps the last dispatched events in a ring buffer
+type recentEvents struct {
+	mu     sync.Mutex
+	events []RecentEvent
+	next   int
+	full   bool
+	seq    uint64
+}
+
+func newRecentEvents(size int) *recentEvents {
+	return &recentEvents{events: make([]RecentEvent, size)}
+}
+
+func (r *recentEvents) add(event *types.Event, scripts []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	r.events[r.next] = RecentEvent{Seq: r.seq, Event: *event, Scripts: scripts}
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// RecentEvents returns the kept events with a sequence number above after,
+// oldest first, and the sequence number of the last event dispatched.
+// Clients pass that back as after to follow the events.
+func (r *Router) RecentEvents(after uint64) ([]RecentEvent, uint64) {
+	c := r.recent
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ordered := c.events[:c.next]
+	if c.full {
+		ordered = append(append([]RecentEvent(nil), c.events[c.next:]...), c.events[:c.next]...)
+	}
+	result := []RecentEvent{}
+	for _, event := range ordered {
+		if event.Seq > after {
+			result = append(result, event)
+		}
+	}
+	return result, c.seq
+}
+
+// recordRecent keeps a dispatched event with the scripts it runs
+func (r *Router) recordRecent(event *types.Event, scripts []string) {
+	relative := make([]string, len(scripts))
+	for i, script := range scripts {
+		relative[i] = r.relativeScript(script)
+	}
+	r.recent.add(event, relative)
+}
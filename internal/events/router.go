@@ -2,57 +2,267 @@ package events
 
 import (
 	"homescript-server/internal/executor"
+	"homescript-server/internal/history"
 	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
+var log = logger.Module("router")
+
+// EventRecorder persists routed events (implemented by storage.Storage)
+type EventRecorder interface {
+	RecordEvent(event *types.Event) error
+}
+
+// SceneActivator applies named scenes (implemented by scenes.Engine)
+type SceneActivator interface {
+	Activate(name string) error
+}
+
+// AliasResolver lists former or alternative IDs of a device (implemented by devices.Manager)
+type AliasResolver interface {
+	Aliases(id string) []string
+}
+
+// sceneEventType is the custom event that activates the scene named in data.scene
+const sceneEventType = "scene"
+
+// TaskSubmitter runs the scripts of routed events (implemented by
+// executor.Pool; the test harness runs them inline)
+type TaskSubmitter interface {
+	Submit(task executor.Task)
+}
+
+// EventSubscriber receives every event the router dispatches (implemented
+// by executor.Executor for service scripts)
+type EventSubscriber interface {
+	Deliver(event *types.Event)
+}
+
 // Router routes events to appropriate Lua scripts
 type Router struct {
-	basePath string
-	pool     *executor.Pool
+	basePath   string
+	pool       TaskSubmitter
+	history    *history.History
+	recorder   EventRecorder
+	scenes     SceneActivator
+	aliases    AliasResolver
+	conditions *conditionEvaluator
+	priority   map[string]bool // device attributes routed to the fast lane
+	middleware []Middleware
+	scripts    *scriptIndex
+	subscriber EventSubscriber
+	unrouted   *unroutedCounter // events no script matched
+	recent     *recentEvents    // last dispatched events for the API
+	flags      ScriptFlags
+	disabled   map[string]bool // handler scripts relative to events/
+	mu         sync.RWMutex
+}
+
+// DefaultPriorityAttributes are device attributes treated as high priority
+var DefaultPriorityAttributes = []string{
+	"occupancy", "motion", "presence", "contact", "smoke", "water_leak",
+	"gas", "tamper", "alarm", "vibration", "person",
 }
 
 // New creates a new event router
-func New(basePath string, pool *executor.Pool) *Router {
+func New(basePath string, pool TaskSubmitter) *Router {
 	return &Router{
-		basePath: basePath,
-		pool:     pool,
+		basePath:   basePath,
+		pool:       pool,
+		conditions: newConditionEvaluator(),
+		priority:   make(map[string]bool),
+		scripts:    newScriptIndex(),
+		unrouted:   newUnroutedCounter(),
+		recent:     newRecentEvents(recentSize),
+	}
+}
+
+// RefreshScripts drops the cached handler lists, so the next events read
+// their directories again (a reload; changes are normally seen right away)
+func (r *Router) RefreshScripts() {
+	r.scripts.reset()
+}
+
+// Close stops watching the event directories for new scripts
+func (r *Router) Close() {
+	r.scripts.close()
+}
+
+// SetHistory sets the in-memory history that records device events
+func (r *Router) SetHistory(h *history.History) {
+	r.history = h
+}
+
+// SetPriorityAttributes sets the device attributes whose events use the fast lane
+func (r *Router) SetPriorityAttributes(attrs []string) {
+	r.priority = make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		r.priority[attr] = true
 	}
 }
 
+// priorityFor classifies an event for the worker pool
+func (r *Router) priorityFor(event *types.Event) executor.Priority {
+	if event.Source == "device" && (event.Type == "snapshot" || r.priority[event.Attribute]) {
+		return executor.PriorityHigh
+	}
+	return executor.PriorityNormal
+}
+
+// SetDeviceStates sets the device state source used by conditions.yaml device checks
+func (r *Router) SetDeviceStates(devices DeviceStateReader) {
+	r.conditions.devices = devices
+}
+
+// SetRecorder enables recording of every routed event (used by the replay tool)
+func (r *Router) SetRecorder(recorder EventRecorder) {
+	r.recorder = recorder
+}
+
+// SetScenes enables activating scenes with a custom "scene" event ({scene = "<name>"})
+func (r *Router) SetScenes(scenes SceneActivator) {
+	r.scenes = scenes
+}
+
+// SetAliases makes device handlers in events/device/<alias>/ run too, so
+// scripts written before a device was renamed keep working
+func (r *Router) SetAliases(aliases AliasResolver) {
+	r.aliases = aliases
+}
+
+// SetSubscriber passes every dispatched event to s as well, whether or not
+// scripts handle it
+func (r *Router) SetSubscriber(s EventSubscriber) {
+	r.subscriber = s
+}
+
 // GetBasePath returns the base path for event scripts
 func (r *Router) GetBasePath() string {
 	return r.basePath
 }
 
-// RouteEvent finds and executes scripts for the given event
+// RouteEvent passes the event through the middleware pipeline, then finds
+// and executes its scripts
 func (r *Router) RouteEvent(event *types.Event) {
-	scripts := r.findScripts(event)
+	// Events not originating from MQTT or the scheduler start a new chain
+	if event.CorrelationID == "" {
+		event.CorrelationID = types.NewCorrelationID()
+	}
+
+	r.mu.RLock()
+	chain := r.middleware
+	r.mu.RUnlock()
+	r.runMiddleware(chain, event)
+}
+
+// dispatch records an event that made it through the pipeline and submits its scripts
+func (r *Router) dispatch(event *types.Event) {
+	if r.history != nil {
+		r.history.Record(event)
+	}
+
+	if r.recorder != nil {
+		if err := r.recorder.RecordEvent(event); err != nil {
+			log.Warn("Failed to record event %s/%s: %v", event.Source, event.Type, err)
+		}
+	}
+
+	sceneEvent := r.scenes != nil && event.Source == "custom" && event.Type == sceneEventType
+	if sceneEvent {
+		r.activateScene(event)
+	}
+
+	if r.subscriber != nil {
+		r.subscriber.Deliver(event)
+	}
+
+	found := r.findScripts(event)
+	if len(found) == 0 && !sceneEvent {
+		if dir, count := r.unrouted.record(event); count == scaffoldHintAfter {
+			log.Info("No handler for events/%s after %d events, create one with: homescript-server events scaffold %s",
+				dir, count, dir)
+		}
+	}
+	scripts := r.filterByConditions(r.filterDisabled(found), event)
+	r.recordRecent(event, scripts)
 
 	if len(scripts) == 0 {
 		// More detailed debug info for device events
 		if event.Source == "device" && event.Device != "" && event.Attribute != "" {
-			logger.Debug("No scripts found for event: %s/%s (device: %s, attribute: %s)",
+			log.Debug("No scripts found for event: %s/%s (device: %s, attribute: %s)",
 				event.Source, event.Type, event.Device, event.Attribute)
 		} else {
-			logger.Debug("No scripts found for event: %s/%s", event.Source, event.Type)
+			log.Debug("No scripts found for event: %s/%s", event.Source, event.Type)
 		}
 		return
 	}
 
-	logger.Debug("Found %d script(s) for event: %s/%s", len(scripts), event.Source, event.Type)
+	log.Debug("[%s] Found %d script(s) for event: %s/%s", event.CorrelationID, len(scripts), event.Source, event.Type)
 
+	priority := r.priorityFor(event)
 	for _, scriptPath := range scripts {
 		r.pool.Submit(executor.Task{
 			ScriptPath: scriptPath,
 			Event:      event,
+			Priority:   priority,
 		})
 	}
 }
 
+// activateScene applies the scene named by a custom "scene" event
+func (r *Router) activateScene(event *types.Event) {
+	name, _ := event.Data["scene"].(string)
+	if name == "" {
+		log.Warn("[%s] Scene event without a scene name", event.CorrelationID)
+		return
+	}
+	if err := r.scenes.Activate(name); err != nil {
+		log.Error("[%s] Failed to activate scene %s: %v", event.CorrelationID, name, err)
+	}
+}
+
+// filterByConditions drops scripts whose directory conditions.yaml is not satisfied
+func (r *Router) filterByConditions(scripts []string, event *types.Event) []string {
+	if len(scripts) == 0 {
+		return scripts
+	}
+
+	now := event.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	allowed := make(map[string]bool)
+	result := scripts[:0:0]
+	for _, script := range scripts {
+		dir := filepath.Dir(script)
+		ok, seen := allowed[dir]
+		if !seen {
+			var reason string
+			ok, reason = r.conditions.allow(dir, now)
+			allowed[dir] = ok
+			if !ok {
+				log.Debug("Conditions not met for %s: %s", dir, reason)
+			}
+		}
+		if ok {
+			result = append(result, script)
+		}
+	}
+	return result
+}
+
+// FindScripts returns the scripts that would handle the event, without running them
+func (r *Router) FindScripts(event *types.Event) []string {
+	return r.findScripts(event)
+}
+
 func (r *Router) findScripts(event *types.Event) []string {
 	var scripts []string
 
@@ -61,10 +271,17 @@ func (r *Router) findScripts(event *types.Event) []string {
 		scripts = append(scripts, r.findMQTTScripts(event)...)
 	case "device":
 		scripts = append(scripts, r.findDeviceScripts(event)...)
+		scripts = append(scripts, r.findAreaScripts(event)...)
 	case "time":
 		scripts = append(scripts, r.findTimeScripts(event)...)
 	case "state":
 		scripts = append(scripts, r.findStateScripts(event)...)
+	case "custom":
+		scripts = append(scripts, r.findCustomScripts(event)...)
+	case "webhook":
+		scripts = append(scripts, r.findWebhookScripts(event)...)
+	case "system":
+		scripts = append(scripts, r.findSystemScripts(event)...)
 	}
 
 	return scripts
@@ -75,7 +292,12 @@ func (r *Router) findMQTTScripts(event *types.Event) []string {
 
 	// Convert MQTT topic to file path
 	// e.g., zigbee2mqtt/bedroom_light -> events/mqtt/zigbee2mqtt/bedroom_light
-	topicPath := strings.ReplaceAll(event.Topic, "/", string(os.PathSeparator))
+	// Configured subscriptions may name their directory instead
+	route := event.Topic
+	if event.Handler != "" {
+		route = event.Handler
+	}
+	topicPath := strings.ReplaceAll(route, "/", string(os.PathSeparator))
 	basePath := filepath.Join(r.basePath, "events", "mqtt", topicPath)
 
 	// Look for scripts in this directory
@@ -91,24 +313,45 @@ func (r *Router) findDeviceScripts(event *types.Event) []string {
 		return scripts
 	}
 
-	devicePath := filepath.Join(r.basePath, "events", "device", event.Device)
-
-	// If there's a specific attribute, look in that directory
-	if event.Attribute != "" {
-		attrPath := filepath.Join(devicePath, event.Attribute)
-		logger.Debug("Looking for device scripts in: %s", attrPath)
-		scripts = append(scripts, r.findLuaFiles(attrPath)...)
-		// Don't look in generic device directory to avoid duplicates
-		return scripts
+	ids := []string{event.Device}
+	if r.aliases != nil {
+		ids = append(ids, r.aliases.Aliases(event.Device)...)
 	}
 
-	// Only look for generic device event handlers if no specific attribute
-	logger.Debug("Looking for generic device scripts in: %s", devicePath)
-	scripts = append(scripts, r.findLuaFiles(devicePath)...)
+	for _, id := range ids {
+		devicePath := filepath.Join(r.basePath, "events", "device", id)
+
+		// If there's a specific attribute, look in that directory
+		if event.Attribute != "" {
+			attrPath := filepath.Join(devicePath, event.Attribute)
+			log.Debug("Looking for device scripts in: %s", attrPath)
+			scripts = append(scripts, r.findLuaFiles(attrPath)...)
+			// Don't look in generic device directory to avoid duplicates
+			continue
+		}
+
+		// Only look for generic device event handlers if no specific attribute
+		log.Debug("Looking for generic device scripts in: %s", devicePath)
+		scripts = append(scripts, r.findLuaFiles(devicePath)...)
+	}
 
 	return scripts
 }
 
+// findAreaScripts finds handlers for device events by area:
+// events/area/<area>/<attribute>/*.lua (or events/area/<area>/*.lua without attribute)
+func (r *Router) findAreaScripts(event *types.Event) []string {
+	if event.Area == "" {
+		return nil
+	}
+
+	areaPath := filepath.Join(r.basePath, "events", "area", event.Area)
+	if event.Attribute != "" {
+		areaPath = filepath.Join(areaPath, event.Attribute)
+	}
+	return r.findLuaFiles(areaPath)
+}
+
 func (r *Router) findTimeScripts(event *types.Event) []string {
 	var scripts []string
 
@@ -131,24 +374,33 @@ func (r *Router) findStateScripts(event *types.Event) []string {
 	return scripts
 }
 
-func (r *Router) findLuaFiles(dir string) []string {
-	var scripts []string
-
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return scripts
+func (r *Router) findCustomScripts(event *types.Event) []string {
+	if event.Type == "" {
+		return nil
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
+	customPath := filepath.Join(r.basePath, "events", "custom", event.Type)
+	return r.findLuaFiles(customPath)
+}
 
-		if strings.HasSuffix(entry.Name(), ".lua") {
-			fullPath := filepath.Join(dir, entry.Name())
-			scripts = append(scripts, fullPath)
-		}
+// findWebhookScripts finds handlers for events posted to /api/events/<type>
+// in events/webhook/<type>/
+func (r *Router) findWebhookScripts(event *types.Event) []string {
+	if event.Type == "" {
+		return nil
 	}
+	return r.findLuaFiles(filepath.Join(r.basePath, "events", "webhook", event.Type))
+}
 
-	return scripts
+// findSystemScripts finds handlers for server events (e.g. mqtt_reconnected)
+// in events/system/<type>/
+func (r *Router) findSystemScripts(event *types.Event) []string {
+	if event.Type == "" {
+		return nil
+	}
+	return r.findLuaFiles(filepath.Join(r.basePath, "events", "system", event.Type))
+}
+
+func (r *Router) findLuaFiles(dir string) []string {
+	return r.scripts.lookup(dir)
 }
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SampleEvent builds an event that would be routed to script, for trying a
+// handler by hand. script is relative to the events directory, e.g.
+// "device/porch/state/on_change.lua"; data is merged into the event data.
+func SampleEvent(script string, data map[string]interface{}) (*types.Event, error) {
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(script)), "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("not a handler path: %s", script)
+	}
+
+	now := time.Now()
+	event := &types.Event{
+		Data:      make(map[string]interface{}),
+		Timestamp: now,
+	}
+	rest := strings.Join(parts[1:], "/")
+	last := parts[len(parts)-1]
+
+	switch parts[0] {
+	case "device":
+		event.Source = "device"
+		event.Type = "state_change"
+		switch {
+		case len(parts) >= 4 && parts[len(parts)-2] == "actions":
+			event.Source = "action"
+			event.Type = "call"
+			event.Device = strings.Join(parts[1:len(parts)-2], "/")
+			event.Attribute = last
+		case len(parts) >= 3:
+			event.Device = strings.Join(parts[1:len(parts)-1], "/")
+			event.Attribute = last
+		default:
+			event.Device = rest
+		}
+	case "area":
+		event.Source = "device"
+		event.Type = "state_change"
+		event.Area = parts[1]
+		if len(parts) >= 3 {
+			event.Attribute = last
+		}
+	case "mqtt":
+		event.Source = "mqtt"
+		event.Type = "message"
+		event.Topic = rest
+	case "custom":
+		event.Source = "custom"
+		event.Type = rest
+	case "system":
+		event.Source = "system"
+		event.Type = rest
+	case "webhook":
+		event.Source = "webhook"
+		event.Type = rest
+	case "state":
+		event.Source = "state"
+		event.Type = "change"
+		event.Attribute = rest
+	case "time":
+		event.Source = "time"
+		event.Type = rest
+		event.Data["time"] = now.Unix()
+		event.Data["hour"] = now.Hour()
+		event.Data["minute"] = now.Minute()
+		event.Data["second"] = now.Second()
+		event.Data["weekday"] = int(now.Weekday())
+	default:
+		return nil, fmt.Errorf("unknown event type directory: %s", parts[0])
+	}
+
+	for k, v := range data {
+		event.Data[k] = v
+	}
+	return event, nil
+}
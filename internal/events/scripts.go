@@ -0,0 +1,176 @@
+package events
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// disabledPrefix marks disabled handler scripts in storage, by path relative
+// to the events directory
+const disabledPrefix = "_disabled/"
+
+// ScriptFlags persists which handler scripts are disabled (a *storage.Storage)
+type ScriptFlags interface {
+	Set(key string, value interface{}) error
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+}
+
+// HandlerScript is a handler script below config/events
+type HandlerScript struct {
+	// Path is relative to the events directory, e.g. "device/porch/state/on_change.lua"
+	Path     string `json:"path"`
+	Disabled bool   `json:"disabled"`
+}
+
+// SetScriptFlags loads the disabled handler scripts from flags and keeps
+// them there when SetScriptDisabled changes them
+func (r *Router) SetScriptFlags(flags ScriptFlags) error {
+	keys, err := flags.List(disabledPrefix)
+	if err != nil {
+		return err
+	}
+	disabled := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		disabled[strings.TrimPrefix(key, disabledPrefix)] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flags = flags
+	r.disabled = disabled
+	if len(disabled) > 0 {
+		log.Info("%d handler script(s) disabled", len(disabled))
+	}
+	return nil
+}
+
+// Scripts lists the handler scripts below the events directory; action
+// scripts (events/device/<id>/actions/) are called by device.call, not routed,
+// and left out
+func (r *Router) Scripts() ([]HandlerScript, error) {
+	root := filepath.Join(r.basePath, "events")
+	var scripts []HandlerScript
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".lua") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if isActionScript(rel) {
+			return nil
+		}
+		scripts = append(scripts, HandlerScript{Path: rel})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	for i := range scripts {
+		scripts[i].Disabled = r.disabled[scripts[i].Path]
+	}
+	r.mu.RUnlock()
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].Path < scripts[j].Path })
+	return scripts, nil
+}
+
+// ScriptPath turns a handler path relative to the events directory into a
+// file path, refusing anything that isn't an existing handler script
+func (r *Router) ScriptPath(rel string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(rel))
+	if rel == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid script path: %s", rel)
+	}
+	if !strings.HasSuffix(clean, ".lua") || isActionScript(filepath.ToSlash(clean)) {
+		return "", fmt.Errorf("not a handler script: %s", rel)
+	}
+	path := filepath.Join(r.basePath, "events", clean)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", fmt.Errorf("no such handler script: %s", rel)
+	}
+	return path, nil
+}
+
+// SetScriptDisabled stops routing events to a handler script (path relative
+// to the events directory), or routes them again. Without script flags the
+// change is lost on restart.
+func (r *Router) SetScriptDisabled(rel string, disabled bool) error {
+	path, err := r.ScriptPath(rel)
+	if err != nil {
+		return err
+	}
+	rel = r.relativeScript(path)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.flags != nil {
+		if disabled {
+			err = r.flags.Set(disabledPrefix+rel, true)
+		} else {
+			err = r.flags.Delete(disabledPrefix + rel)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if r.disabled == nil {
+		r.disabled = make(map[string]bool)
+	}
+	if disabled {
+		r.disabled[rel] = true
+		log.Info("Handler script disabled: %s", rel)
+	} else {
+		delete(r.disabled, rel)
+		log.Info("Handler script enabled: %s", rel)
+	}
+	return nil
+}
+
+// filterDisabled drops disabled scripts
+func (r *Router) filterDisabled(scripts []string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.disabled) == 0 {
+		return scripts
+	}
+	result := scripts[:0:0]
+	for _, script := range scripts {
+		if r.disabled[r.relativeScript(script)] {
+			log.Debug("Skipping disabled script %s", script)
+			continue
+		}
+		result = append(result, script)
+	}
+	return result
+}
+
+// relativeScript returns a script path relative to the events directory
+func (r *Router) relativeScript(path string) string {
+	rel, err := filepath.Rel(filepath.Join(r.basePath, "events"), path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isActionScript reports whether a handler path (relative to the events
+// directory) is a device action, events/device/<id>/actions/<action>.lua
+func isActionScript(rel string) bool {
+	parts := strings.Split(rel, "/")
+	return len(parts) == 4 && parts[0] == "device" && parts[2] == "actions"
+}
@@ -0,0 +1,229 @@
+package events
+
+import (
+	"fmt"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUnrouted bounds the handler directories counted for unrouted events
+const maxUnrouted = 1000
+
+// scaffoldHintAfter is how many unrouted events of one kind are logged with
+// the command that creates a handler for them (once per directory)
+const scaffoldHintAfter = 3
+
+// UnroutedEvent counts the events of one kind that matched no script
+type UnroutedEvent struct {
+	// Directory is where their handler would go, relative to events/
+	Directory string    `json:"directory"`
+	Source    string    `json:"source"`
+	Type      string    `json:"type,omitempty"`
+	Device    string    `json:"device,omitempty"`
+	Attribute string    `json:"attribute,omitempty"`
+	Count     uint64    `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Hint points at a likely typo (similar directory, non-.lua file)
+	Hint string `json:"hint,omitempty"`
+}
+
+// unroutedCounter tallies unrouted events by handler directory
+type unroutedCounter struct {
+	mu      sync.Mutex
+	entries map[string]*UnroutedEvent
+}
+
+func newUnroutedCounter() *unroutedCounter {
+	return &unroutedCounter{entries: make(map[string]*UnroutedEvent)}
+}
+
+// record counts event and returns its handler directory and how many events
+// went there so far (0 if it isn't counted)
+func (c *unroutedCounter) record(event *types.Event) (string, uint64) {
+	dir := handlerDir(event)
+	if dir == "" {
+		return "", 0
+	}
+	now := event.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[dir]
+	if !ok {
+		if len(c.entries) >= maxUnrouted {
+			return dir, 0
+		}
+		entry = &UnroutedEvent{
+			Directory: dir,
+			Source:    event.Source,
+			Device:    event.Device,
+			Attribute: event.Attribute,
+			FirstSeen: now,
+		}
+		if event.Source != "device" {
+			entry.Type = event.Type
+		}
+		c.entries[dir] = entry
+	}
+	entry.Count++
+	if now.After(entry.LastSeen) {
+		entry.LastSeen = now
+	}
+	return dir, entry.Count
+}
+
+// handlerDir is the directory under events/ a handler for event would be in
+func handlerDir(event *types.Event) string {
+	switch event.Source {
+	case "device":
+		if event.Device == "" {
+			return ""
+		}
+		if event.Attribute == "" {
+			return "device/" + event.Device
+		}
+		return "device/" + event.Device + "/" + event.Attribute
+	case "mqtt":
+		if event.Handler != "" {
+			return "mqtt/" + event.Handler
+		}
+		return "mqtt/" + event.Topic
+	case "state":
+		if event.Attribute == "" {
+			return ""
+		}
+		return "state/" + event.Attribute
+	case "time", "custom", "system", "webhook":
+		if event.Type == "" {
+			return ""
+		}
+		return event.Source + "/" + event.Type
+	}
+	return ""
+}
+
+// CheckUnrouted counts event as unrouted if no script would handle it, and
+// reports whether it was
+func (r *Router) CheckUnrouted(event *types.Event) bool {
+	if len(r.findScripts(event)) > 0 {
+		return false
+	}
+	r.unrouted.record(event)
+	return true
+}
+
+// Unrouted returns the events that matched no script since the start, most
+// frequent first; with hints, each says where the handler tree looks
+// misspelled (reads the events directory)
+func (r *Router) Unrouted(hints bool) []UnroutedEvent {
+	r.unrouted.mu.Lock()
+	list := make([]UnroutedEvent, 0, len(r.unrouted.entries))
+	for _, entry := range r.unrouted.entries {
+		list = append(list, *entry)
+	}
+	r.unrouted.mu.Unlock()
+
+	if hints {
+		for i := range list {
+			list[i].Hint = r.unroutedHint(list[i].Directory)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Directory < list[j].Directory
+	})
+	return list
+}
+
+// unroutedHint looks for the first missing directory on the way to dir and
+// suggests a similarly named one, or lists the non-.lua files of an existing
+// directory
+func (r *Router) unroutedHint(dir string) string {
+	path := filepath.Join(r.basePath, "events")
+	parts := strings.Split(dir, "/")
+	for i, part := range parts {
+		next := filepath.Join(path, part)
+		if info, err := os.Stat(next); err != nil || !info.IsDir() {
+			if match := similarEntry(path, part); match != "" {
+				return fmt.Sprintf("events/%s does not exist, did you mean %s?", strings.Join(parts[:i+1], "/"), match)
+			}
+			return ""
+		}
+		path = next
+	}
+
+	entries, _ := os.ReadDir(path)
+	var others []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".lua") {
+			others = append(others, entry.Name())
+		}
+	}
+	if len(others) > 0 {
+		return fmt.Sprintf("events/%s has no .lua handler (found %s)", dir, strings.Join(others, ", "))
+	}
+	return fmt.Sprintf("events/%s has no .lua handler", dir)
+}
+
+// similarEntry returns the entry of dir that name is probably a typo of
+// (differs in case or by up to two edits), or ""
+func similarEntry(dir, name string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return similarName(name, names)
+}
+
+// similarName returns the candidate that name is probably a typo of, or ""
+func similarName(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, name) {
+			return candidate
+		}
+		if len(name) < 4 {
+			continue
+		}
+		if d := editDistance(strings.ToLower(candidate), strings.ToLower(name)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
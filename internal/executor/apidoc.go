@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+//go:generate go run ../../cmd/luadoc -dir . -out apidoc_gen.go
+
+// APIFunction documents one Lua function registered by the executor. The
+// reference is generated from the doc comments of the Go functions that
+// implement it ("Usage:" and "Returns:" lines); run go generate after
+// changing the scripting API.
+type APIFunction struct {
+	Table   string   // global table ("device"), empty for global functions
+	Name    string   // function name within the table
+	Doc     string   // description
+	Usage   []string // example calls
+	Returns string   // description of return values
+}
+
+// QualifiedName returns the name scripts call, e.g. "device.set"
+func (f APIFunction) QualifiedName() string {
+	if f.Table == "" {
+		return f.Name
+	}
+	return f.Table + "." + f.Name
+}
+
+// APIReference returns every documented Lua function, grouped by table
+func APIReference() []APIFunction {
+	return append([]APIFunction(nil), luaAPIReference...)
+}
+
+// APIReferenceMarkdown renders the Lua API reference as Markdown
+func APIReferenceMarkdown() string {
+	var b strings.Builder
+	b.WriteString("# Lua API Reference\n\n")
+	fmt.Fprintf(&b, "Generated from the server source (API version %d).\n", CurrentAPIVersion)
+
+	table := "-"
+	for _, f := range luaAPIReference {
+		if f.Table != table {
+			table = f.Table
+			if table == "" {
+				b.WriteString("\n## Globals\n")
+			} else {
+				fmt.Fprintf(&b, "\n## %s\n", table)
+			}
+		}
+
+		fmt.Fprintf(&b, "\n### `%s`\n\n", f.QualifiedName())
+		if f.Doc != "" {
+			b.WriteString(strings.ReplaceAll(f.Doc, "\n", "  \n") + "\n\n")
+		}
+		if len(f.Usage) > 0 {
+			b.WriteString("```lua\n")
+			for _, usage := range f.Usage {
+				b.WriteString(usage + "\n")
+			}
+			b.WriteString("```\n\n")
+		}
+		if f.Returns != "" {
+			fmt.Fprintf(&b, "Returns: %s\n", f.Returns)
+		}
+	}
+	return b.String()
+}
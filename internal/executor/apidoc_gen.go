@@ -0,0 +1,51 @@
+// Code generated by luadoc; DO NOT EDIT.
+
+package executor
+
+var luaAPIReference = []APIFunction{
+	{Table: "", Name: "DoSiblings", Doc: "Run the other scripts of this directory\nSiblings receive the same event and run in file name order", Usage: []string{"DoSiblings()"}, Returns: "number of sibling scripts executed"},
+	{Table: "device", Name: "get", Doc: "Returns the last known state of a device", Usage: []string{"local porch = device.get(\"porch\")"}, Returns: "table of attributes ({state = \"ON\", brightness = 200, ...}), or nil for unknown devices"},
+	{Table: "device", Name: "set", Doc: "Sends attributes to a device. The optional options table\noverrides the device's optimistic/confirm settings from devices.yaml:\noptimistic updates device.get right away, confirm waits that many seconds\nfor the device to report the values (resending up to retries times, then\nrouting a command_failed event); qos and retain override the device's MQTT\nsettings for this command.", Usage: []string{"device.set(\"porch\", {state = \"ON\", brightness = 200})", "device.set(\"porch\", {state = \"ON\"}, {optimistic = true, confirm = 5, retries = 2})", "device.set(\"garage_door\", {state = \"CLOSE\"}, {qos = 1})"}, Returns: "API v2: true, or false + error message; API v1: nothing"},
+	{Table: "device", Name: "call", Doc: "Runs the action script events/device/<id>/actions/<action>.lua", Usage: []string{"device.call(\"porch\", \"toggle\", {duration = 30})"}, Returns: "true on success, false otherwise"},
+	{Table: "device", Name: "list", Doc: "Returns configured devices, optionally filtered by area and/or type", Usage: []string{"device.list()", "device.list({area = \"kitchen\", type = \"light\"})"}, Returns: "array of {id, name, type, area}"},
+	{Table: "device", Name: "is_online", Doc: "Reports device availability", Usage: []string{"if device.is_online(\"porch\") == false then ... end"}, Returns: "true/false, or nil if the device never reported availability"},
+	{Table: "device", Name: "last_seen", Doc: "Returns when a device last sent a state message", Usage: []string{"local t = device.last_seen(\"porch\"); if t and os.time() - t > 3600 then ... end"}, Returns: "unix timestamp in seconds, or nil if nothing arrived since the server started"},
+	{Table: "effect", Name: "flash", Doc: "Toggles a device on and off without holding the script: the\nsteps run on the scheduler, and with restore (default true) brightness,\ncolor and the rest of the prior state are put back afterwards, even after a\nrestart in the middle of the effect", Usage: []string{"effect.flash(\"hall_light\", 3, 1, true)"}, Returns: "true on success, false + error otherwise"},
+	{Table: "event", Name: "emit", Doc: "Creates event.emit bound to the event that triggered the script\nRoutes to config/events/custom/<name>/*.lua", Usage: []string{"event.emit(\"house_armed\", {by = \"keypad\"})"}, Returns: "true on success, false + error otherwise"},
+	{Table: "event", Name: "history", Doc: "Returns recent values of a device attribute, oldest first\nEach entry is {value = ..., timestamp = <unix seconds>}", Usage: []string{"local readings = event.history(\"kitchen_sensor\", \"temperature\", 3)"}, Returns: ""},
+	{Table: "group", Name: "set", Doc: "Sets attributes on all members of a device group", Usage: []string{"group.set(\"downstairs_lights\", {state = \"OFF\"})"}, Returns: "true on success, false + error otherwise"},
+	{Table: "hass", Name: "call", Doc: "Calls any Home Assistant service over the WebSocket connection", Usage: []string{"hass.call(\"light\", \"turn_on\", {entity_id = \"light.kitchen\", brightness = 120})", "hass.call(\"notify\", \"mobile_app_phone\", {message = \"Door open\"})"}, Returns: "true on success, false + error otherwise"},
+	{Table: "job", Name: "submit", Doc: "Runs a function in the background once the handler has returned,\nwith its own timeout (default 60 s, at most 600 s), so slow work such as\nHTTP uploads doesn't hold the event worker. The job sees the script's\nglobals and upvalues; errors are logged.", Usage: []string{"job.submit(function() ... end)", "job.submit(fn, {timeout = 300, name = \"upload\"})"}, Returns: "job ID, or nil + error if the job queue is full"},
+	{Table: "log", Name: "info", Doc: "Writes an info line to the server log\nAPI v1 accepts a single string; API v2 joins any values with spaces", Usage: []string{"log.info(\"Temperature:\", event.data.temperature)"}, Returns: ""},
+	{Table: "log", Name: "warn", Doc: "Writes a warning to the server log", Usage: []string{"log.warn(\"Battery low on\", event.device)"}, Returns: ""},
+	{Table: "log", Name: "error", Doc: "Writes an error to the server log", Usage: []string{"log.error(\"Failed to reach the doorbell\")"}, Returns: ""},
+	{Table: "mqtt", Name: "publish", Doc: "Publishes a message; a table payload is sent as JSON.\nThe options table sets qos (0-2, default 0) and retain (default false).", Usage: []string{"mqtt.publish(\"home/alarm\", \"armed\")", "mqtt.publish(\"home/alarm/state\", {armed = true}, {qos = 1, retain = true})"}, Returns: "true on success, false + error otherwise"},
+	{Table: "scene", Name: "activate", Doc: "Applies a scene from config/scenes/<name>.yaml", Usage: []string{"scene.activate(\"movie_night\")"}, Returns: "true on success, false + error otherwise"},
+	{Table: "scene", Name: "capture", Doc: "Saves the current state of devices as a new scene", Usage: []string{"scene.capture(\"evening\", {\"living_room_lamp\", \"tv_backlight\"})"}, Returns: "true on success, false + error otherwise"},
+	{Table: "scene", Name: "restore", Doc: "Puts devices back into a scene saved by scene.capture; devices\nthat were off are only switched off", Usage: []string{"scene.restore(\"before_alert\")"}, Returns: "true on success, false + error otherwise"},
+	{Table: "scene", Name: "list", Doc: "Returns the names of all defined scenes", Usage: []string{"for _, name in ipairs(scene.list()) do ... end"}, Returns: ""},
+	{Table: "service", Name: "on", Doc: "Calls fn with every routed event matching a pattern laid out\nlike the events/ directories, with + for one level and a trailing # for\nthe rest. Only available in config/services/ scripts.", Usage: []string{"service.on(\"device/+/temperature\", function(event) ... end)"}, Returns: "subscription ID, or nil + error"},
+	{Table: "service", Name: "off", Doc: "Removes a service.on subscription", Usage: []string{"service.off(id)"}, Returns: "true if the subscription existed"},
+	{Table: "service", Name: "call", Doc: "Calls a function of the table a service script returned, in\nthe service's state. Arguments and results are copied (no functions).\nWaits while the service handles an event, up to the caller's timeout.", Usage: []string{"local avg = service.call(\"averages\", \"get\", \"kitchen\")"}, Returns: "the function's results, or nil + error"},
+	{Table: "state", Name: "get", Doc: "Reads a persisted value", Usage: []string{"local count = state.get(\"doorbell.count\")"}, Returns: "the stored value, or nil if the key does not exist"},
+	{Table: "state", Name: "set", Doc: "Persists a value (string, number, boolean or table) across\nrestarts, optionally deleting it after ttl_seconds", Usage: []string{"state.set(\"doorbell.count\", count + 1)", "state.set(\"presence.kitchen\", true, 600)"}, Returns: ""},
+	{Table: "state", Name: "delete", Doc: "Removes a persisted value", Usage: []string{"state.delete(\"doorbell.count\")"}, Returns: ""},
+	{Table: "state", Name: "set_ttl", Doc: "Makes an existing value expire after ttl_seconds (0 keeps it\nforever)", Usage: []string{"state.set_ttl(\"cooldown.doorbell\", 60)"}, Returns: "true, or false if the key does not exist"},
+	{Table: "state", Name: "ttl", Doc: "Returns the seconds left before a value expires", Usage: []string{"local left = state.ttl(\"cooldown.doorbell\")"}, Returns: "seconds left, or nil if the key has no TTL"},
+	{Table: "state", Name: "dump", Doc: "Reads every persisted value whose key starts with prefix (all\nvalues without one)", Usage: []string{"for key, value in pairs(state.dump(\"presence.\")) do log.info(key, value) end"}, Returns: "table of key = value"},
+	{Table: "state", Name: "keys", Doc: "Lists the persisted keys starting with prefix, sorted", Usage: []string{"for _, key in ipairs(state.keys(\"power.reading_\")) do state.delete(key) end"}, Returns: "array of keys"},
+	{Table: "state", Name: "get_all", Doc: "Reads every persisted value whose key starts with prefix", Usage: []string{"for key, watts in pairs(state.get_all(\"power.reading_\")) do total = total + watts end"}, Returns: "table of key = value"},
+	{Table: "state", Name: "mget", Doc: "Reads several persisted values in one transaction", Usage: []string{"local s = state.mget({\"frigate.person.count\", \"frigate.car.count\"})"}, Returns: "table of key = value for the keys that exist"},
+	{Table: "state", Name: "mset", Doc: "Persists several values in one transaction (all or nothing)", Usage: []string{"state.mset({[\"frigate.person.count\"] = 3, [\"frigate.last_seen\"] = os.time()})"}, Returns: "true on success, false + error otherwise"},
+	{Table: "state", Name: "incr", Doc: "Atomically adds delta (default 1) to a persisted number; a\nmissing key counts as 0 and a TTL is kept", Usage: []string{"local visits = state.incr(\"doorbell.count\")", "state.incr(\"power.total_wh\", -12.5)"}, Returns: "the new value, or nil + error if the key holds something else"},
+	{Table: "state", Name: "cas", Doc: "Atomically replaces a persisted value only if it still holds old\n(nil for a missing key); a nil new value deletes the key", Usage: []string{"if state.cas(\"alarm.mode\", \"armed\", \"triggered\") then siren() end", "local ok = state.cas(\"lock.garage\", nil, event.correlation_id)"}, Returns: "true if swapped, otherwise false + the current value"},
+	{Table: "state", Name: "scoped", Doc: "Returns the state API with keys private to the running\nscript, so automations can use short names without colliding; the\nglobal state table stays available for shared data", Usage: []string{"local my = state.scoped(); my.set(\"last_run\", os.time())"}, Returns: "table with get, set, delete, set_ttl, ttl, dump, keys, get_all, mget, mset, incr and cas"},
+	{Table: "state", Name: "flush", Doc: "Commits state writes batched by storage.batch_window now,\ne.g. before a script triggers something that reads the database file", Usage: []string{"state.flush()"}, Returns: "true on success, false + error otherwise"},
+	{Table: "system", Name: "backup", Doc: "Copies the state database to the backup directory while the\nserver runs, deleting the oldest copies beyond the configured number", Usage: []string{"local path, err = system.backup()"}, Returns: "path of the copy, or nil + error"},
+	{Table: "timer", Name: "after", Doc: "Schedules a timer to run after specified duration", Usage: []string{"timer.after(60, callback)", "timer.after(60, \"timer_id\", callback)"}, Returns: "timer ID, or nil if the scheduler is unavailable"},
+	{Table: "timer", Name: "at", Doc: "Schedules a timer at specific time (HH:MM format)", Usage: []string{"timer.at(\"17:30\", callback)", "timer.at(\"17:30\", \"timer_id\", callback)"}, Returns: "timer ID, or nil if the time is invalid"},
+	{Table: "timer", Name: "every", Doc: "Creates a recurring timer", Usage: []string{"timer.every(300, callback)", "timer.every(300, \"timer_id\", callback)"}, Returns: "timer ID, or nil if the scheduler is unavailable"},
+	{Table: "timer", Name: "cancel", Doc: "Cancels a timer", Usage: []string{"timer.cancel(\"timer_id\")"}, Returns: "true if the timer existed"},
+	{Table: "timer", Name: "list", Doc: "Returns list of active timers", Usage: []string{"local timers = timer.list()"}, Returns: "array of timer IDs"},
+	{Table: "udp", Name: "send", Doc: "Sends a datagram (text or binary), e.g. a StatsD metric\nmessage — required: Lua string (can be text or binary, with \\0 etc.)\nhost    — optional, string, default \"127.0.0.1\"\nport    — optional, number, default 8125", Usage: []string{"udp.send(\"temp:21.5|g\", \"127.0.0.1\", 8125)"}, Returns: "true/nil on success, false + error on failure"},
+}
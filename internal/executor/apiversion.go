@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Scripting API versions. Scripts declare the version they were written
+// against with "-- @api_version N"; scripts without the annotation get
+// APIVersion1 so existing handlers keep their original behavior.
+//
+// APIVersion2 changes:
+//   - log.info/warn/error accept multiple values of any type, joined by spaces
+//   - device.set returns true, or false + error message
+const (
+	APIVersion1       = 1
+	APIVersion2       = 2
+	CurrentAPIVersion = APIVersion2
+)
+
+// apiVersionGlobal holds the API version the running script was written against
+const apiVersionGlobal = "API_VERSION"
+
+// setAPIVersion stores the script API version in the Lua state (also readable by scripts)
+func setAPIVersion(L *lua.LState, version int) {
+	L.SetGlobal(apiVersionGlobal, lua.LNumber(version))
+}
+
+// apiVersionOf returns the API version of a Lua state (APIVersion1 if unset)
+func apiVersionOf(L *lua.LState) int {
+	if v, ok := L.GetGlobal(apiVersionGlobal).(lua.LNumber); ok {
+		return int(v)
+	}
+	return APIVersion1
+}
+
+// logMessage builds a log line from the call arguments according to the API version
+func logMessage(L *lua.LState) string {
+	if apiVersionOf(L) < APIVersion2 {
+		return L.CheckString(1)
+	}
+
+	parts := make([]string, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"sync"
+	"time"
+)
+
+// budgetWarnAfter is how many consecutive overruns trigger a warning
+const budgetWarnAfter = 3
+
+// BudgetStat holds execution budget statistics for a single script
+type BudgetStat struct {
+	Budget       time.Duration
+	Runs         int
+	Overruns     int
+	Consecutive  int
+	LastDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+// budgetTracker records script durations against their execution budgets
+type budgetTracker struct {
+	stats map[string]*BudgetStat
+	mu    sync.Mutex
+}
+
+func newBudgetTracker() *budgetTracker {
+	return &budgetTracker{stats: make(map[string]*BudgetStat)}
+}
+
+// record stores a script run and warns when the budget is repeatedly exceeded
+func (b *budgetTracker) record(scriptPath string, budget, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stat, ok := b.stats[scriptPath]
+	if !ok {
+		stat = &BudgetStat{}
+		b.stats[scriptPath] = stat
+	}
+
+	stat.Budget = budget
+	stat.Runs++
+	stat.LastDuration = elapsed
+	if elapsed > stat.MaxDuration {
+		stat.MaxDuration = elapsed
+	}
+
+	if budget <= 0 || elapsed <= budget {
+		if stat.Consecutive >= budgetWarnAfter {
+			log.Info("Script %s is back within budget (%v <= %v)", scriptPath, elapsed, budget)
+		}
+		stat.Consecutive = 0
+		return
+	}
+
+	stat.Overruns++
+	stat.Consecutive++
+	log.Debug("Script %s exceeded budget: %v > %v", scriptPath, elapsed, budget)
+
+	// Warn once the overrun streak is established, then every budgetWarnAfter runs
+	if stat.Consecutive%budgetWarnAfter == 0 {
+		log.Warn("Script %s exceeded its %v budget %d times in a row (last: %v, max: %v, total overruns: %d/%d)",
+			scriptPath, budget, stat.Consecutive, elapsed, stat.MaxDuration, stat.Overruns, stat.Runs)
+	}
+}
+
+// snapshot returns a copy of all statistics
+func (b *budgetTracker) snapshot() map[string]BudgetStat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := make(map[string]BudgetStat, len(b.stats))
+	for path, stat := range b.stats {
+		result[path] = *stat
+	}
+	return result
+}
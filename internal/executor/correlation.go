@@ -0,0 +1,30 @@
+package executor
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// correlationGlobal holds the correlation ID of the event that created a Lua state
+const correlationGlobal = "__correlation_id__"
+
+// setCorrelation stores the correlation ID in the Lua state so that timer
+// callbacks, emits and action calls made from it stay in the same chain
+func setCorrelation(L *lua.LState, id string) {
+	L.SetGlobal(correlationGlobal, lua.LString(id))
+}
+
+// correlationOf returns the correlation ID stored in a Lua state ("" if none)
+func correlationOf(L *lua.LState) string {
+	if id, ok := L.GetGlobal(correlationGlobal).(lua.LString); ok {
+		return string(id)
+	}
+	return ""
+}
+
+// correlationPrefix formats the correlation ID for script log lines
+func correlationPrefix(L *lua.LState) string {
+	if id := correlationOf(L); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
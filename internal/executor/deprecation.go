@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Deprecation is a deprecated pattern found in a handler script
+type Deprecation struct {
+	Script  string `json:"script"`
+	Line    int    `json:"line"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Hint    string `json:"hint"`
+}
+
+// deprecationRule matches, on a single source line, the use of an API whose
+// behavior changed in a later API version, for scripts that still run with
+// an older one
+type deprecationRule struct {
+	id      string
+	before  int // applies to scripts whose API version is below this
+	pattern *regexp.Regexp
+	message string
+	hint    string
+}
+
+var deprecationRules = []deprecationRule{
+	{
+		id:      "device-set-result",
+		before:  APIVersion2,
+		pattern: regexp.MustCompile(`(\bif|\bnot|\breturn|\band|\bor|[=(,])\s*device\.set\(`),
+		message: "device.set returns nothing under API version 1, so its result is always nil",
+		hint:    "add '-- @api_version 2' at the top to get ok, err (see README, API Versions)",
+	},
+	{
+		id:      "log-multiple-values",
+		before:  APIVersion2,
+		pattern: regexp.MustCompile(`\blog\.(debug|info|warn|error)\(\s*("[^"]*"|'[^']*')\s*,`),
+		message: "log.* prints only its first argument under API version 1",
+		hint:    "add '-- @api_version 2' at the top, or join the values with ..",
+	},
+}
+
+// CheckDeprecations scans a handler script for APIs that behave differently
+// under the API version it runs with than the script expects
+func CheckDeprecations(path string) ([]Deprecation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	version := parseScriptMeta(path).APIVersion
+	var rules []deprecationRule
+	for _, rule := range deprecationRules {
+		if version < rule.before {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var found []Deprecation
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		// Ignore commented-out code
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		for _, rule := range rules {
+			if rule.pattern.MatchString(line) {
+				found = append(found, Deprecation{
+					Script:  path,
+					Line:    lineNo,
+					Rule:    rule.id,
+					Message: rule.message,
+					Hint:    rule.hint,
+				})
+			}
+		}
+	}
+
+	return found, scanner.Err()
+}
+
+// warnDeprecations logs deprecations for a script (called once per script version)
+func warnDeprecations(path string) {
+	found, err := CheckDeprecations(path)
+	if err != nil {
+		return
+	}
+	for _, d := range found {
+		log.Warn("Deprecated [%s] %s:%d: %s; migration: %s", d.Rule, d.Script, d.Line, d.Message, d.Hint)
+	}
+}
@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// EffectEngine runs light effects from Go (implemented by effects.Engine)
+type EffectEngine interface {
+	Flash(device string, times int, interval time.Duration, restore bool) error
+}
+
+// SetEffects sets the effect engine exposed as the effect Lua table
+func (e *Executor) SetEffects(engine EffectEngine) {
+	e.effects = engine
+}
+
+func (e *Executor) registerEffectAPI(L *lua.LState) {
+	effectTable := L.NewTable()
+	L.SetField(effectTable, "flash", L.NewFunction(e.effectFlash))
+	L.SetGlobal("effect", effectTable)
+}
+
+// effectFlash toggles a device on and off without holding the script: the
+// steps run on the scheduler, and with restore (default true) brightness,
+// color and the rest of the prior state are put back afterwards, even after a
+// restart in the middle of the effect
+// Usage: effect.flash("hall_light", 3, 1, true)
+// Returns: true on success, false + error otherwise
+func (e *Executor) effectFlash(L *lua.LState) int {
+	device := L.CheckString(1)
+	times := L.OptInt(2, 3)
+	interval := float64(L.OptNumber(3, 1))
+	restore := L.OptBool(4, true)
+
+	if e.effects == nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("effects not available"))
+		return 2
+	}
+
+	log.Debug("[%s] effect.flash %s %d x %gs", correlationOf(L), device, times, interval)
+	if err := e.effects.Flash(device, times, time.Duration(interval*float64(time.Second)), restore); err != nil {
+		log.Error("[%s] Failed to flash %s: %v", correlationOf(L), device, err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"homescript-server/internal/types"
+	"regexp"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxEmitDepth limits emit chains so scripts emitting each other can't loop forever
+const maxEmitDepth = 8
+
+// customEventName restricts custom event names to a single safe path segment
+var customEventName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// makeEventEmit creates event.emit bound to the event that triggered the script
+// Usage: event.emit("house_armed", {by = "keypad"})
+// Routes to config/events/custom/<name>/*.lua
+// Returns: true on success, false + error otherwise
+func (e *Executor) makeEventEmit(parent *types.Event) lua.LGFunction {
+	return func(L *lua.LState) int {
+		name := L.CheckString(1)
+
+		if !customEventName.MatchString(name) || name == "." || name == ".." {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString("invalid event name: " + name))
+			return 2
+		}
+
+		if e.router == nil {
+			luaLog.Warn("Router not available for event.emit")
+			L.Push(lua.LFalse)
+			L.Push(lua.LString("router not available"))
+			return 2
+		}
+
+		depth := 1
+		if parent != nil {
+			depth = parent.Depth + 1
+		}
+		if depth > maxEmitDepth {
+			luaLog.Error("event.emit(%q) dropped: emit chain deeper than %d", name, maxEmitDepth)
+			L.Push(lua.LFalse)
+			L.Push(lua.LString("emit chain too deep"))
+			return 2
+		}
+
+		data := make(map[string]interface{})
+		if L.GetTop() >= 2 && L.Get(2) != lua.LNil {
+			dataTable := L.CheckTable(2)
+			dataTable.ForEach(func(key, value lua.LValue) {
+				if keyStr, ok := key.(lua.LString); ok {
+					data[string(keyStr)] = e.fromLuaValue(value)
+				}
+			})
+		}
+
+		correlationID := correlationOf(L)
+		log.Debug("[%s] Script emitted custom event: %s (depth %d)", correlationID, name, depth)
+
+		e.router.RouteEvent(&types.Event{
+			Source:        "custom",
+			Type:          name,
+			Data:          data,
+			Timestamp:     time.Now(),
+			Depth:         depth,
+			CorrelationID: correlationID,
+		})
+
+		L.Push(lua.LTrue)
+		L.Push(lua.LNil)
+		return 2
+	}
+}
@@ -0,0 +1,53 @@
+package executor
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// HomeAssistant calls services of a Home Assistant instance (implemented by
+// hass.Bridge)
+type HomeAssistant interface {
+	CallService(domain, service string, data map[string]interface{}) error
+}
+
+// SetHomeAssistant sets the connection used by hass.call
+func (e *Executor) SetHomeAssistant(h HomeAssistant) {
+	e.hass = h
+}
+
+func (e *Executor) registerHassAPI(L *lua.LState) {
+	hassTable := L.NewTable()
+	L.SetField(hassTable, "call", L.NewFunction(e.hassCall))
+	L.SetGlobal("hass", hassTable)
+}
+
+// hassCall calls any Home Assistant service over the WebSocket connection
+// Usage: hass.call("light", "turn_on", {entity_id = "light.kitchen", brightness = 120})
+// Usage: hass.call("notify", "mobile_app_phone", {message = "Door open"})
+// Returns: true on success, false + error otherwise
+func (e *Executor) hassCall(L *lua.LState) int {
+	domain := L.CheckString(1)
+	service := L.CheckString(2)
+	var data map[string]interface{}
+	if table, ok := L.Get(3).(*lua.LTable); ok {
+		data, _ = e.fromLuaValue(table).(map[string]interface{})
+	}
+
+	if e.hass == nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("Home Assistant not connected (homeassistant.websocket in server.yaml)"))
+		return 2
+	}
+
+	log.Debug("[%s] hass.call %s.%s", correlationOf(L), domain, service)
+	if err := e.hass.CallService(domain, service, data); err != nil {
+		log.Error("[%s] Home Assistant call failed: %v", correlationOf(L), err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
@@ -0,0 +1,168 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	// DefaultJobWorkers is how many background jobs run at once
+	DefaultJobWorkers = 4
+	// DefaultJobTimeout applies to jobs submitted without a timeout
+	DefaultJobTimeout = 60 * time.Second
+	// MaxJobTimeout bounds the timeout a script may ask for
+	MaxJobTimeout = 10 * time.Minute
+	// maxPendingJobs bounds jobs waiting for a worker or running
+	maxPendingJobs = 100
+)
+
+// job is a Lua function running in the background, in the state of the
+// script that submitted it
+type job struct {
+	id      string
+	fn      *lua.LFunction
+	state   *lua.LState
+	timeout time.Duration
+}
+
+// SetJobWorkers sets how many background jobs run at once (call before
+// scripts run)
+func (e *Executor) SetJobWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	e.jobSlots = make(chan struct{}, n)
+}
+
+// SetJobDispatcher replaces the goroutines that run background jobs, e.g.
+// with the test harness queue so jobs run in a fixed order
+func (e *Executor) SetJobDispatcher(dispatch func(run func())) {
+	e.jobDispatch = dispatch
+}
+
+func (e *Executor) registerJobAPI(L *lua.LState) {
+	jobTable := L.NewTable()
+	L.SetField(jobTable, "submit", L.NewFunction(e.jobSubmit))
+	L.SetGlobal("job", jobTable)
+}
+
+// jobSubmit runs a function in the background once the handler has returned,
+// with its own timeout (default 60 s, at most 600 s), so slow work such as
+// HTTP uploads doesn't hold the event worker. The job sees the script's
+// globals and upvalues; errors are logged.
+// Usage: job.submit(function() ... end) or job.submit(fn, {timeout = 300, name = "upload"})
+// Returns: job ID, or nil + error if the job queue is full
+func (e *Executor) jobSubmit(L *lua.LState) int {
+	fn := L.CheckFunction(1)
+	opts := L.OptTable(2, nil)
+
+	name := "job"
+	timeout := DefaultJobTimeout
+	if opts != nil {
+		if s, ok := opts.RawGetString("name").(lua.LString); ok && s != "" {
+			name = string(s)
+		}
+		if n, ok := opts.RawGetString("timeout").(lua.LNumber); ok {
+			timeout = time.Duration(float64(n) * float64(time.Second))
+		}
+	}
+	if timeout <= 0 || timeout > MaxJobTimeout {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(fmt.Sprintf("timeout must be between 0 and %d seconds", int(MaxJobTimeout.Seconds()))))
+		return 2
+	}
+
+	if atomic.AddInt32(&e.jobsPending, 1) > maxPendingJobs {
+		atomic.AddInt32(&e.jobsPending, -1)
+		log.Warn("[%s] Job queue full, dropping %s", correlationOf(L), name)
+		L.Push(lua.LNil)
+		L.Push(lua.LString("job queue full"))
+		return 2
+	}
+
+	j := &job{
+		id:      fmt.Sprintf("%s_%d", name, time.Now().UnixNano()),
+		fn:      fn,
+		state:   L,
+		timeout: timeout,
+	}
+	// Like a timer, the job keeps the script's state open until it finished
+	holdState(L)
+
+	log.Debug("[%s] Job %s submitted (timeout %s)", correlationOf(L), j.id, timeout)
+	dispatch := e.jobDispatch
+	if dispatch == nil {
+		dispatch = func(run func()) { go run() }
+	}
+	dispatch(func() { e.runJob(j) })
+
+	L.Push(lua.LString(j.id))
+	return 1
+}
+
+// runJob waits for a free job worker and for the submitting script to
+// finish, then calls the job function
+func (e *Executor) runJob(j *job) {
+	defer atomic.AddInt32(&e.jobsPending, -1)
+
+	if e.jobDispatch == nil {
+		e.jobSlots <- struct{}{}
+		defer func() { <-e.jobSlots }()
+	}
+
+	e.trackersMutex.RLock()
+	tracker, exists := e.stateTrackers[j.state]
+	e.trackersMutex.RUnlock()
+	if !exists {
+		log.Error("Job %s: Lua state %p not tracked", j.id, j.state)
+		return
+	}
+
+	tracker.executeMux.Lock()
+	correlation := correlationOf(j.state)
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	j.state.SetContext(ctx)
+	started := time.Now()
+	err := j.state.CallByParam(lua.P{Fn: j.fn, NRet: 0, Protect: true})
+	cancel()
+	remaining := releaseHold(j.state)
+	tracker.executeMux.Unlock()
+
+	if err != nil {
+		log.Error("[%s] Job %s failed after %s: %v", correlation, j.id, time.Since(started).Round(time.Millisecond), err)
+	} else {
+		log.Debug("[%s] Job %s done in %s", correlation, j.id, time.Since(started).Round(time.Millisecond))
+	}
+	if remaining == 0 {
+		e.releaseStateReference(j.state)
+	}
+}
+
+// holdState counts a pending callback of L (timer or job), which keeps the
+// state open after its script returned
+func holdState(L *lua.LState) {
+	count := 0
+	if n, ok := L.GetGlobal("__timer_count__").(lua.LNumber); ok {
+		count = int(n)
+	}
+	L.SetGlobal("__timer_count__", lua.LNumber(count+1))
+	L.SetGlobal("__timers_created__", lua.LTrue)
+}
+
+// releaseHold undoes holdState and returns the callbacks still pending
+func releaseHold(L *lua.LState) int {
+	count := 1
+	if n, ok := L.GetGlobal("__timer_count__").(lua.LNumber); ok {
+		count = int(n)
+	}
+	count--
+	if count < 0 {
+		count = 0
+	}
+	L.SetGlobal("__timer_count__", lua.LNumber(count))
+	return count
+}
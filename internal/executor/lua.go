@@ -3,6 +3,8 @@ package executor
 import (
 	"context"
 	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/history"
 	"homescript-server/internal/logger"
 	"homescript-server/internal/storage"
 	"homescript-server/internal/types"
@@ -10,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +22,11 @@ import (
 	lua "github.com/yuin/gopher-lua"
 )
 
+var (
+	log    = logger.Module("executor")
+	luaLog = logger.Module("lua")
+)
+
 // luaStateTracker tracks reference count for Lua states
 type luaStateTracker struct {
 	state      *lua.LState
@@ -29,19 +37,50 @@ type luaStateTracker struct {
 
 // Executor manages Lua script execution
 type Executor struct {
-	storage       *storage.Storage
-	deviceManager DeviceManager
-	scheduler     interface{} // Scheduler interface to avoid circular dependency
-	scriptTimeout time.Duration
-	configPath    string // Base path for config directory
-	stateTrackers map[*lua.LState]*luaStateTracker
-	trackersMutex sync.RWMutex
+	storage         *storage.Storage
+	deviceManager   DeviceManager
+	scheduler       interface{} // Scheduler interface to avoid circular dependency
+	router          EventRouter
+	history         *history.History
+	scenes          SceneEngine
+	effects         EffectEngine
+	scriptTimeout   time.Duration
+	scriptBudget    time.Duration // Default execution budget (0 = disabled)
+	configPath      string        // Base path for config directory
+	stateTrackers   map[*lua.LState]*luaStateTracker
+	trackersMutex   sync.RWMutex
+	metaCache       *metaCache
+	paramsCache     *paramsCache
+	budgets         *budgetTracker
+	random          *seededRandom // nil = Lua's default math.random
+	publisher       Publisher
+	jobSlots        chan struct{}    // one per running background job
+	jobDispatch     func(run func()) // nil = a goroutine per job
+	jobsPending     int32
+	services        map[string]*service // by name
+	serviceStates   map[*lua.LState]*service
+	servicesMu      sync.RWMutex
+	serviceDispatch func(run func()) // nil = a goroutine per service
+	backupDir       string           // where system.backup writes
+	backupKeep      int              // copies system.backup keeps (0 = all)
+	hass            HomeAssistant    // nil unless the Home Assistant WebSocket API is configured
+}
+
+// EventRouter routes events emitted from scripts (implemented by events.Router)
+type EventRouter interface {
+	RouteEvent(event *types.Event)
 }
 
 // DeviceManager interface for device operations
 type DeviceManager interface {
 	Get(id string) (map[string]interface{}, error)
 	Set(id string, attrs map[string]interface{}) error
+	SetWithOptions(id string, attrs map[string]interface{}, opts devices.SetOptions) error
+	SetGroup(name string, attrs map[string]interface{}) error
+	ListDevices() []*types.Device
+	IsOnline(id string) (online bool, known bool)
+	LastSeen(id string) (time.Time, bool)
+	GetDevice(id string) (*types.Device, bool)
 }
 
 // New creates a new Executor
@@ -53,7 +92,37 @@ func New(store *storage.Storage, dm DeviceManager, configPath string) *Executor
 		scriptTimeout: 5 * time.Second,
 		configPath:    configPath,
 		stateTrackers: make(map[*lua.LState]*luaStateTracker),
+		metaCache:     newMetaCache(),
+		paramsCache:   newParamsCache(),
+		budgets:       newBudgetTracker(),
+		jobSlots:      make(chan struct{}, DefaultJobWorkers),
+	}
+}
+
+// SetScriptBudget sets the default execution budget for scripts without an @budget annotation
+func (e *Executor) SetScriptBudget(budget time.Duration) {
+	e.scriptBudget = budget
+}
+
+// BudgetStats returns execution budget statistics per script, by path
+// relative to the config directory
+func (e *Executor) BudgetStats() map[string]BudgetStat {
+	stats := e.budgets.snapshot()
+	result := make(map[string]BudgetStat, len(stats))
+	for path, stat := range stats {
+		result[e.relative(path)] = stat
 	}
+	return result
+}
+
+// SetRouter sets the event router used by event.emit (called after router is created)
+func (e *Executor) SetRouter(router EventRouter) {
+	e.router = router
+}
+
+// SetHistory sets the in-memory event history exposed as event.history
+func (e *Executor) SetHistory(h *history.History) {
+	e.history = h
 }
 
 // SetScheduler sets the scheduler reference (called after scheduler is created)
@@ -79,7 +148,7 @@ func (e *Executor) addStateReference(L *lua.LState) {
 	tracker.refCount++
 	tracker.mutex.Unlock()
 
-	logger.Debug("Lua state %p reference count increased to %d", L, tracker.refCount)
+	log.Debug("Lua state %p reference count increased to %d", L, tracker.refCount)
 }
 
 // releaseStateReference releases a reference to a Lua state and closes it if no more references
@@ -89,7 +158,7 @@ func (e *Executor) releaseStateReference(L *lua.LState) {
 
 	tracker, exists := e.stateTrackers[L]
 	if !exists {
-		logger.Warn("Attempting to release non-tracked Lua state %p", L)
+		log.Warn("Attempting to release non-tracked Lua state %p", L)
 		return
 	}
 
@@ -98,7 +167,7 @@ func (e *Executor) releaseStateReference(L *lua.LState) {
 	count := tracker.refCount
 	tracker.mutex.Unlock()
 
-	logger.Debug("Lua state %p reference count decreased to %d", L, count)
+	log.Debug("Lua state %p reference count decreased to %d", L, count)
 
 	if count <= 0 {
 		// Check if there are active timers before closing
@@ -111,14 +180,14 @@ func (e *Executor) releaseStateReference(L *lua.LState) {
 		}
 
 		if activeTimers > 0 {
-			logger.Debug("Lua state %p has %d active timer(s), keeping tracker alive", L, activeTimers)
+			log.Debug("Lua state %p has %d active timer(s), keeping tracker alive", L, activeTimers)
 			// Don't close or remove from tracking yet - timers still need it
 			return
 		}
 
 		L.Close()
 		delete(e.stateTrackers, L)
-		logger.Debug("Lua state %p closed and removed from tracking", L)
+		log.Debug("Lua state %p closed and removed from tracking", L)
 	}
 }
 
@@ -129,6 +198,11 @@ func (e *Executor) ReleaseStateReference(L *lua.LState) {
 
 // Execute runs a Lua script with the given event
 func (e *Executor) Execute(scriptPath string, event *types.Event) error {
+	params, err := e.paramsCache.get(filepath.Dir(scriptPath))
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), e.scriptTimeout)
 	defer cancel()
 
@@ -150,21 +224,61 @@ func (e *Executor) Execute(scriptPath string, event *types.Event) error {
 	// Set timeout context
 	L.SetContext(ctx)
 
+	meta := e.prepareState(L, scriptPath, event, params)
+
+	// Register DoSiblings helper
+	e.registerDoSiblings(L, scriptPath, event)
+
+	// Resolve execution budget (per-script annotation overrides default)
+	budget := e.scriptBudget
+	if meta.Budget > 0 {
+		budget = meta.Budget
+	}
+
+	// Jobs submitted by the script wait for it on the state's lock
+	e.trackersMutex.RLock()
+	tracker := e.stateTrackers[L]
+	e.trackersMutex.RUnlock()
+	tracker.executeMux.Lock()
+	defer tracker.executeMux.Unlock()
+
+	// Execute script
+	started := time.Now()
+	err = L.DoFile(scriptPath)
+	e.budgets.record(scriptPath, budget, time.Since(started))
+	if err != nil {
+		return fmt.Errorf("script execution failed: %w", err)
+	}
+
+	// Check if any timers were created during script execution
+	// If timers were created, they now own the reference - don't release here
+	timersCreated := L.GetGlobal("__timers_created__")
+	if timersCreated != lua.LNil && lua.LVAsBool(timersCreated) {
+		shouldRelease = false
+		log.Debug("Lua state %p has active timers, not releasing from Execute", L)
+	}
+
+	return nil
+}
+
+// prepareState loads the helper libraries and the API into a new state
+// for the script at scriptPath, handling event with the params of its directory
+func (e *Executor) prepareState(L *lua.LState, scriptPath string, event *types.Event, params map[string]interface{}) scriptMeta {
 	// Add config/lib to Lua package path for helper libraries
 	libPath := filepath.Join(e.configPath, "lib")
 	configLibPath := fmt.Sprintf("%s/?.lua;%s/?/init.lua", libPath, libPath)
 	if err := L.DoString(fmt.Sprintf(`package.path = package.path .. ";%s"`, configLibPath)); err != nil {
-		logger.Warn("Failed to set Lua package path: %v", err)
+		log.Warn("Failed to set Lua package path: %v", err)
 	}
 
 	// Preload color helpers
 	if err := L.DoString(`color = require("color_helpers")`); err != nil {
-		logger.Warn("Failed to load color helpers: %v", err)
+		log.Warn("Failed to load color helpers: %v", err)
 	}
 
 	// Preload Frigate helpers
 	if err := L.DoString(`frigate = require("frigate_helpers")`); err != nil {
-		logger.Warn("Failed to load Frigate helpers: %v", err)
+		log.Warn("Failed to load Frigate helpers: %v", err)
 	}
 
 	// Load socket library for network operations (HTTP, TCP, UDP)
@@ -178,26 +292,37 @@ func (e *Executor) Execute(scriptPath string, event *types.Event) error {
 	// Set SCRIPT_PATH global variable (full path to current script)
 	L.SetGlobal("SCRIPT_PATH", lua.LString(scriptPath))
 
+	// params.yaml values of the script's directory (empty table without one)
+	L.SetGlobal("params", e.toLuaValue(L, params))
+
+	// Per-script annotations (API version, budget)
+	meta := e.metaCache.get(scriptPath)
+	setAPIVersion(L, meta.APIVersion)
+
 	// Register API functions
 	e.registerAPI(L, event)
+	e.registerSeededRandom(L)
 
-	// Register DoSiblings helper
-	e.registerDoSiblings(L, scriptPath, event)
+	return meta
+}
 
-	// Execute script
-	if err := L.DoFile(scriptPath); err != nil {
-		return fmt.Errorf("script execution failed: %w", err)
+// scriptOrigin returns the running script relative to the config directory
+// (empty outside a script), which the command echo shows as the origin
+func (e *Executor) scriptOrigin(L *lua.LState) string {
+	path, ok := L.GetGlobal("SCRIPT_PATH").(lua.LString)
+	if !ok {
+		return ""
 	}
+	return e.relative(string(path))
+}
 
-	// Check if any timers were created during script execution
-	// If timers were created, they now own the reference - don't release here
-	timersCreated := L.GetGlobal("__timers_created__")
-	if timersCreated != lua.LNil && lua.LVAsBool(timersCreated) {
-		shouldRelease = false
-		logger.Debug("Lua state %p has active timers, not releasing from Execute", L)
+// relative returns a script path relative to the config directory
+func (e *Executor) relative(path string) string {
+	rel, err := filepath.Rel(e.configPath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
 	}
-
-	return nil
+	return filepath.ToSlash(rel)
 }
 
 // ExecuteCallback runs a serialized Lua callback function
@@ -229,7 +354,7 @@ func (e *Executor) ExecuteCallbackWithPost(callback *lua.LFunction, L *lua.LStat
 	tracker.executeMux.Lock()
 	defer tracker.executeMux.Unlock()
 
-	logger.Debug("Timer %s acquired lock on Lua state %p", timerID, L)
+	log.Debug("[%s] Timer %s acquired lock on Lua state %p", correlationOf(L), timerID, L)
 
 	ctx, cancel := context.WithTimeout(context.Background(), e.scriptTimeout)
 	defer cancel()
@@ -251,39 +376,32 @@ func (e *Executor) ExecuteCallbackWithPost(callback *lua.LFunction, L *lua.LStat
 		post()
 	}
 
-	logger.Debug("Timer %s released lock on Lua state %p", timerID, L)
+	log.Debug("Timer %s released lock on Lua state %p", timerID, L)
 
 	return nil
 }
 
 func (e *Executor) registerAPI(L *lua.LState, event *types.Event) {
-	// Event table
-	eventTable := L.NewTable()
-	eventTable.RawSetString("source", lua.LString(event.Source))
-	eventTable.RawSetString("type", lua.LString(event.Type))
-	if event.Device != "" {
-		eventTable.RawSetString("device", lua.LString(event.Device))
-	}
-	if event.Attribute != "" {
-		eventTable.RawSetString("attribute", lua.LString(event.Attribute))
-	}
-	if event.Topic != "" {
-		eventTable.RawSetString("topic", lua.LString(event.Topic))
-	}
-
-	// Event data - convert all values properly
-	dataTable := L.NewTable()
-	for k, v := range event.Data {
-		dataTable.RawSetString(k, e.toLuaValue(L, v))
-	}
-	eventTable.RawSetString("data", dataTable)
-	L.SetGlobal("event", eventTable)
+	// Timer callbacks reuse this state, so they inherit the correlation ID
+	setCorrelation(L, event.CorrelationID)
+	e.setEventGlobal(L, event)
 
 	// State API
 	stateTable := L.NewTable()
 	L.SetField(stateTable, "get", L.NewFunction(e.stateGet))
 	L.SetField(stateTable, "set", L.NewFunction(e.stateSet))
 	L.SetField(stateTable, "delete", L.NewFunction(e.stateDelete))
+	L.SetField(stateTable, "set_ttl", L.NewFunction(e.stateSetTTL))
+	L.SetField(stateTable, "ttl", L.NewFunction(e.stateTTL))
+	L.SetField(stateTable, "dump", L.NewFunction(e.stateDump))
+	L.SetField(stateTable, "keys", L.NewFunction(e.stateKeys))
+	L.SetField(stateTable, "get_all", L.NewFunction(e.stateGetAll))
+	L.SetField(stateTable, "mget", L.NewFunction(e.stateMGet))
+	L.SetField(stateTable, "mset", L.NewFunction(e.stateMSet))
+	L.SetField(stateTable, "incr", L.NewFunction(e.stateIncr))
+	L.SetField(stateTable, "cas", L.NewFunction(e.stateCAS))
+	L.SetField(stateTable, "scoped", L.NewFunction(e.stateScoped))
+	L.SetField(stateTable, "flush", L.NewFunction(e.stateFlush))
 	L.SetGlobal("state", stateTable)
 
 	// Device API
@@ -291,8 +409,37 @@ func (e *Executor) registerAPI(L *lua.LState, event *types.Event) {
 	L.SetField(deviceTable, "get", L.NewFunction(e.deviceGet))
 	L.SetField(deviceTable, "set", L.NewFunction(e.deviceSet))
 	L.SetField(deviceTable, "call", L.NewFunction(e.deviceCall))
+	L.SetField(deviceTable, "list", L.NewFunction(e.deviceList))
+	L.SetField(deviceTable, "is_online", L.NewFunction(e.deviceIsOnline))
+	L.SetField(deviceTable, "last_seen", L.NewFunction(e.deviceLastSeen))
 	L.SetGlobal("device", deviceTable)
 
+	// Group API
+	groupTable := L.NewTable()
+	L.SetField(groupTable, "set", L.NewFunction(e.groupSet))
+	L.SetGlobal("group", groupTable)
+
+	// Scene API
+	e.registerSceneAPI(L)
+
+	// Effect API
+	e.registerEffectAPI(L)
+
+	// Background jobs
+	e.registerJobAPI(L)
+
+	// Calls into service scripts
+	e.registerServiceAPI(L)
+
+	// MQTT API
+	e.registerMQTTAPI(L)
+
+	// Home Assistant service calls
+	e.registerHassAPI(L)
+
+	// Server maintenance
+	e.registerSystemAPI(L)
+
 	// Log functions
 	logTable := L.NewTable()
 	L.SetField(logTable, "info", L.NewFunction(e.logInfo))
@@ -315,7 +462,44 @@ func (e *Executor) registerAPI(L *lua.LState, event *types.Event) {
 	L.SetGlobal("udp", udpTable)
 }
 
-// registerDoSiblings registers the DoSiblings helper function
+// setEventGlobal makes event the `event` global of L and returns its table
+func (e *Executor) setEventGlobal(L *lua.LState, event *types.Event) *lua.LTable {
+	eventTable := L.NewTable()
+	eventTable.RawSetString("source", lua.LString(event.Source))
+	eventTable.RawSetString("type", lua.LString(event.Type))
+	if event.Device != "" {
+		eventTable.RawSetString("device", lua.LString(event.Device))
+	}
+	if event.Attribute != "" {
+		eventTable.RawSetString("attribute", lua.LString(event.Attribute))
+	}
+	if event.Area != "" {
+		eventTable.RawSetString("area", lua.LString(event.Area))
+	}
+	if event.Topic != "" {
+		eventTable.RawSetString("topic", lua.LString(event.Topic))
+	}
+	eventTable.RawSetString("correlation_id", lua.LString(event.CorrelationID))
+	if event.FirstReport {
+		eventTable.RawSetString("first_report", lua.LTrue)
+	}
+
+	// Event data - convert all values properly
+	dataTable := L.NewTable()
+	for k, v := range event.Data {
+		dataTable.RawSetString(k, e.toLuaValue(L, v))
+	}
+	eventTable.RawSetString("data", dataTable)
+	L.SetField(eventTable, "emit", L.NewFunction(e.makeEventEmit(event)))
+	L.SetField(eventTable, "history", L.NewFunction(e.eventHistory))
+	L.SetGlobal("event", eventTable)
+	return eventTable
+}
+
+// registerDoSiblings registers DoSiblings: run the other scripts of this directory
+// Siblings receive the same event and run in file name order
+// Usage: DoSiblings()
+// Returns: number of sibling scripts executed
 func (e *Executor) registerDoSiblings(L *lua.LState, currentScript string, event *types.Event) {
 	// Create closure with current script path and event
 	doSiblings := L.NewFunction(func(L *lua.LState) int {
@@ -325,7 +509,7 @@ func (e *Executor) registerDoSiblings(L *lua.LState, currentScript string, event
 		// Read directory
 		entries, err := os.ReadDir(scriptDir)
 		if err != nil {
-			logger.Error("DoSiblings: failed to read directory %s: %v", scriptDir, err)
+			log.Error("DoSiblings: failed to read directory %s: %v", scriptDir, err)
 			L.Push(lua.LFalse)
 			return 1
 		}
@@ -349,17 +533,17 @@ func (e *Executor) registerDoSiblings(L *lua.LState, currentScript string, event
 			}
 
 			siblingPath := filepath.Join(scriptDir, name)
-			logger.Debug("DoSiblings: executing %s", siblingPath)
+			log.Debug("DoSiblings: executing %s", siblingPath)
 
 			// Execute sibling script with same event
 			if err := e.Execute(siblingPath, event); err != nil {
-				logger.Error("DoSiblings: failed to execute %s: %v", siblingPath, err)
+				log.Error("DoSiblings: failed to execute %s: %v", siblingPath, err)
 			} else {
 				executed++
 			}
 		}
 
-		logger.Debug("DoSiblings: executed %d sibling scripts", executed)
+		log.Debug("DoSiblings: executed %d sibling scripts", executed)
 		L.Push(lua.LNumber(executed))
 		return 1
 	})
@@ -367,7 +551,9 @@ func (e *Executor) registerDoSiblings(L *lua.LState, currentScript string, event
 	L.SetGlobal("DoSiblings", doSiblings)
 }
 
-// State functions
+// stateGet reads a persisted value
+// Usage: local count = state.get("doorbell.count")
+// Returns: the stored value, or nil if the key does not exist
 func (e *Executor) stateGet(L *lua.LState) int {
 	key := L.CheckString(1)
 	value, err := e.storage.Get(key)
@@ -379,29 +565,257 @@ func (e *Executor) stateGet(L *lua.LState) int {
 	return 1
 }
 
+// stateSet persists a value (string, number, boolean or table) across
+// restarts, optionally deleting it after ttl_seconds
+// Usage: state.set("doorbell.count", count + 1)
+// Usage: state.set("presence.kitchen", true, 600)
 func (e *Executor) stateSet(L *lua.LState) int {
 	key := L.CheckString(1)
 	value := e.fromLuaValue(L.Get(2))
-	if err := e.storage.Set(key, value); err != nil {
-		logger.Error("Failed to set state %s: %v", key, err)
+	ttl := time.Duration(float64(L.OptNumber(3, 0)) * float64(time.Second))
+	if err := e.storage.SetTTL(key, value, ttl); err != nil {
+		log.Error("Failed to set state %s: %v", key, err)
 	}
 	return 0
 }
 
+// stateSetTTL makes an existing value expire after ttl_seconds (0 keeps it
+// forever)
+// Usage: state.set_ttl("cooldown.doorbell", 60)
+// Returns: true, or false if the key does not exist
+func (e *Executor) stateSetTTL(L *lua.LState) int {
+	key := L.CheckString(1)
+	ttl := time.Duration(float64(L.CheckNumber(2)) * float64(time.Second))
+	if err := e.storage.Expire(key, ttl); err != nil {
+		L.Push(lua.LFalse)
+		return 1
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// stateTTL returns the seconds left before a value expires
+// Usage: local left = state.ttl("cooldown.doorbell")
+// Returns: seconds left, or nil if the key has no TTL
+func (e *Executor) stateTTL(L *lua.LState) int {
+	left, ok := e.storage.TTL(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LNumber(left.Seconds()))
+	return 1
+}
+
+// stateDelete removes a persisted value
+// Usage: state.delete("doorbell.count")
 func (e *Executor) stateDelete(L *lua.LState) int {
 	key := L.CheckString(1)
 	if err := e.storage.Delete(key); err != nil {
-		logger.Error("Failed to delete state %s: %v", key, err)
+		log.Error("Failed to delete state %s: %v", key, err)
 	}
 	return 0
 }
 
-// Device functions
+// stateMGet reads several persisted values in one transaction
+// Usage: local s = state.mget({"frigate.person.count", "frigate.car.count"})
+// Returns: table of key = value for the keys that exist
+func (e *Executor) stateMGet(L *lua.LState) int {
+	keysTable := L.CheckTable(1)
+
+	var keys []string
+	keysTable.ForEach(func(_, value lua.LValue) {
+		if key, ok := value.(lua.LString); ok {
+			keys = append(keys, string(key))
+		}
+	})
+
+	table := L.NewTable()
+	values, err := e.storage.GetMany(keys)
+	if err != nil {
+		log.Error("Failed to get state %v: %v", keys, err)
+		L.Push(table)
+		return 1
+	}
+	for key, value := range values {
+		table.RawSetString(key, e.toLuaValue(L, value))
+	}
+	L.Push(table)
+	return 1
+}
+
+// stateDump reads every persisted value whose key starts with prefix (all
+// values without one)
+// Usage: for key, value in pairs(state.dump("presence.")) do log.info(key, value) end
+// Returns: table of key = value
+func (e *Executor) stateDump(L *lua.LState) int {
+	L.Push(e.statePrefixed(L, L.OptString(1, "")))
+	return 1
+}
+
+// stateKeys lists the persisted keys starting with prefix, sorted
+// Usage: for _, key in ipairs(state.keys("power.reading_")) do state.delete(key) end
+// Returns: array of keys
+func (e *Executor) stateKeys(L *lua.LState) int {
+	prefix := L.CheckString(1)
+	table := L.NewTable()
+
+	keys, err := e.storage.List(prefix)
+	if err != nil {
+		log.Error("Failed to list state %q: %v", prefix, err)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		table.Append(lua.LString(key))
+	}
+	L.Push(table)
+	return 1
+}
+
+// stateGetAll reads every persisted value whose key starts with prefix
+// Usage: for key, watts in pairs(state.get_all("power.reading_")) do total = total + watts end
+// Returns: table of key = value
+func (e *Executor) stateGetAll(L *lua.LState) int {
+	L.Push(e.statePrefixed(L, L.CheckString(1)))
+	return 1
+}
+
+// statePrefixed reads the values whose key starts with prefix into a table
+func (e *Executor) statePrefixed(L *lua.LState, prefix string) *lua.LTable {
+	table := L.NewTable()
+
+	keys, err := e.storage.List(prefix)
+	if err == nil {
+		var values map[string]interface{}
+		if values, err = e.storage.GetMany(keys); err == nil {
+			for key, value := range values {
+				table.RawSetString(key, e.toLuaValue(L, value))
+			}
+		}
+	}
+	if err != nil {
+		log.Error("Failed to read state %q: %v", prefix, err)
+	}
+	return table
+}
+
+// stateMSet persists several values in one transaction (all or nothing)
+// Usage: state.mset({["frigate.person.count"] = 3, ["frigate.last_seen"] = os.time()})
+// Returns: true on success, false + error otherwise
+func (e *Executor) stateMSet(L *lua.LState) int {
+	valuesTable := L.CheckTable(1)
+
+	values := make(map[string]interface{})
+	valuesTable.ForEach(func(key, value lua.LValue) {
+		if keyStr, ok := key.(lua.LString); ok {
+			values[string(keyStr)] = e.fromLuaValue(value)
+		}
+	})
+
+	if err := e.storage.SetMany(values); err != nil {
+		log.Error("Failed to set state: %v", err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// stateIncr atomically adds delta (default 1) to a persisted number; a
+// missing key counts as 0 and a TTL is kept
+// Usage: local visits = state.incr("doorbell.count")
+// Usage: state.incr("power.total_wh", -12.5)
+// Returns: the new value, or nil + error if the key holds something else
+func (e *Executor) stateIncr(L *lua.LState) int {
+	key := L.CheckString(1)
+	delta := float64(L.OptNumber(2, 1))
+	n, err := e.storage.Incr(key, delta)
+	if err != nil {
+		log.Error("Failed to increment state %s: %v", key, err)
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LNumber(n))
+	return 1
+}
+
+// stateCAS atomically replaces a persisted value only if it still holds old
+// (nil for a missing key); a nil new value deletes the key
+// Usage: if state.cas("alarm.mode", "armed", "triggered") then siren() end
+// Usage: local ok = state.cas("lock.garage", nil, event.correlation_id)
+// Returns: true if swapped, otherwise false + the current value
+func (e *Executor) stateCAS(L *lua.LState) int {
+	key := L.CheckString(1)
+	var old, value interface{}
+	if L.Get(2) != lua.LNil {
+		old = e.fromLuaValue(L.Get(2))
+	}
+	if L.Get(3) != lua.LNil {
+		value = e.fromLuaValue(L.Get(3))
+	}
+	swapped, current, err := e.storage.CompareAndSwap(key, old, value)
+	if err != nil {
+		log.Error("Failed to swap state %s: %v", key, err)
+	}
+	if swapped {
+		L.Push(lua.LTrue)
+		return 1
+	}
+	L.Push(lua.LFalse)
+	L.Push(e.toLuaValue(L, current))
+	return 2
+}
+
+// stateFlush commits state writes batched by storage.batch_window now,
+// e.g. before a script triggers something that reads the database file
+// Usage: state.flush()
+// Returns: true on success, false + error otherwise
+func (e *Executor) stateFlush(L *lua.LState) int {
+	if err := e.storage.Flush(); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// eventHistory returns recent values of a device attribute, oldest first
+// Usage: local readings = event.history("kitchen_sensor", "temperature", 3)
+// Each entry is {value = ..., timestamp = <unix seconds>}
+func (e *Executor) eventHistory(L *lua.LState) int {
+	device := L.CheckString(1)
+	attr := L.CheckString(2)
+	n := L.OptInt(3, 0)
+
+	table := L.NewTable()
+	if e.history == nil {
+		L.Push(table)
+		return 1
+	}
+
+	for i, entry := range e.history.Recent(device, attr, n) {
+		item := L.NewTable()
+		item.RawSetString("value", e.toLuaValue(L, entry.Value))
+		item.RawSetString("timestamp", lua.LNumber(entry.Timestamp.Unix()))
+		table.RawSetInt(i+1, item)
+	}
+
+	L.Push(table)
+	return 1
+}
+
+// deviceGet returns the last known state of a device
+// Usage: local porch = device.get("porch")
+// Returns: table of attributes ({state = "ON", brightness = 200, ...}), or nil for unknown devices
 func (e *Executor) deviceGet(L *lua.LState) int {
 	id := L.CheckString(1)
 	attrs, err := e.deviceManager.Get(id)
 	if err != nil {
-		logger.Error("Failed to get device %s: %v", id, err)
+		log.Error("Failed to get device %s: %v", id, err)
 		L.Push(lua.LNil)
 		return 1
 	}
@@ -414,6 +828,16 @@ func (e *Executor) deviceGet(L *lua.LState) int {
 	return 1
 }
 
+// deviceSet sends attributes to a device. The optional options table
+// overrides the device's optimistic/confirm settings from devices.yaml:
+// optimistic updates device.get right away, confirm waits that many seconds
+// for the device to report the values (resending up to retries times, then
+// routing a command_failed event); qos and retain override the device's MQTT
+// settings for this command.
+// Usage: device.set("porch", {state = "ON", brightness = 200})
+// Usage: device.set("porch", {state = "ON"}, {optimistic = true, confirm = 5, retries = 2})
+// Usage: device.set("garage_door", {state = "CLOSE"}, {qos = 1})
+// Returns: API v2: true, or false + error message; API v1: nothing
 func (e *Executor) deviceSet(L *lua.LState) int {
 	id := L.CheckString(1)
 	attrsTable := L.CheckTable(2)
@@ -425,12 +849,61 @@ func (e *Executor) deviceSet(L *lua.LState) int {
 		}
 	})
 
-	if err := e.deviceManager.Set(id, attrs); err != nil {
-		logger.Error("Failed to set device %s: %v", id, err)
+	log.Debug("[%s] device.set %s %v", correlationOf(L), id, attrs)
+	optsTable, _ := L.Get(3).(*lua.LTable)
+	opts := e.setOptions(id, optsTable)
+	opts.Origin = e.scriptOrigin(L)
+	opts.CorrelationID = correlationOf(L)
+	err := e.deviceManager.SetWithOptions(id, attrs, opts)
+	if err != nil {
+		log.Error("[%s] Failed to set device %s: %v", correlationOf(L), id, err)
+	}
+
+	// API v1 returned nothing
+	if apiVersionOf(L) < APIVersion2 {
+		return 0
 	}
-	return 0
+	if err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
 }
 
+// setOptions starts from the device's configured set options and applies
+// those given in a device.set options table (none if table is nil)
+func (e *Executor) setOptions(id string, table *lua.LTable) devices.SetOptions {
+	var opts devices.SetOptions
+	if dev, ok := e.deviceManager.GetDevice(id); ok {
+		opts = devices.DefaultSetOptions(dev)
+	}
+	if table == nil {
+		return opts
+	}
+	if v, ok := table.RawGetString("optimistic").(lua.LBool); ok {
+		opts.Optimistic = bool(v)
+	}
+	if v, ok := table.RawGetString("confirm").(lua.LNumber); ok {
+		opts.ConfirmTimeout = time.Duration(float64(v) * float64(time.Second))
+	}
+	if v, ok := table.RawGetString("retries").(lua.LNumber); ok {
+		opts.Retries = int(v)
+	}
+	if v, ok := table.RawGetString("qos").(lua.LNumber); ok {
+		opts.QoS = clampQoS(v)
+	}
+	if v, ok := table.RawGetString("retain").(lua.LBool); ok {
+		opts.Retain = bool(v)
+	}
+	return opts
+}
+
+// deviceCall runs the action script events/device/<id>/actions/<action>.lua
+// Usage: device.call("porch", "toggle", {duration = 30})
+// Returns: true on success, false otherwise
 func (e *Executor) deviceCall(L *lua.LState) int {
 	id := L.CheckString(1)
 	action := L.CheckString(2)
@@ -445,58 +918,193 @@ func (e *Executor) deviceCall(L *lua.LState) int {
 		})
 	}
 
-	// Execute action script directly: config/events/device/{id}/actions/{action}.lua
-	scriptPath := filepath.Join(e.configPath, "events", "device", id, "actions", action+".lua")
-
-	// Check if script exists
-	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-		logger.Error("Action script not found: %s (device: %s, action: %s)", scriptPath, id, action)
+	if err := e.callAction(id, action, params, correlationOf(L)); err != nil {
+		log.Error("[%s] %v", correlationOf(L), err)
 		L.Push(lua.LFalse)
 		return 1
 	}
 
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// deviceList returns configured devices, optionally filtered by area and/or type
+// Usage: device.list() or device.list({area = "kitchen", type = "light"})
+// Returns: array of {id, name, type, area}
+func (e *Executor) deviceList(L *lua.LState) int {
+	var area, devType string
+	if filter, ok := L.Get(1).(*lua.LTable); ok {
+		area = lua.LVAsString(filter.RawGetString("area"))
+		devType = lua.LVAsString(filter.RawGetString("type"))
+	}
+
+	devices := e.deviceManager.ListDevices()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+	result := L.NewTable()
+	for _, dev := range devices {
+		if area != "" && dev.Area != area {
+			continue
+		}
+		if devType != "" && dev.Type != devType {
+			continue
+		}
+
+		item := L.NewTable()
+		item.RawSetString("id", lua.LString(dev.ID))
+		item.RawSetString("name", lua.LString(dev.Name))
+		item.RawSetString("type", lua.LString(dev.Type))
+		if dev.Area != "" {
+			item.RawSetString("area", lua.LString(dev.Area))
+		}
+		result.Append(item)
+	}
+
+	L.Push(result)
+	return 1
+}
+
+// deviceIsOnline reports device availability
+// Usage: if device.is_online("porch") == false then ... end
+// Returns: true/false, or nil if the device never reported availability
+func (e *Executor) deviceIsOnline(L *lua.LState) int {
+	id := L.CheckString(1)
+	online, known := e.deviceManager.IsOnline(id)
+	if !known {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LBool(online))
+	return 1
+}
+
+// deviceLastSeen returns when a device last sent a state message
+// Usage: local t = device.last_seen("porch"); if t and os.time() - t > 3600 then ... end
+// Returns: unix timestamp in seconds, or nil if nothing arrived since the server started
+func (e *Executor) deviceLastSeen(L *lua.LState) int {
+	id := L.CheckString(1)
+	t, ok := e.deviceManager.LastSeen(id)
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LNumber(t.Unix()))
+	return 1
+}
+
+// groupSet sets attributes on all members of a device group
+// Usage: group.set("downstairs_lights", {state = "OFF"})
+// Returns: true on success, false + error otherwise
+func (e *Executor) groupSet(L *lua.LState) int {
+	name := L.CheckString(1)
+	attrsTable := L.CheckTable(2)
+
+	attrs := make(map[string]interface{})
+	attrsTable.ForEach(func(key, value lua.LValue) {
+		if keyStr, ok := key.(lua.LString); ok {
+			attrs[string(keyStr)] = e.fromLuaValue(value)
+		}
+	})
+
+	log.Debug("[%s] group.set %s %v", correlationOf(L), name, attrs)
+	if err := e.deviceManager.SetGroup(name, attrs); err != nil {
+		log.Error("[%s] Failed to set group %s: %v", correlationOf(L), name, err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// CallAction runs a device action script: config/events/device/{id}/actions/{action}.lua
+func (e *Executor) CallAction(id, action string, params map[string]interface{}) error {
+	return e.callAction(id, action, params, "")
+}
+
+// findActionScript locates events/device/<id>/actions/<action>.lua, also
+// looking under the device's current ID and aliases in case it was renamed
+func (e *Executor) findActionScript(id, action string) (string, error) {
+	ids := []string{id}
+	if dev, ok := e.deviceManager.GetDevice(id); ok {
+		ids = append(ids, dev.ID)
+		ids = append(ids, dev.Aliases...)
+	}
+
+	for _, candidate := range ids {
+		scriptPath := filepath.Join(e.configPath, "events", "device", candidate, "actions", action+".lua")
+		if _, err := os.Stat(scriptPath); err == nil {
+			return scriptPath, nil
+		}
+	}
+
+	scriptPath := filepath.Join(e.configPath, "events", "device", id, "actions", action+".lua")
+	return "", fmt.Errorf("action script not found: %s (device: %s, action: %s)", scriptPath, id, action)
+}
+
+// callAction runs an action script as part of an existing correlation chain (new one if empty)
+func (e *Executor) callAction(id, action string, params map[string]interface{}, correlationID string) error {
+	if correlationID == "" {
+		correlationID = types.NewCorrelationID()
+	}
+
+	scriptPath, err := e.findActionScript(id, action)
+	if err != nil {
+		return err
+	}
+
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
 	// Create action event
 	event := &types.Event{
-		Source:    "action",
-		Type:      "call",
-		Device:    id,
-		Attribute: action,
-		Data:      params,
-		Timestamp: time.Now(),
+		Source:        "action",
+		Type:          "call",
+		Device:        id,
+		Attribute:     action,
+		Data:          params,
+		Timestamp:     time.Now(),
+		CorrelationID: correlationID,
 	}
 
 	// Execute action script in new Lua state
 	if err := e.Execute(scriptPath, event); err != nil {
-		logger.Error("Failed to execute action %s on %s: %v", action, id, err)
-		L.Push(lua.LFalse)
-		return 1
+		return fmt.Errorf("failed to execute action %s on %s: %w", action, id, err)
 	}
 
-	L.Push(lua.LTrue)
-	return 1
+	return nil
 }
 
-// Log functions
+// logInfo writes an info line to the server log
+// Usage: log.info("Temperature:", event.data.temperature)
+// API v1 accepts a single string; API v2 joins any values with spaces
 func (e *Executor) logInfo(L *lua.LState) int {
-	msg := L.CheckString(1)
-	logger.Info("[LUA] %s", msg)
+	msg := logMessage(L)
+	luaLog.Info("%s%s", correlationPrefix(L), msg)
 	return 0
 }
 
+// logWarn writes a warning to the server log
+// Usage: log.warn("Battery low on", event.device)
 func (e *Executor) logWarn(L *lua.LState) int {
-	msg := L.CheckString(1)
-	logger.Warn("[LUA] %s", msg)
+	msg := logMessage(L)
+	luaLog.Warn("%s%s", correlationPrefix(L), msg)
 	return 0
 }
 
+// logError writes an error to the server log
+// Usage: log.error("Failed to reach the doorbell")
 func (e *Executor) logError(L *lua.LState) int {
-	msg := L.CheckString(1)
-	logger.Error("[LUA] %s", msg)
+	msg := logMessage(L)
+	luaLog.Error("%s%s", correlationPrefix(L), msg)
 	return 0
 }
 
-// UDP send function
-// UdpSend(message, [host], [port])
+// udpSend sends a datagram (text or binary), e.g. a StatsD metric
+// Usage: udp.send("temp:21.5|g", "127.0.0.1", 8125)
 // message — required: Lua string (can be text or binary, with \0 etc.)
 // host    — optional, string, default "127.0.0.1"
 // port    — optional, number, default 8125
@@ -653,11 +1261,21 @@ func (e *Executor) fromLuaValue(value lua.LValue) interface{} {
 
 // Timer functions
 
+// now returns the scheduler's time, which runs ahead of the wall clock when
+// time is simulated
+func (e *Executor) now() time.Time {
+	if clock, ok := e.scheduler.(interface{ Now() time.Time }); ok {
+		return clock.Now()
+	}
+	return time.Now()
+}
+
 // timerAfter schedules a timer to run after specified duration
 // Usage: timer.after(60, callback) or timer.after(60, "timer_id", callback)
+// Returns: timer ID, or nil if the scheduler is unavailable
 func (e *Executor) timerAfter(L *lua.LState) int {
 	if e.scheduler == nil {
-		logger.Warn("[LUA] Scheduler not available for timer.after")
+		luaLog.Warn("Scheduler not available for timer.after")
 		L.Push(lua.LNil)
 		return 1
 	}
@@ -684,7 +1302,7 @@ func (e *Executor) timerAfter(L *lua.LState) int {
 	}
 
 	if sched, ok := e.scheduler.(schedulerInterface); ok {
-		triggerTime := time.Now().Add(time.Duration(seconds) * time.Second)
+		triggerTime := e.now().Add(time.Duration(seconds) * time.Second)
 
 		// Increment timer counter for this state
 		timerCount := L.GetGlobal("__timer_count__")
@@ -698,12 +1316,12 @@ func (e *Executor) timerAfter(L *lua.LState) int {
 		L.SetGlobal("__timer_count__", lua.LNumber(count))
 		L.SetGlobal("__timers_created__", lua.LTrue)
 
-		logger.Debug("Lua state %p now has %d active timer(s)", L, count)
+		log.Debug("Lua state %p now has %d active timer(s)", L, count)
 
 		sched.AddTimerCallback(timerID, triggerTime, callback, L)
 		L.Push(lua.LString(timerID))
 	} else {
-		logger.Error("[LUA] Scheduler type assertion failed")
+		luaLog.Error("Scheduler type assertion failed")
 		L.Push(lua.LNil)
 	}
 
@@ -712,9 +1330,10 @@ func (e *Executor) timerAfter(L *lua.LState) int {
 
 // timerAt schedules a timer at specific time (HH:MM format)
 // Usage: timer.at("17:30", callback) or timer.at("17:30", "timer_id", callback)
+// Returns: timer ID, or nil if the time is invalid
 func (e *Executor) timerAt(L *lua.LState) int {
 	if e.scheduler == nil {
-		logger.Warn("[LUA] Scheduler not available for timer.at")
+		luaLog.Warn("Scheduler not available for timer.at")
 		L.Push(lua.LNil)
 		return 1
 	}
@@ -738,12 +1357,12 @@ func (e *Executor) timerAt(L *lua.LState) int {
 	// Parse HH:MM format
 	var hour, minute int
 	if _, err := fmt.Sscanf(timeStr, "%d:%d", &hour, &minute); err != nil {
-		logger.Error("[LUA] Invalid time format: %s (expected HH:MM)", timeStr)
+		luaLog.Error("Invalid time format: %s (expected HH:MM)", timeStr)
 		L.Push(lua.LNil)
 		return 1
 	}
 
-	now := time.Now()
+	now := e.now()
 	triggerTime := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
 
 	// If time has passed today, schedule for tomorrow
@@ -768,12 +1387,12 @@ func (e *Executor) timerAt(L *lua.LState) int {
 		L.SetGlobal("__timer_count__", lua.LNumber(count))
 		L.SetGlobal("__timers_created__", lua.LTrue)
 
-		logger.Debug("Lua state %p now has %d active timer(s)", L, count)
+		log.Debug("Lua state %p now has %d active timer(s)", L, count)
 
 		sched.AddTimerCallback(timerID, triggerTime, callback, L)
 		L.Push(lua.LString(timerID))
 	} else {
-		logger.Error("[LUA] Scheduler type assertion failed")
+		luaLog.Error("Scheduler type assertion failed")
 		L.Push(lua.LNil)
 	}
 
@@ -782,9 +1401,10 @@ func (e *Executor) timerAt(L *lua.LState) int {
 
 // timerEvery creates a recurring timer
 // Usage: timer.every(300, callback) or timer.every(300, "timer_id", callback)
+// Returns: timer ID, or nil if the scheduler is unavailable
 func (e *Executor) timerEvery(L *lua.LState) int {
 	if e.scheduler == nil {
-		logger.Warn("[LUA] Scheduler not available for timer.every")
+		luaLog.Warn("Scheduler not available for timer.every")
 		L.Push(lua.LNil)
 		return 1
 	}
@@ -824,12 +1444,12 @@ func (e *Executor) timerEvery(L *lua.LState) int {
 		L.SetGlobal("__timer_count__", lua.LNumber(count))
 		L.SetGlobal("__timers_created__", lua.LTrue)
 
-		logger.Debug("Lua state %p now has %d active timer(s)", L, count)
+		log.Debug("Lua state %p now has %d active timer(s)", L, count)
 
 		sched.AddRecurringTimerCallback(timerID, interval, callback, L)
 		L.Push(lua.LString(timerID))
 	} else {
-		logger.Error("[LUA] Scheduler type assertion failed")
+		luaLog.Error("Scheduler type assertion failed")
 		L.Push(lua.LNil)
 	}
 
@@ -838,9 +1458,10 @@ func (e *Executor) timerEvery(L *lua.LState) int {
 
 // timerCancel cancels a timer
 // Usage: timer.cancel("timer_id")
+// Returns: true if the timer existed
 func (e *Executor) timerCancel(L *lua.LState) int {
 	if e.scheduler == nil {
-		logger.Warn("[LUA] Scheduler not available for timer.cancel")
+		luaLog.Warn("Scheduler not available for timer.cancel")
 		L.Push(lua.LFalse)
 		return 1
 	}
@@ -855,7 +1476,7 @@ func (e *Executor) timerCancel(L *lua.LState) int {
 		result := sched.RemoveTimer(timerID)
 		L.Push(lua.LBool(result))
 	} else {
-		logger.Error("[LUA] Scheduler type assertion failed")
+		luaLog.Error("Scheduler type assertion failed")
 		L.Push(lua.LFalse)
 	}
 
@@ -864,9 +1485,10 @@ func (e *Executor) timerCancel(L *lua.LState) int {
 
 // timerList returns list of active timers
 // Usage: local timers = timer.list()
+// Returns: array of timer IDs
 func (e *Executor) timerList(L *lua.LState) int {
 	if e.scheduler == nil {
-		logger.Warn("[LUA] Scheduler not available for timer.list")
+		luaLog.Warn("Scheduler not available for timer.list")
 		L.Push(L.NewTable())
 		return 1
 	}
@@ -883,7 +1505,7 @@ func (e *Executor) timerList(L *lua.LState) int {
 		}
 		L.Push(table)
 	} else {
-		logger.Error("[LUA] Scheduler type assertion failed")
+		luaLog.Error("Scheduler type assertion failed")
 		L.Push(L.NewTable())
 	}
 
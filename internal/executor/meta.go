@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scriptMeta holds per-script annotations declared in the leading comment block.
+//
+// Annotations use the form "-- @key value", e.g.:
+//
+//	-- @api_version 2
+//	-- @budget 200ms
+type scriptMeta struct {
+	APIVersion int           // scripting API version the script targets
+	Budget     time.Duration // expected execution budget (0 = use executor default)
+}
+
+type cachedMeta struct {
+	modTime time.Time
+	meta    scriptMeta
+}
+
+// metaCache caches parsed annotations keyed by script path, invalidated by mtime
+type metaCache struct {
+	entries map[string]cachedMeta
+	mu      sync.Mutex
+}
+
+func newMetaCache() *metaCache {
+	return &metaCache{entries: make(map[string]cachedMeta)}
+}
+
+// get returns annotations for a script, re-parsing only when the file changed
+func (c *metaCache) get(path string) scriptMeta {
+	info, err := os.Stat(path)
+	if err != nil {
+		return scriptMeta{APIVersion: APIVersion1}
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.meta
+	}
+
+	meta := parseScriptMeta(path)
+
+	// Deprecations are reported once per script version
+	warnDeprecations(path)
+
+	c.mu.Lock()
+	c.entries[path] = cachedMeta{modTime: info.ModTime(), meta: meta}
+	c.mu.Unlock()
+
+	return meta
+}
+
+// parseScriptMeta reads "-- @key value" annotations from the leading comment block
+func parseScriptMeta(path string) scriptMeta {
+	meta := scriptMeta{APIVersion: APIVersion1}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return meta
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// Annotations are only honored in the leading comment block
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		body := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if !strings.HasPrefix(body, "@") {
+			continue
+		}
+
+		key, value, _ := strings.Cut(strings.TrimPrefix(body, "@"), " ")
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "api_version":
+			version, err := strconv.Atoi(value)
+			if err != nil || version < APIVersion1 {
+				log.Warn("Invalid @api_version %q in %s, using %d", value, path, APIVersion1)
+				continue
+			}
+			if version > CurrentAPIVersion {
+				log.Warn("%s targets API version %d, server supports up to %d", path, version, CurrentAPIVersion)
+				version = CurrentAPIVersion
+			}
+			meta.APIVersion = version
+		case "budget":
+			if d, err := parseBudget(value); err == nil {
+				meta.Budget = d
+			}
+		}
+	}
+
+	return meta
+}
+
+// parseBudget accepts Go durations ("200ms", "1.5s") or bare milliseconds ("200")
+func parseBudget(value string) (time.Duration, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+	ms, err := time.ParseDuration(value + "ms")
+	if err != nil {
+		return 0, err
+	}
+	return ms, nil
+}
@@ -0,0 +1,72 @@
+package executor
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Publisher publishes raw MQTT messages (implemented by mqtt.Client)
+type Publisher interface {
+	Publish(topic string, payload interface{}, qos byte, retain bool) error
+}
+
+// SetPublisher sets the MQTT connection used by mqtt.publish
+func (e *Executor) SetPublisher(p Publisher) {
+	e.publisher = p
+}
+
+func (e *Executor) registerMQTTAPI(L *lua.LState) {
+	mqttTable := L.NewTable()
+	L.SetField(mqttTable, "publish", L.NewFunction(e.mqttPublish))
+	L.SetGlobal("mqtt", mqttTable)
+}
+
+// mqttPublish publishes a message; a table payload is sent as JSON.
+// The options table sets qos (0-2, default 0) and retain (default false).
+// Usage: mqtt.publish("home/alarm", "armed")
+// Usage: mqtt.publish("home/alarm/state", {armed = true}, {qos = 1, retain = true})
+// Returns: true on success, false + error otherwise
+func (e *Executor) mqttPublish(L *lua.LState) int {
+	topic := L.CheckString(1)
+	payload := e.fromLuaValue(L.CheckAny(2))
+
+	var qos byte
+	var retain bool
+	if opts, ok := L.Get(3).(*lua.LTable); ok {
+		if v, ok := opts.RawGetString("qos").(lua.LNumber); ok {
+			qos = clampQoS(v)
+		}
+		if v, ok := opts.RawGetString("retain").(lua.LBool); ok {
+			retain = bool(v)
+		}
+	}
+
+	if e.publisher == nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("MQTT not available"))
+		return 2
+	}
+
+	log.Debug("[%s] mqtt.publish %s (qos %d, retain %v)", correlationOf(L), topic, qos, retain)
+	if err := e.publisher.Publish(topic, payload, qos, retain); err != nil {
+		log.Error("[%s] Failed to publish to %s: %v", correlationOf(L), topic, err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// clampQoS converts a Lua number to a valid MQTT QoS level
+func clampQoS(v lua.LNumber) byte {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 2:
+		return 2
+	default:
+		return byte(v)
+	}
+}
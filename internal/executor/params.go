@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParamsFile is the optional file next to handlers whose values scripts
+// read from the params table, so one script (e.g. symlinked into several
+// device directories) can run with different thresholds and targets
+const ParamsFile = "params.yaml"
+
+type cachedParams struct {
+	modTime time.Time
+	params  map[string]interface{}
+	err     error
+}
+
+// paramsCache caches parsed params.yaml files keyed by directory, invalidated by mtime
+type paramsCache struct {
+	entries map[string]cachedParams
+	mu      sync.Mutex
+}
+
+func newParamsCache() *paramsCache {
+	return &paramsCache{entries: make(map[string]cachedParams)}
+}
+
+// ParseParams parses the contents of a params.yaml file, a mapping of names
+// to values
+func ParseParams(data []byte) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	if params == nil {
+		// An empty file
+		params = make(map[string]interface{})
+	}
+	return params, nil
+}
+
+// get returns the params of a handler directory (empty if it has no
+// params.yaml), re-parsing only when the file changed. The directory isn't
+// resolved, so a symlinked script gets the params next to the link.
+func (c *paramsCache) get(dir string) (map[string]interface{}, error) {
+	path := filepath.Join(dir, ParamsFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		return map[string]interface{}{}, nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[dir]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.params, entry.err
+	}
+
+	var params map[string]interface{}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		params, err = ParseParams(data)
+	}
+	if err != nil {
+		// Fail closed: scripts must not run with their thresholds missing
+		err = fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	c.mu.Lock()
+	c.entries[dir] = cachedParams{modTime: info.ModTime(), params: params, err: err}
+	c.mu.Unlock()
+
+	return params, err
+}
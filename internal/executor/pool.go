@@ -1,22 +1,40 @@
 package executor
 
 import (
+	"fmt"
 	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
 	"sync"
 )
 
+var poolLog = logger.Module("pool")
+
+// Priority determines which queue a task is placed in
+type Priority int
+
+const (
+	// PriorityNormal is used for regular sensor updates and time events
+	PriorityNormal Priority = iota
+	// PriorityHigh is used for security-relevant events (motion, contact, alarms)
+	PriorityHigh
+)
+
 // Task represents a script execution task
 type Task struct {
 	ScriptPath string
 	Event      *types.Event
+	Priority   Priority
 }
 
-// Pool manages a pool of workers for executing Lua scripts
+// Pool manages a pool of workers for executing Lua scripts.
+// High-priority tasks have their own queue, preferred by all workers and
+// additionally served by reserved fast-lane workers that never run normal tasks.
 type Pool struct {
 	executor  *Executor
 	workers   int
+	reserved  int
 	taskQueue chan Task
+	highQueue chan Task
 	wg        sync.WaitGroup
 	stopOnce  sync.Once
 	stopChan  chan struct{}
@@ -30,36 +48,52 @@ func NewPool(executor *Executor, workers int, queueSize int) *Pool {
 		executor:  executor,
 		workers:   workers,
 		taskQueue: make(chan Task, queueSize),
+		highQueue: make(chan Task, queueSize),
 		stopChan:  make(chan struct{}),
 	}
 }
 
+// SetReservedWorkers sets the number of extra fast-lane workers dedicated to
+// high-priority tasks (must be called before Start)
+func (p *Pool) SetReservedWorkers(n int) {
+	p.reserved = n
+}
+
 // Start begins processing tasks
 func (p *Pool) Start() {
 	for i := 0; i < p.workers; i++ {
 		p.wg.Add(1)
 		go p.worker(i)
 	}
-	logger.Debug("Started %d workers", p.workers)
+	for i := 0; i < p.reserved; i++ {
+		p.wg.Add(1)
+		go p.fastWorker(p.workers + i)
+	}
+	poolLog.Debug("Started %d workers (+%d fast-lane)", p.workers, p.reserved)
 }
 
-// Submit adds a task to the queue
+// Submit adds a task to the queue matching its priority
 func (p *Pool) Submit(task Task) {
 	p.mu.RLock()
 	stopped := p.stopped
 	p.mu.RUnlock()
 	if stopped {
-		logger.Warn("Pool is stopped, task rejected")
+		poolLog.Warn("Pool is stopped, task rejected")
 		return
 	}
 
+	queue := p.taskQueue
+	if task.Priority == PriorityHigh {
+		queue = p.highQueue
+	}
+
 	select {
-	case p.taskQueue <- task:
+	case queue <- task:
 		// Task queued successfully
 	case <-p.stopChan:
-		logger.Warn("Pool is stopped, task rejected")
+		poolLog.Warn("Pool is stopped, task rejected")
 	default:
-		logger.Warn("Task queue full, dropping task for script: %s", task.ScriptPath)
+		poolLog.Warn("Task queue full, dropping task for script: %s", task.ScriptPath)
 	}
 }
 
@@ -72,29 +106,63 @@ func (p *Pool) Stop() {
 		p.mu.Unlock()
 
 		p.wg.Wait()
-		logger.Debug("Worker pool stopped")
+		poolLog.Debug("Worker pool stopped")
 	})
 }
 
 func (p *Pool) worker(id int) {
 	defer p.wg.Done()
 
+	wlog := logger.Module(fmt.Sprintf("worker-%d", id))
+
 	for {
+		// Drain high-priority tasks first
 		select {
+		case task := <-p.highQueue:
+			p.run(wlog, task)
+			continue
+		default:
+		}
+
+		select {
+		case task := <-p.highQueue:
+			p.run(wlog, task)
+
 		case task, ok := <-p.taskQueue:
 			if !ok {
-				logger.Debug("Worker %d: task queue closed", id)
+				wlog.Debug("Task queue closed")
 				return
 			}
+			p.run(wlog, task)
 
-			logger.Debug("Worker %d: executing %s", id, task.ScriptPath)
-			if err := p.executor.Execute(task.ScriptPath, task.Event); err != nil {
-				logger.Error("Worker %d: script error in %s: %v", id, task.ScriptPath, err)
-			}
+		case <-p.stopChan:
+			wlog.Debug("Stopping")
+			return
+		}
+	}
+}
+
+// fastWorker only serves high-priority tasks
+func (p *Pool) fastWorker(id int) {
+	defer p.wg.Done()
+
+	wlog := logger.Module(fmt.Sprintf("worker-%d", id))
+
+	for {
+		select {
+		case task := <-p.highQueue:
+			p.run(wlog, task)
 
 		case <-p.stopChan:
-			logger.Debug("Worker %d: stopping", id)
+			wlog.Debug("Stopping")
 			return
 		}
 	}
 }
+
+func (p *Pool) run(wlog *logger.ModuleLogger, task Task) {
+	wlog.Debug("[%s] Executing %s", task.Event.CorrelationID, task.ScriptPath)
+	if err := p.executor.Execute(task.ScriptPath, task.Event); err != nil {
+		wlog.Error("[%s] Script error in %s: %v", task.Event.CorrelationID, task.ScriptPath, err)
+	}
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"math/rand"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// seededRandom is a shared random source for math.random, so runs seeded
+// alike make the same choices
+type seededRandom struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// SetRandomSeed makes math.random in every script draw from one source
+// seeded with seed, for reproducible test runs; math.randomseed reseeds it
+func (e *Executor) SetRandomSeed(seed int64) {
+	e.random = &seededRandom{rng: rand.New(rand.NewSource(seed))}
+}
+
+// registerSeededRandom replaces math.random and math.randomseed with the seeded source
+func (e *Executor) registerSeededRandom(L *lua.LState) {
+	mathTable, ok := L.GetGlobal("math").(*lua.LTable)
+	if !ok || e.random == nil {
+		return
+	}
+	L.SetField(mathTable, "random", L.NewFunction(e.random.luaRandom))
+	L.SetField(mathTable, "randomseed", L.NewFunction(e.random.luaRandomSeed))
+}
+
+// luaRandom follows Lua's math.random: a float in [0,1) without arguments,
+// an integer in [1,m] or [m,n] otherwise
+func (r *seededRandom) luaRandom(L *lua.LState) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch L.GetTop() {
+	case 0:
+		L.Push(lua.LNumber(r.rng.Float64()))
+	case 1:
+		n := L.CheckInt(1)
+		if n < 1 {
+			L.ArgError(1, "interval is empty")
+		}
+		L.Push(lua.LNumber(r.rng.Intn(n) + 1))
+	default:
+		low, high := L.CheckInt(1), L.CheckInt(2)
+		if low > high {
+			L.ArgError(2, "interval is empty")
+		}
+		L.Push(lua.LNumber(r.rng.Intn(high-low+1) + low))
+	}
+	return 1
+}
+
+func (r *seededRandom) luaRandomSeed(L *lua.LState) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rng.Seed(L.CheckInt64(1))
+	return 0
+}
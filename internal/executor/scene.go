@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"homescript-server/internal/scenes"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SceneEngine activates, captures and restores scenes (implemented by scenes.Engine)
+type SceneEngine interface {
+	Activate(name string) error
+	Capture(name string, deviceIDs []string) (*scenes.Scene, error)
+	Restore(name string) error
+	List() []string
+}
+
+// SetScenes sets the scene engine exposed as the scene Lua table
+func (e *Executor) SetScenes(engine SceneEngine) {
+	e.scenes = engine
+}
+
+func (e *Executor) registerSceneAPI(L *lua.LState) {
+	sceneTable := L.NewTable()
+	L.SetField(sceneTable, "activate", L.NewFunction(e.sceneActivate))
+	L.SetField(sceneTable, "capture", L.NewFunction(e.sceneCapture))
+	L.SetField(sceneTable, "restore", L.NewFunction(e.sceneRestore))
+	L.SetField(sceneTable, "list", L.NewFunction(e.sceneList))
+	L.SetGlobal("scene", sceneTable)
+}
+
+// sceneActivate applies a scene from config/scenes/<name>.yaml
+// Usage: scene.activate("movie_night")
+// Returns: true on success, false + error otherwise
+func (e *Executor) sceneActivate(L *lua.LState) int {
+	name := L.CheckString(1)
+
+	if e.scenes == nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("scenes not available"))
+		return 2
+	}
+
+	log.Debug("[%s] scene.activate %s", correlationOf(L), name)
+	if err := e.scenes.Activate(name); err != nil {
+		log.Error("[%s] Failed to activate scene %s: %v", correlationOf(L), name, err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// sceneCapture saves the current state of devices as a new scene
+// Usage: scene.capture("evening", {"living_room_lamp", "tv_backlight"})
+// Returns: true on success, false + error otherwise
+func (e *Executor) sceneCapture(L *lua.LState) int {
+	name := L.CheckString(1)
+	devicesTable := L.CheckTable(2)
+
+	if e.scenes == nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("scenes not available"))
+		return 2
+	}
+
+	var ids []string
+	devicesTable.ForEach(func(_, value lua.LValue) {
+		if id, ok := value.(lua.LString); ok {
+			ids = append(ids, string(id))
+		}
+	})
+
+	if _, err := e.scenes.Capture(name, ids); err != nil {
+		log.Error("[%s] Failed to capture scene %s: %v", correlationOf(L), name, err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// sceneRestore puts devices back into a scene saved by scene.capture; devices
+// that were off are only switched off
+// Usage: scene.restore("before_alert")
+// Returns: true on success, false + error otherwise
+func (e *Executor) sceneRestore(L *lua.LState) int {
+	name := L.CheckString(1)
+
+	if e.scenes == nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString("scenes not available"))
+		return 2
+	}
+
+	log.Debug("[%s] scene.restore %s", correlationOf(L), name)
+	if err := e.scenes.Restore(name); err != nil {
+		log.Error("[%s] Failed to restore scene %s: %v", correlationOf(L), name, err)
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	L.Push(lua.LNil)
+	return 2
+}
+
+// sceneList returns the names of all defined scenes
+// Usage: for _, name in ipairs(scene.list()) do ... end
+func (e *Executor) sceneList(L *lua.LState) int {
+	table := L.NewTable()
+	if e.scenes != nil {
+		for _, name := range e.scenes.List() {
+			table.Append(lua.LString(name))
+		}
+	}
+	L.Push(table)
+	return 1
+}
@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// stateScope returns the key prefix of the script at scriptPath, e.g.
+// "script:events/device/porch/state/on_change:" (relative to the config
+// directory, so it survives moving the config)
+func (e *Executor) stateScope(scriptPath string) string {
+	rel, err := filepath.Rel(e.configPath, scriptPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = scriptPath
+	}
+	return "script:" + strings.TrimSuffix(filepath.ToSlash(rel), ".lua") + ":"
+}
+
+// stateScoped returns the state API with keys private to the running
+// script, so automations can use short names without colliding; the
+// global state table stays available for shared data
+// Usage: local my = state.scoped(); my.set("last_run", os.time())
+// Returns: table with get, set, delete, set_ttl, ttl, dump, keys, get_all, mget, mset, incr and cas
+func (e *Executor) stateScoped(L *lua.LState) int {
+	path, ok := L.GetGlobal("SCRIPT_PATH").(lua.LString)
+	if !ok {
+		L.RaiseError("state.scoped: no script is running")
+		return 0
+	}
+	scope := e.stateScope(string(path))
+
+	table := L.NewTable()
+	for name, fn := range map[string]lua.LGFunction{
+		"get":     e.stateGet,
+		"set":     e.stateSet,
+		"delete":  e.stateDelete,
+		"set_ttl": e.stateSetTTL,
+		"ttl":     e.stateTTL,
+		"incr":    e.stateIncr,
+		"cas":     e.stateCAS,
+	} {
+		L.SetField(table, name, L.NewFunction(scopedKey(scope, fn)))
+	}
+	for name, fn := range map[string]lua.LGFunction{
+		"dump":    e.stateDump,
+		"keys":    e.stateKeys,
+		"get_all": e.stateGetAll,
+	} {
+		L.SetField(table, name, L.NewFunction(scopedPrefix(scope, fn)))
+	}
+	L.SetField(table, "mget", L.NewFunction(scopedTable(scope, e.stateMGet, false)))
+	L.SetField(table, "mset", L.NewFunction(scopedTable(scope, e.stateMSet, true)))
+	L.Push(table)
+	return 1
+}
+
+// scopedKey prefixes the key in the first argument of fn
+func scopedKey(scope string, fn lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		L.Replace(1, lua.LString(scope+L.CheckString(1)))
+		return fn(L)
+	}
+}
+
+// scopedPrefix prefixes the optional key prefix in the first argument of fn
+// and strips the scope from the keys of the table it returns
+func scopedPrefix(scope string, fn lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		prefix := lua.LString(scope + L.OptString(1, ""))
+		if L.GetTop() == 0 {
+			L.Push(prefix)
+		} else {
+			L.Replace(1, prefix)
+		}
+		n := fn(L)
+		if table, ok := L.Get(-n).(*lua.LTable); ok {
+			L.Replace(-n, unscoped(L, scope, table))
+		}
+		return n
+	}
+}
+
+// scopedTable prefixes the keys in the table of the first argument of fn
+// (its keys if byKey, otherwise its values) and strips the scope from the
+// keys of the table it returns
+func scopedTable(scope string, fn lua.LGFunction, byKey bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		scoped := L.NewTable()
+		L.CheckTable(1).ForEach(func(key, value lua.LValue) {
+			if byKey {
+				if s, ok := key.(lua.LString); ok {
+					scoped.RawSetString(scope+string(s), value)
+				}
+			} else if s, ok := value.(lua.LString); ok {
+				scoped.Append(lua.LString(scope + string(s)))
+			}
+		})
+		L.Replace(1, scoped)
+		n := fn(L)
+		if table, ok := L.Get(-n).(*lua.LTable); ok && !byKey {
+			L.Replace(-n, unscoped(L, scope, table))
+		}
+		return n
+	}
+}
+
+// unscoped strips scope from the keys of a key = value table, or from the
+// values of an array of keys
+func unscoped(L *lua.LState, scope string, table *lua.LTable) *lua.LTable {
+	result := L.NewTable()
+	if table.Len() > 0 {
+		table.ForEach(func(_, value lua.LValue) {
+			if s, ok := value.(lua.LString); ok {
+				result.Append(lua.LString(strings.TrimPrefix(string(s), scope)))
+			}
+		})
+		return result
+	}
+	table.ForEach(func(key, value lua.LValue) {
+		if s, ok := key.(lua.LString); ok {
+			result.RawSetString(strings.TrimPrefix(string(s), scope), value)
+		}
+	})
+	return result
+}
@@ -0,0 +1,494 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	// ServiceStartEventType is the system event a service script sees as
+	// `event` while it loads
+	ServiceStartEventType = "service_start"
+	// maxServiceBacklog bounds events waiting for a busy service
+	maxServiceBacklog = 100
+)
+
+// service is a long-lived script from config/services/ with its own Lua
+// state, kept open from startup until the server stops
+type service struct {
+	name    string
+	path    string
+	state   *lua.LState
+	tracker *luaStateTracker
+	exports *lua.LTable // table returned by the script, for service.call
+	mu      sync.Mutex
+	subs    []*serviceSub
+	nextSub int
+	stopped bool
+	backlog chan func()
+	done    chan struct{}
+}
+
+// serviceSub is a service.on subscription
+type serviceSub struct {
+	id      int
+	pattern []string
+	fn      *lua.LFunction
+}
+
+// SetServiceDispatcher replaces the goroutine each service handles its
+// events on, e.g. with the test harness queue so they run in a fixed order
+func (e *Executor) SetServiceDispatcher(dispatch func(run func())) {
+	e.serviceDispatch = dispatch
+}
+
+// StartServices loads every config/services/*.lua script into a state of
+// its own. A service that fails to load is logged and skipped. Returns the
+// number of services started.
+func (e *Executor) StartServices() int {
+	dir := filepath.Join(e.configPath, "services")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to read %s: %v", dir, err)
+		}
+		return 0
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".lua") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	started := 0
+	for _, name := range names {
+		svc, err := e.startService(filepath.Join(dir, name))
+		if err != nil {
+			log.Error("Service %s failed to start: %v", strings.TrimSuffix(name, ".lua"), err)
+			continue
+		}
+		e.servicesMu.Lock()
+		if e.services == nil {
+			e.services = make(map[string]*service)
+		}
+		e.services[svc.name] = svc
+		e.servicesMu.Unlock()
+		log.Info("Service %s started (%d subscription(s))", svc.name, len(svc.subs))
+		started++
+	}
+	return started
+}
+
+// startService runs the script of a service once, which registers its
+// subscriptions and returns the functions other scripts may call
+func (e *Executor) startService(path string) (*service, error) {
+	params, err := e.paramsCache.get(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	L := lua.NewState()
+	e.addStateReference(L)
+	e.trackersMutex.RLock()
+	tracker := e.stateTrackers[L]
+	e.trackersMutex.RUnlock()
+
+	svc := &service{
+		name:    strings.TrimSuffix(filepath.Base(path), ".lua"),
+		path:    path,
+		state:   L,
+		tracker: tracker,
+		backlog: make(chan func(), maxServiceBacklog),
+		done:    make(chan struct{}),
+	}
+
+	event := &types.Event{
+		Source:        "system",
+		Type:          ServiceStartEventType,
+		Data:          map[string]interface{}{"service": svc.name},
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	e.prepareState(L, path, event, params)
+	if table, ok := L.GetGlobal("service").(*lua.LTable); ok {
+		table.RawSetString("name", lua.LString(svc.name))
+	}
+
+	// The service's pending callback never completes, so its own timers
+	// finishing don't close the state
+	holdState(L)
+
+	// service.on looks the service up by state while the script loads
+	e.servicesMu.Lock()
+	if e.serviceStates == nil {
+		e.serviceStates = make(map[*lua.LState]*service)
+	}
+	e.serviceStates[L] = svc
+	e.servicesMu.Unlock()
+
+	tracker.executeMux.Lock()
+	top := L.GetTop()
+	err = L.DoFile(path)
+	if err == nil && L.GetTop() > top {
+		svc.exports, _ = L.Get(top + 1).(*lua.LTable)
+	}
+	L.SetTop(top)
+	tracker.executeMux.Unlock()
+
+	if err != nil {
+		e.servicesMu.Lock()
+		delete(e.serviceStates, L)
+		e.servicesMu.Unlock()
+		svc.stop()
+		if releaseHold(L) == 0 {
+			e.releaseStateReference(L)
+		}
+		return nil, err
+	}
+
+	if e.serviceDispatch == nil {
+		go svc.run()
+	}
+	return svc, nil
+}
+
+// StopServices drops the subscriptions of every service and closes its
+// state once its pending timers and jobs are done
+func (e *Executor) StopServices() {
+	e.servicesMu.Lock()
+	services := e.services
+	e.services = nil
+	e.servicesMu.Unlock()
+
+	for _, svc := range services {
+		svc.stop()
+		svc.tracker.executeMux.Lock()
+		remaining := releaseHold(svc.state)
+		svc.tracker.executeMux.Unlock()
+
+		e.servicesMu.Lock()
+		delete(e.serviceStates, svc.state)
+		e.servicesMu.Unlock()
+		if remaining == 0 {
+			e.releaseStateReference(svc.state)
+		}
+		log.Debug("Service %s stopped", svc.name)
+	}
+}
+
+// stop ends event delivery to the service
+func (s *service) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.subs = nil
+	close(s.done)
+}
+
+// run handles the service's events one at a time, in the order routed
+func (s *service) run() {
+	for {
+		select {
+		case fn := <-s.backlog:
+			fn()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Deliver passes a routed event to the services subscribed to it (called
+// by the router for every event it dispatches)
+func (e *Executor) Deliver(event *types.Event) {
+	route := eventRoute(event)
+	if len(route) == 0 {
+		return
+	}
+
+	e.servicesMu.RLock()
+	defer e.servicesMu.RUnlock()
+	for _, svc := range e.services {
+		var handlers []*lua.LFunction
+		svc.mu.Lock()
+		for _, sub := range svc.subs {
+			if matchRoute(sub.pattern, route) {
+				handlers = append(handlers, sub.fn)
+			}
+		}
+		svc.mu.Unlock()
+		if len(handlers) == 0 {
+			continue
+		}
+
+		svc := svc
+		run := func() { e.handleServiceEvent(svc, handlers, event) }
+		if e.serviceDispatch != nil {
+			e.serviceDispatch(run)
+			continue
+		}
+		select {
+		case svc.backlog <- run:
+		default:
+			log.Warn("[%s] Service %s is behind, dropping %s/%s", event.CorrelationID, svc.name, event.Source, event.Type)
+		}
+	}
+}
+
+// handleServiceEvent calls the matching service.on handlers with the event
+func (e *Executor) handleServiceEvent(svc *service, handlers []*lua.LFunction, event *types.Event) {
+	svc.tracker.executeMux.Lock()
+	defer svc.tracker.executeMux.Unlock()
+
+	svc.mu.Lock()
+	stopped := svc.stopped
+	svc.mu.Unlock()
+	if stopped {
+		return
+	}
+
+	L := svc.state
+	ctx, cancel := context.WithTimeout(context.Background(), e.scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	// event.emit from the handler continues the event's chain
+	setCorrelation(L, event.CorrelationID)
+	eventTable := e.setEventGlobal(L, event)
+
+	for _, fn := range handlers {
+		if err := L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, eventTable); err != nil {
+			log.Error("[%s] Service %s failed handling %s/%s: %v", event.CorrelationID, svc.name, event.Source, event.Type, err)
+		}
+	}
+}
+
+// eventRoute is the path service.on patterns match, laid out like the
+// events/ directories: device/<id>/<attribute>, mqtt/<topic>, custom/<type>...
+func eventRoute(event *types.Event) []string {
+	switch event.Source {
+	case "device":
+		if event.Device == "" {
+			return nil
+		}
+		if event.Attribute == "" {
+			return []string{"device", event.Device}
+		}
+		return []string{"device", event.Device, event.Attribute}
+	case "mqtt":
+		route := event.Topic
+		if event.Handler != "" {
+			route = event.Handler
+		}
+		return append([]string{"mqtt"}, strings.Split(route, "/")...)
+	case "state":
+		return []string{"state", event.Attribute}
+	case "":
+		return nil
+	default:
+		return []string{event.Source, event.Type}
+	}
+}
+
+// matchRoute matches an event route against a pattern where + stands for
+// one level and a trailing # for any number of levels, as in MQTT
+func matchRoute(pattern, route []string) bool {
+	for i, part := range pattern {
+		if part == "#" {
+			return true
+		}
+		if i >= len(route) || (part != "+" && part != route[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(route)
+}
+
+func (e *Executor) registerServiceAPI(L *lua.LState) {
+	serviceTable := L.NewTable()
+	L.SetField(serviceTable, "on", L.NewFunction(e.serviceOn))
+	L.SetField(serviceTable, "off", L.NewFunction(e.serviceOff))
+	L.SetField(serviceTable, "call", L.NewFunction(e.serviceCall))
+	L.SetGlobal("service", serviceTable)
+}
+
+// serviceOf returns the service running in L (nil for event scripts)
+func (e *Executor) serviceOf(L *lua.LState) *service {
+	e.servicesMu.RLock()
+	defer e.servicesMu.RUnlock()
+	return e.serviceStates[L]
+}
+
+// serviceOn calls fn with every routed event matching a pattern laid out
+// like the events/ directories, with + for one level and a trailing # for
+// the rest. Only available in config/services/ scripts.
+// Usage: service.on("device/+/temperature", function(event) ... end)
+// Returns: subscription ID, or nil + error
+func (e *Executor) serviceOn(L *lua.LState) int {
+	pattern := L.CheckString(1)
+	fn := L.CheckFunction(2)
+
+	svc := e.serviceOf(L)
+	if svc == nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("service.on is only available in config/services/ scripts"))
+		return 2
+	}
+
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	for i, part := range parts {
+		if part == "" || (part == "#" && i != len(parts)-1) {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("invalid pattern: " + pattern))
+			return 2
+		}
+	}
+
+	svc.mu.Lock()
+	svc.nextSub++
+	id := svc.nextSub
+	svc.subs = append(svc.subs, &serviceSub{id: id, pattern: parts, fn: fn})
+	svc.mu.Unlock()
+
+	L.Push(lua.LNumber(id))
+	return 1
+}
+
+// serviceOff removes a service.on subscription
+// Usage: service.off(id)
+// Returns: true if the subscription existed
+func (e *Executor) serviceOff(L *lua.LState) int {
+	id := L.CheckInt(1)
+
+	svc := e.serviceOf(L)
+	if svc == nil {
+		L.Push(lua.LFalse)
+		return 1
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	for i, sub := range svc.subs {
+		if sub.id == id {
+			svc.subs = append(svc.subs[:i:i], svc.subs[i+1:]...)
+			L.Push(lua.LTrue)
+			return 1
+		}
+	}
+	L.Push(lua.LFalse)
+	return 1
+}
+
+// serviceCall calls a function of the table a service script returned, in
+// the service's state. Arguments and results are copied (no functions).
+// Waits while the service handles an event, up to the caller's timeout.
+// Usage: local avg = service.call("averages", "get", "kitchen")
+// Returns: the function's results, or nil + error
+func (e *Executor) serviceCall(L *lua.LState) int {
+	name := L.CheckString(1)
+	fnName := L.CheckString(2)
+
+	e.servicesMu.RLock()
+	svc := e.services[name]
+	e.servicesMu.RUnlock()
+	if svc == nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("unknown service: " + name))
+		return 2
+	}
+	var fn *lua.LFunction
+	if svc.exports != nil {
+		fn, _ = svc.exports.RawGetString(fnName).(*lua.LFunction)
+	}
+	if fn == nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(fmt.Sprintf("service %s has no function %s", name, fnName)))
+		return 2
+	}
+
+	args := make([]lua.LValue, 0, L.GetTop()-2)
+	for i := 3; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i))
+	}
+
+	// A service calling itself already holds its state
+	if svc.state == L {
+		top := L.GetTop()
+		if err := L.CallByParam(lua.P{Fn: fn, NRet: lua.MultRet, Protect: true}, args...); err != nil {
+			L.SetTop(top)
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		return L.GetTop() - top
+	}
+
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// Polling the lock instead of blocking lets two services calling each
+	// other give up instead of deadlocking
+	for !svc.tracker.executeMux.TryLock() {
+		select {
+		case <-ctx.Done():
+			L.Push(lua.LNil)
+			L.Push(lua.LString("service " + name + " busy"))
+			return 2
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	S := svc.state
+	S.SetContext(ctx)
+	setCorrelation(S, correlationOf(L))
+	for i, arg := range args {
+		args[i] = e.copyValue(S, arg)
+	}
+	top := S.GetTop()
+	err := S.CallByParam(lua.P{Fn: fn, NRet: lua.MultRet, Protect: true}, args...)
+	var results []lua.LValue
+	if err == nil {
+		for i := top + 1; i <= S.GetTop(); i++ {
+			results = append(results, e.copyValue(L, S.Get(i)))
+		}
+	}
+	S.SetTop(top)
+	svc.tracker.executeMux.Unlock()
+
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	for _, result := range results {
+		L.Push(result)
+	}
+	return len(results)
+}
+
+// copyValue copies a value of another state into L
+func (e *Executor) copyValue(L *lua.LState, value lua.LValue) lua.LValue {
+	if value == lua.LNil {
+		return lua.LNil
+	}
+	return e.toLuaValue(L, e.fromLuaValue(value))
+}
@@ -0,0 +1,19 @@
+package executor
+
+import (
+	"bytes"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// CheckSyntax parses and compiles a script without running it. The error
+// names the line of the first problem, e.g. "on_change.lua line:3(column:7) near 'then'".
+func CheckSyntax(source []byte, name string) error {
+	chunk, err := parse.Parse(bytes.NewReader(source), name)
+	if err != nil {
+		return err
+	}
+	_, err = lua.Compile(chunk, name)
+	return err
+}
@@ -0,0 +1,40 @@
+package executor
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SetBackupDir sets where system.backup copies the state database and how
+// many copies it keeps there (all if keep <= 0)
+func (e *Executor) SetBackupDir(dir string, keep int) {
+	e.backupDir = dir
+	e.backupKeep = keep
+}
+
+func (e *Executor) registerSystemAPI(L *lua.LState) {
+	systemTable := L.NewTable()
+	L.SetField(systemTable, "backup", L.NewFunction(e.systemBackup))
+	L.SetGlobal("system", systemTable)
+}
+
+// systemBackup copies the state database to the backup directory while the
+// server runs, deleting the oldest copies beyond the configured number
+// Usage: local path, err = system.backup()
+// Returns: path of the copy, or nil + error
+func (e *Executor) systemBackup(L *lua.LState) int {
+	if e.backupDir == "" {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("no backup directory configured"))
+		return 2
+	}
+	path, err := e.storage.BackupTo(e.backupDir, e.backupKeep)
+	if err != nil {
+		log.Error("Backup failed: %v", err)
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	log.Info("Backed up state to %s", path)
+	L.Push(lua.LString(path))
+	return 1
+}
@@ -0,0 +1,31 @@
+// Package fswatch reports changes to the entries of watched directories, so
+// lookups over events/ can be cached instead of re-read for every event
+package fswatch
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AddNearest watches dir, or its closest existing ancestor when dir doesn't
+// exist, so that dir being created is noticed. It reports whether dir itself
+// is watched.
+func (w *Watcher) AddNearest(dir string) (bool, error) {
+	err := w.Add(dir)
+	if err == nil {
+		return true, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false, err
+		}
+		dir = parent
+		if err = w.Add(dir); err == nil || !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+}
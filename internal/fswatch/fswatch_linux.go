@@ -0,0 +1,53 @@
+//go:build linux
+
+package fswatch
+
+import (
+	"os"
+	"syscall"
+)
+
+// Watcher polls a non-blocking inotify descriptor: Changed costs one read,
+// which fails with EAGAIN while nothing changed
+type Watcher struct {
+	fd  int
+	buf []byte
+}
+
+// Entries added, removed or renamed; writes to existing files don't matter
+const mask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// New creates a watcher with nothing watched
+func New() (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	return &Watcher{fd: fd, buf: make([]byte, 4096)}, nil
+}
+
+// Add watches dir; watches of removed directories go away by themselves
+func (w *Watcher) Add(dir string) error {
+	if _, err := syscall.InotifyAddWatch(w.fd, dir, mask); err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	return nil
+}
+
+// Changed drains pending notifications and reports whether there were any
+func (w *Watcher) Changed() bool {
+	changed := false
+	for {
+		n, err := syscall.Read(w.fd, w.buf)
+		if n <= 0 || err != nil {
+			return changed
+		}
+		changed = true
+	}
+}
+
+// Close releases the descriptor
+func (w *Watcher) Close() error {
+	return syscall.Close(w.fd)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package fswatch
+
+import "errors"
+
+// Watcher is only implemented on Linux; callers fall back to reading the
+// directories every time
+type Watcher struct{}
+
+// New returns errors.ErrUnsupported
+func New() (*Watcher, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (w *Watcher) Add(dir string) error { return errors.ErrUnsupported }
+
+func (w *Watcher) Changed() bool { return true }
+
+func (w *Watcher) Close() error { return nil }
@@ -9,6 +9,8 @@ import (
 	"time"
 )
 
+var log = logger.Module("geo")
+
 // Location represents geographic coordinates
 type Location struct {
 	Latitude  float64
@@ -20,7 +22,7 @@ type Location struct {
 // GetLocationByIP tries to determine location from public IP address
 // Uses free ip-api.com service (no API key required, 45 requests/minute limit)
 func GetLocationByIP() (*Location, error) {
-	logger.Debug("Attempting to determine location from IP address...")
+	log.Debug("Attempting to determine location from IP address...")
 
 	client := &http.Client{
 		Timeout: 10 * time.Second,
@@ -66,7 +68,7 @@ func GetLocationByIP() (*Location, error) {
 		Country:   result.Country,
 	}
 
-	logger.Info("Detected location from IP: %s, %s (%.4f, %.4f)",
+	log.Info("Detected location from IP: %s, %s (%.4f, %.4f)",
 		location.City, location.Country, location.Latitude, location.Longitude)
 
 	return location, nil
@@ -0,0 +1,343 @@
+// Package hass connects to the Home Assistant WebSocket API. Entities that
+// only exist inside Home Assistant become devices: their states arrive as
+// device events and device.set calls their services.
+package hass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/logger"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var log = logger.Module("hass")
+
+// callTimeout bounds how long a request waits for Home Assistant's result
+const callTimeout = 10 * time.Second
+
+// maxBackoff is the longest wait between reconnect attempts
+const maxBackoff = time.Minute
+
+// errDisconnected fails requests while there is no connection
+var errDisconnected = errors.New("not connected to Home Assistant")
+
+// Config selects the Home Assistant instance and the entities to import
+type Config struct {
+	// URL of Home Assistant, e.g. http://homeassistant.local:8123 (the
+	// WebSocket endpoint /api/websocket is added if the path is empty)
+	URL string
+	// Token is a long-lived access token (Profile > Security in Home Assistant)
+	Token string
+	// Domains to import (defaultDomains if empty)
+	Domains []string
+	// Exclude skips entities matching any of these globs, e.g. "sensor.*_rssi"
+	Exclude []string
+}
+
+// message is a frame of the WebSocket API
+type message struct {
+	ID      int             `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Success *bool           `json:"success,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Event *struct {
+		EventType string `json:"event_type"`
+		Data      struct {
+			EntityID string       `json:"entity_id"`
+			NewState *entityState `json:"new_state"`
+		} `json:"data"`
+	} `json:"event,omitempty"`
+	Message string `json:"message,omitempty"` // auth_invalid reason
+}
+
+// reply is the result of a request, or why it failed
+type reply struct {
+	result json.RawMessage
+	err    error
+}
+
+// Bridge keeps a connection to Home Assistant, importing its entities into
+// the device manager and forwarding their commands
+type Bridge struct {
+	cfg           Config
+	endpoint      string
+	deviceManager *devices.Manager
+	router        devices.EventRouter
+
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	nextID   int
+	pending  map[int]chan reply
+	entities map[string]*entity // device ID -> imported entity
+	areas    map[string]registryEntry
+	writeMu  sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a bridge; Start connects it
+func New(cfg Config, dm *devices.Manager, router devices.EventRouter) (*Bridge, error) {
+	endpoint, err := websocketURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("homeassistant.websocket needs a token")
+	}
+	if len(cfg.Domains) == 0 {
+		cfg.Domains = defaultDomains
+	}
+	return &Bridge{
+		cfg:           cfg,
+		endpoint:      endpoint,
+		deviceManager: dm,
+		router:        router,
+		pending:       make(map[int]chan reply),
+		entities:      make(map[string]*entity),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// websocketURL turns the configured Home Assistant URL into its WebSocket endpoint
+func websocketURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid Home Assistant URL %q", raw)
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("invalid Home Assistant URL %q: use http(s):// or ws(s)://", raw)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/api/websocket"
+	}
+	return u.String(), nil
+}
+
+// Start connects in the background, reconnecting with backoff until Stop
+func (b *Bridge) Start() {
+	go b.run()
+	log.Info("Home Assistant bridge started: %s", b.endpoint)
+}
+
+// Stop closes the connection; imported devices stay until the server exits
+func (b *Bridge) Stop() {
+	close(b.stop)
+	b.mu.Lock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+	b.mu.Unlock()
+	<-b.done
+}
+
+func (b *Bridge) run() {
+	defer close(b.done)
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := b.session()
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+
+		// A connection that lasted a while starts the backoff over
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+		log.Warn("Home Assistant connection lost: %v (retrying in %v)", err, backoff)
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session authenticates, imports the entities and handles messages until
+// the connection drops
+func (b *Bridge) session() error {
+	dialer := websocket.Dialer{HandshakeTimeout: callTimeout}
+	conn, _, err := dialer.Dial(b.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := authenticate(conn, b.cfg.Token); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	select {
+	case <-b.stop:
+		b.mu.Unlock()
+		return nil
+	default:
+	}
+	b.conn = conn
+	b.mu.Unlock()
+	defer b.disconnect()
+
+	log.Info("Connected to Home Assistant")
+	go func() {
+		if err := b.sync(); err != nil {
+			log.Warn("Failed to import Home Assistant entities: %v", err)
+			conn.Close()
+		}
+	}()
+
+	for {
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		switch msg.Type {
+		case "result":
+			b.deliver(msg)
+		case "event":
+			if msg.Event != nil && msg.Event.EventType == "state_changed" {
+				b.handleStateChanged(msg.Event.Data.EntityID, msg.Event.Data.NewState)
+			}
+		}
+	}
+}
+
+// authenticate answers Home Assistant's auth_required with the token
+func authenticate(conn *websocket.Conn, token string) error {
+	_ = conn.SetReadDeadline(time.Now().Add(callTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var msg message
+	if err := conn.ReadJSON(&msg); err != nil {
+		return err
+	}
+	if msg.Type != "auth_required" {
+		return fmt.Errorf("unexpected %q instead of auth_required", msg.Type)
+	}
+	if err := conn.WriteJSON(map[string]string{"type": "auth", "access_token": token}); err != nil {
+		return err
+	}
+	if err := conn.ReadJSON(&msg); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case "auth_ok":
+		return nil
+	case "auth_invalid":
+		return fmt.Errorf("authentication failed: %s", msg.Message)
+	default:
+		return fmt.Errorf("unexpected %q instead of auth_ok", msg.Type)
+	}
+}
+
+// disconnect fails the requests still waiting for the closed connection
+func (b *Bridge) disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.conn = nil
+	for id, ch := range b.pending {
+		ch <- reply{err: errDisconnected}
+		delete(b.pending, id)
+	}
+}
+
+// deliver hands a result to the request waiting for it
+func (b *Bridge) deliver(msg message) {
+	b.mu.Lock()
+	ch, ok := b.pending[msg.ID]
+	delete(b.pending, msg.ID)
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if msg.Success != nil && !*msg.Success {
+		err := errors.New("request failed")
+		if msg.Error != nil {
+			err = fmt.Errorf("%s: %s", msg.Error.Code, msg.Error.Message)
+		}
+		ch <- reply{err: err}
+		return
+	}
+	ch <- reply{result: msg.Result}
+}
+
+// call sends a request and waits for its result
+func (b *Bridge) call(request map[string]interface{}) (json.RawMessage, error) {
+	b.mu.Lock()
+	conn := b.conn
+	if conn == nil {
+		b.mu.Unlock()
+		return nil, errDisconnected
+	}
+	b.nextID++
+	id := b.nextID
+	ch := make(chan reply, 1)
+	b.pending[id] = ch
+	b.mu.Unlock()
+
+	request["id"] = id
+	b.writeMu.Lock()
+	_ = conn.SetWriteDeadline(time.Now().Add(callTimeout))
+	err := conn.WriteJSON(request)
+	b.writeMu.Unlock()
+	if err != nil {
+		b.forget(id)
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.result, r.err
+	case <-time.After(callTimeout):
+		b.forget(id)
+		return nil, fmt.Errorf("no reply to %s within %v", request["type"], callTimeout)
+	}
+}
+
+func (b *Bridge) forget(id int) {
+	b.mu.Lock()
+	delete(b.pending, id)
+	b.mu.Unlock()
+}
+
+// CallService calls a Home Assistant service, e.g. ("light", "turn_on",
+// {"entity_id": "light.kitchen", "brightness": 120})
+func (b *Bridge) CallService(domain, service string, data map[string]interface{}) error {
+	request := map[string]interface{}{
+		"type":    "call_service",
+		"domain":  domain,
+		"service": service,
+	}
+	if len(data) > 0 {
+		request["service_data"] = data
+	}
+	if _, err := b.call(request); err != nil {
+		return fmt.Errorf("%s.%s: %w", domain, service, err)
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package hass
+
+import (
+	"encoding/json"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/types"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IDPrefix starts the ID of every imported entity: hass/<entity_id>
+const IDPrefix = "hass/"
+
+// defaultDomains are imported unless the config lists others
+var defaultDomains = []string{
+	"alarm_control_panel", "binary_sensor", "button", "climate", "cover",
+	"fan", "humidifier", "input_boolean", "input_button", "input_number",
+	"input_select", "input_text", "light", "lock", "media_player", "number",
+	"scene", "script", "select", "sensor", "siren", "switch", "text",
+	"vacuum", "valve", "water_heater",
+}
+
+// deviceTypes maps domains whose device type differs from the domain
+var deviceTypes = map[string]string{
+	"alarm_control_panel": "alarm",
+	"input_boolean":       "switch",
+	"input_button":        "button",
+	"input_number":        "number",
+	"input_select":        "select",
+	"input_text":          "text",
+}
+
+// skippedAttributes describe the entity rather than its state
+var skippedAttributes = map[string]bool{
+	"friendly_name": true, "icon": true, "entity_picture": true,
+	"supported_features": true, "attribution": true,
+}
+
+// entityState is a state object of the WebSocket API
+type entityState struct {
+	EntityID   string                 `json:"entity_id"`
+	State      string                 `json:"state"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// registryEntry is the part of an entity or device registry entry used
+// for areas, vendors and skipping MQTT entities
+type registryEntry struct {
+	EntityID     string `json:"entity_id"`
+	ID           string `json:"id"`
+	DeviceID     string `json:"device_id"`
+	AreaID       string `json:"area_id"`
+	Platform     string `json:"platform"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+}
+
+// entity is an imported entity
+type entity struct {
+	id     string // Home Assistant entity ID
+	domain string
+	device *types.Device
+}
+
+// Owns reports whether a device is an imported entity
+func (b *Bridge) Owns(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.entities[id]
+	return ok
+}
+
+// Forward calls the services of an imported entity that set attrs
+func (b *Bridge) Forward(dev *types.Device, attrs map[string]interface{}) error {
+	b.mu.Lock()
+	ent, ok := b.entities[dev.ID]
+	b.mu.Unlock()
+	if !ok {
+		return errDisconnected
+	}
+
+	calls, err := serviceCalls(ent.domain, attrs)
+	if err != nil {
+		return err
+	}
+	for _, c := range calls {
+		data := map[string]interface{}{"entity_id": ent.id}
+		for k, v := range c.data {
+			data[k] = v
+		}
+		log.Debug("Calling %s.%s for %s: %v", c.domain, c.service, ent.id, c.data)
+		if err := b.CallService(c.domain, c.service, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync imports the current entities after connecting and removes the ones
+// deleted in Home Assistant while disconnected
+func (b *Bridge) sync() error {
+	// Subscribe first so no change between the two requests is lost
+	if _, err := b.call(map[string]interface{}{"type": "subscribe_events", "event_type": "state_changed"}); err != nil {
+		return err
+	}
+
+	registry, err := b.registry()
+	if err != nil {
+		// Tokens of non-admin users can't read the registries
+		log.Warn("Importing entities without areas: %v", err)
+	}
+	b.mu.Lock()
+	b.areas = registry
+	b.mu.Unlock()
+
+	result, err := b.call(map[string]interface{}{"type": "get_states"})
+	if err != nil {
+		return err
+	}
+	var states []*entityState
+	if err := json.Unmarshal(result, &states); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, state := range states {
+		if id, ok := b.importEntity(state, false); ok {
+			seen[id] = true
+		}
+	}
+
+	b.mu.Lock()
+	var removed []string
+	for id := range b.entities {
+		if !seen[id] {
+			removed = append(removed, id)
+			delete(b.entities, id)
+		}
+	}
+	b.mu.Unlock()
+	for _, id := range removed {
+		b.deviceManager.RemoveDevice(id)
+		log.Info("Removed %s: no longer in Home Assistant", id)
+	}
+
+	log.Info("Imported %d Home Assistant entities", len(seen))
+	return nil
+}
+
+// registry reads areas, vendors and platforms of the entities by entity ID
+func (b *Bridge) registry() (map[string]registryEntry, error) {
+	result, err := b.call(map[string]interface{}{"type": "config/entity_registry/list"})
+	if err != nil {
+		return nil, err
+	}
+	var entities []registryEntry
+	if err := json.Unmarshal(result, &entities); err != nil {
+		return nil, err
+	}
+
+	result, err = b.call(map[string]interface{}{"type": "config/device_registry/list"})
+	if err != nil {
+		return nil, err
+	}
+	var deviceList []registryEntry
+	if err := json.Unmarshal(result, &deviceList); err != nil {
+		return nil, err
+	}
+	byDevice := make(map[string]registryEntry, len(deviceList))
+	for _, dev := range deviceList {
+		byDevice[dev.ID] = dev
+	}
+
+	registry := make(map[string]registryEntry, len(entities))
+	for _, ent := range entities {
+		if dev, ok := byDevice[ent.DeviceID]; ok {
+			if ent.AreaID == "" {
+				ent.AreaID = dev.AreaID
+			}
+			ent.Manufacturer, ent.Model = dev.Manufacturer, dev.Model
+		}
+		registry[ent.EntityID] = ent
+	}
+	return registry, nil
+}
+
+// wanted reports whether an entity is imported: its domain is configured,
+// it matches no exclude glob and it is not an MQTT entity (those are
+// devices through MQTT discovery already)
+func (b *Bridge) wanted(entityID, domain string, reg registryEntry) bool {
+	if reg.Platform == "mqtt" {
+		return false
+	}
+	found := false
+	for _, d := range b.cfg.Domains {
+		if d == domain {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	for _, pattern := range b.cfg.Exclude {
+		if ok, _ := path.Match(pattern, entityID); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// importEntity adds or updates the device of an entity and applies its
+// state, routing events if route is set. It returns the device ID.
+func (b *Bridge) importEntity(state *entityState, route bool) (string, bool) {
+	domain, _, ok := strings.Cut(state.EntityID, ".")
+	if !ok {
+		return "", false
+	}
+	b.mu.Lock()
+	reg := b.areas[state.EntityID]
+	b.mu.Unlock()
+	if !b.wanted(state.EntityID, domain, reg) {
+		return "", false
+	}
+
+	id := IDPrefix + state.EntityID
+	values := stateValues(state)
+	dev := &types.Device{
+		ID:         id,
+		Name:       state.EntityID,
+		Type:       domain,
+		Vendor:     reg.Manufacturer,
+		Model:      reg.Model,
+		Area:       reg.AreaID,
+		Attributes: sortedKeys(values),
+	}
+	if name, ok := state.Attributes["friendly_name"].(string); ok && name != "" {
+		dev.Name = name
+	}
+	if t, ok := deviceTypes[domain]; ok {
+		dev.Type = t
+	}
+
+	b.mu.Lock()
+	ent, known := b.entities[id]
+	if !known || !reflect.DeepEqual(ent.device, dev) {
+		b.entities[id] = &entity{id: state.EntityID, domain: domain, device: dev}
+	}
+	b.mu.Unlock()
+	if !known || !reflect.DeepEqual(ent.device, dev) {
+		b.deviceManager.AddDevice(dev)
+		if !known && route {
+			log.Info("Imported new Home Assistant entity %s", id)
+		}
+	}
+
+	b.applyState(dev, state, values, route)
+	return id, true
+}
+
+// applyState stores the values of an entity and, if route is set, routes a
+// state_change event per changed attribute and availability changes
+func (b *Bridge) applyState(dev *types.Device, state *entityState, values map[string]interface{}, route bool) {
+	online := state.State != "unavailable"
+	availabilityChanged := b.deviceManager.SetAvailability(dev.ID, online)
+	if !online {
+		// Keep the last known state of unreachable entities
+		delete(values, "state")
+	}
+
+	old, _ := b.deviceManager.Get(dev.ID)
+	b.deviceManager.UpdateState(dev.ID, values)
+	if !route || b.router == nil {
+		return
+	}
+
+	correlationID := types.NewCorrelationID()
+	now := time.Now()
+	if availabilityChanged {
+		value := "offline"
+		if online {
+			value = "online"
+		}
+		b.router.RouteEvent(&types.Event{
+			Source:    "device",
+			Type:      "availability",
+			Device:    dev.ID,
+			Attribute: devices.AvailabilityAttribute,
+			Area:      dev.Area,
+			Data: map[string]interface{}{
+				devices.AvailabilityAttribute: value,
+				"online":                      online,
+			},
+			Timestamp:     now,
+			CorrelationID: correlationID,
+		})
+	}
+
+	for _, attr := range sortedKeys(values) {
+		previous, known := old[attr]
+		if known && reflect.DeepEqual(previous, values[attr]) {
+			continue
+		}
+		data := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			data[k] = v
+		}
+		b.router.RouteEvent(&types.Event{
+			Source:        "device",
+			Type:          "state_change",
+			Device:        dev.ID,
+			Attribute:     attr,
+			Area:          dev.Area,
+			Data:          data,
+			Timestamp:     now,
+			CorrelationID: correlationID,
+			FirstReport:   !known,
+		})
+	}
+}
+
+// handleStateChanged applies a state_changed event; a nil state means the
+// entity was removed
+func (b *Bridge) handleStateChanged(entityID string, state *entityState) {
+	if state == nil {
+		id := IDPrefix + entityID
+		b.mu.Lock()
+		_, known := b.entities[id]
+		delete(b.entities, id)
+		b.mu.Unlock()
+		if known {
+			b.deviceManager.RemoveDevice(id)
+			log.Info("Removed %s: deleted in Home Assistant", id)
+		}
+		return
+	}
+	b.importEntity(state, true)
+}
+
+// stateValues turns a state object into device attributes: "state" plus
+// the entity's attributes. on/off become ON/OFF like MQTT devices report
+// them, and numeric states become numbers.
+func stateValues(state *entityState) map[string]interface{} {
+	values := make(map[string]interface{}, len(state.Attributes)+1)
+	for k, v := range state.Attributes {
+		if !skippedAttributes[k] {
+			values[k] = v
+		}
+	}
+
+	switch state.State {
+	case "on", "off":
+		values["state"] = strings.ToUpper(state.State)
+	default:
+		if n, err := strconv.ParseFloat(state.State, 64); err == nil {
+			values["state"] = n
+		} else {
+			values["state"] = state.State
+		}
+	}
+	return values
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
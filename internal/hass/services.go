@@ -0,0 +1,161 @@
+package hass
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// serviceCall is one Home Assistant service call made for device.set
+type serviceCall struct {
+	domain  string
+	service string
+	data    map[string]interface{}
+}
+
+// attributeService is the service setting an attribute and the service
+// data field taking its value
+type attributeService struct {
+	service string
+	field   string
+}
+
+// attributeServices map settable attributes per domain; "state" of the
+// value domains (number, select, text) sets their value as well
+var attributeServices = map[string]map[string]attributeService{
+	"climate": {
+		"temperature":        {"set_temperature", "temperature"},
+		"target_temperature": {"set_temperature", "temperature"},
+		"target_temp_high":   {"set_temperature", "target_temp_high"},
+		"target_temp_low":    {"set_temperature", "target_temp_low"},
+		"hvac_mode":          {"set_hvac_mode", "hvac_mode"},
+		"fan_mode":           {"set_fan_mode", "fan_mode"},
+		"preset_mode":        {"set_preset_mode", "preset_mode"},
+		"swing_mode":         {"set_swing_mode", "swing_mode"},
+		"humidity":           {"set_humidity", "humidity"},
+	},
+	"cover": {
+		"position":      {"set_cover_position", "position"},
+		"tilt_position": {"set_cover_tilt_position", "tilt_position"},
+	},
+	"valve": {
+		"position": {"set_valve_position", "position"},
+	},
+	"fan": {
+		"percentage":  {"set_percentage", "percentage"},
+		"preset_mode": {"set_preset_mode", "preset_mode"},
+		"oscillating": {"oscillate", "oscillating"},
+		"direction":   {"set_direction", "direction"},
+	},
+	"humidifier": {
+		"humidity": {"set_humidity", "humidity"},
+		"mode":     {"set_mode", "mode"},
+	},
+	"water_heater": {
+		"temperature":    {"set_temperature", "temperature"},
+		"operation_mode": {"set_operation_mode", "operation_mode"},
+	},
+	"media_player": {
+		"volume_level":    {"volume_set", "volume_level"},
+		"is_volume_muted": {"volume_mute", "is_volume_muted"},
+		"source":          {"select_source", "source"},
+	},
+	"input_number": {"state": {"set_value", "value"}, "value": {"set_value", "value"}},
+	"number":       {"state": {"set_value", "value"}, "value": {"set_value", "value"}},
+	"input_text":   {"state": {"set_value", "value"}, "value": {"set_value", "value"}},
+	"text":         {"state": {"set_value", "value"}, "value": {"set_value", "value"}},
+	"input_select": {"state": {"select_option", "option"}, "option": {"select_option", "option"}},
+	"select":       {"state": {"select_option", "option"}, "option": {"select_option", "option"}},
+}
+
+// stateServices map commanded states per domain; other domains use
+// ON/OFF/TOGGLE with turn_on/turn_off/toggle
+var stateServices = map[string]map[string]string{
+	"cover":        {"OPEN": "open_cover", "CLOSE": "close_cover", "STOP": "stop_cover"},
+	"valve":        {"OPEN": "open_valve", "CLOSE": "close_valve", "STOP": "stop_valve"},
+	"lock":         {"LOCK": "lock", "UNLOCK": "unlock", "OPEN": "open"},
+	"button":       {"PRESS": "press"},
+	"input_button": {"PRESS": "press"},
+	"scene":        {"ON": "turn_on"},
+	"vacuum":       {"START": "start", "STOP": "stop", "PAUSE": "pause", "RETURN": "return_to_base"},
+}
+
+var onOffServices = map[string]string{"ON": "turn_on", "OFF": "turn_off", "TOGGLE": "toggle"}
+
+// serviceCalls translates the attributes of device.set into service calls
+// of an entity's domain. Lights take all attributes in one turn_on call
+// (brightness, color_temp, rgb_color, transition, ...).
+func serviceCalls(domain string, attrs map[string]interface{}) ([]serviceCall, error) {
+	attrs = copyAttrs(attrs)
+	var calls []serviceCall
+
+	var stateService string
+	if value, ok := attrs["state"]; ok {
+		if _, isValue := attributeServices[domain]["state"]; !isValue {
+			command := strings.ToUpper(fmt.Sprint(value))
+			services := stateServices[domain]
+			if services == nil {
+				services = onOffServices
+			}
+			if stateService, ok = services[command]; !ok {
+				return nil, fmt.Errorf("%s does not accept state %v", domain, value)
+			}
+			delete(attrs, "state")
+		}
+	}
+
+	if domain == "light" {
+		if stateService == "" && len(attrs) > 0 {
+			stateService = "turn_on"
+		}
+		data := attrs
+		if stateService == "turn_off" {
+			// Only transition applies when turning off
+			data = map[string]interface{}{}
+			if t, ok := attrs["transition"]; ok {
+				data["transition"] = t
+			}
+		}
+		if stateService == "" {
+			return nil, nil
+		}
+		return []serviceCall{{domain, stateService, data}}, nil
+	}
+
+	// Switch on before the settings so they apply, and switch off last
+	grouped := make(map[string]map[string]interface{})
+	for _, attr := range sortedKeys(attrs) {
+		svc, ok := attributeServices[domain][attr]
+		if !ok {
+			return nil, fmt.Errorf("no Home Assistant service sets %s of %s entities, use hass.call", attr, domain)
+		}
+		if grouped[svc.service] == nil {
+			grouped[svc.service] = make(map[string]interface{})
+		}
+		grouped[svc.service][svc.field] = attrs[attr]
+	}
+	services := make([]string, 0, len(grouped))
+	for service := range grouped {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	if stateService != "" && stateService != "turn_off" {
+		calls = append(calls, serviceCall{domain, stateService, nil})
+	}
+	for _, service := range services {
+		calls = append(calls, serviceCall{domain, service, grouped[service]})
+	}
+	if stateService == "turn_off" {
+		calls = append(calls, serviceCall{domain, stateService, nil})
+	}
+	return calls, nil
+}
+
+func copyAttrs(attrs map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"homescript-server/internal/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single recorded attribute value
+type Entry struct {
+	Value     interface{} `json:"value"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// ring is a fixed-size circular buffer of entries
+type ring struct {
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func (r *ring) add(entry Entry) {
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// last returns up to n most recent entries in chronological order
+func (r *ring) last(n int) []Entry {
+	size := r.next
+	if r.full {
+		size = len(r.entries)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	result := make([]Entry, n)
+	start := r.next - n
+	for i := 0; i < n; i++ {
+		idx := (start + i + len(r.entries)) % len(r.entries)
+		result[i] = r.entries[idx]
+	}
+	return result
+}
+
+// History keeps the last N values per device attribute in memory
+type History struct {
+	size  int
+	rings map[string]*ring // "device\x00attribute" -> ring
+	mu    sync.RWMutex
+}
+
+// New creates a History keeping size entries per device attribute
+func New(size int) *History {
+	if size <= 0 {
+		size = 1
+	}
+	return &History{
+		size:  size,
+		rings: make(map[string]*ring),
+	}
+}
+
+func key(device, attribute string) string {
+	return device + "\x00" + attribute
+}
+
+func splitKey(k string) (device, attribute string) {
+	device, attribute, _ = strings.Cut(k, "\x00")
+	return device, attribute
+}
+
+// Record stores the attribute value carried by a device event
+func (h *History) Record(event *types.Event) {
+	if event.Source != "device" || event.Device == "" || event.Attribute == "" {
+		return
+	}
+
+	value, ok := event.Data[event.Attribute]
+	if !ok {
+		return
+	}
+	// Binary payloads (snapshots) are not worth keeping in memory
+	if _, isBinary := value.([]byte); isBinary {
+		return
+	}
+
+	timestamp := event.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	h.Add(event.Device, event.Attribute, value, timestamp)
+}
+
+// Add stores a value for a device attribute
+func (h *History) Add(device, attribute string, value interface{}, timestamp time.Time) {
+	k := key(device, attribute)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rings[k]
+	if !ok {
+		r = &ring{entries: make([]Entry, h.size)}
+		h.rings[k] = r
+	}
+	r.add(Entry{Value: value, Timestamp: timestamp})
+}
+
+// Recent returns up to n most recent values for a device attribute, oldest first.
+// n <= 0 returns everything retained.
+func (h *History) Recent(device, attribute string, n int) []Entry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.rings[key(device, attribute)]
+	if !ok {
+		return nil
+	}
+	return r.last(n)
+}
+
+// Snapshot returns everything retained as device -> attribute -> entries
+func (h *History) Snapshot() map[string]map[string][]Entry {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	snapshot := make(map[string]map[string][]Entry)
+	for k, r := range h.rings {
+		device, attribute := splitKey(k)
+		if snapshot[device] == nil {
+			snapshot[device] = make(map[string][]Entry)
+		}
+		snapshot[device][attribute] = r.last(0)
+	}
+	return snapshot
+}
+
+// Restore replaces the retained values with a snapshot
+func (h *History) Restore(snapshot map[string]map[string][]Entry) {
+	rings := make(map[string]*ring)
+	for device, attributes := range snapshot {
+		for attribute, entries := range attributes {
+			r := &ring{entries: make([]Entry, h.size)}
+			for _, entry := range entries {
+				r.add(entry)
+			}
+			rings[key(device, attribute)] = r
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rings = rings
+}
@@ -0,0 +1,44 @@
+package journal
+
+import (
+	"homescript-server/internal/types"
+	"time"
+)
+
+// Filter selects journal entries
+type Filter struct {
+	Since         time.Time
+	Until         time.Time
+	Source        string
+	Type          string
+	Device        string
+	Attribute     string
+	CorrelationID string
+	Limit         int // 0 = no limit (most recent events win when limited)
+}
+
+// Matches reports whether an event passes the filter
+func (f Filter) Matches(event *types.Event) bool {
+	if !f.Since.IsZero() && event.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && event.Timestamp.After(f.Until) {
+		return false
+	}
+	if f.Source != "" && event.Source != f.Source {
+		return false
+	}
+	if f.Type != "" && event.Type != f.Type {
+		return false
+	}
+	if f.Device != "" && event.Device != f.Device {
+		return false
+	}
+	if f.Attribute != "" && event.Attribute != f.Attribute {
+		return false
+	}
+	if f.CorrelationID != "" && event.CorrelationID != f.CorrelationID {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,278 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var log = logger.Module("journal")
+
+var eventsBucket = []byte("events")
+
+// pruneInterval is how often expired entries are removed while running
+const pruneInterval = 10 * time.Minute
+
+const (
+	// queueSize is how many events wait for the writer before new ones are dropped
+	queueSize = 4096
+	// maxBatch is the most events written in one transaction
+	maxBatch = 512
+)
+
+// entry is a marshaled event waiting to be written
+type entry struct {
+	timestamp time.Time
+	data      []byte
+}
+
+// Journal is an append-only log of routed events kept in its own database file,
+// so it never bloats script state. Entries older than the retention are pruned.
+// Events are queued and written by a background writer, one transaction for
+// whatever queued up meanwhile, so recording never waits for the disk.
+type Journal struct {
+	db        *bbolt.DB
+	retention time.Duration
+	queue     chan entry
+	dropped   atomic.Int64 // events dropped on a full queue, not logged yet
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// Open opens (or creates) a journal file; retention <= 0 keeps entries forever
+func Open(path string, retention time.Duration) (*Journal, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create journal directory: %w", err)
+		}
+	}
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create journal bucket: %w", err)
+	}
+
+	j := &Journal{
+		db:        db,
+		retention: retention,
+		queue:     make(chan entry, queueSize),
+		stopChan:  make(chan struct{}),
+	}
+	j.wg.Add(1)
+	go j.writer()
+	return j, nil
+}
+
+// Start prunes expired entries now and then periodically until Close
+func (j *Journal) Start() {
+	if j.retention <= 0 {
+		return
+	}
+
+	j.prune()
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.prune()
+			case <-j.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close writes the queued events, stops pruning and closes the journal file
+func (j *Journal) Close() error {
+	j.stopOnce.Do(func() { close(j.stopChan) })
+	j.wg.Wait()
+	return j.db.Close()
+}
+
+// RecordEvent queues an event for the journal (implements
+// events.EventRecorder). It doesn't wait for the write; when the writer falls
+// behind by more than queueSize events, new ones are dropped and counted.
+// Binary payloads (e.g. snapshots) are replaced by their size.
+func (j *Journal) RecordEvent(event *types.Event) error {
+	stored := *event
+	stored.Data = make(map[string]interface{}, len(event.Data))
+	for k, v := range event.Data {
+		if b, isBinary := v.([]byte); isBinary {
+			stored.Data[k] = fmt.Sprintf("<binary %d bytes>", len(b))
+			continue
+		}
+		stored.Data[k] = v
+	}
+
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	select {
+	case j.queue <- entry{timestamp: event.Timestamp, data: data}:
+	default:
+		j.dropped.Add(1)
+	}
+	return nil
+}
+
+// writer writes queued events until Close, then what is still queued
+func (j *Journal) writer() {
+	defer j.wg.Done()
+	batch := make([]entry, 0, maxBatch)
+	for {
+		select {
+		case e := <-j.queue:
+			batch = append(batch[:0], e)
+		case <-j.stopChan:
+			for {
+				batch = j.drain(batch[:0])
+				if len(batch) == 0 {
+					return
+				}
+				j.write(batch)
+			}
+		}
+		j.write(j.drain(batch))
+	}
+}
+
+// drain adds the queued events to batch, up to maxBatch, without waiting
+func (j *Journal) drain(batch []entry) []entry {
+	for len(batch) < maxBatch {
+		select {
+		case e := <-j.queue:
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// write appends a batch of events in one transaction
+func (j *Journal) write(batch []entry) {
+	if dropped := j.dropped.Swap(0); dropped > 0 {
+		log.Warn("Journal writer fell behind, dropped %d event(s)", dropped)
+	}
+	err := j.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		for _, e := range batch {
+			// Key: timestamp (ns) + sequence, so keys sort chronologically
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 16)
+			binary.BigEndian.PutUint64(key[:8], uint64(e.timestamp.UnixNano()))
+			binary.BigEndian.PutUint64(key[8:], seq)
+
+			if err := b.Put(key, e.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Failed to write %d journal entries: %v", len(batch), err)
+	}
+}
+
+// Events returns journal entries matching the filter in chronological order
+func (j *Journal) Events(filter Filter) ([]*types.Event, error) {
+	var events []*types.Event
+	err := j.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+
+		var k, v []byte
+		if !filter.Since.IsZero() {
+			k, v = c.Seek(timeKey(filter.Since))
+		} else {
+			k, v = c.First()
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			var event types.Event
+			if err := json.Unmarshal(v, &event); err != nil {
+				continue
+			}
+			if !filter.Until.IsZero() && event.Timestamp.After(filter.Until) {
+				break
+			}
+			if filter.Matches(&event) {
+				events = append(events, &event)
+			}
+		}
+		return nil
+	})
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[len(events)-filter.Limit:]
+	}
+	return events, err
+}
+
+// prune removes entries older than the retention period
+func (j *Journal) prune() {
+	cutoff := timeKey(time.Now().Add(-j.retention))
+
+	removed := 0
+	err := j.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+
+		// Collect first: deleting while iterating a bbolt cursor skips keys
+		var expired [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], cutoff) < 0; k, _ = c.Next() {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	if err != nil {
+		log.Error("Failed to prune journal: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Debug("Pruned %d journal entries older than %v", removed, j.retention)
+	}
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
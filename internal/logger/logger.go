@@ -38,16 +38,52 @@ var levelColors = map[Level]string{
 
 const colorReset = "\033[0m"
 
+// Format selects the log line layout
+type Format int
+
+const (
+	// FormatDefault prints full date, bracketed level and module
+	FormatDefault Format = iota
+	// FormatCompact prints time, short level and a fixed-width module column
+	FormatCompact
+)
+
+var levelShortNames = map[Level]string{
+	DEBUG:    "DBG",
+	INFO:     "INF",
+	WARN:     "WRN",
+	ERROR:    "ERR",
+	CRITICAL: "CRT",
+}
+
+// moduleColors is the palette modules are assigned from (stable per name)
+var moduleColors = []string{
+	"\033[94m", // Bright blue
+	"\033[95m", // Bright magenta
+	"\033[96m", // Bright cyan
+	"\033[92m", // Bright green
+	"\033[93m", // Bright yellow
+	"\033[34m", // Blue
+}
+
+// moduleWidth is the padded width of the module column in compact format
+const moduleWidth = 10
+
 // Logger provides leveled logging
 type Logger struct {
-	level       Level
-	output      io.Writer
-	useColors   bool
-	debugLog    *log.Logger
-	infoLog     *log.Logger
-	warnLog     *log.Logger
-	errorLog    *log.Logger
-	criticalLog *log.Logger
+	level     Level
+	output    io.Writer
+	useColors bool
+	format    Format
+	sampler   *sampler
+	tail      *tail // recent lines for the API, if enabled
+	// moduleLevels override level for single modules
+	moduleLevels map[string]Level
+	debugLog     *log.Logger
+	infoLog      *log.Logger
+	warnLog      *log.Logger
+	errorLog     *log.Logger
+	criticalLog  *log.Logger
 }
 
 var defaultLogger *Logger
@@ -74,6 +110,25 @@ func New(level Level, output io.Writer, useColors bool) *Logger {
 	}
 }
 
+// SetFormat changes the log line layout
+func SetFormat(format Format) {
+	if defaultLogger != nil {
+		defaultLogger.format = format
+	}
+}
+
+// ParseFormat parses format string to Format
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "default":
+		return FormatDefault, nil
+	case "compact":
+		return FormatCompact, nil
+	default:
+		return FormatDefault, fmt.Errorf("invalid log format: %s", s)
+	}
+}
+
 // SetLevel changes the logging level
 func SetLevel(level Level) {
 	if defaultLogger != nil {
@@ -81,6 +136,17 @@ func SetLevel(level Level) {
 	}
 }
 
+// SetModuleLevel makes a module log from level on regardless of the global
+// level, e.g. INFO for one module while the rest stays at ERROR
+func SetModuleLevel(module string, level Level) {
+	if defaultLogger != nil {
+		if defaultLogger.moduleLevels == nil {
+			defaultLogger.moduleLevels = make(map[string]Level)
+		}
+		defaultLogger.moduleLevels[module] = level
+	}
+}
+
 // GetLevel returns current logging level
 func GetLevel() Level {
 	if defaultLogger != nil {
@@ -108,30 +174,93 @@ func ParseLevel(s string) (Level, error) {
 }
 
 func (l *Logger) log(level Level, format string, v ...interface{}) {
-	if l == nil || level < l.level {
+	l.logModule(level, "", format, v...)
+}
+
+func (l *Logger) logModule(level Level, module string, format string, v ...interface{}) {
+	if l == nil {
+		return
+	}
+	minLevel := l.level
+	if moduleLevel, ok := l.moduleLevels[module]; ok {
+		minLevel = moduleLevel
+	}
+	if level < minLevel {
 		return
 	}
 
-	var logInstance *log.Logger
+	logInstance := l.logFor(level)
+	if logInstance == nil {
+		return
+	}
+
+	now := time.Now()
+	message := fmt.Sprintf(format, v...)
+
+	// Sample repetitive debug output
+	if level == DEBUG && l.sampler != nil {
+		allowed, summaries := l.sampler.allow(level, module, message, now)
+		l.printSummaries(summaries, now)
+		if !allowed {
+			return
+		}
+	}
+
+	l.print(logInstance, level, module, message, now)
+}
+
+// logFor returns the log.Logger of a level
+func (l *Logger) logFor(level Level) *log.Logger {
 	switch level {
 	case DEBUG:
-		logInstance = l.debugLog
+		return l.debugLog
 	case INFO:
-		logInstance = l.infoLog
+		return l.infoLog
 	case WARN:
-		logInstance = l.warnLog
+		return l.warnLog
 	case ERROR:
-		logInstance = l.errorLog
+		return l.errorLog
 	case CRITICAL:
-		logInstance = l.criticalLog
+		return l.criticalLog
 	}
+	return nil
+}
 
-	if logInstance == nil {
-		return
+// printSummaries prints the "repeated N times" line of sampled messages
+func (l *Logger) printSummaries(summaries []*sampleEntry, now time.Time) {
+	for _, entry := range summaries {
+		l.print(l.logFor(entry.level), entry.level, entry.module,
+			fmt.Sprintf("%s (repeated %d times)", entry.message, entry.suppressed), now)
+	}
+}
+
+// print writes a log line and keeps it in the tail
+func (l *Logger) print(logInstance *log.Logger, level Level, module, message string, now time.Time) {
+	logInstance.Print(l.prefix(level, module, now) + message)
+	if l.tail != nil {
+		l.tail.add(level, module, message, now)
+	}
+}
+
+// prefix renders timestamp, level and module according to the configured format
+func (l *Logger) prefix(level Level, module string, now time.Time) string {
+	if l.format == FormatCompact {
+		levelName := levelShortNames[level]
+		moduleName := fmt.Sprintf("%-*s", moduleWidth, module)
+		if len(module) > moduleWidth {
+			moduleName = module[:moduleWidth]
+		}
+
+		if l.useColors {
+			levelName = levelColors[level] + levelName + colorReset
+			if module != "" {
+				moduleName = moduleColor(module) + moduleName + colorReset
+			}
+		}
+		return fmt.Sprintf("%s %s %s | ", now.Format("15:04:05.000"), levelName, moduleName)
 	}
 
 	// Format with local time
-	now := time.Now()
 	timestamp := now.Format("2006/01/02 15:04:05")
 
 	levelName := levelNames[level]
@@ -142,8 +271,32 @@ func (l *Logger) log(level Level, format string, v ...interface{}) {
 		prefix = timestamp + " " + color + fmt.Sprintf("[%s]", levelName) + colorReset + " "
 	}
 
-	message := fmt.Sprintf(format, v...)
-	logInstance.Print(prefix + message)
+	if module != "" {
+		if l.useColors {
+			prefix += moduleColor(module) + "[" + module + "]" + colorReset + " "
+		} else {
+			prefix += "[" + module + "] "
+		}
+	}
+
+	return prefix
+}
+
+// moduleColor picks a stable palette color for a module name
+func moduleColor(module string) string {
+	// Worker modules (worker-1, worker-2, ...) share a single color
+	if strings.HasPrefix(module, "worker-") {
+		module = "worker"
+	}
+
+	hash := 0
+	for _, r := range module {
+		hash = hash*31 + int(r)
+	}
+	if hash < 0 {
+		hash = -hash
+	}
+	return moduleColors[hash%len(moduleColors)]
 }
 
 // Debug logs a debug message
@@ -207,3 +360,55 @@ func Critical(format string, v ...interface{}) {
 		defaultLogger.Critical(format, v...)
 	}
 }
+
+// ModuleLogger tags entries with the name of the emitting module
+type ModuleLogger struct {
+	name string
+}
+
+// Module returns a logger that tags entries with the given module name
+// (e.g. "mqtt", "scheduler", "worker-3"). It writes through the default logger,
+// so it can be created before Init is called.
+func Module(name string) *ModuleLogger {
+	return &ModuleLogger{name: name}
+}
+
+// Name returns the module name
+func (m *ModuleLogger) Name() string {
+	return m.name
+}
+
+// Debug logs a debug message tagged with the module name
+func (m *ModuleLogger) Debug(format string, v ...interface{}) {
+	if defaultLogger != nil {
+		defaultLogger.logModule(DEBUG, m.name, format, v...)
+	}
+}
+
+// Info logs an info message tagged with the module name
+func (m *ModuleLogger) Info(format string, v ...interface{}) {
+	if defaultLogger != nil {
+		defaultLogger.logModule(INFO, m.name, format, v...)
+	}
+}
+
+// Warn logs a warning message tagged with the module name
+func (m *ModuleLogger) Warn(format string, v ...interface{}) {
+	if defaultLogger != nil {
+		defaultLogger.logModule(WARN, m.name, format, v...)
+	}
+}
+
+// Error logs an error message tagged with the module name
+func (m *ModuleLogger) Error(format string, v ...interface{}) {
+	if defaultLogger != nil {
+		defaultLogger.logModule(ERROR, m.name, format, v...)
+	}
+}
+
+// Critical logs a critical message tagged with the module name
+func (m *ModuleLogger) Critical(format string, v ...interface{}) {
+	if defaultLogger != nil {
+		defaultLogger.logModule(CRITICAL, m.name, format, v...)
+	}
+}
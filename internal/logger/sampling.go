@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// sampleEntry tracks a repeated message within the sampling window
+type sampleEntry struct {
+	level      Level
+	module     string
+	message    string
+	start      time.Time
+	suppressed int
+}
+
+// sampler suppresses identical debug messages within a time window and
+// reports how many times they were repeated
+type sampler struct {
+	window  time.Duration
+	entries map[string]*sampleEntry
+	mu      sync.Mutex
+	stop    chan struct{}
+}
+
+// maxSampleEntries bounds memory used by distinct sampled messages
+const maxSampleEntries = 4096
+
+func newSampler(window time.Duration) *sampler {
+	return &sampler{
+		window:  window,
+		entries: make(map[string]*sampleEntry),
+		stop:    make(chan struct{}),
+	}
+}
+
+// SetSampling enables suppression of identical DEBUG messages repeated within
+// the window; a "repeated N times" summary is printed once the window expires.
+// A zero window disables sampling.
+func SetSampling(window time.Duration) {
+	if defaultLogger == nil {
+		return
+	}
+	if old := defaultLogger.sampler; old != nil {
+		close(old.stop)
+	}
+	if window <= 0 {
+		defaultLogger.sampler = nil
+		return
+	}
+	s := newSampler(window)
+	defaultLogger.sampler = s
+	go defaultLogger.flushSamples(s)
+}
+
+// flushSamples prints the summaries of expired entries every window, so a
+// message that stops repeating still gets its summary
+func (l *Logger) flushSamples(s *sampler) {
+	ticker := time.NewTicker(s.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			l.printSummaries(s.expire(now), now)
+		}
+	}
+}
+
+// expire drops the entries whose window is over and returns those that had
+// suppressed repeats
+func (s *sampler) expire(now time.Time) []*sampleEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var summaries []*sampleEntry
+	for key, entry := range s.entries {
+		if now.Sub(entry.start) >= s.window {
+			if entry.suppressed > 0 {
+				summaries = append(summaries, entry)
+			}
+			delete(s.entries, key)
+		}
+	}
+	return summaries
+}
+
+// allow reports whether a message should be printed now. It also returns
+// the summary of the message's previous window if it had suppressed repeats.
+func (s *sampler) allow(level Level, module, message string, now time.Time) (bool, []*sampleEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var summaries []*sampleEntry
+
+	key := module + "\x00" + message
+	entry, ok := s.entries[key]
+	if ok && now.Sub(entry.start) < s.window {
+		entry.suppressed++
+		return false, summaries
+	}
+
+	if ok && entry.suppressed > 0 {
+		summaries = append(summaries, entry)
+	}
+
+	if len(s.entries) < maxSampleEntries || ok {
+		s.entries[key] = &sampleEntry{
+			level:   level,
+			module:  module,
+			message: message,
+			start:   now,
+		}
+	}
+
+	return true, summaries
+}
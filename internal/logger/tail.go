@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// Line is a log line kept for the API's log tail
+type Line struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Module  string    `json:"module,omitempty"`
+	Message string    `json:"message"`
+}
+
+// tail keeps the most recent log lines in a ring buffer
+type tail struct {
+	mu    sync.Mutex
+	lines []Line
+	next  int // ring position of the next line
+	full  bool
+	seq   uint64
+}
+
+// EnableTail keeps the last size log lines for Tail
+func EnableTail(size int) {
+	if defaultLogger != nil && size > 0 {
+		defaultLogger.tail = &tail{lines: make([]Line, size)}
+	}
+}
+
+// Tail returns the kept log lines with a sequence number above after, oldest
+// first, and the sequence number of the last line logged (0 if the tail is
+// not enabled). Clients pass that back as after to follow the log.
+func Tail(after uint64) ([]Line, uint64) {
+	if defaultLogger == nil || defaultLogger.tail == nil {
+		return nil, 0
+	}
+	return defaultLogger.tail.since(after)
+}
+
+func (t *tail) add(level Level, module, message string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	t.lines[t.next] = Line{Seq: t.seq, Time: now, Level: levelNames[level], Module: module, Message: message}
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+func (t *tail) since(after uint64) ([]Line, uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ordered := t.lines[:t.next]
+	if t.full {
+		ordered = append(append([]Line(nil), t.lines[t.next:]...), t.lines[:t.next]...)
+	}
+	result := []Line{}
+	for _, line := range ordered {
+		if line.Seq > after {
+			result = append(result, line)
+		}
+	}
+	return result, t.seq
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// echoTimeout is how long a command waits for the device to report the new state
+	echoTimeout = 30 * time.Second
+	// echoBaselineSamples are needed before degradation warnings are raised
+	echoBaselineSamples = 5
+	// echoDegradeFactor is how much slower than typical an echo must be to warn
+	echoDegradeFactor = 3
+	// echoDegradeMin ignores slow echoes below this latency (not worth a warning)
+	echoDegradeMin = time.Second
+	// echoWarnInterval limits degradation warnings per device
+	echoWarnInterval = 10 * time.Minute
+)
+
+// LatencyRecorder stores measured echo latencies (implemented by history.History)
+type LatencyRecorder interface {
+	Add(device, attribute string, value interface{}, timestamp time.Time)
+}
+
+// LatencyAttribute is the history attribute echo latencies are recorded under,
+// e.g. event.history("porch", "command_latency_ms")
+const LatencyAttribute = "command_latency_ms"
+
+// pendingCommand is a command waiting for its state echo
+type pendingCommand struct {
+	attrs  map[string]interface{}
+	sentAt time.Time
+}
+
+// echoStats holds echo latency counters for one device
+type echoStats struct {
+	pending  *pendingCommand
+	count    uint64
+	timeouts uint64
+	total    time.Duration
+	last     time.Duration
+	baseline float64 // moving average in milliseconds
+	warnedAt time.Time
+}
+
+// SetHistory records every measured echo latency in h as LatencyAttribute
+func (r *Registry) SetHistory(h LatencyRecorder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history = h
+}
+
+// ExpectEcho starts timing a command until the device reports one of attrs with
+// the commanded value. A newer command for the same device replaces the old one.
+func (r *Registry) ExpectEcho(id string, attrs map[string]interface{}) {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := r.get(id)
+	d.echo.expire(now)
+	d.echo.pending = &pendingCommand{attrs: attrs, sentAt: now}
+}
+
+// matchEcho completes a pending command if state confirms it; must be called with r.mu held
+func (r *Registry) matchEcho(id string, d *device, state map[string]interface{}, now time.Time) {
+	e := &d.echo
+	e.expire(now)
+	if e.pending == nil || !confirms(e.pending.attrs, state) {
+		return
+	}
+
+	latency := now.Sub(e.pending.sentAt)
+	e.pending = nil
+	e.count++
+	e.total += latency
+	e.last = latency
+
+	ms := float64(latency.Microseconds()) / 1000
+	if r.history != nil {
+		r.history.Add(id, LatencyAttribute, ms, now)
+	}
+
+	if e.count > echoBaselineSamples && latency >= echoDegradeMin &&
+		ms > e.baseline*echoDegradeFactor && now.Sub(e.warnedAt) >= echoWarnInterval {
+		e.warnedAt = now
+		log.Warn("Command latency for %s degraded: %v (typically %.0fms); check the Zigbee network near this device",
+			id, latency.Round(time.Millisecond), e.baseline)
+	}
+
+	// Moving average; the first sample seeds the baseline
+	if e.count == 1 {
+		e.baseline = ms
+	} else {
+		e.baseline = 0.9*e.baseline + 0.1*ms
+	}
+}
+
+// expire drops a pending command that never got its echo
+func (e *echoStats) expire(now time.Time) {
+	if e.pending != nil && now.Sub(e.pending.sentAt) > echoTimeout {
+		e.pending = nil
+		e.timeouts++
+	}
+}
+
+// confirms reports whether state contains at least one commanded attribute with
+// the commanded value (compared loosely: "on" matches "ON", 1 matches 1.0)
+func confirms(attrs, state map[string]interface{}) bool {
+	for attr, want := range attrs {
+		got, ok := state[attr]
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(fmt.Sprint(got), fmt.Sprint(want)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"homescript-server/internal/logger"
+	"sort"
+	"sync"
+	"time"
+)
+
+var log = logger.Module("metrics")
+
+// rateWindow is how many one-minute buckets are kept for message rates
+const rateWindow = 15
+
+// DeviceStats is a snapshot of the counters kept for one device
+type DeviceStats struct {
+	Device         string    `json:"device"`
+	Messages       uint64    `json:"messages"`
+	MessagesPerMin float64   `json:"messages_per_min"` // averaged over the last 15 minutes
+	LastSeen       time.Time `json:"last_seen"`
+	Commands       uint64    `json:"commands"`
+	CommandsFailed uint64    `json:"commands_failed"`
+	SuccessRatio   float64   `json:"success_ratio"` // 1 if no commands were sent
+	AvgLatencyMs   float64   `json:"avg_latency_ms"`
+	LastCommandAt  time.Time `json:"last_command_at"`
+	LastCommandErr string    `json:"last_command_error,omitempty"`
+	// Echo latency: time from publishing a command until the device reports the new state
+	Echoes        uint64  `json:"echoes"`
+	EchoTimeouts  uint64  `json:"echo_timeouts"`
+	AvgEchoMs     float64 `json:"avg_echo_ms"`
+	LastEchoMs    float64 `json:"last_echo_ms"`
+	TypicalEchoMs float64 `json:"typical_echo_ms"` // moving average used for degradation warnings
+}
+
+// device holds the live counters for one device
+type device struct {
+	messages       uint64
+	lastSeen       time.Time
+	buckets        [rateWindow]uint64
+	bucketMinute   int64 // unix minute of the newest bucket
+	commands       uint64
+	commandsFailed uint64
+	latencyTotal   time.Duration
+	lastCommandAt  time.Time
+	lastCommandErr string
+	echo           echoStats
+}
+
+// advance rotates the rate buckets so the newest one covers minute
+func (d *device) advance(minute int64) {
+	if d.bucketMinute == 0 {
+		d.bucketMinute = minute
+		return
+	}
+	shift := minute - d.bucketMinute
+	if shift <= 0 {
+		return
+	}
+	if shift >= rateWindow {
+		d.buckets = [rateWindow]uint64{}
+	} else {
+		copy(d.buckets[:], d.buckets[shift:])
+		for i := rateWindow - int(shift); i < rateWindow; i++ {
+			d.buckets[i] = 0
+		}
+	}
+	d.bucketMinute = minute
+}
+
+// Registry keeps per-device message and command metrics in memory
+type Registry struct {
+	devices map[string]*device
+	history LatencyRecorder
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// New creates an empty metrics registry
+func New() *Registry {
+	return &Registry{
+		devices: make(map[string]*device),
+		now:     time.Now,
+	}
+}
+
+func (r *Registry) get(id string) *device {
+	d, ok := r.devices[id]
+	if !ok {
+		d = &device{}
+		r.devices[id] = d
+	}
+	return d
+}
+
+// RecordMessage counts an inbound state message from a device and completes
+// a pending command echo if the message confirms it
+func (r *Registry) RecordMessage(id string, state map[string]interface{}) {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := r.get(id)
+	d.advance(now.Unix() / 60)
+	d.buckets[rateWindow-1]++
+	d.messages++
+	d.lastSeen = now
+	r.matchEcho(id, d, state, now)
+}
+
+// RecordCommand counts an outbound command and how long publishing it took
+func (r *Registry) RecordCommand(id string, latency time.Duration, err error) {
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d := r.get(id)
+	d.commands++
+	d.latencyTotal += latency
+	d.lastCommandAt = now
+	if err != nil {
+		d.commandsFailed++
+		d.lastCommandErr = err.Error()
+	} else {
+		d.lastCommandErr = ""
+	}
+}
+
+// Device returns a snapshot of one device's metrics
+func (r *Registry) Device(id string) (DeviceStats, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.devices[id]
+	if !ok {
+		return DeviceStats{}, false
+	}
+	return r.snapshot(id, d), true
+}
+
+// Devices returns snapshots of all devices, sorted by ID
+func (r *Registry) Devices() []DeviceStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]DeviceStats, 0, len(r.devices))
+	for id, d := range r.devices {
+		result = append(result, r.snapshot(id, d))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Device < result[j].Device
+	})
+	return result
+}
+
+// snapshot must be called with r.mu held
+func (r *Registry) snapshot(id string, d *device) DeviceStats {
+	now := r.now()
+	d.advance(now.Unix() / 60)
+	d.echo.expire(now)
+
+	var recent uint64
+	for _, n := range d.buckets {
+		recent += n
+	}
+
+	stats := DeviceStats{
+		Device:         id,
+		Messages:       d.messages,
+		MessagesPerMin: float64(recent) / rateWindow,
+		LastSeen:       d.lastSeen,
+		Commands:       d.commands,
+		CommandsFailed: d.commandsFailed,
+		SuccessRatio:   1,
+		LastCommandAt:  d.lastCommandAt,
+		LastCommandErr: d.lastCommandErr,
+		Echoes:         d.echo.count,
+		EchoTimeouts:   d.echo.timeouts,
+		LastEchoMs:     float64(d.echo.last.Microseconds()) / 1000,
+		TypicalEchoMs:  d.echo.baseline,
+	}
+	if d.echo.count > 0 {
+		stats.AvgEchoMs = float64(d.echo.total.Microseconds()) / float64(d.echo.count) / 1000
+	}
+	if d.commands > 0 {
+		stats.SuccessRatio = float64(d.commands-d.commandsFailed) / float64(d.commands)
+		stats.AvgLatencyMs = float64(d.latencyTotal.Microseconds()) / float64(d.commands) / 1000
+	}
+	return stats
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WritePrometheus writes all device metrics in the Prometheus text exposition format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	stats := r.Devices()
+
+	series := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(s DeviceStats) float64
+	}{
+		{"homescript_device_messages_total", "counter", "State messages received from the device",
+			func(s DeviceStats) float64 { return float64(s.Messages) }},
+		{"homescript_device_messages_per_minute", "gauge", "State messages per minute over the last 15 minutes",
+			func(s DeviceStats) float64 { return s.MessagesPerMin }},
+		{"homescript_device_last_seen_timestamp_seconds", "gauge", "Unix time of the last state message",
+			func(s DeviceStats) float64 { return unixSeconds(s) }},
+		{"homescript_device_commands_total", "counter", "Commands sent to the device",
+			func(s DeviceStats) float64 { return float64(s.Commands) }},
+		{"homescript_device_commands_failed_total", "counter", "Commands that failed to publish",
+			func(s DeviceStats) float64 { return float64(s.CommandsFailed) }},
+		{"homescript_device_command_success_ratio", "gauge", "Share of commands that succeeded",
+			func(s DeviceStats) float64 { return s.SuccessRatio }},
+		{"homescript_device_command_latency_avg_seconds", "gauge", "Average command latency",
+			func(s DeviceStats) float64 { return s.AvgLatencyMs / 1000 }},
+		{"homescript_device_echo_latency_avg_seconds", "gauge", "Average time until the device reported a commanded state",
+			func(s DeviceStats) float64 { return s.AvgEchoMs / 1000 }},
+		{"homescript_device_echo_latency_last_seconds", "gauge", "Latest time until the device reported a commanded state",
+			func(s DeviceStats) float64 { return s.LastEchoMs / 1000 }},
+		{"homescript_device_echo_timeouts_total", "counter", "Commands the device never confirmed",
+			func(s DeviceStats) float64 { return float64(s.EchoTimeouts) }},
+	}
+
+	for _, m := range series {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, s := range stats {
+			if _, err := fmt.Fprintf(w, "%s{device=\"%s\"} %g\n", m.name, EscapeLabel(s.Device), m.value(s)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unixSeconds(s DeviceStats) float64 {
+	if s.LastSeen.IsZero() {
+		return 0
+	}
+	return float64(s.LastSeen.UnixMilli()) / 1000
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// EscapeLabel escapes a Prometheus label value
+func EscapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,218 @@
+// Package mirror replicates persistent state and device history to a standby
+// instance or an S3-compatible bucket, so a dead SD card does not take years
+// of accumulated automation state with it. Pushes run in the background and
+// only when something changed since the last successful push.
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/history"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/storage"
+	"os"
+	"sync"
+	"time"
+)
+
+var log = logger.Module("mirror")
+
+// pushTimeout bounds a single push to one target
+const pushTimeout = time.Minute
+
+// Snapshot is what one push carries
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	// Host is the hostname of the instance that took the snapshot
+	Host  string                 `json:"host"`
+	State map[string]interface{} `json:"state"`
+	// Expires holds the expiry of keys set with a TTL
+	Expires map[string]time.Time                  `json:"expires,omitempty"`
+	History map[string]map[string][]history.Entry `json:"history,omitempty"`
+}
+
+// Target receives encoded snapshots
+type Target interface {
+	Push(ctx context.Context, data []byte) error
+	String() string
+}
+
+// Mirror periodically pushes snapshots to its targets
+type Mirror struct {
+	store    *storage.Storage
+	history  *history.History
+	targets  []Target
+	interval time.Duration
+	pushed   map[Target][32]byte // hash of the last snapshot each target accepted
+	mu       sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// New creates a mirror of store and hist (which may be nil) pushing every interval
+func New(store *storage.Storage, hist *history.History, interval time.Duration, targets ...Target) *Mirror {
+	return &Mirror{
+		store:    store,
+		history:  hist,
+		targets:  targets,
+		interval: interval,
+		pushed:   make(map[Target][32]byte),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start pushes now and then every interval until Stop
+func (m *Mirror) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.Push()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Push()
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+	for _, target := range m.targets {
+		log.Info("Mirroring state to %s every %v", target, m.interval)
+	}
+}
+
+// Stop ends the background pushes after a final one
+func (m *Mirror) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+		m.wg.Wait()
+		m.Push()
+	})
+}
+
+// Take captures the current state and history
+func (m *Mirror) Take() (*Snapshot, error) {
+	state, err := m.store.All()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	// Only keys read above, in case one was set with a TTL meanwhile
+	expires := m.store.Expiries()
+	for key := range expires {
+		if _, ok := state[key]; !ok {
+			delete(expires, key)
+		}
+	}
+	host, _ := os.Hostname()
+	snapshot := &Snapshot{Host: host, State: state, Expires: expires}
+	if m.history != nil {
+		snapshot.History = m.history.Snapshot()
+	}
+	return snapshot, nil
+}
+
+// Push sends the current snapshot to every target that hasn't accepted it yet;
+// failed targets are retried on the next push
+func (m *Mirror) Push() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot, err := m.Take()
+	if err != nil {
+		log.Error("%v", err)
+		return
+	}
+	// Hash without the timestamp so an unchanged state is not pushed again
+	unstamped, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Error("Failed to encode snapshot: %v", err)
+		return
+	}
+	hash := sha256.Sum256(unstamped)
+
+	snapshot.Time = time.Now()
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Error("Failed to encode snapshot: %v", err)
+		return
+	}
+
+	for _, target := range m.targets {
+		if m.pushed[target] == hash {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		err := target.Push(ctx, data)
+		cancel()
+		if err != nil {
+			log.Warn("Failed to mirror state to %s: %v", target, err)
+			continue
+		}
+		m.pushed[target] = hash
+		log.Debug("Mirrored %d key(s) to %s (%d bytes)", len(snapshot.State), target, len(data))
+	}
+}
+
+// Decode parses an encoded snapshot
+func Decode(data []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if snapshot.State == nil {
+		return nil, fmt.Errorf("invalid snapshot: no state")
+	}
+	return &snapshot, nil
+}
+
+// Apply replaces the state in store, and the history in hist if not nil,
+// with a snapshot. Keys keep their expiry; those already past it are left out.
+func Apply(snapshot *Snapshot, store *storage.Storage, hist *history.History) error {
+	if err := store.Replace(snapshot.State, snapshot.Expires); err != nil {
+		return fmt.Errorf("failed to restore state: %w", err)
+	}
+	if hist != nil && snapshot.History != nil {
+		hist.Restore(snapshot.History)
+	}
+	return nil
+}
+
+// Receiver applies snapshots pushed by a primary to a standby
+type Receiver struct {
+	store   *storage.Storage
+	history *history.History
+	mu      sync.Mutex
+	last    time.Time
+}
+
+// NewReceiver creates a receiver updating store and hist
+func NewReceiver(store *storage.Storage, hist *history.History) *Receiver {
+	return &Receiver{store: store, history: hist}
+}
+
+// Receive decodes and applies a pushed snapshot; older snapshots than the
+// last applied one are ignored
+func (r *Receiver) Receive(data []byte) (*Snapshot, error) {
+	snapshot, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if snapshot.Time.Before(r.last) {
+		return nil, fmt.Errorf("snapshot from %s is older than the last one applied", snapshot.Time.Format(time.RFC3339))
+	}
+	if err := Apply(snapshot, r.store, r.history); err != nil {
+		return nil, err
+	}
+	r.last = snapshot.Time
+	log.Info("Applied snapshot from %s taken %s (%d key(s))", snapshot.Host, snapshot.Time.Format(time.RFC3339), len(snapshot.State))
+	return snapshot, nil
+}
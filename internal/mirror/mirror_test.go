@@ -0,0 +1,76 @@
+package mirror
+
+import (
+	"encoding/json"
+	"homescript-server/internal/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	store, err := storage.New(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestSnapshotKeepsExpiries(t *testing.T) {
+	primary := openStore(t)
+	if err := primary.Set("mode", "away"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.SetTTL("presence.kitchen", true, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(primary, nil, time.Minute)
+	snapshot, err := m.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unchanged state encodes the same, so it isn't pushed again
+	again, err := m.Take()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := json.Marshal(snapshot)
+	second, _ := json.Marshal(again)
+	if string(first) != string(second) {
+		t.Errorf("snapshots of unchanged state differ:\n%s\n%s", first, second)
+	}
+
+	// A cooldown that ran out between push and failover is left out
+	snapshot.State["cooldown.door"] = true
+	snapshot.Expires["cooldown.door"] = time.Now().Add(-time.Second)
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby := openStore(t)
+	if err := Apply(decoded, standby, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, ok := standby.TTL("presence.kitchen"); !ok || ttl <= 59*time.Minute {
+		t.Errorf("presence.kitchen TTL = %v, %v on the standby, want about an hour", ttl, ok)
+	}
+	if _, ok := standby.TTL("mode"); ok {
+		t.Error("mode has a TTL on the standby, want none")
+	}
+	all, err := standby.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := all["cooldown.door"]; ok || len(all) != 2 {
+		t.Errorf("standby state = %v, want mode and presence.kitchen", all)
+	}
+}
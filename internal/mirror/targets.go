@@ -0,0 +1,167 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPTarget pushes snapshots to the /mirror endpoint of a standby instance
+type HTTPTarget struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// NewHTTPTarget creates a target posting to url with a Bearer token
+func NewHTTPTarget(url, token string) *HTTPTarget {
+	return &HTTPTarget{URL: url, Token: token, Client: &http.Client{}}
+}
+
+func (t *HTTPTarget) String() string {
+	return t.URL
+}
+
+// Push posts the snapshot to the standby
+func (t *HTTPTarget) Push(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	return do(t.Client, req)
+}
+
+// S3Target uploads snapshots as one object to an S3-compatible bucket
+// (AWS, MinIO, Backblaze B2, ...), using path-style URLs and SigV4 signing
+type S3Target struct {
+	// Endpoint is the service URL, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint  string
+	Bucket    string
+	Key       string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func (t *S3Target) String() string {
+	return fmt.Sprintf("s3://%s/%s", t.Bucket, t.Key)
+}
+
+// Push uploads the snapshot, replacing the previous one
+func (t *S3Target) Push(ctx context.Context, data []byte) error {
+	endpoint, err := url.Parse(strings.TrimSuffix(t.Endpoint, "/"))
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	path := "/" + t.Bucket + "/" + strings.TrimPrefix(t.Key, "/")
+	endpoint.Path += path
+	endpoint.RawPath = escapePath(endpoint.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	t.sign(req, data, time.Now())
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return do(client, req)
+}
+
+// sign adds AWS Signature Version 4 headers to req
+func (t *S3Target) sign(req *http.Request, payload []byte, now time.Time) {
+	region := t.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+t.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.AccessKey, scope, signedHeaders, signature))
+}
+
+// escapePath percent-encodes everything but unreserved characters and
+// slashes, as SigV4 expects
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// do sends req and turns non-2xx responses into errors
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -8,51 +8,115 @@ import (
 	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
 	"io"
-	"log"
+	stdlog "log"
 	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+var log = logger.Module("mqtt")
+
 // Client wraps MQTT client with event routing
 type Client struct {
-	client        mqtt.Client
-	router        *events.Router
-	deviceManager *devices.Manager
-	brokerURL     string
+	client         mqtt.Client
+	routing        mqtt.Client // client, with shared subscriptions if a group is set
+	router         *events.Router
+	deviceManager  *devices.Manager
+	brokerURL      string
+	onRename       RenameHandler
+	statusTopic    string
+	tracker        *trackingClient // nil for connections made elsewhere
+	consolidateMin int
+	consolidated   *consolidator
+	topics         types.Topics
+	fpsThresholds  FPSThresholds
+	degraded       map[string]bool   // Frigate cameras below the FPS thresholds
+	snapshots      *frigateSnapshots // attributes Frigate snapshots to their events
+	ha             *haDiscovery      // nil unless SubscribeHADiscovery was called
+	connected      bool              // connected before, so OnConnect is a reconnect
+	seed           seeding           // startup window filling states without events
+	mu             sync.Mutex
 }
 
+// ReconnectedEventType is the system event routed to
+// events/system/mqtt_reconnected/ after the broker connection came back
+const ReconnectedEventType = "mqtt_reconnected"
+
 // Config holds MQTT connection configuration
 type Config struct {
 	Broker   string
 	ClientID string
 	Username string
 	Password string
+	// CleanSession discards the broker-side session on connect. Keep it
+	// false with a stable ClientID so QoS 1 messages queued while the server
+	// was down are delivered after a restart (see DeliverQueued).
+	CleanSession bool
+	// TopicPrefix is prepended to every topic published or subscribed (e.g. "site1")
+	TopicPrefix string
+	// CACert, ClientCert and ClientKey are PEM files for ssl:// brokers
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify accepts any broker certificate (for testing only)
+	InsecureSkipVerify bool
+	// StatusTopic receives a retained "online" on connect and "offline" on
+	// shutdown or, as Last Will, when the connection dies (disabled if empty)
+	StatusTopic string
+	// Topics are the integration base topics (types.DefaultTopics if unset)
+	Topics types.Topics
+	// SharedGroup makes the raw topic subscriptions of Subscribe shared
+	// subscriptions of this group, so several instances split the load
+	// (disabled if empty)
+	SharedGroup string
+	// ConsolidateMin subscribes device state topics sharing a wildcard
+	// filter ("zigbee2mqtt/+") through that filter once at least this many
+	// devices use it (disabled if 0)
+	ConsolidateMin int
 }
 
-// NewClient creates a new MQTT client
+// Server availability payloads published on Config.StatusTopic
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// NewClient creates a new MQTT client. The router and device manager may be
+// nil and attached later with SetRouter and SetDeviceManager, before
+// SubscribeToDevices.
 func NewClient(cfg Config, router *events.Router, dm *devices.Manager) (*Client, error) {
 	// Disable MQTT library internal logging (we'll handle it ourselves)
-	mqtt.ERROR = log.New(io.Discard, "", 0)
-	mqtt.CRITICAL = log.New(io.Discard, "", 0)
-	mqtt.WARN = log.New(io.Discard, "", 0)
+	mqtt.ERROR = stdlog.New(io.Discard, "", 0)
+	mqtt.CRITICAL = stdlog.New(io.Discard, "", 0)
+	mqtt.WARN = stdlog.New(io.Discard, "", 0)
 
-	// Ensure broker URL has tcp:// prefix
+	// Ensure broker URL has a scheme (ssl:// when TLS options are given)
 	brokerURL := cfg.Broker
-	if !strings.HasPrefix(brokerURL, "tcp://") && !strings.HasPrefix(brokerURL, "ssl://") {
-		brokerURL = "tcp://" + brokerURL
+	if !strings.Contains(brokerURL, "://") {
+		if cfg.hasTLSOptions() {
+			brokerURL = "ssl://" + brokerURL
+		} else {
+			brokerURL = "tcp://" + brokerURL
+		}
 	}
 
 	mqttClient := &Client{
-		router:        router,
-		deviceManager: dm,
-		brokerURL:     brokerURL,
+		router:         router,
+		deviceManager:  dm,
+		brokerURL:      brokerURL,
+		statusTopic:    cfg.StatusTopic,
+		consolidateMin: cfg.ConsolidateMin,
+		consolidated:   newConsolidator(),
+		topics:         cfg.Topics.WithDefaults(),
+		degraded:       make(map[string]bool),
+		snapshots:      newFrigateSnapshots(),
 	}
 
 	opts := mqtt.NewClientOptions()
 
-	logger.Debug("Connecting to MQTT broker at %s...", brokerURL)
+	log.Debug("Connecting to MQTT broker at %s...", brokerURL)
 	opts.AddBroker(brokerURL)
 	opts.SetClientID(cfg.ClientID)
 
@@ -61,41 +125,68 @@ func NewClient(cfg Config, router *events.Router, dm *devices.Manager) (*Client,
 		opts.SetPassword(cfg.Password)
 	}
 
+	if isTLSBroker(brokerURL) {
+		tlsConfig, err := cfg.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	} else if cfg.hasTLSOptions() {
+		log.Warn("TLS options ignored for %s, use an ssl:// broker URL", brokerURL)
+	}
+
 	opts.SetAutoReconnect(true)
 	opts.SetMaxReconnectInterval(60 * time.Second)
 	opts.SetConnectTimeout(10 * time.Second)
 	opts.SetWriteTimeout(10 * time.Second)
 	opts.SetPingTimeout(10 * time.Second)
 	opts.SetKeepAlive(60 * time.Second)
-	opts.SetCleanSession(false) // Persist session to keep subscriptions
+	opts.SetCleanSession(cfg.CleanSession)
+	if !cfg.CleanSession {
+		// A resumed session delivers queued messages before anything is
+		// subscribed again; hold them until DeliverQueued
+		opts.SetDefaultPublishHandler(func(c mqtt.Client, msg mqtt.Message) {
+			mqttClient.tracker.hold(msg)
+		})
+	}
+
+	// The broker marks the server offline if it disappears without saying goodbye
+	if cfg.StatusTopic != "" {
+		opts.SetWill(PrefixTopic(cfg.TopicPrefix, cfg.StatusTopic), StatusOffline, 1, true)
+	}
 
 	opts.OnConnect = func(c mqtt.Client) {
-		logger.Info("MQTT connected")
-
-		// Resubscribe to all devices after reconnection
-		if mqttClient.deviceManager != nil {
-			go func() {
-				time.Sleep(100 * time.Millisecond) // Small delay to ensure connection is stable
-				if err := mqttClient.SubscribeToDevices(); err != nil {
-					logger.Error("Failed to resubscribe after reconnect: %v", err)
-				} else {
-					logger.Info("Resubscribed to all devices after reconnection")
-				}
-			}()
+		log.Info("MQTT connected")
+		mqttClient.publishStatus(StatusOnline)
+
+		mqttClient.mu.Lock()
+		reconnect := mqttClient.connected
+		mqttClient.connected = true
+		mqttClient.mu.Unlock()
+		if reconnect {
+			mqttClient.onReconnect()
 		}
 	}
 
 	opts.OnConnectionLost = func(c mqtt.Client, err error) {
-		logger.Error("MQTT connection lost: %v", err)
-		logger.Info("Auto-reconnect is enabled, will attempt to reconnect...")
+		log.Error("MQTT connection lost: %v", err)
+		log.Info("Auto-reconnect is enabled, will attempt to reconnect...")
 	}
 
 	opts.OnReconnecting = func(c mqtt.Client, opts *mqtt.ClientOptions) {
-		logger.Info("Reconnecting to MQTT broker...")
+		log.Info("Reconnecting to MQTT broker...")
 	}
 
 	client := mqtt.NewClient(opts)
-	mqttClient.client = client
+	mqttClient.tracker = newTrackingClient(client)
+	mqttClient.client = newPrefixedClient(mqttClient.tracker, cfg.TopicPrefix)
+	mqttClient.routing = newPrefixedClient(newSharedClient(mqttClient.tracker, cfg.SharedGroup), cfg.TopicPrefix)
+	if cfg.TopicPrefix != "" {
+		log.Info("Using topic prefix: %s/", strings.Trim(cfg.TopicPrefix, "/"))
+	}
+	if cfg.SharedGroup != "" {
+		log.Info("Using shared subscriptions: $share/%s/", strings.Trim(cfg.SharedGroup, "/"))
+	}
 
 	token := client.Connect()
 
@@ -108,34 +199,239 @@ func NewClient(cfg Config, router *events.Router, dm *devices.Manager) (*Client,
 		return nil, fmt.Errorf("failed to connect to MQTT: %w", token.Error())
 	}
 
-	logger.Debug("MQTT connection established successfully")
+	log.Debug("MQTT connection established successfully")
+	if connect, ok := token.(*mqtt.ConnectToken); ok && connect.SessionPresent() {
+		log.Info("Resumed MQTT session %s", cfg.ClientID)
+	}
 
 	return mqttClient, nil
 }
 
+// SetRouter attaches the router that device and topic events are routed to
+func (c *Client) SetRouter(router *events.Router) {
+	c.router = router
+}
+
+// SetDeviceManager attaches the device manager whose devices are subscribed
+// and whose states are updated
+func (c *Client) SetDeviceManager(dm *devices.Manager) {
+	c.deviceManager = dm
+}
+
+// onReconnect renews every subscription (devices, availability, topics,
+// bridge, streams), in case the broker lost the session, and routes a
+// system/mqtt_reconnected event
+func (c *Client) onReconnect() {
+	renewed, err := c.tracker.resubscribe()
+	if err != nil {
+		log.Error("%v", err)
+	}
+	log.Info("Resubscribed to %d topic(s) after reconnection", renewed)
+
+	if c.router == nil {
+		return
+	}
+	c.router.RouteEvent(&types.Event{
+		Source: "system",
+		Type:   ReconnectedEventType,
+		Data: map[string]interface{}{
+			"broker":        c.brokerURL,
+			"subscriptions": renewed,
+		},
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	})
+}
+
+// DeliverQueued hands the messages the broker delivered from a resumed
+// session before their topics were subscribed to the handlers subscribed
+// since. Call it once every subscription is in place; later messages
+// without a handler are dropped.
+func (c *Client) DeliverQueued() {
+	delivered, dropped := c.tracker.release()
+	if delivered > 0 {
+		log.Info("Delivered %d message(s) queued by the broker while the server was down", delivered)
+	}
+	if dropped > 0 {
+		log.Warn("Dropped %d queued message(s) without a subscribed handler", dropped)
+	}
+}
+
+// NewClientFromConnection wraps an already connected MQTT client, such as
+// the in-memory broker of the test harness, instead of dialing a broker
+func NewClientFromConnection(client mqtt.Client, router *events.Router, dm *devices.Manager) *Client {
+	return &Client{
+		client:        client,
+		routing:       client,
+		router:        router,
+		deviceManager: dm,
+		consolidated:  newConsolidator(),
+		topics:        types.DefaultTopics(),
+		degraded:      make(map[string]bool),
+		snapshots:     newFrigateSnapshots(),
+	}
+}
+
 // SubscribeToDevices subscribes to state topics for all devices
 func (c *Client) SubscribeToDevices() error {
 	devices := c.deviceManager.ListDevices()
 
+	var subs []stateSubscription
 	for _, dev := range devices {
+		// Templated devices may report on several topics, each parsed its own way
+		if len(dev.StateTopics) > 0 {
+			subs = append(subs, c.templateSubscriptions(dev)...)
+			continue
+		}
+
 		topic := dev.MQTT.StateTopic
 
 		// Skip devices without state_topic (some HA devices may not have it)
 		if topic == "" {
-			logger.Debug("Skipping device %s: no state_topic configured", dev.ID)
+			log.Debug("Skipping device %s: no state_topic configured", dev.ID)
 			continue
 		}
 
-		token := c.client.Subscribe(topic, 0, c.makeDeviceHandler(dev))
-		if token.Wait() && token.Error() != nil {
-			logger.Warn("Failed to subscribe to %s: %v", topic, token.Error())
-			continue
+		subs = append(subs, stateSubscription{
+			topic:   topic,
+			qos:     dev.MQTT.QoS,
+			handler: c.makeDeviceHandler(dev),
+			desc:    "device: " + dev.ID,
+		})
+	}
+	c.subscribeStates(subs)
+	c.refreshTemplated(devices)
+
+	c.subscribeAvailability(devices)
+	c.subscribeFrigateStats(devices)
+	c.subscribeFrigateEvents(devices)
+	return nil
+}
+
+// SubscribeDevice follows the state and availability of a device added while
+// the server runs, and asks templated firmware for its current state
+func (c *Client) SubscribeDevice(dev *types.Device) {
+	if len(dev.StateTopics) > 0 {
+		c.subscribeStates(c.templateSubscriptions(dev))
+		c.refreshTemplated([]*types.Device{dev})
+	} else if dev.MQTT.StateTopic != "" {
+		c.subscribeState(stateSubscription{
+			topic:   dev.MQTT.StateTopic,
+			qos:     dev.MQTT.QoS,
+			handler: c.makeDeviceHandler(dev),
+			desc:    "device: " + dev.ID,
+		})
+	}
+	c.subscribeDeviceAvailability(dev)
+}
+
+// UnsubscribeDevice stops following the state and availability of a device
+// removed or replaced while the server runs
+func (c *Client) UnsubscribeDevice(dev *types.Device) {
+	if len(dev.StateTopics) > 0 {
+		for _, sub := range c.templateSubscriptions(dev) {
+			c.unsubscribeState(sub.topic)
+		}
+	} else if dev.MQTT.StateTopic != "" {
+		c.unsubscribeState(dev.MQTT.StateTopic)
+	}
+	if topic := c.deviceManager.AvailabilityTopic(dev); topic != "" {
+		if token := c.client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
+			log.Debug("Failed to unsubscribe from %s: %v", topic, token.Error())
+		}
+	}
+}
+
+// subscribeAvailability subscribes to per-device availability topics and, if any
+// Zigbee2MQTT devices exist, to the bridge LWT of their Zigbee2MQTT instances
+func (c *Client) subscribeAvailability(devs []*types.Device) {
+	zigbee := make(map[string][]*types.Device) // base topic -> devices
+	for _, dev := range devs {
+		if base, ok := c.deviceManager.Zigbee2MQTTBase(dev); ok {
+			zigbee[base] = append(zigbee[base], dev)
 		}
 
-		logger.Debug("Subscribed to device: %s (%s)", dev.ID, topic)
+		c.subscribeDeviceAvailability(dev)
 	}
 
-	return nil
+	for base, devs := range zigbee {
+		c.subscribeBridgeState(base, devs)
+		c.subscribeRenames(base)
+	}
+}
+
+// subscribeBridgeState follows the LWT of one Zigbee2MQTT instance: when the
+// bridge goes away, none of its devices are reachable
+func (c *Client) subscribeBridgeState(base string, zigbee []*types.Device) {
+	topic := base + "/" + devices.Zigbee2MQTTBridgeState
+	token := c.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		online, ok := devices.ParseAvailability(msg.Payload())
+		if !ok || online {
+			return // devices report their own availability once the bridge is back
+		}
+		log.Warn("Zigbee2MQTT bridge %s is offline, marking %d device(s) offline", base, len(zigbee))
+		correlationID := types.NewCorrelationID()
+		for _, dev := range zigbee {
+			c.updateAvailability(dev, false, msg.Topic(), correlationID)
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Warn("Failed to subscribe to %s: %v", topic, token.Error())
+	}
+}
+
+// subscribeDeviceAvailability follows the availability topic of one device, if it has one
+func (c *Client) subscribeDeviceAvailability(dev *types.Device) {
+	topic := c.deviceManager.AvailabilityTopic(dev)
+	if topic == "" {
+		return
+	}
+
+	token := c.client.Subscribe(topic, 0, c.makeAvailabilityHandler(dev))
+	if token.Wait() && token.Error() != nil {
+		log.Warn("Failed to subscribe to %s: %v", topic, token.Error())
+	}
+}
+
+// makeAvailabilityHandler parses messages on a device's availability topic
+func (c *Client) makeAvailabilityHandler(dev *types.Device) mqtt.MessageHandler {
+	return func(_ mqtt.Client, msg mqtt.Message) {
+		online, ok := devices.ParseAvailability(msg.Payload())
+		if !ok {
+			log.Debug("Unknown availability payload from %s: %s", dev.ID, string(msg.Payload()))
+			return
+		}
+		c.updateAvailability(dev, online, msg.Topic(), types.NewCorrelationID())
+	}
+}
+
+// updateAvailability records device availability and routes an availability event on change
+func (c *Client) updateAvailability(dev *types.Device, online bool, topic, correlationID string) {
+	if c.deviceManager == nil || !c.deviceManager.SetAvailability(dev.ID, online) {
+		return
+	}
+	if c.router == nil {
+		return
+	}
+
+	value := "offline"
+	if online {
+		value = "online"
+	}
+	c.router.RouteEvent(&types.Event{
+		Source:    "device",
+		Type:      "availability",
+		Device:    dev.ID,
+		Attribute: devices.AvailabilityAttribute,
+		Area:      dev.Area,
+		Topic:     topic,
+		Data: map[string]interface{}{
+			devices.AvailabilityAttribute: value,
+			"online":                      online,
+		},
+		Timestamp:     time.Now(),
+		CorrelationID: correlationID,
+	})
 }
 
 func (c *Client) makeDeviceHandler(dev *types.Device) mqtt.MessageHandler {
@@ -149,14 +445,14 @@ func (c *Client) makeDeviceHandler(dev *types.Device) mqtt.MessageHandler {
 			if dev.Type == "camera" && dev.Vendor == "Frigate NVR" {
 				c.handleFrigateSnapshot(dev, topic, payload)
 			} else {
-				logger.Debug("Skipping binary message from %s (size: %d bytes)", dev.ID, len(payload))
+				log.Debug("Skipping binary message from %s (size: %d bytes)", dev.ID, len(payload))
 			}
 			return
 		}
 
 		// Skip other large binary data
 		if len(payload) > 10000 {
-			logger.Debug("Skipping large message from %s (size: %d bytes)", dev.ID, len(payload))
+			log.Debug("Skipping large message from %s (size: %d bytes)", dev.ID, len(payload))
 			return
 		}
 
@@ -172,75 +468,179 @@ func (c *Client) makeDeviceHandler(dev *types.Device) mqtt.MessageHandler {
 			// For Zigbee2MQTT: just use the whole message as-is
 			if dev.Type == "camera" && dev.Vendor == "Frigate NVR" {
 				// Parse Frigate topic: frigate/CameraName/attribute/state
-				parts := strings.Split(topic, "/")
-				if len(parts) >= 3 {
-					attr := parts[2] // attribute name
+				parts := frigateLevels(dev, topic)
+				if len(parts) >= 1 {
+					attr := parts[0] // attribute name
 
 					// Create state with single attribute
 					state = map[string]interface{}{
 						attr: payloadStr,
 					}
 
-					logger.Debug("Parsed Frigate simple value: %s = %s", attr, payloadStr)
+					log.Debug("Parsed Frigate simple value: %s = %s", attr, payloadStr)
 				} else {
-					logger.Debug("Skipping unknown Frigate topic format: %s", topic)
+					log.Debug("Skipping unknown Frigate topic format: %s", topic)
 					return
 				}
 			} else {
 				// For non-Frigate devices, skip non-JSON messages
-				logger.Debug("Skipping non-JSON message from %s: %v", dev.ID, err)
+				log.Debug("Skipping non-JSON message from %s: %v", dev.ID, err)
 				return
 			}
 		}
 
-		// Update device state if device manager is available
-		if c.deviceManager != nil {
-			c.deviceManager.UpdateState(dev.ID, state)
+		if c.seedOnly(dev, msg.Retained()) {
+			c.seedState(dev, state)
+			return
 		}
+		c.handleDeviceState(dev, topic, state)
 
-		// Route events only if router is available
-		if c.router == nil {
-			return
+		// Note: We don't create a general MQTT event for device messages
+		// to avoid duplicate script execution. Device-specific scripts
+		// are already triggered above. If you need raw MQTT handling,
+		// subscribe to the topic directly with SubscribeToTopic().
+	}
+}
+
+// templateSubscriptions returns one subscription per state topic of a
+// templated device
+func (c *Client) templateSubscriptions(dev *types.Device) []stateSubscription {
+	var topics []string
+	byTopic := make(map[string][]*types.TemplateState)
+	for _, st := range dev.StateTopics {
+		if byTopic[st.Topic] == nil {
+			topics = append(topics, st.Topic)
+		}
+		byTopic[st.Topic] = append(byTopic[st.Topic], st)
+	}
+
+	subs := make([]stateSubscription, 0, len(topics))
+	for _, topic := range topics {
+		subs = append(subs, stateSubscription{
+			topic:   topic,
+			qos:     dev.MQTT.QoS,
+			handler: c.makeTemplateHandler(dev, byTopic[topic]),
+			desc:    "templated device: " + dev.ID,
+		})
+	}
+	return subs
+}
+
+// refreshTemplated publishes the refresh messages of templated devices, which
+// make firmware that only reports changes send its current state
+func (c *Client) refreshTemplated(devs []*types.Device) {
+	for _, dev := range devs {
+		for _, cmd := range dev.Refresh {
+			token := c.client.Publish(cmd.Topic, dev.MQTT.QoS, false, cmd.Payload)
+			if token.Wait() && token.Error() != nil {
+				log.Warn("Failed to request state of %s: %v", dev.ID, token.Error())
+			}
 		}
+	}
+}
 
-		// Create events for each changed attribute
-		for attr, value := range state {
-			// Skip non-attribute fields
-			if attr == "linkquality" || attr == "last_seen" {
+// makeTemplateHandler parses messages on one state topic of a templated
+// device; entries sharing the topic each contribute their attributes
+func (c *Client) makeTemplateHandler(dev *types.Device, states []*types.TemplateState) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		state := make(map[string]interface{})
+		for _, st := range states {
+			parsed, ok := devices.ParseTemplateState(st, msg.Payload())
+			if !ok {
 				continue
 			}
-
-			event := &types.Event{
-				Source:    "device",
-				Type:      "state_change",
-				Device:    dev.ID,
-				Attribute: attr,
-				Topic:     msg.Topic(),
-				Data: map[string]interface{}{
-					attr: value,
-				},
-				Timestamp: time.Now(),
+			for k, v := range parsed {
+				state[k] = v
 			}
+		}
+		if len(state) == 0 {
+			log.Debug("Skipping message from %s on %s: no attributes matched", dev.ID, msg.Topic())
+			return
+		}
+		if c.seedOnly(dev, msg.Retained()) {
+			c.seedState(dev, state)
+			return
+		}
+		c.handleDeviceState(dev, msg.Topic(), state)
+	}
+}
 
-			// Copy all state data to event
-			for k, v := range state {
-				if k != attr {
-					event.Data[k] = v
-				}
-			}
+// handleDeviceState normalizes a parsed state payload, updates the device
+// manager and routes a state_change event per attribute
+func (c *Client) handleDeviceState(dev *types.Device, topic string, state map[string]interface{}) {
+	// Normalize vendor-specific payload conventions
+	devices.NormalizePayload(dev, state)
+
+	// Update device state if device manager is available, remembering what
+	// was known before for first_report
+	var old map[string]interface{}
+	if c.deviceManager != nil {
+		old, _ = c.deviceManager.Get(dev.ID)
+		c.deviceManager.UpdateState(dev.ID, state)
+	}
 
-			c.router.RouteEvent(event)
+	// Route events only if router is available
+	if c.router == nil {
+		return
+	}
+
+	// All events from one message share a correlation ID
+	correlationID := types.NewCorrelationID()
+	log.Debug("[%s] Message on %s for %s", correlationID, topic, dev.ID)
+
+	// Create events for each changed attribute
+	for attr, value := range state {
+		// Skip non-attribute fields
+		if attr == "linkquality" || attr == "last_seen" {
+			continue
 		}
 
-		// Note: We don't create a general MQTT event for device messages
-		// to avoid duplicate script execution. Device-specific scripts
-		// are already triggered above. If you need raw MQTT handling,
-		// subscribe to the topic directly with SubscribeToTopic().
+		event := &types.Event{
+			Source:    "device",
+			Type:      "state_change",
+			Device:    dev.ID,
+			Attribute: attr,
+			Area:      dev.Area,
+			Topic:     topic,
+			Data: map[string]interface{}{
+				attr: value,
+			},
+			Timestamp:     time.Now(),
+			CorrelationID: correlationID,
+		}
+		if _, known := old[attr]; !known {
+			event.FirstReport = true
+		}
+
+		// Copy all state data to event
+		for k, v := range state {
+			if k != attr {
+				event.Data[k] = v
+			}
+		}
+
+		c.router.RouteEvent(event)
 	}
 }
 
+// Subscription is a raw topic subscription routed as "mqtt" events to
+// events/mqtt/<topic>/, or events/mqtt/<handler>/ when Handler is set
+type Subscription struct {
+	Topic   string
+	Handler string
+	QoS     byte
+}
+
 // SubscribeToTopic subscribes to a specific MQTT topic
 func (c *Client) SubscribeToTopic(topic string) error {
+	return c.Subscribe(Subscription{Topic: topic})
+}
+
+// Subscribe subscribes to a topic or filter, routing every message as an
+// "mqtt" event (JSON payloads as event.data, others as event.data.payload).
+// With a shared group only one instance of the group gets each message.
+func (c *Client) Subscribe(sub Subscription) error {
+	handlerDir := strings.Trim(sub.Handler, "/")
 	handler := func(client mqtt.Client, msg mqtt.Message) {
 		var data map[string]interface{}
 		if err := json.Unmarshal(msg.Payload(), &data); err != nil {
@@ -256,27 +656,34 @@ func (c *Client) SubscribeToTopic(topic string) error {
 		}
 
 		event := &types.Event{
-			Source:    "mqtt",
-			Type:      "message",
-			Topic:     msg.Topic(),
-			Data:      data,
-			Timestamp: time.Now(),
+			Source:        "mqtt",
+			Type:          "message",
+			Topic:         msg.Topic(),
+			Handler:       handlerDir,
+			Data:          data,
+			Timestamp:     time.Now(),
+			CorrelationID: types.NewCorrelationID(),
 		}
 
 		c.router.RouteEvent(event)
 	}
 
-	token := c.client.Subscribe(topic, 0, handler)
+	token := c.routing.Subscribe(sub.Topic, sub.QoS, handler)
 	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+		return fmt.Errorf("failed to subscribe to %s: %w", sub.Topic, token.Error())
 	}
 
-	logger.Info("Subscribed to topic: %s", topic)
+	if handlerDir != "" {
+		log.Info("Subscribed to topic: %s (events/mqtt/%s/)", sub.Topic, handlerDir)
+	} else {
+		log.Info("Subscribed to topic: %s", sub.Topic)
+	}
 	return nil
 }
 
-// Publish publishes a message to a topic
-func (c *Client) Publish(topic string, payload interface{}) error {
+// Publish publishes a message to a topic; strings and []byte are sent as
+// is, anything else as JSON
+func (c *Client) Publish(topic string, payload interface{}, qos byte, retain bool) error {
 	var data []byte
 	var err error
 
@@ -292,7 +699,7 @@ func (c *Client) Publish(topic string, payload interface{}) error {
 		}
 	}
 
-	token := c.client.Publish(topic, 0, false, data)
+	token := c.client.Publish(topic, qos, retain, data)
 	if token.Wait() && token.Error() != nil {
 		return fmt.Errorf("failed to publish: %w", token.Error())
 	}
@@ -300,54 +707,90 @@ func (c *Client) Publish(topic string, payload interface{}) error {
 	return nil
 }
 
+// frigateLevels returns the levels of a Frigate camera topic below the
+// camera (its command topic, <base>/<camera>), whatever the base topic is
+func frigateLevels(dev *types.Device, topic string) []string {
+	camera := dev.MQTT.CommandTopic
+	if camera == "" || !strings.HasPrefix(topic, camera+"/") {
+		// Default layout: frigate/<camera>/...
+		parts := strings.Split(topic, "/")
+		if len(parts) < 3 {
+			return nil
+		}
+		return parts[2:]
+	}
+	return strings.Split(strings.TrimPrefix(topic, camera+"/"), "/")
+}
+
 // handleFrigateSnapshot processes JPEG snapshot from Frigate
 func (c *Client) handleFrigateSnapshot(dev *types.Device, topic string, payload []byte) {
 	// Parse topic: frigate/CameraName/ObjectType/snapshot
-	parts := strings.Split(topic, "/")
-	if len(parts) < 4 || parts[3] != "snapshot" {
-		logger.Debug("Skipping non-snapshot Frigate binary topic: %s", topic)
+	parts := frigateLevels(dev, topic)
+	if len(parts) < 2 || parts[1] != "snapshot" {
+		log.Debug("Skipping non-snapshot Frigate binary topic: %s", topic)
 		return
 	}
 
-	objectType := parts[2] // person, car, dog, etc
+	objectType := parts[0] // person, car, dog, etc
 
-	logger.Debug("Received %s snapshot from %s (size: %d bytes)", objectType, dev.ID, len(payload))
+	log.Debug("Received %s snapshot from %s (size: %d bytes)", objectType, dev.ID, len(payload))
 
 	// Route event only if router is available
 	if c.router == nil {
 		return
 	}
 
-	// Create event for snapshot
-	event := &types.Event{
-		Source:    "device",
-		Type:      "snapshot",
-		Device:    dev.ID,
-		Attribute: objectType, // "person", "car", etc
-		Topic:     topic,
-		Data: map[string]interface{}{
-			"object_type": objectType,
-			"snapshot":    payload, // Raw JPEG bytes
-			"size":        len(payload),
-		},
-		Timestamp: time.Now(),
-	}
-
-	c.router.RouteEvent(event)
+	// Snapshots of the same Frigate event after the first are updates
+	key := dev.ID + "/" + objectType
+	c.snapshots.snapshot(key, func(eventID string, update bool) {
+		c.router.RouteEvent(&types.Event{
+			Source:    "device",
+			Type:      "snapshot",
+			Device:    dev.ID,
+			Attribute: objectType, // "person", "car", etc
+			Area:      dev.Area,
+			Topic:     topic,
+			Data: map[string]interface{}{
+				"object_type": objectType,
+				"snapshot":    payload, // Raw JPEG bytes
+				"size":        len(payload),
+				"event_id":    eventID,
+				"update":      update,
+			},
+			Timestamp:     time.Now(),
+			CorrelationID: types.NewCorrelationID(),
+		})
+	})
 }
 
 // Disconnect closes the MQTT connection
 func (c *Client) Disconnect() {
 	// Publish offline status before disconnecting (clean shutdown)
-	token := c.client.Publish("homeassistant/status", 1, true, "offline")
+	token := c.client.Publish(c.topics.HomeAssistant+"/status", 1, true, "offline")
 	if token.WaitTimeout(1 * time.Second) {
 		if token.Error() != nil {
-			logger.Debug("Failed to publish offline status: %v", token.Error())
+			log.Debug("Failed to publish offline status: %v", token.Error())
 		}
 	}
+	c.publishStatus(StatusOffline)
 
 	c.client.Disconnect(250)
-	logger.Debug("MQTT disconnected")
+	log.Debug("MQTT disconnected")
+}
+
+// publishStatus publishes the server availability on the status topic
+func (c *Client) publishStatus(status string) {
+	if c.statusTopic == "" || c.client == nil {
+		return
+	}
+	token := c.client.Publish(c.statusTopic, 1, true, status)
+	if !token.WaitTimeout(time.Second) {
+		log.Debug("Timeout publishing %s to %s", status, c.statusTopic)
+		return
+	}
+	if token.Error() != nil {
+		log.Warn("Failed to publish %s to %s: %v", status, c.statusTopic, token.Error())
+	}
 }
 
 // GetInternalClient returns the underlying MQTT client
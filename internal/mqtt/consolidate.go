@@ -0,0 +1,187 @@
+package mqtt
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// stateSubscription is a device state topic to subscribe to
+type stateSubscription struct {
+	topic   string
+	qos     byte
+	handler mqtt.MessageHandler
+	desc    string // logged once subscribed, e.g. "device: porch"
+}
+
+// consolidator replaces groups of device state subscriptions with one
+// wildcard subscription each ("zigbee2mqtt/+" instead of one per device) and
+// hands messages to the handler of their exact topic; messages on topics no
+// device reports on are dropped. This keeps broker session state small and
+// makes resubscribing after a reconnect a handful of requests.
+type consolidator struct {
+	mu       sync.RWMutex
+	filters  map[string]bool                  // active wildcard filters
+	handlers map[string][]mqtt.MessageHandler // exact topic -> handlers
+}
+
+func newConsolidator() *consolidator {
+	return &consolidator{
+		filters:  make(map[string]bool),
+		handlers: make(map[string][]mqtt.MessageHandler),
+	}
+}
+
+// wildcardFilter returns the filter a topic is consolidated under: its first
+// level followed by a + per remaining level, so only topics of the same
+// depth under the same root share it. Topics that are filters themselves,
+// $SYS topics, single-level topics and topics with empty levels are not
+// consolidated.
+func wildcardFilter(topic string) (string, bool) {
+	if topic == "" || strings.HasPrefix(topic, "$") || strings.ContainsAny(topic, "+#") {
+		return "", false
+	}
+	levels := strings.Split(topic, "/")
+	if len(levels) < 2 {
+		return "", false
+	}
+	for _, level := range levels {
+		if level == "" {
+			return "", false
+		}
+	}
+	return levels[0] + strings.Repeat("/+", len(levels)-1), true
+}
+
+// dispatch is the handler of every consolidated filter
+func (c *consolidator) dispatch(client mqtt.Client, msg mqtt.Message) {
+	c.mu.RLock()
+	handlers := c.handlers[msg.Topic()]
+	c.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(client, msg)
+	}
+}
+
+// add routes topic to handler if its filter is consolidated
+func (c *consolidator) add(topic string, handler mqtt.MessageHandler) bool {
+	filter, ok := wildcardFilter(topic)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.filters[filter] {
+		return false
+	}
+	c.handlers[topic] = append(c.handlers[topic], handler)
+	return true
+}
+
+// remove drops the handlers of topic, reporting whether it was consolidated
+func (c *consolidator) remove(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.handlers[topic]; !ok {
+		return false
+	}
+	delete(c.handlers, topic)
+	return true
+}
+
+// subscribeStates subscribes to device state topics, consolidating topics
+// that share a wildcard filter with at least c.consolidateMin-1 others
+func (c *Client) subscribeStates(subs []stateSubscription) {
+	groups := make(map[string][]stateSubscription)
+	if c.consolidateMin > 0 {
+		for _, sub := range subs {
+			if filter, ok := wildcardFilter(sub.topic); ok {
+				groups[filter] = append(groups[filter], sub)
+			}
+		}
+	}
+
+	filters := make([]string, 0, len(groups))
+	for filter, group := range groups {
+		if countTopics(group) >= c.consolidateMin {
+			filters = append(filters, filter)
+		}
+	}
+	sort.Strings(filters)
+
+	consolidated := make(map[string]bool, len(filters))
+	for _, filter := range filters {
+		group := groups[filter]
+		var qos byte
+		for _, sub := range group {
+			if sub.qos > qos {
+				qos = sub.qos
+			}
+		}
+
+		// Handlers go in first: retained states arrive right after subscribing
+		c.consolidated.mu.Lock()
+		c.consolidated.filters[filter] = true
+		for _, sub := range group {
+			c.consolidated.handlers[sub.topic] = append(c.consolidated.handlers[sub.topic], sub.handler)
+		}
+		c.consolidated.mu.Unlock()
+
+		token := c.client.Subscribe(filter, qos, c.consolidated.dispatch)
+		if token.Wait() && token.Error() != nil {
+			log.Warn("Failed to subscribe to %s, subscribing to its topics one by one: %v", filter, token.Error())
+			c.consolidated.mu.Lock()
+			delete(c.consolidated.filters, filter)
+			for _, sub := range group {
+				delete(c.consolidated.handlers, sub.topic)
+			}
+			c.consolidated.mu.Unlock()
+			continue
+		}
+		consolidated[filter] = true
+		log.Info("Subscribed to %d device topic(s) through %s", countTopics(group), filter)
+	}
+
+	for _, sub := range subs {
+		if filter, ok := wildcardFilter(sub.topic); ok && consolidated[filter] {
+			continue
+		}
+		c.subscribeState(sub)
+	}
+}
+
+// subscribeState subscribes to one device state topic, through its
+// consolidated filter if there is one
+func (c *Client) subscribeState(sub stateSubscription) {
+	if c.consolidated.add(sub.topic, sub.handler) {
+		log.Debug("Subscribed to %s (%s, consolidated)", sub.desc, sub.topic)
+		return
+	}
+	token := c.client.Subscribe(sub.topic, sub.qos, sub.handler)
+	if token.Wait() && token.Error() != nil {
+		log.Warn("Failed to subscribe to %s: %v", sub.topic, token.Error())
+		return
+	}
+	log.Debug("Subscribed to %s (%s)", sub.desc, sub.topic)
+}
+
+// unsubscribeState stops following a device state topic
+func (c *Client) unsubscribeState(topic string) {
+	if c.consolidated.remove(topic) {
+		return
+	}
+	if token := c.client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
+		log.Debug("Failed to unsubscribe from %s: %v", topic, token.Error())
+	}
+}
+
+// countTopics counts the distinct topics of subs
+func countTopics(subs []stateSubscription) int {
+	topics := make(map[string]bool, len(subs))
+	for _, sub := range subs {
+		topics[sub.topic] = true
+	}
+	return len(topics)
+}
@@ -0,0 +1,273 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"homescript-server/internal/types"
+	"slices"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// frigateDetectionMaxAge drops ongoing detections whose "end" was missed
+// (e.g. during a reconnect)
+const frigateDetectionMaxAge = time.Hour
+
+// frigateSnapshotWait is how long a snapshot that arrived before the
+// frigate/events message announcing it waits for that message
+const frigateSnapshotWait = 2 * time.Second
+
+// FrigateEventAttribute is the attribute Frigate event lifecycle messages
+// are routed under: events/device/<camera>/events/
+const FrigateEventAttribute = "events"
+
+// frigateDetection is an ongoing Frigate event: one tracked object that
+// Frigate keeps publishing snapshots and updates for
+type frigateDetection struct {
+	key          string // "<camera>/<label>"
+	started      time.Time
+	snapshotTime float64 // frame time of its best snapshot so far
+	hasSnapshot  bool
+	notified     bool // a snapshot event was routed for it
+}
+
+// heldSnapshot is a snapshot waiting for the frigate/events message that
+// names its event
+type heldSnapshot struct {
+	route func(eventID string, update bool)
+	timer *time.Timer
+}
+
+// frigateSnapshots attributes the pictures on <camera>/<label>/snapshot to
+// Frigate events. The snapshot topic doesn't carry the event ID, but each
+// new snapshot shows up in frigate/events as a changed snapshot time of the
+// event it belongs to; snapshots are matched with those changes in order.
+type frigateSnapshots struct {
+	wait   time.Duration
+	mu     sync.Mutex
+	events map[string]*frigateDetection // Frigate event ID -> ongoing event
+	due    map[string][]string          // "<camera>/<label>" -> events whose new snapshot has not arrived
+	held   map[string]*heldSnapshot     // "<camera>/<label>" -> snapshot waiting for its event
+}
+
+func newFrigateSnapshots() *frigateSnapshots {
+	return &frigateSnapshots{
+		wait:   frigateSnapshotWait,
+		events: make(map[string]*frigateDetection),
+		due:    make(map[string][]string),
+		held:   make(map[string]*heldSnapshot),
+	}
+}
+
+// subscribeFrigateEvents follows <base>/events of every Frigate instance
+// with camera devices, to route the lifecycle of each event (tracked
+// object) and to learn which event snapshots belong to
+func (c *Client) subscribeFrigateEvents(devs []*types.Device) {
+	for base, byName := range c.frigateCameras(devs) {
+		byName := byName
+		topic := base + "/events"
+		token := c.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+			c.handleFrigateEvent(byName, msg)
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Warn("Failed to subscribe to %s: %v", topic, token.Error())
+			continue
+		}
+		log.Debug("Subscribed to Frigate events: %s (%d camera(s))", topic, len(byName))
+	}
+}
+
+func (c *Client) handleFrigateEvent(cameras map[string]*types.Device, msg mqtt.Message) {
+	var event types.FrigateEvent
+	if err := json.Unmarshal(msg.Payload(), &event); err != nil {
+		log.Debug("Failed to parse Frigate event: %v", err)
+		return
+	}
+	obj := event.After
+	dev, ok := cameras[obj.Camera]
+	if !ok || obj.ID == "" {
+		return
+	}
+	c.snapshots.event(dev.ID+"/"+obj.Label, &obj, event.Type == "end")
+	c.routeFrigateEvent(dev, msg.Topic(), &event)
+}
+
+// routeFrigateEvent routes a new, update or end message of a Frigate event
+// to events/device/<camera>/events/
+func (c *Client) routeFrigateEvent(dev *types.Device, topic string, event *types.FrigateEvent) {
+	if c.router == nil {
+		return
+	}
+	obj := event.After
+
+	var endTime interface{}
+	if obj.EndTime != nil {
+		endTime = *obj.EndTime
+	}
+	// Zones the object entered with this message
+	var newZones []interface{}
+	for _, zone := range obj.EnteredZones {
+		if !containsString(event.Before.EnteredZones, zone) {
+			newZones = append(newZones, zone)
+		}
+	}
+
+	c.router.RouteEvent(&types.Event{
+		Source:    "device",
+		Type:      "frigate_event",
+		Device:    dev.ID,
+		Attribute: FrigateEventAttribute,
+		Area:      dev.Area,
+		Topic:     topic,
+		Data: map[string]interface{}{
+			"type":           event.Type,
+			"event_id":       obj.ID,
+			"label":          obj.Label,
+			"score":          obj.Score,
+			"top_score":      obj.TopScore,
+			"false_positive": obj.FalsePositive,
+			"start_time":     obj.StartTime,
+			"end_time":       endTime,
+			"current_zones":  stringsToInterfaces(obj.CurrentZones),
+			"entered_zones":  stringsToInterfaces(obj.EnteredZones),
+			"new_zones":      newZones,
+			"has_snapshot":   obj.HasSnapshot,
+			"has_clip":       obj.HasClip,
+			"stationary":     obj.Stationary,
+		},
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	})
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// stringsToInterfaces converts a list for event data, which scripts see as
+// a Lua array (empty lists become empty tables)
+func stringsToInterfaces(list []string) []interface{} {
+	out := make([]interface{}, len(list))
+	for i, s := range list {
+		out[i] = s
+	}
+	return out
+}
+
+// event follows a frigate/events message about obj, whose label on its
+// camera is key. A changed snapshot time means Frigate published a new
+// snapshot of the event: it claims the snapshot held for key, or else the
+// next one to arrive.
+func (t *frigateSnapshots) event(key string, obj *types.FrigateEventObject, ended bool) {
+	now := time.Now()
+
+	t.mu.Lock()
+	for id, d := range t.events {
+		if now.Sub(d.started) > frigateDetectionMaxAge {
+			t.drop(id)
+		}
+	}
+	if ended {
+		t.drop(obj.ID)
+		t.mu.Unlock()
+		return
+	}
+
+	d, ok := t.events[obj.ID]
+	if !ok {
+		d = &frigateDetection{key: key, started: now}
+		t.events[obj.ID] = d
+	}
+	snapshotTime := obj.SnapshotTime
+	if obj.Snapshot != nil {
+		snapshotTime = obj.Snapshot.FrameTime
+	}
+	changed := snapshotTime > d.snapshotTime || (obj.HasSnapshot && !d.hasSnapshot)
+	d.snapshotTime = max(d.snapshotTime, snapshotTime)
+	d.hasSnapshot = d.hasSnapshot || obj.HasSnapshot
+	if !changed {
+		t.mu.Unlock()
+		return
+	}
+
+	h := t.held[key]
+	if h == nil {
+		t.due[key] = append(t.due[key], obj.ID)
+		t.mu.Unlock()
+		return
+	}
+	delete(t.held, key)
+	h.timer.Stop()
+	update := d.notified
+	d.notified = true
+	t.mu.Unlock()
+	h.route(obj.ID, update)
+}
+
+// snapshot attributes a snapshot published for key and calls route with
+// its event ID, and whether an earlier snapshot of that event was routed.
+// Without a due event the snapshot is held until frigate/events announces
+// it, and routed with an empty event ID if that takes longer than t.wait.
+func (t *frigateSnapshots) snapshot(key string, route func(eventID string, update bool)) {
+	t.mu.Lock()
+	if ids := t.due[key]; len(ids) > 0 {
+		id := ids[0]
+		if len(ids) == 1 {
+			delete(t.due, key)
+		} else {
+			t.due[key] = ids[1:]
+		}
+		d := t.events[id]
+		update := d.notified
+		d.notified = true
+		t.mu.Unlock()
+		route(id, update)
+		return
+	}
+
+	// A snapshot no event claimed yet goes out as is, this one waits
+	old := t.held[key]
+	if old != nil {
+		old.timer.Stop()
+	}
+	h := &heldSnapshot{route: route}
+	h.timer = time.AfterFunc(t.wait, func() { t.release(key, h) })
+	t.held[key] = h
+	t.mu.Unlock()
+	if old != nil {
+		old.route("", false)
+	}
+}
+
+// release routes a held snapshot no event claimed in time
+func (t *frigateSnapshots) release(key string, h *heldSnapshot) {
+	t.mu.Lock()
+	if t.held[key] != h {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.held, key)
+	t.mu.Unlock()
+	h.route("", false)
+}
+
+// drop stops following event id. t.mu must be held.
+func (t *frigateSnapshots) drop(id string) {
+	d, ok := t.events[id]
+	if !ok {
+		return
+	}
+	delete(t.events, id)
+	ids := slices.DeleteFunc(t.due[d.key], func(due string) bool { return due == id })
+	if len(ids) == 0 {
+		delete(t.due, d.key)
+	} else {
+		t.due[d.key] = ids
+	}
+}
@@ -0,0 +1,134 @@
+package mqtt
+
+import (
+	"homescript-server/internal/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+// routed is a snapshot as routed by frigateSnapshots
+type routed struct {
+	picture string
+	eventID string
+	update  bool
+}
+
+// snapshotRecorder collects routed snapshots, including the ones routed
+// from a timer
+type snapshotRecorder struct {
+	mu     sync.Mutex
+	routed []routed
+}
+
+func (r *snapshotRecorder) route(picture string) func(string, bool) {
+	return func(eventID string, update bool) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.routed = append(r.routed, routed{picture, eventID, update})
+	}
+}
+
+func (r *snapshotRecorder) expect(t *testing.T, want ...routed) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.routed) != len(want) {
+		t.Fatalf("routed %+v, want %+v", r.routed, want)
+	}
+	for i := range want {
+		if r.routed[i] != want[i] {
+			t.Errorf("snapshot %d: routed %+v, want %+v", i, r.routed[i], want[i])
+		}
+	}
+}
+
+func frigateObject(id string, snapshotTime float64) *types.FrigateEventObject {
+	obj := &types.FrigateEventObject{ID: id, Camera: "driveway", Label: "person"}
+	if snapshotTime > 0 {
+		obj.HasSnapshot = true
+		obj.Snapshot = &types.FrigateEventSnapshot{FrameTime: snapshotTime}
+	}
+	return obj
+}
+
+const personKey = "driveway/person"
+
+func TestFrigateSnapshotsEventFirst(t *testing.T) {
+	s := newFrigateSnapshots()
+	r := &snapshotRecorder{}
+
+	s.event(personKey, frigateObject("a", 1), false)
+	s.snapshot(personKey, r.route("a1"))
+	// Updates without a new snapshot don't claim the next picture
+	s.event(personKey, frigateObject("a", 1), false)
+	s.event(personKey, frigateObject("a", 2), false)
+	s.snapshot(personKey, r.route("a2"))
+
+	r.expect(t, routed{"a1", "a", false}, routed{"a2", "a", true})
+}
+
+func TestFrigateSnapshotsTwoObjects(t *testing.T) {
+	s := newFrigateSnapshots()
+	r := &snapshotRecorder{}
+
+	// The second person's picture comes first, although "a" was seen first
+	s.event(personKey, frigateObject("a", 0), false)
+	s.event(personKey, frigateObject("b", 1), false)
+	s.snapshot(personKey, r.route("b1"))
+	s.event(personKey, frigateObject("a", 2), false)
+	s.event(personKey, frigateObject("b", 3), false)
+	s.snapshot(personKey, r.route("a1"))
+	s.snapshot(personKey, r.route("b2"))
+
+	r.expect(t, routed{"b1", "b", false}, routed{"a1", "a", false}, routed{"b2", "b", true})
+}
+
+func TestFrigateSnapshotsSnapshotFirst(t *testing.T) {
+	s := newFrigateSnapshots()
+	r := &snapshotRecorder{}
+
+	s.snapshot(personKey, r.route("a1"))
+	r.expect(t)
+	s.event(personKey, frigateObject("a", 1), false)
+	r.expect(t, routed{"a1", "a", false})
+
+	// Frigate 0.12 reports snapshot_time
+	s.snapshot(personKey, r.route("a2"))
+	s.event(personKey, &types.FrigateEventObject{ID: "a", HasSnapshot: true, SnapshotTime: 2}, false)
+	r.expect(t, routed{"a1", "a", false}, routed{"a2", "a", true})
+}
+
+func TestFrigateSnapshotsUnclaimed(t *testing.T) {
+	s := newFrigateSnapshots()
+	s.wait = 10 * time.Millisecond
+	r := &snapshotRecorder{}
+
+	s.snapshot(personKey, r.route("x1"))
+	s.snapshot(personKey, r.route("x2")) // x1 goes out, x2 waits
+	r.expect(t, routed{"x1", "", false})
+
+	time.Sleep(50 * time.Millisecond)
+	r.expect(t, routed{"x1", "", false}, routed{"x2", "", false})
+
+	// Announced after its picture was released: the next picture isn't "a"'s
+	s.event(personKey, frigateObject("a", 1), false)
+	s.event(personKey, frigateObject("a", 0), true)
+	s.snapshot(personKey, r.route("x3"))
+	time.Sleep(50 * time.Millisecond)
+	r.expect(t, routed{"x1", "", false}, routed{"x2", "", false}, routed{"x3", "", false})
+}
+
+func TestFrigateSnapshotsPerLabel(t *testing.T) {
+	s := newFrigateSnapshots()
+	r := &snapshotRecorder{}
+
+	car := frigateObject("c", 1)
+	car.Label = "car"
+	s.event("driveway/car", car, false)
+	s.event(personKey, frigateObject("a", 1), false)
+	s.snapshot(personKey, r.route("a1"))
+	s.snapshot("driveway/car", r.route("c1"))
+
+	r.expect(t, routed{"a1", "a", false}, routed{"c1", "c", false})
+}
@@ -0,0 +1,134 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"homescript-server/internal/types"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DegradedAttribute is the event attribute of camera stream health
+// notifications, routed to events/device/<camera>/degraded/
+const DegradedAttribute = "degraded"
+
+// FPSThresholds mark a Frigate camera degraded when its frame rates drop
+// below them (0 disables a check)
+type FPSThresholds struct {
+	CameraFPS    float64
+	DetectionFPS float64
+}
+
+// SetFPSThresholds sets when Frigate cameras count as degraded
+func (c *Client) SetFPSThresholds(thresholds FPSThresholds) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fpsThresholds = thresholds
+}
+
+// frigateCameras groups the Frigate camera devices by Frigate base topic and
+// camera name
+func (c *Client) frigateCameras(devs []*types.Device) map[string]map[string]*types.Device {
+	topics := c.deviceManager.Topics()
+	cameras := make(map[string]map[string]*types.Device) // base -> camera name -> device
+	for _, dev := range devs {
+		if dev.Type != "camera" || dev.Vendor != "Frigate NVR" {
+			continue
+		}
+		base, ok := topics.FrigateBase(dev.MQTT.CommandTopic)
+		if !ok {
+			continue
+		}
+		if cameras[base] == nil {
+			cameras[base] = make(map[string]*types.Device)
+		}
+		cameras[base][strings.TrimPrefix(dev.MQTT.CommandTopic, base+"/")] = dev
+	}
+	return cameras
+}
+
+// subscribeFrigateStats follows <base>/stats of every Frigate instance with
+// camera devices, reporting camera_fps, detection_fps, process_fps and
+// skipped_fps as camera attributes
+func (c *Client) subscribeFrigateStats(devs []*types.Device) {
+	for base, byName := range c.frigateCameras(devs) {
+		byName := byName
+		topic := base + "/stats"
+		token := c.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+			c.handleFrigateStats(byName, msg)
+		})
+		if token.Wait() && token.Error() != nil {
+			log.Warn("Failed to subscribe to %s: %v", topic, token.Error())
+			continue
+		}
+		log.Debug("Subscribed to Frigate stats: %s (%d camera(s))", topic, len(byName))
+	}
+}
+
+func (c *Client) handleFrigateStats(cameras map[string]*types.Device, msg mqtt.Message) {
+	var stats types.FrigateStats
+	if err := json.Unmarshal(msg.Payload(), &stats); err != nil {
+		log.Debug("Failed to parse Frigate stats: %v", err)
+		return
+	}
+
+	for name, camera := range stats.Cameras {
+		dev, ok := cameras[name]
+		if !ok {
+			continue
+		}
+		c.handleDeviceState(dev, msg.Topic(), map[string]interface{}{
+			"camera_fps":    camera.CameraFPS,
+			"detection_fps": camera.DetectionFPS,
+			"process_fps":   camera.ProcessFPS,
+			"skipped_fps":   camera.SkippedFPS,
+		})
+		c.checkFPS(dev, camera, msg.Topic())
+	}
+}
+
+// checkFPS routes a "degraded" event when a camera drops below the
+// thresholds, and one with degraded = false when it is back above them
+func (c *Client) checkFPS(dev *types.Device, stats types.FrigateCameraStats, topic string) {
+	c.mu.Lock()
+	thresholds := c.fpsThresholds
+	degraded := (thresholds.CameraFPS > 0 && stats.CameraFPS < thresholds.CameraFPS) ||
+		(thresholds.DetectionFPS > 0 && stats.DetectionFPS < thresholds.DetectionFPS)
+	if c.degraded[dev.ID] == degraded {
+		c.mu.Unlock()
+		return
+	}
+	c.degraded[dev.ID] = degraded
+	c.mu.Unlock()
+
+	if degraded {
+		log.Warn("Camera %s is degraded: %.1f fps (detection %.1f fps)", dev.ID, stats.CameraFPS, stats.DetectionFPS)
+	} else {
+		log.Info("Camera %s recovered: %.1f fps (detection %.1f fps)", dev.ID, stats.CameraFPS, stats.DetectionFPS)
+	}
+
+	if c.deviceManager != nil {
+		c.deviceManager.UpdateState(dev.ID, map[string]interface{}{DegradedAttribute: degraded})
+	}
+	if c.router == nil {
+		return
+	}
+	c.router.RouteEvent(&types.Event{
+		Source:    "device",
+		Type:      DegradedAttribute,
+		Device:    dev.ID,
+		Attribute: DegradedAttribute,
+		Area:      dev.Area,
+		Topic:     topic,
+		Data: map[string]interface{}{
+			DegradedAttribute:   degraded,
+			"camera_fps":        stats.CameraFPS,
+			"detection_fps":     stats.DetectionFPS,
+			"min_camera_fps":    thresholds.CameraFPS,
+			"min_detection_fps": thresholds.DetectionFPS,
+		},
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	})
+}
@@ -0,0 +1,211 @@
+package mqtt
+
+import (
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/discovery"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// haDiscovery follows Home Assistant MQTT discovery at runtime. Entities of
+// one firmware often report on the same topics (every Tasmota sensor reads
+// tele/<topic>/SENSOR with its own value template, all share one LWT), so
+// each topic is subscribed once and dispatched to the entities using it.
+type haDiscovery struct {
+	prefix   string
+	mu       sync.Mutex
+	entities map[string]*haEntity                      // discovery topic -> entity
+	routes   map[string]map[string]mqtt.MessageHandler // topic -> route key -> handler
+}
+
+// haEntity is a discovered entity and the device it became
+type haEntity struct {
+	deviceID string
+	// added is false when the device came from devices.yaml: discovery then
+	// only supplies its state topics and is not allowed to remove it
+	added bool
+}
+
+// SubscribeHADiscovery turns Home Assistant discovery configs under the
+// discovery prefix into devices while the server runs: a config adds or
+// updates its entity's device, an empty one removes it. Each entity is a
+// device (ha/<unique_id>) reporting its state topics through their value
+// templates. Entities on Zigbee2MQTT topics are left to the native
+// Zigbee2MQTT support. Devices in devices.yaml with the same ID keep their
+// settings and get the entity's state topics.
+func (c *Client) SubscribeHADiscovery() error {
+	c.ha = &haDiscovery{
+		prefix:   c.deviceManager.Topics().HomeAssistant,
+		entities: make(map[string]*haEntity),
+		routes:   make(map[string]map[string]mqtt.MessageHandler),
+	}
+
+	for _, filter := range []string{c.ha.prefix + "/+/+/config", c.ha.prefix + "/+/+/+/config"} {
+		token := c.client.Subscribe(filter, 0, c.handleHADiscovery)
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", filter, token.Error())
+		}
+	}
+
+	// Devices announce themselves again when Home Assistant comes online
+	token := c.client.Publish(c.ha.prefix+"/status", 1, true, "online")
+	if token.Wait() && token.Error() != nil {
+		log.Debug("Failed to publish online status: %v", token.Error())
+	}
+
+	log.Info("Following Home Assistant discovery under %s/", c.ha.prefix)
+	return nil
+}
+
+func (c *Client) handleHADiscovery(_ mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	component, nodeID, objectID, ok := devices.ParseHADiscoveryTopic(c.ha.prefix, topic)
+	if !ok {
+		return
+	}
+
+	if len(msg.Payload()) == 0 {
+		c.removeHAEntity(topic)
+		return
+	}
+
+	config, err := devices.ParseHADiscovery(msg.Payload())
+	if err != nil {
+		log.Debug("Skipping Home Assistant entity %s: %v", topic, err)
+		return
+	}
+	if base, ok := c.deviceManager.Topics().Zigbee2MQTTBase(config.StateTopic); ok {
+		log.Debug("Skipping Home Assistant entity %s: reported by Zigbee2MQTT (%s)", topic, base)
+		return
+	}
+	dev := discovery.NewHomeAssistantDevice(component, nodeID, objectID, config)
+
+	c.ha.mu.Lock()
+	previous, known := c.ha.entities[topic]
+	for other, entity := range c.ha.entities {
+		if other != topic && entity.deviceID == dev.ID {
+			c.ha.mu.Unlock()
+			log.Warn("Home Assistant entity %s has the same device ID as %s (%s), skipped", topic, other, dev.ID)
+			return
+		}
+	}
+	c.ha.mu.Unlock()
+
+	// An updated config replaces everything of the previous one
+	if known {
+		c.removeHARoutes(previous.deviceID)
+		if previous.added && previous.deviceID != dev.ID {
+			c.deviceManager.RemoveDevice(previous.deviceID)
+		}
+	}
+
+	entity := &haEntity{deviceID: dev.ID, added: true}
+	if existing, ok := c.deviceManager.GetDevice(dev.ID); ok && (!known || !previous.added) {
+		merged := *existing
+		merged.StateTopics = dev.StateTopics
+		if merged.MQTT.AvailabilityTopic == "" {
+			merged.MQTT.AvailabilityTopic = dev.MQTT.AvailabilityTopic
+		}
+		if !known && len(existing.StateTopics) == 0 && existing.MQTT.StateTopic != "" {
+			c.unsubscribeState(existing.MQTT.StateTopic)
+		}
+		dev = &merged
+		entity.added = false
+	}
+
+	c.ha.mu.Lock()
+	c.ha.entities[topic] = entity
+	c.ha.mu.Unlock()
+
+	c.deviceManager.AddDevice(dev)
+	c.deviceManager.GetHAManager().RegisterDevice(dev.ID, config)
+
+	for _, sub := range c.templateSubscriptions(dev) {
+		c.addHARoute(sub.topic, sub.qos, dev.ID, sub.handler)
+	}
+	if topic := c.deviceManager.AvailabilityTopic(dev); topic != "" {
+		c.addHARoute(topic, 0, dev.ID+" availability", c.makeAvailabilityHandler(dev))
+	}
+
+	if known {
+		log.Debug("Updated Home Assistant entity %s (%s)", dev.ID, topic)
+	} else {
+		log.Info("Home Assistant entity %s discovered (%s, %s)", dev.ID, component, dev.Name)
+	}
+}
+
+// removeHAEntity stops following an entity whose config was cleared and
+// removes its device unless it is configured in devices.yaml
+func (c *Client) removeHAEntity(topic string) {
+	c.ha.mu.Lock()
+	entity, ok := c.ha.entities[topic]
+	delete(c.ha.entities, topic)
+	c.ha.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	c.removeHARoutes(entity.deviceID)
+	if entity.added {
+		c.deviceManager.RemoveDevice(entity.deviceID)
+	}
+	log.Info("Home Assistant entity %s removed (%s)", entity.deviceID, topic)
+}
+
+// addHARoute dispatches messages on topic to handler under key, subscribing
+// to the topic for its first route
+func (c *Client) addHARoute(topic string, qos byte, key string, handler mqtt.MessageHandler) {
+	c.ha.mu.Lock()
+	routes, subscribed := c.ha.routes[topic]
+	if !subscribed {
+		routes = make(map[string]mqtt.MessageHandler)
+		c.ha.routes[topic] = routes
+	}
+	routes[key] = handler
+	c.ha.mu.Unlock()
+
+	if !subscribed {
+		c.subscribeState(stateSubscription{
+			topic:   topic,
+			qos:     qos,
+			handler: c.dispatchHA,
+			desc:    "Home Assistant entity: " + key,
+		})
+	}
+}
+
+// removeHARoutes drops the state and availability routes of a device,
+// unsubscribing from topics nothing else uses
+func (c *Client) removeHARoutes(deviceID string) {
+	var unused []string
+	c.ha.mu.Lock()
+	for topic, routes := range c.ha.routes {
+		delete(routes, deviceID)
+		delete(routes, deviceID+" availability")
+		if len(routes) == 0 {
+			delete(c.ha.routes, topic)
+			unused = append(unused, topic)
+		}
+	}
+	c.ha.mu.Unlock()
+
+	for _, topic := range unused {
+		c.unsubscribeState(topic)
+	}
+}
+
+// dispatchHA hands a message to every entity route of its topic
+func (c *Client) dispatchHA(client mqtt.Client, msg mqtt.Message) {
+	c.ha.mu.Lock()
+	handlers := make([]mqtt.MessageHandler, 0, len(c.ha.routes[msg.Topic()]))
+	for _, handler := range c.ha.routes[msg.Topic()] {
+		handlers = append(handlers, handler)
+	}
+	c.ha.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(client, msg)
+	}
+}
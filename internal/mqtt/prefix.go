@@ -0,0 +1,100 @@
+package mqtt
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// prefixedClient puts every topic under a global prefix, so the same
+// configuration works behind bridges that remap namespaces per site:
+// "zigbee2mqtt/porch" is published and subscribed as "site1/zigbee2mqtt/porch",
+// and handlers see the unprefixed topic.
+type prefixedClient struct {
+	mqtt.Client
+	prefix string // without trailing slash
+}
+
+// newPrefixedClient wraps client; an empty prefix returns client unchanged
+func newPrefixedClient(client mqtt.Client, prefix string) mqtt.Client {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return client
+	}
+	return &prefixedClient{Client: client, prefix: prefix}
+}
+
+// add prefixes a topic or filter. $SYS topics are left alone and shared
+// subscriptions keep their $share/<group>/ head.
+func (c *prefixedClient) add(topic string) string {
+	if strings.HasPrefix(topic, "$SYS") {
+		return topic
+	}
+	if strings.HasPrefix(topic, "$share/") {
+		parts := strings.SplitN(topic, "/", 3)
+		if len(parts) == 3 {
+			return parts[0] + "/" + parts[1] + "/" + c.prefix + "/" + parts[2]
+		}
+	}
+	return c.prefix + "/" + topic
+}
+
+// PrefixTopic applies prefix to topic the way a prefixed client would
+func PrefixTopic(prefix, topic string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return topic
+	}
+	return (&prefixedClient{prefix: prefix}).add(topic)
+}
+
+func (c *prefixedClient) strip(topic string) string {
+	return strings.TrimPrefix(topic, c.prefix+"/")
+}
+
+func (c *prefixedClient) wrap(callback mqtt.MessageHandler) mqtt.MessageHandler {
+	if callback == nil {
+		return nil
+	}
+	return func(client mqtt.Client, msg mqtt.Message) {
+		callback(c, &prefixedMessage{Message: msg, topic: c.strip(msg.Topic())})
+	}
+}
+
+func (c *prefixedClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	return c.Client.Publish(c.add(topic), qos, retained, payload)
+}
+
+func (c *prefixedClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.Client.Subscribe(c.add(topic), qos, c.wrap(callback))
+}
+
+func (c *prefixedClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	prefixed := make(map[string]byte, len(filters))
+	for topic, qos := range filters {
+		prefixed[c.add(topic)] = qos
+	}
+	return c.Client.SubscribeMultiple(prefixed, c.wrap(callback))
+}
+
+func (c *prefixedClient) Unsubscribe(topics ...string) mqtt.Token {
+	prefixed := make([]string, len(topics))
+	for i, topic := range topics {
+		prefixed[i] = c.add(topic)
+	}
+	return c.Client.Unsubscribe(prefixed...)
+}
+
+func (c *prefixedClient) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.Client.AddRoute(c.add(topic), c.wrap(callback))
+}
+
+// prefixedMessage reports the topic without the global prefix
+type prefixedMessage struct {
+	mqtt.Message
+	topic string
+}
+
+func (m *prefixedMessage) Topic() string {
+	return m.topic
+}
@@ -0,0 +1,76 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"homescript-server/internal/discovery"
+	"homescript-server/internal/types"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// zigbee2MQTTRename carries the result of a friendly_name change, under the
+// Zigbee2MQTT base topic
+const zigbee2MQTTRename = "bridge/response/device/rename"
+
+// RenameHandler is called after a device was renamed at runtime, e.g. to
+// update devices.yaml and move its event directory
+type RenameHandler func(oldID string, dev *types.Device)
+
+// SetRenameHandler sets the callback invoked after a Zigbee2MQTT rename was applied
+func (c *Client) SetRenameHandler(handler RenameHandler) {
+	c.onRename = handler
+}
+
+// subscribeRenames follows friendly_name changes of one Zigbee2MQTT instance
+// so renamed devices keep working instead of going silent on their old topics
+func (c *Client) subscribeRenames(base string) {
+	topic := base + "/" + zigbee2MQTTRename
+	token := c.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		c.handleRename(base, msg)
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Warn("Failed to subscribe to %s: %v", topic, token.Error())
+	}
+}
+
+func (c *Client) handleRename(base string, msg mqtt.Message) {
+	var response struct {
+		Status string `json:"status"`
+		Data   struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Payload(), &response); err != nil {
+		log.Debug("Invalid rename response: %v", err)
+		return
+	}
+	if response.Status != "ok" || response.Data.From == "" || response.Data.To == "" {
+		return
+	}
+
+	from, to := response.Data.From, response.Data.To
+	dev, oldID, err := c.deviceManager.RenameZigbee2MQTT(base, from, to, discovery.SanitizeID(to))
+	if err != nil {
+		log.Warn("Zigbee2MQTT renamed %s to %s, but the device could not be remapped: %v", from, to, err)
+		return
+	}
+
+	// Move subscriptions from the old topics to the new ones
+	c.unsubscribeState(base + "/" + from)
+	availability := base + "/" + from + "/availability"
+	if token := c.client.Unsubscribe(availability); token.Wait() && token.Error() != nil {
+		log.Debug("Failed to unsubscribe from %s: %v", availability, token.Error())
+	}
+	c.subscribeState(stateSubscription{
+		topic:   dev.MQTT.StateTopic,
+		qos:     dev.MQTT.QoS,
+		handler: c.makeDeviceHandler(dev),
+		desc:    "device: " + dev.ID,
+	})
+	c.subscribeDeviceAvailability(dev)
+
+	if c.onRename != nil {
+		c.onRename(oldID, dev)
+	}
+}
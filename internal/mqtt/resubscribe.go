@@ -0,0 +1,161 @@
+package mqtt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// trackedSubscription is a subscription to renew after a reconnect
+type trackedSubscription struct {
+	qos     byte
+	handler mqtt.MessageHandler
+}
+
+// trackingClient remembers every subscription made through it, so they can
+// be renewed when the broker lost the session during a reconnect (broker
+// restart, expired session). It sits below the prefixedClient and
+// sharedClient and records the filters as sent to the broker.
+type trackingClient struct {
+	mqtt.Client
+	mu   sync.Mutex
+	subs map[string]trackedSubscription // filter -> subscription
+
+	// held are messages that arrived without a handler before release
+	held     []mqtt.Message
+	released bool
+}
+
+func newTrackingClient(client mqtt.Client) *trackingClient {
+	return &trackingClient{Client: client, subs: make(map[string]trackedSubscription)}
+}
+
+func (c *trackingClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	c.subs[topic] = trackedSubscription{qos: qos, handler: callback}
+	c.mu.Unlock()
+	return c.Client.Subscribe(topic, qos, callback)
+}
+
+func (c *trackingClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	for topic, qos := range filters {
+		c.subs[topic] = trackedSubscription{qos: qos, handler: callback}
+	}
+	c.mu.Unlock()
+	return c.Client.SubscribeMultiple(filters, callback)
+}
+
+func (c *trackingClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subs, topic)
+	}
+	c.mu.Unlock()
+	return c.Client.Unsubscribe(topics...)
+}
+
+// resubscribe renews every tracked subscription and returns how many
+// succeeded; the first failure is returned as error
+func (c *trackingClient) resubscribe() (int, error) {
+	c.mu.Lock()
+	topics := make([]string, 0, len(c.subs))
+	for topic := range c.subs {
+		topics = append(topics, topic)
+	}
+	subs := make(map[string]trackedSubscription, len(c.subs))
+	for topic, sub := range c.subs {
+		subs[topic] = sub
+	}
+	c.mu.Unlock()
+	sort.Strings(topics)
+
+	renewed := 0
+	var firstErr error
+	for _, topic := range topics {
+		sub := subs[topic]
+		token := c.Client.Subscribe(topic, sub.qos, sub.handler)
+		if token.Wait() && token.Error() != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to resubscribe to %s: %w", topic, token.Error())
+			}
+			continue
+		}
+		renewed++
+	}
+	return renewed, firstErr
+}
+
+// maxHeldMessages bounds the messages held from a resumed session
+const maxHeldMessages = 10000
+
+// hold keeps a message that arrived before its handler was subscribed
+func (c *trackingClient) hold(msg mqtt.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released || len(c.held) >= maxHeldMessages {
+		log.Debug("Dropping message on %s: no handler subscribed", msg.Topic())
+		return
+	}
+	c.held = append(c.held, msg)
+}
+
+// release passes the held messages to the handlers of the matching tracked
+// filters, in arrival order, and stops holding new ones
+func (c *trackingClient) release() (delivered, dropped int) {
+	c.mu.Lock()
+	held := c.held
+	c.held = nil
+	c.released = true
+	subs := make(map[string]trackedSubscription, len(c.subs))
+	for topic, sub := range c.subs {
+		subs[topic] = sub
+	}
+	c.mu.Unlock()
+
+	for _, msg := range held {
+		matched := false
+		for filter, sub := range subs {
+			if matchFilter(unshare(filter), msg.Topic()) {
+				sub.handler(c.Client, msg)
+				matched = true
+			}
+		}
+		if matched {
+			delivered++
+		} else {
+			dropped++
+		}
+	}
+	return delivered, dropped
+}
+
+// unshare strips the "$share/<group>/" head of a shared subscription
+func unshare(filter string) string {
+	if !strings.HasPrefix(filter, "$share/") {
+		return filter
+	}
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) < 3 {
+		return filter
+	}
+	return parts[2]
+}
+
+// matchFilter reports whether topic matches an MQTT filter with + and #
+func matchFilter(filter, topic string) bool {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
+		}
+		if i >= len(topicParts) || (part != "+" && part != topicParts[i]) {
+			return false
+		}
+	}
+	return len(filterParts) == len(topicParts)
+}
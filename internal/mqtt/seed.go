@@ -0,0 +1,169 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+// seeding is the startup window in which state replayed by the broker
+// (retained messages) and the answers to Zigbee2MQTT /get requests only
+// fill the device manager: nothing changed, so no events are routed
+type seeding struct {
+	mu        sync.Mutex
+	active    bool
+	requested map[string]bool // devices asked for their state that haven't answered
+	answered  chan struct{}   // closed once every requested device answered
+	closed    bool
+	retained  int
+	replies   int
+}
+
+// gettableAttributes are read with Zigbee2MQTT's /get; sensors that sleep
+// between reports can't answer and are left to report on their own
+var gettableAttributes = map[string]bool{
+	"state": true, "brightness": true, "color_temp": true, "color_mode": true,
+	"position": true, "tilt": true, "system_mode": true,
+	"occupied_heating_setpoint": true, "current_heating_setpoint": true,
+}
+
+// BeginSeeding starts the startup window; call it before SubscribeToDevices
+// so the retained states delivered with the subscriptions are caught
+func (c *Client) BeginSeeding() {
+	c.seed.mu.Lock()
+	defer c.seed.mu.Unlock()
+	c.seed.active = true
+	c.seed.requested = make(map[string]bool)
+	c.seed.answered = make(chan struct{})
+	c.seed.closed = false
+}
+
+// allAnswered closes answered once no requested device is left (seed.mu
+// must be held)
+func (s *seeding) allAnswered() {
+	if len(s.requested) == 0 && !s.closed {
+		close(s.answered)
+		s.closed = true
+	}
+}
+
+// Seed asks Zigbee2MQTT devices without state for it with <topic>/get and
+// waits up to timeout for their answers, then ends the startup window.
+// Later messages route events as usual.
+func (c *Client) Seed(timeout time.Duration) {
+	c.seed.mu.Lock()
+	active := c.seed.active
+	c.seed.mu.Unlock()
+	if !active {
+		return
+	}
+
+	started := time.Now()
+	var requested []*types.Device
+	for _, dev := range c.deviceManager.ListDevices() {
+		if _, ok := c.deviceManager.Zigbee2MQTTBase(dev); !ok || dev.MQTT.StateTopic == "" {
+			continue
+		}
+		if state, err := c.deviceManager.Get(dev.ID); err == nil && hasState(state) {
+			continue
+		}
+		if payload := getPayload(dev); payload != nil {
+			c.seed.mu.Lock()
+			c.seed.requested[dev.ID] = true
+			c.seed.mu.Unlock()
+			token := c.client.Publish(dev.MQTT.StateTopic+"/get", 0, false, payload)
+			if token.Wait() && token.Error() != nil {
+				log.Debug("Failed to request state of %s: %v", dev.ID, token.Error())
+				c.seed.mu.Lock()
+				delete(c.seed.requested, dev.ID)
+				c.seed.mu.Unlock()
+				continue
+			}
+			requested = append(requested, dev)
+		}
+	}
+
+	c.seed.mu.Lock()
+	c.seed.allAnswered()
+	answered := c.seed.answered
+	c.seed.mu.Unlock()
+
+	select {
+	case <-answered:
+	case <-time.After(timeout):
+	}
+
+	c.seed.mu.Lock()
+	c.seed.active = false
+	missing := make([]string, 0, len(c.seed.requested))
+	for id := range c.seed.requested {
+		missing = append(missing, id)
+	}
+	retained, replies := c.seed.retained, c.seed.replies
+	c.seed.mu.Unlock()
+
+	log.Info("Seeded device state in %v: %d retained message(s), %d of %d /get request(s) answered",
+		time.Since(started).Round(time.Millisecond), retained, replies, len(requested))
+	if len(missing) > 0 {
+		log.Debug("No state from %s yet", strings.Join(missing, ", "))
+	}
+}
+
+// seedOnly reports whether a state message only fills the device manager:
+// during the startup window, retained messages and the first answer of a
+// device asked with /get
+func (c *Client) seedOnly(dev *types.Device, retained bool) bool {
+	c.seed.mu.Lock()
+	defer c.seed.mu.Unlock()
+	if !c.seed.active {
+		return false
+	}
+	if c.seed.requested[dev.ID] {
+		delete(c.seed.requested, dev.ID)
+		c.seed.replies++
+		c.seed.allAnswered()
+		return true
+	}
+	if retained {
+		c.seed.retained++
+		return true
+	}
+	return false
+}
+
+// seedState stores a state message without routing events
+func (c *Client) seedState(dev *types.Device, state map[string]interface{}) {
+	devices.NormalizePayload(dev, state)
+	if c.deviceManager != nil {
+		c.deviceManager.UpdateState(dev.ID, state)
+	}
+}
+
+// hasState reports whether a device reported anything besides availability
+func hasState(state map[string]interface{}) bool {
+	for attr := range state {
+		if attr != devices.AvailabilityAttribute {
+			return true
+		}
+	}
+	return false
+}
+
+// getPayload is the /get request for the readable attributes of a
+// Zigbee2MQTT device ({"state": "", "brightness": ""}), nil if it has none
+func getPayload(dev *types.Device) []byte {
+	request := make(map[string]string)
+	for _, attr := range dev.Attributes {
+		if gettableAttributes[attr] || strings.HasPrefix(attr, "state_") {
+			request[attr] = ""
+		}
+	}
+	if len(request) == 0 {
+		return nil
+	}
+	payload, _ := json.Marshal(request)
+	return payload
+}
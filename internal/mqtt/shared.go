@@ -0,0 +1,62 @@
+package mqtt
+
+import (
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// sharedClient turns subscriptions into shared subscriptions
+// ("$share/<group>/<filter>"), so instances running the same configuration
+// split the messages between them instead of each handling all of them.
+// Brokers accept it from MQTT 3.1.1 clients (Mosquitto 2, EMQX, HiveMQ);
+// messages arrive with their plain topic and the library routes them to the
+// shared filter's handler. It sits below the prefixedClient of Client.routing
+// only: device state, availability and discovery subscriptions must reach
+// every instance to keep its state, so they are never shared.
+type sharedClient struct {
+	mqtt.Client
+	group string
+}
+
+// newSharedClient wraps client; an empty group returns client unchanged
+func newSharedClient(client mqtt.Client, group string) mqtt.Client {
+	group = strings.Trim(group, "/")
+	if group == "" {
+		return client
+	}
+	return &sharedClient{Client: client, group: group}
+}
+
+// share adds the $share head to a filter. $SYS topics and filters already
+// shared are left alone.
+func (c *sharedClient) share(topic string) string {
+	if strings.HasPrefix(topic, "$") {
+		return topic
+	}
+	return "$share/" + c.group + "/" + topic
+}
+
+func (c *sharedClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.Client.Subscribe(c.share(topic), qos, callback)
+}
+
+func (c *sharedClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	shared := make(map[string]byte, len(filters))
+	for topic, qos := range filters {
+		shared[c.share(topic)] = qos
+	}
+	return c.Client.SubscribeMultiple(shared, callback)
+}
+
+func (c *sharedClient) Unsubscribe(topics ...string) mqtt.Token {
+	shared := make([]string, len(topics))
+	for i, topic := range topics {
+		shared[i] = c.share(topic)
+	}
+	return c.Client.Unsubscribe(shared...)
+}
+
+func (c *sharedClient) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.Client.AddRoute(c.share(topic), callback)
+}
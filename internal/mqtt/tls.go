@@ -0,0 +1,61 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// isTLSBroker reports whether the broker URL uses an encrypted transport
+func isTLSBroker(brokerURL string) bool {
+	for _, scheme := range []string{"ssl://", "tls://", "mqtts://", "tcps://", "wss://"} {
+		if strings.HasPrefix(brokerURL, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg Config) hasTLSOptions() bool {
+	return cfg.CACert != "" || cfg.ClientCert != "" || cfg.ClientKey != "" || cfg.InsecureSkipVerify
+}
+
+// tlsConfig builds the TLS settings for an encrypted broker connection:
+// the CA file (or the system roots) to verify the broker, and an optional
+// client certificate
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.InsecureSkipVerify {
+		log.Warn("MQTT broker certificate is not verified (insecure_skip_verify)")
+	}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("MQTT client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,54 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTopicLength is the longest topic MQTT allows, in bytes
+const maxTopicLength = 65535
+
+// ValidateTopic checks the syntax of a topic messages are published to:
+// not empty, valid UTF-8 and without wildcards
+func ValidateTopic(topic string) error {
+	if err := checkTopicString(topic); err != nil {
+		return err
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("topic %q: wildcards are only allowed in subscriptions", topic)
+	}
+	return nil
+}
+
+// ValidateFilter checks the syntax of a topic filter to subscribe to: + must
+// fill a whole level and # must be the last level
+func ValidateFilter(filter string) error {
+	if err := checkTopicString(filter); err != nil {
+		return err
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return fmt.Errorf("topic filter %q: # must be the last level", filter)
+		case level != "+" && level != "#" && strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("topic filter %q: wildcards must fill a whole level", filter)
+		}
+	}
+	return nil
+}
+
+func checkTopicString(topic string) error {
+	switch {
+	case topic == "":
+		return fmt.Errorf("empty topic")
+	case len(topic) > maxTopicLength:
+		return fmt.Errorf("topic %.40q...: longer than %d bytes", topic, maxTopicLength)
+	case !utf8.ValidString(topic):
+		return fmt.Errorf("topic %q: invalid UTF-8", topic)
+	case strings.ContainsRune(topic, 0):
+		return fmt.Errorf("topic %q: contains a NUL character", topic)
+	}
+	return nil
+}
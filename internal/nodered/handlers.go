@@ -0,0 +1,342 @@
+package nodered
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/events"
+	"homescript-server/internal/types"
+	"strconv"
+	"strings"
+)
+
+// stateKey is the flow context variable holding the device states the
+// guards read, {device: {attribute: value}}
+const stateKey = "homescript_state"
+
+// parseFunction turns a state message into attributes like the server does
+// for the topic (JSON, a plain value or dotted JSON fields, then value
+// translation), stores them under stateKey and passes them on as payload
+const parseFunction = `const spec = %s;
+let values;
+if (spec.attribute) {
+    const text = String(msg.payload).trim();
+    values = {[spec.attribute]: text !== "" && !isNaN(text) ? Number(text) : text};
+} else {
+    let data = msg.payload;
+    if (typeof data === "string") {
+        try { data = JSON.parse(data); } catch (e) { return null; }
+    }
+    if (data === null || typeof data !== "object") return null;
+    values = data;
+    if (spec.fields) {
+        values = {};
+        for (const [attr, path] of Object.entries(spec.fields)) {
+            let value = data;
+            for (const part of path.split(".")) {
+                value = value !== null && typeof value === "object" ? value[part] : undefined;
+            }
+            if (value !== undefined) values[attr] = value;
+        }
+    }
+}
+for (const [attr, map] of Object.entries(spec.values || {})) {
+    if (attr in values && String(values[attr]) in map) values[attr] = map[String(values[attr])];
+}
+const states = flow.get("` + stateKey + `") || {};
+states[spec.device] = Object.assign(states[spec.device] || {}, values);
+flow.set("` + stateKey + `", states);
+msg.payload = values;
+return msg;`
+
+// stateSpec is the parseFunction setup of a state topic
+type stateSpec struct {
+	Device    string                       `json:"device"`
+	Attribute string                       `json:"attribute,omitempty"`
+	Fields    map[string]string            `json:"fields,omitempty"`
+	Values    map[string]map[string]string `json:"values,omitempty"`
+}
+
+// AddHandler exports the conditions.yaml of a handler directory (relative
+// to events/, e.g. device/hallway_motion/occupancy) as a guard: the event
+// that runs the directory's scripts passes through the conditions and the
+// cooldown to a link out node, where the converted scripts attach. scripts
+// are listed in a comment; Lua isn't converted.
+func (e *Exporter) AddHandler(dir string, conditions *events.Conditions, scripts []string) error {
+	if conditions == nil {
+		conditions = &events.Conditions{}
+	}
+
+	// Check everything before adding nodes, so a failed handler leaves no tab
+	parts := strings.Split(dir, "/")
+	var trigger *types.Device
+	var crontab string
+	switch {
+	case len(parts) == 3 && parts[0] == "device" && parts[2] != "actions":
+		dev, err := e.device(parts[1])
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+		trigger = dev
+	case len(parts) == 2 && parts[0] == "time":
+		var err error
+		if crontab, err = timeCrontab(parts[1]); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	default:
+		return fmt.Errorf("%s: only device/<id>/<attribute> and time/<HH_MM> handlers can be exported", dir)
+	}
+	guard, err := e.guardFunction(dir, conditions)
+	if err != nil {
+		return err
+	}
+
+	tab := e.tab("handler/"+dir, "Handler "+dir, "Exported from events/"+dir+"/conditions.yaml")
+	guardID, outID := nodeID("handler", dir, "guard"), nodeID("handler", dir, "out")
+	row := 1
+
+	// Trigger: the state topics of the device, or a time of day
+	seen := map[string]bool{}
+	if trigger != nil {
+		seen[trigger.ID] = true
+		filterID := nodeID("handler", dir, "filter")
+		e.stateNodes(tab, dir, trigger, &row, filterID)
+		// Device handlers run when the attribute changes, not on every report
+		e.add(tab, 2, 1, Node{
+			"id":        filterID,
+			"type":      "rbe",
+			"name":      parts[2] + " changed",
+			"func":      "rbe",
+			"gap":       "",
+			"start":     "",
+			"inout":     "out",
+			"septopics": false,
+			"property":  "payload." + parts[2],
+			"topi":      "topic",
+			"wires":     [][]string{{guardID}},
+		})
+	} else {
+		e.add(tab, 2, 1, Node{
+			"id":          nodeID("handler", dir, "inject"),
+			"type":        "inject",
+			"name":        parts[1],
+			"props":       []map[string]string{{"p": "payload"}},
+			"repeat":      "",
+			"crontab":     crontab,
+			"once":        false,
+			"topic":       "",
+			"payload":     "",
+			"payloadType": "date",
+			"wires":       [][]string{{guardID}},
+		})
+	}
+
+	// Devices the conditions read keep their state in the flow context
+	for _, dc := range conditions.Devices {
+		dev, _ := e.device(dc.Device)
+		if !seen[dev.ID] {
+			seen[dev.ID] = true
+			e.stateNodes(tab, dir, dev, &row, "")
+		}
+	}
+
+	e.add(tab, 3, 1, Node{
+		"id":         guardID,
+		"type":       "function",
+		"name":       "conditions.yaml",
+		"func":       guard,
+		"outputs":    1,
+		"timeout":    0,
+		"noerr":      0,
+		"initialize": "",
+		"finalize":   "",
+		"libs":       []string{},
+		"wires":      [][]string{{outID}},
+	})
+	e.add(tab, 4, 1, Node{
+		"id":    outID,
+		"type":  "link out",
+		"name":  dir,
+		"mode":  "link",
+		"links": []string{},
+	})
+
+	info := "No scripts in this directory."
+	if len(scripts) > 0 {
+		info = "Convert these scripts and wire them to the link out node:\n\n- " + strings.Join(scripts, "\n- ")
+	}
+	e.add(tab, 0, 0, Node{
+		"id":   nodeID("handler", dir, "comment"),
+		"type": "comment",
+		"name": fmt.Sprintf("events/%s: %d Lua script(s) to convert", dir, len(scripts)),
+		"info": info,
+	})
+	return nil
+}
+
+// stateNodes subscribes to the state topics of a device, parsing and
+// storing what it reports; the parsed state goes to next if set
+func (e *Exporter) stateNodes(tab, dir string, dev *types.Device, row *int, next string) {
+	var wires [][]string
+	if next != "" {
+		wires = [][]string{{next}}
+	}
+	if dev.Dialect != "" {
+		e.warn("%s: %s uses payload dialect %s, which the flow doesn't apply", dir, dev.ID, dev.Dialect)
+	}
+
+	states := dev.StateTopics
+	if len(states) == 0 {
+		states = []*types.TemplateState{{Topic: dev.MQTT.StateTopic}}
+	}
+
+	for i, st := range states {
+		if st.ValueTemplate != "" {
+			e.warn("%s: %s reports %s through a value template, which the flow doesn't evaluate", dir, dev.ID, st.Topic)
+			continue
+		}
+		spec, _ := json.Marshal(stateSpec{Device: dev.ID, Attribute: st.Attribute, Fields: st.Fields, Values: st.Values})
+		key := []string{"handler", dir, dev.ID, strconv.Itoa(i)}
+		inID, parseID := nodeID(append(key, "in")...), nodeID(append(key, "parse")...)
+		e.add(tab, 0, *row, Node{
+			"id":       inID,
+			"type":     "mqtt in",
+			"name":     dev.ID,
+			"topic":    e.topic(st.Topic),
+			"qos":      strconv.Itoa(int(dev.MQTT.QoS)),
+			"datatype": "utf8",
+			"broker":   e.broker["id"],
+			"nl":       false,
+			"rap":      true,
+			"rh":       0,
+			"inputs":   0,
+			"wires":    [][]string{{parseID}},
+		})
+		e.add(tab, 1, *row, Node{
+			"id":         parseID,
+			"type":       "function",
+			"name":       "state of " + dev.ID,
+			"func":       fmt.Sprintf(parseFunction, spec),
+			"outputs":    1,
+			"timeout":    0,
+			"noerr":      0,
+			"initialize": "",
+			"finalize":   "",
+			"libs":       []string{},
+			"wires":      wires,
+		})
+		*row++
+	}
+}
+
+// guardFunction renders conditions as the body of a function node that
+// drops the message unless they pass, like the event router does
+func (e *Exporter) guardFunction(dir string, c *events.Conditions) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// events/%s/conditions.yaml\n", dir)
+	b.WriteString("const now = new Date();\n")
+
+	if tw := c.TimeWindow; tw != nil {
+		after, hasAfter := parseClock(tw.After)
+		before, hasBefore := parseClock(tw.Before)
+		b.WriteString("const minutes = now.getHours() * 60 + now.getMinutes();\n")
+		switch {
+		case hasAfter && hasBefore && after <= before:
+			fmt.Fprintf(&b, "if (!(minutes >= %d && minutes < %d)) return null; // %s-%s\n", after, before, tw.After, tw.Before)
+		case hasAfter && hasBefore:
+			fmt.Fprintf(&b, "if (!(minutes >= %d || minutes < %d)) return null; // %s-%s\n", after, before, tw.After, tw.Before)
+		case hasAfter:
+			fmt.Fprintf(&b, "if (minutes < %d) return null; // after %s\n", after, tw.After)
+		case hasBefore:
+			fmt.Fprintf(&b, "if (minutes >= %d) return null; // before %s\n", before, tw.Before)
+		}
+	}
+
+	if len(c.Weekdays) > 0 {
+		days := make([]string, len(c.Weekdays))
+		for i, d := range c.Weekdays {
+			days[i] = strconv.Itoa(d)
+		}
+		fmt.Fprintf(&b, "if (![%s].includes(now.getDay())) return null; // 0 = Sunday\n", strings.Join(days, ", "))
+	}
+
+	if len(c.Devices) > 0 {
+		b.WriteString("const states = flow.get(\"" + stateKey + "\") || {};\n")
+		b.WriteString("const value = (id, attr) => (states[id] || {})[attr];\n")
+		b.WriteString("const number = (v) => typeof v === \"number\" || (typeof v === \"string\" && v.trim() !== \"\") ? Number(v) : NaN;\n")
+	}
+	for _, dc := range c.Devices {
+		dev, err := e.device(dc.Device)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", dir, err)
+		}
+		value := fmt.Sprintf("value(%s, %s)", jsString(dev.ID), jsString(dc.Attribute))
+		if dc.Equals != nil {
+			fmt.Fprintf(&b, "if (String(%s) !== %s) return null;\n", value, jsString(fmt.Sprint(dc.Equals)))
+		}
+		if dc.NotEquals != nil {
+			fmt.Fprintf(&b, "if (String(%s) === %s) return null;\n", value, jsString(fmt.Sprint(dc.NotEquals)))
+		}
+		if dc.Above != nil || dc.Below != nil {
+			var checks []string
+			if dc.Above != nil {
+				checks = append(checks, fmt.Sprintf("n > %v", *dc.Above))
+			}
+			if dc.Below != nil {
+				checks = append(checks, fmt.Sprintf("n < %v", *dc.Below))
+			}
+			fmt.Fprintf(&b, "{ const n = number(%s); if (isNaN(n) || !(%s)) return null; }\n", value, strings.Join(checks, " && "))
+		}
+	}
+
+	if c.Cooldown > 0 {
+		fmt.Fprintf(&b, "if (Date.now() - (context.get(\"last_fired\") || 0) < %d) return null; // cooldown %v\n", c.Cooldown.Milliseconds(), c.Cooldown)
+		b.WriteString("context.set(\"last_fired\", Date.now());\n")
+	}
+	b.WriteString("return msg;")
+	return b.String(), nil
+}
+
+// timeCrontab converts a time handler directory (HH_MM, *_MM, *_* or
+// every_hour) to a Node-RED inject crontab
+func timeCrontab(name string) (string, error) {
+	if name == "every_hour" {
+		return "0 * * * *", nil
+	}
+	hour, minute, ok := strings.Cut(name, "_")
+	if !ok || !cronField(hour, 23) || !cronField(minute, 59) {
+		return "", fmt.Errorf("only fixed times can be exported, not sunrise and sunset")
+	}
+	return fmt.Sprintf("%s %s * * *", trimZero(minute), trimZero(hour)), nil
+}
+
+func cronField(s string, max int) bool {
+	if s == "*" {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0 && n <= max
+}
+
+func trimZero(s string) string {
+	if n, err := strconv.Atoi(s); err == nil {
+		return strconv.Itoa(n)
+	}
+	return s
+}
+
+// parseClock converts HH:MM to minutes after midnight
+func parseClock(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	var hour, minute int
+	if _, err := fmt.Sscanf(s, "%d:%d", &hour, &minute); err != nil {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
+
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
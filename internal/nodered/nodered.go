@@ -0,0 +1,220 @@
+// Package nodered renders declarative automations (scenes and the
+// conditions.yaml guards of handler directories) as Node-RED flow JSON, for
+// moving automations between homescript-server and Node-RED. Topics and
+// payloads are the ones the server itself publishes and parses.
+package nodered
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/scenes"
+	"homescript-server/internal/types"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Node is a node of a Node-RED flow; Node-RED keeps every node type's
+// settings as plain JSON properties
+type Node map[string]interface{}
+
+// Layout of the generated nodes on a tab
+const (
+	columnWidth = 220
+	rowHeight   = 60
+)
+
+// Exporter collects the nodes of exported automations. Each scene and
+// handler directory gets its own tab; all MQTT nodes share one broker.
+type Exporter struct {
+	devices     map[string]*types.Device // by ID and alias
+	skipped     map[string]string        // device ID -> why it has no MQTT commands
+	topicPrefix string
+	broker      Node
+	nodes       []Node
+	warnings    []string
+}
+
+// New creates an exporter for devices, connecting the flow to brokerURL
+// (tcp://host:port or ssl://host:port) with topicPrefix put before every topic
+func New(devs []*types.Device, brokerURL, topicPrefix string) (*Exporter, error) {
+	u, err := url.Parse(brokerURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid broker URL %q", brokerURL)
+	}
+	tls := u.Scheme == "ssl" || u.Scheme == "tls" || u.Scheme == "mqtts"
+	port := u.Port()
+	if port == "" {
+		port = "1883"
+		if tls {
+			port = "8883"
+		}
+	}
+
+	e := &Exporter{
+		devices:     make(map[string]*types.Device),
+		skipped:     make(map[string]string),
+		topicPrefix: topicPrefix,
+	}
+	for _, dev := range devs {
+		e.devices[dev.ID] = dev
+		for _, alias := range dev.Aliases {
+			e.devices[alias] = dev
+		}
+	}
+	e.broker = Node{
+		"id":              nodeID("broker"),
+		"type":            "mqtt-broker",
+		"name":            "homescript",
+		"broker":          u.Hostname(),
+		"port":            port,
+		"clientid":        "",
+		"autoConnect":     true,
+		"usetls":          tls,
+		"protocolVersion": "4",
+		"keepalive":       "60",
+		"cleansession":    true,
+	}
+	return e, nil
+}
+
+// Skip excludes a device that isn't controlled through its own MQTT topics
+// (virtual devices, Home Assistant discovery devices)
+func (e *Exporter) Skip(id, reason string) {
+	e.skipped[id] = reason
+}
+
+// Flow returns the nodes of everything exported so far
+func (e *Exporter) Flow() []Node {
+	return append([]Node{e.broker}, e.nodes...)
+}
+
+// Warnings lists what couldn't be exported or behaves differently in Node-RED
+func (e *Exporter) Warnings() []string {
+	return e.warnings
+}
+
+func (e *Exporter) warn(format string, args ...interface{}) {
+	e.warnings = append(e.warnings, fmt.Sprintf(format, args...))
+}
+
+// device looks up an exportable device by ID or alias
+func (e *Exporter) device(id string) (*types.Device, error) {
+	dev, ok := e.devices[id]
+	if !ok {
+		if reason, skipped := e.skipped[id]; skipped {
+			return nil, fmt.Errorf("%s is a %s", id, reason)
+		}
+		return nil, fmt.Errorf("unknown device %s", id)
+	}
+	if reason, skipped := e.skipped[dev.ID]; skipped {
+		return nil, fmt.Errorf("%s is a %s", id, reason)
+	}
+	return dev, nil
+}
+
+func (e *Exporter) topic(topic string) string {
+	return mqtt.PrefixTopic(e.topicPrefix, topic)
+}
+
+// tab adds a flow tab and returns its ID
+func (e *Exporter) tab(key, label, info string) string {
+	id := nodeID("tab", key)
+	e.nodes = append(e.nodes, Node{"id": id, "type": "tab", "label": label, "disabled": false, "info": info})
+	return id
+}
+
+// add places a node on a tab at a column and row of the layout
+func (e *Exporter) add(tab string, column, row int, node Node) {
+	node["z"] = tab
+	node["x"] = 140 + column*columnWidth
+	node["y"] = 60 + row*rowHeight
+	if _, ok := node["wires"]; !ok {
+		node["wires"] = [][]string{}
+	}
+	e.nodes = append(e.nodes, node)
+}
+
+// AddScene exports a scene as an inject node that publishes the commands
+// activating it. Devices without MQTT commands are left out with a warning.
+func (e *Exporter) AddScene(scene *scenes.Scene) {
+	tab := e.tab("scene/"+scene.Name, "Scene "+scene.Name, "Exported from scenes/"+scene.Name+".yaml")
+	inject := Node{
+		"id":          nodeID("scene", scene.Name, "inject"),
+		"type":        "inject",
+		"name":        "Activate " + scene.Name,
+		"props":       []map[string]string{{"p": "payload"}},
+		"repeat":      "",
+		"crontab":     "",
+		"once":        false,
+		"topic":       "",
+		"payload":     "",
+		"payloadType": "date",
+	}
+
+	ids := make([]string, 0, len(scene.Devices))
+	for id := range scene.Devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var targets []string
+	row := 0
+	for _, id := range ids {
+		dev, err := e.device(id)
+		if err != nil {
+			e.warn("scene %s: skipped %v", scene.Name, err)
+			continue
+		}
+		commands, err := devices.Commands(dev, scene.Devices[id])
+		if err != nil {
+			e.warn("scene %s: skipped %s: %v", scene.Name, id, err)
+			continue
+		}
+		for i, cmd := range commands {
+			key := []string{"scene", scene.Name, id, strconv.Itoa(i)}
+			setID, outID := nodeID(append(key, "set")...), nodeID(append(key, "out")...)
+			e.add(tab, 1, row, Node{
+				"id":   setID,
+				"type": "change",
+				"name": "",
+				"rules": []map[string]interface{}{{
+					"t": "set", "p": "payload", "pt": "msg", "to": string(cmd.Payload), "tot": "str",
+				}},
+				"wires": [][]string{{outID}},
+			})
+			e.add(tab, 2, row, e.mqttOut(outID, dev, cmd.Topic))
+			targets = append(targets, setID)
+			row++
+		}
+	}
+	inject["wires"] = [][]string{targets}
+	e.add(tab, 0, 0, inject)
+}
+
+// mqttOut publishes to a device topic with the device's QoS and retain flag
+func (e *Exporter) mqttOut(id string, dev *types.Device, topic string) Node {
+	return Node{
+		"id":     id,
+		"type":   "mqtt out",
+		"name":   dev.ID,
+		"topic":  e.topic(topic),
+		"qos":    strconv.Itoa(int(dev.MQTT.QoS)),
+		"retain": strconv.FormatBool(dev.MQTT.Retain),
+		"broker": e.broker["id"],
+	}
+}
+
+// nodeID derives a stable Node-RED node ID from a key, so a new export can
+// replace the nodes of an earlier import
+func nodeID(key ...string) string {
+	h := sha1.New()
+	for _, k := range key {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
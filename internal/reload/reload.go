@@ -0,0 +1,370 @@
+// Package reload applies changes to devices.yaml and checks the handler
+// scripts while the server runs. Everything is validated off to the side
+// first; the device registry is only swapped if nothing fails.
+package reload
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/events"
+	"homescript-server/internal/executor"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/scenes"
+	"homescript-server/internal/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var log = logger.Module("reload")
+
+// Changes lists what a reload adds, removes or changes, by name
+type Changes struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Count is the number of names changed
+func (c Changes) Count() int {
+	return len(c.Added) + len(c.Removed) + len(c.Changed)
+}
+
+// Result is the outcome of a reload
+type Result struct {
+	// Applied is false for a dry run or when validation failed
+	Applied bool     `json:"applied"`
+	Errors  []string `json:"errors,omitempty"`
+	Devices Changes  `json:"devices"`
+	Groups  Changes  `json:"groups"`
+	// Files are the handler scripts, libraries, conditions.yaml and
+	// params.yaml files and scenes, relative to the config directory
+	Files Changes `json:"files"`
+	// Restart lists changes that only take effect after a restart
+	Restart []string `json:"restart,omitempty"`
+}
+
+// Loader reads devices.yaml with its templates applied
+type Loader func() (*types.DevicesConfig, error)
+
+// Reloader swaps in a changed configuration as one step
+type Reloader struct {
+	basePath string
+	load     Loader
+	devices  *devices.Manager
+	client   *mqtt.Client
+	router   *events.Router
+
+	mu     sync.Mutex
+	config *types.DevicesConfig // as last applied
+	files  map[string][32]byte  // file -> content hash, as last applied
+}
+
+// New creates a Reloader for the configuration the server started with
+func New(basePath string, load Loader, current *types.DevicesConfig, deviceManager *devices.Manager, client *mqtt.Client, router *events.Router) *Reloader {
+	r := &Reloader{
+		basePath: basePath,
+		load:     load,
+		devices:  deviceManager,
+		client:   client,
+		router:   router,
+		config:   current,
+	}
+	r.files, _ = hashFiles(basePath)
+	return r
+}
+
+// Reload validates devices.yaml, the scripts, conditions and scenes, and
+// unless dryRun or something is invalid, swaps in the new devices and groups
+func (r *Reloader) Reload(dryRun bool) *Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &Result{}
+	next, err := r.load()
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("devices.yaml: %v", err))
+	} else {
+		result.Errors = append(result.Errors, r.checkDevices(next)...)
+		result.Devices = deviceChanges(r.devices.DiffConfigured(next.Devices))
+		result.Groups = groupChanges(r.config.Groups, next.Groups)
+		if !reflect.DeepEqual(r.config.Virtual, next.Virtual) {
+			result.Restart = append(result.Restart, "virtual devices in devices.yaml")
+		}
+	}
+
+	files, err := hashFiles(r.basePath)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	}
+	result.Files = fileChanges(r.files, files)
+	for _, file := range sortedKeys(files) {
+		if err := r.checkFile(file, next); err != nil {
+			result.Errors = append(result.Errors, strings.TrimSpace(err.Error()))
+		}
+	}
+	for _, file := range append(append([]string(nil), result.Files.Added...), result.Files.Changed...) {
+		if strings.HasPrefix(file, "services/") {
+			result.Restart = append(result.Restart, file)
+		}
+	}
+
+	if dryRun || len(result.Errors) > 0 {
+		if len(result.Errors) > 0 {
+			log.Warn("Reload rejected, nothing changed: %s", strings.Join(result.Errors, "; "))
+		}
+		return result
+	}
+
+	changes := r.devices.ReplaceConfigured(next.Devices, next.Groups)
+	for _, change := range changes {
+		if change.Old != nil {
+			r.client.UnsubscribeDevice(change.Old)
+		}
+		if change.New != nil {
+			r.client.SubscribeDevice(change.New)
+		}
+	}
+	r.router.RefreshScripts()
+	r.config = next
+	r.files = files
+	result.Devices = deviceChanges(changes)
+	result.Applied = true
+
+	log.Info("Reloaded: %d device, %d group and %d file change(s)",
+		result.Devices.Count(), result.Groups.Count(), result.Files.Count())
+	if len(result.Restart) > 0 {
+		log.Warn("Restart to apply: %s", strings.Join(result.Restart, ", "))
+	}
+	return result
+}
+
+// checkDevices reports devices.yaml problems the server would otherwise
+// only warn about
+func (r *Reloader) checkDevices(cfg *types.DevicesConfig) []string {
+	var errs []string
+	ids := make(map[string]bool)
+	for _, dev := range cfg.Devices {
+		switch {
+		case dev.ID == "":
+			errs = append(errs, fmt.Sprintf("devices.yaml: device %q has no id", dev.Name))
+		case ids[dev.ID]:
+			errs = append(errs, fmt.Sprintf("devices.yaml: duplicate device %s", dev.ID))
+		}
+		ids[dev.ID] = true
+	}
+	for _, v := range cfg.Virtual {
+		if ids[v.ID] {
+			errs = append(errs, fmt.Sprintf("devices.yaml: virtual device %s has the ID of a device", v.ID))
+		}
+		ids[v.ID] = true
+	}
+
+	aliases := make(map[string]string)
+	for _, dev := range cfg.Devices {
+		for _, alias := range dev.Aliases {
+			if ids[alias] {
+				errs = append(errs, fmt.Sprintf("devices.yaml: alias %s of %s is the ID of a device", alias, dev.ID))
+			} else if other, taken := aliases[alias]; taken {
+				errs = append(errs, fmt.Sprintf("devices.yaml: alias %s of %s is already used by %s", alias, dev.ID, other))
+			}
+			aliases[alias] = dev.ID
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Groups))
+	for name := range cfg.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		group := cfg.Groups[name]
+		if group == nil {
+			continue
+		}
+		for _, id := range group.Devices {
+			if !ids[id] {
+				errs = append(errs, fmt.Sprintf("devices.yaml: group %s references unknown device %s", name, id))
+			}
+		}
+	}
+	return errs
+}
+
+// checkFile compiles a script, or parses a conditions.yaml or params.yaml
+// file or scene
+func (r *Reloader) checkFile(file string, cfg *types.DevicesConfig) error {
+	path := filepath.Join(r.basePath, filepath.FromSlash(file))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasSuffix(file, ".lua"):
+		return executor.CheckSyntax(data, file)
+	case strings.HasPrefix(file, "scenes/"):
+		name := strings.TrimSuffix(filepath.Base(file), ".yaml")
+		if _, err := scenes.New(filepath.Dir(path), nil).Get(name); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	case filepath.Base(file) == "conditions.yaml":
+		conditions, err := events.ParseConditions(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, dc := range conditions.Devices {
+			if !r.knownDevice(dc.Device, cfg) {
+				return fmt.Errorf("%s: unknown device %s", file, dc.Device)
+			}
+		}
+	case filepath.Base(file) == executor.ParamsFile:
+		if _, err := executor.ParseParams(data); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// knownDevice reports whether id is a device after the reload (devices
+// added at runtime, like Home Assistant entities, count)
+func (r *Reloader) knownDevice(id string, cfg *types.DevicesConfig) bool {
+	if cfg != nil {
+		for _, dev := range cfg.Devices {
+			if dev.ID == id || contains(dev.Aliases, id) {
+				return true
+			}
+		}
+		for _, v := range cfg.Virtual {
+			if v.ID == id {
+				return true
+			}
+		}
+	}
+	if r.devices == nil {
+		// Validate without a server: only a broken devices.yaml leaves it open
+		return cfg == nil
+	}
+	_, ok := r.devices.GetDevice(id)
+	return ok
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFiles hashes the files a reload checks, by path relative to basePath
+func hashFiles(basePath string) (map[string][32]byte, error) {
+	files := make(map[string][32]byte)
+	for _, dir := range []string{"events", "lib", "services", "scenes"} {
+		root := filepath.Join(basePath, dir)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() || !(strings.HasSuffix(name, ".lua") || name == "conditions.yaml" || name == executor.ParamsFile ||
+				(dir == "scenes" && strings.HasSuffix(name, ".yaml"))) {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(basePath, path)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = sha256.Sum256(data)
+			return nil
+		})
+		if err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+func sortedKeys(files map[string][32]byte) []string {
+	keys := make([]string, 0, len(files))
+	for file := range files {
+		keys = append(keys, file)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func deviceChanges(changes []devices.DeviceChange) Changes {
+	var c Changes
+	for _, change := range changes {
+		switch {
+		case change.Old == nil:
+			c.Added = append(c.Added, change.New.ID)
+		case change.New == nil:
+			c.Removed = append(c.Removed, change.Old.ID)
+		default:
+			c.Changed = append(c.Changed, change.New.ID)
+		}
+	}
+	return c
+}
+
+func groupChanges(old, next map[string]*types.Group) Changes {
+	var c Changes
+	for name, group := range next {
+		previous, ok := old[name]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, name)
+		case !reflect.DeepEqual(previous, group):
+			c.Changed = append(c.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := next[name]; !ok {
+			c.Removed = append(c.Removed, name)
+		}
+	}
+	c.sort()
+	return c
+}
+
+func fileChanges(old, next map[string][32]byte) Changes {
+	var c Changes
+	for file, hash := range next {
+		previous, ok := old[file]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, file)
+		case previous != hash:
+			c.Changed = append(c.Changed, file)
+		}
+	}
+	for file := range old {
+		if _, ok := next[file]; !ok {
+			c.Removed = append(c.Removed, file)
+		}
+	}
+	c.sort()
+	return c
+}
+
+func (c *Changes) sort() {
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+	sort.Strings(c.Changed)
+}
@@ -0,0 +1,158 @@
+package reload
+
+import (
+	"fmt"
+	"homescript-server/internal/config"
+	"homescript-server/internal/devices"
+	"homescript-server/internal/events"
+	"homescript-server/internal/metrics"
+	"homescript-server/internal/mqtt"
+	"homescript-server/internal/types"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// reportedAttributes are attributes the server reports for any device, so
+// handler directories for them are valid whatever devices.yaml lists
+var reportedAttributes = []string{
+	devices.AvailabilityAttribute,
+	devices.StaleAttribute,
+	devices.CommandFailedAttribute,
+	devices.CommandBlockedAttribute,
+	metrics.LatencyAttribute,
+	mqtt.DegradedAttribute,
+	mqtt.FrigateEventAttribute,
+}
+
+// Validation is the outcome of Validate
+type Validation struct {
+	Devices int `json:"devices"`
+	// Files are the scripts, conditions.yaml and params.yaml files and scenes checked
+	Files  int      `json:"files"`
+	Errors []string `json:"errors,omitempty"`
+	// Warnings are handler directories no event will reach; devices the
+	// server adds at runtime (Home Assistant entities) show up here too
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Validate runs the checks of a reload without a running server, before a
+// restart: devices.yaml and server.yaml, MQTT topic syntax, every script,
+// conditions.yaml, params.yaml and scene, and whether the handler
+// directories refer to known devices, attributes and areas
+func Validate(basePath string, load Loader) *Validation {
+	v := &Validation{}
+	r := &Reloader{basePath: basePath}
+
+	cfg, err := load()
+	if err != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("devices.yaml: %v", err))
+	} else {
+		v.Devices = len(cfg.Devices) + len(cfg.Virtual)
+		v.Errors = append(v.Errors, r.checkDevices(cfg)...)
+		v.Errors = append(v.Errors, checkDeviceTopics(cfg)...)
+	}
+
+	server, err := config.LoadServerConfig(filepath.Join(basePath, "server.yaml"))
+	if err != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("server.yaml: %v", err))
+	} else {
+		for _, sub := range server.Subscriptions {
+			if err := mqtt.ValidateFilter(sub.Topic); err != nil {
+				v.Errors = append(v.Errors, fmt.Sprintf("server.yaml: subscription: %v", err))
+			}
+		}
+	}
+
+	files, err := hashFiles(basePath)
+	if err != nil {
+		v.Errors = append(v.Errors, err.Error())
+	}
+	v.Files = len(files)
+	for _, file := range sortedKeys(files) {
+		if err := r.checkFile(file, cfg); err != nil {
+			v.Errors = append(v.Errors, strings.TrimSpace(err.Error()))
+		}
+	}
+
+	if cfg != nil {
+		known, areas := handlerTargets(cfg)
+		v.Warnings = events.CheckHandlerDirs(basePath, known, areas)
+	}
+	return v
+}
+
+// checkDeviceTopics checks the syntax of the topics of each device
+func checkDeviceTopics(cfg *types.DevicesConfig) []string {
+	var errs []string
+	check := func(dev *types.Device, what string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("devices.yaml: %s %s: %v", dev.ID, what, err))
+		}
+	}
+	for _, dev := range cfg.Devices {
+		if dev.MQTT.StateTopic != "" {
+			check(dev, "state_topic", mqtt.ValidateFilter(dev.MQTT.StateTopic))
+		}
+		if dev.MQTT.CommandTopic != "" {
+			check(dev, "command_topic", mqtt.ValidateTopic(dev.MQTT.CommandTopic))
+		}
+		if dev.MQTT.AvailabilityTopic != "" {
+			check(dev, "availability_topic", mqtt.ValidateFilter(dev.MQTT.AvailabilityTopic))
+		}
+		for _, state := range dev.StateTopics {
+			check(dev, "template state topic", mqtt.ValidateFilter(state.Topic))
+		}
+		for attr, command := range dev.Commands {
+			check(dev, "template command for "+attr, mqtt.ValidateTopic(command.Topic))
+		}
+	}
+	sort.Strings(errs)
+	return errs
+}
+
+// handlerTargets maps the device IDs and aliases of cfg to the attributes
+// their events may carry (nil if devices.yaml doesn't list them), and lists
+// the areas
+func handlerTargets(cfg *types.DevicesConfig) (map[string][]string, []string) {
+	known := make(map[string][]string)
+	areaSet := make(map[string]bool)
+	for _, dev := range cfg.Devices {
+		var attributes []string
+		if len(dev.Attributes) > 0 || len(dev.Schema) > 0 || len(dev.StateTopics) > 0 {
+			attributes = append(append([]string(nil), reportedAttributes...), dev.Attributes...)
+			for attr := range dev.Schema {
+				attributes = append(attributes, attr)
+			}
+			for _, state := range dev.StateTopics {
+				if state.Attribute != "" {
+					attributes = append(attributes, state.Attribute)
+				}
+				for attr := range state.Fields {
+					attributes = append(attributes, attr)
+				}
+			}
+			sort.Strings(attributes)
+		}
+		known[dev.ID] = attributes
+		for _, alias := range dev.Aliases {
+			known[alias] = attributes
+		}
+		if dev.Area != "" {
+			areaSet[dev.Area] = true
+		}
+	}
+	for _, v := range cfg.Virtual {
+		known[v.ID] = nil
+		if v.Area != "" {
+			areaSet[v.Area] = true
+		}
+	}
+
+	areas := make([]string, 0, len(areaSet))
+	for area := range areaSet {
+		areas = append(areas, area)
+	}
+	sort.Strings(areas)
+	return known, areas
+}
@@ -0,0 +1,256 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// automationName restricts names used as file and directory names
+var automationName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// clockTime matches the HH:MM of a time trigger
+var clockTime = regexp.MustCompile(`^([01][0-9]|2[0-3]):([0-5][0-9])$`)
+
+// Automation describes a handler created by the web wizard
+type Automation struct {
+	Name string // script file name without .lua
+
+	// Trigger is "device", "time" or "webhook"
+	Trigger   string
+	Device    string // device trigger
+	Attribute string // device trigger
+	Time      string // time trigger: "HH:MM", "sunrise" or "sunset"
+	Webhook   string // webhook trigger: webhook event type
+
+	// Optional conditions, checked by the generated script
+	After    string // "HH:MM"
+	Before   string // "HH:MM"
+	Weekdays []int  // 0 = Sunday
+	// Only run if CondDevice's CondAttribute equals CondValue
+	CondDevice    string
+	CondAttribute string
+	CondValue     string
+
+	// Body is "set", "scene", "log" or "blank"
+	Body        string
+	Target      string // "set": device to control
+	TargetAttr  string // "set": attribute
+	TargetValue string // "set": value; "scene": scene name; "log": message
+}
+
+// AutomationPath returns where the handler for a is written, relative to basePath
+func AutomationPath(a *Automation) (string, error) {
+	if !automationName.MatchString(a.Name) {
+		return "", fmt.Errorf("invalid name %q: use letters, digits, - and _", a.Name)
+	}
+
+	var dir string
+	switch a.Trigger {
+	case "device":
+		if a.Device == "" || a.Attribute == "" {
+			return "", fmt.Errorf("device trigger needs a device and an attribute")
+		}
+		if !safePath(a.Device) || !safePath(a.Attribute) {
+			return "", fmt.Errorf("invalid device or attribute")
+		}
+		dir = filepath.Join("events", "device", a.Device, a.Attribute)
+	case "time":
+		switch {
+		case a.Time == "sunrise" || a.Time == "sunset":
+			dir = filepath.Join("events", "time", a.Time)
+		case clockTime.MatchString(a.Time):
+			dir = filepath.Join("events", "time", strings.Replace(a.Time, ":", "_", 1))
+		default:
+			return "", fmt.Errorf("invalid time %q: use HH:MM, sunrise or sunset", a.Time)
+		}
+	case "webhook":
+		if !automationName.MatchString(a.Webhook) {
+			return "", fmt.Errorf("invalid webhook name %q", a.Webhook)
+		}
+		dir = filepath.Join("events", "webhook", a.Webhook)
+	default:
+		return "", fmt.Errorf("unknown trigger %q", a.Trigger)
+	}
+
+	return filepath.Join(dir, a.Name+".lua"), nil
+}
+
+// WriteAutomation writes the handler script for a below basePath and returns
+// its path. Existing files are never overwritten. The router picks the new
+// script up on the next matching event, no restart needed.
+func WriteAutomation(basePath string, a *Automation) (string, error) {
+	rel, err := AutomationPath(a)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range []string{a.After, a.Before} {
+		if v != "" && !clockTime.MatchString(v) {
+			return "", fmt.Errorf("invalid time %q: use HH:MM", v)
+		}
+	}
+
+	switch {
+	case a.Body == "set" && (a.Target == "" || a.TargetAttr == ""):
+		return "", fmt.Errorf("setting a device needs a device and an attribute")
+	case a.Body == "scene" && a.TargetValue == "":
+		return "", fmt.Errorf("activating a scene needs a scene name")
+	}
+
+	path := filepath.Join(basePath, rel)
+	if fileExists(path) {
+		return "", fmt.Errorf("%s already exists", rel)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := writeScript(path, AutomationScript(a)); err != nil {
+		return "", err
+	}
+
+	log.Info("Created automation: %s", path)
+	return path, nil
+}
+
+// AutomationScript renders the Lua handler for a
+func AutomationScript(a *Automation) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "-- Automation: %s (created with the web wizard)\n", luaEscape(a.Name))
+	switch a.Trigger {
+	case "device":
+		fmt.Fprintf(&b, "-- Runs when %s.%s changes\n", luaEscape(a.Device), luaEscape(a.Attribute))
+	case "time":
+		fmt.Fprintf(&b, "-- Runs at %s\n", luaEscape(a.Time))
+	case "webhook":
+		fmt.Fprintf(&b, "-- Runs on POST /webhook/%s and POST /api/events/%s\n", luaEscape(a.Webhook), luaEscape(a.Webhook))
+	}
+	b.WriteString("\n")
+
+	if a.After != "" || a.Before != "" {
+		b.WriteString("local now = os.date(\"%H:%M\")\n")
+		after, before := quote(a.After), quote(a.Before)
+		switch {
+		case a.After != "" && a.Before != "" && a.After > a.Before:
+			// Window crosses midnight
+			fmt.Fprintf(&b, "if now < %s and now >= %s then\n    return\nend\n", after, before)
+		case a.After != "" && a.Before != "":
+			fmt.Fprintf(&b, "if now < %s or now >= %s then\n    return\nend\n", after, before)
+		case a.After != "":
+			fmt.Fprintf(&b, "if now < %s then\n    return\nend\n", after)
+		default:
+			fmt.Fprintf(&b, "if now >= %s then\n    return\nend\n", before)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(a.Weekdays) > 0 {
+		days := make([]string, len(a.Weekdays))
+		for i, d := range a.Weekdays {
+			days[i] = fmt.Sprintf("[%d] = true", d)
+		}
+		fmt.Fprintf(&b, "local weekdays = {%s}\n", strings.Join(days, ", "))
+		b.WriteString("if not weekdays[tonumber(os.date(\"%w\"))] then\n    return\nend\n\n")
+	}
+
+	if a.CondDevice != "" && a.CondAttribute != "" {
+		fmt.Fprintf(&b, "local condition = device.get(%s)\n", quote(a.CondDevice))
+		fmt.Fprintf(&b, "if not condition or tostring(condition%s) ~= %s then\n    return\nend\n\n",
+			luaIndex(a.CondAttribute), quote(a.CondValue))
+	}
+
+	switch a.Body {
+	case "set":
+		fmt.Fprintf(&b, "device.set(%s, {%s = %s})\n", quote(a.Target), luaKey(a.TargetAttr), luaValue(a.TargetValue))
+		fmt.Fprintf(&b, "log.info(%s)\n", quote(fmt.Sprintf("%s: set %s.%s to %s", a.Name, a.Target, a.TargetAttr, a.TargetValue)))
+	case "scene":
+		fmt.Fprintf(&b, "scene.activate(%s)\n", quote(a.TargetValue))
+	case "log":
+		fmt.Fprintf(&b, "log.info(%s)\n", quote(a.TargetValue))
+	default:
+		b.WriteString("-- Your code here\n")
+	}
+
+	return b.String()
+}
+
+// luaIdentifier matches names usable as plain Lua table keys
+var luaIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// luaNumber matches the decimal numbers written as Lua number literals
+var luaNumber = regexp.MustCompile(`^-?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+// safePath rejects path elements that would escape the events directory,
+// and control characters (device IDs may contain "/", e.g. ha/<entity>)
+func safePath(s string) bool {
+	if s == "" || strings.Contains(s, `\`) || strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return false
+	}
+	for _, part := range strings.Split(s, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// luaKey returns a table constructor key: name or ["na-me"]
+func luaKey(name string) string {
+	if luaIdentifier.MatchString(name) {
+		return name
+	}
+	return "[" + quote(name) + "]"
+}
+
+// luaIndex returns a field access: .name or ["na-me"]
+func luaIndex(name string) string {
+	if luaIdentifier.MatchString(name) {
+		return "." + name
+	}
+	return "[" + quote(name) + "]"
+}
+
+// quote returns s as a Lua string literal
+func quote(s string) string {
+	return `"` + luaEscape(s) + `"`
+}
+
+// luaEscape escapes s for a double-quoted Lua string, which also keeps it on
+// one line in a comment. Control bytes become decimal escapes, the only kind
+// Lua 5.1 knows besides the C ones; other bytes, UTF-8 included, stay as is.
+func luaEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// luaValue writes decimal numbers and booleans as Lua literals, anything
+// else (Inf, NaN, hex floats) as a string
+func luaValue(s string) string {
+	if luaNumber.MatchString(s) {
+		return s
+	}
+	if s == "true" || s == "false" {
+		return s
+	}
+	return quote(s)
+}
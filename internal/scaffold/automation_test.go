@@ -0,0 +1,85 @@
+package scaffold
+
+import (
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestLuaValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"42", "42"},
+		{"-1.5", "-1.5"},
+		{".5", ".5"},
+		{"1e3", "1e3"},
+		{"true", "true"},
+		{"ON", `"ON"`},
+		{"NaN", `"NaN"`},
+		{"Inf", `"Inf"`},
+		{"-infinity", `"-infinity"`},
+		{"0x1p-2", `"0x1p-2"`},
+		{"1_000", `"1_000"`},
+		{"+5", `"+5"`},
+		{"a\"b\\c", `"a\"b\\c"`},
+		{"line\nbreak\x1b ", "\"line\\nbreak\\027 \""},
+	}
+	for _, tt := range tests {
+		if got := luaValue(tt.in); got != tt.want {
+			t.Errorf("luaValue(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	for _, s := range []string{"porch", "ha/light.kitchen", "Living Room Lamp"} {
+		if !safePath(s) {
+			t.Errorf("safePath(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"", "..", "a/../b", "a//b", `a\b`, "a\nb", "a\x00b"} {
+		if safePath(s) {
+			t.Errorf("safePath(%q) = true, want false", s)
+		}
+	}
+}
+
+// TestAutomationScriptEscapes runs scripts generated from hostile input:
+// none of it may end up as code
+func TestAutomationScriptEscapes(t *testing.T) {
+	hostile := "x\nos.exit(1)\n--"
+	tests := []*Automation{
+		{Name: "n", Trigger: "device", Device: hostile, Attribute: hostile, Body: "blank"},
+		{Name: "n", Trigger: "time", Time: "07:00", CondDevice: hostile, CondAttribute: hostile, CondValue: hostile, Body: "log", TargetValue: hostile},
+		{Name: "n", Trigger: "time", Time: "07:00", Body: "set", Target: hostile, TargetAttr: hostile, TargetValue: "NaN\x1b\"]]"},
+	}
+	for i, a := range tests {
+		script := AutomationScript(a)
+		if strings.Contains(script, "\nos.exit") {
+			t.Errorf("automation %d: input became code:\n%s", i, script)
+		}
+		L := lua.NewState()
+		if _, err := L.LoadString(script); err != nil {
+			t.Errorf("automation %d: generated script doesn't compile: %v\n%s", i, err, script)
+		}
+		L.Close()
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	for _, s := range []string{"plain", "tab\tquote\"back\\slash", "esc\x1b del\x7f nul\x00 1", "  ünïcode ✓", "\r\n"} {
+		if err := L.DoString("return " + quote(s)); err != nil {
+			t.Errorf("quote(%q) = %s: %v", s, quote(s), err)
+			continue
+		}
+		if got := L.Get(-1).String(); got != s {
+			t.Errorf("quote(%q) reads back as %q", s, got)
+		}
+		L.Pop(1)
+	}
+}
@@ -2,6 +2,7 @@ package scaffold
 
 import (
 	"fmt"
+	"homescript-server/internal/executor"
 	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
 	"os"
@@ -9,29 +10,41 @@ import (
 	"strings"
 )
 
+var log = logger.Module("scaffold")
+
 // GenerateScaffolds creates the directory structure and script templates
 func GenerateScaffolds(devices []*types.Device, basePath string) error {
 	// Generate helper libraries
 	if err := generateHelpers(basePath); err != nil {
-		logger.Warn("Failed to generate helper libraries: %v", err)
+		log.Warn("Failed to generate helper libraries: %v", err)
 	}
 
 	// Generate device scaffolds
 	for _, dev := range devices {
 		if err := generateDeviceScaffold(dev, basePath); err != nil {
-			logger.Warn("Failed to generate scaffold for %s: %v", dev.ID, err)
+			log.Warn("Failed to generate scaffold for %s: %v", dev.ID, err)
 			continue
 		}
 	}
 
 	// Generate time event scaffolds
 	if err := generateTimeScaffolds(basePath); err != nil {
-		logger.Warn("Failed to generate time scaffolds: %v", err)
+		log.Warn("Failed to generate time scaffolds: %v", err)
 	}
 
 	return nil
 }
 
+// GenerateDeviceScaffolds creates missing handler scripts for the given devices only
+func GenerateDeviceScaffolds(devices []*types.Device, basePath string) error {
+	for _, dev := range devices {
+		if err := generateDeviceScaffold(dev, basePath); err != nil {
+			return fmt.Errorf("scaffold for %s: %w", dev.ID, err)
+		}
+	}
+	return nil
+}
+
 func generateDeviceScaffold(dev *types.Device, basePath string) error {
 	devicePath := filepath.Join(basePath, "events", "device", dev.ID)
 
@@ -55,10 +68,10 @@ func generateDeviceScaffold(dev *types.Device, basePath string) error {
 		scriptPath := filepath.Join(attrPath, "on_change.lua")
 		if !fileExists(scriptPath) {
 			template := generateAttributeScript(dev, attr)
-			if err := os.WriteFile(scriptPath, []byte(template), 0644); err != nil {
+			if err := writeScript(scriptPath, template); err != nil {
 				return err
 			}
-			logger.Debug("Created: %s", scriptPath)
+			log.Debug("Created: %s", scriptPath)
 		}
 	}
 
@@ -82,10 +95,10 @@ func generateDeviceScaffold(dev *types.Device, basePath string) error {
 			scriptPath := filepath.Join(actionsPath, action+".lua")
 			if !fileExists(scriptPath) {
 				template := generateActionScript(dev, action)
-				if err := os.WriteFile(scriptPath, []byte(template), 0644); err != nil {
+				if err := writeScript(scriptPath, template); err != nil {
 					return err
 				}
-				logger.Debug("Created: %s", scriptPath)
+				log.Debug("Created: %s", scriptPath)
 			}
 		}
 	}
@@ -302,6 +315,7 @@ end`
 
 local new_value = event.data.%s
 local old_value = state.get("device.%s.%s")
+-- event.first_report is true for the device's first value (old_value is nil then)
 
 -- Save new value to state
 state.set("device.%s.%s", new_value)
@@ -558,6 +572,12 @@ return %s
 `, dev.Name, dev.Vendor, dev.Model, action, description, action, action, dev.ID, actionCode, action, action)
 }
 
+// writeScript writes a handler script pinned to the current scripting API version
+func writeScript(path, content string) error {
+	header := fmt.Sprintf("-- @api_version %d\n", executor.CurrentAPIVersion)
+	return os.WriteFile(path, []byte(header+content), 0644)
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -617,10 +637,10 @@ func generateTimeScaffolds(basePath string) error {
 
 		scriptPath := filepath.Join(eventPath, "handler.lua")
 		if !fileExists(scriptPath) {
-			if err := os.WriteFile(scriptPath, []byte(timeEvent.template), 0644); err != nil {
+			if err := writeScript(scriptPath, timeEvent.template); err != nil {
 				return err
 			}
-			logger.Debug("Created: %s", scriptPath)
+			log.Debug("Created: %s", scriptPath)
 		}
 	}
 
@@ -641,10 +661,10 @@ func generateTimeScaffolds(basePath string) error {
 
 		scriptPath := filepath.Join(offsetPath, "handler.lua")
 		if !fileExists(scriptPath) {
-			if err := os.WriteFile(scriptPath, []byte(offset.template), 0644); err != nil {
+			if err := writeScript(scriptPath, offset.template); err != nil {
 				return err
 			}
-			logger.Debug("Created: %s", scriptPath)
+			log.Debug("Created: %s", scriptPath)
 		}
 	}
 
@@ -665,10 +685,10 @@ func generateTimeScaffolds(basePath string) error {
 
 		scriptPath := filepath.Join(offsetPath, "handler.lua")
 		if !fileExists(scriptPath) {
-			if err := os.WriteFile(scriptPath, []byte(offset.template), 0644); err != nil {
+			if err := writeScript(scriptPath, offset.template); err != nil {
 				return err
 			}
-			logger.Debug("Created: %s", scriptPath)
+			log.Debug("Created: %s", scriptPath)
 		}
 	}
 
@@ -678,7 +698,7 @@ func generateTimeScaffolds(basePath string) error {
 		if err := os.WriteFile(readmePath, []byte(generateTimeReadme()), 0644); err != nil {
 			return err
 		}
-		logger.Debug("Created: %s", readmePath)
+		log.Debug("Created: %s", readmePath)
 	}
 
 	return nil
@@ -891,7 +911,7 @@ func generateHelpers(basePath string) error {
 		if err := os.WriteFile(frigateHelperPath, []byte(getFrigateHelperContent()), 0644); err != nil {
 			return err
 		}
-		logger.Debug("Created: %s", frigateHelperPath)
+		log.Debug("Created: %s", frigateHelperPath)
 	}
 
 	// Generate color_helpers.lua
@@ -900,7 +920,7 @@ func generateHelpers(basePath string) error {
 		if err := os.WriteFile(colorHelperPath, []byte(getColorHelperContent()), 0644); err != nil {
 			return err
 		}
-		logger.Debug("Created: %s", colorHelperPath)
+		log.Debug("Created: %s", colorHelperPath)
 	}
 
 	return nil
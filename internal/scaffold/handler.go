@@ -0,0 +1,79 @@
+package scaffold
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handlerSources are the event sources with a directory under events/
+var handlerSources = map[string]bool{
+	"device": true, "area": true, "mqtt": true, "state": true,
+	"time": true, "custom": true, "system": true, "webhook": true,
+}
+
+// HandlerPath returns where the stub handler for an event directory (relative
+// to events/, as listed by "events unrouted") is written, relative to basePath
+func HandlerPath(dir string) (string, error) {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+	dir = strings.TrimPrefix(dir, "events/")
+	if !safePath(dir) {
+		return "", fmt.Errorf("invalid handler directory %q", dir)
+	}
+	parts := strings.Split(dir, "/")
+	if len(parts) < 2 || !handlerSources[parts[0]] {
+		return "", fmt.Errorf("invalid handler directory %q: use <source>/<name>, e.g. device/porch/state", dir)
+	}
+
+	name := "handler.lua"
+	if parts[0] == "device" && len(parts) >= 3 {
+		name = "on_change.lua"
+	}
+	return filepath.Join("events", filepath.FromSlash(dir), name), nil
+}
+
+// WriteHandler writes a stub handler for an event directory below basePath
+// and returns its path. Existing files are never overwritten; the router
+// picks the new script up on the next matching event.
+func WriteHandler(basePath, dir string) (string, error) {
+	rel, err := HandlerPath(dir)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(basePath, rel)
+	if fileExists(path) {
+		return "", fmt.Errorf("%s already exists", rel)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := writeScript(path, handlerScript(filepath.ToSlash(filepath.Dir(rel)))); err != nil {
+		return "", err
+	}
+
+	log.Info("Created handler: %s", path)
+	return path, nil
+}
+
+// handlerScript renders a stub that logs the events routed to dir
+func handlerScript(dir string) string {
+	parts := strings.Split(strings.TrimPrefix(dir, "events/"), "/")
+	body := `log.info("Event:", event.source, event.type)`
+	switch {
+	case parts[0] == "device" && len(parts) >= 3:
+		attr := parts[len(parts)-1]
+		body = fmt.Sprintf(`local new_value = event.data%s
+
+log.info(%s, tostring(new_value))`, luaIndex(attr), quote(strings.Join(parts[1:], "/")+" changed to"))
+	case parts[0] == "mqtt":
+		body = `log.info("Message on", event.topic)`
+	}
+
+	return fmt.Sprintf(`-- Handler for %s
+-- Created for events that had no handler yet; replace the log line with
+-- what should happen.
+
+%s
+`, dir, body)
+}
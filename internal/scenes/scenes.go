@@ -0,0 +1,240 @@
+package scenes
+
+import (
+	"errors"
+	"fmt"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var log = logger.Module("scenes")
+
+// sceneName restricts scene names to a single safe file name
+var sceneName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// nonSceneAttributes are reported by devices but make no sense to restore
+var nonSceneAttributes = map[string]bool{
+	"linkquality":      true,
+	"last_seen":        true,
+	"battery":          true,
+	"voltage":          true,
+	"update":           true,
+	"update_available": true,
+	"availability":     true,
+}
+
+// Scene is a snapshot of attributes to apply to a set of devices
+type Scene struct {
+	Name string `yaml:"-"`
+	// Devices maps device ID to the attributes passed to device.set
+	Devices map[string]map[string]interface{} `yaml:"devices"`
+}
+
+// DeviceController reads and sets device state (implemented by devices.Manager)
+type DeviceController interface {
+	GetDevice(id string) (*types.Device, bool)
+	Get(id string) (map[string]interface{}, error)
+	Set(id string, attrs map[string]interface{}) error
+}
+
+// Engine loads scenes from config/scenes/<name>.yaml and applies them.
+// Files are read on every activation, so edits take effect without a restart.
+type Engine struct {
+	dir     string
+	devices DeviceController
+}
+
+// New creates a scene engine reading scene files from dir
+func New(dir string, devices DeviceController) *Engine {
+	return &Engine{dir: dir, devices: devices}
+}
+
+func (e *Engine) path(name string) (string, error) {
+	if !sceneName.MatchString(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid scene name: %s", name)
+	}
+	return filepath.Join(e.dir, name+".yaml"), nil
+}
+
+// Get loads a scene by name
+func (e *Engine) Get(name string) (*Scene, error) {
+	path, err := e.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("scene not found: %s", name)
+		}
+		return nil, fmt.Errorf("failed to read scene %s: %w", name, err)
+	}
+
+	var scene Scene
+	if err := yaml.Unmarshal(data, &scene); err != nil {
+		return nil, fmt.Errorf("failed to parse scene %s: %w", name, err)
+	}
+	scene.Name = name
+	return &scene, nil
+}
+
+// List returns the names of all defined scenes, sorted
+func (e *Engine) List() []string {
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Activate sets every device in the scene. All devices are attempted even if
+// some fail; the returned error joins the individual failures.
+func (e *Engine) Activate(name string) error {
+	scene, err := e.Get(name)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(scene.Devices))
+	for id := range scene.Devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for _, id := range ids {
+		if err := e.devices.Set(id, scene.Devices[id]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	log.Info("Activated scene %s (%d device(s), %d failed)", name, len(ids), len(errs))
+	return errors.Join(errs...)
+}
+
+// Capture records the current state of the given devices as a new scene and
+// writes it to config/scenes/<name>.yaml, replacing an existing scene
+func (e *Engine) Capture(name string, deviceIDs []string) (*Scene, error) {
+	path, err := e.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(deviceIDs) == 0 {
+		return nil, fmt.Errorf("no devices to capture")
+	}
+
+	scene := &Scene{Name: name, Devices: make(map[string]map[string]interface{})}
+	for _, id := range deviceIDs {
+		attrs, err := Snapshot(e.devices, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(attrs) == 0 {
+			log.Warn("Scene %s: no state known yet for device %s, skipped", name, id)
+			continue
+		}
+		scene.Devices[id] = attrs
+	}
+
+	data, err := yaml.Marshal(scene)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scene: %w", err)
+	}
+
+	header := fmt.Sprintf("# Scene captured at: %s\n\n", time.Now().Format(time.RFC3339))
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create scenes directory: %w", err)
+	}
+	if err := os.WriteFile(path, append([]byte(header), data...), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write scene: %w", err)
+	}
+
+	log.Info("Captured scene %s (%d device(s))", name, len(scene.Devices))
+	return scene, nil
+}
+
+// Snapshot returns the restorable attributes of a device's current state.
+// Devices with a configured attribute list only capture those attributes.
+func Snapshot(devices DeviceController, id string) (map[string]interface{}, error) {
+	dev, ok := devices.GetDevice(id)
+	if !ok {
+		return nil, fmt.Errorf("device not found: %s", id)
+	}
+	state, err := devices.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool, len(dev.Attributes))
+	for _, attr := range dev.Attributes {
+		allowed[attr] = true
+	}
+
+	attrs := make(map[string]interface{})
+	for attr, value := range state {
+		if nonSceneAttributes[attr] {
+			continue
+		}
+		if len(allowed) > 0 && !allowed[attr] {
+			continue
+		}
+		if _, isBinary := value.([]byte); isBinary {
+			continue
+		}
+		attrs[attr] = value
+	}
+	return attrs, nil
+}
+
+// Restore puts devices back into a captured scene. Unlike Activate, a device
+// that was off only gets its state: sending the captured brightness or color
+// along would turn most lights back on.
+func (e *Engine) Restore(name string) error {
+	scene, err := e.Get(name)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(scene.Devices))
+	for id := range scene.Devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for _, id := range ids {
+		if err := e.devices.Set(id, RestoreAttributes(scene.Devices[id])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	log.Info("Restored scene %s (%d device(s), %d failed)", name, len(ids), len(errs))
+	return errors.Join(errs...)
+}
+
+// RestoreAttributes returns the attributes that bring a device back to a
+// captured state
+func RestoreAttributes(attrs map[string]interface{}) map[string]interface{} {
+	if state, ok := attrs["state"].(string); ok && strings.EqualFold(state, "OFF") {
+		return map[string]interface{}{"state": state}
+	}
+	return attrs
+}
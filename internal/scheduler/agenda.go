@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathan-osman/go-sunrise"
+)
+
+// Agenda is what the scheduler will still run today
+type Agenda struct {
+	Now     time.Time     `json:"now"`
+	Sunrise *time.Time    `json:"sunrise,omitempty"`
+	Sunset  *time.Time    `json:"sunset,omitempty"`
+	Events  []AgendaEntry `json:"events"`
+	Timers  []AgendaEntry `json:"timers"`
+}
+
+// AgendaEntry is a time event (events/time/<name>/) or a timer (its ID)
+// due at At. Entries that repeat during the rest of the day fire every
+// Every until Until.
+type AgendaEntry struct {
+	Name  string     `json:"name"`
+	At    time.Time  `json:"at"`
+	Every string     `json:"every,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Today lists the time events with a handler and the timers that will fire
+// between now and midnight, in order, with today's sun times
+func (s *Scheduler) Today() *Agenda {
+	now := s.clock.Now().In(s.location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, s.location)
+	agenda := &Agenda{Now: now, Events: []AgendaEntry{}, Timers: []AgendaEntry{}}
+
+	rise, set := s.sunTimes(now)
+	if !rise.IsZero() {
+		agenda.Sunrise, agenda.Sunset = &rise, &set
+	}
+
+	if s.handlers != nil {
+		names, offsets := s.handlers.list()
+		for _, name := range names {
+			occurrences := timeEventOccurrences(name, now, rise, set)
+			if entry, ok := agendaEntry(name, occurrences, now); ok {
+				agenda.Events = append(agenda.Events, entry)
+			}
+		}
+		for base, list := range offsets {
+			baseTime := rise
+			if base == "sunset" {
+				baseTime = set
+			}
+			if baseTime.IsZero() {
+				continue
+			}
+			for _, offset := range list {
+				at := baseTime.Truncate(time.Minute).Add(time.Duration(offset.minutes) * time.Minute)
+				if entry, ok := agendaEntry(base+"/"+offset.name, []time.Time{at}, now); ok && at.Before(midnight) {
+					agenda.Events = append(agenda.Events, entry)
+				}
+			}
+		}
+	}
+
+	s.timersMutex.RLock()
+	for id, timer := range s.timers {
+		if !timer.TriggerTime.Before(midnight) {
+			continue
+		}
+		entry := AgendaEntry{Name: id, At: timer.TriggerTime.In(s.location)}
+		if timer.Recurring && timer.Interval > 0 {
+			last := timer.TriggerTime
+			for next := last.Add(timer.Interval); next.Before(midnight); next = next.Add(timer.Interval) {
+				last = next
+			}
+			if last.After(timer.TriggerTime) {
+				until := last.In(s.location)
+				entry.Every = timer.Interval.String()
+				entry.Until = &until
+			}
+		}
+		agenda.Timers = append(agenda.Timers, entry)
+	}
+	s.timersMutex.RUnlock()
+
+	sortAgenda(agenda.Events)
+	sortAgenda(agenda.Timers)
+	return agenda
+}
+
+// timeEventOccurrences returns the times a time event directory fires on
+// the day of now, at equal intervals
+func timeEventOccurrences(name string, now, rise, set time.Time) []time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	at := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+
+	var times []time.Time
+	switch name {
+	case "sunrise":
+		if !rise.IsZero() {
+			times = append(times, rise.Truncate(time.Minute))
+		}
+	case "sunset":
+		if !set.IsZero() {
+			times = append(times, set.Truncate(time.Minute))
+		}
+	case "every_hour":
+		for hour := 0; hour < 24; hour++ {
+			times = append(times, at(hour, 0))
+		}
+	default:
+		hourPart, minutePart, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil
+		}
+		hours, okH := timeField(hourPart, 24)
+		minutes, okM := timeField(minutePart, 60)
+		if !okH || !okM {
+			return nil
+		}
+		for _, hour := range hours {
+			for _, minute := range minutes {
+				times = append(times, at(hour, minute))
+			}
+		}
+	}
+	return times
+}
+
+// timeField expands "*" to 0..limit-1 or parses a two-digit value
+func timeField(s string, limit int) ([]int, bool) {
+	if s == "*" {
+		values := make([]int, limit)
+		for i := range values {
+			values[i] = i
+		}
+		return values, true
+	}
+	var v int
+	if len(s) != 2 {
+		return nil, false
+	}
+	if _, err := fmt.Sscanf(s, "%02d", &v); err != nil || v < 0 || v >= limit {
+		return nil, false
+	}
+	return []int{v}, true
+}
+
+// agendaEntry keeps the occurrences that haven't fired yet (an event fires
+// at the start of its minute)
+func agendaEntry(name string, occurrences []time.Time, now time.Time) (AgendaEntry, bool) {
+	var remaining []time.Time
+	for _, t := range occurrences {
+		if !t.Before(now) {
+			remaining = append(remaining, t)
+		}
+	}
+	if len(remaining) == 0 {
+		return AgendaEntry{}, false
+	}
+
+	entry := AgendaEntry{Name: name, At: remaining[0]}
+	if len(remaining) > 1 {
+		until := remaining[len(remaining)-1]
+		entry.Every = remaining[1].Sub(remaining[0]).String()
+		entry.Until = &until
+	}
+	return entry, true
+}
+
+func sortAgenda(entries []AgendaEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].At.Equal(entries[j].At) {
+			return entries[i].At.Before(entries[j].At)
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// sunTimes returns sunrise and sunset of the day of now (zero without
+// coordinates)
+func (s *Scheduler) sunTimes(now time.Time) (time.Time, time.Time) {
+	if s.latitude == 0 && s.longitude == 0 {
+		return time.Time{}, time.Time{}
+	}
+	// SunriseSunset returns UTC times
+	rise, set := sunrise.SunriseSunset(s.latitude, s.longitude, now.Year(), now.Month(), now.Day())
+	return rise.In(s.location), set.In(s.location)
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock is a virtual, accelerated clock for trying time events without
+// waiting for them: it starts at a chosen time and runs speed times faster
+// than the wall clock. A manual clock only moves when set, so tests decide
+// when time passes. A nil *Clock is the wall clock.
+type Clock struct {
+	start     time.Time
+	realStart time.Time
+	speed     float64
+	manual    bool
+	mu        sync.Mutex
+}
+
+// NewSimulatedClock returns a clock that reads start now and advances speed
+// virtual seconds per real second
+func NewSimulatedClock(start time.Time, speed float64) *Clock {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Clock{start: start, realStart: time.Now(), speed: speed}
+}
+
+// NewManualClock returns a clock that reads start until Set moves it
+func NewManualClock(start time.Time) *Clock {
+	return &Clock{start: start, manual: true}
+}
+
+// Set moves a manual clock to t
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start = t
+}
+
+// ParseSimulatedTime parses --simulate-time values ("2024-06-21T20:55",
+// with optional seconds, or RFC 3339) in loc
+func ParseSimulatedTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (expected e.g. 2024-06-21T20:55)", value)
+}
+
+// Now returns the current (virtual) time
+func (c *Clock) Now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	if c.manual {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.start
+	}
+	elapsed := time.Since(c.realStart)
+	return c.start.Add(time.Duration(float64(elapsed) * c.speed))
+}
+
+// Simulated reports whether the clock is virtual
+func (c *Clock) Simulated() bool {
+	return c != nil
+}
+
+// Manual reports whether the clock only moves when set
+func (c *Clock) Manual() bool {
+	return c != nil && c.manual
+}
+
+// tickInterval is how often the scheduler looks at the clock: every second,
+// or often enough that a tick advances about one virtual second
+func (c *Clock) tickInterval() time.Duration {
+	if c == nil || c.speed <= 1 {
+		return time.Second
+	}
+	interval := time.Duration(float64(time.Second) / c.speed)
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	}
+	return interval
+}
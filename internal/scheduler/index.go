@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"fmt"
+	"homescript-server/internal/fswatch"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// handlerFile is the script a time event directory must contain
+const handlerFile = "handler.lua"
+
+// sunOffset is a handler directory like sunrise/-00_30
+type sunOffset struct {
+	name    string // directory name, e.g. "-00_30"
+	minutes int    // signed offset from the sun event
+}
+
+// handlerIndex knows which time events have a handler, so the per-minute
+// check is a map lookup instead of a stat per candidate. It is rebuilt when
+// the watcher reports a change under events/time (on every check where
+// directories can't be watched).
+type handlerIndex struct {
+	dir     string // events/time
+	watcher *fswatch.Watcher
+	mu      sync.Mutex
+	built   bool
+	events  map[string]bool        // "07_00", "*_15", "sunrise", ...
+	offsets map[string][]sunOffset // "sunrise"/"sunset" -> offsets with a handler
+}
+
+func newHandlerIndex(dir string) *handlerIndex {
+	watcher, err := fswatch.New()
+	if err != nil {
+		log.Debug("Not watching %s, time handlers are looked up every minute: %v", dir, err)
+	}
+	return &handlerIndex{dir: dir, watcher: watcher}
+}
+
+// has reports whether events/time/<eventType>/handler.lua exists
+func (x *handlerIndex) has(eventType string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.refresh()
+	return x.events[eventType]
+}
+
+// sunOffsets returns the offset handlers of "sunrise" or "sunset"
+func (x *handlerIndex) sunOffsets(base string) []sunOffset {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.refresh()
+	return x.offsets[base]
+}
+
+// list returns the time events and the sun offsets that have a handler
+func (x *handlerIndex) list() ([]string, map[string][]sunOffset) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.refresh()
+
+	names := make([]string, 0, len(x.events))
+	for name := range x.events {
+		names = append(names, name)
+	}
+	offsets := make(map[string][]sunOffset, len(x.offsets))
+	for base, list := range x.offsets {
+		offsets[base] = append([]sunOffset(nil), list...)
+	}
+	return names, offsets
+}
+
+// close stops watching for changes
+func (x *handlerIndex) close() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.watcher != nil {
+		x.watcher.Close()
+		x.watcher = nil
+	}
+}
+
+// refresh rescans if the directory changed (x.mu must be held)
+func (x *handlerIndex) refresh() {
+	if x.built && x.watcher != nil && !x.watcher.Changed() {
+		return
+	}
+	x.scan()
+}
+
+// watch adds dir to the watcher before it is read, so nothing created in
+// between is missed; a directory that can't be watched forces a rescan on
+// the next check
+func (x *handlerIndex) watch(dir string) {
+	if x.watcher == nil {
+		return
+	}
+	if _, err := x.watcher.AddNearest(dir); err != nil {
+		log.Debug("Cannot watch %s for new time handlers: %v", dir, err)
+		x.built = false
+	}
+}
+
+// scan rebuilds the index, watching every directory it reads
+func (x *handlerIndex) scan() {
+	x.events = make(map[string]bool)
+	x.offsets = make(map[string][]sunOffset)
+	x.built = true
+
+	x.watch(x.dir)
+	entries, _ := os.ReadDir(x.dir)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		dir := filepath.Join(x.dir, name)
+		x.watch(dir)
+		if fileExists(filepath.Join(dir, handlerFile)) {
+			x.events[name] = true
+		}
+		if name == "sunrise" || name == "sunset" {
+			x.offsets[name] = x.scanOffsets(dir)
+		}
+	}
+	log.Debug("Indexed %d time event handler(s) in %s", len(x.events), x.dir)
+}
+
+// scanOffsets finds offset directories (-00_30, +01_15) with a handler
+func (x *handlerIndex) scanOffsets(dir string) []sunOffset {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var offsets []sunOffset
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || len(name) < 6 || (name[0] != '-' && name[0] != '+') {
+			continue
+		}
+
+		var hours, minutes int
+		if _, err := fmt.Sscanf(name[1:], "%02d_%02d", &hours, &minutes); err != nil {
+			continue
+		}
+		offset := hours*60 + minutes
+		if name[0] == '-' {
+			offset = -offset
+		}
+
+		x.watch(filepath.Join(dir, name))
+		if fileExists(filepath.Join(dir, name, handlerFile)) {
+			offsets = append(offsets, sunOffset{name: name, minutes: offset})
+		}
+	}
+	return offsets
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
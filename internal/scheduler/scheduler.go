@@ -5,15 +5,17 @@ import (
 	"homescript-server/internal/events"
 	"homescript-server/internal/logger"
 	"homescript-server/internal/types"
-	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/nathan-osman/go-sunrise"
 	lua "github.com/yuin/gopher-lua"
 )
 
+var log = logger.Module("scheduler")
+
 // Scheduler handles time-based events
 type Scheduler struct {
 	router      *events.Router
@@ -30,6 +32,11 @@ type Scheduler struct {
 	sunsetTime  time.Time
 	timers      map[string]*Timer
 	timersMutex sync.RWMutex
+	clock       *Clock
+	// lastChecked is the last virtual minute checked (simulated clock only)
+	lastChecked time.Time
+	// handlers indexes events/time so minute checks don't stat the filesystem
+	handlers *handlerIndex
 }
 
 // Timer represents a one-time or recurring scheduled event
@@ -40,6 +47,8 @@ type Timer struct {
 	State       *lua.LState
 	Recurring   bool
 	Interval    time.Duration
+	// Func is run instead of a Lua callback (effects driven from Go)
+	Func func()
 }
 
 // Config holds scheduler configuration
@@ -50,6 +59,8 @@ type Config struct {
 	Latitude float64
 	// Longitude for sunrise/sunset calculations (required for accurate times)
 	Longitude float64
+	// Clock replaces the wall clock with a simulated one (optional)
+	Clock *Clock
 }
 
 // New creates a new scheduler
@@ -59,7 +70,7 @@ func New(router *events.Router, cfg Config) *Scheduler {
 		location = time.Local
 	}
 
-	now := time.Now()
+	now := cfg.Clock.Now()
 
 	s := &Scheduler{
 		router:     router,
@@ -71,6 +82,13 @@ func New(router *events.Router, cfg Config) *Scheduler {
 		lastHour:   -1,
 		lastDay:    now.Day(),
 		timers:     make(map[string]*Timer),
+		clock:      cfg.Clock,
+	}
+	if cfg.Clock.Simulated() {
+		s.lastChecked = now.Truncate(time.Minute)
+	}
+	if router != nil {
+		s.handlers = newHandlerIndex(filepath.Join(router.GetBasePath(), "events", "time"))
 	}
 
 	// Calculate sunrise/sunset for today
@@ -79,6 +97,12 @@ func New(router *events.Router, cfg Config) *Scheduler {
 	return s
 }
 
+// Now returns the scheduler's current time, which is virtual in simulation
+// mode; timers and time events both follow it
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
 // SetExecutor sets the executor reference for callback execution
 func (s *Scheduler) SetExecutor(exec interface{}) {
 	s.executor = exec
@@ -88,7 +112,7 @@ func (s *Scheduler) SetExecutor(exec interface{}) {
 func (s *Scheduler) Start() {
 	s.wg.Add(1)
 	go s.run()
-	logger.Info("Scheduler started")
+	log.Info("Scheduler started")
 }
 
 // Stop gracefully stops the scheduler
@@ -104,23 +128,36 @@ func (s *Scheduler) Stop() {
 	s.timers = make(map[string]*Timer)
 	s.timersMutex.Unlock()
 
-	logger.Info("Scheduler stopped")
+	if s.handlers != nil {
+		s.handlers.close()
+	}
+
+	log.Info("Scheduler stopped")
 }
 
 func (s *Scheduler) run() {
 	defer s.wg.Done()
 
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(s.clock.tickInterval())
 	defer ticker.Stop()
 
-	logger.Debug("Scheduler ticker started, checking events every second")
+	if s.clock.Simulated() {
+		log.Warn("Simulated clock starts at %s", s.clock.Now().In(s.location).Format("2006-01-02 15:04:05"))
+	}
+
+	log.Debug("Scheduler ticker started, checking events every second")
 
 	for {
 		select {
 		case <-s.stopChan:
-			logger.Debug("Scheduler received stop signal")
+			log.Debug("Scheduler received stop signal")
 			return
 		case now := <-ticker.C:
+			if s.clock.Simulated() {
+				s.Tick()
+				continue
+			}
+
 			// Check timers every second (they need 1-second precision)
 			s.checkTimers(now)
 
@@ -149,7 +186,7 @@ func (s *Scheduler) checkTimeEvents(now time.Time) {
 	if minute != s.lastMinute {
 		s.lastMinute = minute
 
-		logger.Debug("Minute changed: %02d:%02d, checking time events", hour, minute)
+		log.Debug("Minute changed: %02d:%02d, checking time events", hour, minute)
 
 		// Check and trigger wildcard: *_* (every minute)
 		s.checkAndTrigger("*_*", now, weekday)
@@ -166,14 +203,14 @@ func (s *Scheduler) checkTimeEvents(now time.Time) {
 		// Check and trigger sunrise
 		if !s.sunriseTime.IsZero() && hour == s.sunriseTime.Hour() && minute == s.sunriseTime.Minute() {
 			if s.checkAndTrigger("sunrise", now, weekday) {
-				logger.Info("Sunrise at %02d:%02d", hour, minute)
+				log.Info("Sunrise at %02d:%02d", hour, minute)
 			}
 		}
 
 		// Check and trigger sunset
 		if !s.sunsetTime.IsZero() && hour == s.sunsetTime.Hour() && minute == s.sunsetTime.Minute() {
 			if s.checkAndTrigger("sunset", now, weekday) {
-				logger.Info("Sunset at %02d:%02d", hour, minute)
+				log.Info("Sunset at %02d:%02d", hour, minute)
 			}
 		}
 
@@ -187,95 +224,84 @@ func (s *Scheduler) checkTimeEvents(now time.Time) {
 	}
 }
 
-// checkSunOffsetEvents checks for sunrise/sunset offset events
-func (s *Scheduler) checkSunOffsetEvents(now time.Time, hour, minute, weekday int) {
-	if s.sunriseTime.IsZero() || s.sunsetTime.IsZero() {
-		return
+// Tick runs due timers and time events for every minute passed since the
+// last tick on a simulated clock (an accelerated clock skips many minutes per
+// tick). It is called by the scheduler loop, or directly after moving a
+// manual clock.
+func (s *Scheduler) Tick() {
+	now := s.clock.Now()
+	s.checkTimers(now)
+
+	for minute := s.lastChecked.Add(time.Minute); !minute.After(now); minute = minute.Add(time.Minute) {
+		s.checkTimeEvents(minute)
+		s.lastChecked = minute
 	}
-
-	sunrisePath := filepath.Join(s.router.GetBasePath(), "events", "time", "sunrise")
-	s.checkOffsetDirectory(sunrisePath, s.sunriseTime, now, hour, minute, weekday)
-
-	sunsetPath := filepath.Join(s.router.GetBasePath(), "events", "time", "sunset")
-	s.checkOffsetDirectory(sunsetPath, s.sunsetTime, now, hour, minute, weekday)
 }
 
-// checkOffsetDirectory checks for offset time directories under sunrise/sunset
-func (s *Scheduler) checkOffsetDirectory(basePath string, baseTime, now time.Time, currentHour, currentMinute, weekday int) {
-	entries, err := os.ReadDir(basePath)
-	if err != nil {
+// checkSunOffsetEvents checks for sunrise/sunset offset events
+func (s *Scheduler) checkSunOffsetEvents(now time.Time, hour, minute, weekday int) {
+	if s.sunriseTime.IsZero() || s.sunsetTime.IsZero() || s.handlers == nil {
 		return
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-
-		// Parse offset format: -00_30 or +01_30
-		if len(name) < 6 || (name[0] != '-' && name[0] != '+') {
-			continue
-		}
-
-		sign := name[0]
-		timeStr := name[1:]
-
-		var offsetHour, offsetMinute int
-		if _, err := fmt.Sscanf(timeStr, "%02d_%02d", &offsetHour, &offsetMinute); err != nil {
-			continue
-		}
-
-		// Calculate offset in minutes
-		offsetMinutes := offsetHour*60 + offsetMinute
-		if sign == '-' {
-			offsetMinutes = -offsetMinutes
-		}
-
-		// Calculate target time
-		targetTime := baseTime.Add(time.Duration(offsetMinutes) * time.Minute)
+	s.checkOffsets("sunrise", s.sunriseTime, now, hour, minute, weekday)
+	s.checkOffsets("sunset", s.sunsetTime, now, hour, minute, weekday)
+}
 
-		// Check if current time matches
+// checkOffsets triggers the indexed offset handlers (-00_30, +01_30) of
+// sunrise or sunset that fall on the current minute
+func (s *Scheduler) checkOffsets(base string, baseTime, now time.Time, currentHour, currentMinute, weekday int) {
+	for _, offset := range s.handlers.sunOffsets(base) {
+		targetTime := baseTime.Add(time.Duration(offset.minutes) * time.Minute)
 		if targetTime.Hour() == currentHour && targetTime.Minute() == currentMinute {
-			eventPath := fmt.Sprintf("sunrise/%s", name)
-			if filepath.Base(basePath) == "sunset" {
-				eventPath = fmt.Sprintf("sunset/%s", name)
-			}
-
-			// Check if handler script exists
-			scriptPath := filepath.Join(basePath, name, "handler.lua")
-			if _, err := os.Stat(scriptPath); err == nil {
-				s.triggerEvent(eventPath, now, weekday)
-				logger.Info("Triggered offset event: %s at %02d:%02d", eventPath, currentHour, currentMinute)
-			}
+			eventPath := base + "/" + offset.name
+			s.triggerEvent(eventPath, now, weekday)
+			log.Info("Triggered offset event: %s at %02d:%02d", eventPath, currentHour, currentMinute)
 		}
 	}
 }
 
-// checkTimers checks and triggers any due timers
+// checkTimers checks and triggers any due timers. Callbacks run in their own
+// goroutines, or in order on the caller's with a manual clock (tests).
 func (s *Scheduler) checkTimers(now time.Time) {
+	var due []*Timer
+	defer func() {
+		sort.Slice(due, func(i, j int) bool {
+			if !due[i].TriggerTime.Equal(due[j].TriggerTime) {
+				return due[i].TriggerTime.Before(due[j].TriggerTime)
+			}
+			return due[i].ID < due[j].ID
+		})
+		for _, timer := range due {
+			s.executeTimerCallback(timer)
+		}
+	}()
+
 	s.timersMutex.Lock()
 	defer s.timersMutex.Unlock()
 
 	for id, timer := range s.timers {
 		if now.After(timer.TriggerTime) || now.Equal(timer.TriggerTime) {
-			logger.Debug("Triggering timer: %s", id)
+			log.Debug("Triggering timer: %s", id)
 
 			// Execute the callback via executor
-			if timer.Callback != nil && s.executor != nil {
-				go s.executeTimerCallback(timer)
+			if timer.Func != nil || (timer.Callback != nil && s.executor != nil) {
+				if s.clock.Manual() {
+					due = append(due, timer)
+				} else {
+					go s.executeTimerCallback(timer)
+				}
 			}
 
 			// Handle recurring timers
 			if timer.Recurring {
 				timer.TriggerTime = now.Add(timer.Interval)
-				logger.Debug("Timer %s rescheduled for %s", id, timer.TriggerTime.Format("15:04:05"))
+				log.Debug("Timer %s rescheduled for %s", id, timer.TriggerTime.Format("15:04:05"))
 			} else {
 				// Remove one-time timer and release Lua state reference
 				s.releaseTimerState(timer)
 				delete(s.timers, id)
-				logger.Debug("Timer %s removed (one-time)", id)
+				log.Debug("Timer %s removed (one-time)", id)
 			}
 		}
 	}
@@ -283,6 +309,11 @@ func (s *Scheduler) checkTimers(now time.Time) {
 
 // executeTimerCallback executes a timer callback in a goroutine
 func (s *Scheduler) executeTimerCallback(timer *Timer) {
+	if timer.Func != nil {
+		timer.Func()
+		return
+	}
+
 	post := func() {}
 	if !timer.Recurring {
 		post = func() {
@@ -301,12 +332,12 @@ func (s *Scheduler) executeTimerCallback(timer *Timer) {
 
 			if count > 0 {
 				timer.State.SetGlobal("__timer_count__", lua.LNumber(count))
-				logger.Debug("Lua state %p has %d remaining timer(s)", timer.State, count)
+				log.Debug("Lua state %p has %d remaining timer(s)", timer.State, count)
 				return
 			}
 
 			timer.State.SetGlobal("__timer_count__", lua.LNumber(0))
-			logger.Debug("Lua state %p last timer completed, releasing state", timer.State)
+			log.Debug("Lua state %p last timer completed, releasing state", timer.State)
 			s.releaseTimerState(timer)
 		}
 	}
@@ -315,7 +346,7 @@ func (s *Scheduler) executeTimerCallback(timer *Timer) {
 		ExecuteCallbackWithPost(callback *lua.LFunction, L *lua.LState, timerID string, post func()) error
 	}); ok {
 		if err := executor.ExecuteCallbackWithPost(timer.Callback, timer.State, timer.ID, post); err != nil {
-			logger.Error("Timer %s callback failed: %v", timer.ID, err)
+			log.Error("Timer %s callback failed: %v", timer.ID, err)
 		}
 		return
 	}
@@ -325,14 +356,14 @@ func (s *Scheduler) executeTimerCallback(timer *Timer) {
 		ExecuteCallback(callback *lua.LFunction, L *lua.LState, timerID string) error
 	}); ok {
 		if err := executor.ExecuteCallback(timer.Callback, timer.State, timer.ID); err != nil {
-			logger.Error("Timer %s callback failed: %v", timer.ID, err)
+			log.Error("Timer %s callback failed: %v", timer.ID, err)
 			return
 		}
 		post()
 		return
 	}
 
-	logger.Error("Executor does not support ExecuteCallback")
+	log.Error("Executor does not support ExecuteCallback")
 }
 
 // releaseTimerState releases the Lua state reference for a timer
@@ -343,7 +374,7 @@ func (s *Scheduler) releaseTimerState(timer *Timer) {
 		}); ok {
 			executor.ReleaseStateReference(timer.State)
 		} else {
-			logger.Warn("Executor does not support ReleaseStateReference")
+			log.Warn("Executor does not support ReleaseStateReference")
 		}
 	}
 }
@@ -361,7 +392,22 @@ func (s *Scheduler) AddTimerCallback(id string, triggerTime time.Time, callback
 		Recurring:   false,
 	}
 
-	logger.Info("Timer added: %s at %s", id, triggerTime.Format("2006-01-02 15:04:05"))
+	log.Info("Timer added: %s at %s", id, triggerTime.Format("2006-01-02 15:04:05"))
+}
+
+// AddFuncTimer schedules a Go function; like Lua timers it follows the
+// scheduler's clock (with one-second precision) and replaces a timer of the
+// same ID
+func (s *Scheduler) AddFuncTimer(id string, triggerTime time.Time, fn func()) {
+	s.timersMutex.Lock()
+	defer s.timersMutex.Unlock()
+
+	s.timers[id] = &Timer{
+		ID:          id,
+		TriggerTime: triggerTime,
+		Func:        fn,
+	}
+	log.Debug("Timer added: %s at %s", id, triggerTime.Format("2006-01-02 15:04:05.000"))
 }
 
 // AddRecurringTimerCallback adds a recurring callback-based timer
@@ -369,7 +415,7 @@ func (s *Scheduler) AddRecurringTimerCallback(id string, interval time.Duration,
 	s.timersMutex.Lock()
 	defer s.timersMutex.Unlock()
 
-	triggerTime := time.Now().Add(interval)
+	triggerTime := s.Now().Add(interval)
 
 	s.timers[id] = &Timer{
 		ID:          id,
@@ -380,7 +426,7 @@ func (s *Scheduler) AddRecurringTimerCallback(id string, interval time.Duration,
 		Interval:    interval,
 	}
 
-	logger.Info("Recurring timer added: %s every %s", id, interval.String())
+	log.Info("Recurring timer added: %s every %s", id, interval.String())
 }
 
 // RemoveTimer removes a timer by ID
@@ -405,16 +451,16 @@ func (s *Scheduler) RemoveTimer(id string) bool {
 			if count > 0 {
 				// Still have active timers, just update count
 				timer.State.SetGlobal("__timer_count__", lua.LNumber(count))
-				logger.Debug("Timer %s cancelled, Lua state %p has %d remaining timer(s)", id, timer.State, count)
+				log.Debug("Timer %s cancelled, Lua state %p has %d remaining timer(s)", id, timer.State, count)
 			} else {
 				// Last timer removed/cancelled, release the state
 				timer.State.SetGlobal("__timer_count__", lua.LNumber(0))
-				logger.Debug("Timer %s cancelled (last one), releasing Lua state %p", id, timer.State)
+				log.Debug("Timer %s cancelled (last one), releasing Lua state %p", id, timer.State)
 				s.releaseTimerState(timer)
 			}
 		}
 
-		logger.Info("Timer removed: %s", id)
+		log.Info("Timer removed: %s", id)
 		return true
 	}
 	return false
@@ -432,25 +478,36 @@ func (s *Scheduler) ListTimers() []string {
 	return ids
 }
 
+// Timers lists all pending timers by due time, whatever the day; recurring
+// timers repeat every Every
+func (s *Scheduler) Timers() []AgendaEntry {
+	s.timersMutex.RLock()
+	timers := make([]AgendaEntry, 0, len(s.timers))
+	for id, timer := range s.timers {
+		entry := AgendaEntry{Name: id, At: timer.TriggerTime.In(s.location)}
+		if timer.Recurring && timer.Interval > 0 {
+			entry.Every = timer.Interval.String()
+		}
+		timers = append(timers, entry)
+	}
+	s.timersMutex.RUnlock()
+
+	sortAgenda(timers)
+	return timers
+}
+
 // updateSunTimes calculates sunrise and sunset times for the given day
 func (s *Scheduler) updateSunTimes(now time.Time) {
 	if s.latitude == 0 && s.longitude == 0 {
-		logger.Error("Cannot calculate sunrise/sunset: no coordinates available")
+		log.Error("Cannot calculate sunrise/sunset: no coordinates available")
 		s.sunriseTime = time.Time{}
 		s.sunsetTime = time.Time{}
 		return
 	}
 
-	// SunriseSunset returns UTC time, convert to configured scheduler location.
-	sunrise, sunset := sunrise.SunriseSunset(
-		s.latitude, s.longitude,
-		now.Year(), now.Month(), now.Day(),
-	)
-
-	s.sunriseTime = sunrise.In(s.location)
-	s.sunsetTime = sunset.In(s.location)
+	s.sunriseTime, s.sunsetTime = s.sunTimes(now)
 
-	logger.Info("Calculated sun times for %s: sunrise %02d:%02d, sunset %02d:%02d",
+	log.Info("Calculated sun times for %s: sunrise %02d:%02d, sunset %02d:%02d",
 		now.Format("2006-01-02"),
 		s.sunriseTime.Hour(), s.sunriseTime.Minute(),
 		s.sunsetTime.Hour(), s.sunsetTime.Minute())
@@ -458,11 +515,50 @@ func (s *Scheduler) updateSunTimes(now time.Time) {
 
 func (s *Scheduler) triggerEvent(eventType string, now time.Time, weekday int) {
 	if s.router == nil {
-		logger.Error("Scheduler router is nil, cannot trigger event: %s", eventType)
+		log.Error("Scheduler router is nil, cannot trigger event: %s", eventType)
 		return
 	}
 
-	event := &types.Event{
+	event := newTimeEvent(eventType, now, weekday)
+	log.Debug("[%s] Triggering time event: %s at %02d:%02d:%02d", event.CorrelationID, eventType, now.Hour(), now.Minute(), now.Second())
+	s.router.RouteEvent(event)
+}
+
+// Trigger routes a time event (a directory under events/time, like "sunset"
+// or "sunset/-00_30") right away, as if its time had come. The event data
+// has simulated = true.
+func (s *Scheduler) Trigger(eventType string) error {
+	if s.router == nil || s.handlers == nil {
+		return fmt.Errorf("scheduler has no router")
+	}
+	if !s.handlers.has(eventType) && !s.hasOffsetHandler(eventType) {
+		return fmt.Errorf("no handler for time event %s (events/time/%s/%s)", eventType, eventType, handlerFile)
+	}
+
+	now := s.clock.Now().In(s.location)
+	event := newTimeEvent(eventType, now, int(now.Weekday()))
+	event.Data["simulated"] = true
+	log.Info("[%s] Simulating time event: %s", event.CorrelationID, eventType)
+	s.router.RouteEvent(event)
+	return nil
+}
+
+// hasOffsetHandler reports whether eventType is a sun offset with a handler
+func (s *Scheduler) hasOffsetHandler(eventType string) bool {
+	base, name, ok := strings.Cut(eventType, "/")
+	if !ok {
+		return false
+	}
+	for _, offset := range s.handlers.sunOffsets(base) {
+		if offset.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newTimeEvent(eventType string, now time.Time, weekday int) *types.Event {
+	return &types.Event{
 		Source: "time",
 		Type:   eventType,
 		Data: map[string]interface{}{
@@ -472,20 +568,15 @@ func (s *Scheduler) triggerEvent(eventType string, now time.Time, weekday int) {
 			"second":  now.Second(),
 			"weekday": weekday,
 		},
-		Timestamp: now,
+		Timestamp:     now,
+		CorrelationID: types.NewCorrelationID(),
 	}
-
-	logger.Debug("Triggering time event: %s at %02d:%02d:%02d", eventType, now.Hour(), now.Minute(), now.Second())
-	s.router.RouteEvent(event)
 }
 
-// checkAndTrigger checks if script exists and triggers event if it does
+// checkAndTrigger triggers the event if it has a handler script
 func (s *Scheduler) checkAndTrigger(eventType string, now time.Time, weekday int) bool {
-	timeBasePath := filepath.Join(s.router.GetBasePath(), "events", "time")
-	scriptPath := filepath.Join(timeBasePath, eventType, "handler.lua")
-
-	if _, err := os.Stat(scriptPath); err == nil {
-		logger.Debug("Time event triggered: %s", eventType)
+	if s.handlers != nil && s.handlers.has(eventType) {
+		log.Debug("Time event triggered: %s", eventType)
 		s.triggerEvent(eventType, now, weekday)
 		return true
 	}
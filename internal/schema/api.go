@@ -0,0 +1,160 @@
+package schema
+
+// addAPIPaths adds the REST API (/api/...) to an OpenAPI document. Each
+// operation carries the credential scope it needs as x-scope.
+func addAPIPaths(paths, schemas Object, deviceState Object) {
+	schemas["RESTDevice"] = Object{
+		"type": "object",
+		"properties": Object{
+			"id":         Object{"type": "string"},
+			"name":       Object{"type": "string"},
+			"type":       Object{"type": "string"},
+			"area":       Object{"type": "string"},
+			"attributes": Object{"type": "array", "items": Object{"type": "string"}},
+			"actions":    Object{"type": "array", "items": Object{"type": "string"}},
+			"state":      deviceState,
+		},
+	}
+	schemas["Result"] = Object{
+		"type":                 "object",
+		"properties":           Object{"ok": Object{"type": "boolean"}},
+		"additionalProperties": true,
+	}
+	schemas["Timer"] = Object{
+		"type": "object",
+		"properties": Object{
+			"name":  Object{"type": "string"},
+			"at":    Object{"type": "string", "format": "date-time"},
+			"every": Object{"type": "string"},
+			"until": Object{"type": "string", "format": "date-time"},
+		},
+	}
+	schemas["HandlerScript"] = Object{
+		"type": "object",
+		"properties": Object{
+			"path":     Object{"type": "string"},
+			"disabled": Object{"type": "boolean"},
+		},
+	}
+
+	object := Object{"type": "object", "additionalProperties": true}
+	result := ref("Result")
+
+	paths["/api/devices"] = Object{
+		"get": apiOperation("read", "List devices with their state",
+			Object{"type": "array", "items": ref("RESTDevice")}),
+	}
+	paths["/api/devices/{id}"] = Object{
+		"get": withPathID(apiOperation("read", "Get a device with its state", ref("RESTDevice"))),
+	}
+	paths["/api/devices/{id}/set"] = Object{
+		"post": withBody(withPathID(apiOperation("control", "Set device attributes", result)), deviceState, true),
+	}
+	paths["/api/devices/{id}/actions/{action}"] = Object{
+		"post": withBody(withPath(apiOperation("control", "Call a device action", result), "id", "action"), object, false),
+	}
+
+	paths["/api/state"] = Object{
+		"get": withQuery(apiOperation("read", "List persistent state values", object), "prefix"),
+	}
+	paths["/api/state/{key}"] = Object{
+		"get": withPath(apiOperation("read", "Get a persistent state value", Object{
+			"type": "object",
+			"properties": Object{
+				"key":   Object{"type": "string"},
+				"value": Object{},
+			},
+		}), "key"),
+		"put":    withBody(withPath(apiOperation("control", "Set a persistent state value", result), "key"), Object{}, true),
+		"delete": withPath(apiOperation("control", "Delete a persistent state value", result), "key"),
+	}
+
+	paths["/api/timers"] = Object{
+		"get": apiOperation("read", "List pending timers", Object{"type": "array", "items": ref("Timer")}),
+	}
+	paths["/api/timers/{id}"] = Object{
+		"delete": withPath(apiOperation("control", "Cancel a timer", result), "id"),
+	}
+
+	paths["/api/scripts"] = Object{
+		"get": apiOperation("read", "List handler scripts", Object{"type": "array", "items": ref("HandlerScript")}),
+	}
+	paths["/api/scripts/disable/{path}"] = Object{
+		"post": withPath(apiOperation("control", "Disable a handler script", result), "path"),
+	}
+	paths["/api/scripts/enable/{path}"] = Object{
+		"post": withPath(apiOperation("control", "Enable a handler script", result), "path"),
+	}
+	paths["/api/scripts/run/{path}"] = Object{
+		"post": withBody(withPath(apiOperation("control", "Run a handler script with a sample event", result), "path"), object, false),
+	}
+
+	paths["/api/events/{type}"] = Object{
+		"post": withBody(withPath(apiOperation("events", "Route a webhook event to events/webhook/<type>/", result), "type"), object, false),
+	}
+	after := func(op Object) Object {
+		op["parameters"] = []interface{}{Object{
+			"name":        "after",
+			"in":          "query",
+			"description": "Only entries with a higher seq; pass the last of the previous answer",
+			"schema":      Object{"type": "integer", "minimum": 0},
+		}}
+		return op
+	}
+	paths["/api/logs"] = Object{
+		"get": after(apiOperation("read", "Recent log lines", Object{
+			"type": "object",
+			"properties": Object{
+				"lines": Object{"type": "array", "items": Object{
+					"type": "object",
+					"properties": Object{
+						"seq":     Object{"type": "integer"},
+						"time":    Object{"type": "string", "format": "date-time"},
+						"level":   Object{"type": "string"},
+						"module":  Object{"type": "string"},
+						"message": Object{"type": "string"},
+					},
+				}},
+				"last": Object{"type": "integer"},
+			},
+		})),
+	}
+	paths["/api/events/recent"] = Object{
+		"get": after(apiOperation("read", "Recent events with the handler scripts they ran", Object{
+			"type": "object",
+			"properties": Object{
+				"events": Object{"type": "array", "items": Object{"type": "object", "additionalProperties": true}},
+				"last":   Object{"type": "integer"},
+			},
+		})),
+	}
+	paths["/api/openapi.json"] = Object{
+		"get": apiOperation("read", "This document", Object{"type": "object"}),
+	}
+}
+
+// apiOperation is an operation of the REST API, which answers 403 to
+// credentials without scope
+func apiOperation(scope, summary string, response Object) Object {
+	op := operation(summary, response)
+	op["x-scope"] = scope
+	op["responses"].(Object)["403"] = Object{
+		"description": "Credential lacks the " + scope + " scope",
+		"content":     Object{"application/json": Object{"schema": ref("Error")}},
+	}
+	return op
+}
+
+// withQuery declares optional string query parameters of an operation
+func withQuery(op Object, names ...string) Object {
+	params, _ := op["parameters"].([]interface{})
+	for _, name := range names {
+		params = append(params, Object{
+			"name":   name,
+			"in":     "query",
+			"schema": Object{"type": "string"},
+		})
+	}
+	op["parameters"] = params
+	return op
+}
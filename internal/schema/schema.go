@@ -0,0 +1,228 @@
+package schema
+
+import (
+	"homescript-server/internal/config"
+	"homescript-server/internal/types"
+	"sort"
+)
+
+// JSONSchemaDraft is the dialect of generated device schemas
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Object is a JSON document under construction
+type Object = map[string]interface{}
+
+// attributeNames returns all known attributes of a device, sorted
+func attributeNames(dev *types.Device) []string {
+	seen := make(map[string]bool)
+	for _, attr := range dev.Attributes {
+		seen[attr] = true
+	}
+	for attr := range dev.Schema {
+		seen[attr] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for attr := range seen {
+		names = append(names, attr)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// attributeSchema converts a devices.yaml attribute schema to JSON schema
+func attributeSchema(s *types.AttributeSchema) Object {
+	if s == nil {
+		return Object{}
+	}
+	if len(s.Values) > 0 {
+		return Object{"type": "string", "enum": s.Values}
+	}
+
+	result := Object{"type": "number"}
+	if s.Min != nil {
+		result["minimum"] = *s.Min
+	}
+	if s.Max != nil {
+		result["maximum"] = *s.Max
+	}
+	return result
+}
+
+// Device returns a JSON schema describing the state of a device. Actions,
+// identity and topics are included as x- annotations.
+func Device(dev *types.Device) Object {
+	title := dev.Name
+	if title == "" {
+		title = dev.ID
+	}
+
+	props := Object{}
+	for _, attr := range attributeNames(dev) {
+		props[attr] = attributeSchema(dev.Schema[attr])
+	}
+
+	result := Object{
+		"$schema":    JSONSchemaDraft,
+		"$id":        "homescript:device:" + dev.ID,
+		"title":      title,
+		"type":       "object",
+		"properties": props,
+		"x-device": Object{
+			"id":     dev.ID,
+			"type":   dev.Type,
+			"model":  dev.Model,
+			"vendor": dev.Vendor,
+			"area":   dev.Area,
+		},
+	}
+	if len(dev.Actions) > 0 {
+		result["x-actions"] = dev.Actions
+	}
+	if dev.MQTT.StateTopic != "" || dev.MQTT.CommandTopic != "" {
+		result["x-mqtt"] = Object{
+			"state_topic":   dev.MQTT.StateTopic,
+			"command_topic": dev.MQTT.CommandTopic,
+		}
+	}
+	return result
+}
+
+// OpenAPI returns an OpenAPI 3.0 document for the endpoints enabled in
+// server.yaml, with one component schema per device
+func OpenAPI(devices []*types.Device, server *config.ServerConfig) Object {
+	sorted := append([]*types.Device(nil), devices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	schemas := Object{
+		"Error": Object{
+			"type":       "object",
+			"properties": Object{"error": Object{"type": "string"}},
+		},
+	}
+	var deviceRefs []interface{}
+	for _, dev := range sorted {
+		deviceSchema := Device(dev)
+		delete(deviceSchema, "$schema")
+		delete(deviceSchema, "$id")
+		schemas[componentName(dev.ID)] = deviceSchema
+		deviceRefs = append(deviceRefs, ref(componentName(dev.ID)))
+	}
+
+	deviceState := Object{"type": "object"}
+	if len(deviceRefs) > 0 {
+		deviceState = Object{"anyOf": deviceRefs}
+	}
+
+	paths := Object{}
+	if server.Guest.Enabled {
+		paths["/guest/devices"] = Object{"get": operation("List whitelisted devices with their state", Object{
+			"type": "array",
+			"items": Object{
+				"type": "object",
+				"properties": Object{
+					"id":    Object{"type": "string"},
+					"name":  Object{"type": "string"},
+					"type":  Object{"type": "string"},
+					"state": deviceState,
+				},
+			},
+		})}
+	}
+	if server.Metrics.Enabled {
+		paths["/metrics/devices"] = Object{"get": apiOperation("read", "Per-device message and command metrics",
+			Object{"type": "array", "items": Object{"type": "object"}})}
+		paths["/metrics/devices/{id}"] = Object{"get": withPathID(apiOperation("read", "Metrics of one device",
+			Object{"type": "object"}))}
+	}
+
+	if server.API.Enabled {
+		addAPIPaths(paths, schemas, deviceState)
+	}
+
+	names := make([]string, 0, len(server.Kiosk.Actions))
+	for name := range server.Kiosk.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		paths["/kiosk/"+name] = Object{"get": operation("Run kiosk action "+name, Object{
+			"type": "object",
+			"properties": Object{
+				"ok":     Object{"type": "boolean"},
+				"action": Object{"type": "string"},
+			},
+		})}
+	}
+
+	return Object{
+		"openapi": "3.0.3",
+		"info": Object{
+			"title":   "homescript-server",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": Object{
+			"schemas": schemas,
+			"securitySchemes": Object{
+				"bearer": Object{"type": "http", "scheme": "bearer"},
+				"token":  Object{"type": "apiKey", "in": "query", "name": "token"},
+				"basic":  Object{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []interface{}{Object{"bearer": []string{}}, Object{"token": []string{}}, Object{"basic": []string{}}},
+	}
+}
+
+// componentName turns a device ID into an OpenAPI component name
+func componentName(id string) string {
+	return "Device_" + id
+}
+
+func ref(name string) Object {
+	return Object{"$ref": "#/components/schemas/" + name}
+}
+
+func operation(summary string, response Object) Object {
+	return Object{
+		"summary": summary,
+		"responses": Object{
+			"200": Object{
+				"description": "OK",
+				"content":     Object{"application/json": Object{"schema": response}},
+			},
+			"401": Object{
+				"description": "Invalid token",
+				"content":     Object{"application/json": Object{"schema": ref("Error")}},
+			},
+		},
+	}
+}
+
+func withPathID(op Object) Object {
+	return withPath(op, "id")
+}
+
+// withPath declares required string path parameters of an operation
+func withPath(op Object, names ...string) Object {
+	params, _ := op["parameters"].([]interface{})
+	for _, name := range names {
+		params = append(params, Object{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   Object{"type": "string"},
+		})
+	}
+	op["parameters"] = params
+	return op
+}
+
+// withBody declares the JSON request body of an operation
+func withBody(op Object, body Object, required bool) Object {
+	op["requestBody"] = Object{
+		"required": required,
+		"content":  Object{"application/json": Object{"schema": body}},
+	}
+	return op
+}
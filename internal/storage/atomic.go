@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Incr adds delta to the number stored at key and returns the result. A
+// missing key counts as 0. The key keeps its TTL.
+func (s *Storage) Incr(key string, delta float64) (float64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	// Writes hold writeMu, so nothing changes key between the read and the write
+	current, found, err := s.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	var n float64
+	if found {
+		var ok bool
+		if n, ok = current.(float64); !ok {
+			return 0, fmt.Errorf("key %s does not hold a number", key)
+		}
+	}
+	n += delta
+	return n, s.update(key, n, found)
+}
+
+// CompareAndSwap stores value at key only if it currently holds old (nil
+// for a missing key); a nil value deletes the key. It returns whether it swapped
+// and the value found. The key keeps its TTL.
+func (s *Storage) CompareAndSwap(key string, old, value interface{}) (bool, interface{}, error) {
+	expected, err := normalize(old)
+	if err != nil {
+		return false, nil, err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	current, found, err := s.lookup(key)
+	if err != nil {
+		return false, nil, err
+	}
+	if !reflect.DeepEqual(current, expected) {
+		return false, current, nil
+	}
+
+	if value == nil {
+		if !found {
+			return true, current, nil
+		}
+		if err := s.write(&Batch{Delete: []string{key}}); err != nil {
+			s.forget(key)
+			return false, current, err
+		}
+		s.remember(key, cacheEntry{missing: true}, time.Time{})
+		return true, current, nil
+	}
+	return true, current, s.update(key, value, found)
+}
+
+// update writes value to key in one transaction, keeping the TTL of an
+// existing key (s.writeMu must be held)
+func (s *Storage) update(key string, value interface{}, existing bool) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	batch := &Batch{Put: map[string][]byte{key: data}}
+	s.cacheMu.RLock()
+	expires := s.expires[key]
+	s.cacheMu.RUnlock()
+	if !existing {
+		// A key past its TTL that the sweeper hasn't deleted yet starts over
+		expires = time.Time{}
+		batch.Expires = map[string]time.Time{key: expires}
+	}
+
+	if err := s.write(batch); err != nil {
+		s.forget(key)
+		return err
+	}
+	var decoded interface{}
+	if json.Unmarshal(data, &decoded) == nil {
+		s.remember(key, cacheEntry{value: decoded}, expires)
+	} else {
+		s.forget(key)
+		s.setExpiry(key, expires)
+	}
+	return nil
+}
+
+// normalize converts value to what Get would decode for it, so values from
+// scripts compare equal to stored ones
+func normalize(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
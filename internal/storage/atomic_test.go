@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func openTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := New(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// stored reads key from the backend, past the cache
+func stored(t *testing.T, s *Storage, key string) (string, bool) {
+	t.Helper()
+	values, err := s.backend.Get([]string{key})
+	if err != nil {
+		t.Fatalf("backend.Get(%s): %v", key, err)
+	}
+	data, ok := values[key]
+	return string(data), ok
+}
+
+func TestIncrConcurrent(t *testing.T) {
+	s := openTestStorage(t)
+	const goroutines, increments = 20, 50
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				if _, err := s.Incr("counter", 1); err != nil {
+					t.Errorf("Incr: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.Get("counter")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if want := float64(goroutines * increments); got != want {
+		t.Errorf("counter = %v, want %v", got, want)
+	}
+	if data, _ := stored(t, s, "counter"); data != "1000" {
+		t.Errorf("stored counter = %s, want 1000", data)
+	}
+}
+
+func TestIncr(t *testing.T) {
+	s := openTestStorage(t)
+
+	if n, err := s.Incr("missing", 2.5); err != nil || n != 2.5 {
+		t.Errorf("Incr(missing, 2.5) = %v, %v, want 2.5", n, err)
+	}
+	if n, err := s.Incr("missing", -1); err != nil || n != 1.5 {
+		t.Errorf("Incr(missing, -1) = %v, %v, want 1.5", n, err)
+	}
+	if err := s.Set("text", "abc"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := s.Incr("text", 1); err == nil {
+		t.Error("Incr of a string succeeded")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	tests := []struct {
+		name        string
+		initial     interface{} // nil: key missing
+		old, value  interface{}
+		wantSwapped bool
+		wantCurrent interface{}
+		wantStored  string // "" for missing
+	}{
+		{"missing key, expect missing", nil, nil, "on", true, nil, `"on"`},
+		{"missing key, expect value", nil, "off", "on", false, nil, ""},
+		{"match", "off", "off", "on", true, "off", `"on"`},
+		{"mismatch", "off", "idle", "on", false, "off", `"off"`},
+		{"number match", 3, 3, 4, true, 3.0, "4"},
+		{"number mismatch", 3, 2, 4, false, 3.0, "3"},
+		{"table match", map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1}, "x", true, map[string]interface{}{"a": 1.0}, `"x"`},
+		{"value expected, key set", "off", nil, "on", false, "off", `"off"`},
+		{"delete", "off", "off", nil, true, "off", ""},
+		{"delete mismatch", "off", "on", nil, false, "off", `"off"`},
+		{"delete missing key", nil, nil, nil, true, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := openTestStorage(t)
+			if tt.initial != nil {
+				if err := s.Set("key", tt.initial); err != nil {
+					t.Fatalf("Set: %v", err)
+				}
+			}
+
+			swapped, current, err := s.CompareAndSwap("key", tt.old, tt.value)
+			if err != nil {
+				t.Fatalf("CompareAndSwap: %v", err)
+			}
+			if swapped != tt.wantSwapped {
+				t.Errorf("swapped = %v, want %v", swapped, tt.wantSwapped)
+			}
+			if !reflect.DeepEqual(current, tt.wantCurrent) {
+				t.Errorf("current = %#v, want %#v", current, tt.wantCurrent)
+			}
+
+			data, ok := stored(t, s, "key")
+			if tt.wantStored == "" {
+				if ok {
+					t.Errorf("stored %s, want the key missing", data)
+				}
+				if _, err := s.Get("key"); err == nil {
+					t.Error("Get found the key, want it missing")
+				}
+				return
+			}
+			if data != tt.wantStored {
+				t.Errorf("stored %s, want %s", data, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	s := openTestStorage(t)
+	const goroutines, increments = 10, 30
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				current, err := s.Get("n")
+				if err != nil {
+					current = nil
+				}
+				next := 1.0
+				if n, ok := current.(float64); ok {
+					next = n + 1
+				}
+				swapped, _, err := s.CompareAndSwap("n", current, next)
+				if err != nil {
+					t.Errorf("CompareAndSwap: %v", err)
+					return
+				}
+				if swapped {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := s.Get("n"); got != float64(goroutines*increments) {
+		t.Errorf("n = %v, want %d", got, goroutines*increments)
+	}
+}
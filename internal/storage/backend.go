@@ -0,0 +1,30 @@
+package storage
+
+import "time"
+
+// Backend is where Storage keeps its values, encoded as JSON, and the expiry
+// of keys with a TTL. Storage serializes writes and caches reads on top.
+type Backend interface {
+	// Get returns the stored values of keys; missing keys are left out
+	Get(keys []string) (map[string][]byte, error)
+	// Keys returns the keys starting with prefix, in order
+	Keys(prefix string) ([]string, error)
+	// All returns every stored value by key
+	All() (map[string][]byte, error)
+	// Expiries returns the expiry of every key with a TTL
+	Expiries() (map[string]time.Time, error)
+	// Write applies a batch in one transaction
+	Write(batch *Batch) error
+	Close() error
+}
+
+// Batch is a set of changes written in one transaction, applied in field
+// order. Deleting a key also removes its expiry.
+type Batch struct {
+	// Reset deletes every key and expiry first (Replace)
+	Reset bool
+	Put   map[string][]byte
+	// Expires sets the expiry of keys, or removes it for a zero time
+	Expires map[string]time.Time
+	Delete  []string
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testBackend runs the cases every Backend must pass on a fresh, empty one
+func testBackend(t *testing.T, open func(t *testing.T) Backend) {
+	at := time.Unix(1700000000, 123456789)
+
+	t.Run("Get", func(t *testing.T) {
+		b := open(t)
+		write(t, b, &Batch{Put: map[string][]byte{"a": []byte(`1`), "b": []byte(`"x"`)}})
+		got, err := b.Get([]string{"a", "b", "missing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string][]byte{"a": []byte(`1`), "b": []byte(`"x"`)}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Get = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Get many", func(t *testing.T) {
+		b := open(t)
+		put := make(map[string][]byte)
+		var keys []string
+		for i := 0; i < 1200; i++ {
+			key := fmt.Sprintf("k%04d", i)
+			put[key] = []byte(fmt.Sprint(i))
+			keys = append(keys, key)
+		}
+		write(t, b, &Batch{Put: put})
+		got, err := b.Get(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(keys) {
+			t.Errorf("Get of %d keys returned %d", len(keys), len(got))
+		}
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		b := open(t)
+		put := make(map[string][]byte)
+		for _, key := range []string{"pre.a", "pre.b", "pre_c", "prefix", "pre%d", "pre!e", "PRE.f", "other"} {
+			put[key] = []byte(`true`)
+		}
+		write(t, b, &Batch{Put: put})
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{"pre.", []string{"pre.a", "pre.b"}},
+			{"pre_", []string{"pre_c"}},
+			{"pre%", []string{"pre%d"}},
+			{"pre!", []string{"pre!e"}},
+			{"PRE", []string{"PRE.f"}},
+			{"none", nil},
+		}
+		for _, tt := range tests {
+			got, err := b.Keys(tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Keys(%q) = %q, want %q", tt.prefix, got, tt.want)
+			}
+		}
+		all, err := b.Keys("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(put) {
+			t.Errorf("Keys(\"\") = %q, want all %d keys", all, len(put))
+		}
+	})
+
+	t.Run("Expiries", func(t *testing.T) {
+		b := open(t)
+		write(t, b, &Batch{
+			Put:     map[string][]byte{"ttl": []byte(`1`), "plain": []byte(`2`), "gone": []byte(`3`)},
+			Expires: map[string]time.Time{"ttl": at, "gone": at},
+		})
+		// Overwriting a value keeps its expiry; a zero time removes it
+		write(t, b, &Batch{
+			Put:     map[string][]byte{"ttl": []byte(`10`)},
+			Expires: map[string]time.Time{"gone": {}},
+		})
+		expires := expiries(t, b)
+		if len(expires) != 1 || !expires["ttl"].Equal(at) {
+			t.Errorf("Expiries = %v, want ttl at %v", expires, at)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		b := open(t)
+		write(t, b, &Batch{
+			Put:     map[string][]byte{"a": []byte(`1`), "b": []byte(`2`)},
+			Expires: map[string]time.Time{"a": at},
+		})
+		write(t, b, &Batch{Delete: []string{"a", "missing"}})
+		all, err := b.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(all, map[string][]byte{"b": []byte(`2`)}) {
+			t.Errorf("All = %q after deleting a, want only b", all)
+		}
+		if expires := expiries(t, b); len(expires) != 0 {
+			t.Errorf("Expiries = %v, want the deleted key's expiry gone", expires)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		b := open(t)
+		write(t, b, &Batch{
+			Put:     map[string][]byte{"a": []byte(`1`), "b": []byte(`2`)},
+			Expires: map[string]time.Time{"a": at},
+		})
+		write(t, b, &Batch{
+			Reset:   true,
+			Put:     map[string][]byte{"c": []byte(`3`)},
+			Expires: map[string]time.Time{"c": at},
+		})
+		all, err := b.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(all, map[string][]byte{"c": []byte(`3`)}) {
+			t.Errorf("All = %q after a reset, want only c", all)
+		}
+		if expires := expiries(t, b); len(expires) != 1 || !expires["c"].Equal(at) {
+			t.Errorf("Expiries = %v after a reset, want c at %v", expires, at)
+		}
+	})
+}
+
+func write(t *testing.T, b Backend, batch *Batch) {
+	t.Helper()
+	if err := b.Write(batch); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func expiries(t *testing.T, b Backend) map[string]time.Time {
+	t.Helper()
+	expires, err := b.Expiries()
+	if err != nil {
+		t.Fatalf("Expiries: %v", err)
+	}
+	return expires
+}
+
+func TestBoltBackend(t *testing.T) {
+	testBackend(t, func(t *testing.T) Backend {
+		b, err := openBolt(filepath.Join(t.TempDir(), "state.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = b.Close() })
+		return b
+	})
+}
+
+func TestSQLPlaceholders(t *testing.T) {
+	q := "UPDATE homescript_state SET expires = ? WHERE name = ?"
+	if got := (&sqlBackend{}).query(q); got != q {
+		t.Errorf("query(%q) = %q, want it unchanged", q, got)
+	}
+	want := "UPDATE homescript_state SET expires = $1 WHERE name = $2"
+	if got := (&sqlBackend{dollar: true}).query(q); got != want {
+		t.Errorf("query(%q) for PostgreSQL = %q, want %q", q, got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupPrefix and backupSuffix name the files written by BackupTo
+const (
+	backupPrefix = "state-"
+	backupSuffix = ".db"
+)
+
+// ErrNotSupported is returned for backups and compaction of backends that
+// leave them to the database server (SQL)
+var ErrNotSupported = errors.New("not supported by this storage backend, use the database's own tools")
+
+// backupBackend can copy its database while it is in use (bbolt)
+type backupBackend interface {
+	Backup(w io.Writer) (int64, error)
+}
+
+// compactBackend can shrink its database file while it is in use (bbolt)
+type compactBackend interface {
+	Compact() (before, after int64, err error)
+}
+
+// Backup writes a consistent copy of the database to w
+func (s *Storage) Backup(w io.Writer) (int64, error) {
+	backend, ok := s.backend.(backupBackend)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	if err := s.Flush(); err != nil {
+		return 0, err
+	}
+	return backend.Backup(w)
+}
+
+// BackupTo writes a copy of the database to a timestamped file in dir and
+// deletes all but the newest keep copies there (all are kept if keep <= 0).
+// It returns the path of the new copy.
+func (s *Storage) BackupTo(dir string, keep int) (string, error) {
+	if _, ok := s.backend.(backupBackend); !ok {
+		return "", ErrNotSupported
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupPrefix+time.Now().Format("20060102-150405")+backupSuffix)
+	tmp, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = s.Backup(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("backup failed: %w", err)
+	}
+
+	if keep > 0 {
+		if err := rotateBackups(dir, keep); err != nil {
+			log.Warn("Failed to delete old backups in %s: %v", dir, err)
+		}
+	}
+	return path, nil
+}
+
+// rotateBackups deletes all but the newest keep backups in dir
+func rotateBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	// Timestamped names sort oldest first
+	sort.Strings(backups)
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Compact shrinks the database file, reporting its size before and after.
+// Reads and writes wait until it is done.
+func (s *Storage) Compact() (before, after int64, err error) {
+	backend, ok := s.backend.(compactBackend)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+	if err := s.Flush(); err != nil {
+		return 0, 0, err
+	}
+	return backend.Compact()
+}
+
+// StartBackups backs the database up to dir every interval, keeping the
+// newest keep copies and compacting the database after each backup if
+// compact is set, until the returned function is called
+func (s *Storage) StartBackups(dir string, interval time.Duration, keep int, compact bool) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			path, err := s.BackupTo(dir, keep)
+			if err != nil {
+				log.Warn("Scheduled backup failed: %v", err)
+				continue
+			}
+			log.Info("Backed up state to %s", path)
+			if compact {
+				before, after, err := s.Compact()
+				if err != nil {
+					log.Warn("Compaction failed: %v", err)
+					continue
+				}
+				log.Info("Compacted state database: %d -> %d bytes", before, after)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxPendingKeys flushes a batch early once it changes this many keys
+const maxPendingKeys = 1000
+
+// SetBatchWindow delays writes by up to window so bursts of them are
+// committed in one transaction (one fsync) instead of one each. Reads see
+// batched writes right away; a failed commit is logged and its keys are
+// reread from the database. 0 writes through (the default).
+func (s *Storage) SetBatchWindow(window time.Duration) {
+	if err := s.Flush(); err != nil {
+		log.Error("%v", err)
+	}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	s.batchWindow = window
+}
+
+// Flush commits the batched writes now
+func (s *Storage) Flush() error {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	return s.flushPending()
+}
+
+// flushPending commits the pending batch (s.pendingMu must be held)
+func (s *Storage) flushPending() error {
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+	batch := s.pending
+	s.pending = nil
+	if batch == nil {
+		return nil
+	}
+
+	if err := s.backend.Write(batch); err != nil {
+		// The cache holds values the database doesn't have
+		for key := range batch.Put {
+			s.forget(key)
+		}
+		for _, key := range batch.Delete {
+			s.forget(key)
+		}
+		return fmt.Errorf("failed to write %d batched key(s): %w", len(batch.Put)+len(batch.Delete), err)
+	}
+	return nil
+}
+
+// get reads keys from the pending batch, or the backend for those it
+// doesn't change
+func (s *Storage) get(keys []string) (map[string][]byte, error) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pending == nil {
+		return s.backend.Get(keys)
+	}
+
+	values := make(map[string][]byte, len(keys))
+	var stored []string
+	for _, key := range keys {
+		if data, ok := s.pending.Put[key]; ok {
+			values[key] = data
+		} else if !containsKey(s.pending.Delete, key) {
+			stored = append(stored, key)
+		}
+	}
+	if len(stored) == 0 {
+		return values, nil
+	}
+	read, err := s.backend.Get(stored)
+	if err != nil {
+		return nil, err
+	}
+	for key, data := range read {
+		values[key] = data
+	}
+	return values, nil
+}
+
+// keys and all read from the backend after committing batched writes
+func (s *Storage) keys(prefix string) ([]string, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	return s.backend.Keys(prefix)
+}
+
+func (s *Storage) all() (map[string][]byte, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	return s.backend.All()
+}
+
+// write commits batch, or adds it to the pending batch when batching (the
+// caller holds s.writeMu and updates the cache)
+func (s *Storage) write(batch *Batch) error {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.batchWindow <= 0 || batch.Reset {
+		if err := s.flushPending(); err != nil {
+			return err
+		}
+		return s.backend.Write(batch)
+	}
+
+	if s.pending == nil {
+		s.pending = &Batch{Put: make(map[string][]byte), Expires: make(map[string]time.Time)}
+	}
+	mergeBatch(s.pending, batch)
+	if len(s.pending.Put)+len(s.pending.Delete) >= maxPendingKeys {
+		return s.flushPending()
+	}
+	if s.flushTimer == nil {
+		s.flushTimer = time.AfterFunc(s.batchWindow, func() {
+			if err := s.Flush(); err != nil {
+				log.Error("%v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// mergeBatch adds the changes of next to pending as if next was written
+// after it
+func mergeBatch(pending, next *Batch) {
+	for key, data := range next.Put {
+		pending.Put[key] = data
+		if removeKey(&pending.Delete, key) {
+			// The delete removed the expiry, which next may not set
+			pending.Expires[key] = time.Time{}
+		}
+	}
+	for key, expires := range next.Expires {
+		pending.Expires[key] = expires
+	}
+	for _, key := range next.Delete {
+		delete(pending.Put, key)
+		delete(pending.Expires, key)
+		removeKey(&pending.Delete, key)
+		pending.Delete = append(pending.Delete, key)
+	}
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// removeKey removes key from keys, reporting whether it was there
+func removeKey(keys *[]string, key string) bool {
+	for i, k := range *keys {
+		if k == key {
+			*keys = append((*keys)[:i], (*keys)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
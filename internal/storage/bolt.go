@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxSize is how many bytes Compact copies per transaction
+const compactTxSize = 64 << 20
+
+var (
+	stateBucket = []byte("state")
+	// expiryBucket holds the expiry time of keys set with a TTL
+	expiryBucket = []byte("expiry")
+)
+
+// boltBackend keeps the state in a bbolt file, which only one process can
+// open at a time
+type boltBackend struct {
+	// mu guards db, which Compact replaces
+	mu sync.RWMutex
+	db *bbolt.DB
+}
+
+func openBolt(path string) (*boltBackend, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &boltBackend{db: db}, nil
+}
+
+// openBoltDB opens the file at path and creates the buckets
+func openBoltDB(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Create buckets
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(stateBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(expiryBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+	return db, nil
+}
+
+func (b *boltBackend) view(fn func(tx *bbolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.View(fn)
+}
+
+func (b *boltBackend) update(fn func(tx *bbolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.db.Update(fn)
+}
+
+func (b *boltBackend) Get(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	err := b.view(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(stateBucket)
+		for _, key := range keys {
+			if data := bucket.Get([]byte(key)); data != nil {
+				// Only valid during the transaction
+				values[key] = bytes.Clone(data)
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+func (b *boltBackend) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := b.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(stateBucket).Cursor()
+		prefixBytes := []byte(prefix)
+		for k, _ := c.Seek(prefixBytes); k != nil && bytes.HasPrefix(k, prefixBytes); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (b *boltBackend) All() (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	err := b.view(func(tx *bbolt.Tx) error {
+		return tx.Bucket(stateBucket).ForEach(func(k, v []byte) error {
+			values[string(k)] = bytes.Clone(v)
+			return nil
+		})
+	})
+	return values, err
+}
+
+func (b *boltBackend) Expiries() (map[string]time.Time, error) {
+	expires := make(map[string]time.Time)
+	err := b.view(func(tx *bbolt.Tx) error {
+		return tx.Bucket(expiryBucket).ForEach(func(k, v []byte) error {
+			t, err := time.Parse(time.RFC3339Nano, string(v))
+			if err != nil {
+				return fmt.Errorf("key %s: %w", k, err)
+			}
+			expires[string(k)] = t
+			return nil
+		})
+	})
+	return expires, err
+}
+
+func (b *boltBackend) Write(batch *Batch) error {
+	return b.update(func(tx *bbolt.Tx) error {
+		if batch.Reset {
+			for _, name := range [][]byte{stateBucket, expiryBucket} {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+				if _, err := tx.CreateBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+
+		state := tx.Bucket(stateBucket)
+		expiry := tx.Bucket(expiryBucket)
+		for key, data := range batch.Put {
+			if err := state.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		for key, expires := range batch.Expires {
+			var err error
+			if expires.IsZero() {
+				err = expiry.Delete([]byte(key))
+			} else {
+				err = expiry.Put([]byte(key), []byte(expires.UTC().Format(time.RFC3339Nano)))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for _, key := range batch.Delete {
+			if err := state.Delete([]byte(key)); err != nil {
+				return err
+			}
+			if err := expiry.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.db.Close()
+}
+
+// Backup writes a consistent copy of the database file to w while it is in use
+func (b *boltBackend) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := b.view(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Compact rewrites the database file without the free pages left by deleted
+// and overwritten keys, and reports its size before and after. Reads and
+// writes wait until it is done.
+func (b *boltBackend) Compact() (before, after int64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	path := b.db.Path()
+	if info, err := os.Stat(path); err == nil {
+		before = info.Size()
+	}
+	tmp := path + ".compact"
+	_ = os.Remove(tmp)
+	dst, err := bbolt.Open(tmp, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return before, 0, fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+	err = bbolt.Compact(dst, b.db, compactTxSize)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return before, 0, fmt.Errorf("failed to compact: %w", err)
+	}
+
+	// Swap the files; the old one stays in place if anything goes wrong
+	if err := b.db.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return before, 0, err
+	}
+	renameErr := os.Rename(tmp, path)
+	db, err := openBoltDB(path)
+	if err != nil {
+		return before, 0, err
+	}
+	b.db = db
+	if renameErr != nil {
+		_ = os.Remove(tmp)
+		return before, before, fmt.Errorf("failed to replace database: %w", renameErr)
+	}
+	if info, err := os.Stat(path); err == nil {
+		after = info.Size()
+	}
+	return before, after, nil
+}
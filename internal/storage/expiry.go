@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// sweepInterval is how often keys past their TTL are deleted. Until then
+// they already read as missing.
+const sweepInterval = 30 * time.Second
+
+// Expire sets the TTL of an existing key, or removes it if ttl <= 0
+func (s *Storage) Expire(key string, ttl time.Duration) error {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.cacheMu.RLock()
+	expired := s.expired(key, time.Now())
+	s.cacheMu.RUnlock()
+	if expired {
+		return fmt.Errorf("key not found: %s", key)
+	}
+
+	// Writes hold writeMu, so the key can't go away before the expiry is set
+	stored, err := s.get([]string{key})
+	if err != nil {
+		return err
+	}
+	if _, ok := stored[key]; !ok {
+		return fmt.Errorf("key not found: %s", key)
+	}
+	if err := s.write(&Batch{Expires: map[string]time.Time{key: expires}}); err != nil {
+		return err
+	}
+	s.setExpiry(key, expires)
+	return nil
+}
+
+// TTL returns the time left before key expires; false if it has no TTL
+func (s *Storage) TTL(key string) (time.Duration, bool) {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	expires, ok := s.expires[key]
+	if !ok {
+		return 0, false
+	}
+	return max(time.Until(expires), 0), true
+}
+
+// Expiries returns when the keys with a TTL expire
+func (s *Storage) Expiries() map[string]time.Time {
+	now := time.Now()
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	expires := make(map[string]time.Time, len(s.expires))
+	for key, t := range s.expires {
+		if now.Before(t) {
+			expires[key] = t
+		}
+	}
+	return expires
+}
+
+// expired reports whether key is past its TTL (s.cacheMu must be held)
+func (s *Storage) expired(key string, now time.Time) bool {
+	expires, ok := s.expires[key]
+	return ok && !now.Before(expires)
+}
+
+// unexpired drops the keys past their TTL
+func (s *Storage) unexpired(keys []string) []string {
+	now := time.Now()
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+	kept := keys[:0]
+	for _, key := range keys {
+		if !s.expired(key, now) {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+// setExpiry records the expiry of key (zero for none)
+func (s *Storage) setExpiry(key string, expires time.Time) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if expires.IsZero() {
+		delete(s.expires, key)
+	} else {
+		s.expires[key] = expires
+	}
+}
+
+// sweepLoop deletes expired keys until Close
+func (s *Storage) sweepLoop() {
+	defer close(s.sweepDone)
+	s.sweep()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopSweep:
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep deletes the keys past their TTL in one transaction
+func (s *Storage) sweep() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	// Writes hold writeMu, so the expiries can't change under us
+	now := time.Now()
+	var due []string
+	s.cacheMu.RLock()
+	for key, expires := range s.expires {
+		if !now.Before(expires) {
+			due = append(due, key)
+		}
+	}
+	s.cacheMu.RUnlock()
+	if len(due) == 0 {
+		return
+	}
+
+	if err := s.write(&Batch{Delete: due}); err != nil {
+		log.Warn("Failed to delete %d expired key(s): %v", len(due), err)
+		return
+	}
+	for _, key := range due {
+		s.remember(key, cacheEntry{missing: true}, time.Time{})
+	}
+	log.Debug("Deleted %d expired key(s)", len(due))
+}
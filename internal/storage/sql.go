@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqlSchema creates the state table; expires is the Unix time in
+// nanoseconds of keys with a TTL
+const sqlSchema = `CREATE TABLE IF NOT EXISTS homescript_state (
+	name TEXT PRIMARY KEY,
+	value TEXT NOT NULL,
+	expires BIGINT
+)`
+
+// sqlBackend keeps the state in a table of a database/sql database, e.g.
+// SQLite or PostgreSQL. The driver must be compiled into the binary.
+type sqlBackend struct {
+	db *sql.DB
+	// dollar numbers placeholders $1, $2 (PostgreSQL) instead of ?
+	dollar bool
+}
+
+// NewSQL creates a Storage instance backed by the homescript_state table of
+// the database at dsn, opened with a registered database/sql driver
+// ("sqlite", "sqlite3", "postgres", "pgx", ...)
+func NewSQL(driver, dsn string) (*Storage, error) {
+	backend, err := openSQL(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithBackend(backend)
+}
+
+func openSQL(driver, dsn string) (*sqlBackend, error) {
+	if drivers := sql.Drivers(); !slices.Contains(drivers, driver) {
+		if len(drivers) == 0 {
+			return nil, fmt.Errorf("database driver %q is not compiled in (no SQL drivers are)", driver)
+		}
+		return nil, fmt.Errorf("database driver %q is not compiled in (available: %s)", driver, strings.Join(drivers, ", "))
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if _, err := db.Exec(sqlSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create table: %w", err)
+	}
+	return &sqlBackend{db: db, dollar: driver == "postgres" || driver == "pgx"}, nil
+}
+
+// query rewrites ? placeholders for the driver
+func (b *sqlBackend) query(q string) string {
+	if !b.dollar {
+		return q
+	}
+	var out strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&out, "$%d", n)
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// maxParams caps the placeholders of one IN (...) list, below SQLite's
+// default limit of 999 bound parameters
+const maxParams = 500
+
+func (b *sqlBackend) Get(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	for len(keys) > 0 {
+		chunk := keys[:min(len(keys), maxParams)]
+		keys = keys[len(chunk):]
+
+		args := make([]interface{}, len(chunk))
+		for i, key := range chunk {
+			args[i] = key
+		}
+		q := "SELECT name, value FROM homescript_state WHERE name IN (?" + strings.Repeat(", ?", len(chunk)-1) + ")"
+		rows, err := b.db.Query(b.query(q), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key, value string
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			values[key] = []byte(value)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// likeEscaper escapes the LIKE wildcards of a prefix, with ! as the escape
+// character (a backslash means different things to SQLite and PostgreSQL)
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (b *sqlBackend) Keys(prefix string) ([]string, error) {
+	rows, err := b.db.Query(b.query(`SELECT name FROM homescript_state
+		WHERE name LIKE ? || '%' ESCAPE '!' ORDER BY name`), likeEscaper.Replace(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		// SQLite's LIKE ignores ASCII case
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+func (b *sqlBackend) All() (map[string][]byte, error) {
+	rows, err := b.db.Query("SELECT name, value FROM homescript_state")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string][]byte)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = []byte(value)
+	}
+	return values, rows.Err()
+}
+
+func (b *sqlBackend) Expiries() (map[string]time.Time, error) {
+	rows, err := b.db.Query("SELECT name, expires FROM homescript_state WHERE expires IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expires := make(map[string]time.Time)
+	for rows.Next() {
+		var key string
+		var nanos int64
+		if err := rows.Scan(&key, &nanos); err != nil {
+			return nil, err
+		}
+		expires[key] = time.Unix(0, nanos)
+	}
+	return expires, rows.Err()
+}
+
+func (b *sqlBackend) Write(batch *Batch) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if batch.Reset {
+		if _, err := tx.Exec("DELETE FROM homescript_state"); err != nil {
+			return err
+		}
+	}
+	// An upsert keeps the expiry of existing keys, like the bbolt backend
+	for key, data := range batch.Put {
+		_, err := tx.Exec(b.query(`INSERT INTO homescript_state (name, value) VALUES (?, ?)
+			ON CONFLICT (name) DO UPDATE SET value = excluded.value`), key, string(data))
+		if err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+	for key, expires := range batch.Expires {
+		var nanos interface{}
+		if !expires.IsZero() {
+			nanos = expires.UnixNano()
+		}
+		if _, err := tx.Exec(b.query("UPDATE homescript_state SET expires = ? WHERE name = ?"), nanos, key); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+	for _, key := range batch.Delete {
+		if _, err := tx.Exec(b.query("DELETE FROM homescript_state WHERE name = ?"), key); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *sqlBackend) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,22 @@
+//go:build sqlite
+
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// Run with: go test -tags sqlite ./internal/storage
+func TestSQLiteBackend(t *testing.T) {
+	testBackend(t, func(t *testing.T) Backend {
+		b, err := openSQL("sqlite", "file:"+filepath.Join(t.TempDir(), "state.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = b.Close() })
+		return b
+	})
+}
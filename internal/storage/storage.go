@@ -3,103 +3,394 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"homescript-server/internal/logger"
+	"sync"
 	"time"
-
-	"go.etcd.io/bbolt"
 )
 
-var stateBucket = []byte("state")
+var log = logger.Module("storage")
+
+// maxCacheEntries bounds the read cache; it is emptied when full
+const maxCacheEntries = 10000
+
+// cacheEntry is a decoded value, or a key known not to exist
+type cacheEntry struct {
+	value   interface{}
+	missing bool
+}
 
-// Storage manages persistent state using bbolt
+// Storage manages persistent state in a Backend (bbolt by default). Decoded
+// values are cached in memory (write-through), since handlers often read
+// several keys per event.
 type Storage struct {
-	db *bbolt.DB
+	backend Backend
+	cache   map[string]cacheEntry
+	writes  uint64 // bumped by every write, so a racing Get doesn't cache a stale read
+	cacheMu sync.RWMutex
+	// expires holds the expiry of keys with a TTL (guarded by cacheMu)
+	expires map[string]time.Time
+	// writeMu orders writes with their cache updates (the backend serializes them anyway)
+	writeMu sync.Mutex
+
+	// Batched writes not committed yet (see SetBatchWindow)
+	pendingMu   sync.Mutex
+	pending     *Batch
+	batchWindow time.Duration
+	flushTimer  *time.Timer
+
+	stopSweep chan struct{}
+	sweepDone chan struct{}
 }
 
-// New creates a new Storage instance
+// New creates a new Storage instance backed by the bbolt file at path
 func New(path string) (*Storage, error) {
-	db, err := bbolt.Open(path, 0600, &bbolt.Options{
-		Timeout: 1 * time.Second,
-	})
+	backend, err := openBolt(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
+	return NewWithBackend(backend)
+}
 
-	// Create buckets
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(stateBucket)
-		return err
-	})
+// NewWithBackend creates a Storage instance on top of backend, which it
+// closes on Close
+func NewWithBackend(backend Backend) (*Storage, error) {
+	expires, err := backend.Expiries()
 	if err != nil {
-		_ = db.Close()
-		return nil, fmt.Errorf("failed to create buckets: %w", err)
+		_ = backend.Close()
+		return nil, fmt.Errorf("failed to load key expiries: %w", err)
 	}
 
-	return &Storage{db: db}, nil
+	s := &Storage{
+		backend:   backend,
+		cache:     make(map[string]cacheEntry),
+		expires:   expires,
+		stopSweep: make(chan struct{}),
+		sweepDone: make(chan struct{}),
+	}
+	go s.sweepLoop()
+
+	return s, nil
 }
 
 // Get retrieves a value from storage
 func (s *Storage) Get(key string) (interface{}, error) {
-	var value interface{}
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(stateBucket)
-		data := b.Get([]byte(key))
-		if data == nil {
-			return fmt.Errorf("key not found: %s", key)
+	value, found, err := s.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+	return value, nil
+}
+
+// lookup reads key through the cache; found is false if it doesn't exist or
+// is past its TTL
+func (s *Storage) lookup(key string) (value interface{}, found bool, err error) {
+	s.cacheMu.RLock()
+	entry, cached := s.cache[key]
+	writes := s.writes
+	expired := s.expired(key, time.Now())
+	s.cacheMu.RUnlock()
+	if expired {
+		return nil, false, nil
+	}
+	if cached {
+		if entry.missing {
+			return nil, false, nil
 		}
-		return json.Unmarshal(data, &value)
-	})
-	return value, err
+		return copyValue(entry.value), true, nil
+	}
+
+	read, err := s.get([]string{key})
+	if err != nil {
+		return nil, false, err
+	}
+	data, found := read[key]
+	if found {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, false, err
+		}
+	}
+	s.cacheMu.Lock()
+	if s.writes == writes {
+		s.store(key, cacheEntry{value: value, missing: !found})
+	}
+	s.cacheMu.Unlock()
+	return copyValue(value), found, nil
 }
 
-// Set stores a value in storage
+// Set stores a value in storage (without expiry, replacing any TTL)
 func (s *Storage) Set(key string, value interface{}) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(stateBucket)
-		data, err := json.Marshal(value)
-		if err != nil {
-			return err
-		}
-		return b.Put([]byte(key), data)
+	return s.SetTTL(key, value, 0)
+}
+
+// SetTTL stores a value that is deleted after ttl (kept forever if ttl <= 0)
+func (s *Storage) SetTTL(key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err = s.write(&Batch{
+		Put:     map[string][]byte{key: data},
+		Expires: map[string]time.Time{key: expires},
 	})
+	if err != nil {
+		s.forget(key)
+		return err
+	}
+
+	// Cache what Get would decode (numbers become float64 and so on)
+	var decoded interface{}
+	if json.Unmarshal(data, &decoded) == nil {
+		s.remember(key, cacheEntry{value: decoded}, expires)
+	} else {
+		s.forget(key)
+		s.setExpiry(key, expires)
+	}
+	return nil
+}
+
+// GetMany retrieves several values in one transaction; missing keys are
+// left out of the result
+func (s *Storage) GetMany(keys []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(keys))
+	var uncached []string
+
+	now := time.Now()
+	s.cacheMu.RLock()
+	writes := s.writes
+	for _, key := range keys {
+		entry, cached := s.cache[key]
+		switch {
+		case s.expired(key, now):
+		case !cached:
+			uncached = append(uncached, key)
+		case !entry.missing:
+			values[key] = copyValue(entry.value)
+		}
+	}
+	s.cacheMu.RUnlock()
+	if len(uncached) == 0 {
+		return values, nil
+	}
+
+	stored, err := s.get(uncached)
+	if err != nil {
+		return nil, err
+	}
+	read := make(map[string]cacheEntry, len(uncached))
+	for _, key := range uncached {
+		data, ok := stored[key]
+		if !ok {
+			read[key] = cacheEntry{missing: true}
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		read[key] = cacheEntry{value: value}
+	}
+
+	s.cacheMu.Lock()
+	for key, entry := range read {
+		if s.writes == writes {
+			s.store(key, entry)
+		}
+		if !entry.missing {
+			values[key] = copyValue(entry.value)
+		}
+	}
+	s.cacheMu.Unlock()
+	return values, nil
+}
+
+// SetMany stores several values in one transaction; nothing is stored if
+// one of them fails
+func (s *Storage) SetMany(values map[string]interface{}) error {
+	encoded, err := encodeValues(values)
+	if err != nil {
+		return err
+	}
+	expires := make(map[string]time.Time, len(encoded))
+	for key := range encoded {
+		expires[key] = time.Time{}
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err = s.write(&Batch{Put: encoded, Expires: expires})
+	for key, data := range encoded {
+		var decoded interface{}
+		if err == nil && json.Unmarshal(data, &decoded) == nil {
+			s.remember(key, cacheEntry{value: decoded}, time.Time{})
+		} else {
+			s.forget(key)
+			if err == nil {
+				s.setExpiry(key, time.Time{})
+			}
+		}
+	}
+	return err
 }
 
 // Delete removes a value from storage
 func (s *Storage) Delete(key string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(stateBucket)
-		return b.Delete([]byte(key))
-	})
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.write(&Batch{Delete: []string{key}})
+	if err != nil {
+		s.forget(key)
+		return err
+	}
+	s.remember(key, cacheEntry{missing: true}, time.Time{})
+	return nil
+}
+
+// remember caches the result of a write along with the key's expiry (zero
+// for none)
+func (s *Storage) remember(key string, entry cacheEntry, expires time.Time) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.writes++
+	s.store(key, entry)
+	if expires.IsZero() {
+		delete(s.expires, key)
+	} else {
+		s.expires[key] = expires
+	}
+}
+
+// forget drops a key whose stored value is unknown
+func (s *Storage) forget(key string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.writes++
+	delete(s.cache, key)
+}
+
+// store adds an entry (s.cacheMu must be held)
+func (s *Storage) store(key string, entry cacheEntry) {
+	if len(s.cache) >= maxCacheEntries {
+		s.cache = make(map[string]cacheEntry)
+	}
+	s.cache[key] = entry
+}
+
+// copyValue copies decoded maps and slices so callers can't modify cached values
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			c[k] = copyValue(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = copyValue(item)
+		}
+		return c
+	default:
+		return value
+	}
 }
 
 // List returns all keys with a given prefix
 func (s *Storage) List(prefix string) ([]string, error) {
-	var keys []string
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(stateBucket)
-		c := b.Cursor()
-
-		prefixBytes := []byte(prefix)
-		for k, _ := c.Seek(prefixBytes); k != nil && len(k) >= len(prefixBytes); k, _ = c.Next() {
-			// Check if key starts with prefix
-			match := true
-			for i := 0; i < len(prefixBytes); i++ {
-				if k[i] != prefixBytes[i] {
-					match = false
-					break
-				}
-			}
-			if match {
-				keys = append(keys, string(k))
-			} else {
-				break
+	keys, err := s.keys(prefix)
+	return s.unexpired(keys), err
+}
+
+// All returns every stored value by key
+func (s *Storage) All() (map[string]interface{}, error) {
+	stored, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(stored))
+	for key, data := range stored {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		values[key] = value
+	}
+
+	now := time.Now()
+	s.cacheMu.RLock()
+	for key := range values {
+		if s.expired(key, now) {
+			delete(values, key)
+		}
+	}
+	s.cacheMu.RUnlock()
+	return values, nil
+}
+
+// Replace swaps the whole state for values in one transaction. Keys in
+// expires (may be nil) get that expiry, or are left out if it has passed;
+// the others have no TTL.
+func (s *Storage) Replace(values map[string]interface{}, expires map[string]time.Time) error {
+	now := time.Now()
+	live := make(map[string]interface{}, len(values))
+	kept := make(map[string]time.Time)
+	for key, value := range values {
+		if t, ok := expires[key]; ok && !t.IsZero() {
+			if !now.Before(t) {
+				continue
 			}
+			kept[key] = t
 		}
-		return nil
-	})
-	return keys, err
+		live[key] = value
+	}
+	encoded, err := encodeValues(live)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err = s.write(&Batch{Reset: true, Put: encoded, Expires: kept})
+
+	s.cacheMu.Lock()
+	s.writes++
+	s.cache = make(map[string]cacheEntry)
+	if err == nil {
+		s.expires = kept
+	}
+	s.cacheMu.Unlock()
+	return err
 }
 
-// Close closes the database
+// encodeValues marshals values to JSON
+func encodeValues(values map[string]interface{}) (map[string][]byte, error) {
+	encoded := make(map[string][]byte, len(values))
+	for key, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		encoded[key] = data
+	}
+	return encoded, nil
+}
+
+// Close stops expiring keys, commits batched writes and closes the backend
 func (s *Storage) Close() error {
-	return s.db.Close()
+	close(s.stopSweep)
+	<-s.sweepDone
+	if err := s.Flush(); err != nil {
+		log.Error("%v", err)
+	}
+	return s.backend.Close()
 }
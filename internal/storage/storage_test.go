@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memBackend is an in-memory Backend whose writes can be made to fail
+type memBackend struct {
+	mu        sync.Mutex
+	values    map[string][]byte
+	expires   map[string]time.Time
+	failWrite bool
+	// afterGet runs once, when Get has read its values and before it returns
+	afterGet func()
+}
+
+var errWrite = errors.New("write failed")
+
+func newMemBackend() *memBackend {
+	return &memBackend{values: make(map[string][]byte), expires: make(map[string]time.Time)}
+}
+
+func (b *memBackend) Get(keys []string) (map[string][]byte, error) {
+	b.mu.Lock()
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if data, ok := b.values[key]; ok {
+			values[key] = data
+		}
+	}
+	hook := b.afterGet
+	b.afterGet = nil
+	b.mu.Unlock()
+
+	if hook != nil {
+		hook()
+	}
+	return values, nil
+}
+
+func (b *memBackend) Keys(prefix string) ([]string, error) { return nil, nil }
+
+func (b *memBackend) All() (map[string][]byte, error) { return nil, nil }
+
+func (b *memBackend) Expiries() (map[string]time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	expires := make(map[string]time.Time, len(b.expires))
+	for key, t := range b.expires {
+		expires[key] = t
+	}
+	return expires, nil
+}
+
+func (b *memBackend) Write(batch *Batch) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failWrite {
+		return errWrite
+	}
+	if batch.Reset {
+		b.values = make(map[string][]byte)
+		b.expires = make(map[string]time.Time)
+	}
+	for key, data := range batch.Put {
+		b.values[key] = data
+	}
+	for key, t := range batch.Expires {
+		if t.IsZero() {
+			delete(b.expires, key)
+		} else {
+			b.expires[key] = t
+		}
+	}
+	for _, key := range batch.Delete {
+		delete(b.values, key)
+		delete(b.expires, key)
+	}
+	return nil
+}
+
+func (b *memBackend) Close() error { return nil }
+
+func (b *memBackend) setFailWrite(fail bool) {
+	b.mu.Lock()
+	b.failWrite = fail
+	b.mu.Unlock()
+}
+
+func newMemStorage(t *testing.T) (*Storage, *memBackend) {
+	t.Helper()
+	backend := newMemBackend()
+	s, err := NewWithBackend(backend)
+	if err != nil {
+		t.Fatalf("NewWithBackend: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, backend
+}
+
+func TestCacheAfterFailedWrite(t *testing.T) {
+	writes := []struct {
+		name  string
+		write func(s *Storage) error
+	}{
+		{"Set", func(s *Storage) error { return s.Set("key", "new") }},
+		{"SetTTL", func(s *Storage) error { return s.SetTTL("key", "new", time.Hour) }},
+		{"SetMany", func(s *Storage) error { return s.SetMany(map[string]interface{}{"key": "new"}) }},
+		{"Delete", func(s *Storage) error { return s.Delete("key") }},
+		{"CompareAndSwap", func(s *Storage) error { _, _, err := s.CompareAndSwap("key", "old", "new"); return err }},
+		{"CompareAndSwap delete", func(s *Storage) error { _, _, err := s.CompareAndSwap("key", "old", nil); return err }},
+	}
+	for _, tt := range writes {
+		t.Run(tt.name, func(t *testing.T) {
+			s, backend := newMemStorage(t)
+			if err := s.Set("key", "old"); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+
+			backend.setFailWrite(true)
+			if err := tt.write(s); !errors.Is(err, errWrite) {
+				t.Fatalf("write error = %v, want %v", err, errWrite)
+			}
+			backend.setFailWrite(false)
+
+			if got, err := s.Get("key"); err != nil || got != "old" {
+				t.Errorf("Get = %v, %v, want the stored value old", got, err)
+			}
+			if _, ok := s.TTL("key"); ok {
+				t.Error("failed write left a TTL")
+			}
+		})
+	}
+}
+
+func TestCacheFailedWriteOfMissingKey(t *testing.T) {
+	s, backend := newMemStorage(t)
+	if _, err := s.Get("key"); err == nil {
+		t.Fatal("Get of a missing key succeeded")
+	}
+
+	backend.setFailWrite(true)
+	if err := s.Set("key", "new"); err == nil {
+		t.Fatal("Set succeeded")
+	}
+	backend.setFailWrite(false)
+
+	if got, err := s.Get("key"); err == nil {
+		t.Errorf("Get = %v, want the key missing", got)
+	}
+
+	// The failed write dropped the cached entry: a value that reaches the
+	// backend another way (e.g. a retried commit) is read
+	backend.setFailWrite(true)
+	if err := s.Set("key", "new"); err == nil {
+		t.Fatal("Set succeeded")
+	}
+	backend.setFailWrite(false)
+	if err := backend.Write(&Batch{Put: map[string][]byte{"key": []byte(`"outside"`)}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, err := s.Get("key"); err != nil || got != "outside" {
+		t.Errorf("Get = %v, %v, want outside", got, err)
+	}
+}
+
+func TestCacheReadRacingWrite(t *testing.T) {
+	s, backend := newMemStorage(t)
+	if err := s.Set("key", "old"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	s.forget("key")
+
+	// A write commits and updates the cache after the read fetched "old"
+	// but before it caches it, as a Set on another goroutine would
+	backend.afterGet = func() {
+		if err := backend.Write(&Batch{Put: map[string][]byte{"key": []byte(`"new"`)}}); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+		s.remember("key", cacheEntry{value: "new"}, time.Time{})
+	}
+	if got, err := s.Get("key"); err != nil || got != "old" {
+		t.Fatalf("racing Get = %v, %v, want old", got, err)
+	}
+
+	if got, err := s.Get("key"); err != nil || got != "new" {
+		t.Errorf("Get after the racing read = %v, %v, want new", got, err)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	s, backend := newMemStorage(t)
+	if err := s.SetTTL("key", "value", time.Hour); err != nil {
+		t.Fatalf("SetTTL: %v", err)
+	}
+	expires := s.expires["key"]
+
+	s.cacheMu.RLock()
+	before := s.expired("key", expires.Add(-time.Nanosecond))
+	at := s.expired("key", expires)
+	s.cacheMu.RUnlock()
+	if before {
+		t.Error("key expired before its expiry")
+	}
+	if !at {
+		t.Error("key not expired at its expiry")
+	}
+
+	// Past the TTL the cached value reads as missing before the sweep
+	s.setExpiry("key", time.Now())
+	if got, err := s.Get("key"); err == nil {
+		t.Errorf("Get = %v, want the expired key missing", got)
+	}
+	if values, _ := s.GetMany([]string{"key"}); len(values) != 0 {
+		t.Errorf("GetMany = %v, want the expired key left out", values)
+	}
+	if _, ok := backend.values["key"]; !ok {
+		t.Fatal("expired key deleted before the sweep")
+	}
+
+	// Incr on it starts over, without the TTL
+	if n, err := s.Incr("key", 1); err != nil || n != 1 {
+		t.Errorf("Incr of the expired key = %v, %v, want 1", n, err)
+	}
+	if _, ok := s.TTL("key"); ok {
+		t.Error("Incr kept the TTL of the expired key")
+	}
+	if got, err := s.Get("key"); err != nil || got != 1.0 {
+		t.Errorf("Get = %v, %v, want 1", got, err)
+	}
+
+	// The sweep deletes keys past their TTL and caches them as missing
+	if err := s.SetTTL("gone", "x", time.Hour); err != nil {
+		t.Fatalf("SetTTL: %v", err)
+	}
+	s.setExpiry("gone", time.Now())
+	s.sweep()
+	if _, ok := backend.values["gone"]; ok {
+		t.Error("sweep kept the expired key")
+	}
+	if entry, cached := s.cache["gone"]; !cached || !entry.missing {
+		t.Errorf("cache entry after sweep = %+v, %v, want missing", entry, cached)
+	}
+}
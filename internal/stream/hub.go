@@ -0,0 +1,163 @@
+package stream
+
+import (
+	"homescript-server/internal/logger"
+	"math"
+	"sync"
+	"time"
+)
+
+var log = logger.Module("stream")
+
+// Change is a single device attribute change delivered to subscribers
+type Change struct {
+	Device    string      `json:"device"`
+	Attribute string      `json:"attribute"`
+	Value     interface{} `json:"value"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Filter selects which changes a subscriber receives (empty fields match everything)
+type Filter struct {
+	Devices    []string `json:"devices,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+	// MinDelta suppresses numeric changes smaller than this since the last delivered value
+	MinDelta float64 `json:"min_delta,omitempty"`
+}
+
+// Subscription receives filtered changes on C until closed
+type Subscription struct {
+	C        chan Change
+	filter   Filter
+	devices  map[string]bool
+	attrs    map[string]bool
+	lastSent map[string]interface{} // "device\x00attr" -> last delivered value
+	mu       sync.Mutex
+}
+
+// SetFilter replaces the subscription filter
+func (s *Subscription) SetFilter(filter Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.filter = filter
+	s.devices = toSet(filter.Devices)
+	s.attrs = toSet(filter.Attributes)
+}
+
+// accept applies the filter and delta suppression to a change
+func (s *Subscription) accept(change Change) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.devices) > 0 && !s.devices[change.Device] {
+		return false
+	}
+	if len(s.attrs) > 0 && !s.attrs[change.Attribute] {
+		return false
+	}
+
+	key := change.Device + "\x00" + change.Attribute
+	last, seen := s.lastSent[key]
+	if seen && s.filter.MinDelta > 0 {
+		newNum, ok1 := toFloat(change.Value)
+		oldNum, ok2 := toFloat(last)
+		if ok1 && ok2 && math.Abs(newNum-oldNum) < s.filter.MinDelta {
+			return false
+		}
+	}
+
+	s.lastSent[key] = change.Value
+	return true
+}
+
+// Hub fans out device state changes to filtered subscribers
+type Hub struct {
+	subscribers map[*Subscription]bool
+	mu          sync.RWMutex
+}
+
+// NewHub creates a new Hub
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]bool)}
+}
+
+// Subscribe registers a new subscriber with the given filter
+func (h *Hub) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{
+		C:        make(chan Change, 64),
+		lastSent: make(map[string]interface{}),
+	}
+	sub.SetFilter(filter)
+
+	h.mu.Lock()
+	h.subscribers[sub] = true
+	h.mu.Unlock()
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// PublishState publishes each attribute of a device state update
+// (signature matches devices.StateListener)
+func (h *Hub) PublishState(deviceID string, state map[string]interface{}) {
+	now := time.Now()
+	for attr, value := range state {
+		// Binary payloads (snapshots) are not streamed
+		if _, isBinary := value.([]byte); isBinary {
+			continue
+		}
+		h.Publish(Change{Device: deviceID, Attribute: attr, Value: value, Timestamp: now})
+	}
+}
+
+// Publish delivers a change to every matching subscriber; slow subscribers drop changes
+func (h *Hub) Publish(change Change) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subscribers {
+		if !sub.accept(change) {
+			continue
+		}
+		select {
+		case sub.C <- change:
+		default:
+			log.Debug("Subscriber too slow, dropping %s.%s", change.Device, change.Attribute)
+		}
+	}
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		if item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// subscriberID restricts MQTT stream subscriber IDs to a single topic level
+var subscriberID = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// mqttRequest is a subscription request published to <prefix>/subscribe
+type mqttRequest struct {
+	ID string `json:"id"`
+	Filter
+}
+
+// MQTTStream serves filtered changes over MQTT:
+//
+//	publish {"id": "tablet", "devices": ["porch"], "min_delta": 0.5} to <prefix>/subscribe
+//	receive changes on <prefix>/<id>
+//	publish {"id": "tablet"} to <prefix>/unsubscribe to stop
+type MQTTStream struct {
+	client mqtt.Client
+	hub    *Hub
+	prefix string
+	subs   map[string]*Subscription
+	mu     sync.Mutex
+}
+
+// NewMQTTStream creates an MQTT stream under prefix (e.g. "homescript/stream")
+func NewMQTTStream(client mqtt.Client, hub *Hub, prefix string) *MQTTStream {
+	return &MQTTStream{
+		client: client,
+		hub:    hub,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		subs:   make(map[string]*Subscription),
+	}
+}
+
+// Start subscribes to the subscribe/unsubscribe request topics
+func (m *MQTTStream) Start() error {
+	for topic, handler := range map[string]mqtt.MessageHandler{
+		m.prefix + "/subscribe":   m.handleSubscribe,
+		m.prefix + "/unsubscribe": m.handleUnsubscribe,
+	} {
+		token := m.client.Subscribe(topic, 0, handler)
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+		}
+	}
+
+	log.Info("MQTT event stream available at %s/subscribe", m.prefix)
+	return nil
+}
+
+// Stop ends all MQTT stream subscriptions
+func (m *MQTTStream) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, sub := range m.subs {
+		m.hub.Unsubscribe(sub)
+		delete(m.subs, id)
+	}
+}
+
+func (m *MQTTStream) handleSubscribe(_ mqtt.Client, msg mqtt.Message) {
+	var req mqttRequest
+	if err := json.Unmarshal(msg.Payload(), &req); err != nil || !subscriberID.MatchString(req.ID) {
+		log.Warn("Invalid stream subscription request: %s", string(msg.Payload()))
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Re-subscribing with the same ID just updates the filter
+	if sub, ok := m.subs[req.ID]; ok {
+		sub.SetFilter(req.Filter)
+		log.Debug("Updated MQTT stream filter for %s", req.ID)
+		return
+	}
+
+	sub := m.hub.Subscribe(req.Filter)
+	m.subs[req.ID] = sub
+	go m.forward(req.ID, sub)
+	log.Info("MQTT stream subscriber added: %s", req.ID)
+}
+
+func (m *MQTTStream) handleUnsubscribe(_ mqtt.Client, msg mqtt.Message) {
+	var req mqttRequest
+	if err := json.Unmarshal(msg.Payload(), &req); err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sub, ok := m.subs[req.ID]; ok {
+		m.hub.Unsubscribe(sub)
+		delete(m.subs, req.ID)
+		log.Info("MQTT stream subscriber removed: %s", req.ID)
+	}
+}
+
+// forward publishes a subscriber's changes to <prefix>/<id> until unsubscribed
+func (m *MQTTStream) forward(id string, sub *Subscription) {
+	topic := m.prefix + "/" + id
+	for change := range sub.C {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			continue
+		}
+		m.client.Publish(topic, 0, false, payload)
+	}
+}
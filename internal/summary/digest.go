@@ -0,0 +1,122 @@
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMaxLines bounds the timeline of a digest so it fits the context of
+// small local models
+const DefaultMaxLines = 300
+
+// Digest condenses events into text for the model: numeric readings
+// (temperatures, power, ...) become one line per device attribute with their
+// range, everything else (doors, motion, presence, availability, custom
+// events) a timeline in which repeated values are dropped. The timeline keeps
+// the most recent maxLines entries.
+func Digest(events []*types.Event, since, until time.Time, maxLines int) string {
+	type reading struct {
+		device, attribute string
+		count             int
+		min, max, last    float64
+	}
+	readings := make(map[string]*reading)
+	lastValue := make(map[string]string)
+	var timeline []string
+
+	for _, event := range events {
+		if event.Source == "device" && event.Attribute != "" {
+			value := event.Data[event.Attribute]
+			if f, ok := value.(float64); ok {
+				key := event.Device + "/" + event.Attribute
+				r, exists := readings[key]
+				if !exists {
+					r = &reading{device: event.Device, attribute: event.Attribute, min: f, max: f}
+					readings[key] = r
+				}
+				r.count++
+				r.last = f
+				if f < r.min {
+					r.min = f
+				}
+				if f > r.max {
+					r.max = f
+				}
+				continue
+			}
+
+			key := event.Device + "/" + event.Attribute
+			text := formatValue(value)
+			if lastValue[key] == text {
+				continue
+			}
+			lastValue[key] = text
+			line := fmt.Sprintf("%s %s", event.Timestamp.Format("15:04"), event.Device)
+			if event.Area != "" {
+				line += " (" + event.Area + ")"
+			}
+			timeline = append(timeline, fmt.Sprintf("%s %s = %s", line, event.Attribute, text))
+			continue
+		}
+
+		if event.Source == "time" {
+			continue // the clock ticking says nothing about the home
+		}
+		line := fmt.Sprintf("%s %s event %s", event.Timestamp.Format("15:04"), event.Source, event.Type)
+		if len(event.Data) > 0 {
+			line += " " + formatValue(event.Data)
+		}
+		timeline = append(timeline, line)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Events from %s to %s.\n", since.Format("Mon 2 Jan 15:04"), until.Format("Mon 2 Jan 15:04"))
+
+	if len(readings) > 0 {
+		keys := make([]string, 0, len(readings))
+		for key := range readings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		sb.WriteString("\nReadings (device attribute: min..max, last, number of reports):\n")
+		for _, key := range keys {
+			r := readings[key]
+			fmt.Fprintf(&sb, "- %s %s: %s..%s, last %s, %d report(s)\n", r.device, r.attribute,
+				formatValue(r.min), formatValue(r.max), formatValue(r.last), r.count)
+		}
+	}
+
+	if len(timeline) > 0 {
+		sb.WriteString("\nTimeline:\n")
+		if maxLines > 0 && len(timeline) > maxLines {
+			fmt.Fprintf(&sb, "(%d earlier entries omitted)\n", len(timeline)-maxLines)
+			timeline = timeline[len(timeline)-maxLines:]
+		}
+		for _, line := range timeline {
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// formatValue prints a value compactly
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+	case nil:
+		return "null"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
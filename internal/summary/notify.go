@@ -0,0 +1,89 @@
+package summary
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"homescript-server/internal/types"
+	"io"
+	"net/http"
+	"time"
+)
+
+// EventRouter routes events to scripts (the events.Router)
+type EventRouter interface {
+	RouteEvent(event *types.Event)
+}
+
+// EventNotifier routes the summary as a daily_summary custom event, so
+// scripts in events/custom/daily_summary/ send it wherever notifications go
+// (event.data.summary, since, until, events)
+type EventNotifier struct {
+	Router EventRouter
+}
+
+func (n *EventNotifier) String() string {
+	return "events/custom/" + EventType
+}
+
+// Notify routes the event
+func (n *EventNotifier) Notify(_ context.Context, summary *Summary) error {
+	n.Router.RouteEvent(&types.Event{
+		Source: "custom",
+		Type:   EventType,
+		Data: map[string]interface{}{
+			"summary": summary.Text,
+			"since":   summary.Since.Format(time.RFC3339),
+			"until":   summary.Until.Format(time.RFC3339),
+			"events":  summary.Events,
+		},
+		Timestamp:     time.Now(),
+		CorrelationID: types.NewCorrelationID(),
+	})
+	return nil
+}
+
+// WebhookNotifier posts the summary as JSON to a URL (ntfy, Gotify, a
+// chat bridge, ...), with a Bearer token if set
+type WebhookNotifier struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url, token string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, Token: token, Client: &http.Client{}}
+}
+
+func (n *WebhookNotifier) String() string {
+	return n.URL
+}
+
+// Notify posts {"since", "until", "events", "summary"}
+func (n *WebhookNotifier) Notify(ctx context.Context, summary *Summary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", n.URL, resp.Status)
+	}
+	return nil
+}
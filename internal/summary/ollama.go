@@ -0,0 +1,77 @@
+package summary
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultOllamaURL is where Ollama listens by default
+const DefaultOllamaURL = "http://localhost:11434"
+
+// Ollama completes prompts with a model served by Ollama (/api/generate)
+type Ollama struct {
+	URL    string
+	Model  string
+	Client *http.Client
+}
+
+// NewOllama creates a model client for url (DefaultOllamaURL if empty)
+func NewOllama(url, model string) *Ollama {
+	if url == "" {
+		url = DefaultOllamaURL
+	}
+	return &Ollama{URL: strings.TrimSuffix(url, "/"), Model: model, Client: &http.Client{}}
+}
+
+func (o *Ollama) String() string {
+	return fmt.Sprintf("ollama %s (%s)", o.Model, o.URL)
+}
+
+// Complete generates the response to prompt in one piece
+func (o *Ollama) Complete(ctx context.Context, prompt string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":  o.Model,
+		"prompt": prompt,
+		"stream": false,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		Response string `json:"response"`
+		Error    string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	if result.Error != "" {
+		return "", fmt.Errorf("%s", result.Error)
+	}
+	return result.Response, nil
+}
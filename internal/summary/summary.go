@@ -0,0 +1,201 @@
+// Package summary has a language model write a plain-language summary of the
+// events journaled over a day ("the garage door was open from 14:10 to
+// 17:45, nobody was home in the afternoon") and hands it to notifiers. The
+// model runs locally (Ollama), so no event leaves the house; everything is
+// opt-in.
+package summary
+
+import (
+	"context"
+	"fmt"
+	"homescript-server/internal/journal"
+	"homescript-server/internal/logger"
+	"homescript-server/internal/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logger.Module("summary")
+
+// EventType is the custom event carrying the summary to scripts, routed to
+// events/custom/daily_summary/
+const EventType = "daily_summary"
+
+// DefaultPrompt are the instructions placed before the event digest
+const DefaultPrompt = `You are the assistant of a smart home. Below is a digest of what the home's
+devices reported during the last day. Write a short, friendly summary for the
+household in plain language (at most 8 sentences): when people came and went,
+doors or windows left open, unusual activity, devices that went offline, and
+anything that looks like it needs attention. Do not list every event and do
+not invent anything that is not in the digest.`
+
+// runTimeout bounds one summary: digest, model and notifiers
+const runTimeout = 10 * time.Minute
+
+// Source provides journaled events (a *journal.Journal)
+type Source interface {
+	Events(filter journal.Filter) ([]*types.Event, error)
+}
+
+// Model turns a prompt into text
+type Model interface {
+	Complete(ctx context.Context, prompt string) (string, error)
+	String() string
+}
+
+// Summary is one written summary
+type Summary struct {
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+	Events int       `json:"events"`
+	Text   string    `json:"summary"`
+}
+
+// Notifier delivers a summary
+type Notifier interface {
+	Notify(ctx context.Context, summary *Summary) error
+	String() string
+}
+
+// Summarizer writes summaries of the journal and delivers them
+type Summarizer struct {
+	source    Source
+	model     Model
+	notifiers []Notifier
+	prompt    string
+	maxLines  int
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+}
+
+// New creates a summarizer reading source, writing with model and delivering
+// to notifiers
+func New(source Source, model Model, notifiers ...Notifier) *Summarizer {
+	return &Summarizer{
+		source:    source,
+		model:     model,
+		notifiers: notifiers,
+		prompt:    DefaultPrompt,
+		maxLines:  DefaultMaxLines,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// SetPrompt replaces the instructions placed before the digest
+func (s *Summarizer) SetPrompt(prompt string) {
+	if prompt != "" {
+		s.prompt = prompt
+	}
+}
+
+// Prompt returns the complete prompt for the events between since and until
+func (s *Summarizer) Prompt(since, until time.Time) (string, int, error) {
+	events, err := s.source.Events(journal.Filter{Since: since, Until: until})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return s.prompt + "\n\n" + Digest(events, since, until, s.maxLines), len(events), nil
+}
+
+// Run writes the summary of the events between since and until and delivers
+// it to every notifier. A failing notifier does not stop the others; the
+// first error is returned with the summary.
+func (s *Summarizer) Run(ctx context.Context, since, until time.Time) (*Summary, error) {
+	prompt, count, err := s.Prompt(since, until)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("no journaled events between %s and %s", since.Format("2006-01-02 15:04"), until.Format("2006-01-02 15:04"))
+	}
+
+	text, err := s.model.Complete(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.model, err)
+	}
+	summary := &Summary{Since: since, Until: until, Events: count, Text: strings.TrimSpace(text)}
+
+	var firstErr error
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(ctx, summary); err != nil {
+			log.Warn("Failed to deliver summary to %s: %v", notifier, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return summary, firstErr
+}
+
+// Start writes a summary of the preceding 24 hours every day at the local
+// time at ("21:00") until Stop
+func (s *Summarizer) Start(at string) error {
+	hour, minute, err := parseClock(at)
+	if err != nil {
+		return err
+	}
+
+	base, cancel := context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		go func() {
+			// A summary in progress is abandoned on Stop
+			select {
+			case <-s.stopChan:
+				cancel()
+			case <-base.Done():
+			}
+		}()
+		for {
+			next := nextAt(time.Now(), hour, minute)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-s.stopChan:
+				timer.Stop()
+				return
+			}
+
+			ctx, cancelRun := context.WithTimeout(base, runTimeout)
+			summary, err := s.Run(ctx, next.Add(-24*time.Hour), next)
+			cancelRun()
+			if err != nil {
+				log.Warn("Daily summary: %v", err)
+			}
+			if summary != nil {
+				log.Info("Daily summary written (%d event(s))", summary.Events)
+			}
+		}
+	}()
+
+	log.Info("Daily summary at %02d:%02d by %s", hour, minute, s.model)
+	return nil
+}
+
+// Stop stops the daily summaries, abandoning a running one
+func (s *Summarizer) Stop() {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	s.wg.Wait()
+}
+
+// parseClock parses "HH:MM"
+func parseClock(at string) (hour, minute int, err error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid summary time %q (expected HH:MM)", at)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// nextAt returns the next local time hour:minute after now
+func nextAt(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
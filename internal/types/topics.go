@@ -0,0 +1,86 @@
+package types
+
+import "strings"
+
+// Topics are the base topics of the integrations the server talks to, for
+// installations with a custom Zigbee2MQTT base_topic, several Zigbee2MQTT or
+// Frigate instances (zigbee2mqtt_upstairs) or another Home Assistant
+// discovery prefix
+type Topics struct {
+	Zigbee2MQTT   []string `yaml:"zigbee2mqtt"`
+	Frigate       []string `yaml:"frigate"`
+	HomeAssistant string   `yaml:"homeassistant"`
+}
+
+// DefaultTopics are the integrations' own defaults
+func DefaultTopics() Topics {
+	return Topics{
+		Zigbee2MQTT:   []string{"zigbee2mqtt"},
+		Frigate:       []string{"frigate"},
+		HomeAssistant: "homeassistant",
+	}
+}
+
+// WithDefaults fills unset entries from DefaultTopics and drops trailing slashes
+func (t Topics) WithDefaults() Topics {
+	defaults := DefaultTopics()
+	result := Topics{
+		Zigbee2MQTT:   trimTopics(t.Zigbee2MQTT),
+		Frigate:       trimTopics(t.Frigate),
+		HomeAssistant: strings.Trim(t.HomeAssistant, "/"),
+	}
+	if len(result.Zigbee2MQTT) == 0 {
+		result.Zigbee2MQTT = defaults.Zigbee2MQTT
+	}
+	if len(result.Frigate) == 0 {
+		result.Frigate = defaults.Frigate
+	}
+	if result.HomeAssistant == "" {
+		result.HomeAssistant = defaults.HomeAssistant
+	}
+	return result
+}
+
+// Zigbee2MQTTBase returns the Zigbee2MQTT base topic a topic is under
+// ("zigbee2mqtt_upstairs/porch" -> "zigbee2mqtt_upstairs")
+func (t Topics) Zigbee2MQTTBase(topic string) (string, bool) {
+	return baseOf(t.Zigbee2MQTT, topic)
+}
+
+// FrigateBase returns the Frigate base topic a topic is under
+func (t Topics) FrigateBase(topic string) (string, bool) {
+	return baseOf(t.Frigate, topic)
+}
+
+// DeviceBase returns the Zigbee2MQTT or Frigate base topic a discovered
+// device is under; other integrations have none
+func (t Topics) DeviceBase(dev *Device) (string, bool) {
+	switch {
+	case strings.HasPrefix(dev.ID, "frigate/"):
+		return t.FrigateBase(dev.MQTT.CommandTopic)
+	case !strings.Contains(dev.ID, "/"):
+		return t.Zigbee2MQTTBase(dev.MQTT.StateTopic)
+	}
+	return "", false
+}
+
+// baseOf returns the longest base that topic is under
+func baseOf(bases []string, topic string) (string, bool) {
+	found := ""
+	for _, base := range bases {
+		if strings.HasPrefix(topic, base+"/") && len(base) > len(found) {
+			found = base
+		}
+	}
+	return found, found != ""
+}
+
+func trimTopics(topics []string) []string {
+	var result []string
+	for _, topic := range topics {
+		if topic = strings.Trim(topic, "/"); topic != "" {
+			result = append(result, topic)
+		}
+	}
+	return result
+}
@@ -1,6 +1,10 @@
 package types
 
-import "time"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
 
 // Device represents a smart home device
 type Device struct {
@@ -9,32 +13,225 @@ type Device struct {
 	Type       string     `yaml:"type"`
 	Model      string     `yaml:"model,omitempty"`
 	Vendor     string     `yaml:"vendor,omitempty"`
+	Area       string     `yaml:"area,omitempty"` // room/area ID, e.g. "kitchen"
 	Attributes []string   `yaml:"attributes"`
 	Actions    []string   `yaml:"actions"`
 	MQTT       MQTTConfig `yaml:"mqtt"`
+	// Dialect selects payload normalization (e.g. "tuya", "lowercase_onoff", "comma_decimal")
+	Dialect string `yaml:"dialect,omitempty"`
+	// Datapoints maps Tuya datapoint IDs to attribute names (dialect "tuya")
+	Datapoints map[string]string `yaml:"datapoints,omitempty"`
+	// Schema constrains values accepted by device.set, per attribute
+	Schema map[string]*AttributeSchema `yaml:"schema,omitempty"`
+	// Aliases are former or alternative IDs; scripts and event directories using them keep working
+	Aliases []string `yaml:"aliases,omitempty"`
+	// StaleAfter overrides the global --stale-after window for this device
+	// (e.g. "6h" for battery sensors that report rarely)
+	StaleAfter time.Duration `yaml:"stale_after,omitempty"`
+	// Optimistic updates the cached state as soon as a command is sent,
+	// without waiting for the device to report it
+	Optimistic bool `yaml:"optimistic,omitempty"`
+	// Confirm waits for the device to report commanded values, resending and
+	// routing a command_failed event if it doesn't
+	Confirm *ConfirmConfig `yaml:"confirm,omitempty"`
+	// Protect limits how often commands may switch the device (compressors,
+	// relays), whatever scripts ask for
+	Protect *ProtectConfig `yaml:"protect,omitempty"`
+	// Template names a device template for hand-added MQTT devices that
+	// discovery can't find; Vars fill its {placeholders}
+	Template string            `yaml:"template,omitempty"`
+	Vars     map[string]string `yaml:"vars,omitempty"`
+	// StateTopics, Commands and Refresh are resolved from the template at startup
+	StateTopics []*TemplateState            `yaml:"-"`
+	Commands    map[string]*TemplateCommand `yaml:"-"`
+	Refresh     []*TemplateCommand          `yaml:"-"`
+}
+
+// DeviceTemplate describes topics, payload parsing and commands of a family of
+// generic MQTT devices (Tasmota, custom ESP firmware). Topics and payloads may
+// use {id} and any {var} set in the device's vars.
+type DeviceTemplate struct {
+	Type       string                      `yaml:"type"`
+	Vendor     string                      `yaml:"vendor,omitempty"`
+	Model      string                      `yaml:"model,omitempty"`
+	Attributes []string                    `yaml:"attributes"`
+	Actions    []string                    `yaml:"actions,omitempty"`
+	Dialect    string                      `yaml:"dialect,omitempty"`
+	Schema     map[string]*AttributeSchema `yaml:"schema,omitempty"`
+	State      []*TemplateState            `yaml:"state"`
+	// Commands maps attribute names (or "*" for any attribute) to the command sent by device.set
+	Commands          map[string]*TemplateCommand `yaml:"commands,omitempty"`
+	AvailabilityTopic string                      `yaml:"availability_topic,omitempty"`
+	// Refresh is published once the state topics are subscribed, for
+	// firmware that only reports changes unless asked for its state
+	Refresh []*TemplateCommand `yaml:"refresh,omitempty"`
+}
+
+// TemplateState is a topic a templated device reports state on
+type TemplateState struct {
+	Topic string `yaml:"topic"`
+	// Attribute stores a plain (non-JSON) payload under this name
+	Attribute string `yaml:"attribute,omitempty"`
+	// Fields maps attribute names to dotted paths in a JSON payload
+	// ("ENERGY.Power"); without fields every top-level key is used
+	Fields map[string]string `yaml:"fields,omitempty"`
+	// ValueTemplate is a Home Assistant value template ("{{ value_json.POWER }}")
+	// whose result is stored under Attribute; without Attribute it must
+	// produce a JSON object whose keys are used
+	ValueTemplate string `yaml:"value_template,omitempty"`
+	// Values translates received values per attribute ({state: {"true": "ON"}})
+	Values map[string]map[string]string `yaml:"values,omitempty"`
+}
+
+// TemplateCommand is how one attribute is set on a templated device
+type TemplateCommand struct {
+	Topic string `yaml:"topic"`
+	// Payload may contain {value} and {attribute}; empty sends {"<attribute>": value} as JSON
+	Payload string `yaml:"payload,omitempty"`
+	// Values translates values before they are put in the payload ({"ON": "true"})
+	Values map[string]string `yaml:"values,omitempty"`
+}
+
+// ConfirmConfig controls command confirmation of a device
+type ConfirmConfig struct {
+	// Timeout is how long to wait for the state topic after each attempt
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is how often the command is resent before it failed
+	Retries int `yaml:"retries,omitempty"`
+}
+
+// ProtectConfig limits switching of a device
+type ProtectConfig struct {
+	// MinInterval is the shortest time between two switches
+	MinInterval time.Duration `yaml:"min_interval,omitempty"`
+	// MaxPerHour caps switches within any 60 minutes
+	MaxPerHour int `yaml:"max_per_hour,omitempty"`
+	// Attributes that switch the device (default: state)
+	Attributes []string `yaml:"attributes,omitempty"`
+}
+
+// LANDevice is a device found on the local network by mDNS or SSDP that
+// may not be connected to MQTT yet
+type LANDevice struct {
+	// Kind is esphome, shelly, wled or camera
+	Kind    string `yaml:"kind"`
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port,omitempty"`
+	// Source is mdns or ssdp
+	Source string `yaml:"source"`
+	Model  string `yaml:"model,omitempty"`
+	// Info holds the TXT records or UPnP description fields
+	Info map[string]string `yaml:"info,omitempty"`
+	// Hint says how to bring the device onto MQTT
+	Hint string `yaml:"hint"`
+	// Suggested is the devices.yaml entry for the device once MQTT is enabled
+	Suggested *LANSuggestion `yaml:"suggested,omitempty"`
+}
+
+// LANSuggestion is a templated devices.yaml entry for a LAN device
+type LANSuggestion struct {
+	ID       string            `yaml:"id"`
+	Name     string            `yaml:"name"`
+	Template string            `yaml:"template"`
+	Vars     map[string]string `yaml:"vars"`
+}
+
+// DiscoveryRule matches discovered devices for the discovery include and
+// exclude lists. Every field set must match; Vendor, Model, Name and ID are
+// case-insensitive globs (* and ?), Topic is a state topic prefix.
+type DiscoveryRule struct {
+	Vendor string `yaml:"vendor,omitempty"`
+	Model  string `yaml:"model,omitempty"`
+	// Name is the friendly name, e.g. "0x*" for unnamed Zigbee devices
+	Name  string `yaml:"name,omitempty"`
+	ID    string `yaml:"id,omitempty"`
+	Topic string `yaml:"topic,omitempty"`
+}
+
+// AttributeSchema describes valid values of a settable attribute
+type AttributeSchema struct {
+	Min *float64 `yaml:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty"`
+	// Values lists the allowed values of an enum or binary attribute
+	Values []string `yaml:"values,omitempty"`
 }
 
 // MQTTConfig holds MQTT-specific configuration
 type MQTTConfig struct {
 	StateTopic   string `yaml:"state_topic"`
 	CommandTopic string `yaml:"command_topic"`
+	// AvailabilityTopic reports online/offline (Zigbee2MQTT availability or a device LWT)
+	AvailabilityTopic string `yaml:"availability_topic,omitempty"`
+	// QoS (0-2) for the state subscription and commands
+	QoS byte `yaml:"qos,omitempty"`
+	// Retain makes the broker keep the last command, for devices that
+	// fetch it when they reconnect
+	Retain bool `yaml:"retain,omitempty"`
+}
+
+// Group is a named set of devices controlled together
+type Group struct {
+	Devices []string `yaml:"devices"`
+	// Zigbee2MQTT is the friendly name of a native Zigbee2MQTT group; when set,
+	// commands are sent once to the group instead of to each member
+	Zigbee2MQTT string `yaml:"zigbee2mqtt,omitempty"`
+}
+
+// VirtualDevice is a helper that exists only in the server (like Home Assistant's
+// input_boolean/input_number/input_text); it is controlled with device.set
+type VirtualDevice struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name,omitempty"`
+	Type string `yaml:"type"` // "switch", "number" or "text"
+	Area string `yaml:"area,omitempty"`
+	// Initial value at startup ("OFF", 0 or "" if unset)
+	Initial interface{} `yaml:"initial,omitempty"`
+	// Min, Max and Step constrain "number" helpers
+	Min  *float64 `yaml:"min,omitempty"`
+	Max  *float64 `yaml:"max,omitempty"`
+	Step float64  `yaml:"step,omitempty"`
+	// Mirror publishes the state to MQTT for dashboards and accepts commands from it
+	Mirror bool `yaml:"mirror,omitempty"`
 }
 
 // DevicesConfig is the root configuration structure
 type DevicesConfig struct {
-	Devices   []*Device `yaml:"devices"`
-	Generated time.Time `yaml:"generated,omitempty"`
+	Devices   []*Device         `yaml:"devices"`
+	Virtual   []*VirtualDevice  `yaml:"virtual,omitempty"`
+	Groups    map[string]*Group `yaml:"groups,omitempty"`
+	Generated time.Time         `yaml:"generated,omitempty"`
 }
 
 // Event represents an event in the system
 type Event struct {
-	Source    string                 // "mqtt", "time", "device", "state"
-	Type      string                 // event type
-	Device    string                 // device ID (if applicable)
-	Attribute string                 // attribute name (if applicable)
-	Topic     string                 // MQTT topic (if applicable)
-	Data      map[string]interface{} // event payload
-	Timestamp time.Time
+	Source    string `json:"source"`              // "mqtt", "time", "device", "state", "custom", "system", "webhook"
+	Type      string `json:"type"`                // event type
+	Device    string `json:"device,omitempty"`    // device ID (if applicable)
+	Attribute string `json:"attribute,omitempty"` // attribute name (if applicable)
+	Area      string `json:"area,omitempty"`      // area of the device (if applicable)
+	Topic     string `json:"topic,omitempty"`     // MQTT topic (if applicable)
+	// Handler is the directory under events/mqtt/ of a configured subscription,
+	// used instead of the topic (e.g. "owntracks" for owntracks/+/+)
+	Handler   string                 `json:"handler,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"` // event payload
+	Timestamp time.Time              `json:"timestamp"`
+	Depth     int                    `json:"depth,omitempty"` // number of emit() hops that led to this event
+	// FirstReport marks a device event whose attribute had no value before
+	// (a new device, or an attribute reported for the first time), so
+	// edge-triggered handlers can tell the initial sync from a transition
+	FirstReport bool `json:"first_report,omitempty"`
+	// CorrelationID ties together everything caused by one inbound MQTT message or time tick
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// NewCorrelationID returns a short random ID for tracing an event chain
+func NewCorrelationID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("150405.000000")
+	}
+	return hex.EncodeToString(b)
 }
 
 // Zigbee2MQTTDevice represents a device from Zigbee2MQTT
@@ -59,6 +256,10 @@ type Zigbee2MQTTExpose struct {
 	Features []Zigbee2MQTTFeature `json:"features"`
 	Property string               `json:"property"`
 	Name     string               `json:"name"`
+	// Top-level numeric/enum exposes carry their constraints directly
+	Values   []string `json:"values"`
+	ValueMin *float64 `json:"value_min"`
+	ValueMax *float64 `json:"value_max"`
 }
 
 // Zigbee2MQTTFeature represents a specific feature of a capability
@@ -66,8 +267,12 @@ type Zigbee2MQTTFeature struct {
 	Name     string   `json:"name"`
 	Property string   `json:"property"`
 	Values   []string `json:"values"`
-	ValueMin *int     `json:"value_min"`
-	ValueMax *int     `json:"value_max"`
+	ValueMin *float64 `json:"value_min"`
+	ValueMax *float64 `json:"value_max"`
+	// Binary features (e.g. state) name their on/off/toggle values
+	ValueOn     interface{} `json:"value_on"`
+	ValueOff    interface{} `json:"value_off"`
+	ValueToggle interface{} `json:"value_toggle"`
 }
 
 // FrigateStats represents Frigate statistics message
@@ -75,6 +280,40 @@ type FrigateStats struct {
 	Cameras map[string]FrigateCameraStats `json:"cameras"`
 }
 
+// FrigateEvent is a message on frigate/events: the tracked object before and
+// after a change
+type FrigateEvent struct {
+	Type   string             `json:"type"` // new, update or end
+	Before FrigateEventObject `json:"before"`
+	After  FrigateEventObject `json:"after"`
+}
+
+// FrigateEventObject is one tracked object (detection) of a Frigate event
+type FrigateEventObject struct {
+	ID            string   `json:"id"`
+	Camera        string   `json:"camera"`
+	Label         string   `json:"label"`
+	Score         float64  `json:"score"`
+	TopScore      float64  `json:"top_score"`
+	FalsePositive bool     `json:"false_positive"`
+	StartTime     float64  `json:"start_time"`
+	EndTime       *float64 `json:"end_time"`
+	CurrentZones  []string `json:"current_zones"`
+	EnteredZones  []string `json:"entered_zones"`
+	HasSnapshot   bool     `json:"has_snapshot"`
+	HasClip       bool     `json:"has_clip"`
+	Stationary    bool     `json:"stationary"`
+	// Frame time of the best snapshot so far: snapshot_time up to Frigate
+	// 0.12, snapshot.frame_time since 0.13
+	SnapshotTime float64               `json:"snapshot_time"`
+	Snapshot     *FrigateEventSnapshot `json:"snapshot"`
+}
+
+// FrigateEventSnapshot is the best snapshot of a Frigate event
+type FrigateEventSnapshot struct {
+	FrameTime float64 `json:"frame_time"`
+}
+
 // FrigateCameraActivity represents camera activity message (instant response to frigate/onConnect)
 type FrigateCameraActivity map[string]FrigateCameraActivityInfo
 
@@ -117,6 +356,14 @@ type HomeAssistantDiscovery struct {
 	StateOff          string               `json:"state_off,omitempty"`
 	Icon              string               `json:"icon,omitempty"`
 	EntityCategory    string               `json:"entity_category,omitempty"`
+	Schema            string               `json:"schema,omitempty"`
+
+	// Availability lists availability topics (used when availability_topic is unset)
+	Availability []HomeAssistantAvailability `json:"availability,omitempty"`
+
+	// Extra attributes reported as a JSON object
+	JSONAttributesTopic    string `json:"json_attributes_topic,omitempty"`
+	JSONAttributesTemplate string `json:"json_attributes_template,omitempty"`
 
 	// Climate-specific topics (https://www.home-assistant.io/integrations/climate.mqtt/)
 	CurrentTemperatureTopic string `json:"current_temperature_topic,omitempty"`
@@ -128,43 +375,92 @@ type HomeAssistantDiscovery struct {
 	FanModeCommandTopic     string `json:"fan_mode_command_topic,omitempty"`
 	ActionTopic             string `json:"action_topic,omitempty"`
 
+	CurrentTemperatureTemplate string `json:"current_temperature_template,omitempty"`
+	TemperatureStateTemplate   string `json:"temperature_state_template,omitempty"`
+	ModeStateTemplate          string `json:"mode_state_template,omitempty"`
+	FanModeStateTemplate       string `json:"fan_mode_state_template,omitempty"`
+	ActionTemplate             string `json:"action_template,omitempty"`
+
 	// Cover-specific topics
-	PositionTopic    string `json:"position_topic,omitempty"`
-	SetPositionTopic string `json:"set_position_topic,omitempty"`
-	TiltStatusTopic  string `json:"tilt_status_topic,omitempty"`
-	TiltCommandTopic string `json:"tilt_command_topic,omitempty"`
+	PositionTopic      string `json:"position_topic,omitempty"`
+	SetPositionTopic   string `json:"set_position_topic,omitempty"`
+	TiltStatusTopic    string `json:"tilt_status_topic,omitempty"`
+	TiltCommandTopic   string `json:"tilt_command_topic,omitempty"`
+	PositionTemplate   string `json:"position_template,omitempty"`
+	TiltStatusTemplate string `json:"tilt_status_template,omitempty"`
 
 	// Light-specific topics
-	BrightnessStateTopic   string `json:"brightness_state_topic,omitempty"`
-	BrightnessCommandTopic string `json:"brightness_command_topic,omitempty"`
-	ColorModeStateTopic    string `json:"color_mode_state_topic,omitempty"`
-	RGBStateTopic          string `json:"rgb_state_topic,omitempty"`
-	RGBCommandTopic        string `json:"rgb_command_topic,omitempty"`
+	BrightnessStateTopic    string `json:"brightness_state_topic,omitempty"`
+	BrightnessCommandTopic  string `json:"brightness_command_topic,omitempty"`
+	ColorModeStateTopic     string `json:"color_mode_state_topic,omitempty"`
+	RGBStateTopic           string `json:"rgb_state_topic,omitempty"`
+	RGBCommandTopic         string `json:"rgb_command_topic,omitempty"`
+	BrightnessValueTemplate string `json:"brightness_value_template,omitempty"`
+	ColorModeValueTemplate  string `json:"color_mode_value_template,omitempty"`
+	RGBValueTemplate        string `json:"rgb_value_template,omitempty"`
 
 	// Fan-specific topics
-	PercentageStateTopic    string `json:"percentage_state_topic,omitempty"`
-	PercentageCommandTopic  string `json:"percentage_command_topic,omitempty"`
-	PresetModeStateTopic    string `json:"preset_mode_state_topic,omitempty"`
-	PresetModeCommandTopic  string `json:"preset_mode_command_topic,omitempty"`
-	OscillationStateTopic   string `json:"oscillation_state_topic,omitempty"`
-	OscillationCommandTopic string `json:"oscillation_command_topic,omitempty"`
+	PercentageStateTopic     string `json:"percentage_state_topic,omitempty"`
+	PercentageCommandTopic   string `json:"percentage_command_topic,omitempty"`
+	PresetModeStateTopic     string `json:"preset_mode_state_topic,omitempty"`
+	PresetModeCommandTopic   string `json:"preset_mode_command_topic,omitempty"`
+	OscillationStateTopic    string `json:"oscillation_state_topic,omitempty"`
+	OscillationCommandTopic  string `json:"oscillation_command_topic,omitempty"`
+	PercentageValueTemplate  string `json:"percentage_value_template,omitempty"`
+	PresetModeValueTemplate  string `json:"preset_mode_value_template,omitempty"`
+	OscillationValueTemplate string `json:"oscillation_value_template,omitempty"`
 
 	// Humidifier-specific topics
-	TargetHumidityStateTopic   string `json:"target_humidity_state_topic,omitempty"`
-	TargetHumidityCommandTopic string `json:"target_humidity_command_topic,omitempty"`
-	CurrentHumidityTopic       string `json:"current_humidity_topic,omitempty"`
+	TargetHumidityStateTopic    string `json:"target_humidity_state_topic,omitempty"`
+	TargetHumidityCommandTopic  string `json:"target_humidity_command_topic,omitempty"`
+	CurrentHumidityTopic        string `json:"current_humidity_topic,omitempty"`
+	TargetHumidityStateTemplate string `json:"target_humidity_state_template,omitempty"`
+	CurrentHumidityTemplate     string `json:"current_humidity_template,omitempty"`
 
 	// Additional fields that may be present
 	Extra map[string]interface{} `json:"-"`
 }
 
+// HomeAssistantAvailability is one entry of an HA discovery availability list
+type HomeAssistantAvailability struct {
+	Topic string `json:"topic"`
+}
+
 // HomeAssistantDevice represents device info in HA discovery
 type HomeAssistantDevice struct {
-	Identifiers  []string `json:"identifiers"`
-	Name         string   `json:"name"`
-	Model        string   `json:"model,omitempty"`
-	Manufacturer string   `json:"manufacturer,omitempty"`
-	SWVersion    string   `json:"sw_version,omitempty"`
+	Identifiers   []string `json:"identifiers"`
+	Name          string   `json:"name"`
+	Model         string   `json:"model,omitempty"`
+	Manufacturer  string   `json:"manufacturer,omitempty"`
+	SWVersion     string   `json:"sw_version,omitempty"`
+	SuggestedArea string   `json:"suggested_area,omitempty"`
+}
+
+// TasmotaDiscovery is the config Tasmota (9.2+, SetOption19 0) publishes
+// retained on tasmota/discovery/<mac>/config
+type TasmotaDiscovery struct {
+	IP            string    `json:"ip"`
+	DeviceName    string    `json:"dn"`
+	FriendlyNames []*string `json:"fn"`
+	Hostname      string    `json:"hn"`
+	MAC           string    `json:"mac"`
+	Model         string    `json:"md"`
+	Software      string    `json:"sw"`
+	Topic         string    `json:"t"`
+	// FullTopic is the topic pattern ("%prefix%/%topic%/"); Prefixes are the
+	// cmnd, stat and tele prefixes substituted for %prefix%
+	FullTopic string   `json:"ft"`
+	Prefixes  []string `json:"tp"`
+	// Relays has one entry per POWER output: 0 none, 1 relay, 2 light, 3 shutter
+	Relays []int `json:"rl"`
+	// LightSubtype is 0 (no light), 1 dimmer, 2 CT, 3 RGB, 4 RGBW or 5 RGBCW
+	LightSubtype int `json:"lt_st"`
+}
+
+// TasmotaSensors is the retained tasmota/discovery/<mac>/sensors message: a
+// sample of the tele/<topic>/SENSOR payload
+type TasmotaSensors struct {
+	Sensors map[string]interface{} `json:"sn"`
 }
 
 // FrigateCameraStats represents stats for a single camera
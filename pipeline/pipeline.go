@@ -0,0 +1,29 @@
+// Package pipeline lets programs embedding the server pipeline (such as the
+// test harness) add their own event middleware without importing internal
+// packages:
+//
+//	h.Use(pipeline.MiddlewareFunc(func(event *pipeline.Event, next pipeline.Handler) {
+//		if event.Source == "device" && event.Device == "noisy_plug" {
+//			return // drop
+//		}
+//		next(event)
+//	}))
+package pipeline
+
+import (
+	"homescript-server/internal/events"
+	"homescript-server/internal/types"
+)
+
+// Event is a routed event (device, mqtt, time, state, custom)
+type Event = types.Event
+
+// Handler continues routing an event
+type Handler = events.Handler
+
+// Middleware is an ordered stage run for every event before it is recorded
+// and dispatched to scripts; see events.Middleware
+type Middleware = events.Middleware
+
+// MiddlewareFunc adapts a function to Middleware
+type MiddlewareFunc = events.MiddlewareFunc